@@ -58,7 +58,9 @@ func Spawn(actorID *ID, wg *sync.WaitGroup, f func()) {
 			defer wg.Done()
 		}
 		log.Infof("<%s> started", actorID)
+		unregister := register(actorID)
 		defer func() {
+			unregister()
 			if p := recover(); p != nil {
 				log.Errorf("<%s> paniced: %v, stack=%s", actorID, p, debug.Stack())
 				panic(p)
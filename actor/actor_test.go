@@ -2,8 +2,12 @@ package actor
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/mailgun/kafka-pixy/none"
 	. "gopkg.in/check.v1"
 )
 
@@ -49,3 +53,181 @@ func (s *IDSuite) TestNewChildEmpty(c *C) {
 func (s *IDSuite) TestNewChildComplex(c *C) {
 	c.Assert(RootID.NewChild("foo", 0, []string{"d"}, nil, "bar").String(), Equals, "/foo_0_[d]_<nil>_bar[0]")
 }
+
+type DumpSuite struct{}
+
+var _ = Suite(&DumpSuite{})
+
+// Running actors are dumped with their goroutine states and stacks, and
+// stopped actors are not.
+func (s *DumpSuite) TestDump(c *C) {
+	var wg sync.WaitGroup
+	stopCh := make(chan none.T)
+	parentID := RootID.NewChild("dump")
+	Spawn(parentID.NewChild("b"), &wg, func() { <-stopCh })
+	Spawn(parentID.NewChild("a"), &wg, func() { <-stopCh })
+	Spawn(parentID.NewChild("c"), &wg, func() {})
+	time.Sleep(100 * time.Millisecond)
+
+	// When
+	infos := dumpOf(parentID)
+	close(stopCh)
+	wg.Wait()
+
+	// Then
+	c.Assert(len(infos), Equals, 2)
+	c.Assert(infos[0].ID, Equals, "/dump[0]/a[0]")
+	c.Assert(infos[1].ID, Equals, "/dump[0]/b[0]")
+	for _, info := range infos {
+		c.Assert(info.Goroutine > 0, Equals, true)
+		c.Assert(info.State, Equals, "chan receive")
+		c.Assert(info.Stack, Matches, "(?s)goroutine .*TestDump.*")
+	}
+	c.Assert(dumpOf(parentID), DeepEquals, []Info{})
+}
+
+// Actors descending from an ID are counted along with the actor of the ID.
+func (s *DumpSuite) TestCount(c *C) {
+	var wg sync.WaitGroup
+	stopCh := make(chan none.T)
+	parentID := RootID.NewChild("count")
+	Spawn(parentID, &wg, func() { <-stopCh })
+	Spawn(parentID.NewChild("a"), &wg, func() { <-stopCh })
+	Spawn(parentID.NewChild("b").NewChild("c"), &wg, func() { <-stopCh })
+	Spawn(RootID.NewChild("count"), &wg, func() { <-stopCh })
+	time.Sleep(100 * time.Millisecond)
+
+	// When
+	count := Count(parentID)
+	close(stopCh)
+	wg.Wait()
+
+	// Then
+	c.Assert(count, Equals, 3)
+	c.Assert(Count(parentID), Equals, 0)
+}
+
+func (s *DumpSuite) TestParseGoroutineHeader(c *C) {
+	id, state, ok := parseGoroutineHeader("goroutine 18 [chan receive, 5 minutes]:")
+	c.Assert(ok, Equals, true)
+	c.Assert(id, Equals, int64(18))
+	c.Assert(state, Equals, "chan receive, 5 minutes")
+
+	_, _, ok = parseGoroutineHeader("created by main.main")
+	c.Assert(ok, Equals, false)
+}
+
+func dumpOf(parentID *ID) []Info {
+	infos := []Info{}
+	for _, info := range Dump() {
+		if strings.HasPrefix(info.ID, parentID.String()+"/") {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+type SuperviseSuite struct{}
+
+var _ = Suite(&SuperviseSuite{})
+
+var testPolicy = RestartPolicy{MaxRestarts: 2, Window: time.Minute, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+// A panicking function is restarted until it returns normally.
+func (s *SuperviseSuite) TestRestart(c *C) {
+	calls := 0
+
+	// When
+	Supervise(RootID.NewChild("sup"), testPolicy, nil, func() {
+		calls++
+		if calls < 3 {
+			panic("kaboom")
+		}
+	})
+
+	// Then
+	c.Assert(calls, Equals, 3)
+}
+
+// If a function panics more often than the policy allows, then the panic is
+// escalated.
+func (s *SuperviseSuite) TestEscalate(c *C) {
+	calls := 0
+
+	// When
+	p, _ := runRecovering(func() {
+		Supervise(RootID.NewChild("sup"), testPolicy, nil, func() {
+			calls++
+			panic("kaboom")
+		})
+	})
+
+	// Then
+	c.Assert(p, Equals, "kaboom")
+	c.Assert(calls, Equals, 3)
+}
+
+// Restarts that are older than the window are not counted.
+func (s *SuperviseSuite) TestWindow(c *C) {
+	policy := testPolicy
+	policy.Window = 20 * time.Millisecond
+	calls := 0
+
+	// When
+	Supervise(RootID.NewChild("sup"), policy, nil, func() {
+		calls++
+		if calls < 6 {
+			time.Sleep(15 * time.Millisecond)
+			panic("kaboom")
+		}
+	})
+
+	// Then
+	c.Assert(calls, Equals, 6)
+}
+
+// Restart is not attempted if the cancel channel is closed during backoff.
+func (s *SuperviseSuite) TestCancel(c *C) {
+	policy := testPolicy
+	policy.Backoff, policy.MaxBackoff = time.Hour, time.Hour
+	cancelCh := make(chan none.T)
+	close(cancelCh)
+	calls := 0
+
+	// When
+	Supervise(RootID.NewChild("sup"), policy, cancelCh, func() {
+		calls++
+		panic("kaboom")
+	})
+
+	// Then
+	c.Assert(calls, Equals, 1)
+}
+
+// Restarts are reported in the actor dump.
+func (s *SuperviseSuite) TestDumpRestarts(c *C) {
+	var wg sync.WaitGroup
+	stopCh := make(chan none.T)
+	parentID := RootID.NewChild("sup")
+	actorID := parentID.NewChild("a")
+	calls := 0
+	Spawn(actorID, &wg, func() {
+		Supervise(actorID, testPolicy, nil, func() {
+			calls++
+			if calls == 1 {
+				panic("kaboom")
+			}
+			<-stopCh
+		})
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	// When
+	infos := dumpOf(parentID)
+	close(stopCh)
+	wg.Wait()
+
+	// Then
+	c.Assert(len(infos), Equals, 1)
+	c.Assert(infos[0].Restarts, Equals, 1)
+}
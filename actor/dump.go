@@ -0,0 +1,170 @@
+package actor
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Info describes a running actor.
+type Info struct {
+	ID        string
+	StartedAt time.Time
+
+	// Goroutine that runs the actor, and its state as reported by the Go
+	// runtime, e.g. `chan receive, 5 minutes`. The state of an actor blocked
+	// for a long time includes the time it has been blocked for.
+	Goroutine int64
+	State     string
+	Stack     string
+
+	// The number of times the actor was restarted by Supervise after a panic.
+	Restarts int
+}
+
+type running struct {
+	id        *ID
+	startedAt time.Time
+	goroutine int64
+	restarts  int
+}
+
+var (
+	runningMu  sync.Mutex
+	runningMap = make(map[*running]bool)
+)
+
+// register makes the calling goroutine known as the actor with the specified
+// ID to Dump, until the returned function is called.
+func register(actorID *ID) func() {
+	r := &running{id: actorID, startedAt: time.Now().UTC(), goroutine: currentGoroutine()}
+	runningMu.Lock()
+	runningMap[r] = true
+	runningMu.Unlock()
+	return func() {
+		runningMu.Lock()
+		delete(runningMap, r)
+		runningMu.Unlock()
+	}
+}
+
+// countRestart increments the restart counter of the actor running in the
+// calling goroutine.
+func countRestart() {
+	goroutine := currentGoroutine()
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	for r := range runningMap {
+		if r.goroutine == goroutine {
+			r.restarts++
+			return
+		}
+	}
+}
+
+// Dump returns information about all running actors ordered by ID.
+func Dump() []Info {
+	runningMu.Lock()
+	infos := make([]Info, 0, len(runningMap))
+	for r := range runningMap {
+		infos = append(infos, Info{ID: r.id.String(), StartedAt: r.startedAt, Goroutine: r.goroutine, Restarts: r.restarts})
+	}
+	runningMu.Unlock()
+
+	goroutines := allGoroutines()
+	for i := range infos {
+		if g, ok := goroutines[infos[i].Goroutine]; ok {
+			infos[i].State = g.state
+			infos[i].Stack = g.stack
+		}
+	}
+	sort.Sort(infosByID(infos))
+	return infos
+}
+
+// Count returns the number of running actors with the specified ID or IDs
+// descending from it. It is much cheaper than Dump.
+func Count(parentID *ID) int {
+	prefix := parentID.String() + "/"
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	count := 0
+	for r := range runningMap {
+		if r.id == parentID || strings.HasPrefix(r.id.String(), prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+type infosByID []Info
+
+func (p infosByID) Len() int           { return len(p) }
+func (p infosByID) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p infosByID) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type goroutine struct {
+	state string
+	stack string
+}
+
+// allGoroutines returns states and stacks of all goroutines by their IDs.
+func allGoroutines() map[int64]goroutine {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	goroutines := make(map[int64]goroutine)
+	for _, trace := range bytes.Split(buf, []byte("\n\n")) {
+		header := trace
+		if i := bytes.IndexByte(trace, '\n'); i >= 0 {
+			header = trace[:i]
+		}
+		id, state, ok := parseGoroutineHeader(string(header))
+		if !ok {
+			continue
+		}
+		goroutines[id] = goroutine{state: state, stack: string(trace)}
+	}
+	return goroutines
+}
+
+// currentGoroutine returns the ID of the calling goroutine. The Go runtime
+// does not expose it, so it is parsed from the goroutine stack trace.
+func currentGoroutine() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	id, _, _ := parseGoroutineHeader(string(buf))
+	return id
+}
+
+// parseGoroutineHeader parses the first line of a goroutine stack trace, e.g.
+// `goroutine 18 [chan receive, 5 minutes]:`.
+func parseGoroutineHeader(header string) (int64, string, bool) {
+	if !strings.HasPrefix(header, "goroutine ") {
+		return 0, "", false
+	}
+	header = strings.TrimPrefix(header, "goroutine ")
+	sep := strings.Index(header, " [")
+	end := strings.LastIndex(header, "]")
+	if sep < 0 || end < sep {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(header[:sep], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, header[sep+2 : end], true
+}
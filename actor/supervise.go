@@ -0,0 +1,82 @@
+package actor
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+// RestartPolicy tells how an actor run by Supervise is restarted after it
+// panics.
+type RestartPolicy struct {
+	// The maximum number of restarts within Window. When it is exceeded the
+	// panic is escalated, that is the process crashes as it does when an
+	// unsupervised actor panics. Zero disables restarts.
+	MaxRestarts int
+
+	// The period of time that MaxRestarts are counted within.
+	Window time.Duration
+
+	// The delay before the first restart. It doubles with every following
+	// restart within Window up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Supervise calls `f` and, if it panics, calls it again as `policy` prescribes
+// until it returns normally. It is meant to be called from within a function
+// started by Spawn, so that resources of the actor that must survive restarts,
+// like output channels, are released by the caller when Supervise returns. If
+// `cancelCh` is closed while waiting for a restart, then Supervise returns
+// without restarting `f`.
+//
+// Note that deferred functions of `f` are executed before it is restarted, so
+// it must not leave anything behind that prevents it from running again.
+func Supervise(actorID *ID, policy RestartPolicy, cancelCh <-chan none.T, f func()) {
+	var restarts []time.Time
+	backoff := policy.Backoff
+	for {
+		p, stack := runRecovering(f)
+		if p == nil {
+			return
+		}
+		now := time.Now()
+		for len(restarts) > 0 && now.Sub(restarts[0]) > policy.Window {
+			restarts = restarts[1:]
+		}
+		if len(restarts) == 0 {
+			backoff = policy.Backoff
+		}
+		if len(restarts) >= policy.MaxRestarts {
+			log.Errorf("<%s> paniced, giving up after %d restarts: %v, stack=%s", actorID, len(restarts), p, stack)
+			panic(p)
+		}
+		restarts = append(restarts, now)
+		countRestart()
+		log.Errorf("<%s> paniced, restarting in %v: restartNo=%d, panic=%v, stack=%s",
+			actorID, backoff, len(restarts), p, stack)
+		select {
+		case <-time.After(backoff):
+		case <-cancelCh:
+			return
+		}
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// runRecovering calls `f` and returns the value it panics with, if any, along
+// with the stack trace of the panic.
+func runRecovering(f func()) (p interface{}, stack []byte) {
+	defer func() {
+		if p = recover(); p != nil {
+			stack = debug.Stack()
+		}
+	}()
+	f()
+	return nil, nil
+}
@@ -1,7 +1,9 @@
 package admin
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"sort"
 	"strconv"
 	"sync"
@@ -10,6 +12,8 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/seedpeers"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -18,25 +22,63 @@ type (
 	ErrInvalidParam error
 )
 
+// ErrTopicExists is returned by CreateTopic if the topic already exists.
+var ErrTopicExists = errors.New("topic already exists")
+
+// ErrGroupNotFound is returned by RebalanceGroup if the group has never been
+// registered in ZooKeeper.
+var ErrGroupNotFound = errors.New("consumer group not found")
+
+// ErrOffsetOutOfRange is returned by PlanRecordsDeletion if an offset is
+// beyond the partition end.
+var ErrOffsetOutOfRange = errors.New("offset out of range")
+
+// ErrMemberNotFound is returned by EvictGroupMember if the member is not
+// registered with the group.
+var ErrMemberNotFound = errors.New("consumer group member not found")
+
 const (
 	ProtocolVer1 = 1 // Supported by Kafka v0.8.2 and later
 )
 
 // T provides methods to perform administrative operations on a Kafka cluster.
+//
+// TODO Report on-disk sizes of partitions and disk usage of brokers, e.g. via
+// `GET /cluster/storage`, for capacity dashboards that scrape broker JMX for
+// them now. That takes DescribeLogDirs requests (KIP-113, Kafka 1.0), that
+// the vendored Shopify/sarama does not implement, so it has to be upgraded
+// first.
 type T struct {
 	namespace *actor.ID
 	cfg       *config.Proxy
+	seedPeers *seedpeers.T
 	kafkaClt  sarama.Client
 	zkConn    *zk.Conn
+	zkCache   *zkCache
 	mtx       sync.Mutex
 }
 
 // Spawn creates an admin instance with the specified configuration and starts
 // internal goroutines to support its operation.
 func Spawn(namespace *actor.ID, cfg *config.Proxy) (*T, error) {
+	return SpawnWithOpts(namespace, cfg, Opts{})
+}
+
+// Opts are optional parameters of an admin instance.
+type Opts struct {
+	// If given, then the admin connects to the seed peers current as of
+	// connecting, rather than to the configured ones, and keeps resolving
+	// ZooKeeper peers again while it cannot reach any.
+	SeedPeers *seedpeers.T
+}
+
+// SpawnWithOpts is like Spawn, but takes optional parameters.
+func SpawnWithOpts(namespace *actor.ID, cfg *config.Proxy, opts Opts) (*T, error) {
 	a := T{
 		namespace: namespace,
 		cfg:       cfg,
+		seedPeers: opts.SeedPeers,
+		zkCache:   newZKCache(cfg.Admin.ZooKeeperCacheTTL),
 	}
 	return &a, nil
 }
@@ -61,6 +103,23 @@ type PartitionOffset struct {
 	Metadata  string
 }
 
+// Lag returns the number of messages in the partition that the group has not
+// consumed yet.
+//
+// TODO Report lag relative to the last stable offset too, once the vendored
+// Shopify/sarama supports offset requests v2 that return it. Until then
+// consumers only read uncommitted messages, and End is what they lag behind.
+func (po *PartitionOffset) Lag() int64 {
+	switch po.Offset {
+	case sarama.OffsetNewest:
+		return 0
+	case sarama.OffsetOldest:
+		return po.End - po.Begin
+	default:
+		return po.End - po.Offset
+	}
+}
+
 type indexedPartition struct {
 	index     int
 	partition int32
@@ -70,6 +129,44 @@ type indexedPartition struct {
 // current offset range along with the latest offset and metadata committed by
 // the specified consumer group.
 func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
+	offsets, err := a.TopicOffsets(topic)
+	if err != nil {
+		return nil, err
+	}
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the last committed offsets for all partitions of the group/topic.
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get coordinator")
+	}
+	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: ProtocolVer1}
+	for _, po := range offsets {
+		req.AddPartition(topic, po.Partition)
+	}
+	res, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch offsets")
+	}
+	for i, po := range offsets {
+		block := res.GetBlock(topic, po.Partition)
+		if block == nil {
+			return nil, errors.Wrapf(nil, "offset block is missing, partition=%d", po.Partition)
+		}
+		offsets[i].Offset = block.Offset
+		offsets[i].Metadata = block.Metadata
+	}
+
+	return offsets, nil
+}
+
+// TopicOffsets for every partition of the specified topic it returns the
+// current offset range. Offset and Metadata of the returned values are not
+// set.
+func (a *T) TopicOffsets(topic string) ([]PartitionOffset, error) {
 	kafkaClt, err := a.lazyKafkaClt()
 	if err != nil {
 		return nil, err
@@ -138,29 +235,6 @@ func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 	if err, ok := <-errorsCh; ok {
 		return nil, err
 	}
-
-	// Fetch the last committed offsets for all partitions of the group/topic.
-	coordinator, err := kafkaClt.Coordinator(group)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get coordinator")
-	}
-	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: ProtocolVer1}
-	for _, p := range partitions {
-		req.AddPartition(topic, p)
-	}
-	res, err := coordinator.FetchOffset(&req)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to fetch offsets")
-	}
-	for i, p := range partitions {
-		block := res.GetBlock(topic, p)
-		if block == nil {
-			return nil, errors.Wrapf(nil, "offset block is missing, partition=%d", p)
-		}
-		offsets[i].Offset = block.Offset
-		offsets[i].Metadata = block.Metadata
-	}
-
 	return offsets, nil
 }
 
@@ -203,29 +277,67 @@ func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 	if err != nil {
 		return nil, err
 	}
-	consumedPartitionsPath := fmt.Sprintf("%s/consumers/%s/owners/%s",
-		a.cfg.ZooKeeper.Chroot, group, topic)
-	partitionNodes, _, err := zkConn.Children(consumedPartitionsPath)
+	consumers, err := a.getTopicConsumers(zkConn, group, topic)
 	if err != nil {
-		if err == zk.ErrNoNode {
+		if errors.Cause(err) == zk.ErrNoNode {
 			return nil, ErrInvalidParam(errors.New("either group or topic is incorrect"))
 		}
+		return nil, err
+	}
+	return consumers, nil
+}
+
+// getTopicConsumers is like GetTopicConsumers, except it returns an error
+// caused by zk.ErrNoNode if the group does not consume the topic. Partition
+// owner nodes are requested all at once, so that the requests are pipelined
+// by the ZooKeeper connection.
+func (a *T) getTopicConsumers(zkConn *zk.Conn, group, topic string) (map[string][]int32, error) {
+	consumedPartitionsPath := fmt.Sprintf("%s/consumers/%s/owners/%s",
+		a.cfg.ZooKeeper.Chroot, group, topic)
+	partitionNodes, err := a.zkCache.getChildren(consumedPartitionsPath, func() ([]string, error) {
+		children, _, err := zkConn.Children(consumedPartitionsPath)
+		return children, err
+	})
+	if err != nil {
 		return nil, errors.Wrapf(err, "failed to fetch partition owners data")
 	}
 
-	consumers := make(map[string][]int32)
-	for _, partitionNode := range partitionNodes {
+	partitions := make([]int32, len(partitionNodes))
+	for i, partitionNode := range partitionNodes {
 		partition, err := strconv.Atoi(partitionNode)
 		if err != nil {
 			return nil, errors.Wrapf(err, "invalid partition id, %s", partitionNode)
 		}
-		partitionPath := fmt.Sprintf("%s/%s", consumedPartitionsPath, partitionNode)
-		partitionNodeData, _, err := zkConn.Get(partitionPath)
-		if err != nil {
+		partitions[i] = int32(partition)
+	}
+	owners := make([][]byte, len(partitionNodes))
+	ownerErrors := make([]error, len(partitionNodes))
+	var wg sync.WaitGroup
+	for i, partitionNode := range partitionNodes {
+		i, partitionPath := i, fmt.Sprintf("%s/%s", consumedPartitionsPath, partitionNode)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			owners[i], ownerErrors[i] = a.zkCache.getData(partitionPath, func() ([]byte, error) {
+				data, _, err := zkConn.Get(partitionPath)
+				return data, err
+			})
+		}()
+	}
+	wg.Wait()
+
+	consumers := make(map[string][]int32)
+	for i, partition := range partitions {
+		if err := ownerErrors[i]; err != nil {
+			// The partition has been released since its owner node was
+			// listed, e.g. due to a rebalance.
+			if err == zk.ErrNoNode {
+				continue
+			}
 			return nil, errors.Wrapf(err, "failed to fetch partition owner")
 		}
-		clientID := string(partitionNodeData)
-		consumers[clientID] = append(consumers[clientID], int32(partition))
+		clientID := string(owners[i])
+		consumers[clientID] = append(consumers[clientID], partition)
 	}
 
 	for _, partitions := range consumers {
@@ -238,36 +350,367 @@ func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 // GetAllTopicConsumers returns group -> client-id -> consumed-partitions-list
 // mapping for a particular topic. Warning, the function performs scan of all
 // consumer groups registered in ZooKeeper and therefore can take a lot of time.
+//
+// Groups are queried concurrently by `admin.zoo_keeper_scan_workers`
+// goroutines. Errors are reported per group, so if data of some groups could
+// not be fetched, then consumers of the rest of groups are returned along with
+// a GroupErrors error.
 func (a *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error) {
-	kzConn, err := a.lazyZKConn()
+	consumers, _, err := a.GetTopicConsumersPage(topic, Page{})
+	return consumers, err
+}
+
+// GetTopicConsumersPage is like GetAllTopicConsumers, except only consumer
+// groups on the specified page are scanned, so the scan stops as soon as
+// `Page.Limit` groups consuming the topic are found. It also tells whether
+// there are groups left to scan after the page.
+func (a *T) GetTopicConsumersPage(topic string, pg Page) (map[string]map[string][]int32, bool, error) {
+	consumers := make(map[string]map[string][]int32)
+	groupErrors := make(GroupErrors)
+	more, err := a.ScanTopicConsumers(topic, pg, func(group string, groupConsumers map[string][]int32, err error) {
+		if err != nil {
+			groupErrors[group] = err
+			return
+		}
+		consumers[group] = groupConsumers
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return consumers, more, groupErrors.orNil()
+}
+
+// ScanTopicConsumers is like GetTopicConsumersPage, except it calls `fn` for
+// every group on the page that consumes the topic, or that data could not be
+// fetched of, as soon as the group data is fetched. That allows callers to
+// process results while the scan is still in progress. Groups are reported
+// in the order of their names.
+func (a *T) ScanTopicConsumers(topic string, pg Page, fn func(group string, consumers map[string][]int32, err error)) (bool, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return false, err
+	}
+	groups, err := a.ListGroups()
+	if err != nil {
+		return false, err
+	}
+	var candidates []string
+	for _, group := range groups {
+		if group > pg.After && pg.Match(group) {
+			candidates = append(candidates, group)
+		}
+	}
+
+	found := 0
+	more := false
+	a.scanGroups(zkConn, topic, candidates, func(i int, consumers map[string][]int32, err error) bool {
+		if pg.Limit > 0 && found == pg.Limit {
+			more = true
+			return false
+		}
+		if err != nil {
+			if errors.Cause(err) != zk.ErrNoNode {
+				fn(candidates[i], nil, err)
+			}
+			return true
+		}
+		if len(consumers) > 0 {
+			found++
+			fn(candidates[i], consumers, nil)
+		}
+		return true
+	})
+	return more, nil
+}
+
+// scanGroups fetches consumers of a topic for all specified groups using a
+// bounded pool of goroutines, and calls `fn` with results in the order of
+// groups. Workers get ahead of `fn` by at most twice the pool size, so
+// results are never buffered for more groups than that. If `fn` returns
+// false then the scan stops.
+func (a *T) scanGroups(zkConn *zk.Conn, topic string, groups []string, fn func(i int, consumers map[string][]int32, err error) bool) {
+	workers := a.cfg.Admin.ZooKeeperScanWorkers
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	type result struct {
+		consumers map[string][]int32
+		err       error
+		doneCh    chan none.T
+	}
+	results := make([]result, len(groups))
+	for i := range results {
+		results[i].doneCh = make(chan none.T)
+	}
+	aheadCh := make(chan none.T, 2*workers)
+	stopCh := make(chan none.T)
+	indexCh := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(indexCh)
+		for i := range groups {
+			select {
+			case aheadCh <- none.V:
+			case <-stopCh:
+				return
+			}
+			select {
+			case indexCh <- i:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexCh {
+				results[i].consumers, results[i].err = a.getTopicConsumers(zkConn, groups[i], topic)
+				close(results[i].doneCh)
+			}
+		}()
+	}
+	for i := range results {
+		<-results[i].doneCh
+		ok := fn(i, results[i].consumers, results[i].err)
+		results[i] = result{}
+		<-aheadCh
+		if !ok {
+			break
+		}
+	}
+	close(stopCh)
+	wg.Wait()
+}
+
+// GroupErrors is returned by consumer group scans if data of some groups
+// could not be fetched. It maps group names to errors.
+type GroupErrors map[string]error
+
+func (ge GroupErrors) Error() string {
+	groups := make([]string, 0, len(ge))
+	for group := range ge {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	msg := fmt.Sprintf("failed to fetch data of %d groups", len(ge))
+	for i, group := range groups {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		msg += fmt.Sprintf("%s%s: %s", sep, group, ge[group])
+	}
+	return msg
+}
+
+func (ge GroupErrors) orNil() error {
+	if len(ge) == 0 {
+		return nil
+	}
+	return ge
+}
+
+// ListGroups returns sorted names of all consumer groups registered in
+// ZooKeeper.
+func (a *T) ListGroups() ([]string, error) {
+	zkConn, err := a.lazyZKConn()
 	if err != nil {
 		return nil, err
 	}
 	groupsPath := fmt.Sprintf("%s/consumers", a.cfg.ZooKeeper.Chroot)
-	groups, _, err := kzConn.Children(groupsPath)
+	groups, err := a.zkCache.getChildren(groupsPath, func() ([]string, error) {
+		children, _, err := zkConn.Children(groupsPath)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(children)
+		return children, nil
+	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to fetch consumer groups")
 	}
+	return groups, nil
+}
 
-	consumers := make(map[string]map[string][]int32)
-	for _, group := range groups {
-		groupConsumers, err := a.GetTopicConsumers(group, topic)
+// RebalanceGroup makes all members of a consumer group rebalance at once. That
+// is done by deleting registrations of all members from ZooKeeper. Members
+// that notice they have been evicted release all their partitions, and then
+// register again, so the partitions are reassigned among them from scratch.
+func (a *T) RebalanceGroup(group string) error {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	membersPath := fmt.Sprintf("%s/consumers/%s/ids", a.cfg.ZooKeeper.Chroot, group)
+	members, _, err := zkConn.Children(membersPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return ErrGroupNotFound
+		}
+		return errors.Wrap(err, "failed to fetch group members")
+	}
+	for _, member := range members {
+		err := zkConn.Delete(fmt.Sprintf("%s/%s", membersPath, member), -1)
+		if err != nil && err != zk.ErrNoNode {
+			return errors.Wrapf(err, "failed to delete member registration, member=%s", member)
+		}
+	}
+	a.zkCache.invalidate()
+	return nil
+}
+
+// EvictGroupMember deletes the registration of a consumer group member from
+// ZooKeeper, along with claims of all partitions that it owns. So the rest of
+// the group rebalance without the member and can take over its partitions
+// even if the member is stuck. A member that is still alive notices that it
+// has been evicted, releases all its partitions, and then registers again.
+func (a *T) EvictGroupMember(group, memberID string) error {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	memberPath := fmt.Sprintf("%s/consumers/%s/ids/%s", a.cfg.ZooKeeper.Chroot, group, memberID)
+	if err := zkConn.Delete(memberPath, -1); err != nil {
+		if err == zk.ErrNoNode {
+			return ErrMemberNotFound
+		}
+		return errors.Wrap(err, "failed to delete member registration")
+	}
+	defer a.zkCache.invalidate()
+
+	ownersPath := fmt.Sprintf("%s/consumers/%s/owners", a.cfg.ZooKeeper.Chroot, group)
+	topics, _, err := zkConn.Children(ownersPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return errors.Wrap(err, "failed to fetch consumed topics")
+	}
+	for _, topic := range topics {
+		topicPath := fmt.Sprintf("%s/%s", ownersPath, topic)
+		partitions, _, err := zkConn.Children(topicPath)
 		if err != nil {
-			if _, ok := err.(ErrInvalidParam); ok {
+			if err == zk.ErrNoNode {
 				continue
 			}
-			return nil, errors.Wrapf(err, "failed to fetch group `%s` data", group)
+			return errors.Wrapf(err, "failed to fetch partition owners, topic=%s", topic)
 		}
-		if len(groupConsumers) > 0 {
-			consumers[group] = groupConsumers
+		for _, partition := range partitions {
+			partitionPath := fmt.Sprintf("%s/%s", topicPath, partition)
+			owner, stat, err := zkConn.Get(partitionPath)
+			if err != nil {
+				if err == zk.ErrNoNode {
+					continue
+				}
+				return errors.Wrapf(err, "failed to fetch partition owner, topic=%s, partition=%s", topic, partition)
+			}
+			if string(owner) != memberID {
+				continue
+			}
+			// The version check makes sure that a claim made by another
+			// member in the meantime is not deleted.
+			err = zkConn.Delete(partitionPath, stat.Version)
+			if err != nil && err != zk.ErrNoNode && err != zk.ErrBadVersion {
+				return errors.Wrapf(err, "failed to release partition, topic=%s, partition=%s", topic, partition)
+			}
 		}
 	}
-	return consumers, nil
+	return nil
+}
+
+// CreateTopic creates a topic by registering its replica assignment in
+// ZooKeeper, the same way Kafka admin tools do. The Kafka controller picks
+// the topic up and elects partition leaders shortly after the call returns.
+// Replicas are assigned to brokers round robin starting from a random one.
+// If the topic already exists, then ErrTopicExists is returned.
+func (a *T) CreateTopic(topic string, partitions, replicationFactor int) error {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	brokersPath := fmt.Sprintf("%s/brokers/ids", a.cfg.ZooKeeper.Chroot)
+	brokerNodes, _, err := zkConn.Children(brokersPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch brokers")
+	}
+	brokers := make([]int, 0, len(brokerNodes))
+	for _, brokerNode := range brokerNodes {
+		brokerID, err := strconv.Atoi(brokerNode)
+		if err != nil {
+			return errors.Wrapf(err, "invalid broker id, %s", brokerNode)
+		}
+		brokers = append(brokers, brokerID)
+	}
+	sort.Ints(brokers)
+	if replicationFactor > len(brokers) {
+		return ErrInvalidParam(errors.Errorf("replication factor %d is larger than the number of brokers %d",
+			replicationFactor, len(brokers)))
+	}
+	start := rand.Intn(len(brokers))
+	assignment := make(map[string][]int, partitions)
+	for p := 0; p < partitions; p++ {
+		replicas := make([]int, replicationFactor)
+		for r := range replicas {
+			replicas[r] = brokers[(start+p+r)%len(brokers)]
+		}
+		assignment[strconv.Itoa(p)] = replicas
+	}
+
+	topicCfgData, _ := json.Marshal(map[string]interface{}{"version": 1, "config": map[string]string{}})
+	topicCfgPath := fmt.Sprintf("%s/config/topics/%s", a.cfg.ZooKeeper.Chroot, topic)
+	_, err = zkConn.Create(topicCfgPath, topicCfgData, 0, zk.WorldACL(zk.PermAll))
+	if err != nil && err != zk.ErrNodeExists {
+		return errors.Wrap(err, "failed to create topic config")
+	}
+	topicData, _ := json.Marshal(map[string]interface{}{"version": 1, "partitions": assignment})
+	topicPath := fmt.Sprintf("%s/brokers/topics/%s", a.cfg.ZooKeeper.Chroot, topic)
+	if _, err = zkConn.Create(topicPath, topicData, 0, zk.WorldACL(zk.PermAll)); err != nil {
+		if err == zk.ErrNodeExists {
+			return ErrTopicExists
+		}
+		return errors.Wrap(err, "failed to create topic")
+	}
+	return nil
+}
+
+// InvalidateCache drops all ZooKeeper data cached by consumers queries.
+func (a *T) InvalidateCache() {
+	a.zkCache.invalidate()
+}
+
+// CacheStats returns statistics of the ZooKeeper data cache.
+func (a *T) CacheStats() CacheStats {
+	return a.zkCache.stats()
+}
+
+// ListTopics returns sorted names of all topics in the Kafka cluster.
+func (a *T) ListTopics() ([]string, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	if err := kafkaClt.RefreshMetadata(); err != nil {
+		return nil, errors.Wrap(err, "failed to refresh metadata")
+	}
+	topics, err := kafkaClt.Topics()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch topics")
+	}
+	sort.Strings(topics)
+	return topics, nil
 }
 
 // saramaConfig generates a `Shopify/sarama` library config.
 func (a *T) saramaConfig() *sarama.Config {
 	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = a.cfg.SaramaKafkaVersion()
 	saramaConfig.ClientID = a.cfg.ClientID
 	return saramaConfig
 }
@@ -277,7 +720,11 @@ func (a *T) lazyKafkaClt() (sarama.Client, error) {
 	defer a.mtx.Unlock()
 	if a.kafkaClt == nil {
 		var err error
-		if a.kafkaClt, err = sarama.NewClient(a.cfg.Kafka.SeedPeers, a.saramaConfig()); err != nil {
+		peers := a.cfg.Kafka.SeedPeers
+		if a.seedPeers != nil {
+			peers = a.seedPeers.Kafka()
+		}
+		if a.kafkaClt, err = sarama.NewClient(peers, a.saramaConfig()); err != nil {
 			return nil, errors.Wrap(err, "failed to create sarama.Client")
 		}
 	}
@@ -289,7 +736,13 @@ func (a *T) lazyZKConn() (*zk.Conn, error) {
 	defer a.mtx.Unlock()
 	if a.zkConn == nil {
 		var err error
-		if a.zkConn, _, err = zk.Connect(a.cfg.ZooKeeper.SeedPeers, 1*time.Second); err != nil {
+		if a.seedPeers != nil {
+			a.zkConn, _, err = zk.Connect(a.seedPeers.ZooKeeper(), 1*time.Second,
+				zk.WithHostProvider(a.seedPeers.ZooKeeperHostProvider()))
+		} else {
+			a.zkConn, _, err = zk.Connect(a.cfg.ZooKeeper.SeedPeers, 1*time.Second)
+		}
+		if err != nil {
 			return nil, errors.Wrap(err, "failed to create zk.Conn")
 		}
 	}
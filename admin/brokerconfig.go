@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Sources of broker config values, as Kafka reports them.
+const (
+	ConfigSourceDynamic        = "DYNAMIC_BROKER_CONFIG"
+	ConfigSourceDynamicDefault = "DYNAMIC_DEFAULT_BROKER_CONFIG"
+)
+
+// brokerDefault is the entity that dynamic configs shared by all brokers of
+// the cluster are kept under.
+const brokerDefault = "<default>"
+
+var (
+	// ErrBrokerNotFound is returned if a broker with the given ID is not a
+	// member of the cluster.
+	ErrBrokerNotFound = errors.New("broker not found")
+
+	// ErrInvalidBrokerConfig is returned if a broker config alteration
+	// names no config, or a config that cannot be altered via ZooKeeper.
+	ErrInvalidBrokerConfig = errors.New("invalid broker config")
+)
+
+// BrokerConfig is a dynamic config entry of a broker. Values of sensitive
+// entries, e.g. passwords, are never returned.
+type BrokerConfig struct {
+	Name      string
+	Value     string
+	Source    string
+	Sensitive bool
+}
+
+// NewBrokerConfig returns a config entry, with the value withheld if the
+// entry is sensitive.
+func NewBrokerConfig(name, value, source string) BrokerConfig {
+	bc := BrokerConfig{Name: name, Value: value, Source: source}
+	if isSensitiveBrokerConfig(name) {
+		bc.Value, bc.Sensitive = "", true
+	}
+	return bc
+}
+
+// CheckBrokerConfigAlteration returns an error caused by
+// ErrInvalidBrokerConfig if broker configs cannot be altered as `alter`
+// prescribes. Sensitive configs cannot be set, for Kafka expects them
+// encrypted with the `password.encoder.secret` of brokers.
+func CheckBrokerConfigAlteration(alter map[string]*string) error {
+	for name, value := range alter {
+		if name == "" {
+			return errors.Wrap(ErrInvalidBrokerConfig, "config name is empty")
+		}
+		if value != nil && isSensitiveBrokerConfig(name) {
+			return errors.Wrapf(ErrInvalidBrokerConfig, "%s is sensitive", name)
+		}
+	}
+	return nil
+}
+
+// SortBrokerConfigs sorts config entries by name.
+func SortBrokerConfigs(configs []BrokerConfig) {
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+}
+
+// DescribeBrokerConfigs returns dynamic config entries of a broker sorted by
+// name, that is entries set for the broker, and entries set for all brokers
+// of the cluster that the broker does not override. Entries are read from
+// ZooKeeper, where Kafka 1.1.0.0 and later keeps them. Static entries of
+// broker property files are not available there.
+func (a *T) DescribeBrokerConfigs(brokerID int32) ([]BrokerConfig, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkBroker(zkConn, brokerID); err != nil {
+		return nil, err
+	}
+	brokerCfg, err := a.getEntityConfig(zkConn, brokerEntityPath(brokerID))
+	if err != nil {
+		return nil, err
+	}
+	defaultCfg, err := a.getEntityConfig(zkConn, "brokers/"+brokerDefault)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]BrokerConfig, 0, len(brokerCfg)+len(defaultCfg))
+	for name, value := range brokerCfg {
+		configs = append(configs, NewBrokerConfig(name, value, ConfigSourceDynamic))
+	}
+	for name, value := range defaultCfg {
+		if _, ok := brokerCfg[name]; !ok {
+			configs = append(configs, NewBrokerConfig(name, value, ConfigSourceDynamicDefault))
+		}
+	}
+	SortBrokerConfigs(configs)
+	return configs, nil
+}
+
+// AlterBrokerConfigs sets and removes dynamic config entries of a broker as
+// `alter` prescribes, and notifies brokers of the change. A nil value removes
+// an entry, that is reverts it to the cluster-wide dynamic, the static or the
+// default value. Brokers older than 1.1.0.0 ignore dynamic entries.
+func (a *T) AlterBrokerConfigs(brokerID int32, alter map[string]*string) error {
+	if err := CheckBrokerConfigAlteration(alter); err != nil {
+		return err
+	}
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	if err := a.checkBroker(zkConn, brokerID); err != nil {
+		return err
+	}
+	return a.alterEntityConfig(zkConn, brokerEntityPath(brokerID), alter)
+}
+
+// checkBroker returns ErrBrokerNotFound if the broker with the given ID is
+// not registered in ZooKeeper.
+func (a *T) checkBroker(zkConn *zk.Conn, brokerID int32) error {
+	brokerPath := fmt.Sprintf("%s/brokers/ids/%d", a.cfg.ZooKeeper.Chroot, brokerID)
+	exists, _, err := zkConn.Exists(brokerPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch broker")
+	}
+	if !exists {
+		return errors.Wrapf(ErrBrokerNotFound, "broker=%d", brokerID)
+	}
+	return nil
+}
+
+// brokerEntityPath returns the path of the broker config relative to the
+// `/config` node.
+func brokerEntityPath(brokerID int32) string {
+	return fmt.Sprintf("brokers/%d", brokerID)
+}
+
+// isSensitiveBrokerConfig tells whether a broker config is of the password
+// type, that Kafka keeps encrypted.
+func isSensitiveBrokerConfig(name string) bool {
+	return strings.HasSuffix(name, ".password") || strings.HasSuffix(name, "sasl.jaas.config")
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type BrokerConfigSuite struct{}
+
+var _ = Suite(&BrokerConfigSuite{})
+
+// Values of password configs are withheld.
+func (s *BrokerConfigSuite) TestNewBrokerConfig(c *C) {
+	c.Assert(NewBrokerConfig("message.max.bytes", "1000012", ConfigSourceDynamic),
+		DeepEquals, BrokerConfig{Name: "message.max.bytes", Value: "1000012", Source: ConfigSourceDynamic})
+	c.Assert(NewBrokerConfig("listener.name.internal.ssl.key.password", "ZX2f", ConfigSourceDynamicDefault),
+		DeepEquals, BrokerConfig{Name: "listener.name.internal.ssl.key.password", Source: ConfigSourceDynamicDefault, Sensitive: true})
+	c.Assert(brokerEntityPath(3), Equals, "brokers/3")
+}
+
+func (s *BrokerConfigSuite) TestCheckBrokerConfigAlteration(c *C) {
+	threads := "2"
+	c.Assert(CheckBrokerConfigAlteration(map[string]*string{
+		"log.cleaner.threads": &threads,
+		"ssl.key.password":    nil,
+	}), IsNil)
+
+	for i, tc := range []struct {
+		alter map[string]*string
+		error string
+	}{{
+		alter: map[string]*string{"": &threads},
+		error: "config name is empty: invalid broker config",
+	}, {
+		alter: map[string]*string{"ssl.keystore.password": &threads},
+		error: "ssl.keystore.password is sensitive: invalid broker config",
+	}, {
+		alter: map[string]*string{"listener.name.sasl_ssl.plain.sasl.jaas.config": &threads},
+		error: "listener.name.sasl_ssl.plain.sasl.jaas.config is sensitive: invalid broker config",
+	}} {
+		// When
+		err := CheckBrokerConfigAlteration(tc.alter)
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+	}
+}
@@ -0,0 +1,219 @@
+package lagwatch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+// Snapshot describes offsets of all partitions of a topic committed by a
+// consumer group, along with partition ranges, at some point in time.
+type Snapshot struct {
+	Time time.Time
+
+	// Offsets are shared between all watchers, so they must not be
+	// modified.
+	Offsets []admin.PartitionOffset
+
+	// Err is the error that offsets failed to be fetched with.
+	Err error
+
+	// Thresholds of the total lag that were crossed since the previous
+	// snapshot, in either direction.
+	Crossed []int64
+
+	seq int64
+}
+
+// Lag returns the total lag of the group in all partitions of the topic.
+func (s *Snapshot) Lag() int64 {
+	var lag int64
+	for i := range s.Offsets {
+		lag += s.Offsets[i].Lag()
+	}
+	return lag
+}
+
+// Opts defines which snapshots a watcher gets.
+type Opts struct {
+	// Snapshots are delivered at most this often, unless a threshold is
+	// crossed. They cannot be delivered more often than offsets are polled.
+	Interval time.Duration
+
+	// A snapshot is delivered right away if the total lag crosses any of
+	// these thresholds.
+	Thresholds []int64
+}
+
+// T polls offsets of group/topic pairs that are being watched and hands out
+// snapshots to watchers. Offsets of a pair are polled once per interval
+// regardless of the number of watchers, and only while there is at least one.
+type T struct {
+	actorID    *actor.ID
+	getOffsets func(group, topic string) ([]admin.PartitionOffset, error)
+	interval   time.Duration
+	mu         sync.Mutex
+	pollers    map[groupTopic]*poller
+	wg         sync.WaitGroup
+}
+
+type groupTopic struct {
+	group string
+	topic string
+}
+
+type poller struct {
+	actorID   *actor.ID
+	watchers  int
+	latest    Snapshot
+	changedCh chan none.T
+	stopCh    chan none.T
+}
+
+// Watcher gets snapshots of offsets of a particular group/topic.
+type Watcher struct {
+	t       *T
+	gt      groupTopic
+	p       *poller
+	opts    Opts
+	lastSeq int64
+
+	// The previous snapshot, whether delivered or not.
+	seen    bool
+	seenLag int64
+	seenErr bool
+
+	// The last delivered snapshot.
+	delivered     bool
+	deliveredTime time.Time
+}
+
+// New creates a lag watch that polls offsets with `getOffsets` every
+// `interval`.
+func New(namespace *actor.ID, getOffsets func(group, topic string) ([]admin.PartitionOffset, error),
+	interval time.Duration,
+) *T {
+	return &T{
+		actorID:    namespace.NewChild("lag_watch"),
+		getOffsets: getOffsets,
+		interval:   interval,
+		pollers:    make(map[groupTopic]*poller),
+	}
+}
+
+// Watch starts watching offsets of a group/topic. Close should be called on
+// the returned watcher when it is not needed anymore.
+func (t *T) Watch(group, topic string, opts Opts) *Watcher {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gt := groupTopic{group, topic}
+	p := t.pollers[gt]
+	if p == nil {
+		p = &poller{
+			actorID:   t.actorID.NewChild(fmt.Sprintf("%s_%s", group, topic)),
+			changedCh: make(chan none.T),
+			stopCh:    make(chan none.T),
+		}
+		t.pollers[gt] = p
+		actor.Spawn(p.actorID, &t.wg, func() { t.poll(gt, p) })
+	}
+	p.watchers++
+	return &Watcher{t: t, gt: gt, p: p, opts: opts}
+}
+
+// Stop stops polling of all group/topic pairs. Pending Next calls of all
+// watchers return false.
+func (t *T) Stop() {
+	t.mu.Lock()
+	for gt, p := range t.pollers {
+		delete(t.pollers, gt)
+		close(p.stopCh)
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+func (t *T) poll(gt groupTopic, p *poller) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		offsets, err := t.getOffsets(gt.group, gt.topic)
+		t.mu.Lock()
+		p.latest = Snapshot{Time: time.Now().UTC(), Offsets: offsets, Err: err, seq: p.latest.seq + 1}
+		close(p.changedCh)
+		p.changedCh = make(chan none.T)
+		t.mu.Unlock()
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// Next blocks until a snapshot is due for delivery to the watcher as its
+// options prescribe. It returns false if `cancelCh` is closed or the lag
+// watch is stopped.
+func (w *Watcher) Next(cancelCh <-chan struct{}) (Snapshot, bool) {
+	for {
+		w.t.mu.Lock()
+		latest, changedCh := w.p.latest, w.p.changedCh
+		w.t.mu.Unlock()
+		if latest.seq > w.lastSeq {
+			w.lastSeq = latest.seq
+			if w.due(&latest) {
+				return latest, true
+			}
+		}
+		select {
+		case <-changedCh:
+		case <-cancelCh:
+			return Snapshot{}, false
+		case <-w.p.stopCh:
+			return Snapshot{}, false
+		}
+	}
+}
+
+// Close stops watching. Polling of the group/topic stops when its last
+// watcher is closed.
+func (w *Watcher) Close() {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	w.p.watchers--
+	if w.p.watchers == 0 && w.t.pollers[w.gt] == w.p {
+		delete(w.t.pollers, w.gt)
+		close(w.p.stopCh)
+	}
+}
+
+// due tells whether a snapshot should be delivered, filling in the
+// thresholds that it crossed.
+func (w *Watcher) due(s *Snapshot) bool {
+	isErr := s.Err != nil
+	var lag int64
+	if !isErr {
+		lag = s.Lag()
+		if w.seen && !w.seenErr {
+			for _, threshold := range w.opts.Thresholds {
+				if (w.seenLag < threshold) != (lag < threshold) {
+					s.Crossed = append(s.Crossed, threshold)
+				}
+			}
+		}
+	}
+	// The first error after successful snapshots, and the first snapshot
+	// after errors, are delivered right away too.
+	changed := w.seen && isErr != w.seenErr
+	w.seen, w.seenLag, w.seenErr = true, lag, isErr
+
+	if w.delivered && len(s.Crossed) == 0 && !changed && s.Time.Sub(w.deliveredTime) < w.opts.Interval {
+		return false
+	}
+	w.delivered, w.deliveredTime = true, s.Time
+	return true
+}
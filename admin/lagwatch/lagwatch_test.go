@@ -0,0 +1,168 @@
+package lagwatch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type LagWatchSuite struct{}
+
+var _ = Suite(&LagWatchSuite{})
+
+// testOffsets returns offsets with lags taken one by one from a list, the
+// last one is returned over and over. Negative lags are returned as errors.
+type testOffsets struct {
+	mu    sync.Mutex
+	lags  []int64
+	calls int
+}
+
+func (to *testOffsets) get(group, topic string) ([]admin.PartitionOffset, error) {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	lag := to.lags[0]
+	if len(to.lags) > 1 {
+		to.lags = to.lags[1:]
+	}
+	to.calls++
+	if lag < 0 {
+		return nil, errors.New("kaboom")
+	}
+	return []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 1000, Offset: 1000 - lag/2},
+		{Partition: 1, Begin: 0, End: 1000, Offset: 1000 - lag + lag/2},
+	}, nil
+}
+
+func (to *testOffsets) callCount() int {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	return to.calls
+}
+
+// Snapshots are delivered at the watcher interval.
+func (s *LagWatchSuite) TestInterval(c *C) {
+	to := &testOffsets{lags: []int64{10, 20, 30, 40, 50, 60}}
+	lw := New(actor.RootID, to.get, 10*time.Millisecond)
+	defer lw.Stop()
+
+	// When
+	w := lw.Watch("foo", "bar", Opts{Interval: 25 * time.Millisecond})
+	defer w.Close()
+
+	// Then
+	first, ok := w.Next(nil)
+	c.Assert(ok, Equals, true)
+	c.Assert(first.Lag(), Equals, int64(10))
+	second, ok := w.Next(nil)
+	c.Assert(ok, Equals, true)
+	c.Assert(second.Time.Sub(first.Time) >= 25*time.Millisecond, Equals, true)
+	c.Assert(second.Lag() >= 30, Equals, true)
+}
+
+// Crossing of a threshold is delivered right away, regardless of the interval.
+func (s *LagWatchSuite) TestThresholds(c *C) {
+	to := &testOffsets{lags: []int64{10, 20, 120, 130, 90, 80}}
+	lw := New(actor.RootID, to.get, 10*time.Millisecond)
+	defer lw.Stop()
+
+	// When
+	w := lw.Watch("foo", "bar", Opts{Interval: time.Hour, Thresholds: []int64{100, 125}})
+	defer w.Close()
+
+	// Then
+	var lags []int64
+	var crossed [][]int64
+	for i := 0; i < 4; i++ {
+		snapshot, ok := w.Next(nil)
+		c.Assert(ok, Equals, true)
+		lags = append(lags, snapshot.Lag())
+		crossed = append(crossed, snapshot.Crossed)
+	}
+	c.Assert(lags, DeepEquals, []int64{10, 120, 130, 90})
+	c.Assert(crossed, DeepEquals, [][]int64{nil, {100}, {125}, {100, 125}})
+}
+
+// Errors are delivered right away, and so is the first snapshot after them.
+func (s *LagWatchSuite) TestErrors(c *C) {
+	to := &testOffsets{lags: []int64{10, -1, -1, 20}}
+	lw := New(actor.RootID, to.get, 10*time.Millisecond)
+	defer lw.Stop()
+
+	// When
+	w := lw.Watch("foo", "bar", Opts{Interval: time.Hour})
+	defer w.Close()
+
+	// Then
+	var errs []string
+	for i := 0; i < 3; i++ {
+		snapshot, ok := w.Next(nil)
+		c.Assert(ok, Equals, true)
+		if snapshot.Err != nil {
+			errs = append(errs, snapshot.Err.Error())
+		} else {
+			errs = append(errs, "")
+		}
+	}
+	c.Assert(errs, DeepEquals, []string{"", "kaboom", ""})
+}
+
+// Watchers of the same group/topic share polling, and it stops when the last
+// of them is closed.
+func (s *LagWatchSuite) TestSharedPolling(c *C) {
+	to := &testOffsets{lags: []int64{10}}
+	lw := New(actor.RootID, to.get, 10*time.Millisecond)
+	defer lw.Stop()
+	w1 := lw.Watch("foo", "bar", Opts{})
+	w2 := lw.Watch("foo", "bar", Opts{})
+
+	c.Assert(len(lw.pollers), Equals, 1)
+	for i := 0; i < 3; i++ {
+		_, ok := w1.Next(nil)
+		c.Assert(ok, Equals, true)
+		_, ok = w2.Next(nil)
+		c.Assert(ok, Equals, true)
+	}
+
+	// When
+	w1.Close()
+	w2.Close()
+
+	// Then
+	c.Assert(len(lw.pollers), Equals, 0)
+	// Let a poll that might be in progress complete.
+	time.Sleep(20 * time.Millisecond)
+	calls := to.callCount()
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(to.callCount(), Equals, calls)
+}
+
+// Next returns false when canceled or when the lag watch is stopped.
+func (s *LagWatchSuite) TestCancel(c *C) {
+	to := &testOffsets{lags: []int64{10}}
+	lw := New(actor.RootID, to.get, 10*time.Millisecond)
+	w := lw.Watch("foo", "bar", Opts{Interval: time.Hour})
+	defer w.Close()
+	_, ok := w.Next(nil)
+	c.Assert(ok, Equals, true)
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+
+	// When/Then
+	_, ok = w.Next(cancelCh)
+	c.Assert(ok, Equals, false)
+
+	lw.Stop()
+	_, ok = w.Next(nil)
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+)
+
+// readMessagesTimeout is how long ReadMessages waits for messages to be
+// fetched from a partition, before it returns those fetched so far.
+var readMessagesTimeout = 10 * time.Second
+
+// OffsetForTime returns the offset of the first message of a partition with a
+// timestamp at or after `t`, or the partition end offset if there is none.
+func (a *T) OffsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return 0, err
+	}
+	offset, err := kafkaClt.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get offset by time, partition=%d", partition)
+	}
+	if offset < 0 {
+		if offset, err = kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+			return 0, errors.Wrapf(err, "failed to get newest offset, partition=%d", partition)
+		}
+	}
+	return offset, nil
+}
+
+// PartitionOffsets returns the offset of the first message of a partition
+// and the offset of the message to be produced to it next.
+func (a *T) PartitionOffsets(topic string, partition int32) (int64, int64, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return 0, 0, err
+	}
+	begin, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get oldest offset, partition=%d", partition)
+	}
+	end, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get newest offset, partition=%d", partition)
+	}
+	return begin, end, nil
+}
+
+// ReadMessages returns up to `count` messages of a partition starting from
+// `offset`, without committing anything on behalf of any group. Fewer
+// messages are returned if the end of the partition is reached.
+func (a *T) ReadMessages(topic string, partition int32, offset int64, count int) ([]consumer.Message, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	end, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get newest offset, partition=%d", partition)
+	}
+	if offset >= end {
+		return nil, nil
+	}
+	// The consumer shares the client, so closing it leaves the client open.
+	saramaCsm, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create consumer")
+	}
+	defer saramaCsm.Close()
+	pc, err := saramaCsm.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to consume partition=%d, offset=%d", partition, offset)
+	}
+	defer pc.Close()
+
+	var messages []consumer.Message
+	timeoutCh := time.After(readMessagesTimeout)
+	for len(messages) < count && offset < end {
+		select {
+		case msg := <-pc.Messages():
+			messages = append(messages, consumer.Message{
+				Key:           msg.Key,
+				Value:         msg.Value,
+				Topic:         topic,
+				Partition:     partition,
+				Offset:        msg.Offset,
+				Timestamp:     msg.Timestamp,
+				HighWaterMark: pc.HighWaterMarkOffset(),
+			})
+			offset = msg.Offset + 1
+		case <-timeoutCh:
+			if len(messages) == 0 {
+				return nil, errors.Errorf("timeout reading partition=%d, offset=%d", partition, offset)
+			}
+			return messages, nil
+		}
+	}
+	return messages, nil
+}
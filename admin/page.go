@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Page selects a page of a sorted list of names, e.g. topics or consumer
+// groups. A zero Page selects all names.
+type Page struct {
+	// Only names with this prefix are selected.
+	Prefix string
+
+	// If not nil, only names that match it, with Prefix trimmed, are selected.
+	Filter *regexp.Regexp
+
+	// Only names greater then this one are selected. It is supposed to be
+	// the last name of the previous page.
+	After string
+
+	// Maximum number of names to select. Zero means unlimited.
+	Limit int
+}
+
+// Match tells whether a name satisfies the page Prefix and Filter.
+func (pg *Page) Match(name string) bool {
+	if !strings.HasPrefix(name, pg.Prefix) {
+		return false
+	}
+	return pg.Filter == nil || pg.Filter.MatchString(name[len(pg.Prefix):])
+}
+
+// Select returns names from a sorted list that belong to the page, and
+// whether there are more matching names after the page.
+func (pg *Page) Select(names []string) ([]string, bool) {
+	selected := []string{}
+	for _, name := range names {
+		if name <= pg.After || !pg.Match(name) {
+			continue
+		}
+		if pg.Limit > 0 && len(selected) == pg.Limit {
+			return selected, true
+		}
+		selected = append(selected, name)
+	}
+	return selected, false
+}
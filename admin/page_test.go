@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"regexp"
+
+	. "gopkg.in/check.v1"
+)
+
+type PageSuite struct{}
+
+var _ = Suite(&PageSuite{})
+
+func (s *PageSuite) TestSelect(c *C) {
+	names := []string{"a_bar", "a_foo1", "a_foo2", "a_foo3", "b_foo"}
+	for i, tc := range []struct {
+		pg       Page
+		selected []string
+		more     bool
+	}{{
+		pg:       Page{},
+		selected: names,
+	}, {
+		pg:       Page{Prefix: "a_", Filter: regexp.MustCompile("^foo")},
+		selected: []string{"a_foo1", "a_foo2", "a_foo3"},
+	}, {
+		pg:       Page{Prefix: "a_", Limit: 2},
+		selected: []string{"a_bar", "a_foo1"},
+		more:     true,
+	}, {
+		pg:       Page{Prefix: "a_", After: "a_foo1", Limit: 2},
+		selected: []string{"a_foo2", "a_foo3"},
+	}, {
+		pg:       Page{After: "b_foo"},
+		selected: []string{},
+	}} {
+		selected, more := tc.pg.Select(names)
+		c.Assert(selected, DeepEquals, tc.selected, Commentf("case #%d", i))
+		c.Assert(more, Equals, tc.more, Commentf("case #%d", i))
+	}
+}
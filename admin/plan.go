@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// OffsetChange describes how committing an offset would change the offset of
+// a partition, that is what a dry run of SetGroupOffsets reports.
+type OffsetChange struct {
+	Partition   int32
+	Begin       int64
+	End         int64
+	Offset      int64
+	NewOffset   int64
+	Metadata    string
+	NewMetadata string
+}
+
+// OutOfRange tells whether the new offset is outside the partition range, in
+// which case consumption would start from a position determined by the
+// `consumer.offset_reset` policy.
+func (oc *OffsetChange) OutOfRange() bool {
+	return oc.NewOffset < oc.Begin || oc.NewOffset > oc.End
+}
+
+// PlanOffsetChanges returns changes that committing `offsets` would make to
+// `current` offsets of a topic partitions, as returned by GetGroupOffsets.
+// Changes are returned in the order of `offsets`. An error is returned if
+// `offsets` mention a partition that the topic does not have.
+func PlanOffsetChanges(current, offsets []PartitionOffset) ([]OffsetChange, error) {
+	byPartition := make(map[int32]PartitionOffset, len(current))
+	for _, po := range current {
+		byPartition[po.Partition] = po
+	}
+	changes := make([]OffsetChange, len(offsets))
+	for i, po := range offsets {
+		cur, ok := byPartition[po.Partition]
+		if !ok {
+			return nil, errors.Wrapf(sarama.ErrUnknownTopicOrPartition,
+				"failed to commit offset, partition=%d", po.Partition)
+		}
+		changes[i] = OffsetChange{
+			Partition:   po.Partition,
+			Begin:       cur.Begin,
+			End:         cur.End,
+			Offset:      cur.Offset,
+			NewOffset:   po.Offset,
+			Metadata:    cur.Metadata,
+			NewMetadata: po.Metadata,
+		}
+	}
+	return changes, nil
+}
+
+// Shift computes a new offset of a partition from its current offset and
+// range. Use ShiftBy, ShiftToBegin, ShiftToEnd and ShiftToPercent to create
+// one.
+type Shift func(po PartitionOffset) int64
+
+// ShiftBy moves offsets by n messages, back if n is negative. Offsets that
+// are not committed yet are moved from where a consumer would start, as it
+// is told by the sarama.OffsetNewest or sarama.OffsetOldest value.
+func ShiftBy(n int64) Shift {
+	return func(po PartitionOffset) int64 {
+		switch po.Offset {
+		case sarama.OffsetNewest:
+			return po.End + n
+		case sarama.OffsetOldest:
+			return po.Begin + n
+		default:
+			return po.Offset + n
+		}
+	}
+}
+
+// ShiftToBegin moves offsets to the beginning of partitions.
+func ShiftToBegin() Shift {
+	return func(po PartitionOffset) int64 {
+		return po.Begin
+	}
+}
+
+// ShiftToEnd moves offsets to the end of partitions.
+func ShiftToEnd() Shift {
+	return func(po PartitionOffset) int64 {
+		return po.End
+	}
+}
+
+// ShiftToPercent moves offsets to the specified percentage of partition
+// ranges, e.g. to the middle of partitions for 50.
+func ShiftToPercent(percent float64) Shift {
+	return func(po PartitionOffset) int64 {
+		return po.Begin + int64(float64(po.End-po.Begin)*percent/100)
+	}
+}
+
+// ShiftOffsets returns offsets of all partitions in `current`, as returned by
+// GetGroupOffsets, moved as `shift` prescribes. New offsets are clamped to
+// partition ranges, and metadata is preserved.
+func ShiftOffsets(current []PartitionOffset, shift Shift) []PartitionOffset {
+	offsets := make([]PartitionOffset, len(current))
+	for i, po := range current {
+		offset := shift(po)
+		if offset < po.Begin {
+			offset = po.Begin
+		}
+		if offset > po.End {
+			offset = po.End
+		}
+		offsets[i] = PartitionOffset{Partition: po.Partition, Offset: offset, Metadata: po.Metadata}
+	}
+	return offsets
+}
+
+// RecordsDeletion describes how deleting records before an offset would
+// change the range of a partition, that is what a dry run of DeleteRecords
+// reports.
+type RecordsDeletion struct {
+	Partition int32
+	Begin     int64
+	End       int64
+	Offset    int64
+}
+
+// Deleted returns the number of records that the deletion removes. It is zero
+// if the offset is not beyond the partition beginning, e.g. because the
+// records have been removed by retention already.
+func (rd *RecordsDeletion) Deleted() int64 {
+	if rd.Offset <= rd.Begin {
+		return 0
+	}
+	return rd.Offset - rd.Begin
+}
+
+// PlanRecordsDeletion returns deletions that removing records before
+// `offsets` would make to `ranges` of a topic partitions, as returned by
+// TopicOffsets. The sarama.OffsetNewest offset stands for the partition end,
+// that is all records of the partition are deleted. Deletions are returned in
+// the order of `offsets`. An error is returned if `offsets` mention a
+// partition that the topic does not have, or an offset beyond the partition
+// end.
+func PlanRecordsDeletion(ranges, offsets []PartitionOffset) ([]RecordsDeletion, error) {
+	byPartition := make(map[int32]PartitionOffset, len(ranges))
+	for _, po := range ranges {
+		byPartition[po.Partition] = po
+	}
+	deletions := make([]RecordsDeletion, len(offsets))
+	for i, po := range offsets {
+		cur, ok := byPartition[po.Partition]
+		if !ok {
+			return nil, errors.Wrapf(sarama.ErrUnknownTopicOrPartition,
+				"failed to delete records, partition=%d", po.Partition)
+		}
+		offset := po.Offset
+		if offset == sarama.OffsetNewest {
+			offset = cur.End
+		}
+		if offset < 0 || offset > cur.End {
+			return nil, errors.Wrapf(ErrOffsetOutOfRange,
+				"failed to delete records, partition=%d, offset=%d, end=%d", po.Partition, po.Offset, cur.End)
+		}
+		deletions[i] = RecordsDeletion{
+			Partition: po.Partition,
+			Begin:     cur.Begin,
+			End:       cur.End,
+			Offset:    offset,
+		}
+	}
+	return deletions, nil
+}
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type PlanSuite struct{}
+
+var _ = Suite(&PlanSuite{})
+
+var testCurrentOffsets = []PartitionOffset{
+	{Partition: 0, Begin: 10, End: 100, Offset: 50, Metadata: "a"},
+	{Partition: 1, Begin: 0, End: 200, Offset: 200},
+}
+
+func (s *PlanSuite) TestPlanOffsetChanges(c *C) {
+	// When
+	changes, err := PlanOffsetChanges(testCurrentOffsets, []PartitionOffset{
+		{Partition: 1, Offset: 150, Metadata: "b"},
+		{Partition: 0, Offset: 5},
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(changes, DeepEquals, []OffsetChange{
+		{Partition: 1, Begin: 0, End: 200, Offset: 200, NewOffset: 150, NewMetadata: "b"},
+		{Partition: 0, Begin: 10, End: 100, Offset: 50, NewOffset: 5, Metadata: "a"},
+	})
+	c.Assert(changes[0].OutOfRange(), Equals, false)
+	c.Assert(changes[1].OutOfRange(), Equals, true)
+}
+
+// Offsets of partitions that a topic does not have cannot be set.
+func (s *PlanSuite) TestPlanOffsetChangesUnknownPartition(c *C) {
+	// When
+	_, err := PlanOffsetChanges(testCurrentOffsets, []PartitionOffset{{Partition: 2, Offset: 1}})
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, sarama.ErrUnknownTopicOrPartition)
+	c.Assert(err, ErrorMatches, "failed to commit offset, partition=2: .*")
+}
+
+func (s *PlanSuite) TestPlanRecordsDeletion(c *C) {
+	// When
+	deletions, err := PlanRecordsDeletion(testCurrentOffsets, []PartitionOffset{
+		{Partition: 1, Offset: sarama.OffsetNewest},
+		{Partition: 0, Offset: 5},
+		{Partition: 0, Offset: 40},
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(deletions, DeepEquals, []RecordsDeletion{
+		{Partition: 1, Begin: 0, End: 200, Offset: 200},
+		{Partition: 0, Begin: 10, End: 100, Offset: 5},
+		{Partition: 0, Begin: 10, End: 100, Offset: 40},
+	})
+	c.Assert(deletions[0].Deleted(), Equals, int64(200))
+	c.Assert(deletions[1].Deleted(), Equals, int64(0))
+	c.Assert(deletions[2].Deleted(), Equals, int64(30))
+}
+
+func (s *PlanSuite) TestPlanRecordsDeletionInvalid(c *C) {
+	for i, tc := range []struct {
+		offset PartitionOffset
+		error  string
+	}{{
+		offset: PartitionOffset{Partition: 2, Offset: 1},
+		error:  "failed to delete records, partition=2: .*",
+	}, {
+		offset: PartitionOffset{Partition: 0, Offset: 101},
+		error:  "failed to delete records, partition=0, offset=101, end=100: offset out of range",
+	}, {
+		offset: PartitionOffset{Partition: 0, Offset: sarama.OffsetOldest},
+		error:  "failed to delete records, partition=0, offset=-2, end=100: offset out of range",
+	}} {
+		// When
+		_, err := PlanRecordsDeletion(testCurrentOffsets, []PartitionOffset{tc.offset})
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Offsets that are not committed yet are shifted from the end or the
+// beginning of partitions.
+func (s *PlanSuite) TestShiftByUncommitted(c *C) {
+	offsets := ShiftOffsets([]PartitionOffset{
+		{Partition: 0, Begin: 10, End: 100, Offset: sarama.OffsetNewest},
+		{Partition: 1, Begin: 10, End: 100, Offset: sarama.OffsetOldest},
+	}, ShiftBy(-5))
+	c.Assert(offsets, DeepEquals, []PartitionOffset{
+		{Partition: 0, Offset: 95},
+		{Partition: 1, Offset: 10},
+	})
+}
+
+func (s *PlanSuite) TestShiftOffsets(c *C) {
+	for i, tc := range []struct {
+		shift   Shift
+		offsets []int64
+	}{{
+		shift:   ShiftBy(-20),
+		offsets: []int64{30, 180},
+	}, {
+		shift:   ShiftBy(20),
+		offsets: []int64{70, 200}, // Clamped to the end.
+	}, {
+		shift:   ShiftBy(-1000),
+		offsets: []int64{10, 0}, // Clamped to the beginning.
+	}, {
+		shift:   ShiftToBegin(),
+		offsets: []int64{10, 0},
+	}, {
+		shift:   ShiftToEnd(),
+		offsets: []int64{100, 200},
+	}, {
+		shift:   ShiftToPercent(25),
+		offsets: []int64{32, 50},
+	}} {
+		// When
+		offsets := ShiftOffsets(testCurrentOffsets, tc.shift)
+
+		// Then
+		c.Assert(offsets, DeepEquals, []PartitionOffset{
+			{Partition: 0, Offset: tc.offsets[0], Metadata: "a"},
+			{Partition: 1, Offset: tc.offsets[1]},
+		}, Commentf("case #%d", i))
+	}
+}
@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Client quotas that Kafka enforces.
+const (
+	QuotaProducerByteRate  = "producer_byte_rate"
+	QuotaConsumerByteRate  = "consumer_byte_rate"
+	QuotaRequestPercentage = "request_percentage"
+)
+
+// QuotaDefault is a user or client ID that stands for all users or client
+// IDs that do not have a quota of their own.
+const QuotaDefault = "<default>"
+
+// ErrInvalidQuota is returned if a quota alteration names an unknown quota,
+// has an invalid value, or does not name whom quotas apply to.
+var ErrInvalidQuota = errors.New("invalid quota")
+
+// quotaKinds tells whether values of client quotas are whole numbers.
+var quotaKinds = map[string]bool{
+	QuotaProducerByteRate:  true,
+	QuotaConsumerByteRate:  true,
+	QuotaRequestPercentage: false,
+}
+
+// QuotaEntity tells whom client quotas apply to: a user principal, a client
+// ID, or a client ID of a particular user. Either can be QuotaDefault.
+type QuotaEntity struct {
+	User     string
+	ClientID string
+}
+
+// ClientQuotas are values of client quotas along with whom they apply to.
+type ClientQuotas struct {
+	Entity QuotaEntity
+	Values map[string]float64
+}
+
+// CheckQuotaAlteration returns an error caused by ErrInvalidQuota if quotas of
+// `entity` cannot be altered as `alter` prescribes. A nil value in `alter`
+// removes the quota.
+func CheckQuotaAlteration(entity QuotaEntity, alter map[string]*float64) error {
+	if entity.User == "" && entity.ClientID == "" {
+		return errors.Wrap(ErrInvalidQuota, "either user or client ID should be provided")
+	}
+	for key, value := range alter {
+		whole, ok := quotaKinds[key]
+		if !ok {
+			return errors.Wrapf(ErrInvalidQuota, "unknown quota: %s", key)
+		}
+		if value == nil {
+			continue
+		}
+		if *value <= 0 || (whole && *value != math.Trunc(*value)) {
+			return errors.Wrapf(ErrInvalidQuota, "bad %s: %v", key, *value)
+		}
+	}
+	return nil
+}
+
+// AlterQuotas returns `current` quotas altered as `alter` prescribes, that is
+// quotas with nil values are removed and all others are set.
+func AlterQuotas(current map[string]float64, alter map[string]*float64) map[string]float64 {
+	altered := make(map[string]float64, len(current)+len(alter))
+	for key, value := range current {
+		altered[key] = value
+	}
+	for key, value := range alter {
+		if value == nil {
+			delete(altered, key)
+			continue
+		}
+		altered[key] = *value
+	}
+	return altered
+}
+
+// SortClientQuotas sorts quotas by user and then by client ID.
+func SortClientQuotas(quotas []ClientQuotas) {
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Entity.User != quotas[j].Entity.User {
+			return quotas[i].Entity.User < quotas[j].Entity.User
+		}
+		return quotas[i].Entity.ClientID < quotas[j].Entity.ClientID
+	})
+}
+
+// DescribeClientQuotas returns all client quotas set in the cluster, sorted
+// by user and then by client ID. Quotas are read from ZooKeeper, the same way
+// Kafka admin tools do.
+func (a *T) DescribeClientQuotas() ([]ClientQuotas, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	var quotas []ClientQuotas
+	users, err := a.zkChildren(zkConn, "users")
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if quotas, err = a.appendClientQuotas(zkConn, quotas, QuotaEntity{User: unsanitize(user)}); err != nil {
+			return nil, err
+		}
+		clientIDs, err := a.zkChildren(zkConn, "users/"+user+"/clients")
+		if err != nil {
+			return nil, err
+		}
+		for _, clientID := range clientIDs {
+			entity := QuotaEntity{User: unsanitize(user), ClientID: unsanitize(clientID)}
+			if quotas, err = a.appendClientQuotas(zkConn, quotas, entity); err != nil {
+				return nil, err
+			}
+		}
+	}
+	clientIDs, err := a.zkChildren(zkConn, "clients")
+	if err != nil {
+		return nil, err
+	}
+	for _, clientID := range clientIDs {
+		if quotas, err = a.appendClientQuotas(zkConn, quotas, QuotaEntity{ClientID: unsanitize(clientID)}); err != nil {
+			return nil, err
+		}
+	}
+	SortClientQuotas(quotas)
+	return quotas, nil
+}
+
+// AlterClientQuotas alters client quotas of `entity` as `alter` prescribes,
+// and notifies brokers of the change. Other configs of the entity, e.g. SCRAM
+// credentials of a user, are preserved.
+func (a *T) AlterClientQuotas(entity QuotaEntity, alter map[string]*float64) error {
+	if err := CheckQuotaAlteration(entity, alter); err != nil {
+		return err
+	}
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	values := make(map[string]*string, len(alter))
+	for key, value := range alter {
+		if value == nil {
+			values[key] = nil
+			continue
+		}
+		valueStr := strconv.FormatFloat(*value, 'f', -1, 64)
+		values[key] = &valueStr
+	}
+	return a.alterEntityConfig(zkConn, quotaEntityPath(entity), values)
+}
+
+// appendClientQuotas appends quotas of `entity` to `quotas`, unless it has
+// none. Configs other than client quotas are skipped.
+func (a *T) appendClientQuotas(zkConn *zk.Conn, quotas []ClientQuotas, entity QuotaEntity) ([]ClientQuotas, error) {
+	entityPath := quotaEntityPath(entity)
+	cfg, err := a.getEntityConfig(zkConn, entityPath)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for key, valueStr := range cfg {
+		if _, ok := quotaKinds[key]; !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad %s, entity=%s", key, entityPath)
+		}
+		values[key] = value
+	}
+	if len(values) == 0 {
+		return quotas, nil
+	}
+	return append(quotas, ClientQuotas{Entity: entity, Values: values}), nil
+}
+
+// quotaEntityPath returns the path of the entity config relative to the
+// `/config` node.
+func quotaEntityPath(entity QuotaEntity) string {
+	switch {
+	case entity.User == "":
+		return "clients/" + sanitize(entity.ClientID)
+	case entity.ClientID == "":
+		return "users/" + sanitize(entity.User)
+	}
+	return "users/" + sanitize(entity.User) + "/clients/" + sanitize(entity.ClientID)
+}
+
+// sanitize makes a valid ZooKeeper node name of a user or a client ID, the
+// same way Kafka does, that is URL encodes it.
+func sanitize(name string) string {
+	if name == QuotaDefault {
+		return name
+	}
+	return strings.NewReplacer("+", "%20", "~", "%7E").Replace(url.QueryEscape(name))
+}
+
+func unsanitize(name string) string {
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type QuotasSuite struct{}
+
+var _ = Suite(&QuotasSuite{})
+
+// Entity configs are stored at the same paths that Kafka admin tools use,
+// with names URL encoded.
+func (s *QuotasSuite) TestQuotaEntityPath(c *C) {
+	c.Assert(quotaEntityPath(QuotaEntity{ClientID: "foo"}), Equals, "clients/foo")
+	c.Assert(quotaEntityPath(QuotaEntity{User: "CN=alice, O=acme*"}), Equals, "users/CN%3Dalice%2C%20O%3Dacme%2A")
+	c.Assert(quotaEntityPath(QuotaEntity{User: QuotaDefault, ClientID: "a/b"}), Equals, "users/<default>/clients/a%2Fb")
+	c.Assert(unsanitize(sanitize("CN=alice, O=acme*~")), Equals, "CN=alice, O=acme*~")
+}
+
+func (s *QuotasSuite) TestCheckQuotaAlteration(c *C) {
+	rate, percentage, fraction := 1024.0, 12.5, 0.5
+	c.Assert(CheckQuotaAlteration(QuotaEntity{User: "alice"}, map[string]*float64{
+		QuotaProducerByteRate:  &rate,
+		QuotaConsumerByteRate:  nil,
+		QuotaRequestPercentage: &percentage,
+	}), IsNil)
+
+	for i, tc := range []struct {
+		entity QuotaEntity
+		alter  map[string]*float64
+		error  string
+	}{{
+		alter: map[string]*float64{QuotaProducerByteRate: &rate},
+		error: "either user or client ID should be provided: invalid quota",
+	}, {
+		entity: QuotaEntity{ClientID: "foo"},
+		alter:  map[string]*float64{"connection_creation_rate": &rate},
+		error:  "unknown quota: connection_creation_rate: invalid quota",
+	}, {
+		entity: QuotaEntity{ClientID: "foo"},
+		alter:  map[string]*float64{QuotaConsumerByteRate: &fraction},
+		error:  "bad consumer_byte_rate: 0.5: invalid quota",
+	}} {
+		// When
+		err := CheckQuotaAlteration(tc.entity, tc.alter)
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *QuotasSuite) TestAlterQuotas(c *C) {
+	rate := 2048.0
+	current := map[string]float64{QuotaProducerByteRate: 1024, QuotaConsumerByteRate: 4096}
+
+	// When
+	altered := AlterQuotas(current, map[string]*float64{QuotaProducerByteRate: &rate, QuotaConsumerByteRate: nil})
+
+	// Then
+	c.Assert(altered, DeepEquals, map[string]float64{QuotaProducerByteRate: 2048})
+	c.Assert(current[QuotaConsumerByteRate], Equals, float64(4096))
+}
@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheStats describes the state of the ZooKeeper data cache.
+type CacheStats struct {
+	Entries int
+	Hits    int64
+	Misses  int64
+}
+
+// zkCache caches children lists and data of ZooKeeper nodes for a fixed TTL.
+// Errors are never cached. If TTL is zero, then every lookup is a miss.
+type zkCache struct {
+	ttl      time.Duration
+	clock    func() time.Time
+	mu       sync.Mutex
+	children map[string]zkChildrenEntry
+	data     map[string]zkDataEntry
+	hits     int64
+	misses   int64
+}
+
+type zkChildrenEntry struct {
+	children []string
+	expires  time.Time
+}
+
+type zkDataEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+func newZKCache(ttl time.Duration) *zkCache {
+	return &zkCache{
+		ttl:      ttl,
+		clock:    time.Now,
+		children: make(map[string]zkChildrenEntry),
+		data:     make(map[string]zkDataEntry),
+	}
+}
+
+// getChildren returns a cached children list of a node, or calls `fetch` to
+// get it if the node is not in the cache or its entry has expired.
+func (zc *zkCache) getChildren(path string, fetch func() ([]string, error)) ([]string, error) {
+	zc.mu.Lock()
+	now := zc.clock()
+	if entry, ok := zc.children[path]; ok && now.Before(entry.expires) {
+		zc.hits++
+		zc.mu.Unlock()
+		return entry.children, nil
+	}
+	zc.misses++
+	zc.mu.Unlock()
+
+	children, err := fetch()
+	if err != nil || zc.ttl <= 0 {
+		return children, err
+	}
+	zc.mu.Lock()
+	zc.children[path] = zkChildrenEntry{children, now.Add(zc.ttl)}
+	zc.mu.Unlock()
+	return children, nil
+}
+
+// getData is like getChildren but for node data.
+func (zc *zkCache) getData(path string, fetch func() ([]byte, error)) ([]byte, error) {
+	zc.mu.Lock()
+	now := zc.clock()
+	if entry, ok := zc.data[path]; ok && now.Before(entry.expires) {
+		zc.hits++
+		zc.mu.Unlock()
+		return entry.data, nil
+	}
+	zc.misses++
+	zc.mu.Unlock()
+
+	data, err := fetch()
+	if err != nil || zc.ttl <= 0 {
+		return data, err
+	}
+	zc.mu.Lock()
+	zc.data[path] = zkDataEntry{data, now.Add(zc.ttl)}
+	zc.mu.Unlock()
+	return data, nil
+}
+
+// invalidate drops all cached entries.
+func (zc *zkCache) invalidate() {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	zc.children = make(map[string]zkChildrenEntry)
+	zc.data = make(map[string]zkDataEntry)
+}
+
+func (zc *zkCache) stats() CacheStats {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	return CacheStats{
+		Entries: len(zc.children) + len(zc.data),
+		Hits:    zc.hits,
+		Misses:  zc.misses,
+	}
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ZKCacheSuite struct{}
+
+var _ = Suite(&ZKCacheSuite{})
+
+func (s *ZKCacheSuite) TestTTL(c *C) {
+	now := time.Now()
+	zc := newZKCache(time.Second)
+	zc.clock = func() time.Time { return now }
+	fetches := 0
+	fetch := func() ([]string, error) {
+		fetches++
+		return []string{"foo"}, nil
+	}
+
+	// When
+	zc.getChildren("/a", fetch)
+	now = now.Add(999 * time.Millisecond)
+	children, err := zc.getChildren("/a", fetch)
+	now = now.Add(time.Millisecond)
+	zc.getChildren("/a", fetch)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(children, DeepEquals, []string{"foo"})
+	c.Assert(fetches, Equals, 2)
+	c.Assert(zc.stats(), DeepEquals, CacheStats{Entries: 1, Hits: 1, Misses: 2})
+}
+
+// Errors are not cached.
+func (s *ZKCacheSuite) TestError(c *C) {
+	zc := newZKCache(time.Minute)
+	fetches := 0
+	fetch := func() ([]byte, error) {
+		fetches++
+		return nil, errors.New("kaboom")
+	}
+
+	// When
+	_, err := zc.getData("/a", fetch)
+	zc.getData("/a", fetch)
+
+	// Then
+	c.Assert(err, ErrorMatches, "kaboom")
+	c.Assert(fetches, Equals, 2)
+	c.Assert(zc.stats().Entries, Equals, 0)
+}
+
+func (s *ZKCacheSuite) TestInvalidate(c *C) {
+	zc := newZKCache(time.Minute)
+	fetches := 0
+	fetch := func() ([]byte, error) {
+		fetches++
+		return []byte("bar"), nil
+	}
+
+	// When
+	zc.getData("/a", fetch)
+	zc.invalidate()
+	data, _ := zc.getData("/a", fetch)
+
+	// Then
+	c.Assert(string(data), Equals, "bar")
+	c.Assert(fetches, Equals, 2)
+}
+
+// If TTL is zero, then nothing is cached.
+func (s *ZKCacheSuite) TestDisabled(c *C) {
+	zc := newZKCache(0)
+	fetches := 0
+	fetch := func() ([]string, error) {
+		fetches++
+		return nil, nil
+	}
+
+	zc.getChildren("/a", fetch)
+	zc.getChildren("/a", fetch)
+
+	c.Assert(fetches, Equals, 2)
+	c.Assert(zc.stats(), DeepEquals, CacheStats{Misses: 2})
+}
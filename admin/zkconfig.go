@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// zkEntityConfig is the format entity configs are stored in ZooKeeper in.
+type zkEntityConfig struct {
+	Version int               `json:"version"`
+	Config  map[string]string `json:"config"`
+}
+
+// getEntityConfig returns configs of an entity, e.g. `clients/foo` or
+// `brokers/1`, or none if the entity has no configs.
+func (a *T) getEntityConfig(zkConn *zk.Conn, entityPath string) (map[string]string, error) {
+	cfgPath := fmt.Sprintf("%s/config/%s", a.cfg.ZooKeeper.Chroot, entityPath)
+	data, _, err := zkConn.Get(cfgPath)
+	if err == zk.ErrNoNode || (err == nil && len(data) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get config, path=%s", cfgPath)
+	}
+	var cfg zkEntityConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config, path=%s", cfgPath)
+	}
+	return cfg.Config, nil
+}
+
+// alterEntityConfig sets and removes configs of an entity as `alter`
+// prescribes, a nil value removes the config, and notifies brokers of the
+// change. Other configs of the entity are preserved.
+func (a *T) alterEntityConfig(zkConn *zk.Conn, entityPath string, alter map[string]*string) error {
+	cfgPath := fmt.Sprintf("%s/config/%s", a.cfg.ZooKeeper.Chroot, entityPath)
+	for {
+		cfg := zkEntityConfig{Version: 1, Config: make(map[string]string)}
+		data, stat, err := zkConn.Get(cfgPath)
+		if err != nil && err != zk.ErrNoNode {
+			return errors.Wrapf(err, "failed to get config, path=%s", cfgPath)
+		}
+		if err == nil && len(data) > 0 {
+			if err := json.Unmarshal(data, &cfg); err != nil {
+				return errors.Wrapf(err, "failed to parse config, path=%s", cfgPath)
+			}
+			if cfg.Config == nil {
+				cfg.Config = make(map[string]string)
+			}
+		}
+		for key, value := range alter {
+			if value == nil {
+				delete(cfg.Config, key)
+				continue
+			}
+			cfg.Config[key] = *value
+		}
+		data, _ = json.Marshal(cfg)
+		if stat == nil {
+			err = a.zkCreateAll(zkConn, cfgPath, data)
+		} else {
+			_, err = zkConn.Set(cfgPath, data, stat.Version)
+		}
+		// The config was updated concurrently, so start over.
+		if err == zk.ErrNodeExists || err == zk.ErrBadVersion {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to set config, path=%s", cfgPath)
+		}
+		break
+	}
+	changeData, _ := json.Marshal(map[string]interface{}{"version": 2, "entity_path": entityPath})
+	changePath := fmt.Sprintf("%s/config/changes/config_change_", a.cfg.ZooKeeper.Chroot)
+	if _, err := zkConn.Create(changePath, changeData, zk.FlagSequence, zk.WorldACL(zk.PermAll)); err != nil {
+		return errors.Wrapf(err, "failed to notify of config change, entity=%s", entityPath)
+	}
+	return nil
+}
+
+// zkChildren returns children of a config node, or none if it does not exist.
+func (a *T) zkChildren(zkConn *zk.Conn, path string) ([]string, error) {
+	children, _, err := zkConn.Children(fmt.Sprintf("%s/config/%s", a.cfg.ZooKeeper.Chroot, path))
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %s", path)
+	}
+	return children, nil
+}
+
+// zkCreateAll creates a node along with all missing parents, the parents are
+// created with no data, the same way Kafka admin tools do.
+func (a *T) zkCreateAll(zkConn *zk.Conn, path string, data []byte) error {
+	_, err := zkConn.Create(path, data, 0, zk.WorldACL(zk.PermAll))
+	if err != zk.ErrNoNode {
+		return err
+	}
+	parent := path[:strings.LastIndex(path, "/")]
+	if err := a.zkCreateAll(zkConn, parent, nil); err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+	_, err = zkConn.Create(path, data, 0, zk.WorldACL(zk.PermAll))
+	return err
+}
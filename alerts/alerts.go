@@ -0,0 +1,299 @@
+package alerts
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+// Alert states.
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+// Alert describes a rule that fired or resolved.
+type Alert struct {
+	Rule    string `json:"rule"`
+	Kind    string `json:"kind"`
+	State   string `json:"state"`
+	Cluster string `json:"cluster"`
+	Group   string `json:"group"`
+	Topic   string `json:"topic"`
+
+	// The total lag for lag and stall rules, the number of unassigned
+	// partitions for unassigned rules, or the smallest retention headroom
+	// for retention rules.
+	Value     int64 `json:"value"`
+	Threshold int64 `json:"threshold,omitempty"`
+
+	// When the rule condition started to hold.
+	Since time.Time `json:"since"`
+	Time  time.Time `json:"time"`
+}
+
+// Source provides data that rules are evaluated against. It is implemented by
+// admin.T and inmem.T.
+type Source interface {
+	GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error)
+	GetTopicConsumers(group, topic string) (map[string][]int32, error)
+}
+
+// Notifier is implemented by destinations that alerts are sent to when they
+// fire and resolve.
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// T periodically evaluates alert rules of a cluster. A nil instance is valid,
+// it has no alerts firing.
+type T struct {
+	actorID   *actor.ID
+	cluster   string
+	source    Source
+	notifiers []Notifier
+	interval  time.Duration
+	rules     []*ruleState
+	metrics   *metrics.Registry
+	mu        sync.Mutex
+	firing    map[string]Alert
+	stopCh    chan none.T
+	wg        sync.WaitGroup
+}
+
+type ruleState struct {
+	rule         config.AlertRule
+	pendingSince time.Time
+	firing       bool
+
+	// Offsets committed by the group as of the previous check. It is only
+	// maintained for stall rules.
+	lastOffsets map[int32]int64
+}
+
+// Spawn starts evaluating alert rules of a cluster. It returns nil if there
+// are no rules configured.
+func Spawn(namespace *actor.ID, cluster string, cfg *config.Proxy, source Source, notifiers ...Notifier) *T {
+	return SpawnWithMetrics(namespace, cluster, cfg, source, nil, notifiers...)
+}
+
+// SpawnWithMetrics is like Spawn, but values that rules are decided on are
+// reported to `registry` by the `alerts.rule.value` gauge tagged with the
+// rule name.
+func SpawnWithMetrics(namespace *actor.ID, cluster string, cfg *config.Proxy, source Source,
+	registry *metrics.Registry, notifiers ...Notifier,
+) *T {
+	if len(cfg.Alerts.Rules) == 0 {
+		return nil
+	}
+	t := newT(namespace, cluster, cfg, source, notifiers)
+	t.metrics = registry
+	actor.Spawn(t.actorID, &t.wg, t.run)
+	return t
+}
+
+func newT(namespace *actor.ID, cluster string, cfg *config.Proxy, source Source, notifiers []Notifier) *T {
+	t := &T{
+		actorID:   namespace.NewChild("alerts"),
+		cluster:   cluster,
+		source:    source,
+		notifiers: notifiers,
+		interval:  cfg.Alerts.CheckInterval,
+		firing:    make(map[string]Alert),
+		stopCh:    make(chan none.T),
+	}
+	for _, rule := range cfg.Alerts.Rules {
+		t.rules = append(t.rules, &ruleState{rule: rule})
+	}
+	return t
+}
+
+// Firing returns alerts that are firing at the moment, ordered by rule name.
+func (t *T) Firing() []Alert {
+	alerts := []Alert{}
+	if t == nil {
+		return alerts
+	}
+	t.mu.Lock()
+	for _, alert := range t.firing {
+		alerts = append(alerts, alert)
+	}
+	t.mu.Unlock()
+	sort.Sort(byRule(alerts))
+	return alerts
+}
+
+type byRule []Alert
+
+func (a byRule) Len() int           { return len(a) }
+func (a byRule) Less(i, j int) bool { return a[i].Rule < a[j].Rule }
+func (a byRule) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Stop stops evaluating rules.
+func (t *T) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+func (t *T) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.check(now)
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// check evaluates all rules and notifies about alerts that fired or resolved.
+func (t *T) check(now time.Time) {
+	for _, rs := range t.rules {
+		cond, value, err := rs.evaluate(t.source)
+		if err != nil {
+			log.Errorf("<%s> failed to evaluate rule %s: err=(%s)", t.actorID, rs.rule.Name, err)
+			continue
+		}
+		t.metrics.Gauge("alerts.rule.value", "rule", rs.rule.Name).Update(value)
+		alert := rs.transition(now, cond)
+		if alert == nil {
+			continue
+		}
+		alert.Cluster = t.cluster
+		alert.Value = value
+		alert.Time = now.UTC()
+		t.mu.Lock()
+		if alert.State == Firing {
+			t.firing[alert.Rule] = *alert
+		} else {
+			delete(t.firing, alert.Rule)
+		}
+		t.mu.Unlock()
+		log.Warningf("<%s> alert %s: rule=%s, group=%s, topic=%s, value=%d",
+			t.actorID, alert.State, alert.Rule, alert.Group, alert.Topic, alert.Value)
+		for _, notifier := range t.notifiers {
+			if err := notifier.Notify(*alert); err != nil {
+				log.Errorf("<%s> failed to notify about alert %s: err=(%s)", t.actorID, alert.Rule, err)
+			}
+		}
+	}
+}
+
+// evaluate tells whether the rule condition holds at the moment, along with
+// the value that it was decided on.
+func (rs *ruleState) evaluate(source Source) (bool, int64, error) {
+	offsets, err := source.GetGroupOffsets(rs.rule.Group, rs.rule.Topic)
+	if err != nil {
+		return false, 0, err
+	}
+	var lag int64
+	for i := range offsets {
+		lag += offsets[i].Lag()
+	}
+	switch rs.rule.Kind {
+	case config.AlertKindLag:
+		return lag > rs.rule.Threshold, lag, nil
+
+	case config.AlertKindStall:
+		committed := make(map[int32]int64, len(offsets))
+		for _, po := range offsets {
+			committed[po.Partition] = po.Offset
+		}
+		// Nothing is known about commits until the second check.
+		stalled := rs.lastOffsets != nil && lag > 0
+		for partition, offset := range committed {
+			if rs.lastOffsets[partition] != offset {
+				stalled = false
+			}
+		}
+		rs.lastOffsets = committed
+		return stalled, lag, nil
+
+	case config.AlertKindUnassigned:
+		consumers, err := source.GetTopicConsumers(rs.rule.Group, rs.rule.Topic)
+		if err != nil {
+			return false, 0, err
+		}
+		assigned := make(map[int32]bool)
+		for _, partitions := range consumers {
+			for _, partition := range partitions {
+				assigned[partition] = true
+			}
+		}
+		var unassigned int64
+		for _, po := range offsets {
+			if !assigned[po.Partition] {
+				unassigned++
+			}
+		}
+		return unassigned > 0, unassigned, nil
+
+	case config.AlertKindRetention:
+		// Headroom is how many more messages retention can remove from the
+		// beginning of a partition before the group misses some. It is
+		// negative if the group has missed some already. Partitions that
+		// the group has committed nothing to or has consumed up to the end
+		// cannot miss anything.
+		var headroom int64
+		found := false
+		for i := range offsets {
+			po := &offsets[i]
+			if po.Offset < 0 || po.Lag() <= 0 {
+				continue
+			}
+			if h := po.Offset - po.Begin; !found || h < headroom {
+				headroom, found = h, true
+			}
+		}
+		return found && headroom < rs.rule.Threshold, headroom, nil
+	}
+	return false, 0, nil
+}
+
+// transition updates the rule state with the result of a check, and returns
+// an alert if the rule fired or resolved as a result.
+func (rs *ruleState) transition(now time.Time, cond bool) *Alert {
+	if !cond {
+		since, firing := rs.pendingSince, rs.firing
+		rs.pendingSince, rs.firing = time.Time{}, false
+		if !firing {
+			return nil
+		}
+		return rs.newAlert(Resolved, since)
+	}
+	if rs.pendingSince.IsZero() {
+		rs.pendingSince = now
+	}
+	if rs.firing || now.Sub(rs.pendingSince) < rs.rule.For {
+		return nil
+	}
+	rs.firing = true
+	return rs.newAlert(Firing, rs.pendingSince)
+}
+
+func (rs *ruleState) newAlert(state string, since time.Time) *Alert {
+	alert := &Alert{
+		Rule:  rs.rule.Name,
+		Kind:  rs.rule.Kind,
+		State: state,
+		Group: rs.rule.Group,
+		Topic: rs.rule.Topic,
+		Since: since.UTC(),
+	}
+	if rs.rule.Kind == config.AlertKindLag || rs.rule.Kind == config.AlertKindRetention {
+		alert.Threshold = rs.rule.Threshold
+	}
+	return alert
+}
@@ -0,0 +1,285 @@
+package alerts
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type AlertsSuite struct {
+	cfg    *config.Proxy
+	source *testSource
+	notes  *testNotifier
+	now    time.Time
+}
+
+var _ = Suite(&AlertsSuite{})
+
+type testSource struct {
+	offsets   []admin.PartitionOffset
+	consumers map[string][]int32
+}
+
+func (ts *testSource) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error) {
+	return ts.offsets, nil
+}
+
+func (ts *testSource) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
+	return ts.consumers, nil
+}
+
+type testNotifier struct {
+	alerts []Alert
+}
+
+func (tn *testNotifier) Notify(alert Alert) error {
+	tn.alerts = append(tn.alerts, alert)
+	return nil
+}
+
+func (tn *testNotifier) states() []string {
+	var states []string
+	for _, alert := range tn.alerts {
+		states = append(states, alert.State)
+	}
+	return states
+}
+
+func (s *AlertsSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.source = &testSource{offsets: []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 100, Offset: 100},
+		{Partition: 1, Begin: 0, End: 100, Offset: 100},
+	}}
+	s.notes = &testNotifier{}
+	s.now = time.Date(2017, 3, 20, 10, 0, 0, 0, time.UTC)
+}
+
+func (s *AlertsSuite) newT(rule config.AlertRule) *T {
+	rule.Name, rule.Group, rule.Topic = "r1", "foo", "bar"
+	s.cfg.Alerts.Rules = []config.AlertRule{rule}
+	return newT(actor.RootID, "default", s.cfg, s.source, []Notifier{s.notes})
+}
+
+func (s *AlertsSuite) check(t *T, after time.Duration) {
+	s.now = s.now.Add(after)
+	t.check(s.now)
+}
+
+// A lag rule fires when the lag stays above the threshold long enough, and
+// resolves when it gets back.
+func (s *AlertsSuite) TestLag(c *C) {
+	t := s.newT(config.AlertRule{Kind: config.AlertKindLag, Threshold: 50, For: time.Minute})
+	s.check(t, 0)
+	s.source.offsets[0].Offset = 20
+
+	// When
+	s.check(t, 30*time.Second)
+	s.check(t, 30*time.Second)
+	c.Assert(s.notes.alerts, HasLen, 0)
+	s.check(t, 30*time.Second)
+
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing})
+	c.Assert(s.notes.alerts[0], DeepEquals, Alert{
+		Rule:      "r1",
+		Kind:      config.AlertKindLag,
+		State:     Firing,
+		Cluster:   "default",
+		Group:     "foo",
+		Topic:     "bar",
+		Value:     80,
+		Threshold: 50,
+		Since:     time.Date(2017, 3, 20, 10, 0, 30, 0, time.UTC),
+		Time:      time.Date(2017, 3, 20, 10, 1, 30, 0, time.UTC),
+	})
+	c.Assert(t.Firing(), DeepEquals, s.notes.alerts)
+
+	// When
+	s.check(t, 30*time.Second)
+	s.source.offsets[0].Offset = 90
+	s.check(t, 30*time.Second)
+
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing, Resolved})
+	c.Assert(s.notes.alerts[1].Value, Equals, int64(10))
+	c.Assert(t.Firing(), DeepEquals, []Alert{})
+}
+
+// A lag rule does not fire if the lag drops before the rule duration passes.
+func (s *AlertsSuite) TestLagShortSpike(c *C) {
+	t := s.newT(config.AlertRule{Kind: config.AlertKindLag, Threshold: 50, For: time.Minute})
+	s.source.offsets[0].Offset = 20
+	s.check(t, 0)
+	s.check(t, 30*time.Second)
+	s.source.offsets[0].Offset = 90
+
+	// When
+	s.check(t, 30*time.Second)
+	s.source.offsets[0].Offset = 20
+	s.check(t, 30*time.Second)
+	s.check(t, 30*time.Second)
+
+	// Then
+	c.Assert(s.notes.alerts, HasLen, 0)
+}
+
+// A stall rule fires when the group has lag, but commits nothing.
+func (s *AlertsSuite) TestStall(c *C) {
+	t := s.newT(config.AlertRule{Kind: config.AlertKindStall, For: time.Minute})
+	s.source.offsets[1].Offset = 40
+	s.check(t, 0)
+
+	// When: commits keep coming.
+	for i := 0; i < 4; i++ {
+		s.source.offsets[1].Offset++
+		s.check(t, 30*time.Second)
+	}
+	// Then
+	c.Assert(s.notes.alerts, HasLen, 0)
+
+	// When: commits stop.
+	s.check(t, 30*time.Second)
+	s.check(t, 30*time.Second)
+	s.check(t, 30*time.Second)
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing})
+	c.Assert(s.notes.alerts[0].Value, Equals, int64(56))
+
+	// When: commits resume.
+	s.source.offsets[1].Offset++
+	s.check(t, 30*time.Second)
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing, Resolved})
+}
+
+// A group that has no lag is idle, rather than stalled.
+func (s *AlertsSuite) TestStallNoLag(c *C) {
+	t := s.newT(config.AlertRule{Kind: config.AlertKindStall})
+	s.source.offsets[0].Offset = sarama.OffsetNewest
+
+	// When
+	for i := 0; i < 4; i++ {
+		s.check(t, 30*time.Second)
+	}
+
+	// Then
+	c.Assert(s.notes.alerts, HasLen, 0)
+}
+
+// An unassigned rule fires when some partitions have no consumer.
+func (s *AlertsSuite) TestUnassigned(c *C) {
+	t := s.newT(config.AlertRule{Kind: config.AlertKindUnassigned})
+	s.source.consumers = map[string][]int32{"a": {0}, "b": {1}}
+	s.check(t, 0)
+	c.Assert(s.notes.alerts, HasLen, 0)
+
+	// When
+	delete(s.source.consumers, "b")
+	s.check(t, 30*time.Second)
+
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing})
+	c.Assert(s.notes.alerts[0].Value, Equals, int64(1))
+}
+
+// A retention rule fires when the committed offset of a lagging partition gets
+// too close to the oldest retained message, and the headroom is reported in
+// metrics. Partitions consumed up to the end are ignored.
+func (s *AlertsSuite) TestRetention(c *C) {
+	t := s.newT(config.AlertRule{Kind: config.AlertKindRetention, Threshold: 10})
+	t.metrics = metrics.New()
+	s.source.offsets[0] = admin.PartitionOffset{Partition: 0, Begin: 50, End: 100, Offset: 70}
+	s.source.offsets[1] = admin.PartitionOffset{Partition: 1, Begin: 100, End: 100, Offset: 100}
+	s.check(t, 0)
+	c.Assert(s.notes.alerts, HasLen, 0)
+
+	// When
+	s.source.offsets[0].Begin = 65
+	s.check(t, 30*time.Second)
+
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing})
+	c.Assert(s.notes.alerts[0].Value, Equals, int64(5))
+	c.Assert(s.notes.alerts[0].Threshold, Equals, int64(10))
+	c.Assert(t.metrics.Gauge("alerts.rule.value", "rule", "r1").Value(), Equals, int64(5))
+
+	// When
+	s.source.offsets[0].Offset = 100
+	s.check(t, 30*time.Second)
+
+	// Then
+	c.Assert(s.notes.states(), DeepEquals, []string{Firing, Resolved})
+}
+
+// Alert rules are only evaluated if there are any.
+func (s *AlertsSuite) TestNoRules(c *C) {
+	t := Spawn(actor.RootID, "default", s.cfg, s.source)
+	c.Assert(t, IsNil)
+	c.Assert(t.Firing(), DeepEquals, []Alert{})
+	t.Stop()
+}
+
+func (s *AlertsSuite) TestWebhook(c *C) {
+	var received []Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var alert Alert
+		c.Check(json.Unmarshal(body, &alert), IsNil)
+		received = append(received, alert)
+	}))
+	defer srv.Close()
+	wh := NewWebhook(srv.URL, time.Second)
+
+	// When
+	err := wh.Notify(Alert{Rule: "r1", State: Firing, Value: 3})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(received, DeepEquals, []Alert{{Rule: "r1", State: Firing, Value: 3}})
+}
+
+func (s *AlertsSuite) TestWebhookFailed(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	wh := NewWebhook(srv.URL, time.Second)
+
+	// When
+	err := wh.Notify(Alert{Rule: "r1"})
+
+	// Then
+	c.Assert(err, ErrorMatches, "webhook failed, status=502")
+}
+
+func (s *AlertsSuite) TestTopic(c *C) {
+	var produced []string
+	tn := NewTopic("alerts", func(topic string, key, message sarama.Encoder) {
+		keyBytes, _ := key.Encode()
+		messageBytes, _ := message.Encode()
+		produced = append(produced, topic, string(keyBytes), string(messageBytes))
+	})
+
+	// When
+	err := tn.Notify(Alert{Rule: "r1", State: Resolved})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(produced[:2], DeepEquals, []string{"alerts", "r1"})
+	c.Assert(produced[2], Matches, `\{"rule":"r1",.*"state":"resolved".*\}`)
+}
@@ -0,0 +1,61 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// Webhook posts alerts as JSON to a URL.
+type Webhook struct {
+	url     string
+	httpClt *http.Client
+}
+
+// NewWebhook creates a notifier that posts alerts to `url`.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, httpClt: &http.Client{Timeout: timeout}}
+}
+
+// Notify implements Notifier.
+func (wh *Webhook) Notify(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal alert")
+	}
+	rs, err := wh.httpClt.Post(wh.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to post alert")
+	}
+	rs.Body.Close()
+	if rs.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("webhook failed, status=%d", rs.StatusCode)
+	}
+	return nil
+}
+
+// Topic produces alerts as JSON to a Kafka topic, keyed by rule name so that
+// notifications about an alert are kept in order.
+type Topic struct {
+	topic   string
+	produce func(topic string, key, message sarama.Encoder)
+}
+
+// NewTopic creates a notifier that produces alerts to `topic` with `produce`.
+func NewTopic(topic string, produce func(topic string, key, message sarama.Encoder)) *Topic {
+	return &Topic{topic: topic, produce: produce}
+}
+
+// Notify implements Notifier.
+func (t *Topic) Notify(alert Alert) error {
+	message, err := json.Marshal(alert)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal alert")
+	}
+	t.produce(t.topic, sarama.StringEncoder(alert.Rule), sarama.ByteEncoder(message))
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package chaos implements fault injection into request processing paths of a
+// proxy. It is intended for resilience testing of client applications in
+// staging environments and should never be enabled in production.
+package chaos
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Operations that faults can be injected into.
+	OpProduce = "produce"
+	OpConsume = "consume"
+	OpAck     = "ack"
+	OpCommit  = "commit"
+)
+
+var (
+	// ErrInjected is returned by operations that failed due to an injected
+	// fault.
+	ErrInjected = errors.New("injected fault")
+
+	ops = []string{OpProduce, OpConsume, OpAck, OpCommit}
+)
+
+// Fault defines a fault injected into an operation.
+type Fault struct {
+	// Probability in range [0, 1] of an operation to fail with ErrInjected.
+	ErrorRate float64
+
+	// Delay introduced into every operation.
+	Latency time.Duration
+}
+
+// T keeps faults injected into operations. A nil instance is valid and never
+// injects anything, so it can be used when fault injection is disabled.
+type T struct {
+	mu     sync.Mutex
+	faults map[string]Fault
+	rand   *rand.Rand
+}
+
+// New creates a fault injector instance with no faults injected.
+func New() *T {
+	return &T{
+		faults: make(map[string]Fault),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Set injects a fault into the specified operation replacing the previously
+// injected one, if any.
+func (ch *T) Set(op string, fault Fault) error {
+	if !isValidOp(op) {
+		return errors.Errorf("invalid operation: %s", op)
+	}
+	if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+		return errors.Errorf("error rate must be in range [0, 1]: %v", fault.ErrorRate)
+	}
+	if fault.Latency < 0 {
+		return errors.Errorf("latency must be >= 0: %v", fault.Latency)
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.faults[op] = fault
+	return nil
+}
+
+// Reset removes faults from all operations.
+func (ch *T) Reset() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.faults = make(map[string]Fault)
+}
+
+// Faults returns operation -> fault mapping of currently injected faults.
+func (ch *T) Faults() map[string]Fault {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	faults := make(map[string]Fault, len(ch.faults))
+	for op, fault := range ch.faults {
+		faults[op] = fault
+	}
+	return faults
+}
+
+// Inject should be called at the beginning of the specified operation. It
+// blocks for the configured latency, and then returns ErrInjected if the
+// operation should fail.
+func (ch *T) Inject(op string) error {
+	if ch == nil {
+		return nil
+	}
+	ch.mu.Lock()
+	fault, ok := ch.faults[op]
+	fail := ok && ch.rand.Float64() < fault.ErrorRate
+	ch.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if fault.Latency > 0 {
+		time.Sleep(fault.Latency)
+	}
+	if fail {
+		return ErrInjected
+	}
+	return nil
+}
+
+func isValidOp(op string) bool {
+	for _, validOp := range ops {
+		if op == validOp {
+			return true
+		}
+	}
+	return false
+}
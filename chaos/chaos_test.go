@@ -0,0 +1,55 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ChaosSuite struct{}
+
+var _ = Suite(&ChaosSuite{})
+
+// A nil fault injector never injects anything.
+func (s *ChaosSuite) TestNil(c *C) {
+	var ch *T
+	c.Assert(ch.Inject(OpProduce), IsNil)
+}
+
+func (s *ChaosSuite) TestInject(c *C) {
+	ch := New()
+	c.Assert(ch.Set(OpConsume, Fault{ErrorRate: 1}), IsNil)
+	c.Assert(ch.Set(OpAck, Fault{ErrorRate: 0, Latency: 50 * time.Millisecond}), IsNil)
+
+	// When/Then
+	c.Assert(ch.Inject(OpProduce), IsNil)
+	c.Assert(ch.Inject(OpConsume), Equals, ErrInjected)
+	begin := time.Now()
+	c.Assert(ch.Inject(OpAck), IsNil)
+	c.Assert(time.Now().Sub(begin) >= 50*time.Millisecond, Equals, true)
+}
+
+func (s *ChaosSuite) TestReset(c *C) {
+	ch := New()
+	c.Assert(ch.Set(OpCommit, Fault{ErrorRate: 1}), IsNil)
+	c.Assert(ch.Faults(), DeepEquals, map[string]Fault{OpCommit: {ErrorRate: 1}})
+
+	// When
+	ch.Reset()
+
+	// Then
+	c.Assert(ch.Inject(OpCommit), IsNil)
+	c.Assert(ch.Faults(), DeepEquals, map[string]Fault{})
+}
+
+func (s *ChaosSuite) TestSetInvalid(c *C) {
+	ch := New()
+	c.Assert(ch.Set("foo", Fault{}), ErrorMatches, "invalid operation: foo")
+	c.Assert(ch.Set(OpProduce, Fault{ErrorRate: 1.5}), ErrorMatches, "error rate must be in range \\[0, 1\\]: 1.5")
+	c.Assert(ch.Set(OpProduce, Fault{Latency: -1}), ErrorMatches, "latency must be >= 0: -1ns")
+}
@@ -0,0 +1,388 @@
+// Package kafkapixy provides a Go client for the Kafka-Pixy gRPC API. It takes
+// care of acknowledging consumed messages, retrying failed requests with
+// backoff, and managing the underlying gRPC connection.
+package kafkapixy
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrClosed is returned by Consumer methods called after the consumer has
+	// been closed.
+	ErrClosed = errors.New("consumer closed")
+
+	// ErrFlushTimeout is returned by Flush if some messages were neither
+	// written to Kafka nor failed by the timeout.
+	ErrFlushTimeout = errors.New("flush timeout")
+
+	// ErrNoMessage is returned by Nack if there is no message returned by
+	// Next that is not acknowledged yet.
+	ErrNoMessage = errors.New("no message to nack")
+)
+
+// Config defines configuration of a Kafka-Pixy client.
+type Config struct {
+	// Address of a Kafka-Pixy gRPC server, e.g. "localhost:19091".
+	Addr string
+
+	// Name of a Kafka cluster to operate on. If empty then the default
+	// cluster of the Kafka-Pixy instance is used.
+	Cluster string
+
+	// How long to wait before retrying a request that failed with a
+	// retriable error.
+	RetryBackoff time.Duration
+
+	// Maximum delay between retries. The backoff is doubled on each
+	// consecutive failure until it reaches this value.
+	MaxRetryBackoff time.Duration
+
+	// The total number of times to retry a request that failed with a
+	// retriable error. Zero means retry until the context is done.
+	RetryMax int
+
+	// Additional options to be passed to grpc.Dial.
+	DialOpts []grpc.DialOption
+}
+
+// DefaultConfig returns a client config with the specified Kafka-Pixy address
+// and sane default values of all other parameters.
+func DefaultConfig(addr string) *Config {
+	return &Config{
+		Addr:            addr,
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 5 * time.Second,
+		DialOpts:        []grpc.DialOption{grpc.WithInsecure()},
+	}
+}
+
+// T is a Kafka-Pixy client. It wraps the gRPC API making it more convenient
+// to use, and it is safe for concurrent use by multiple goroutines.
+type T struct {
+	cfg  Config
+	conn *grpc.ClientConn
+	clt  pb.KafkaPixyClient
+}
+
+// Message represents a message consumed from Kafka via Kafka-Pixy.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+
+	// Key is nil if the message was produced without a key.
+	Key   []byte
+	Value []byte
+}
+
+// New creates a Kafka-Pixy client connected to the gRPC server at the
+// configured address. Note that gRPC connects lazily, so an error is only
+// returned if the config is invalid.
+func New(cfg *Config) (*T, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("address must be provided")
+	}
+	conn, err := grpc.Dial(cfg.Addr, cfg.DialOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial gRPC server")
+	}
+	return newWithConn(cfg, conn), nil
+}
+
+func newWithConn(cfg *Config, conn *grpc.ClientConn) *T {
+	return &T{
+		cfg:  *cfg,
+		conn: conn,
+		clt:  pb.NewKafkaPixyClient(conn),
+	}
+}
+
+// Close releases the underlying gRPC connection. Consumers created by the
+// client should be closed first to make sure that their last consumed
+// messages are acknowledged.
+func (c *T) Close() error {
+	return c.conn.Close()
+}
+
+// Produce writes a message to a Kafka topic and returns the partition and the
+// offset that the message was written to. If key is nil then the message is
+// written to a random partition.
+func (c *T) Produce(ctx context.Context, topic string, key, value []byte) (int32, int64, error) {
+	req := c.newProdRq(topic, key, value)
+	var res *pb.ProdRs
+	err := c.retry(ctx, false, func() error {
+		var err error
+		res, err = c.clt.Produce(ctx, req, grpc.FailFast(false))
+		return err
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+	return res.Partition, res.Offset, nil
+}
+
+// AsyncProduce submits a message to Kafka-Pixy and returns as soon as it is
+// accepted. The message is written to Kafka asynchronously.
+func (c *T) AsyncProduce(ctx context.Context, topic string, key, value []byte) error {
+	req := c.newProdRq(topic, key, value)
+	req.AsyncMode = true
+	return c.retry(ctx, false, func() error {
+		_, err := c.clt.Produce(ctx, req, grpc.FailFast(false))
+		return err
+	})
+}
+
+// Flush waits for all messages produced via Kafka-Pixy by the time of the
+// call, including asynchronously produced ones, to be either written to Kafka
+// or failed, but no longer than `timeout`, zero standing for the Kafka-Pixy
+// default. It returns the numbers of messages written and failed, and
+// ErrFlushTimeout if some were neither by the timeout.
+func (c *T) Flush(ctx context.Context, timeout time.Duration) (int64, int64, error) {
+	req := pb.FlushRq{Cluster: c.cfg.Cluster, TimeoutMs: int64(timeout / time.Millisecond)}
+	var res *pb.FlushRs
+	err := c.retry(ctx, false, func() error {
+		var err error
+		res, err = c.clt.Flush(ctx, &req, grpc.FailFast(false))
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if res.Pending > 0 {
+		return res.Acked, res.Failed, errors.Wrapf(ErrFlushTimeout, "pending=%d", res.Pending)
+	}
+	return res.Acked, res.Failed, nil
+}
+
+func (c *T) newProdRq(topic string, key, value []byte) *pb.ProdRq {
+	req := pb.ProdRq{
+		Cluster: c.cfg.Cluster,
+		Topic:   topic,
+		Message: value,
+	}
+	if key == nil {
+		req.KeyUndefined = true
+	} else {
+		req.KeyValue = key
+	}
+	return &req
+}
+
+// NewConsumer creates a consumer of the specified topic on behalf of the
+// specified consumer group.
+func (c *T) NewConsumer(group, topic string) *Consumer {
+	return &Consumer{
+		clt:   c,
+		group: group,
+		topic: topic,
+	}
+}
+
+// Consumer reads messages from a topic on behalf of a consumer group. A
+// message returned by Next is considered processed, and is therefore
+// acknowledged, when Next is called again or when the consumer is closed. The
+// acknowledgement is piggybacked on the next consume request, so in a steady
+// state there is only one round trip per message.
+//
+// A consumer is safe for concurrent use, but messages returned to different
+// goroutines are acknowledged in the order the goroutines call Next rather
+// than the order they finish processing. Use one consumer per goroutine if
+// that is not acceptable.
+type Consumer struct {
+	clt   *T
+	group string
+	topic string
+
+	mu      sync.Mutex
+	pending *Message
+	closed  bool
+}
+
+// Next acknowledges the message returned by the previous call, if any, and
+// blocks until the next message is available or ctx is done. Long polling
+// timeouts and temporary unavailability of Kafka-Pixy are retried
+// transparently.
+func (cs *Consumer) Next(ctx context.Context) (Message, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return Message{}, ErrClosed
+	}
+	var res *pb.ConsRs
+	err := cs.clt.retry(ctx, true, func() error {
+		req := cs.newConsNAckRq()
+		var err error
+		res, err = cs.clt.clt.ConsumeNAck(ctx, req, grpc.FailFast(false))
+		if ackDelivered(err) {
+			cs.pending = nil
+		}
+		return err
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		Topic:     cs.topic,
+		Partition: res.Partition,
+		Offset:    res.Offset,
+		Value:     res.Message,
+	}
+	if !res.KeyUndefined {
+		msg.Key = res.KeyValue
+	}
+	cs.pending = &msg
+	return msg, nil
+}
+
+// Close acknowledges the message returned by the last call to Next, if any.
+// It does not close the parent client.
+func (cs *Consumer) Close(ctx context.Context) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return nil
+	}
+	cs.closed = true
+	if cs.pending == nil {
+		return nil
+	}
+	req := pb.AckRq{
+		Cluster:   cs.clt.cfg.Cluster,
+		Topic:     cs.topic,
+		Group:     cs.group,
+		Partition: cs.pending.Partition,
+		Offset:    cs.pending.Offset,
+	}
+	err := cs.clt.retry(ctx, true, func() error {
+		_, err := cs.clt.clt.Ack(ctx, &req, grpc.FailFast(false))
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to ack, partition=%d, offset=%d",
+			req.Partition, req.Offset)
+	}
+	cs.pending = nil
+	return nil
+}
+
+// Nack negatively acknowledges the message returned by the last call to Next,
+// that is tells Kafka-Pixy that it could not be processed and why, so that it
+// is not acknowledged by the following call to Next or by Close. The reason
+// is only logged by Kafka-Pixy.
+func (cs *Consumer) Nack(ctx context.Context, class pb.NackRq_ErrorClass, reason string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return ErrClosed
+	}
+	if cs.pending == nil {
+		return ErrNoMessage
+	}
+	req := pb.NackRq{
+		Cluster:    cs.clt.cfg.Cluster,
+		Topic:      cs.topic,
+		Group:      cs.group,
+		Partition:  cs.pending.Partition,
+		Offset:     cs.pending.Offset,
+		ErrorClass: class,
+		Reason:     reason,
+	}
+	// A fatal nack that failed with an internal error may have been produced
+	// to the dead letter topic already, so it is not retried to avoid a
+	// duplicate there.
+	err := cs.clt.retry(ctx, class != pb.NackRq_FATAL, func() error {
+		_, err := cs.clt.clt.Nack(ctx, &req, grpc.FailFast(false))
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to nack, partition=%d, offset=%d",
+			req.Partition, req.Offset)
+	}
+	cs.pending = nil
+	return nil
+}
+
+func (cs *Consumer) newConsNAckRq() *pb.ConsNAckRq {
+	req := pb.ConsNAckRq{
+		Cluster: cs.clt.cfg.Cluster,
+		Topic:   cs.topic,
+		Group:   cs.group,
+	}
+	if cs.pending == nil {
+		req.NoAck = true
+	} else {
+		req.AckPartition = cs.pending.Partition
+		req.AckOffset = cs.pending.Offset
+	}
+	return &req
+}
+
+// retry calls fn until it either succeeds, fails with a non retriable error,
+// the retry limit is reached, or ctx is done. Long polling timeouts are not
+// counted as failures. Internal errors are only retried if fn is idempotent,
+// for a request that failed with one may have taken effect, e.g. a message
+// may have been written to Kafka.
+func (c *T) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	backoff := c.cfg.RetryBackoff
+	for retries := 0; ; {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		code := grpc.Code(err)
+		if code == codes.NotFound {
+			// Long polling timeout, there were no messages to consume.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		if !isRetriable(code, idempotent) {
+			return err
+		}
+		if retries++; c.cfg.RetryMax > 0 && retries > c.cfg.RetryMax {
+			return errors.Wrapf(err, "too many retries: %d", c.cfg.RetryMax)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; c.cfg.MaxRetryBackoff > 0 && backoff > c.cfg.MaxRetryBackoff {
+			backoff = c.cfg.MaxRetryBackoff
+		}
+	}
+}
+
+func isRetriable(code codes.Code, idempotent bool) bool {
+	switch code {
+	case codes.ResourceExhausted, codes.Unavailable:
+		return true
+	case codes.Internal:
+		return idempotent
+	}
+	return false
+}
+
+// ackDelivered tells whether Kafka-Pixy has accepted the ack piggybacked on a
+// ConsumeNAck request that completed with the specified error. Kafka-Pixy
+// submits an ack before it starts waiting for a message, so it is accepted
+// unless the request was rejected as invalid or never reached the server.
+func ackDelivered(err error) bool {
+	if err == nil {
+		return true
+	}
+	switch grpc.Code(err) {
+	case codes.InvalidArgument, codes.Unavailable, codes.Canceled, codes.DeadlineExceeded:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,306 @@
+package kafkapixy
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ClientSuite struct {
+	srv     *fakeServer
+	grpcSrv *grpc.Server
+	clt     *T
+}
+
+var _ = Suite(&ClientSuite{})
+
+func (s *ClientSuite) SetUpTest(c *C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.srv = &fakeServer{}
+	s.grpcSrv = grpc.NewServer()
+	pb.RegisterKafkaPixyServer(s.grpcSrv, s.srv)
+	go s.grpcSrv.Serve(listener)
+
+	cfg := DefaultConfig(listener.Addr().String())
+	cfg.RetryBackoff = time.Millisecond
+	s.clt, err = New(cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *ClientSuite) TearDownTest(c *C) {
+	s.clt.Close()
+	s.grpcSrv.Stop()
+}
+
+// A message returned by Next is acknowledged by the following Next call, and
+// the last one is acknowledged on Close.
+func (s *ClientSuite) TestNextAcksPrevious(c *C) {
+	s.srv.messages = []*pb.ConsRs{
+		{Partition: 1, Offset: 10, Message: []byte("m1"), KeyUndefined: true},
+		{Partition: 2, Offset: 20, Message: []byte("m2"), KeyValue: []byte("k2")},
+	}
+	ctx := context.Background()
+	cs := s.clt.NewConsumer("g1", "t1")
+
+	// When
+	msg1, err := cs.Next(ctx)
+	c.Assert(err, IsNil)
+	msg2, err := cs.Next(ctx)
+	c.Assert(err, IsNil)
+	err = cs.Close(ctx)
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Assert(msg1, DeepEquals, Message{Topic: "t1", Partition: 1, Offset: 10, Value: []byte("m1")})
+	c.Assert(msg2, DeepEquals, Message{Topic: "t1", Partition: 2, Offset: 20, Key: []byte("k2"), Value: []byte("m2")})
+	c.Assert(s.srv.consReqs[0].NoAck, Equals, true)
+	c.Assert(s.srv.consReqs[1].NoAck, Equals, false)
+	c.Assert(s.srv.consReqs[1].AckPartition, Equals, int32(1))
+	c.Assert(s.srv.consReqs[1].AckOffset, Equals, int64(10))
+	c.Assert(len(s.srv.ackReqs), Equals, 1)
+	c.Assert(s.srv.ackReqs[0].Partition, Equals, int32(2))
+	c.Assert(s.srv.ackReqs[0].Offset, Equals, int64(20))
+}
+
+// Long polling timeouts and retriable errors do not make Next fail, and the
+// pending ack is not resent once it has been accepted.
+func (s *ClientSuite) TestNextRetries(c *C) {
+	s.srv.messages = []*pb.ConsRs{
+		{Partition: 1, Offset: 10},
+		{Partition: 1, Offset: 11},
+	}
+	ctx := context.Background()
+	cs := s.clt.NewConsumer("g1", "t1")
+	_, err := cs.Next(ctx)
+	c.Assert(err, IsNil)
+	s.srv.errors = []error{
+		grpc.Errorf(codes.NotFound, "long polling timeout"),
+		grpc.Errorf(codes.ResourceExhausted, "too many requests"),
+	}
+
+	// When
+	msg, err := cs.Next(ctx)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(msg.Offset, Equals, int64(11))
+	c.Assert(len(s.srv.consReqs), Equals, 4)
+	c.Assert(s.srv.consReqs[1].NoAck, Equals, false)
+	c.Assert(s.srv.consReqs[2].NoAck, Equals, true)
+	c.Assert(s.srv.consReqs[3].NoAck, Equals, true)
+}
+
+// Non retriable errors are returned to the caller as is.
+func (s *ClientSuite) TestNextInvalidArgument(c *C) {
+	s.srv.errors = []error{grpc.Errorf(codes.InvalidArgument, "proxy `foo` does not exist")}
+	cs := s.clt.NewConsumer("g1", "t1")
+
+	// When
+	_, err := cs.Next(context.Background())
+
+	// Then
+	c.Assert(grpc.Code(err), Equals, codes.InvalidArgument)
+	c.Assert(len(s.srv.consReqs), Equals, 1)
+}
+
+// If the retry limit is reached then the last error is returned.
+func (s *ClientSuite) TestProduceRetryMax(c *C) {
+	s.clt.cfg.RetryMax = 2
+	unavailable := grpc.Errorf(codes.Unavailable, "unavailable")
+	s.srv.errors = []error{unavailable, unavailable, unavailable}
+
+	// When
+	_, _, err := s.clt.Produce(context.Background(), "t1", nil, []byte("m"))
+
+	// Then
+	c.Assert(err, ErrorMatches, "too many retries: 2: rpc error: .*")
+	c.Assert(len(s.srv.prodReqs), Equals, 3)
+	c.Assert(s.srv.prodReqs[0].KeyUndefined, Equals, true)
+}
+
+// A produce request that failed with an internal error may have been written
+// to Kafka, so it is not retried.
+func (s *ClientSuite) TestProduceInternal(c *C) {
+	s.srv.errors = []error{grpc.Errorf(codes.Internal, "internal error")}
+
+	// When
+	_, _, err := s.clt.Produce(context.Background(), "t1", nil, []byte("m"))
+
+	// Then
+	c.Assert(grpc.Code(err), Equals, codes.Internal)
+	c.Assert(len(s.srv.prodReqs), Equals, 1)
+}
+
+// Internal errors are retried for idempotent requests, but not for fatal
+// nacks, that may have produced the message to the dead letter topic.
+func (s *ClientSuite) TestNackInternal(c *C) {
+	for i, tc := range []struct {
+		class    pb.NackRq_ErrorClass
+		attempts int
+		err      codes.Code
+	}{
+		{pb.NackRq_RETRYABLE, 2, codes.OK},
+		{pb.NackRq_THROTTLE, 2, codes.OK},
+		{pb.NackRq_FATAL, 1, codes.Internal},
+	} {
+		s.srv.messages = []*pb.ConsRs{{Partition: 1, Offset: 10}}
+		s.srv.nackReqs = nil
+		ctx := context.Background()
+		cs := s.clt.NewConsumer("g1", "t1")
+		_, err := cs.Next(ctx)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		s.srv.errors = []error{grpc.Errorf(codes.Internal, "internal error")}
+
+		// When
+		err = cs.Nack(ctx, tc.class, "")
+
+		// Then
+		c.Assert(grpc.Code(errors.Cause(err)), Equals, tc.err, Commentf("case #%d", i))
+		c.Assert(len(s.srv.nackReqs), Equals, tc.attempts, Commentf("case #%d", i))
+	}
+}
+
+// A nacked message is not acknowledged by the following Next call.
+func (s *ClientSuite) TestNack(c *C) {
+	s.srv.messages = []*pb.ConsRs{
+		{Partition: 1, Offset: 10},
+		{Partition: 1, Offset: 11},
+	}
+	ctx := context.Background()
+	cs := s.clt.NewConsumer("g1", "t1")
+	_, err := cs.Next(ctx)
+	c.Assert(err, IsNil)
+
+	// When
+	err = cs.Nack(ctx, pb.NackRq_FATAL, "bad payload")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(cs.Nack(ctx, pb.NackRq_FATAL, "bad payload"), Equals, ErrNoMessage)
+	_, err = cs.Next(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(len(s.srv.nackReqs), Equals, 1)
+	c.Assert(s.srv.nackReqs[0].Partition, Equals, int32(1))
+	c.Assert(s.srv.nackReqs[0].Offset, Equals, int64(10))
+	c.Assert(s.srv.nackReqs[0].ErrorClass, Equals, pb.NackRq_FATAL)
+	c.Assert(s.srv.nackReqs[0].Reason, Equals, "bad payload")
+	c.Assert(s.srv.consReqs[1].NoAck, Equals, true)
+}
+
+func (s *ClientSuite) TestNextClosed(c *C) {
+	cs := s.clt.NewConsumer("g1", "t1")
+	c.Assert(cs.Close(context.Background()), IsNil)
+
+	// When
+	_, err := cs.Next(context.Background())
+
+	// Then
+	c.Assert(err, Equals, ErrClosed)
+}
+
+// fakeServer implements pb.KafkaPixyServer. It replies with queued errors
+// first, and then with queued messages.
+type fakeServer struct {
+	mu       sync.Mutex
+	errors   []error
+	messages []*pb.ConsRs
+	prodReqs []*pb.ProdRq
+	consReqs []*pb.ConsNAckRq
+	ackReqs  []*pb.AckRq
+	nackReqs []*pb.NackRq
+}
+
+func (fs *fakeServer) nextError() error {
+	if len(fs.errors) == 0 {
+		return nil
+	}
+	err := fs.errors[0]
+	fs.errors = fs.errors[1:]
+	return err
+}
+
+func (fs *fakeServer) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.prodReqs = append(fs.prodReqs, req)
+	if err := fs.nextError(); err != nil {
+		return nil, err
+	}
+	return &pb.ProdRs{}, nil
+}
+
+func (fs *fakeServer) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.consReqs = append(fs.consReqs, req)
+	if err := fs.nextError(); err != nil {
+		return nil, err
+	}
+	if len(fs.messages) == 0 {
+		return nil, grpc.Errorf(codes.NotFound, "long polling timeout")
+	}
+	res := fs.messages[0]
+	fs.messages = fs.messages[1:]
+	return res, nil
+}
+
+func (fs *fakeServer) Ack(ctx context.Context, req *pb.AckRq) (*pb.AckRs, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.ackReqs = append(fs.ackReqs, req)
+	if err := fs.nextError(); err != nil {
+		return nil, err
+	}
+	return &pb.AckRs{}, nil
+}
+
+func (fs *fakeServer) Nack(ctx context.Context, req *pb.NackRq) (*pb.NackRs, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.nackReqs = append(fs.nackReqs, req)
+	if err := fs.nextError(); err != nil {
+		return nil, err
+	}
+	return &pb.NackRs{}, nil
+}
+
+func (fs *fakeServer) GetOffsets(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetOffsetsRs, error) {
+	return &pb.GetOffsetsRs{}, nil
+}
+
+func (fs *fakeServer) WatchGroupEvents(req *pb.WatchGroupEventsRq, stream pb.KafkaPixy_WatchGroupEventsServer) error {
+	return nil
+}
+
+func (fs *fakeServer) StreamOffsets(req *pb.GetOffsetsRq, stream pb.KafkaPixy_StreamOffsetsServer) error {
+	return nil
+}
+
+func (fs *fakeServer) ListConsumers(req *pb.ListConsumersRq, stream pb.KafkaPixy_ListConsumersServer) error {
+	return nil
+}
+
+func (fs *fakeServer) Checkpoint(ctx context.Context, req *pb.CheckpointRq) (*pb.CheckpointRs, error) {
+	return &pb.CheckpointRs{}, nil
+}
+
+func (fs *fakeServer) GetCheckpoints(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetCheckpointsRs, error) {
+	return &pb.GetCheckpointsRs{}, nil
+}
+
+func (fs *fakeServer) Flush(ctx context.Context, req *pb.FlushRq) (*pb.FlushRs, error) {
+	return &pb.FlushRs{}, nil
+}
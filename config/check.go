@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mailgun/kafka-pixy/secrets"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// UnknownKeys returns dotted paths of keys in a YAML config that do not
+// correspond to any config parameter, e.g. misspelled ones. FromYAML silently
+// ignores such keys, and the parameters they were meant for take their
+// default values.
+func UnknownKeys(data []byte) ([]string, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse config")
+	}
+	unknown := findUnknownKeys("", doc, reflect.TypeOf(fileKeys{}), nil)
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// fileKeys describes keys of a config file, that has sections which are not
+// unmarshaled into App.
+type fileKeys struct {
+	App           `yaml:",inline"`
+	ProxyDefaults Proxy `yaml:"proxy_defaults"`
+}
+
+// Inconsistencies returns descriptions of parameters that are valid each on
+// its own, but do not work together the way one would expect.
+func (a *App) Inconsistencies() []string {
+	var found []string
+	for _, source := range a.ClientIdentity.Sources {
+		if source == IdentityTLSCN && a.TLS.ClientCAFile == "" {
+			found = append(found, "client_identity.sources has tls_cn, but tls.client_ca_file is not set, so clients do not present certificates")
+		}
+	}
+	clusters := make([]string, 0, len(a.Proxies))
+	for cluster := range a.Proxies {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	for _, cluster := range clusters {
+		for _, inconsistency := range a.Proxies[cluster].inconsistencies() {
+			found = append(found, fmt.Sprintf("cluster=%s: %s", cluster, inconsistency))
+		}
+	}
+	return found
+}
+
+func (p *Proxy) inconsistencies() []string {
+	var found []string
+	if _, ok := p.Routing.Peers[p.ClientID]; !ok && len(p.Routing.Peers) != 0 {
+		found = append(found, fmt.Sprintf("routing.peers does not have client_id %s, so routing is disabled", p.ClientID))
+	}
+	if len(p.Metrics.StatsD.Tags) != 0 && p.Metrics.Backend != MetricsBackendDogStatsD {
+		found = append(found, fmt.Sprintf("metrics.statsd.tags are ignored by the %s metrics backend", p.Metrics.Backend))
+	}
+	return found
+}
+
+// findUnknownKeys walks a decoded YAML node along with the type it is
+// unmarshaled to, and appends paths of mapping keys that the type does not
+// have a field for to `found`.
+func findUnknownKeys(path string, node interface{}, t reflect.Type, found []string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch node := node.(type) {
+	case map[interface{}]interface{}:
+		if len(node) == 1 && node[secrets.RefKey] != nil {
+			return found
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for k, v := range node {
+				key := fmt.Sprint(k)
+				fieldType, ok := fields[key]
+				if !ok {
+					found = append(found, joinKeyPath(path, key))
+					continue
+				}
+				found = findUnknownKeys(joinKeyPath(path, key), v, fieldType, found)
+			}
+		case reflect.Map:
+			for k, v := range node {
+				found = findUnknownKeys(joinKeyPath(path, fmt.Sprint(k)), v, t.Elem(), found)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i, v := range node {
+				found = findUnknownKeys(fmt.Sprintf("%s[%d]", path, i), v, t.Elem(), found)
+			}
+		}
+	}
+	return found
+}
+
+// yamlFields returns YAML key -> field type mapping of a struct.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.HasSuffix(field.Tag.Get("yaml"), ",inline") {
+			for key, fieldType := range yamlFields(field.Type) {
+				fields[key] = fieldType
+			}
+			continue
+		}
+		if key, ok := yamlKey(field); ok {
+			fields[key] = field.Type
+		}
+	}
+	return fields
+}
+
+// yamlKey returns the key that a struct field is unmarshaled from, following
+// the yaml package rules of naming fields. False is returned if the field is
+// not unmarshaled at all.
+func yamlKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	switch key {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	}
+	return key, true
+}
+
+func joinKeyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
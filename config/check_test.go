@@ -0,0 +1,81 @@
+package config
+
+import (
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+// Every key in default.yaml is a config parameter.
+func (s *ConfigSuite) TestUnknownKeysDefault(c *C) {
+	data, err := ioutil.ReadFile("../default.yaml")
+	c.Assert(err, IsNil)
+
+	// When
+	unknown, err := UnknownKeys(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(unknown, HasLen, 0)
+}
+
+// Misspelled keys are found at any depth, in structs, maps and lists, but
+// secret references and arbitrary map keys are not reported.
+func (s *ConfigSuite) TestUnknownKeys(c *C) {
+	data := []byte(`
+grpc_adr: 0.0.0.0:19091
+tls:
+  cert_file: {secretRef: "env:CERT_FILE"}
+proxy_defaults:
+  consumer:
+    ack_timeout: 10s
+    ack_timout: 10s
+proxies:
+  foo:
+    kafka:
+      seed_peers: ["localhost:9092"]
+    producer:
+      required_ack: wait_for_all
+    tenants:
+      t1:
+        tokens: [bar]
+        rate: 10
+    alerts:
+      rules:
+      - name: lag
+        treshold: 10
+`)
+	// When
+	unknown, err := UnknownKeys(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(unknown, DeepEquals, []string{
+		"grpc_adr",
+		"proxies.foo.alerts.rules[0].treshold",
+		"proxies.foo.producer.required_ack",
+		"proxies.foo.tenants.t1.rate",
+		"proxy_defaults.consumer.ack_timout",
+	})
+}
+
+func (s *ConfigSuite) TestInconsistencies(c *C) {
+	appCfg := DefaultApp("foo")
+	c.Assert(appCfg.Inconsistencies(), HasLen, 0)
+	appCfg.ClientIdentity.Sources = []string{IdentityTLSCN}
+	proxyCfg := appCfg.Proxies["foo"]
+	proxyCfg.ClientID = "pixy3"
+	proxyCfg.Routing.Peers = map[string]string{"pixy1": "host1:19092", "pixy2": "host2:19092"}
+	proxyCfg.Metrics.Backend = MetricsBackendStatsD
+	proxyCfg.Metrics.StatsD.Tags = []string{"env:prod"}
+
+	// When
+	inconsistencies := appCfg.Inconsistencies()
+
+	// Then
+	c.Assert(inconsistencies, DeepEquals, []string{
+		"client_identity.sources has tls_cn, but tls.client_ca_file is not set, so clients do not present certificates",
+		"cluster=foo: routing.peers does not have client_id pixy3, so routing is disabled",
+		"cluster=foo: metrics.statsd.tags are ignored by the statsd metrics backend",
+	})
+}
@@ -6,10 +6,14 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/partitioner"
+	"github.com/mailgun/kafka-pixy/secrets"
 	"github.com/pkg/errors"
 	"github.com/wvanbergen/kazoo-go"
 	"gopkg.in/yaml.v2"
@@ -19,6 +23,12 @@ const (
 	defaultCompression  = "snappy"
 	defaultRequiredAcks = "wait_for_all"
 	defaultKafkaVersion = "0.8.2.2"
+
+	defaultHandoffTimeout = 10 * time.Second
+
+	// Seed peers with the prefix are names of DNS SRV records, that point
+	// to the actual peers.
+	seedPeerSRVPrefix = "srv:"
 )
 
 var (
@@ -28,6 +38,13 @@ var (
 		"snappy": sarama.CompressionSnappy,
 		"lz4":    sarama.CompressionLZ4,
 	}
+	partitioners = map[string]sarama.PartitionerConstructor{
+		PartitionerHash:             sarama.NewHashPartitioner,
+		PartitionerConsistent:       partitioner.NewConsistent,
+		PartitionerConsistentRandom: partitioner.NewConsistentRandom,
+		PartitionerMurmur2:          partitioner.NewMurmur2,
+		PartitionerMurmur2Random:    partitioner.NewMurmur2Random,
+	}
 	producerAcks = map[string]sarama.RequiredAcks{
 		"no_response":    sarama.NoResponse,
 		"wait_for_local": sarama.WaitForLocal,
@@ -41,6 +58,214 @@ var (
 		"0.10.0.1": sarama.V0_10_0_1,
 		"0.10.1.0": sarama.V0_10_1_0,
 	}
+	latestKafkaVersion = "0.10.1.0"
+
+	// Minimum Kafka versions required by features that are not available
+	// with all supported Kafka versions.
+	kafkaFeatures = map[string]string{
+		KafkaFeatureTimestamps:    "0.10.0.0",
+		KafkaFeatureOffsetsByTime: "0.10.1.0",
+		KafkaFeatureHeaders:       "0.11.0.0",
+		KafkaFeatureDeleteGroups:  "1.1.0.0",
+		KafkaFeatureUserQuotas:    "0.10.1.0",
+		KafkaFeatureRequestQuotas: "0.11.0.0",
+		KafkaFeatureZstd:          "2.1.0.0",
+	}
+
+	// ErrKafkaFeatureUnsupported is returned when a feature requires a more
+	// recent Kafka version than the configured one.
+	ErrKafkaFeatureUnsupported = errors.New("kafka feature not supported")
+)
+
+// Values of the `producer.unknown_topics` parameter.
+const (
+	// Leave it up to the Kafka brokers, that either create the topic if
+	// `auto.create.topics.enable` is set, or fail produce requests after all
+	// retries are exhausted.
+	UnknownTopicsBroker = "broker"
+
+	// Fail produce requests right away.
+	UnknownTopicsFail = "fail"
+
+	// Create the topic as configured in `producer.auto_create`.
+	UnknownTopicsCreate = "create"
+)
+
+// Values of the `consumer.offset_reset` parameter.
+const (
+	// Start consuming from the oldest message in a partition.
+	OffsetResetEarliest = "earliest"
+
+	// Start consuming from messages produced after the consumer joined.
+	OffsetResetLatest = "latest"
+)
+
+// Values of the `consumer.dispatch` parameter.
+const (
+	// Messages of a partition are offered in order, and at most a fixed
+	// number of them can be offered but not yet acknowledged at a time.
+	DispatchPartition = "partition"
+
+	// Messages of a partition are offered to as many clients at a time as
+	// `consumer.max_in_flight` allows. Ordering is not preserved when some
+	// of them are redelivered.
+	DispatchUnordered = "unordered"
+
+	// Messages of a partition with different keys are offered in parallel,
+	// like in the unordered mode, but a message is only offered once the
+	// message with the same key before it is acknowledged.
+	DispatchKey = "key"
+)
+
+// Values of the `producer.partitioner` parameter.
+const (
+	// The FNV-1a hash of a key selects a partition, and messages without a
+	// key go to random partitions.
+	PartitionerHash = "hash"
+
+	// As the librdkafka `consistent` partitioner, the CRC32 hash of a key
+	// selects a partition, messages without a key go to the same partition.
+	PartitionerConsistent = "consistent"
+
+	// As the librdkafka `consistent_random` partitioner, that is its default,
+	// the CRC32 hash of a key selects a partition, and messages with a
+	// missing or empty key go to random partitions.
+	PartitionerConsistentRandom = "consistent_random"
+
+	// As the librdkafka `murmur2` partitioner, the murmur2 hash of a key
+	// selects a partition the way the Java producer does, and messages
+	// without a key go to the same partition.
+	PartitionerMurmur2 = "murmur2"
+
+	// As the librdkafka `murmur2_random` partitioner, that is the Java
+	// producer default, the murmur2 hash of a key selects a partition, and
+	// messages without a key go to random partitions.
+	PartitionerMurmur2Random = "murmur2_random"
+)
+
+// Values of the `consumer.timing` parameter.
+const (
+	// Consumer group membership is given up shortly after a consumer stops
+	// making requests or Kafka-Pixy goes away, and its partitions are
+	// rebalanced right away. It suits ephemeral consumers, e.g. CLI tools.
+	TimingShort = "short"
+
+	// Consumer group membership survives longer pauses and network hiccups,
+	// and bursts of members joining and leaving are coalesced into a single
+	// rebalancing. It suits stable long running services.
+	TimingLong = "long"
+)
+
+// Values of the `listener_modes` parameters.
+const (
+	// Produce requests are rejected.
+	ModeConsumeOnly = "consume_only"
+
+	// Consume, ack, checkpoint and replay requests are rejected.
+	ModeProduceOnly = "produce_only"
+)
+
+// Values of the `coordination.backend` parameter.
+const (
+	// Every instance carries out tasks that are coordinated otherwise.
+	CoordinationNone = "none"
+
+	// Leases are ephemeral znodes in the ZooKeeper of the cluster.
+	CoordinationZooKeeper = "zookeeper"
+
+	// Leases are Lease objects of the Kubernetes coordination API.
+	CoordinationKubernetes = "kubernetes"
+)
+
+// Values of the `discovery.registry` parameter.
+const (
+	// Instances are registered via the HTTP API of the local Consul agent.
+	RegistryConsul = "consul"
+
+	// Instances are registered as keys under a lease via the JSON gateway of
+	// the etcd v3 API.
+	RegistryEtcd = "etcd"
+)
+
+// Values of the `access_log.format` parameter.
+const (
+	AccessLogNone   = "none"
+	AccessLogApache = "apache"
+	AccessLogJSON   = "json"
+)
+
+// Values of the `client_identity.sources` parameter.
+const (
+	// The identity is taken from the `client_identity.header` HTTP header,
+	// or gRPC metadata key.
+	IdentityHeader = "header"
+
+	// The identity is the subject of a JWT bearer token. The token signature
+	// is not verified.
+	IdentityJWTSubject = "jwt_subject"
+
+	// The identity is the common name of the client TLS certificate.
+	IdentityTLSCN = "tls_cn"
+
+	// The identity is the IP address of the client.
+	IdentityRemoteIP = "remote_ip"
+)
+
+// Values of the `slow_consumers.action` parameter.
+const (
+	// Slow consumers are only reported.
+	SlowConsumerReport = "report"
+
+	// Consume requests of slow consumers get at most one message, no matter
+	// how many they ask for.
+	SlowConsumerShrinkPrefetch = "shrink_prefetch"
+
+	// Consume requests of slow consumers are rejected, so that messages they
+	// do not acknowledge in time are redelivered to other consumers.
+	SlowConsumerPause = "pause"
+)
+
+// Values of the `alerts.rules[].kind` parameter.
+const (
+	// Fires when the total lag of the group is above the rule threshold.
+	AlertKindLag = "lag"
+
+	// Fires when the group has lag but commits no offsets.
+	AlertKindStall = "stall"
+
+	// Fires when some partitions of the topic are not assigned to any
+	// member of the group.
+	AlertKindUnassigned = "unassigned"
+
+	// Fires when the offset committed by the group in some partition is
+	// less than the rule threshold of messages ahead of the oldest message
+	// retained in the partition, so that retention is about to remove
+	// messages that the group has not consumed yet.
+	AlertKindRetention = "retention"
+)
+
+// Values of the `metrics.backend` parameter.
+const (
+	// Metrics are only exposed via the `GET /_metrics` endpoint.
+	MetricsBackendNone = "none"
+
+	// Metrics are emitted to a statsd daemon. Tags are folded into metric
+	// names since plain statsd does not support them.
+	MetricsBackendStatsD = "statsd"
+
+	// Metrics are emitted to a DogStatsD agent with tags.
+	MetricsBackendDogStatsD = "dogstatsd"
+)
+
+// Kafka features that are gated by the configured Kafka version.
+const (
+	KafkaFeatureTimestamps    = "timestamps"
+	KafkaFeatureOffsetsByTime = "offsets_by_time"
+	KafkaFeatureHeaders       = "headers"
+	KafkaFeatureDeleteGroups  = "delete_groups"
+	KafkaFeatureUserQuotas    = "user_quotas"
+	KafkaFeatureRequestQuotas = "request_quotas"
+	KafkaFeatureZstd          = "zstd"
 )
 
 // App defines Kafka-Pixy application configuration. It mirrors the structure
@@ -56,6 +281,54 @@ type App struct {
 	// Listening on a unix domain socket is disabled by default.
 	UnixAddr string `yaml:"unix_addr"`
 
+	// TCP address that the HTTP API server of the admin plane should listen
+	// on. If given, then the HTTP API servers at TCPAddr and UnixAddr serve
+	// only the data plane, that is produce and consume requests, and this
+	// one serves all the other requests. It is disabled by default.
+	AdminAddr string `yaml:"admin_addr"`
+
+	// TCP address that the diagnostics server, serving pprof profiles and
+	// actor dumps, should listen on. It is disabled by default, and it is
+	// recommended to bind it to localhost.
+	DiagAddr string `yaml:"diag_addr"`
+
+	// Operations that API listeners accept.
+	ListenerModes ListenerModes `yaml:"listener_modes"`
+
+	// IP networks that API clients may connect from.
+	NetworkPolicies NetworkPolicies `yaml:"network_policies"`
+
+	// Access log of all HTTP and gRPC API requests.
+	AccessLog AccessLog `yaml:"access_log"`
+
+	// Detection of HTTP API clients that are slow to process messages.
+	SlowConsumers SlowConsumers `yaml:"slow_consumers"`
+
+	// Connection management of the HTTP API servers.
+	HTTPServer HTTPServer `yaml:"http_server"`
+
+	// Message size and concurrency limits of the gRPC API server.
+	GRPCServer GRPCServer `yaml:"grpc_server"`
+
+	// TLS settings of the gRPC and the TCP HTTP API servers.
+	TLS TLS `yaml:"tls"`
+
+	// How API clients are identified.
+	ClientIdentity ClientIdentity `yaml:"client_identity"`
+
+	// How values referenced with `secretRef` in the config are kept up to
+	// date.
+	Secrets Secrets `yaml:"secrets"`
+
+	// Whether proxies to more clusters can be registered, and proxies other
+	// than the default one deregistered, at runtime via the `/_proxies` HTTP
+	// API endpoints.
+	ProxyRegistration bool `yaml:"proxy_registration"`
+
+	// Self-registration of the instance in a service registry, so that
+	// clients can discover it.
+	Discovery Discovery `yaml:"discovery"`
+
 	// An arbitrary number of proxies to different Kafka/ZooKeeper clusters can
 	// be configured. Each proxy configuration is identified by a cluster name.
 	Proxies map[string]*Proxy `yaml:"proxies"`
@@ -64,6 +337,272 @@ type App struct {
 	// prefix `/clusters/<cluster>`. If it is not explicitly provided, then the
 	// one mentioned in the `Proxies` section first is assumed.
 	DefaultCluster string `yaml:"default_cluster"`
+
+	// The config as it was given, if it has secret references, so that they
+	// can be resolved again.
+	secretsSource []byte
+
+	// Environment variables that overrode parameters, so that they override
+	// them again once secrets are resolved anew.
+	envOverrides []string
+}
+
+// Secrets defines how often values referenced with `secretRef` are resolved
+// again, so that rotated tenant tokens and routing secrets take effect without
+// a restart. See package `secrets` for the reference syntax.
+type Secrets struct {
+	// Zero disables re-resolution.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// Discovery defines how an instance registers itself in a service registry,
+// along with its served clusters and health, so that clients can discover
+// it. The registration is kept alive every third of TTL, and removed when
+// the instance stops.
+type Discovery struct {
+	// Either consul or etcd. Registration is disabled if empty.
+	Registry string `yaml:"registry"`
+
+	// Base URL of the Consul agent, or of an etcd endpoint.
+	URL string `yaml:"url"`
+
+	// Access token, passed to Consul in the X-Consul-Token header, and to
+	// etcd in the Authorization header.
+	Token string `yaml:"token"`
+
+	// Name of the service that instances register as.
+	ServiceName string `yaml:"service_name"`
+
+	// ID of the instance registration. If empty, then
+	// `<service_name>-<host>-<port>` of the advertised address is used.
+	ID string `yaml:"id"`
+
+	// Address that clients should reach the HTTP API at. If empty, then
+	// `tcp_addr` is used, with the host name of the machine if it listens
+	// on all interfaces.
+	AdvertiseAddr string `yaml:"advertise_addr"`
+
+	// Tags added to those generated for served clusters.
+	Tags []string `yaml:"tags"`
+
+	// Whether to tag registrations with topics of served clusters, that
+	// is only sensible if there are not too many of them.
+	TopicTags bool `yaml:"topic_tags"`
+
+	// How long a registration outlives an instance that went away without
+	// deregistering. In Consul it is the TTL of the health check, after
+	// that the instance is reported as critical.
+	TTL time.Duration `yaml:"ttl"`
+
+	// How long Consul keeps an instance that is critical, before it is
+	// deregistered.
+	DeregisterAfter time.Duration `yaml:"deregister_after"`
+
+	// etcd key prefix, instances are registered as
+	// `<prefix>/<service_name>/<id>`.
+	EtcdPrefix string `yaml:"etcd_prefix"`
+}
+
+// ListenerModes defines operations that API listeners accept. A mode can be
+// one of: consume_only, produce_only, or empty for all operations.
+type ListenerModes struct {
+	// Mode of listeners that are not given one of their own.
+	All string `yaml:"all"`
+
+	// Modes of the gRPC, the TCP HTTP, the Unix socket HTTP, and the admin
+	// plane HTTP listeners.
+	GRPC  string `yaml:"grpc"`
+	TCP   string `yaml:"tcp"`
+	Unix  string `yaml:"unix"`
+	Admin string `yaml:"admin"`
+}
+
+// For returns the mode of a listener that has `own` mode configured.
+func (m ListenerModes) For(own string) string {
+	if own != "" {
+		return own
+	}
+	return m.All
+}
+
+// NetworkPolicies defines IP networks that API clients may connect from. A
+// request is only accepted if both the policy of all listeners and the policy
+// of the listener that received it allow it.
+type NetworkPolicies struct {
+	All   NetworkPolicy `yaml:"all"`
+	GRPC  NetworkPolicy `yaml:"grpc"`
+	TCP   NetworkPolicy `yaml:"tcp"`
+	Unix  NetworkPolicy `yaml:"unix"`
+	Admin NetworkPolicy `yaml:"admin"`
+}
+
+// NetworkPolicy defines IP networks that clients may make requests from. Any
+// applies to all requests, and the others to requests of the respective
+// operation class in addition to that.
+type NetworkPolicy struct {
+	Any     NetworkFilter `yaml:"any"`
+	Produce NetworkFilter `yaml:"produce"`
+	Consume NetworkFilter `yaml:"consume"`
+	Admin   NetworkFilter `yaml:"admin"`
+}
+
+// NetworkFilter defines a set of client IP addresses. An address belongs to
+// the set if it is in at least one of Allow networks and in none of Deny
+// networks. An empty Allow list matches all addresses. Networks are given in
+// the CIDR notation or as single IP addresses.
+type NetworkFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+func (f NetworkFilter) validate(path string) error {
+	for _, list := range []struct {
+		name     string
+		networks []string
+	}{{"allow", f.Allow}, {"deny", f.Deny}} {
+		for _, network := range list.networks {
+			if _, err := ParseNetwork(network); err != nil {
+				return errors.Errorf("Bad %s.%s: %v", path, list.name, network)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseNetwork parses a network given in the CIDR notation or as a single IP
+// address.
+func ParseNetwork(network string) (*net.IPNet, error) {
+	if !strings.Contains(network, "/") {
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, errors.Errorf("invalid IP address: %s", network)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	return ipNet, err
+}
+
+// AccessLog defines where and how API requests are logged.
+type AccessLog struct {
+	// Format of access log records: none, apache or json.
+	Format string `yaml:"format"`
+
+	// File that records are appended to. If empty, then records are written
+	// to stdout.
+	Path string `yaml:"path"`
+}
+
+// HTTPServer defines timeouts, keep-alives and HTTP/2 settings of the HTTP
+// API servers.
+type HTTPServer struct {
+	// Maximum time to read a whole request, including the body, and to read
+	// the request headers. Zero means no limit.
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+
+	// Maximum time to write a response. It must be greater than
+	// `consumer.long_polling_timeout` of all proxies, or long polls are cut
+	// short. It also cuts streaming responses, e.g. of the watch endpoints.
+	// Zero means no limit.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// Maximum time to wait for the next request on a kept alive connection.
+	// Zero means that ReadTimeout is used.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// Whether HTTP/1.1 connections are kept alive between requests, and
+	// whether responses tell clients how long idle connections are kept
+	// with the `Keep-Alive` header.
+	KeepAlives      bool `yaml:"keep_alives"`
+	KeepAliveHeader bool `yaml:"keep_alive_header"`
+
+	// How often heartbeats are sent on long responses that nothing else is
+	// written to, so that intermediaries do not close their connections for
+	// being idle. Server-sent event streams get comment lines, and consume
+	// requests with the `heartbeat` parameter get new lines before the JSON
+	// body. Zero disables heartbeats.
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+
+	// Whether HTTP/2 is negotiated with clients of the TCP server if TLS is
+	// enabled, and whether HTTP/2 without TLS (h2c) with prior knowledge is
+	// accepted.
+	HTTP2 bool `yaml:"http2"`
+	H2C   bool `yaml:"h2c"`
+
+	// Maximum number of concurrent streams of an HTTP/2 connection.
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+}
+
+// GRPCServer defines message size and concurrency limits of the gRPC API
+// server.
+//
+// TODO Add keepalive enforcement and connection age limits. The vendored gRPC
+// predates the keepalive server options, so it neither rejects clients that
+// ping too often nor drains connections of a certain age.
+type GRPCServer struct {
+	// Maximum size of a request message, and of a response message. Requests
+	// that exceed the former are rejected by gRPC. Responses that exceed the
+	// latter fail with ResourceExhausted, in which case consumed messages are
+	// redelivered after the ack timeout unless they are auto acknowledged.
+	// Zero MaxSendMsgSize means no limit.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize int `yaml:"max_send_msg_size"`
+
+	// Maximum number of concurrent streams, that is requests, of a client
+	// connection. Zero means no limit.
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+}
+
+// TLS defines TLS settings of the API servers. TLS is disabled if no
+// certificate is given.
+type TLS struct {
+	// Files with the server certificate and its key in the PEM format.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// If given, then clients must present certificates signed by one of CA
+	// certificates from the file.
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// ClientIdentity defines how API clients are identified in sessions, access
+// log records, metrics, and per client rate limits.
+type ClientIdentity struct {
+	// Sources of the identity tried in order, the first one that yields a
+	// non empty identity is used: header, jwt_subject, tls_cn or remote_ip.
+	Sources []string `yaml:"sources"`
+
+	// The name of the HTTP header, or the gRPC metadata key, that the header
+	// source takes the identity from.
+	Header string `yaml:"header"`
+
+	// Requests of every client are limited to this rate. Clients without an
+	// identity are not limited. Zero means no limit.
+	RequestsPerSecond int `yaml:"requests_per_second"`
+}
+
+// SlowConsumers defines when a client session is considered slow, and what is
+// done about it. A session is only considered slow while it has messages that
+// are not acknowledged yet.
+type SlowConsumers struct {
+	// A session is slow if its mean ack latency exceeds this value. Zero
+	// disables the check.
+	MaxAckLatency time.Duration `yaml:"max_ack_latency"`
+
+	// A session is slow if it consumed fewer messages per second than this
+	// value during the last window. Zero disables the check.
+	MinConsumeRate float64 `yaml:"min_consume_rate"`
+
+	// The period that the consume rate is measured over.
+	Window time.Duration `yaml:"window"`
+
+	// What to do with slow sessions: report, shrink_prefetch or pause.
+	Action string `yaml:"action"`
 }
 
 // Proxy defines configuration of a proxy to a particular Kafka/ZooKeeper
@@ -77,116 +616,1001 @@ type Proxy struct {
 	Kafka struct {
 
 		// List of seed Kafka peers that Kafka-Pixy should access to resolve
-		// the Kafka cluster topology.
+		// the Kafka cluster topology. A peer is either `host:port` or
+		// `srv:<name>`, the name of a DNS SRV record pointing to peers.
 		SeedPeers []string `yaml:"seed_peers"`
 
-		// Version of the Kafka cluster. Supported versions are 0.8.2.2 - 0.10.1.0
+		// Version of the Kafka cluster. Supported versions are 0.8.2.2 - 0.10.1.0.
+		// Features that require a more recent version than configured are
+		// rejected with an error.
 		Version string `yaml:"version"`
 	} `yaml:"kafka"`
 
 	ZooKeeper struct {
 
 		// List of seed ZooKeeper peers that Kafka-Pixy should access to
-		// resolve the ZooKeeper cluster topology.
+		// resolve the ZooKeeper cluster topology. As with Kafka, SRV
+		// records can be given as `srv:<name>`.
 		SeedPeers []string `yaml:"seed_peers"`
 
 		// Path to the directory where Kafka keeps its data.
 		Chroot string `yaml:"chroot"`
+
+		// ZooKeeper session timeout, that is how long consumer group
+		// registrations of a Kafka-Pixy instance outlive it if it becomes
+		// unreachable. The ZooKeeper client heartbeats every third of that.
+		// ZooKeeper servers constrain it to 2-20 times their tickTime.
+		SessionTimeout time.Duration `yaml:"session_timeout"`
 	} `yaml:"zoo_keeper"`
 
+	// Resolution of seed peers given as DNS SRV records.
+	DNS struct {
+		// How often SRV records among seed peers are looked up again.
+		RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+		// How long DNS lookups may take.
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"dns"`
+
+	// Failover to a standby cluster, e.g. in another datacenter, when the
+	// primary one defined by the `kafka` and `zoo_keeper` sections becomes
+	// unhealthy, and back once it recovers.
+	Failover struct {
+		// Seed peers of the standby Kafka cluster. If empty, then failover
+		// is disabled.
+		KafkaSeedPeers []string `yaml:"kafka_seed_peers"`
+
+		// Seed peers of the ZooKeeper ensemble of the standby cluster.
+		ZooKeeperSeedPeers []string `yaml:"zoo_keeper_seed_peers"`
+
+		// How often health of both clusters is checked.
+		CheckInterval time.Duration `yaml:"check_interval"`
+
+		// How long a health check waits for a broker to respond.
+		CheckTimeout time.Duration `yaml:"check_timeout"`
+
+		// Number of consecutive failed checks of the active cluster, after
+		// which the proxy fails over to the other one, if it is healthy.
+		FailureThreshold int `yaml:"failure_threshold"`
+
+		// Number of consecutive successful checks of the primary cluster,
+		// while the standby one is active, after which the proxy fails back
+		// to the primary.
+		FailBackThreshold int `yaml:"fail_back_threshold"`
+	} `yaml:"failover"`
+
+	// Coordination of Kafka-Pixy instances serving the cluster, so that
+	// tasks that should only be carried out by one of them at a time, e.g.
+	// notifying about alerts, are. An instance carries a task out while it
+	// holds the lease of the task.
+	Coordination struct {
+		// Either none, zookeeper or kubernetes.
+		Backend string `yaml:"backend"`
+
+		// How long a lease outlives an instance that went away without
+		// releasing it. Leases are renewed every third of that. The
+		// zookeeper backend ties leases to the ZooKeeper session instead.
+		LeaseTTL time.Duration `yaml:"lease_ttl"`
+
+		Kubernetes struct {
+			// URL of the API server. If empty, then it is made of the
+			// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT
+			// environment variables, that are set in pods.
+			APIURL string `yaml:"api_url"`
+
+			// Namespace to keep Lease objects in. If empty, then the
+			// namespace of the pod service account is used.
+			Namespace string `yaml:"namespace"`
+
+			// Bearer token and CA certificate files of the pod service
+			// account.
+			TokenFile string `yaml:"token_file"`
+			CAFile    string `yaml:"ca_file"`
+		} `yaml:"kubernetes"`
+	} `yaml:"coordination"`
+
+	// Sampling of messages produced and consumed via the proxy, for
+	// debugging what flows through it without creating consumer groups.
+	Tap struct {
+		// Sampling rates of topics, from 0 to 1, where 1 means that all
+		// messages of a topic are sampled. Topics not listed are not
+		// sampled, and if none is, then the tap is disabled.
+		Rates map[string]float64 `yaml:"rates"`
+
+		// Topic to copy sampled messages to, along with metadata, as JSON
+		// keyed by the original topic. If empty, then sampled messages are
+		// only streamed by `GET /_tap`.
+		Topic string `yaml:"topic"`
+
+		// Number of sampled messages buffered for each `GET /_tap` stream.
+		// Messages that do not fit are dropped.
+		BufferSize int `yaml:"buffer_size"`
+	} `yaml:"tap"`
+
 	Producer struct {
 
-		// Size of all buffered channels created by the producer module.
+		// Size of all buffered channels created by the producer module,
+		// unless overridden by a more specific parameter.
 		ChannelBufferSize int `yaml:"channel_buffer_size"`
 
+		// Number of messages that can wait to be handed over to the Kafka
+		// client. When it is full, produce requests block. Zero means
+		// ChannelBufferSize.
+		DispatchQueueSize int `yaml:"dispatch_queue_size"`
+
 		// The type of compression to use on messages.
 		Compression string `yaml:"compression"`
 
+		// How partitions are selected for messages by their keys. One of
+		// Partitioner* constants.
+		Partitioner string `yaml:"partitioner"`
+
+		// Sticky partitioning of messages without a key, that are sent to
+		// the same partition for a while rather than to random partitions,
+		// to make larger batches.
+		StickyPartitioning struct {
+			Enabled bool `yaml:"enabled"`
+
+			// The maximum number of messages sent to a partition before
+			// moving on to another one.
+			MaxMessages int `yaml:"max_messages"`
+
+			// The maximum time messages are sent to a partition before
+			// moving on to another one.
+			MaxDuration time.Duration `yaml:"max_duration"`
+		} `yaml:"sticky_partitioning"`
+
 		// The best-effort number of bytes needed to trigger a flush.
 		FlushBytes int `yaml:"flush_bytes"`
 
-		// The best-effort frequency of flushes.
-		FlushFrequency time.Duration `yaml:"flush_frequency"`
+		// The best-effort frequency of flushes, that is how long messages
+		// linger waiting for a batch to fill up.
+		FlushFrequency time.Duration `yaml:"flush_frequency"`
+
+		// The best-effort number of messages needed to trigger a flush. Zero
+		// means that only FlushBytes and FlushFrequency trigger flushes.
+		FlushMessages int `yaml:"flush_messages"`
+
+		// The maximum number of messages sent to a broker in a single
+		// request. Zero means unlimited.
+		FlushMaxMessages int `yaml:"flush_max_messages"`
+
+		// The maximum number of messages handed over to the Kafka client
+		// that have not been acknowledged or failed yet. When it is reached,
+		// messages are held in the dispatch queue. Zero means unlimited.
+		MaxBufferedMessages int `yaml:"max_buffered_messages"`
+
+		// The maximum total size of keys and values of messages handed over
+		// to the Kafka client that have not been acknowledged or failed yet.
+		// When it is reached, messages are held in the dispatch queue. Zero
+		// means unlimited.
+		MaxBufferedBytes int `yaml:"max_buffered_bytes"`
+
+		// How long to wait for the cluster to settle between retries.
+		RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+		// The total number of times to retry sending a message.
+		RetryMax int `yaml:"retry_max"`
+
+		// How often metadata of topics that messages are produced to is
+		// refreshed.
+		MetadataRefreshInterval time.Duration `yaml:"metadata_refresh_interval"`
+
+		// When produce requests fail because partition leaders moved, metadata
+		// of affected topics is refreshed at most this often. All topics
+		// invalidated in between are refreshed with a single request.
+		MetadataRefreshBackoff time.Duration `yaml:"metadata_refresh_backoff"`
+
+		// The level of acknowledgement reliability needed from the broker.
+		RequiredAcks string `yaml:"required_acks"`
+
+		// Levels of acknowledgement reliability that produce requests may
+		// ask for instead of RequiredAcks, that is always allowed. A
+		// separate Kafka producer is kept for each of them.
+		AllowedAcks []string `yaml:"allowed_acks"`
+
+		// Period of time that Kafka-Pixy should keep trying to submit buffered
+		// messages to Kafka. It is recommended to make it large enough to survive
+		// a ZooKeeper leader election in your setup.
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+		// Messages that take longer than this from a produce request to a
+		// broker acknowledgement are logged. Zero disables the log.
+		SlowProduceThreshold time.Duration `yaml:"slow_produce_threshold"`
+
+		// If true, then messages are timestamped with the time a produce
+		// request was accepted rather than when a message was sent to a
+		// broker, so that end-to-end latency reported by consumers includes
+		// buffering in the proxy. It requires Kafka 0.10.0.0 or later.
+		StampTimestamps bool `yaml:"stamp_timestamps"`
+
+		// What to do when a message is produced to a topic that does not
+		// exist. One of UnknownTopics* constants.
+		UnknownTopics string `yaml:"unknown_topics"`
+
+		// Parameters of topics created when `unknown_topics` is `create`.
+		AutoCreate struct {
+			Partitions        int `yaml:"partitions"`
+			ReplicationFactor int `yaml:"replication_factor"`
+		} `yaml:"auto_create"`
+
+		// Delayed production, when a produce request tells when a message is
+		// due with `deliverAt`.
+		Delayed struct {
+			// Directory where delayed messages are kept until they are due,
+			// so that they survive restarts. Messages of each cluster are
+			// stored in a subdirectory named after it. If empty, then delayed
+			// production is disabled.
+			Dir string `yaml:"dir"`
+
+			// How far in the future a message is allowed to be due.
+			MaxDelay time.Duration `yaml:"max_delay"`
+		} `yaml:"delayed"`
+
+		// Reporting of outcomes of asynchronously produced messages, when a
+		// produce request asks for them with a callback URL or a result
+		// topic.
+		Outcomes struct {
+			// Callback URLs must start with one of these prefixes. If empty,
+			// then callback URLs are not allowed.
+			URLPrefixes []string `yaml:"url_prefixes"`
+
+			// Timeout of callback requests.
+			Timeout time.Duration `yaml:"timeout"`
+
+			// Maximum number of outcomes waiting to be reported. Outcomes
+			// that do not fit are dropped.
+			QueueSize int `yaml:"queue_size"`
+		} `yaml:"outcomes"`
+	} `yaml:"producer"`
+
+	Consumer struct {
+
+		// Period of time that Kafka-Pixy should wait for an acknowledgement
+		// before retrying. It must be less then RegistrationTimeout.
+		AckTimeout time.Duration `yaml:"ack_timeout"`
+
+		// Size of all buffered channels created by the consumer module,
+		// unless overridden by a more specific parameter.
+		ChannelBufferSize int `yaml:"channel_buffer_size"`
+
+		// If a partition assigned to this member is still owned by another
+		// member of the group for this long, then the claim fails with an
+		// error that tells who owns it, and the partition is not consumed by
+		// this member until the next rebalancing. Zero means to wait for as
+		// long as it takes.
+		ClaimTimeout time.Duration `yaml:"claim_timeout"`
+
+		// Defines how messages of a partition are dispatched to consume
+		// requests. One of Dispatch* constants.
+		Dispatch string `yaml:"dispatch"`
+
+		// The default number of message bytes to fetch from the broker in each
+		// request. This should be larger than the majority of your messages,
+		// or else the consumer will spend a lot of time negotiating sizes and
+		// not actually consuming.
+		FetchBytes int `yaml:"fetch_bytes"`
+
+		// Defines resources that every consumer group gets to itself, so
+		// that a misbehaving group cannot degrade others.
+		GroupIsolation GroupIsolation `yaml:"group_isolation"`
+
+		// When a partition is reassigned to another group member, the losing
+		// member waits at most this long for acknowledgements of messages
+		// already offered from the partition, before committing the acked
+		// offset and releasing the partition to the new owner. It must be
+		// less then or equal to AckTimeout. Zero means 10 seconds or
+		// AckTimeout, whichever is less.
+		HandoffTimeout time.Duration `yaml:"handoff_timeout"`
+
+		// If true, then the time from a message timestamp to the delivery of
+		// the message by a consume request is recorded per group and topic
+		// in the `consume.e2e_latency` metric. It requires Kafka 0.10.0.0 or
+		// later, messages produced with older versions have no timestamps.
+		EndToEndLatency bool `yaml:"end_to_end_latency"`
+
+		// Consume request will wait at most this long until a message from the
+		// specified group/topic becomes available.
+		LongPollingTimeout time.Duration `yaml:"long_polling_timeout"`
+
+		// Maximum number of bytes of client metadata that a checkpoint can be
+		// committed with. Checkpoint metadata is stored in offset metadata
+		// along with sparse acks, and it counts toward MaxSparseAcksSize.
+		MaxCheckpointSize int `yaml:"max_checkpoint_size"`
+
+		// Maximum number of consecutive messages from the same partition that
+		// a consume request can claim, so that they are all offered to the
+		// client at once before any of them is acknowledged.
+		MaxClaimSize int `yaml:"max_claim_size"`
+
+		// Maximum number of messages of a partition of a topic in the
+		// unordered or key dispatch mode that can be offered but not yet
+		// acknowledged at a time. They are
+		// tracked as sparse acks, so the more of them, the more likely
+		// acknowledged ranges do not fit into MaxSparseAcksSize.
+		MaxInFlight int `yaml:"max_in_flight"`
+
+		// Maximum number of messages with the same key that can wait for an
+		// earlier message with the key to be acknowledged, in the key
+		// dispatch mode. When it is reached, reading from the partition is
+		// suspended until the key queue drains.
+		MaxKeyQueueSize int `yaml:"max_key_queue_size"`
+
+		// Messages older than this, according to their Kafka timestamps, are
+		// acknowledged and skipped without being offered to consumers. Zero
+		// means that messages never expire. Messages without timestamps,
+		// written by Kafka older than 0.10, never expire either.
+		MaxMessageAge time.Duration `yaml:"max_message_age"`
+
+		// Maximum number of bytes of offset metadata that sparse acks, ranges
+		// of messages acknowledged out of order, are encoded to. It must not
+		// exceed `offset.metadata.max.bytes` of the Kafka brokers, or offsets
+		// cannot be committed. Ranges that do not fit are not persisted, so
+		// messages in them are redelivered after a restart or rebalancing.
+		// Zero means no limit.
+		MaxSparseAcksSize int `yaml:"max_sparse_acks_size"`
+
+		// Number of messages fetched from a partition that are buffered
+		// until consume requests take them. Zero means ChannelBufferSize.
+		MessageBufferSize int `yaml:"message_buffer_size"`
+
+		// Where to start consuming a partition that a consumer group has no
+		// committed offset for. One of OffsetReset* constants. A consume
+		// request can override it for the group it is made on behalf of.
+		OffsetReset string `yaml:"offset_reset"`
+
+		// Where to resume consuming a partition if the offset to fetch from
+		// no longer exists, e.g. because messages have been removed by
+		// retention. One of OffsetReset* constants.
+		OffsetOutOfRange string `yaml:"offset_out_of_range"`
+
+		// How frequently to commit offsets to Kafka.
+		OffsetsCommitInterval time.Duration `yaml:"offsets_commit_interval"`
+
+		// Offset commits that keep failing are retried with a backoff that
+		// starts at RetryBackoff and doubles with every failure up to that.
+		OffsetsCommitMaxBackoff time.Duration `yaml:"offsets_commit_max_backoff"`
+
+		// Partitions which offsets have not been committed for that long due
+		// to errors are reported by the health endpoint.
+		OffsetsCommitFailureThreshold time.Duration `yaml:"offsets_commit_failure_threshold"`
+
+		// How frequently to check whether partitions were added to topics
+		// consumed by a group. Zero disables the check, so that added
+		// partitions are only consumed after a proxy restart.
+		PartitionCheckInterval time.Duration `yaml:"partition_check_interval"`
+
+		// Added partitions are assigned that long after they are detected,
+		// to let their leaders get elected and metadata propagate to all
+		// brokers.
+		PartitionSettleDelay time.Duration `yaml:"partition_settle_delay"`
+
+		// Kafka-Pixy should wait this long after it gets notification that a
+		// consumer joined/left a consumer group it is a member of before
+		// rebalancing.
+		RebalanceDelay time.Duration `yaml:"rebalance_delay"`
+
+		// If a consumer group membership changes, then the group is
+		// rebalanced no earlier than this long after the first change, so
+		// that a burst of changes, e.g. by a rolling deploy of consumers,
+		// makes one rebalancing instead of many. Zero means that only
+		// RebalanceDelay applies.
+		RebalanceWindow time.Duration `yaml:"rebalance_window"`
+
+		// Defines how long messages that have not been acknowledged within
+		// AckTimeout are withheld before they are offered again.
+		Redelivery Redelivery `yaml:"redelivery"`
+
+		// Period of time that Kafka-Pixy should keep registration with a
+		// consumer group or subscription for a topic in the absence of
+		// requests to the consumer group or topic.
+		RegistrationTimeout time.Duration `yaml:"registration_timeout"`
+
+		// Number of consume requests that can wait for a message of a
+		// group/topic, and for dispatch to the group. Requests above that are
+		// rejected with ErrTooManyRequests. Zero means ChannelBufferSize.
+		RequestQueueSize int `yaml:"request_queue_size"`
+
+		// Defines how partition consumers and offset managers are restarted
+		// if they crash.
+		Restart Restart `yaml:"restart"`
+
+		// If a request to a Kafka-Pixy fails for any reason, then it should
+		// wait this long before retrying.
+		RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+		// Preset of consumer group membership timings, it can be one of:
+		// short, long, or empty for the defaults. A preset sets
+		// zoo_keeper.session_timeout, rebalance_delay and
+		// registration_timeout, and for the short one also ack_timeout and
+		// handoff_timeout to fit under registration_timeout. Parameters
+		// given explicitly override the preset.
+		Timing string `yaml:"timing"`
+
+		// Per-topic dispatch modes that override Dispatch.
+		TopicDispatch map[string]string `yaml:"topic_dispatch"`
+
+		// Per-topic redelivery parameters that override Redelivery.
+		TopicRedelivery map[string]*Redelivery `yaml:"topic_redelivery"`
+
+		// Per-topic max message ages that override MaxMessageAge.
+		TopicMaxMessageAge map[string]time.Duration `yaml:"topic_max_message_age"`
+	} `yaml:"consumer"`
+
+	// TESTING ONLY! If enabled then the proxy does not connect to Kafka and
+	// ZooKeeper at all. Instead produce, consume and offset operations are
+	// served by an in-memory simulation of a Kafka cluster. That allows
+	// applications to run integration tests against a real Kafka-Pixy API.
+	InMemory struct {
+
+		// Enables the in-memory mode.
+		Enabled bool `yaml:"enabled"`
+
+		// Number of partitions that topics created on first use have.
+		Partitions int `yaml:"partitions"`
+
+		// Topics to be created on start along with their partition counts.
+		Topics map[string]int `yaml:"topics"`
+	} `yaml:"in_memory"`
+
+	// TESTING ONLY! Fault injection allows introducing artificial errors and
+	// latency into produce, consume, ack, and offset commit operations, and
+	// triggering consumer group rebalancing via the `/_faults` HTTP API
+	// endpoints. It is intended for resilience testing of client applications.
+	FaultInjection struct {
+
+		// Enables the fault injection API endpoints.
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"fault_injection"`
+
+	// Naming policy for topics and consumer groups. Requests with names that
+	// violate the policy are rejected. Note that the rules are checked against
+	// actual names, that is after a tenant prefix is applied. Prefixing names
+	// by client identity is provided by the tenants feature.
+	Names struct {
+
+		// If true then topic and group names are converted to lower case
+		// before anything else.
+		Lowercase bool `yaml:"lowercase"`
+
+		// Rules for topic names.
+		Topic NameRules `yaml:"topic"`
+
+		// Rules for consumer group names.
+		Group NameRules `yaml:"group"`
+	} `yaml:"names"`
+
+	// Topic access control lists for produce, consume, and admin operations.
+	// They can be used to prevent clients from accessing internal topics, like
+	// `__consumer_offsets`, via the proxy.
+	TopicACL struct {
+
+		// Topics that can be produced to.
+		Produce TopicFilter `yaml:"produce"`
+
+		// Topics that can be consumed from.
+		Consume TopicFilter `yaml:"consume"`
+
+		// Topics that admin operations, offsets and consumers queries, can be
+		// performed on.
+		Admin TopicFilter `yaml:"admin"`
+	} `yaml:"topic_acl"`
+
+	// Tenants that share the cluster. If at least one tenant is configured
+	// then all API requests to the cluster must be authenticated with a token
+	// of one of the tenants. Names of topics and groups in requests are then
+	// automatically prefixed with the tenant prefix.
+	Tenants map[string]*Tenant `yaml:"tenants"`
+
+	// Secret tokens that authenticate operators of the cluster. Operators
+	// are not tenants, they access topics and groups by their actual names
+	// and may use endpoints that are forbidden to tenants, like sessions,
+	// quotas and fault injection. Only makes sense if tenants are configured.
+	OperatorTokens []string `yaml:"operator_tokens"`
+
+	// Partitions that are always assigned to particular consumer group
+	// members, bypassing automatic assignment. The rest of partitions are
+	// distributed among all group members subscribed to a topic as usual.
+	PartitionPins []PartitionPin `yaml:"partition_pins"`
+
+	// Routing of consume requests between Kafka-Pixy instances serving the
+	// cluster behind a load balancer. Every consumer group gets a home
+	// instance selected consistently by all peers, and consume and ack
+	// requests received by other instances are forwarded to it. That way
+	// clients long polling different instances do not make the group
+	// rebalance over and over again.
+	Routing struct {
+
+		// HTTP API addresses of all Kafka-Pixy instances serving the cluster,
+		// including this one, mapped to their client IDs. Routing is disabled
+		// unless `client_id` of this instance is in the map.
+		Peers map[string]string `yaml:"peers"`
+
+		// Secret shared by all peers to authenticate forwarded requests.
+		Secret string `yaml:"secret"`
+	} `yaml:"routing"`
+
+	// Admin API related parameters.
+	Admin struct {
+
+		// How often offsets of a group/topic are polled while their lag is
+		// watched. It is polled once per interval regardless of the number
+		// of watchers.
+		LagWatchInterval time.Duration `yaml:"lag_watch_interval"`
+
+		// Consumer group and partition owner data fetched from ZooKeeper by
+		// consumers queries is cached for this long. Zero disables caching.
+		ZooKeeperCacheTTL time.Duration `yaml:"zoo_keeper_cache_ttl"`
+
+		// The number of consumer groups that consumers queries fetch data of
+		// from ZooKeeper concurrently.
+		ZooKeeperScanWorkers int `yaml:"zoo_keeper_scan_workers"`
+
+		// If true, then dynamic broker configs can be altered via the admin
+		// API, otherwise broker configs are read-only.
+		AlterBrokerConfigs bool `yaml:"alter_broker_configs"`
+	} `yaml:"admin"`
+
+	// Metrics emitter parameters section.
+	Metrics struct {
+
+		// Backend to periodically emit all internal metrics to. It can be
+		// one of: none, statsd or dogstatsd.
+		Backend string `yaml:"backend"`
+
+		// How often metrics are emitted to the backend.
+		FlushInterval time.Duration `yaml:"flush_interval"`
+
+		// statsd and DogStatsD backend parameters.
+		StatsD struct {
+
+			// UDP address of the statsd daemon or the Datadog agent.
+			Addr string `yaml:"addr"`
+
+			// Prefix prepended to names of all emitted metrics.
+			Prefix string `yaml:"prefix"`
+
+			// Tags in the `key:value` form attached to all emitted metrics.
+			// They are only supported by the dogstatsd backend.
+			Tags []string `yaml:"tags"`
+		} `yaml:"statsd"`
+	} `yaml:"metrics"`
+
+	// Alerting parameters section.
+	Alerts struct {
+
+		// How often alert rules are evaluated.
+		CheckInterval time.Duration `yaml:"check_interval"`
+
+		// Rules that fire alerts.
+		Rules []AlertRule `yaml:"rules"`
+
+		// Alerts are posted as JSON to this URL when they fire and resolve.
+		Webhook struct {
+			URL     string        `yaml:"url"`
+			Timeout time.Duration `yaml:"timeout"`
+		} `yaml:"webhook"`
+
+		// Alerts are produced as JSON to this topic when they fire and
+		// resolve.
+		Topic string `yaml:"topic"`
+	} `yaml:"alerts"`
+
+	// Key index parameters section. Kafka-Pixy keeps the latest message of
+	// every key of the listed compacted topics in memory, so that they can
+	// be looked up by key.
+	KeyIndex struct {
+
+		// Compacted topics to be indexed.
+		Topics []string `yaml:"topics"`
+
+		// How often indexes catch up with the ends of their topics.
+		RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+		// Lookups fail with ErrStale if an index has not caught up with
+		// the end of its topic this long.
+		MaxStaleness time.Duration `yaml:"max_staleness"`
+	} `yaml:"key_index"`
+
+	// Warm-up parameters section. Kafka-Pixy prepares to serve the listed
+	// topics and consumers as soon as it starts, rather than on the first
+	// request, and reports readiness once it is done.
+	WarmUp struct {
+
+		// Topics that the producer fetches metadata of.
+		Topics []string `yaml:"topics"`
+
+		// Consumers that are started as if with a start request.
+		Consumers []WarmUpConsumer `yaml:"consumers"`
+
+		// Readiness is reported after this long even if some of the
+		// topics or consumers have failed to warm up.
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"warm_up"`
+
+	// The proxy config as it would be if the proxy section was empty, that
+	// is the built-in defaults with `proxy_defaults` applied. It is nil if
+	// there are no `proxy_defaults`. See Effective.
+	inherited *Proxy
+}
+
+// NameRules defines rules that names of a particular kind must comply with.
+type NameRules struct {
+	// Regular expression that names must fully match. Empty means any name.
+	Pattern string `yaml:"pattern"`
+
+	// Maximum name length. Zero means unlimited.
+	MaxLength int `yaml:"max_length"`
+
+	// Names must not start with any of these prefixes.
+	ReservedPrefixes []string `yaml:"reserved_prefixes"`
+}
+
+// TopicFilter defines a set of topics with lists of regular expressions. A
+// topic belongs to the set if it fully matches at least one of Allow patterns
+// and does not match any of Deny patterns. An empty Allow list matches all
+// topics.
+type TopicFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// PartitionPin assigns partitions of a topic consumed by a consumer group to a
+// particular member of the group. Pinned partitions are not consumed at all
+// while the member is not subscribed to the topic. All Kafka-Pixy instances
+// serving the group must have the same pins configured.
+type PartitionPin struct {
+	Group      string  `yaml:"group"`
+	Topic      string  `yaml:"topic"`
+	Partitions []int32 `yaml:"partitions"`
+
+	// ID of the group member, that is `client_id` of the Kafka-Pixy instance.
+	Member string `yaml:"member"`
+}
+
+// Redelivery defines a backoff that messages, which have not been
+// acknowledged in time or have been nacked, are withheld for before they are
+// offered again. The first retry is delayed by Backoff, and each subsequent
+// one BackoffFactor times longer than the previous, but never longer than
+// MaxBackoff. A message nacked as retryable after MaxAttempts offers is
+// produced to DeadLetterTopic instead.
+type Redelivery struct {
+	Backoff       time.Duration `yaml:"backoff"`
+	BackoffFactor float64       `yaml:"backoff_factor"`
+
+	// Zero means unlimited.
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+
+	// Topic that messages nacked as fatal are produced to. If empty, then
+	// fatal nacks are rejected.
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+
+	// Maximum number of times a message is offered. Zero means unlimited.
+	// Requires DeadLetterTopic.
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// Keys of parameters given in YAML. It is only kept until topic
+	// overrides inherit parameters that are not given in them from
+	// `consumer.redelivery`, see inheritRedelivery.
+	given map[string]bool
+}
+
+// Restart defines a policy of restarting a crashed actor. It is restarted up
+// to MaxRestarts times within Window, with a delay that starts at Backoff and
+// doubles with every restart up to MaxBackoff. If the actor crashes more often
+// than that, the whole process does. Zero MaxRestarts disables restarts.
+type Restart struct {
+	MaxRestarts int           `yaml:"max_restarts"`
+	Window      time.Duration `yaml:"window"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+// GroupIsolation defines how consumer groups are isolated from each other.
+type GroupIsolation struct {
+	// If true, then every consumer group fetches messages over Kafka broker
+	// connections of its own, so that large fetch responses of one group do
+	// not hold up fetches of others. It takes a connection per broker per
+	// group.
+	DedicatedConnections bool `yaml:"dedicated_connections"`
+
+	// Maximum number of topics that a consumer group can consume at a time.
+	// Consume requests for more topics are rejected. It bounds the number of
+	// goroutines and buffers of the group, for they grow with topics. Zero
+	// means no limit.
+	MaxTopics int `yaml:"max_topics"`
+}
+
+// WarmUpConsumer is a topic consumed by a consumer group, that is started when
+// Kafka-Pixy starts.
+type WarmUpConsumer struct {
+	Group string `yaml:"group"`
+	Topic string `yaml:"topic"`
+}
+
+// AlertRule fires an alert when its condition holds for a consumer group and
+// a topic for at least For, and resolves it when the condition stops holding.
+type AlertRule struct {
+	Name  string `yaml:"name"`
+	Kind  string `yaml:"kind"`
+	Group string `yaml:"group"`
+	Topic string `yaml:"topic"`
+
+	// Total lag of the group in the topic that the lag rule kind fires
+	// above, or the number of messages between the oldest retained message
+	// and the committed offset that the retention rule kind fires below.
+	Threshold int64 `yaml:"threshold"`
+
+	For time.Duration `yaml:"for"`
+}
+
+// Delay returns how long a message is withheld before it is offered for the
+// retryNo'th time.
+func (r *Redelivery) Delay(retryNo int) time.Duration {
+	delay := float64(r.Backoff)
+	for i := 1; i < retryNo; i++ {
+		delay *= r.BackoffFactor
+		if r.MaxBackoff > 0 && delay >= float64(r.MaxBackoff) {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && delay > float64(r.MaxBackoff) {
+		return r.MaxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. It records what parameters are
+// given, so that topic overrides can inherit the rest.
+func (r *Redelivery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Redelivery
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	var given map[string]interface{}
+	if err := unmarshal(&given); err != nil {
+		return err
+	}
+	r.given = make(map[string]bool, len(given))
+	for key := range given {
+		r.given[key] = true
+	}
+	return nil
+}
+
+// inherit returns redelivery parameters with those not given in YAML taken
+// from `base`.
+func (r *Redelivery) inherit(base Redelivery) Redelivery {
+	merged := base
+	merged.given = nil
+	if r.given["backoff"] {
+		merged.Backoff = r.Backoff
+	}
+	if r.given["backoff_factor"] {
+		merged.BackoffFactor = r.BackoffFactor
+	}
+	if r.given["max_backoff"] {
+		merged.MaxBackoff = r.MaxBackoff
+	}
+	if r.given["dead_letter_topic"] {
+		merged.DeadLetterTopic = r.DeadLetterTopic
+	}
+	if r.given["max_attempts"] {
+		merged.MaxAttempts = r.MaxAttempts
+	}
+	return merged
+}
+
+// inheritRedelivery makes topic redelivery overrides inherit parameters that
+// they do not give from `consumer.redelivery`. It has to be called once the
+// proxy config is parsed, for overrides may be given before the parameters
+// that they inherit, or in `proxy_defaults`.
+func (p *Proxy) inheritRedelivery() {
+	for topic, redelivery := range p.Consumer.TopicRedelivery {
+		if redelivery != nil {
+			inherited := redelivery.inherit(p.Consumer.Redelivery)
+			p.Consumer.TopicRedelivery[topic] = &inherited
+		}
+	}
+	p.Consumer.Redelivery.given = nil
+}
+
+func (r *Redelivery) validate(path string) error {
+	switch {
+	case r.Backoff < 0:
+		return errors.Errorf("%s.backoff must be >= 0", path)
+	case r.BackoffFactor < 1:
+		return errors.Errorf("%s.backoff_factor must be >= 1", path)
+	case r.MaxBackoff < 0:
+		return errors.Errorf("%s.max_backoff must be >= 0", path)
+	case r.MaxAttempts < 0:
+		return errors.Errorf("%s.max_attempts must be >= 0", path)
+	case r.MaxAttempts > 0 && r.DeadLetterTopic == "":
+		return errors.Errorf("%s.max_attempts requires dead_letter_topic", path)
+	}
+	return nil
+}
+
+// Tenant defines a group of clients that have access to a dedicated subset of
+// topics and consumer groups of a cluster.
+type Tenant struct {
+	// Secret tokens that authenticate clients as members of the tenant.
+	Tokens []string `yaml:"tokens"`
 
-		// How long to wait for the cluster to settle between retries.
-		RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Prefix applied to names of all topics and consumer groups accessed by
+	// the tenant clients. If not specified then `<tenant name>.` is used.
+	Prefix string `yaml:"prefix"`
 
-		// The total number of times to retry sending a message.
-		RetryMax int `yaml:"retry_max"`
+	// Maximum number of API requests per second that the tenant clients are
+	// allowed to make. Zero means unlimited.
+	RequestsPerSecond int `yaml:"requests_per_second"`
+}
 
-		// The level of acknowledgement reliability needed from the broker.
-		RequiredAcks string `yaml:"required_acks"`
+// SeedPeerSRV returns the name of the DNS SRV record that a seed peer is
+// given as, if it is one.
+func SeedPeerSRV(peer string) (string, bool) {
+	if !strings.HasPrefix(peer, seedPeerSRVPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(peer, seedPeerSRVPrefix), true
+}
 
-		// Period of time that Kafka-Pixy should keep trying to submit buffered
-		// messages to Kafka. It is recommended to make it large enough to survive
-		// a ZooKeeper leader election in your setup.
-		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
-	} `yaml:"producer"`
+// StandbyCfg returns the config of a proxy to the standby cluster, that is
+// the same as this one except for the seed peers, or nil if failover is not
+// configured.
+func (p *Proxy) StandbyCfg() *Proxy {
+	if len(p.Failover.KafkaSeedPeers) == 0 {
+		return nil
+	}
+	standbyCfg := *p
+	standbyCfg.Kafka.SeedPeers = p.Failover.KafkaSeedPeers
+	standbyCfg.ZooKeeper.SeedPeers = p.Failover.ZooKeeperSeedPeers
+	return &standbyCfg
+}
 
-	Consumer struct {
+// ProducerPartitioner returns the constructor of partitioners that select
+// partitions for produced messages.
+func (p *Proxy) ProducerPartitioner() sarama.PartitionerConstructor {
+	keyed := partitioners[p.Producer.Partitioner]
+	if !p.Producer.StickyPartitioning.Enabled {
+		return keyed
+	}
+	return partitioner.NewSticky(keyed, p.Producer.StickyPartitioning.MaxMessages,
+		p.Producer.StickyPartitioning.MaxDuration)
+}
 
-		// Period of time that Kafka-Pixy should wait for an acknowledgement
-		// before retrying. It must be less then RegistrationTimeout.
-		AckTimeout time.Duration `yaml:"ack_timeout"`
+// ProducerDispatchQueueSize returns the size of the producer dispatch queue.
+func (p *Proxy) ProducerDispatchQueueSize() int {
+	return sizeOr(p.Producer.DispatchQueueSize, p.Producer.ChannelBufferSize)
+}
 
-		// Size of all buffered channels created by the consumer module.
-		ChannelBufferSize int `yaml:"channel_buffer_size"`
+// ConsumerMessageBufferSize returns the size of partition message buffers.
+func (p *Proxy) ConsumerMessageBufferSize() int {
+	return sizeOr(p.Consumer.MessageBufferSize, p.Consumer.ChannelBufferSize)
+}
 
-		// The default number of message bytes to fetch from the broker in each
-		// request. This should be larger than the majority of your messages,
-		// or else the consumer will spend a lot of time negotiating sizes and
-		// not actually consuming.
-		FetchBytes int `yaml:"fetch_bytes"`
+// ConsumerRequestQueueSize returns the size of consume request queues.
+func (p *Proxy) ConsumerRequestQueueSize() int {
+	return sizeOr(p.Consumer.RequestQueueSize, p.Consumer.ChannelBufferSize)
+}
 
-		// Consume request will wait at most this long until a message from the
-		// specified group/topic becomes available.
-		LongPollingTimeout time.Duration `yaml:"long_polling_timeout"`
+// ConsumerHandoffTimeout returns how long acks of a reassigned partition are
+// waited for.
+func (p *Proxy) ConsumerHandoffTimeout() time.Duration {
+	if p.Consumer.HandoffTimeout > 0 {
+		return p.Consumer.HandoffTimeout
+	}
+	if p.Consumer.AckTimeout < defaultHandoffTimeout {
+		return p.Consumer.AckTimeout
+	}
+	return defaultHandoffTimeout
+}
 
-		// How frequently to commit offsets to Kafka.
-		OffsetsCommitInterval time.Duration `yaml:"offsets_commit_interval"`
+func sizeOr(size, dflt int) int {
+	if size > 0 {
+		return size
+	}
+	return dflt
+}
 
-		// Kafka-Pixy should wait this long after it gets notification that a
-		// consumer joined/left a consumer group it is a member of before
-		// rebalancing.
-		RebalanceDelay time.Duration `yaml:"rebalance_delay"`
+// TopicRedelivery returns redelivery parameters configured for a topic.
+func (p *Proxy) TopicRedelivery(topic string) Redelivery {
+	if redelivery := p.Consumer.TopicRedelivery[topic]; redelivery != nil {
+		return *redelivery
+	}
+	return p.Consumer.Redelivery
+}
 
-		// Period of time that Kafka-Pixy should keep registration with a
-		// consumer group or subscription for a topic in the absence of
-		// requests to the consumer group or topic.
-		RegistrationTimeout time.Duration `yaml:"registration_timeout"`
+// TopicMaxMessageAge returns the max message age configured for a topic.
+func (p *Proxy) TopicMaxMessageAge(topic string) time.Duration {
+	if maxAge, ok := p.Consumer.TopicMaxMessageAge[topic]; ok {
+		return maxAge
+	}
+	return p.Consumer.MaxMessageAge
+}
 
-		// If a request to a Kafka-Pixy fails for any reason, then it should
-		// wait this long before retrying.
-		RetryBackoff time.Duration `yaml:"retry_backoff"`
-	} `yaml:"consumer"`
+// TopicDispatch returns the dispatch mode configured for a topic.
+func (p *Proxy) TopicDispatch(topic string) string {
+	if dispatch, ok := p.Consumer.TopicDispatch[topic]; ok {
+		return dispatch
+	}
+	return p.Consumer.Dispatch
 }
 
 func (p *Proxy) KazooCfg() *kazoo.Config {
 	kazooCfg := kazoo.NewConfig()
 	kazooCfg.Chroot = p.ZooKeeper.Chroot
-	// ZooKeeper documentation says following about the session timeout: "The
-	// current (ZooKeeper) implementation requires that the timeout be a
-	// minimum of 2 times the tickTime (as set in the server configuration) and
-	// a maximum of 20 times the tickTime". The default tickTime is 2 seconds.
-	// See http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#ch_zkSessions
-	kazooCfg.Timeout = 15 * time.Second
+	kazooCfg.Timeout = p.ZooKeeper.SessionTimeout
 	return kazooCfg
 }
 
 // SaramaProdCfg returns a config for sarama producer.
 func (p *Proxy) SaramaProdCfg() *sarama.Config {
 	saramaCfg := sarama.NewConfig()
+	saramaCfg.Version = p.SaramaKafkaVersion()
 	saramaCfg.ChannelBufferSize = p.Producer.ChannelBufferSize
 	saramaCfg.ClientID = p.ClientID
 	saramaCfg.Producer.Compression = compressionCodecs[p.Producer.Compression]
+	saramaCfg.Producer.Partitioner = p.ProducerPartitioner()
 	saramaCfg.Producer.Flush.Frequency = p.Producer.FlushFrequency
 	saramaCfg.Producer.Flush.Bytes = p.Producer.FlushBytes
+	saramaCfg.Producer.Flush.Messages = p.Producer.FlushMessages
+	saramaCfg.Producer.Flush.MaxMessages = p.Producer.FlushMaxMessages
 	saramaCfg.Producer.Retry.Backoff = p.Producer.RetryBackoff
 	saramaCfg.Producer.Retry.Max = p.Producer.RetryMax
 	saramaCfg.Producer.RequiredAcks = producerAcks[p.Producer.RequiredAcks]
 	return saramaCfg
 }
 
+// SaramaKafkaVersion returns the configured Kafka version in the form
+// accepted by `Shopify/sarama`.
+func (p *Proxy) SaramaKafkaVersion() sarama.KafkaVersion {
+	return kafkaVersions[p.Kafka.Version]
+}
+
+// CheckKafkaFeature returns an error caused by ErrKafkaFeatureUnsupported if
+// the feature requires a more recent Kafka version than `kafka.version`.
+func (p *Proxy) CheckKafkaFeature(feature string) error {
+	required, ok := kafkaFeatures[feature]
+	if !ok {
+		return errors.Errorf("unknown kafka feature: %s", feature)
+	}
+	if compareVersions(required, latestKafkaVersion) > 0 {
+		return errors.Wrapf(ErrKafkaFeatureUnsupported,
+			"%s requires Kafka %s or later, but the most recent version supported by Kafka-Pixy is %s",
+			feature, required, latestKafkaVersion)
+	}
+	if compareVersions(required, p.Kafka.Version) > 0 {
+		return errors.Wrapf(ErrKafkaFeatureUnsupported,
+			"%s requires Kafka %s or later, but kafka.version is %s",
+			feature, required, p.Kafka.Version)
+	}
+	return nil
+}
+
+// compareVersions compares dot separated version strings, e.g. 0.10.1.0,
+// number by number. It returns -1, 0, or 1 if `lhs` is less, equal, or
+// greater than `rhs` respectively.
+func compareVersions(lhs, rhs string) int {
+	lhsParts, rhsParts := strings.Split(lhs, "."), strings.Split(rhs, ".")
+	for i := 0; i < len(lhsParts) || i < len(rhsParts); i++ {
+		var l, r int
+		if i < len(lhsParts) {
+			l, _ = strconv.Atoi(lhsParts[i])
+		}
+		if i < len(rhsParts) {
+			r, _ = strconv.Atoi(rhsParts[i])
+		}
+		if l != r {
+			if l < r {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
 // DefaultApp returns default application configuration where default proxy has
 // the specified cluster.
 func DefaultApp(cluster string) *App {
@@ -225,14 +1649,61 @@ func FromYAMLFile(filename string) (*App, error) {
 // FromYAML parses configuration from a YAML string and performs basic
 // validation of parameters.
 func FromYAML(data []byte) (*App, error) {
-	var prob proxyProb
+	source := data
+	data, hasSecretRefs, err := secrets.ResolveYAML(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve secrets")
+	}
+	appCfg := newApp()
+	if hasSecretRefs {
+		appCfg.secretsSource = source
+	}
+	prob := proxyProb{
+		GRPCAddr:       appCfg.GRPCAddr,
+		TCPAddr:        appCfg.TCPAddr,
+		UnixAddr:       appCfg.UnixAddr,
+		AdminAddr:      appCfg.AdminAddr,
+		DiagAddr:       appCfg.DiagAddr,
+		ListenerModes:  appCfg.ListenerModes,
+		NetPolicies:    appCfg.NetworkPolicies,
+		AccessLog:      appCfg.AccessLog,
+		SlowConsumers:  appCfg.SlowConsumers,
+		HTTPServer:     appCfg.HTTPServer,
+		GRPCServer:     appCfg.GRPCServer,
+		TLS:            appCfg.TLS,
+		ClientIdentity: appCfg.ClientIdentity,
+		Secrets:        appCfg.Secrets,
+
+		ProxyRegistration: appCfg.ProxyRegistration,
+		Discovery:         appCfg.Discovery,
+	}
 	if err := yaml.Unmarshal(data, &prob); err != nil {
 		return nil, errors.Wrap(err, "failed to parse config")
 	}
-
-	appCfg := newApp()
+	appCfg.GRPCAddr = prob.GRPCAddr
+	appCfg.TCPAddr = prob.TCPAddr
+	appCfg.UnixAddr = prob.UnixAddr
+	appCfg.AdminAddr = prob.AdminAddr
+	appCfg.DiagAddr = prob.DiagAddr
+	appCfg.ListenerModes = prob.ListenerModes
+	appCfg.NetworkPolicies = prob.NetPolicies
+	appCfg.AccessLog = prob.AccessLog
+	appCfg.SlowConsumers = prob.SlowConsumers
+	appCfg.HTTPServer = prob.HTTPServer
+	appCfg.GRPCServer = prob.GRPCServer
+	appCfg.TLS = prob.TLS
+	appCfg.ClientIdentity = prob.ClientIdentity
+	appCfg.Secrets = prob.Secrets
+	appCfg.ProxyRegistration = prob.ProxyRegistration
+	appCfg.Discovery = prob.Discovery
 	clientID := newClientID()
 
+	var encodedProxyDefaults []byte
+	if prob.ProxyDefaults != nil {
+		if encodedProxyDefaults, err = yaml.Marshal(prob.ProxyDefaults); err != nil {
+			panic(err)
+		}
+	}
 	for _, proxyItem := range prob.Proxies {
 		cluster, ok := proxyItem.Key.(string)
 		if !ok {
@@ -245,10 +1716,38 @@ func FromYAML(data []byte) (*App, error) {
 		if err != nil {
 			panic(err)
 		}
+		// The timing preset is applied before anything else, so that
+		// parameters that it sets can be overridden explicitly.
+		defaultsTiming, err := peekTiming(encodedProxyDefaults)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse proxy_defaults")
+		}
+		timing, err := peekTiming(encodedProxyCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse proxy config, cluster=%s", cluster)
+		}
+		if timing == "" {
+			timing = defaultsTiming
+		}
 		proxyCfg := defaultProxyWithClientID(clientID)
+		proxyCfg.applyTiming(timing)
+		if encodedProxyDefaults != nil {
+			// Defaults are unmarshaled twice, rather than copied, for the
+			// proxy section is merged into maps that they have.
+			if err := yaml.Unmarshal(encodedProxyDefaults, proxyCfg); err != nil {
+				return nil, errors.Wrap(err, "failed to parse proxy_defaults")
+			}
+			proxyCfg.inherited = defaultProxyWithClientID(clientID)
+			proxyCfg.inherited.applyTiming(defaultsTiming)
+			if err := yaml.Unmarshal(encodedProxyDefaults, proxyCfg.inherited); err != nil {
+				return nil, errors.Wrap(err, "failed to parse proxy_defaults")
+			}
+			proxyCfg.inherited.inheritRedelivery()
+		}
 		if err := yaml.Unmarshal(encodedProxyCfg, proxyCfg); err != nil {
 			return nil, errors.Wrapf(err, "failed to parse proxy config, cluster=%s", cluster)
 		}
+		proxyCfg.inheritRedelivery()
 		appCfg.Proxies[cluster] = proxyCfg
 		if appCfg.DefaultCluster == "" {
 			appCfg.DefaultCluster = cluster
@@ -261,10 +1760,185 @@ func FromYAML(data []byte) (*App, error) {
 	return appCfg, nil
 }
 
+// ProxyFromYAML parses configuration of a single proxy from a YAML string, the
+// same as a `proxies` entry of a config file, and performs basic validation of
+// parameters. Secret references are not resolved, for the string may come
+// from an API client.
+func ProxyFromYAML(data []byte) (*Proxy, error) {
+	timing, err := peekTiming(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse proxy config")
+	}
+	proxyCfg := DefaultProxy()
+	proxyCfg.applyTiming(timing)
+	if err := yaml.Unmarshal(data, proxyCfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse proxy config")
+	}
+	proxyCfg.inheritRedelivery()
+	if err := proxyCfg.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config parameter")
+	}
+	return proxyCfg, nil
+}
+
+// HasSecretRefs tells whether the config has values referenced with
+// `secretRef`.
+func (a *App) HasSecretRefs() bool {
+	return a.secretsSource != nil
+}
+
+// ResolveSecrets returns the config parsed anew with secret references
+// resolved again.
+func (a *App) ResolveSecrets() (*App, error) {
+	if a.secretsSource == nil {
+		return a, nil
+	}
+	appCfg, err := FromYAML(a.secretsSource)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.envOverrides) != 0 {
+		if err := appCfg.ApplyEnv(a.envOverrides); err != nil {
+			return nil, err
+		}
+	}
+	return appCfg, nil
+}
+
 func (a *App) validate() error {
 	if len(a.Proxies) == 0 {
 		return errors.New("at least on proxy must be configured")
 	}
+	switch a.AccessLog.Format {
+	case AccessLogNone, AccessLogApache, AccessLogJSON:
+	default:
+		return errors.Errorf("Bad access_log.format: %v", a.AccessLog.Format)
+	}
+	for _, mode := range []struct{ name, value string }{
+		{"all", a.ListenerModes.All},
+		{"grpc", a.ListenerModes.GRPC},
+		{"tcp", a.ListenerModes.TCP},
+		{"unix", a.ListenerModes.Unix},
+		{"admin", a.ListenerModes.Admin},
+	} {
+		switch mode.value {
+		case "", ModeConsumeOnly, ModeProduceOnly:
+		default:
+			return errors.Errorf("Bad listener_modes.%s: %v", mode.name, mode.value)
+		}
+	}
+	for _, policy := range []struct {
+		name   string
+		policy NetworkPolicy
+	}{
+		{"all", a.NetworkPolicies.All},
+		{"grpc", a.NetworkPolicies.GRPC},
+		{"tcp", a.NetworkPolicies.TCP},
+		{"unix", a.NetworkPolicies.Unix},
+		{"admin", a.NetworkPolicies.Admin},
+	} {
+		for _, filter := range []struct {
+			name   string
+			filter NetworkFilter
+		}{
+			{"any", policy.policy.Any},
+			{"produce", policy.policy.Produce},
+			{"consume", policy.policy.Consume},
+			{"admin", policy.policy.Admin},
+		} {
+			path := "network_policies." + policy.name + "." + filter.name
+			if err := filter.filter.validate(path); err != nil {
+				return err
+			}
+		}
+	}
+	switch {
+	case a.SlowConsumers.MaxAckLatency < 0:
+		return errors.New("slow_consumers.max_ack_latency must be >= 0")
+	case a.SlowConsumers.MinConsumeRate < 0:
+		return errors.New("slow_consumers.min_consume_rate must be >= 0")
+	case a.SlowConsumers.Window <= 0:
+		return errors.New("slow_consumers.window must be > 0")
+	}
+	switch a.SlowConsumers.Action {
+	case SlowConsumerReport, SlowConsumerShrinkPrefetch, SlowConsumerPause:
+	default:
+		return errors.Errorf("Bad slow_consumers.action: %v", a.SlowConsumers.Action)
+	}
+	switch {
+	case a.HTTPServer.ReadTimeout < 0:
+		return errors.New("http_server.read_timeout must be >= 0")
+	case a.HTTPServer.ReadHeaderTimeout < 0:
+		return errors.New("http_server.read_header_timeout must be >= 0")
+	case a.HTTPServer.WriteTimeout < 0:
+		return errors.New("http_server.write_timeout must be >= 0")
+	case a.HTTPServer.IdleTimeout < 0:
+		return errors.New("http_server.idle_timeout must be >= 0")
+	case a.HTTPServer.HeartbeatInterval < 0:
+		return errors.New("http_server.heartbeat_interval must be >= 0")
+	case a.HTTPServer.MaxConcurrentStreams <= 0:
+		return errors.New("http_server.max_concurrent_streams must be > 0")
+	case a.GRPCServer.MaxRecvMsgSize <= 0:
+		return errors.New("grpc_server.max_recv_msg_size must be > 0")
+	case a.GRPCServer.MaxSendMsgSize < 0:
+		return errors.New("grpc_server.max_send_msg_size must be >= 0")
+	case a.GRPCServer.MaxConcurrentStreams < 0:
+		return errors.New("grpc_server.max_concurrent_streams must be >= 0")
+	}
+	if a.HTTPServer.WriteTimeout > 0 {
+		for cluster, proxyCfg := range a.Proxies {
+			if a.HTTPServer.WriteTimeout <= proxyCfg.Consumer.LongPollingTimeout {
+				return errors.Errorf("http_server.write_timeout must be > consumer.long_polling_timeout, cluster=%s", cluster)
+			}
+		}
+	}
+	switch {
+	case (a.TLS.CertFile == "") != (a.TLS.KeyFile == ""):
+		return errors.New("tls.cert_file and tls.key_file must be given together")
+	case a.TLS.ClientCAFile != "" && a.TLS.CertFile == "":
+		return errors.New("tls.client_ca_file requires tls.cert_file")
+	case len(a.ClientIdentity.Sources) == 0:
+		return errors.New("client_identity.sources must not be empty")
+	case a.ClientIdentity.RequestsPerSecond < 0:
+		return errors.New("client_identity.requests_per_second must be >= 0")
+	case a.Secrets.RefreshInterval < 0:
+		return errors.New("secrets.refresh_interval must be >= 0")
+	}
+	if a.Discovery.Registry != "" {
+		switch a.Discovery.Registry {
+		case RegistryConsul, RegistryEtcd:
+		default:
+			return errors.Errorf("Bad discovery.registry: %v", a.Discovery.Registry)
+		}
+		switch {
+		case a.Discovery.URL == "":
+			return errors.New("discovery.url must not be empty")
+		case a.Discovery.ServiceName == "":
+			return errors.New("discovery.service_name must not be empty")
+		case a.Discovery.AdvertiseAddr == "" && a.TCPAddr == "":
+			return errors.New("discovery.advertise_addr must not be empty")
+		case a.Discovery.TTL <= 0:
+			return errors.New("discovery.ttl must be > 0")
+		case a.Discovery.DeregisterAfter <= 0:
+			return errors.New("discovery.deregister_after must be > 0")
+		}
+		if a.Discovery.AdvertiseAddr != "" {
+			if _, _, err := net.SplitHostPort(a.Discovery.AdvertiseAddr); err != nil {
+				return errors.Errorf("Bad discovery.advertise_addr: %v", a.Discovery.AdvertiseAddr)
+			}
+		}
+	}
+	for _, source := range a.ClientIdentity.Sources {
+		switch source {
+		case IdentityHeader:
+			if a.ClientIdentity.Header == "" {
+				return errors.New("client_identity.header must not be empty")
+			}
+		case IdentityJWTSubject, IdentityTLSCN, IdentityRemoteIP:
+		default:
+			return errors.Errorf("Bad client_identity.sources: %v", source)
+		}
+	}
 	for cluster, proxyCfg := range a.Proxies {
 		if err := proxyCfg.validate(); err != nil {
 			return errors.Wrapf(err, "invalid config, cluster=%s", cluster)
@@ -277,53 +1951,447 @@ func (p *Proxy) validate() error {
 	if _, ok := kafkaVersions[p.Kafka.Version]; !ok {
 		return errors.Errorf("Bad kafka.version: %v", p.Kafka.Version)
 	}
+	if p.ZooKeeper.SessionTimeout <= 0 {
+		return errors.New("zoo_keeper.session_timeout must be > 0")
+	}
+	for param, peers := range map[string][]string{
+		"kafka.seed_peers":               p.Kafka.SeedPeers,
+		"zoo_keeper.seed_peers":          p.ZooKeeper.SeedPeers,
+		"failover.kafka_seed_peers":      p.Failover.KafkaSeedPeers,
+		"failover.zoo_keeper_seed_peers": p.Failover.ZooKeeperSeedPeers,
+	} {
+		for _, peer := range peers {
+			if name, ok := SeedPeerSRV(peer); ok && name == "" {
+				return errors.Errorf("Bad %s: %v", param, peer)
+			}
+		}
+	}
+	if p.DNS.RefreshInterval <= 0 {
+		return errors.New("dns.refresh_interval must be > 0")
+	}
+	if p.DNS.Timeout <= 0 {
+		return errors.New("dns.timeout must be > 0")
+	}
+	for topic, rate := range p.Tap.Rates {
+		if rate <= 0 || rate > 1 {
+			return errors.Errorf("Bad tap.rates: %s=%v", topic, rate)
+		}
+	}
+	if _, ok := p.Tap.Rates[p.Tap.Topic]; ok && p.Tap.Topic != "" {
+		return errors.Errorf("Bad tap.topic: %s is sampled", p.Tap.Topic)
+	}
+	if p.Tap.BufferSize <= 0 {
+		return errors.New("tap.buffer_size must be > 0")
+	}
+	switch p.Coordination.Backend {
+	case CoordinationNone, CoordinationZooKeeper, CoordinationKubernetes:
+	default:
+		return errors.Errorf("Bad coordination.backend: %v", p.Coordination.Backend)
+	}
+	if p.Coordination.LeaseTTL < time.Second {
+		return errors.New("coordination.lease_ttl must be >= 1s")
+	}
+	if len(p.Failover.KafkaSeedPeers) > 0 {
+		switch {
+		case len(p.Failover.ZooKeeperSeedPeers) == 0:
+			return errors.New("failover.zoo_keeper_seed_peers must not be empty")
+		case p.Failover.CheckInterval <= 0:
+			return errors.New("failover.check_interval must be > 0")
+		case p.Failover.CheckTimeout <= 0:
+			return errors.New("failover.check_timeout must be > 0")
+		case p.Failover.FailureThreshold <= 0:
+			return errors.New("failover.failure_threshold must be > 0")
+		case p.Failover.FailBackThreshold <= 0:
+			return errors.New("failover.fail_back_threshold must be > 0")
+		}
+	}
 	// Validate the Producer parameters.
 	switch {
 	case p.Producer.ChannelBufferSize <= 0:
 		return errors.New("producer.channel_buffer_size must be > 0")
+	case p.Producer.DispatchQueueSize < 0:
+		return errors.New("producer.dispatch_queue_size must be >= 0")
 	case p.Producer.FlushBytes < 0:
 		return errors.New("producer.flush_bytes must be >= 0")
 	case p.Producer.FlushFrequency < 0:
 		return errors.New("producer.flush_frequency must be >= 0")
+	case p.Producer.FlushMessages < 0:
+		return errors.New("producer.flush_messages must be >= 0")
+	case p.Producer.FlushMaxMessages < 0:
+		return errors.New("producer.flush_max_messages must be >= 0")
+	case p.Producer.FlushMaxMessages > 0 && p.Producer.FlushMessages > p.Producer.FlushMaxMessages:
+		return errors.New("producer.flush_messages must be <= producer.flush_max_messages")
+	case p.Producer.MaxBufferedMessages < 0:
+		return errors.New("producer.max_buffered_messages must be >= 0")
+	case p.Producer.MaxBufferedBytes < 0:
+		return errors.New("producer.max_buffered_bytes must be >= 0")
+	case p.Producer.MetadataRefreshInterval <= 0:
+		return errors.New("producer.metadata_refresh_interval must be > 0")
+	case p.Producer.MetadataRefreshBackoff < 0:
+		return errors.New("producer.metadata_refresh_backoff must be >= 0")
 	case p.Producer.RetryBackoff <= 0:
 		return errors.New("producer.retry_backoff must be > 0")
 	case p.Producer.RetryMax <= 0:
 		return errors.New("producer.retry_max must be > 0")
 	case p.Producer.ShutdownTimeout < 0:
 		return errors.New("producer.shutdown_timeout must be >= 0")
+	case p.Producer.SlowProduceThreshold < 0:
+		return errors.New("producer.slow_produce_threshold must be >= 0")
 	}
 	if _, ok := compressionCodecs[p.Producer.Compression]; !ok {
 		return errors.Errorf("Bad producer.compression: %v", p.Producer.Compression)
 	}
+	if _, ok := partitioners[p.Producer.Partitioner]; !ok {
+		return errors.Errorf("Bad producer.partitioner: %v", p.Producer.Partitioner)
+	}
+	if p.Producer.StickyPartitioning.Enabled {
+		switch {
+		case p.Producer.StickyPartitioning.MaxMessages <= 0:
+			return errors.New("producer.sticky_partitioning.max_messages must be > 0")
+		case p.Producer.StickyPartitioning.MaxDuration <= 0:
+			return errors.New("producer.sticky_partitioning.max_duration must be > 0")
+		}
+	}
 	if _, ok := producerAcks[p.Producer.RequiredAcks]; !ok {
 		return errors.Errorf("Bad producer.required_acks: %v", p.Producer.RequiredAcks)
 	}
+	for _, acks := range p.Producer.AllowedAcks {
+		if _, ok := producerAcks[acks]; !ok {
+			return errors.Errorf("Bad producer.allowed_acks: %v", acks)
+		}
+	}
+	if p.Producer.StampTimestamps {
+		if err := p.CheckKafkaFeature(KafkaFeatureTimestamps); err != nil {
+			return errors.Wrap(err, "producer.stamp_timestamps")
+		}
+	}
+	switch p.Producer.UnknownTopics {
+	case UnknownTopicsBroker, UnknownTopicsFail:
+	case UnknownTopicsCreate:
+		if p.Producer.AutoCreate.Partitions <= 0 {
+			return errors.New("producer.auto_create.partitions must be > 0")
+		}
+		if p.Producer.AutoCreate.ReplicationFactor <= 0 {
+			return errors.New("producer.auto_create.replication_factor must be > 0")
+		}
+	default:
+		return errors.Errorf("Bad producer.unknown_topics: %v", p.Producer.UnknownTopics)
+	}
+	if p.Producer.Delayed.MaxDelay <= 0 {
+		return errors.New("producer.delayed.max_delay must be > 0")
+	}
+	switch {
+	case p.Producer.Outcomes.Timeout <= 0:
+		return errors.New("producer.outcomes.timeout must be > 0")
+	case p.Producer.Outcomes.QueueSize <= 0:
+		return errors.New("producer.outcomes.queue_size must be > 0")
+	}
+	for i, prefix := range p.Producer.Outcomes.URLPrefixes {
+		if !strings.HasPrefix(prefix, "http://") && !strings.HasPrefix(prefix, "https://") {
+			return errors.Errorf("Bad producer.outcomes.url_prefixes[%d]: %v", i, prefix)
+		}
+	}
 	// Validate the Consumer parameters.
 	switch {
 	case p.Consumer.AckTimeout >= p.Consumer.RegistrationTimeout:
 		return errors.New("consumer.ack_timeout must be < consumer.registration_timeout")
 	case p.Consumer.ChannelBufferSize <= 0:
 		return errors.New("consumer.channel_buffer_size must be > 0")
+	case p.Consumer.ClaimTimeout < 0:
+		return errors.New("consumer.claim_timeout must be >= 0")
+	case !isValidDispatch(p.Consumer.Dispatch):
+		return errors.Errorf("Bad consumer.dispatch: %v", p.Consumer.Dispatch)
 	case p.Consumer.FetchBytes <= 0:
 		return errors.New("consumer.fetch_bytes must be > 0")
+	case p.Consumer.GroupIsolation.MaxTopics < 0:
+		return errors.New("consumer.group_isolation.max_topics must be >= 0")
+	case p.Consumer.HandoffTimeout < 0:
+		return errors.New("consumer.handoff_timeout must be >= 0")
+	case p.Consumer.HandoffTimeout > p.Consumer.AckTimeout:
+		return errors.New("consumer.handoff_timeout must be <= consumer.ack_timeout")
 	case p.Consumer.LongPollingTimeout <= 0:
 		return errors.New("consumer.long_polling_timeout must be > 0")
+	case p.Consumer.MaxCheckpointSize < 1:
+		return errors.New("consumer.max_checkpoint_size must be >= 1")
+	case p.Consumer.MaxClaimSize < 1:
+		return errors.New("consumer.max_claim_size must be >= 1")
+	case p.Consumer.MaxInFlight < 1:
+		return errors.New("consumer.max_in_flight must be >= 1")
+	case p.Consumer.MaxKeyQueueSize < 1:
+		return errors.New("consumer.max_key_queue_size must be >= 1")
+	case p.Consumer.MaxMessageAge < 0:
+		return errors.New("consumer.max_message_age must be >= 0")
+	case p.Consumer.MaxSparseAcksSize < 0:
+		return errors.New("consumer.max_sparse_acks_size must be >= 0")
+	case p.Consumer.MessageBufferSize < 0:
+		return errors.New("consumer.message_buffer_size must be >= 0")
+	case p.Consumer.OffsetReset != OffsetResetEarliest && p.Consumer.OffsetReset != OffsetResetLatest:
+		return errors.Errorf("Bad consumer.offset_reset: %v", p.Consumer.OffsetReset)
+	case p.Consumer.OffsetOutOfRange != OffsetResetEarliest && p.Consumer.OffsetOutOfRange != OffsetResetLatest:
+		return errors.Errorf("Bad consumer.offset_out_of_range: %v", p.Consumer.OffsetOutOfRange)
 	case p.Consumer.OffsetsCommitInterval <= 0:
 		return errors.New("consumer.offsets_commit_interval must be > 0")
+	case p.Consumer.OffsetsCommitMaxBackoff <= 0:
+		return errors.New("consumer.offsets_commit_max_backoff must be > 0")
+	case p.Consumer.OffsetsCommitFailureThreshold <= 0:
+		return errors.New("consumer.offsets_commit_failure_threshold must be > 0")
+	case p.Consumer.PartitionCheckInterval < 0:
+		return errors.New("consumer.partition_check_interval must be >= 0")
+	case p.Consumer.PartitionSettleDelay < 0:
+		return errors.New("consumer.partition_settle_delay must be >= 0")
 	case p.Consumer.RebalanceDelay <= 0:
 		return errors.New("consumer.rebalance_delay must be > 0")
+	case p.Consumer.RebalanceWindow < 0:
+		return errors.New("consumer.rebalance_window must be >= 0")
 	case p.Consumer.RegistrationTimeout <= 0:
 		return errors.New("consumer.registration_timeout must be > 0")
+	case p.Consumer.RequestQueueSize < 0:
+		return errors.New("consumer.request_queue_size must be >= 0")
+	case p.Consumer.Restart.MaxRestarts < 0:
+		return errors.New("consumer.restart.max_restarts must be >= 0")
+	case p.Consumer.Restart.MaxRestarts > 0 && p.Consumer.Restart.Window <= 0:
+		return errors.New("consumer.restart.window must be > 0")
+	case p.Consumer.Restart.Backoff < 0:
+		return errors.New("consumer.restart.backoff must be >= 0")
+	case p.Consumer.Restart.MaxBackoff < p.Consumer.Restart.Backoff:
+		return errors.New("consumer.restart.max_backoff must be >= consumer.restart.backoff")
 	case p.Consumer.RetryBackoff <= 0:
 		return errors.New("consumer.retry_backoff must be > 0")
+	case p.Consumer.Timing != "" && p.Consumer.Timing != TimingShort && p.Consumer.Timing != TimingLong:
+		return errors.Errorf("Bad consumer.timing: %v", p.Consumer.Timing)
+	}
+	if err := p.Consumer.Redelivery.validate("consumer.redelivery"); err != nil {
+		return err
+	}
+	if p.Consumer.EndToEndLatency {
+		if err := p.CheckKafkaFeature(KafkaFeatureTimestamps); err != nil {
+			return errors.Wrap(err, "consumer.end_to_end_latency")
+		}
+	}
+	for topic, dispatch := range p.Consumer.TopicDispatch {
+		if !isValidDispatch(dispatch) {
+			return errors.Errorf("Bad consumer.topic_dispatch.%s: %v", topic, dispatch)
+		}
+	}
+	for topic, maxAge := range p.Consumer.TopicMaxMessageAge {
+		if maxAge < 0 {
+			return errors.Errorf("consumer.topic_max_message_age.%s must be >= 0", topic)
+		}
+	}
+	for topic, redelivery := range p.Consumer.TopicRedelivery {
+		if redelivery == nil {
+			return errors.Errorf("consumer.topic_redelivery.%s must not be empty", topic)
+		}
+		if err := redelivery.validate("consumer.topic_redelivery." + topic); err != nil {
+			return err
+		}
+	}
+	// Validate the Names parameters.
+	for name, rules := range map[string]NameRules{
+		"topic": p.Names.Topic,
+		"group": p.Names.Group,
+	} {
+		if _, err := regexp.Compile(rules.Pattern); err != nil {
+			return errors.Wrapf(err, "names.%s.pattern is invalid", name)
+		}
+		if rules.MaxLength < 0 {
+			return errors.Errorf("names.%s.max_length must be >= 0", name)
+		}
+	}
+	// Validate the TopicACL parameters.
+	for name, filter := range map[string]TopicFilter{
+		"produce": p.TopicACL.Produce,
+		"consume": p.TopicACL.Consume,
+		"admin":   p.TopicACL.Admin,
+	} {
+		for _, pattern := range append(filter.Allow, filter.Deny...) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return errors.Wrapf(err, "topic_acl.%s has invalid pattern", name)
+			}
+		}
+	}
+	// Validate the PartitionPins parameters.
+	pinned := make(map[string]string)
+	for i, pin := range p.PartitionPins {
+		switch {
+		case pin.Group == "":
+			return errors.Errorf("partition_pins[%d].group must not be empty", i)
+		case pin.Topic == "":
+			return errors.Errorf("partition_pins[%d].topic must not be empty", i)
+		case pin.Member == "":
+			return errors.Errorf("partition_pins[%d].member must not be empty", i)
+		case len(pin.Partitions) == 0:
+			return errors.Errorf("partition_pins[%d].partitions must not be empty", i)
+		}
+		for _, partition := range pin.Partitions {
+			if partition < 0 {
+				return errors.Errorf("partition_pins[%d].partitions must be >= 0", i)
+			}
+			key := fmt.Sprintf("%s/%s/%d", pin.Group, pin.Topic, partition)
+			if member, ok := pinned[key]; ok && member != pin.Member {
+				return errors.Errorf("partition_pins: %s pinned to both %s and %s",
+					key, member, pin.Member)
+			}
+			pinned[key] = pin.Member
+		}
+	}
+	// Validate the Routing parameters.
+	if len(p.Routing.Peers) > 0 && p.Routing.Secret == "" {
+		return errors.New("routing.secret must not be empty")
+	}
+	for id, addr := range p.Routing.Peers {
+		if addr == "" {
+			return errors.Errorf("routing.peers.%s must not be empty", id)
+		}
+	}
+	// Validate the Admin parameters.
+	if p.Admin.LagWatchInterval <= 0 {
+		return errors.New("admin.lag_watch_interval must be > 0")
+	}
+	if p.Admin.ZooKeeperCacheTTL < 0 {
+		return errors.New("admin.zoo_keeper_cache_ttl must be >= 0")
+	}
+	if p.Admin.ZooKeeperScanWorkers < 1 {
+		return errors.New("admin.zoo_keeper_scan_workers must be >= 1")
+	}
+	// Validate the Metrics parameters.
+	switch p.Metrics.Backend {
+	case MetricsBackendNone:
+	case MetricsBackendStatsD, MetricsBackendDogStatsD:
+		if p.Metrics.FlushInterval <= 0 {
+			return errors.New("metrics.flush_interval must be > 0")
+		}
+		if p.Metrics.StatsD.Addr == "" {
+			return errors.New("metrics.statsd.addr must not be empty")
+		}
+	default:
+		return errors.Errorf("Bad metrics.backend: %v", p.Metrics.Backend)
+	}
+	// Validate the Alerts parameters.
+	if p.Alerts.CheckInterval <= 0 {
+		return errors.New("alerts.check_interval must be > 0")
+	}
+	ruleNames := make(map[string]bool, len(p.Alerts.Rules))
+	for i, rule := range p.Alerts.Rules {
+		switch {
+		case rule.Name == "":
+			return errors.Errorf("alerts.rules[%d].name must not be empty", i)
+		case ruleNames[rule.Name]:
+			return errors.Errorf("alerts.rules[%d].name must be unique: %s", i, rule.Name)
+		case rule.Group == "":
+			return errors.Errorf("alerts.rules[%d].group must not be empty", i)
+		case rule.Topic == "":
+			return errors.Errorf("alerts.rules[%d].topic must not be empty", i)
+		case rule.Threshold < 0:
+			return errors.Errorf("alerts.rules[%d].threshold must be >= 0", i)
+		case rule.For < 0:
+			return errors.Errorf("alerts.rules[%d].for must be >= 0", i)
+		}
+		switch rule.Kind {
+		case AlertKindLag, AlertKindStall, AlertKindUnassigned, AlertKindRetention:
+		default:
+			return errors.Errorf("Bad alerts.rules[%d].kind: %v", i, rule.Kind)
+		}
+		ruleNames[rule.Name] = true
+	}
+	if p.Alerts.Webhook.URL != "" && p.Alerts.Webhook.Timeout <= 0 {
+		return errors.New("alerts.webhook.timeout must be > 0")
+	}
+	// Validate the KeyIndex parameters.
+	switch {
+	case p.KeyIndex.RefreshInterval <= 0:
+		return errors.New("key_index.refresh_interval must be > 0")
+	case p.KeyIndex.MaxStaleness <= p.KeyIndex.RefreshInterval:
+		return errors.New("key_index.max_staleness must be > key_index.refresh_interval")
+	}
+	for i, topic := range p.KeyIndex.Topics {
+		if topic == "" {
+			return errors.Errorf("key_index.topics[%d] must not be empty", i)
+		}
+	}
+	// Validate the WarmUp parameters.
+	if p.WarmUp.Timeout <= 0 {
+		return errors.New("warm_up.timeout must be > 0")
+	}
+	for i, topic := range p.WarmUp.Topics {
+		if topic == "" {
+			return errors.Errorf("warm_up.topics[%d] must not be empty", i)
+		}
+	}
+	for i, wc := range p.WarmUp.Consumers {
+		switch {
+		case wc.Group == "":
+			return errors.Errorf("warm_up.consumers[%d].group must not be empty", i)
+		case wc.Topic == "":
+			return errors.Errorf("warm_up.consumers[%d].topic must not be empty", i)
+		}
+	}
+	// Validate the Tenants parameters.
+	tokens := make(map[string]string)
+	for name, tenant := range p.Tenants {
+		if tenant == nil || len(tenant.Tokens) == 0 {
+			return errors.Errorf("tenants.%s.tokens must not be empty", name)
+		}
+		for _, token := range tenant.Tokens {
+			if otherName, ok := tokens[token]; ok {
+				return errors.Errorf("tenants.%s.tokens must be unique, shared with %s", name, otherName)
+			}
+			tokens[token] = name
+		}
+		if tenant.RequestsPerSecond < 0 {
+			return errors.Errorf("tenants.%s.requests_per_second must be >= 0", name)
+		}
+	}
+	if len(p.OperatorTokens) != 0 && len(p.Tenants) == 0 {
+		return errors.New("operator_tokens requires tenants to be configured")
+	}
+	for i, token := range p.OperatorTokens {
+		if token == "" {
+			return errors.Errorf("operator_tokens[%d] must not be empty", i)
+		}
+		if name, ok := tokens[token]; ok {
+			return errors.Errorf("operator_tokens[%d] must not be shared with tenant %s", i, name)
+		}
+	}
+	// Validate the InMemory parameters.
+	if p.InMemory.Enabled {
+		if p.InMemory.Partitions <= 0 {
+			return errors.New("in_memory.partitions must be > 0")
+		}
+		for topic, partitions := range p.InMemory.Topics {
+			if partitions <= 0 {
+				return errors.Errorf("in_memory.topics.%s must be > 0", topic)
+			}
+		}
 	}
 	return nil
 }
 
+func isValidDispatch(dispatch string) bool {
+	return dispatch == DispatchPartition || dispatch == DispatchUnordered || dispatch == DispatchKey
+}
+
 func newApp() *App {
 	appCfg := &App{}
 	appCfg.GRPCAddr = "0.0.0.0:19091"
 	appCfg.TCPAddr = "0.0.0.0:19092"
+	appCfg.AccessLog.Format = AccessLogNone
+	appCfg.SlowConsumers.Window = time.Minute
+	appCfg.SlowConsumers.Action = SlowConsumerReport
+	appCfg.HTTPServer.ReadHeaderTimeout = 10 * time.Second
+	appCfg.HTTPServer.IdleTimeout = 2 * time.Minute
+	appCfg.HTTPServer.KeepAlives = true
+	appCfg.HTTPServer.HeartbeatInterval = 15 * time.Second
+	appCfg.HTTPServer.HTTP2 = true
+	appCfg.HTTPServer.MaxConcurrentStreams = 250
+	appCfg.GRPCServer.MaxRecvMsgSize = 1024 * 1024
+	appCfg.ClientIdentity.Sources = []string{IdentityHeader}
+	appCfg.ClientIdentity.Header = "X-Kafka-Pixy-Client-ID"
+	appCfg.Secrets.RefreshInterval = 5 * time.Minute
+	appCfg.Discovery.ServiceName = "kafka-pixy"
+	appCfg.Discovery.TTL = 15 * time.Second
+	appCfg.Discovery.DeregisterAfter = time.Minute
+	appCfg.Discovery.EtcdPrefix = "/services"
 	appCfg.Proxies = make(map[string]*Proxy)
 	return appCfg
 }
@@ -332,8 +2400,25 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c := &Proxy{}
 	c.ClientID = clientID
 	c.ZooKeeper.SeedPeers = []string{"localhost:2181"}
+	// ZooKeeper documentation says following about the session timeout: "The
+	// current (ZooKeeper) implementation requires that the timeout be a
+	// minimum of 2 times the tickTime (as set in the server configuration) and
+	// a maximum of 20 times the tickTime". The default tickTime is 2 seconds.
+	// See http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#ch_zkSessions
+	c.ZooKeeper.SessionTimeout = 15 * time.Second
 
 	c.Kafka.SeedPeers = []string{"localhost:9092"}
+	c.DNS.RefreshInterval = 30 * time.Second
+	c.DNS.Timeout = 5 * time.Second
+	c.Tap.BufferSize = 256
+	c.Coordination.Backend = CoordinationNone
+	c.Coordination.LeaseTTL = 15 * time.Second
+	c.Coordination.Kubernetes.TokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	c.Coordination.Kubernetes.CAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	c.Failover.CheckInterval = 10 * time.Second
+	c.Failover.CheckTimeout = 5 * time.Second
+	c.Failover.FailureThreshold = 3
+	c.Failover.FailBackThreshold = 6
 	c.Kafka.Version = defaultKafkaVersion
 	// If a valid Kafka version provided in an environment variable then use it
 	// as the default value. This logic is only needed in tests.
@@ -344,24 +2429,105 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 
 	c.Producer.ChannelBufferSize = 4096
 	c.Producer.Compression = defaultCompression
+	c.Producer.Partitioner = PartitionerHash
+	c.Producer.StickyPartitioning.MaxMessages = 1000
+	c.Producer.StickyPartitioning.MaxDuration = 500 * time.Millisecond
 	c.Producer.FlushFrequency = 500 * time.Millisecond
 	c.Producer.FlushBytes = 1024 * 1024
+	c.Producer.MetadataRefreshInterval = 10 * time.Minute
+	c.Producer.MetadataRefreshBackoff = time.Second
 	c.Producer.RequiredAcks = defaultRequiredAcks
 	c.Producer.RetryBackoff = 10 * time.Second
 	c.Producer.RetryMax = 6
 	c.Producer.ShutdownTimeout = 30 * time.Second
+	c.Producer.SlowProduceThreshold = time.Second
+	c.Producer.UnknownTopics = UnknownTopicsBroker
+	c.Producer.AutoCreate.Partitions = 1
+	c.Producer.AutoCreate.ReplicationFactor = 1
+	c.Producer.Delayed.MaxDelay = 7 * 24 * time.Hour
+	c.Producer.Outcomes.Timeout = 5 * time.Second
+	c.Producer.Outcomes.QueueSize = 1000
 
 	c.Consumer.AckTimeout = 15 * time.Second
 	c.Consumer.ChannelBufferSize = 64
 	c.Consumer.FetchBytes = 1024 * 1024
 	c.Consumer.LongPollingTimeout = 3 * time.Second
+	c.Consumer.Dispatch = DispatchPartition
+	c.Consumer.MaxCheckpointSize = 256
+	c.Consumer.MaxClaimSize = 1
+	c.Consumer.MaxInFlight = 1000
+	c.Consumer.MaxKeyQueueSize = 100
+	c.Consumer.MaxSparseAcksSize = 4000
+	c.Consumer.OffsetReset = OffsetResetLatest
+	c.Consumer.OffsetOutOfRange = OffsetResetEarliest
+	c.Consumer.Redelivery.BackoffFactor = 1
 	c.Consumer.OffsetsCommitInterval = 500 * time.Millisecond
+	c.Consumer.OffsetsCommitMaxBackoff = 10 * time.Second
+	c.Consumer.OffsetsCommitFailureThreshold = 30 * time.Second
+	c.Consumer.PartitionCheckInterval = 30 * time.Second
+	c.Consumer.PartitionSettleDelay = 30 * time.Second
 	c.Consumer.RebalanceDelay = 250 * time.Millisecond
 	c.Consumer.RegistrationTimeout = 20 * time.Second
+	c.Consumer.Restart.MaxRestarts = 3
+	c.Consumer.Restart.Window = time.Minute
+	c.Consumer.Restart.Backoff = time.Second
+	c.Consumer.Restart.MaxBackoff = 10 * time.Second
 	c.Consumer.RetryBackoff = 500 * time.Millisecond
+
+	c.Names.Topic.Pattern = "[a-zA-Z0-9._-]+"
+	c.Names.Topic.MaxLength = 249
+
+	c.InMemory.Partitions = 1
+
+	c.Admin.LagWatchInterval = 5 * time.Second
+	c.Admin.ZooKeeperScanWorkers = 16
+
+	c.Metrics.Backend = MetricsBackendNone
+	c.Metrics.FlushInterval = 10 * time.Second
+	c.Metrics.StatsD.Addr = "localhost:8125"
+	c.Metrics.StatsD.Prefix = "kafka_pixy."
+
+	c.Alerts.CheckInterval = 30 * time.Second
+	c.Alerts.Webhook.Timeout = 5 * time.Second
+
+	c.KeyIndex.RefreshInterval = time.Second
+	c.KeyIndex.MaxStaleness = 30 * time.Second
+
+	c.WarmUp.Timeout = time.Minute
 	return c
 }
 
+// peekTiming returns the `consumer.timing` parameter of an encoded proxy
+// config, that can be nil.
+func peekTiming(data []byte) (string, error) {
+	var prob struct {
+		Consumer struct {
+			Timing string `yaml:"timing"`
+		} `yaml:"consumer"`
+	}
+	if err := yaml.Unmarshal(data, &prob); err != nil {
+		return "", err
+	}
+	return prob.Consumer.Timing, nil
+}
+
+// applyTiming sets consumer group membership timings of a preset. An unknown
+// preset is left for validate to report.
+func (p *Proxy) applyTiming(timing string) {
+	switch timing {
+	case TimingShort:
+		p.ZooKeeper.SessionTimeout = 6 * time.Second
+		p.Consumer.RebalanceDelay = 50 * time.Millisecond
+		p.Consumer.RegistrationTimeout = 5 * time.Second
+		p.Consumer.AckTimeout = 4 * time.Second
+		p.Consumer.HandoffTimeout = 4 * time.Second
+	case TimingLong:
+		p.ZooKeeper.SessionTimeout = 30 * time.Second
+		p.Consumer.RebalanceDelay = 2 * time.Second
+		p.Consumer.RegistrationTimeout = time.Minute
+	}
+}
+
 // newClientID creates a unique id that identifies this particular Kafka-Pixy
 // in both Kafka and ZooKeeper.
 func newClientID() string {
@@ -405,5 +2571,24 @@ func getIP() (net.IP, error) {
 }
 
 type proxyProb struct {
-	Proxies yaml.MapSlice
+	GRPCAddr       string          `yaml:"grpc_addr"`
+	TCPAddr        string          `yaml:"tcp_addr"`
+	UnixAddr       string          `yaml:"unix_addr"`
+	AdminAddr      string          `yaml:"admin_addr"`
+	DiagAddr       string          `yaml:"diag_addr"`
+	ListenerModes  ListenerModes   `yaml:"listener_modes"`
+	NetPolicies    NetworkPolicies `yaml:"network_policies"`
+	AccessLog      AccessLog       `yaml:"access_log"`
+	SlowConsumers  SlowConsumers   `yaml:"slow_consumers"`
+	HTTPServer     HTTPServer      `yaml:"http_server"`
+	GRPCServer     GRPCServer      `yaml:"grpc_server"`
+	TLS            TLS             `yaml:"tls"`
+	ClientIdentity ClientIdentity  `yaml:"client_identity"`
+	Secrets        Secrets         `yaml:"secrets"`
+
+	ProxyRegistration bool      `yaml:"proxy_registration"`
+	Discovery         Discovery `yaml:"discovery"`
+
+	ProxyDefaults yaml.MapSlice `yaml:"proxy_defaults"`
+	Proxies       yaml.MapSlice
 }
@@ -1,9 +1,11 @@
 package config
 
 import (
+	"os"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	. "gopkg.in/check.v1"
 )
 
@@ -58,6 +60,381 @@ func (s *ConfigSuite) TestFromYAML(c *C) {
 	c.Assert(appCfg, DeepEquals, expected)
 }
 
+// Server addresses are read from the top level of the YAML data.
+func (s *ConfigSuite) TestFromYAMLAddrs(c *C) {
+	data := []byte("" +
+		"grpc_addr: 0.0.0.0:29091\n" +
+		"diag_addr: localhost:19093\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.GRPCAddr, Equals, "0.0.0.0:29091")
+	c.Assert(appCfg.TCPAddr, Equals, "0.0.0.0:19092")
+	c.Assert(appCfg.UnixAddr, Equals, "")
+	c.Assert(appCfg.DiagAddr, Equals, "localhost:19093")
+}
+
+func (s *ConfigSuite) TestFromYAMLAccessLog(c *C) {
+	data := []byte("" +
+		"access_log:\n" +
+		"  format: json\n" +
+		"  path: /var/log/kafka-pixy/access.log\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.AccessLog, DeepEquals, AccessLog{Format: AccessLogJSON, Path: "/var/log/kafka-pixy/access.log"})
+}
+
+func (s *ConfigSuite) TestFromYAMLAccessLogInvalid(c *C) {
+	data := []byte("" +
+		"access_log:\n" +
+		"  format: xml\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, ".*Bad access_log.format: xml.*")
+}
+
+// Slow consumer window defaults are preserved if not overridden.
+func (s *ConfigSuite) TestFromYAMLSlowConsumers(c *C) {
+	data := []byte("" +
+		"slow_consumers:\n" +
+		"  max_ack_latency: 30s\n" +
+		"  action: pause\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.SlowConsumers, DeepEquals, SlowConsumers{
+		MaxAckLatency: 30 * time.Second,
+		Window:        time.Minute,
+		Action:        SlowConsumerPause,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLSlowConsumersInvalid(c *C) {
+	data := []byte("" +
+		"slow_consumers:\n" +
+		"  action: kill\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, ".*Bad slow_consumers.action: kill.*")
+}
+
+// HTTP server defaults are preserved if not overridden.
+func (s *ConfigSuite) TestFromYAMLHTTPServer(c *C) {
+	data := []byte("" +
+		"http_server:\n" +
+		"  write_timeout: 1m\n" +
+		"  h2c: true\n" +
+		"  max_concurrent_streams: 1000\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.HTTPServer, DeepEquals, HTTPServer{
+		ReadHeaderTimeout:    10 * time.Second,
+		WriteTimeout:         time.Minute,
+		IdleTimeout:          2 * time.Minute,
+		KeepAlives:           true,
+		HeartbeatInterval:    15 * time.Second,
+		HTTP2:                true,
+		H2C:                  true,
+		MaxConcurrentStreams: 1000,
+	})
+}
+
+// gRPC server defaults are preserved if not overridden.
+func (s *ConfigSuite) TestFromYAMLGRPCServer(c *C) {
+	data := []byte("" +
+		"grpc_server:\n" +
+		"  max_send_msg_size: 4194304\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.GRPCServer, DeepEquals, GRPCServer{
+		MaxRecvMsgSize: 1024 * 1024,
+		MaxSendMsgSize: 4 * 1024 * 1024,
+	})
+}
+
+// The write timeout must not cut long polls short.
+func (s *ConfigSuite) TestFromYAMLHTTPServerWriteTimeout(c *C) {
+	data := []byte("" +
+		"http_server:\n" +
+		"  write_timeout: 3s\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, ".*http_server.write_timeout must be > consumer.long_polling_timeout, cluster=default.*")
+}
+
+// Key indexes have to catch up with their topics more often than their
+// staleness bound.
+func (s *ConfigSuite) TestFromYAMLKeyIndex(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n" +
+		"    key_index:\n" +
+		"      topics: [config.features]\n" +
+		"      refresh_interval: 1m\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, ".*key_index.max_staleness must be > key_index.refresh_interval.*")
+}
+
+// Produce requests may only ask for acknowledgement levels that exist.
+func (s *ConfigSuite) TestFromYAMLAllowedAcks(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n" +
+		"    producer:\n" +
+		"      allowed_acks:\n" +
+		"        - wait_for_local\n" +
+		"        - wait_for_nothing\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, ".*Bad producer.allowed_acks: wait_for_nothing.*")
+}
+
+func (s *ConfigSuite) TestFromYAMLClientIdentity(c *C) {
+	data := []byte("" +
+		"client_identity:\n" +
+		"  sources:\n" +
+		"    - tls_cn\n" +
+		"    - remote_ip\n" +
+		"  requests_per_second: 100\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.ClientIdentity, DeepEquals, ClientIdentity{
+		Sources:           []string{IdentityTLSCN, IdentityRemoteIP},
+		Header:            "X-Kafka-Pixy-Client-ID",
+		RequestsPerSecond: 100,
+	})
+}
+
+func (s *ConfigSuite) TestProxyFromYAML(c *C) {
+	// When
+	proxyCfg, err := ProxyFromYAML([]byte(`{"kafka": {"seed_peers": ["k1:9092"]}, "producer": {"retry_max": 5}}`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(proxyCfg.Kafka.SeedPeers, DeepEquals, []string{"k1:9092"})
+	c.Assert(proxyCfg.Producer.RetryMax, Equals, 5)
+	c.Assert(proxyCfg.Producer.RequiredAcks, Equals, defaultRequiredAcks)
+
+	// When
+	_, err = ProxyFromYAML([]byte("producer:\n  retry_max: 0\n"))
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: producer.retry_max must be > 0")
+}
+
+// Secret references are resolved, and can be resolved again to pick up
+// rotated values.
+func (s *ConfigSuite) TestFromYAMLSecretRefs(c *C) {
+	os.Setenv("CONFIG_TEST_TOKEN", "token1")
+	defer os.Unsetenv("CONFIG_TEST_TOKEN")
+	data := []byte("" +
+		"proxies:\n" +
+		"  bar:\n" +
+		"    client_id: foo\n" +
+		"    tenants:\n" +
+		"      t1:\n" +
+		"        tokens: [{secretRef: \"env:CONFIG_TEST_TOKEN\"}]\n" +
+		"  bazz:\n" +
+		"    client_id: foo\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.HasSecretRefs(), Equals, true)
+	c.Assert(appCfg.DefaultCluster, Equals, "bar")
+	c.Assert(appCfg.Proxies["bar"].Tenants["t1"].Tokens, DeepEquals, []string{"token1"})
+
+	// When
+	os.Setenv("CONFIG_TEST_TOKEN", "token2")
+	appCfg, err = appCfg.ResolveSecrets()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.Proxies["bar"].Tenants["t1"].Tokens, DeepEquals, []string{"token2"})
+}
+
+func (s *ConfigSuite) TestFromYAMLClientIdentityInvalid(c *C) {
+	for i, tc := range []struct {
+		yaml   string
+		errMsg string
+	}{{
+		yaml:   "client_identity:\n  sources:\n    - cookie\n",
+		errMsg: ".*Bad client_identity.sources: cookie.*",
+	}, {
+		yaml:   "client_identity:\n  header: \"\"\n",
+		errMsg: ".*client_identity.header must not be empty.*",
+	}, {
+		yaml:   "tls:\n  cert_file: /etc/kafka-pixy/cert.pem\n",
+		errMsg: ".*tls.cert_file and tls.key_file must be given together.*",
+	}} {
+		data := []byte(tc.yaml +
+			"proxies:\n" +
+			"  default:\n" +
+			"    client_id: foo\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLDiscoveryInvalid(c *C) {
+	for i, tc := range []struct {
+		yaml   string
+		errMsg string
+	}{{
+		yaml:   "discovery:\n  registry: zookeeper\n",
+		errMsg: ".*Bad discovery.registry: zookeeper.*",
+	}, {
+		yaml:   "discovery:\n  registry: consul\n",
+		errMsg: ".*discovery.url must not be empty.*",
+	}, {
+		yaml:   "discovery:\n  registry: etcd\n  url: http://127.0.0.1:2379\n  advertise_addr: pixy.example.com\n",
+		errMsg: ".*Bad discovery.advertise_addr: pixy.example.com.*",
+	}, {
+		yaml:   "discovery:\n  registry: consul\n  url: http://127.0.0.1:8500\n  ttl: 0s\n",
+		errMsg: ".*discovery.ttl must be > 0.*",
+	}} {
+		data := []byte(tc.yaml +
+			"proxies:\n" +
+			"  default:\n" +
+			"    client_id: foo\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLCoordinationInvalid(c *C) {
+	for i, tc := range []struct {
+		yaml   string
+		errMsg string
+	}{{
+		yaml:   "    coordination:\n      backend: etcd\n",
+		errMsg: ".*Bad coordination.backend: etcd.*",
+	}, {
+		yaml:   "    coordination:\n      backend: kubernetes\n      lease_ttl: 500ms\n",
+		errMsg: ".*coordination.lease_ttl must be >= 1s.*",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  default:\n" +
+			"    client_id: foo\n" +
+			tc.yaml)
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLTapInvalid(c *C) {
+	for i, tc := range []struct {
+		yaml   string
+		errMsg string
+	}{{
+		yaml:   "    tap:\n      rates:\n        foo: 1.5\n",
+		errMsg: ".*Bad tap.rates: foo=1.5.*",
+	}, {
+		yaml:   "    tap:\n      rates:\n        foo: 0\n",
+		errMsg: ".*Bad tap.rates: foo=0.*",
+	}, {
+		yaml:   "    tap:\n      rates:\n        foo: 1\n      topic: foo\n",
+		errMsg: ".*Bad tap.topic: foo is sampled.*",
+	}, {
+		yaml:   "    tap:\n      buffer_size: 0\n",
+		errMsg: ".*tap.buffer_size must be > 0.*",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  default:\n" +
+			"    client_id: foo\n" +
+			tc.yaml)
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
 // If YAML data is invalid then the original config is not changed.
 func (s *ConfigSuite) TestFromYAMLInvalid(c *C) {
 	data := []byte("" +
@@ -115,3 +492,665 @@ func (s *ConfigSuite) TestFromYAMLFile(c *C) {
 	appCfg.Proxies["default"].ClientID = "ID"
 	c.Assert(appCfg, DeepEquals, expected)
 }
+
+// Tokens cannot be shared by several tenants.
+func (s *ConfigSuite) TestFromYAMLTenantsSharedToken(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    tenants:\n" +
+		"      a:\n" +
+		"        tokens: [foo]\n" +
+		"      b:\n" +
+		"        tokens: [bar, foo]\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"tenants.[ab].tokens must be unique, shared with [ab]")
+}
+
+func (s *ConfigSuite) TestFromYAMLOperatorTokensInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg string
+		err string
+	}{{
+		cfg: "    operator_tokens: [foo]\n",
+		err: "operator_tokens requires tenants to be configured",
+	}, {
+		cfg: "" +
+			"    tenants:\n" +
+			"      a:\n" +
+			"        tokens: [foo]\n" +
+			"    operator_tokens: [bar, \"\"]\n",
+		err: "operator_tokens\\[1\\] must not be empty",
+	}, {
+		cfg: "" +
+			"    tenants:\n" +
+			"      a:\n" +
+			"        tokens: [foo]\n" +
+			"    operator_tokens: [foo]\n",
+		err: "operator_tokens\\[0\\] must not be shared with tenant a",
+	}} {
+		data := []byte("proxies:\n  default:\n" + tc.cfg)
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+tc.err,
+			Commentf("case #%d", i))
+	}
+}
+
+// A partition cannot be pinned to several members.
+func (s *ConfigSuite) TestFromYAMLPartitionPinConflict(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    partition_pins:\n" +
+		"      - {group: g, topic: t, partitions: [0, 1], member: a}\n" +
+		"      - {group: g, topic: t, partitions: [1], member: b}\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"partition_pins: g/t/1 pinned to both a and b")
+}
+
+// Auto-created topics must have a positive number of partitions.
+func (s *ConfigSuite) TestFromYAMLAutoCreateInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    producer:\n" +
+		"      unknown_topics: create\n" +
+		"      auto_create:\n" +
+		"        partitions: 0\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"producer.auto_create.partitions must be > 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLProducerBuffering(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    producer:\n" +
+		"      flush_messages: 100\n" +
+		"      flush_max_messages: 500\n" +
+		"      max_buffered_messages: 10000\n" +
+		"      max_buffered_bytes: 67108864\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	saramaCfg := appCfg.Proxies["default"].SaramaProdCfg()
+	c.Assert(saramaCfg.Producer.Flush.Messages, Equals, 100)
+	c.Assert(saramaCfg.Producer.Flush.MaxMessages, Equals, 500)
+	c.Assert(appCfg.Proxies["default"].Producer.MaxBufferedMessages, Equals, 10000)
+	c.Assert(appCfg.Proxies["default"].Producer.MaxBufferedBytes, Equals, 67108864)
+}
+
+func (s *ConfigSuite) TestFromYAMLProducerBufferingInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    producer:\n" +
+		"      flush_messages: 100\n" +
+		"      flush_max_messages: 50\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"producer.flush_messages must be <= producer.flush_max_messages")
+}
+
+func (s *ConfigSuite) TestFromYAMLPartitioner(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    producer:\n" +
+		"      partitioner: murmur3\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, ".*Bad producer.partitioner: murmur3.*")
+}
+
+func (s *ConfigSuite) TestFromYAMLStickyPartitioningInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    producer:\n" +
+		"      sticky_partitioning:\n" +
+		"        enabled: true\n" +
+		"        max_messages: 0\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"producer.sticky_partitioning.max_messages must be > 0")
+}
+
+func (s *ConfigSuite) TestTopicRedelivery(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      topic_redelivery:\n" +
+		"        foo:\n" +
+		"          backoff: 1s\n" +
+		"          backoff_factor: 2\n" +
+		"          max_backoff: 5s\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+	c.Assert(err, IsNil)
+
+	// Then
+	cfg := appCfg.Proxies["default"]
+	foo := cfg.TopicRedelivery("foo")
+	c.Assert(foo.Delay(1), Equals, 1*time.Second)
+	c.Assert(foo.Delay(2), Equals, 2*time.Second)
+	c.Assert(foo.Delay(3), Equals, 4*time.Second)
+	c.Assert(foo.Delay(4), Equals, 5*time.Second)
+	c.Assert(foo.Delay(100), Equals, 5*time.Second)
+	bar := cfg.TopicRedelivery("bar")
+	c.Assert(bar.Delay(3), Equals, time.Duration(0))
+}
+
+// Parameters that a topic override does not give are inherited from
+// consumer.redelivery, and those that it gives explicitly are kept, even if
+// they are zero.
+func (s *ConfigSuite) TestTopicRedeliveryPartial(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      redelivery:\n" +
+		"        backoff: 1s\n" +
+		"        backoff_factor: 3\n" +
+		"        max_backoff: 1m\n" +
+		"        dead_letter_topic: dlq\n" +
+		"        max_attempts: 5\n" +
+		"      topic_redelivery:\n" +
+		"        foo:\n" +
+		"          backoff: 2s\n" +
+		"        bar:\n" +
+		"          max_backoff: 0s\n" +
+		"          dead_letter_topic: \"\"\n" +
+		"          max_attempts: 0\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	cfg := appCfg.Proxies["default"]
+	foo := cfg.TopicRedelivery("foo")
+	c.Assert(foo.Backoff, Equals, 2*time.Second)
+	c.Assert(foo.BackoffFactor, Equals, float64(3))
+	c.Assert(foo.MaxBackoff, Equals, time.Minute)
+	c.Assert(foo.DeadLetterTopic, Equals, "dlq")
+	c.Assert(foo.MaxAttempts, Equals, 5)
+	bar := cfg.TopicRedelivery("bar")
+	c.Assert(bar.Backoff, Equals, time.Second)
+	c.Assert(bar.BackoffFactor, Equals, float64(3))
+	c.Assert(bar.MaxBackoff, Equals, time.Duration(0))
+	c.Assert(bar.DeadLetterTopic, Equals, "")
+	c.Assert(bar.MaxAttempts, Equals, 0)
+}
+
+func (s *ConfigSuite) TestFromYAMLTopicRedeliveryInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      topic_redelivery:\n" +
+		"        foo:\n" +
+		"          backoff: 1s\n" +
+		"          backoff_factor: 0.5\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"consumer.topic_redelivery.foo.backoff_factor must be >= 1")
+}
+
+// Messages that run out of attempts are produced to the dead letter topic,
+// so there has to be one, including the one inherited by topic overrides.
+func (s *ConfigSuite) TestFromYAMLRedeliveryMaxAttemptsInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg string
+		err string
+	}{{
+		cfg: "" +
+			"      redelivery:\n" +
+			"        max_attempts: 3\n",
+		err: "consumer.redelivery.max_attempts requires dead_letter_topic",
+	}, {
+		cfg: "" +
+			"      redelivery:\n" +
+			"        max_attempts: -1\n",
+		err: "consumer.redelivery.max_attempts must be >= 0",
+	}, {
+		cfg: "" +
+			"      redelivery:\n" +
+			"        dead_letter_topic: dlq\n" +
+			"        max_attempts: 3\n" +
+			"      topic_redelivery:\n" +
+			"        foo:\n" +
+			"          dead_letter_topic: \"\"\n",
+		err: "consumer.topic_redelivery.foo.max_attempts requires dead_letter_topic",
+	}} {
+		data := []byte("proxies:\n  default:\n    consumer:\n" + tc.cfg)
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+tc.err,
+			Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestTopicDispatch(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      topic_dispatch:\n" +
+		"        foo: unordered\n" +
+		"        baz: key\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+	c.Assert(err, IsNil)
+
+	// Then
+	cfg := appCfg.Proxies["default"]
+	c.Assert(cfg.TopicDispatch("foo"), Equals, DispatchUnordered)
+	c.Assert(cfg.TopicDispatch("bar"), Equals, DispatchPartition)
+	c.Assert(cfg.TopicDispatch("baz"), Equals, DispatchKey)
+	c.Assert(cfg.Consumer.MaxInFlight, Equals, 1000)
+	c.Assert(cfg.Consumer.MaxKeyQueueSize, Equals, 100)
+}
+
+func (s *ConfigSuite) TestFromYAMLTopicDispatchInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      topic_dispatch:\n" +
+		"        foo: random\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"Bad consumer.topic_dispatch.foo: random")
+}
+
+// A listener mode applies to listeners that do not have one of their own.
+func (s *ConfigSuite) TestFromYAMLListenerModes(c *C) {
+	data := []byte("" +
+		"listener_modes:\n" +
+		"  all: consume_only\n" +
+		"  unix: produce_only\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    kafka:\n" +
+		"      seed_peers: [\"localhost:9092\"]\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.ListenerModes.For(appCfg.ListenerModes.TCP), Equals, ModeConsumeOnly)
+	c.Assert(appCfg.ListenerModes.For(appCfg.ListenerModes.Unix), Equals, ModeProduceOnly)
+}
+
+func (s *ConfigSuite) TestFromYAMLListenerModeInvalid(c *C) {
+	data := []byte("" +
+		"listener_modes:\n" +
+		"  grpc: read_only\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    kafka:\n" +
+		"      seed_peers: [\"localhost:9092\"]\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: Bad listener_modes.grpc: read_only")
+}
+
+func (s *ConfigSuite) TestFromYAMLNetworkPolicyInvalid(c *C) {
+	data := []byte("" +
+		"network_policies:\n" +
+		"  tcp:\n" +
+		"    admin:\n" +
+		"      allow: [\"10.0.0.0/8\", \"10.0.0.0/33\"]\n" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    kafka:\n" +
+		"      seed_peers: [\"localhost:9092\"]\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: Bad network_policies.tcp.admin.allow: 10.0.0.0/33")
+}
+
+// A timing preset sets membership timings, unless they are given explicitly,
+// and a proxy can pick a different preset than proxy_defaults.
+func (s *ConfigSuite) TestFromYAMLTiming(c *C) {
+	data := []byte("" +
+		"proxy_defaults:\n" +
+		"  consumer:\n" +
+		"    timing: long\n" +
+		"proxies:\n" +
+		"  cli:\n" +
+		"    consumer:\n" +
+		"      timing: short\n" +
+		"      rebalance_delay: 10ms\n" +
+		"  service:\n" +
+		"    kafka:\n" +
+		"      seed_peers: [\"localhost:9092\"]\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	cli := appCfg.Proxies["cli"]
+	c.Assert(cli.ZooKeeper.SessionTimeout, Equals, 6*time.Second)
+	c.Assert(cli.Consumer.RebalanceDelay, Equals, 10*time.Millisecond)
+	c.Assert(cli.Consumer.RegistrationTimeout, Equals, 5*time.Second)
+	c.Assert(cli.Consumer.AckTimeout, Equals, 4*time.Second)
+	c.Assert(cli.KazooCfg().Timeout, Equals, 6*time.Second)
+	service := appCfg.Proxies["service"]
+	c.Assert(service.ZooKeeper.SessionTimeout, Equals, 30*time.Second)
+	c.Assert(service.Consumer.RebalanceDelay, Equals, 2*time.Second)
+	c.Assert(service.Consumer.RegistrationTimeout, Equals, time.Minute)
+	c.Assert(service.Consumer.AckTimeout, Equals, 15*time.Second)
+}
+
+// Unless handoff_timeout is given explicitly, it defaults to 10s or
+// ack_timeout, whichever is less.
+func (s *ConfigSuite) TestHandoffTimeoutDefault(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  short:\n" +
+		"    consumer:\n" +
+		"      ack_timeout: 3s\n" +
+		"  long:\n" +
+		"    consumer:\n" +
+		"      ack_timeout: 15s\n" +
+		"  explicit:\n" +
+		"    consumer:\n" +
+		"      ack_timeout: 15s\n" +
+		"      handoff_timeout: 12s\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.Proxies["short"].ConsumerHandoffTimeout(), Equals, 3*time.Second)
+	c.Assert(appCfg.Proxies["long"].ConsumerHandoffTimeout(), Equals, 10*time.Second)
+	c.Assert(appCfg.Proxies["explicit"].ConsumerHandoffTimeout(), Equals, 12*time.Second)
+}
+
+func (s *ConfigSuite) TestHandoffTimeoutAboveAckTimeout(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      ack_timeout: 3s\n" +
+		"      handoff_timeout: 5s\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"consumer.handoff_timeout must be <= consumer.ack_timeout")
+}
+
+func (s *ConfigSuite) TestFromYAMLTimingInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    consumer:\n" +
+		"      timing: medium\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"Bad consumer.timing: medium")
+}
+
+func (s *ConfigSuite) TestFromYAMLPartitionCheckInvalid(c *C) {
+	for i, tc := range []struct {
+		consumer string
+		error    string
+	}{{
+		consumer: "partition_check_interval: -1s",
+		error:    "consumer.partition_check_interval must be >= 0",
+	}, {
+		consumer: "partition_settle_delay: -1s",
+		error:    "consumer.partition_settle_delay must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  default:\n" +
+			"    consumer:\n" +
+			"      " + tc.consumer + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+tc.error,
+			Commentf("case #%d", i))
+	}
+}
+
+// Queue and buffer sizes that are not given default to the channel buffer
+// size of the module.
+func (s *ConfigSuite) TestQueueSizes(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    producer:\n" +
+		"      channel_buffer_size: 100\n" +
+		"    consumer:\n" +
+		"      channel_buffer_size: 10\n" +
+		"      request_queue_size: 3\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+	c.Assert(err, IsNil)
+
+	// Then
+	cfg := appCfg.Proxies["default"]
+	c.Assert(cfg.ProducerDispatchQueueSize(), Equals, 100)
+	c.Assert(cfg.ConsumerRequestQueueSize(), Equals, 3)
+	c.Assert(cfg.ConsumerMessageBufferSize(), Equals, 10)
+}
+
+func (s *ConfigSuite) TestFromYAMLMetricsBackendInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    metrics:\n" +
+		"      backend: prometheus\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, ErrorMatches, "invalid config parameter: invalid config, cluster=default: "+
+		"Bad metrics.backend: prometheus")
+}
+
+func (s *ConfigSuite) TestFromYAMLAlertRules(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    alerts:\n" +
+		"      rules:\n" +
+		"        - name: orders-lag\n" +
+		"          kind: lag\n" +
+		"          group: billing\n" +
+		"          topic: orders\n" +
+		"          threshold: 10000\n" +
+		"          for: 5m\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.Proxies["default"].Alerts.Rules, DeepEquals, []AlertRule{{
+		Name: "orders-lag", Kind: AlertKindLag, Group: "billing", Topic: "orders", Threshold: 10000, For: 5 * time.Minute,
+	}})
+}
+
+func (s *ConfigSuite) TestAlertRulesInvalid(c *C) {
+	for i, tc := range []struct {
+		rule   AlertRule
+		errMsg string
+	}{{
+		rule:   AlertRule{Kind: AlertKindLag, Group: "foo", Topic: "bar"},
+		errMsg: "alerts.rules\\[1\\].name must not be empty",
+	}, {
+		rule:   AlertRule{Name: "r0", Kind: AlertKindLag, Group: "foo", Topic: "bar"},
+		errMsg: "alerts.rules\\[1\\].name must be unique: r0",
+	}, {
+		rule:   AlertRule{Name: "r1", Kind: AlertKindLag, Topic: "bar"},
+		errMsg: "alerts.rules\\[1\\].group must not be empty",
+	}, {
+		rule:   AlertRule{Name: "r1", Kind: "silence", Group: "foo", Topic: "bar"},
+		errMsg: "Bad alerts.rules\\[1\\].kind: silence",
+	}} {
+		cfg := DefaultProxy()
+		cfg.Alerts.Rules = []AlertRule{{Name: "r0", Kind: AlertKindStall, Group: "foo", Topic: "bar"}, tc.rule}
+
+		// When
+		err := cfg.validate()
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestCheckKafkaFeature(c *C) {
+	cfg := DefaultProxy()
+	cfg.Kafka.Version = "0.10.0.1"
+
+	err := cfg.CheckKafkaFeature(KafkaFeatureOffsetsByTime)
+	c.Assert(err, ErrorMatches, "offsets_by_time requires Kafka 0.10.1.0 or later, "+
+		"but kafka.version is 0.10.0.1: kafka feature not supported")
+	c.Assert(errors.Cause(err), Equals, ErrKafkaFeatureUnsupported)
+
+	cfg.Kafka.Version = "0.10.1.0"
+	c.Assert(cfg.CheckKafkaFeature(KafkaFeatureOffsetsByTime), IsNil)
+	err = cfg.CheckKafkaFeature(KafkaFeatureHeaders)
+	c.Assert(err, ErrorMatches, "headers requires Kafka 0.11.0.0 or later, "+
+		"but the most recent version supported by Kafka-Pixy is 0.10.1.0: kafka feature not supported")
+	c.Assert(cfg.CheckKafkaFeature("foo"), ErrorMatches, "unknown kafka feature: foo")
+}
+
+// Seed peers can be given as DNS SRV records, that must be named.
+func (s *ConfigSuite) TestSeedPeerSRV(c *C) {
+	name, ok := SeedPeerSRV("srv:_kafka._tcp.example.com")
+	c.Assert(name, Equals, "_kafka._tcp.example.com")
+	c.Assert(ok, Equals, true)
+	_, ok = SeedPeerSRV("kafka.example.com:9092")
+	c.Assert(ok, Equals, false)
+
+	cfg := DefaultProxy()
+	cfg.ZooKeeper.SeedPeers = []string{"srv:"}
+	c.Assert(cfg.validate(), ErrorMatches, "Bad zoo_keeper.seed_peers: srv:")
+	cfg = DefaultProxy()
+	cfg.DNS.RefreshInterval = 0
+	c.Assert(cfg.validate(), ErrorMatches, "dns.refresh_interval must be > 0")
+}
+
+// A standby cluster needs its own ZooKeeper, and the standby config differs
+// from the primary one in seed peers only.
+func (s *ConfigSuite) TestFailover(c *C) {
+	cfg := DefaultProxy()
+	c.Assert(cfg.StandbyCfg(), IsNil)
+	cfg.Failover.KafkaSeedPeers = []string{"kafka.dc2:9092"}
+
+	// When
+	err := cfg.validate()
+
+	// Then
+	c.Assert(err, ErrorMatches, "failover.zoo_keeper_seed_peers must not be empty")
+
+	// When
+	cfg.Failover.ZooKeeperSeedPeers = []string{"zk.dc2:2181"}
+	standbyCfg := cfg.StandbyCfg()
+
+	// Then
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(standbyCfg.Kafka.SeedPeers, DeepEquals, []string{"kafka.dc2:9092"})
+	c.Assert(standbyCfg.ZooKeeper.SeedPeers, DeepEquals, []string{"zk.dc2:2181"})
+	c.Assert(cfg.Kafka.SeedPeers, DeepEquals, []string{"localhost:9092"})
+	c.Assert(standbyCfg.ClientID, Equals, cfg.ClientID)
+}
+
+// Timestamps are only stamped and measured with Kafka versions that have
+// them.
+func (s *ConfigSuite) TestTimestamps(c *C) {
+	cfg := DefaultProxy()
+	cfg.Kafka.Version = "0.9.0.1"
+	c.Assert(cfg.validate(), IsNil)
+
+	cfg.Producer.StampTimestamps = true
+	c.Assert(cfg.validate(), ErrorMatches,
+		"producer.stamp_timestamps: timestamps requires Kafka 0.10.0.0 or later, but kafka.version is 0.9.0.1.*")
+
+	cfg.Producer.StampTimestamps = false
+	cfg.Consumer.EndToEndLatency = true
+	c.Assert(cfg.validate(), ErrorMatches,
+		"consumer.end_to_end_latency: timestamps requires Kafka 0.10.0.0 or later, but kafka.version is 0.9.0.1.*")
+
+	cfg.Kafka.Version = "0.10.0.0"
+	c.Assert(cfg.validate(), IsNil)
+}
+
+func (s *ConfigSuite) TestCompareVersions(c *C) {
+	c.Assert(compareVersions("0.10.1.0", "0.9.0.1"), Equals, 1)
+	c.Assert(compareVersions("0.8.2.2", "0.10.0.0"), Equals, -1)
+	c.Assert(compareVersions("2.1.0", "2.1.0.0"), Equals, 0)
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Layers of the config that parameters in effect can come from, from the
+// least to the most specific:
+const (
+	// Built-in defaults.
+	SourceDefault = "default"
+
+	// The `proxy_defaults` section, that all proxies inherit.
+	SourceProxyDefaults = "proxy_defaults"
+
+	// The section of a particular proxy.
+	SourceProxy = "proxy"
+
+	// Parameters of a particular topic, e.g. `consumer.topic_dispatch`.
+	SourceTopic = "topic"
+
+	// Parameters of a particular API request, e.g. `offset_reset`.
+	SourceRequest = "request"
+)
+
+// Param is a config parameter in effect, along with the config layer that it
+// comes from.
+type Param struct {
+	// Path of the parameter in a proxy section, e.g. `consumer.ack_timeout`.
+	Name   string
+	Value  interface{}
+	Source string
+}
+
+// Effective returns producer and consumer parameters that are in effect for
+// a topic. Per topic parameters, like `consumer.topic_dispatch`, are folded
+// into the parameters they override, rather than returned as is.
+func (p *Proxy) Effective(topic string) []Param {
+	builtin := defaultProxyWithClientID(p.ClientID)
+	inherited := p.inherited
+	if inherited == nil {
+		inherited = builtin
+	}
+	var params []Param
+	for _, section := range []string{"producer", "consumer"} {
+		params = appendEffective(params, section,
+			proxySection(p, section), proxySection(inherited, section), proxySection(builtin, section))
+	}
+	if dispatch, ok := p.Consumer.TopicDispatch[topic]; ok {
+		params = overrideTopicParam(params, "consumer.dispatch", dispatch)
+	}
+	if redelivery := p.Consumer.TopicRedelivery[topic]; redelivery != nil {
+		v := reflect.ValueOf(*redelivery)
+		for _, param := range appendEffective(nil, "consumer.redelivery", v, v, v) {
+			params = overrideTopicParam(params, param.Name, param.Value)
+		}
+	}
+	if maxAge, ok := p.Consumer.TopicMaxMessageAge[topic]; ok {
+		params = overrideTopicParam(params, "consumer.max_message_age", maxAge.String())
+	}
+	return params
+}
+
+// OverrideParam replaces the value of a parameter with one given in an API
+// request.
+func OverrideParam(params []Param, name string, value interface{}) []Param {
+	for i := range params {
+		if params[i].Name == name {
+			params[i].Value, params[i].Source = value, SourceRequest
+			return params
+		}
+	}
+	return append(params, Param{Name: name, Value: value, Source: SourceRequest})
+}
+
+// overrideTopicParam replaces the value of a parameter with one given for a
+// topic.
+func overrideTopicParam(params []Param, name string, value interface{}) []Param {
+	for i := range params {
+		if params[i].Name == name {
+			params[i].Value, params[i].Source = value, SourceTopic
+		}
+	}
+	return params
+}
+
+// proxySection returns the value of a top level section of a proxy config.
+func proxySection(p *Proxy, key string) reflect.Value {
+	v := reflect.ValueOf(p).Elem()
+	for _, field := range envFields(v.Type()) {
+		if field.key == key {
+			return v.Field(field.index)
+		}
+	}
+	panic("unknown section " + key)
+}
+
+// appendEffective appends parameters of a config section to `params`,
+// telling the source of each by comparing its value with the values it takes
+// in the inherited and the built-in configs.
+func appendEffective(params []Param, path string, v, inherited, builtin reflect.Value) []Param {
+	if v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(time.Time{}) {
+		for i := 0; i < v.NumField(); i++ {
+			key, ok := yamlKey(v.Type().Field(i))
+			if !ok || path == "consumer" && strings.HasPrefix(key, "topic_") {
+				continue
+			}
+			params = appendEffective(params, path+"."+key, v.Field(i), inherited.Field(i), builtin.Field(i))
+		}
+		return params
+	}
+	value := v.Interface()
+	if d, ok := value.(time.Duration); ok {
+		value = d.String()
+	}
+	param := Param{Name: path, Value: value, Source: SourceDefault}
+	switch {
+	case !reflect.DeepEqual(v.Interface(), inherited.Interface()):
+		param.Source = SourceProxy
+	case !reflect.DeepEqual(v.Interface(), builtin.Interface()):
+		param.Source = SourceProxyDefaults
+	}
+	return append(params, param)
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+// Parameters are inherited from proxy_defaults, unless overridden by a proxy
+// or a topic, and each is reported along with the layer it comes from.
+func (s *ConfigSuite) TestEffective(c *C) {
+	appCfg, err := FromYAML([]byte(`
+proxy_defaults:
+  consumer:
+    ack_timeout: 10s
+    redelivery:
+      backoff: 3s
+    topic_dispatch:
+      foo: key
+proxies:
+  bar:
+    consumer:
+      max_in_flight: 7
+      redelivery:
+        backoff: 5s
+      topic_redelivery:
+        foo: {backoff: 1s, backoff_factor: 3}
+      topic_max_message_age:
+        foo: 1h
+  bazz:
+    consumer:
+      topic_dispatch:
+        bazz: unordered
+`))
+	c.Assert(err, IsNil)
+
+	// When
+	barFoo := paramsByName(appCfg.Proxies["bar"].Effective("foo"))
+	barBar := paramsByName(appCfg.Proxies["bar"].Effective("bar"))
+	bazzFoo := paramsByName(appCfg.Proxies["bazz"].Effective("foo"))
+
+	// Then
+	c.Assert(barFoo["consumer.ack_timeout"], Equals, Param{"consumer.ack_timeout", "10s", SourceProxyDefaults})
+	c.Assert(barFoo["consumer.max_in_flight"], Equals, Param{"consumer.max_in_flight", 7, SourceProxy})
+	c.Assert(barFoo["consumer.dispatch"], Equals, Param{"consumer.dispatch", DispatchKey, SourceTopic})
+	c.Assert(barFoo["consumer.redelivery.backoff"], Equals, Param{"consumer.redelivery.backoff", "1s", SourceTopic})
+	c.Assert(barFoo["consumer.redelivery.backoff_factor"], Equals, Param{"consumer.redelivery.backoff_factor", float64(3), SourceTopic})
+	c.Assert(barFoo["producer.required_acks"], Equals, Param{"producer.required_acks", defaultRequiredAcks, SourceDefault})
+	c.Assert(barFoo["consumer.max_message_age"], Equals, Param{"consumer.max_message_age", "1h0m0s", SourceTopic})
+	_, ok := barFoo["consumer.topic_dispatch"]
+	c.Assert(ok, Equals, false)
+
+	c.Assert(barBar["consumer.dispatch"], Equals, Param{"consumer.dispatch", DispatchPartition, SourceDefault})
+	c.Assert(barBar["consumer.redelivery.backoff"], Equals, Param{"consumer.redelivery.backoff", "5s", SourceProxy})
+	c.Assert(barBar["consumer.max_message_age"], Equals, Param{"consumer.max_message_age", "0s", SourceDefault})
+
+	// Maps of topic parameters are merged.
+	c.Assert(bazzFoo["consumer.dispatch"], Equals, Param{"consumer.dispatch", DispatchKey, SourceTopic})
+	c.Assert(bazzFoo["consumer.redelivery.backoff"], Equals, Param{"consumer.redelivery.backoff", "3s", SourceProxyDefaults})
+	c.Assert(appCfg.Proxies["bazz"].TopicDispatch("bazz"), Equals, DispatchUnordered)
+}
+
+func (s *ConfigSuite) TestOverrideParam(c *C) {
+	params := []Param{{"consumer.offset_reset", OffsetResetLatest, SourceDefault}}
+
+	// When
+	params = OverrideParam(params, "consumer.offset_reset", OffsetResetEarliest)
+
+	// Then
+	c.Assert(params, DeepEquals, []Param{{"consumer.offset_reset", OffsetResetEarliest, SourceRequest}})
+}
+
+func paramsByName(params []Param) map[string]Param {
+	byName := make(map[string]Param, len(params))
+	for _, param := range params {
+		byName[param.Name] = param
+	}
+	return byName
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix is the prefix of environment variables that override config
+	// parameters, see ApplyEnv.
+	EnvPrefix = "KAFKA_PIXY_"
+
+	// An environment variable can refer to the default proxy by that name,
+	// regardless of the actual cluster name.
+	envDefaultCluster = "DEFAULT"
+)
+
+// ApplyEnv overrides config parameters with values of environment variables
+// given in the os.Environ format. A variable name is the EnvPrefix followed by
+// the path of a parameter, the way it is spelled in a YAML config file, in
+// upper case and with all separators replaced with `_`, e.g.
+// `KAFKA_PIXY_PROXIES_PROD_KAFKA_SEED_PEERS` overrides `kafka.seed_peers` of
+// the `prod` proxy. Proxies, tenants, and other map entries can only be
+// overridden if they are already in the config.
+//
+// Values are parsed as YAML, except for strings that are taken verbatim, and
+// lists that can also be given as comma separated values. Variables that do
+// not match any parameter are reported as errors, the same as invalid values.
+// The config is validated again after overrides are applied.
+func (a *App) ApplyEnv(environ []string) error {
+	var overrides []string
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, EnvPrefix) {
+			overrides = append(overrides, kv)
+		}
+	}
+	// Overrides are applied in a deterministic order, so that the outcome
+	// does not depend on the order of the environment, should two variables
+	// refer to the same parameter.
+	sort.Strings(overrides)
+	for _, kv := range overrides {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := parts[0], parts[1]
+		if err := a.applyEnvVar(strings.Split(name[len(EnvPrefix):], "_"), value); err != nil {
+			return errors.Wrapf(err, "bad environment variable %s", name)
+		}
+	}
+	if err := a.validate(); err != nil {
+		return errors.Wrap(err, "invalid config parameter")
+	}
+	a.envOverrides = overrides
+	return nil
+}
+
+func (a *App) applyEnvVar(path []string, value string) error {
+	// The default proxy alias can only be resolved given the entire config,
+	// hence it is handled here rather than by the generic code.
+	if len(path) > 1 && path[0] == "PROXIES" && path[1] == envDefaultCluster {
+		proxyCfg, ok := a.Proxies[a.DefaultCluster]
+		if !ok {
+			return errors.New("no default proxy")
+		}
+		return setEnvPath(reflect.ValueOf(proxyCfg), path[2:], value)
+	}
+	return setEnvPath(reflect.ValueOf(a), path, value)
+}
+
+// setEnvPath sets the value at the specified path under `v` to the one parsed
+// from `value`.
+func setEnvPath(v reflect.Value, path []string, value string) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if len(path) == 0 {
+		return setEnvValue(v, value)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := envFields(v.Type())
+		for _, field := range fields {
+			if rest, ok := trimEnvPath(path, field.key); ok {
+				return setEnvPath(v.Field(field.index), rest, value)
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		for _, key := range v.MapKeys() {
+			rest, ok := trimEnvPath(path, key.String())
+			if !ok {
+				continue
+			}
+			// Map elements are not addressable, so elements other than
+			// pointers are updated in a copy that is put back.
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := setEnvPath(elem, rest, value); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+			return nil
+		}
+	case reflect.Slice:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= v.Len() {
+			break
+		}
+		return setEnvPath(v.Index(i), path[1:], value)
+	}
+	return errors.New("does not match any config parameter")
+}
+
+// setEnvValue sets a value parsed from an environment variable.
+func setEnvValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	if v.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setEnvValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	parsed := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+		return errors.Wrap(err, "bad value")
+	}
+	v.Set(parsed.Elem())
+	return nil
+}
+
+type envField struct {
+	key   string
+	index int
+}
+
+// envFields returns fields of a struct along with their YAML keys, longest
+// keys first, so that a key that is a prefix of another does not shadow it.
+func envFields(t reflect.Type) []envField {
+	var fields []envField
+	for i := 0; i < t.NumField(); i++ {
+		if key, ok := yamlKey(t.Field(i)); ok {
+			fields = append(fields, envField{key: key, index: i})
+		}
+	}
+	sort.Stable(envFieldsByKeyLen(fields))
+	return fields
+}
+
+type envFieldsByKeyLen []envField
+
+func (p envFieldsByKeyLen) Len() int           { return len(p) }
+func (p envFieldsByKeyLen) Less(i, j int) bool { return len(p[i].key) > len(p[j].key) }
+func (p envFieldsByKeyLen) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// trimEnvPath checks whether an environment variable path starts with the
+// specified key, and if so returns the rest of the path.
+func trimEnvPath(path []string, key string) ([]string, bool) {
+	keyPath := strings.Split(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key), "_")
+	if len(keyPath) > len(path) {
+		return nil, false
+	}
+	for i := range keyPath {
+		if keyPath[i] != path[i] {
+			return nil, false
+		}
+	}
+	return path[len(keyPath):], true
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *ConfigSuite) TestApplyEnv(c *C) {
+	appCfg, err := FromYAML([]byte(`
+proxies:
+  prod-eu:
+    client_id: foo
+    tenants:
+      t1:
+        tokens: [a]
+  bar:
+    client_id: bar
+`))
+	c.Assert(err, IsNil)
+
+	// When
+	err = appCfg.ApplyEnv([]string{
+		"PATH=/bin",
+		"KAFKA_PIXY_GRPC_ADDR=0.0.0.0:1234",
+		"KAFKA_PIXY_SLOW_CONSUMERS_WINDOW=3m",
+		"KAFKA_PIXY_PROXIES_DEFAULT_KAFKA_SEED_PEERS=k1:9092, k2:9092",
+		"KAFKA_PIXY_PROXIES_PROD_EU_CONSUMER_OFFSETS_COMMIT_INTERVAL=1s",
+		"KAFKA_PIXY_PROXIES_PROD_EU_TENANTS_T1_TOKENS=[b, c]",
+		"KAFKA_PIXY_PROXIES_BAR_PRODUCER_RETRY_MAX=7",
+		"KAFKA_PIXY_PROXIES_BAR_ROUTING_PEERS={bar: \"h1:19092\"}",
+		"KAFKA_PIXY_PROXIES_BAR_ROUTING_SECRET=s3cr3t",
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.GRPCAddr, Equals, "0.0.0.0:1234")
+	c.Assert(appCfg.SlowConsumers.Window, Equals, 3*time.Minute)
+	prodCfg := appCfg.Proxies["prod-eu"]
+	c.Assert(prodCfg.Kafka.SeedPeers, DeepEquals, []string{"k1:9092", "k2:9092"})
+	c.Assert(prodCfg.Consumer.OffsetsCommitInterval, Equals, time.Second)
+	c.Assert(prodCfg.Tenants["t1"].Tokens, DeepEquals, []string{"b", "c"})
+	barCfg := appCfg.Proxies["bar"]
+	c.Assert(barCfg.Producer.RetryMax, Equals, 7)
+	c.Assert(barCfg.Routing.Peers, DeepEquals, map[string]string{"bar": "h1:19092"})
+	c.Assert(barCfg.Routing.Secret, Equals, "s3cr3t")
+	c.Assert(barCfg.Kafka.SeedPeers, DeepEquals, []string{"localhost:9092"})
+}
+
+func (s *ConfigSuite) TestApplyEnvError(c *C) {
+	for i, tc := range []struct {
+		env string
+		err string
+	}{{
+		env: "KAFKA_PIXY_GRPC_ADR=0.0.0.0:1234",
+		err: "bad environment variable KAFKA_PIXY_GRPC_ADR: does not match any config parameter",
+	}, {
+		env: "KAFKA_PIXY_PROXIES_BAZZ_CLIENT_ID=bazz",
+		err: "bad environment variable KAFKA_PIXY_PROXIES_BAZZ_CLIENT_ID: does not match any config parameter",
+	}, {
+		env: "KAFKA_PIXY_PROXIES_DEFAULT_PRODUCER_RETRY_MAX=many",
+		err: "bad environment variable KAFKA_PIXY_PROXIES_DEFAULT_PRODUCER_RETRY_MAX: bad value: (?s).*cannot unmarshal.*",
+	}, {
+		env: "KAFKA_PIXY_PROXIES_DEFAULT_PRODUCER_RETRY_MAX=0",
+		err: "invalid config parameter: invalid config, cluster=foo: producer.retry_max must be > 0",
+	}} {
+		appCfg := DefaultApp("foo")
+
+		// When
+		err := appCfg.ApplyEnv([]string{tc.env})
+
+		// Then
+		c.Assert(err, ErrorMatches, tc.err, Commentf("case #%d", i))
+	}
+}
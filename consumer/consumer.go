@@ -3,6 +3,7 @@ package consumer
 import (
 	"time"
 
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/pkg/errors"
 )
 
@@ -14,11 +15,42 @@ const (
 	// An event of this type should be sent to the message events channel
 	// when the message is acknowledged by a client.
 	EvAcked
+
+	// An event of this type should be sent to the message events channel
+	// when a client commits a checkpoint, that is all messages before the
+	// event offset are acknowledged at once, and the offset is committed
+	// along with the event metadata. The outcome is reported to the event
+	// done channel once the offset is committed to Kafka.
+	EvCheckpoint
+
+	// An event of this type should be sent to the message events channel
+	// when a client fails to process the message, see Nack* constants.
+	EvNacked
+)
+
+// Classes of negative acknowledgements, that tell why a client failed to
+// process a message.
+const (
+	// The message may be processed if it is offered again. It is offered
+	// after the redelivery backoff, rather than after the ack timeout.
+	NackRetryable = "retryable"
+
+	// The message can never be processed. It is produced to the dead letter
+	// topic and acknowledged.
+	NackFatal = "fatal"
+
+	// The client is overloaded. The message is offered again after the
+	// redelivery backoff, and the partition is not offered from meanwhile.
+	// The backoff grows with every consecutive throttle nack of the
+	// partition.
+	NackThrottle = "throttle"
 )
 
 var (
-	ErrRequestTimeout  = errors.New("long polling timeout")
-	ErrTooManyRequests = errors.New("Too many requests. Consider increasing `consumer.channel_buffer_size` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml#L43)")
+	ErrRequestTimeout   = errors.New("long polling timeout")
+	ErrTooManyRequests  = errors.New("Too many requests. Consider increasing `consumer.channel_buffer_size` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml#L43)")
+	ErrCheckpointBehind = errors.New("checkpoint is behind the acknowledged offset")
+	ErrTooManyTopics    = errors.New("Too many topics consumed by the group. Consider increasing `consumer.group_isolation.max_topics`")
 )
 
 type T interface {
@@ -35,12 +67,34 @@ type T interface {
 	// and then repeat the request.
 	Consume(group, topic string) (Message, error)
 
+	// ConsumeWithOpts is like Consume, except it takes optional request
+	// parameters that override the proxy defaults, see ConsumeOpts.
+	ConsumeWithOpts(group, topic string, opts ConsumeOpts) (Message, error)
+
 	// Stop sends a shutdown signal to all internal goroutines and blocks until
 	// they are stopped. It is guaranteed that all last consumed offsets of all
 	// consumer groups/topics are committed to Kafka before Consumer stops.
 	Stop()
 }
 
+// Lifecycle is implemented by consumers that let clients control when the
+// consumption of a topic by a group starts and stops, rather than only start
+// on the first consume request and stop after
+// `Config.Consumer.RegistrationTimeout` of inactivity.
+type Lifecycle interface {
+	// StartTopic registers with the group and subscribes for the topic, if
+	// that has not been done yet, without consuming any messages. Options
+	// apply to the following consume requests as if they were given with
+	// the first of them. The topic consumption still stops if there are no
+	// consume requests for `Config.Consumer.RegistrationTimeout`.
+	StartTopic(group, topic string, opts ConsumeOpts) error
+
+	// StopTopic unsubscribes from the topic right away, committing offsets
+	// of all messages acknowledged by then. It does nothing if the topic is
+	// not consumed by the group.
+	StopTopic(group, topic string) error
+}
+
 // Message encapsulates a Kafka message returned by the consumer.
 type Message struct {
 	Key, Value    []byte
@@ -50,15 +104,147 @@ type Message struct {
 	Timestamp     time.Time // only set if Kafka is version 0.10+
 	HighWaterMark int64
 	EventsCh      chan<- Event
+
+	// True if the message is re-delivered by a replay, rather than consumed
+	// from the group position in the partition. Acknowledgements of replayed
+	// messages do not affect committed offsets.
+	Replay bool
+
+	// Number of offsets right before this message that have no messages to
+	// be consumed at, e.g. because they were compacted away, removed by
+	// retention, or taken by transaction markers. Committed offsets move
+	// past them as soon as the message is fetched.
+	Skipped int64
+
+	// True if the message has been nacked as retryable after it was offered
+	// `redelivery.max_attempts` times, so that it is produced to the dead
+	// letter topic rather than retried. It is only set on messages sent to
+	// the offered channel of a nack event.
+	AttemptsExhausted bool
+
+	// Following are messages from the same partition that come right after
+	// this one, claimed by the same request. They are offered together with
+	// this message, but every one of them has to be acknowledged separately.
+	Following []Message
+}
+
+// Lag returns the number of messages in the partition that come after this
+// one, as of when it was fetched.
+func (m *Message) Lag() int64 {
+	if lag := m.HighWaterMark - m.Offset - 1; lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// ConsumeOpts are optional parameters of a consume request. The zero value
+// stands for the proxy defaults.
+type ConsumeOpts struct {
+	// Partitions of the topic that the group has no committed offsets for
+	// are consumed from where it tells, rather than where
+	// `Config.Consumer.OffsetReset` does.
+	OffsetReset OffsetReset
+
+	// Maximum number of consecutive messages from the same partition to
+	// claim. It is capped by `Config.Consumer.MaxClaimSize`, and zero is
+	// the same as one.
+	MaxMessages int
+}
+
+// ClaimSize returns the number of messages the request can claim, given the
+// configured maximum claim size.
+func (opts ConsumeOpts) ClaimSize(maxClaimSize int) int {
+	if opts.MaxMessages > maxClaimSize {
+		return maxClaimSize
+	}
+	if opts.MaxMessages < 1 {
+		return 1
+	}
+	return opts.MaxMessages
+}
+
+// OffsetReset tells where a consumer group starts consuming a partition that
+// it has no committed offset for. The zero value stands for the proxy default
+// `Config.Consumer.OffsetReset`.
+type OffsetReset struct {
+	// Either config.OffsetResetEarliest or config.OffsetResetLatest. It is
+	// ignored if Time is set.
+	Policy string
+
+	// If not zero, consumption starts from the first message with a
+	// timestamp not earlier than that.
+	Time time.Time
+}
+
+// ParseOffsetReset parses an offset reset policy given in a request. It is
+// either `earliest`, `latest`, or an RFC3339 timestamp. An empty string
+// stands for the proxy default.
+func ParseOffsetReset(s string) (OffsetReset, error) {
+	switch s {
+	case "":
+		return OffsetReset{}, nil
+	case config.OffsetResetEarliest, config.OffsetResetLatest:
+		return OffsetReset{Policy: s}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return OffsetReset{}, errors.Errorf("bad offset reset %q, must be `%s`, `%s` or an RFC3339 timestamp",
+			s, config.OffsetResetEarliest, config.OffsetResetLatest)
+	}
+	return OffsetReset{Time: t}, nil
+}
+
+// IsZero tells whether the proxy default policy should be used.
+func (or OffsetReset) IsZero() bool {
+	return or.Policy == "" && or.Time.IsZero()
+}
+
+// String returns the policy in the format accepted by ParseOffsetReset.
+func (or OffsetReset) String() string {
+	if !or.Time.IsZero() {
+		return or.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return or.Policy
 }
 
 func Ack(offset int64) Event {
-	return Event{EvAcked, offset}
+	return Event{T: EvAcked, Offset: offset}
+}
+
+// Checkpoint returns a checkpoint event. The outcome of the checkpoint is
+// sent to `doneCh`, that should be buffered for the sender not to block.
+func Checkpoint(offset int64, meta string, doneCh chan<- error) Event {
+	return Event{T: EvCheckpoint, Offset: offset, Meta: meta, DoneCh: doneCh}
+}
+
+// Nack returns a negative acknowledgement event of the specified class. The
+// offered message is sent to `offeredCh`, if it is not nil, that is then
+// closed. It is closed right away if the message is not offered.
+func Nack(offset int64, class string, offeredCh chan<- Message) Event {
+	return Event{T: EvNacked, Offset: offset, Class: class, OfferedCh: offeredCh}
+}
+
+// IsValidNackClass tells whether a negative acknowledgement class is one of
+// Nack* constants.
+func IsValidNackClass(class string) bool {
+	switch class {
+	case NackRetryable, NackFatal, NackThrottle:
+		return true
+	}
+	return false
 }
 
 type Event struct {
 	T      eventType
 	Offset int64
+
+	// Only set for checkpoint events.
+	Meta   string
+	DoneCh chan<- error
+
+	// Only set for nack events.
+	Class     string
+	OfferedCh chan<- Message
 }
 
 type eventType int
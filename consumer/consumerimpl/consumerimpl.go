@@ -9,6 +9,9 @@ import (
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
 	"github.com/mailgun/kafka-pixy/consumer/groupcsm"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
+	"github.com/mailgun/kafka-pixy/consumer/sizestats"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/pkg/errors"
 	"github.com/wvanbergen/kazoo-go"
@@ -25,6 +28,7 @@ import (
 // requests for that period then the consumer deregisters from the group.
 //
 // implements `consumer.T`.
+// implements `consumer.Lifecycle`.
 // implements `dispatcher.Factory`.
 type t struct {
 	namespace  *actor.ID
@@ -33,14 +37,38 @@ type t struct {
 	kafkaClt   sarama.Client
 	kazooClt   *kazoo.Kazoo
 	offsetMgrF offsetmgr.Factory
+	events     *groupevents.T
+	sizes      *sizestats.T
+	metrics    *metrics.Registry
+}
+
+// Opts are optional parameters of a consumer.
+type Opts struct {
+	// If given, then partition assignment changes of all consumer groups are
+	// recorded to it.
+	Events *groupevents.T
+
+	// If given, then sizes of all messages fetched from Kafka are recorded
+	// to it.
+	Sizes *sizestats.T
+
+	// If given, then resource usage of every consumer group is reported to
+	// it.
+	Metrics *metrics.Registry
 }
 
 // Spawn creates a consumer instance with the specified configuration and
 // starts all its goroutines.
 func Spawn(namespace *actor.ID, cfg *config.Proxy, offsetMgrF offsetmgr.Factory) (*t, error) {
+	return SpawnWithOpts(namespace, cfg, offsetMgrF, Opts{})
+}
+
+// SpawnWithOpts is like Spawn, but takes optional parameters.
+func SpawnWithOpts(namespace *actor.ID, cfg *config.Proxy, offsetMgrF offsetmgr.Factory, opts Opts) (*t, error) {
 	saramaCfg := sarama.NewConfig()
+	saramaCfg.Version = cfg.SaramaKafkaVersion()
 	saramaCfg.ClientID = cfg.ClientID
-	saramaCfg.ChannelBufferSize = cfg.Consumer.ChannelBufferSize
+	saramaCfg.ChannelBufferSize = cfg.ConsumerMessageBufferSize()
 	saramaCfg.Consumer.Retry.Backoff = cfg.Consumer.RetryBackoff
 	saramaCfg.Consumer.Fetch.Default = int32(cfg.Consumer.FetchBytes)
 
@@ -51,6 +79,11 @@ func Spawn(namespace *actor.ID, cfg *config.Proxy, offsetMgrF offsetmgr.Factory)
 		return nil, errors.Wrap(err, "failed to create Kafka client for message streams")
 	}
 
+	// TODO kazoo connects with the default host provider, that resolves
+	// host names of ZooKeeper peers once, so if their addresses change the
+	// consumer only gets to know of that when it is respawned by a
+	// rebalance. The vendored kazoo does not let connection options through
+	// to use seedpeers.T.ZooKeeperHostProvider as admin does.
 	kazooClt, err := kazoo.NewKazoo(cfg.ZooKeeper.SeedPeers, cfg.KazooCfg())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create kazoo.Kazoo")
@@ -62,6 +95,9 @@ func Spawn(namespace *actor.ID, cfg *config.Proxy, offsetMgrF offsetmgr.Factory)
 		kafkaClt:   kafkaClt,
 		offsetMgrF: offsetMgrF,
 		kazooClt:   kazooClt,
+		events:     opts.Events,
+		sizes:      opts.Sizes,
+		metrics:    opts.Metrics,
 	}
 	c.dispatcher = dispatcher.New(c.namespace, c, c.cfg)
 	c.dispatcher.Start()
@@ -70,10 +106,35 @@ func Spawn(namespace *actor.ID, cfg *config.Proxy, offsetMgrF offsetmgr.Factory)
 
 // implements `consumer.T`
 func (c *t) Consume(group, topic string) (consumer.Message, error) {
+	return c.ConsumeWithOpts(group, topic, consumer.ConsumeOpts{})
+}
+
+// implements `consumer.T`
+func (c *t) ConsumeWithOpts(group, topic string, opts consumer.ConsumeOpts) (consumer.Message, error) {
+	result := c.request(dispatcher.Request{Kind: dispatcher.KindConsume, Group: group, Topic: topic, Opts: opts})
+	return result.Msg, result.Err
+}
+
+// implements `consumer.Lifecycle`
+func (c *t) StartTopic(group, topic string, opts consumer.ConsumeOpts) error {
+	return c.request(dispatcher.Request{Kind: dispatcher.KindStart, Group: group, Topic: topic, Opts: opts}).Err
+}
+
+// implements `consumer.Lifecycle`
+func (c *t) StopTopic(group, topic string) error {
+	return c.request(dispatcher.Request{Kind: dispatcher.KindStop, Group: group, Topic: topic}).Err
+}
+
+func (c *t) request(req dispatcher.Request) dispatcher.Response {
 	replyCh := make(chan dispatcher.Response, 1)
-	c.dispatcher.Requests() <- dispatcher.Request{time.Now().UTC(), group, topic, replyCh}
+	req.Timestamp = time.Now().UTC()
+	req.ResponseCh = replyCh
+	c.dispatcher.Requests() <- req
 	result := <-replyCh
-	return result.Msg, result.Err
+	if result.Err == consumer.ErrTooManyRequests || result.Err == consumer.ErrTooManyTopics {
+		c.metrics.Counter("consumer.group.rejected_requests", "group", req.Group).Inc(1)
+	}
+	return result
 }
 
 // implements `consumer.T`
@@ -90,7 +151,7 @@ func (c *t) KeyOf(req dispatcher.Request) string {
 
 // implements `dispatcher.Factory`.
 func (c *t) NewTier(key string) dispatcher.Tier {
-	return groupcsm.New(c.namespace, key, c.cfg, c.kafkaClt, c.kazooClt, c.offsetMgrF)
+	return groupcsm.New(c.namespace, key, c.cfg, c.kafkaClt, c.kazooClt, c.offsetMgrF, c.events, c.sizes, c.metrics)
 }
 
 // String returns a string ID of this instance to be used in logs.
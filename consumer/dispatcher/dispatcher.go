@@ -21,16 +21,37 @@ type T struct {
 	cfg               *config.Proxy
 	factory           Factory
 	requestsCh        chan Request
+	maxChildren       int
+	limitErr          error
+	stopsChildren     bool
 	children          map[string]*expiringTier
 	expiredChildrenCh chan Tier
 	stoppedChildrenCh chan Tier
 	wg                sync.WaitGroup
 }
 
+// RequestKind tells what a request asks a dispatch tier to do.
+type RequestKind int
+
+const (
+	// KindConsume requests a message to be consumed.
+	KindConsume RequestKind = iota
+
+	// KindStart requests the dispatch tiers of the group and topic to be
+	// created, if they do not exist yet, without consuming anything.
+	KindStart
+
+	// KindStop requests the dispatch tier of the group and topic to be torn
+	// down right away, rather than when it expires. It never creates tiers.
+	KindStop
+)
+
 type Request struct {
+	Kind       RequestKind
 	Timestamp  time.Time
 	Group      string
 	Topic      string
+	Opts       consumer.ConsumeOpts
 	ResponseCh chan<- Response
 }
 
@@ -80,11 +101,20 @@ type expiringTier struct {
 }
 
 func New(namespace *actor.ID, factory Factory, cfg *config.Proxy) *T {
+	return NewWithLimit(namespace, factory, cfg, 0, nil)
+}
+
+// NewWithLimit is like New, but at most `maxChildren` downstream tiers can
+// exist at a time. Requests that would need one more are rejected with
+// `limitErr`. Zero `maxChildren` means no limit.
+func NewWithLimit(namespace *actor.ID, factory Factory, cfg *config.Proxy, maxChildren int, limitErr error) *T {
 	d := &T{
 		actorID:           namespace.NewChild("dispatcher"),
 		cfg:               cfg,
 		factory:           factory,
-		requestsCh:        make(chan Request, cfg.Consumer.ChannelBufferSize),
+		requestsCh:        make(chan Request, cfg.ConsumerRequestQueueSize()),
+		maxChildren:       maxChildren,
+		limitErr:          limitErr,
 		children:          make(map[string]*expiringTier),
 		expiredChildrenCh: make(chan Tier, cfg.Consumer.ChannelBufferSize),
 		stoppedChildrenCh: make(chan Tier, cfg.Consumer.ChannelBufferSize),
@@ -92,6 +122,13 @@ func New(namespace *actor.ID, factory Factory, cfg *config.Proxy) *T {
 	return d
 }
 
+// StopChildren makes the dispatcher tear down downstream tiers that stop
+// requests resolve to, rather than dispatch the requests to them. It must be
+// called before Start.
+func (d *T) StopChildren() {
+	d.stopsChildren = true
+}
+
 func (d *T) Start() {
 	actor.Spawn(d.actorID, &d.wg, d.run)
 }
@@ -105,6 +142,11 @@ func (d *T) Requests() chan<- Request {
 	return d.requestsCh
 }
 
+// QueueDepth returns the number of requests waiting to be dispatched.
+func (d *T) QueueDepth() int {
+	return len(d.requestsCh)
+}
+
 // run receives consume requests from the `Requests()` channel and dispatches
 // them to downstream tiers based on request dispatch key.
 func (d *T) run() {
@@ -114,6 +156,14 @@ func (d *T) run() {
 			if !ok {
 				goto done
 			}
+			if req.Kind == KindStop {
+				d.handleStopRequest(req)
+				continue
+			}
+			if d.maxChildren > 0 && len(d.children) >= d.maxChildren && d.children[d.factory.KeyOf(req)] == nil {
+				req.ResponseCh <- Response{Err: d.limitErr}
+				continue
+			}
 			dt := d.resolveTier(req)
 			// If the requests buffer is full then either the callers are
 			// pulling too aggressively or the Kafka is experiencing issues.
@@ -184,6 +234,29 @@ func (d *T) resolveTier(req Request) Tier {
 	return et.successor
 }
 
+// handleStopRequest either tears down the dispatch tier that a stop request
+// resolves to, or passes the request down to it, depending on whether the
+// dispatcher stops children. If there is no such tier, then there is nothing
+// to stop, and the request is replied to right away.
+func (d *T) handleStopRequest(req Request) {
+	et := d.children[d.factory.KeyOf(req)]
+	if et == nil || et.expired {
+		req.ResponseCh <- Response{}
+		return
+	}
+	if d.stopsChildren {
+		et.timer.Stop()
+		d.handleExpired(et.instance)
+		req.ResponseCh <- Response{}
+		return
+	}
+	select {
+	case et.instance.Requests() <- req:
+	default:
+		req.ResponseCh <- Response{Err: consumer.ErrTooManyRequests}
+	}
+}
+
 // handleExpired marks the respective dispatch tier as expired and triggers its
 // asynchronous stop. When the tier is stopped it will notify about that via the
 // `stoppedChildrenCh` channel.
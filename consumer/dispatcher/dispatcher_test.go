@@ -0,0 +1,101 @@
+package dispatcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type DispatcherSuite struct{}
+
+var _ = Suite(&DispatcherSuite{})
+
+var errLimit = errors.New("limit reached")
+
+// Requests that would need more tiers than the limit allows are rejected,
+// while requests to existing tiers are still dispatched.
+func (s *DispatcherSuite) TestLimit(c *C) {
+	cfg := config.DefaultProxy()
+	d := NewWithLimit(actor.RootID.NewChild("test"), fakeFactory{}, cfg, 2, errLimit)
+	d.Start()
+	defer d.Stop()
+
+	// When/Then
+	c.Assert(dispatch(d, "a"), IsNil)
+	c.Assert(dispatch(d, "b"), IsNil)
+	c.Assert(dispatch(d, "c"), Equals, errLimit)
+	c.Assert(dispatch(d, "a"), IsNil)
+}
+
+// Stop requests tear down tiers right away, freeing their spots, and are
+// done with at once if there is no tier to stop.
+func (s *DispatcherSuite) TestStopChildren(c *C) {
+	cfg := config.DefaultProxy()
+	d := NewWithLimit(actor.RootID.NewChild("test"), fakeFactory{}, cfg, 1, errLimit)
+	d.StopChildren()
+	d.Start()
+	defer d.Stop()
+	c.Assert(dispatch(d, "a"), IsNil)
+	c.Assert(dispatch(d, "b"), Equals, errLimit)
+
+	// When
+	c.Assert(dispatchKind(d, KindStop, "b"), IsNil)
+	c.Assert(dispatchKind(d, KindStop, "a"), IsNil)
+
+	// Then
+	deadline := time.Now().Add(3 * time.Second)
+	for dispatch(d, "b") == errLimit && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(dispatch(d, "b"), IsNil)
+}
+
+func dispatch(d *T, topic string) error {
+	return dispatchKind(d, KindConsume, topic)
+}
+
+func dispatchKind(d *T, kind RequestKind, topic string) error {
+	replyCh := make(chan Response, 1)
+	d.Requests() <- Request{Kind: kind, Timestamp: time.Now(), Topic: topic, ResponseCh: replyCh}
+	select {
+	case rs := <-replyCh:
+		return rs.Err
+	case <-time.After(3 * time.Second):
+		return errors.New("no response")
+	}
+}
+
+type fakeFactory struct{}
+
+func (fakeFactory) KeyOf(req Request) string { return req.Topic }
+func (fakeFactory) NewTier(key string) Tier {
+	return &fakeTier{key: key, requestsCh: make(chan Request, 1)}
+}
+
+// fakeTier responds to all requests with an empty message.
+type fakeTier struct {
+	key        string
+	requestsCh chan Request
+	stoppedCh  chan<- Tier
+}
+
+func (t *fakeTier) Key() string              { return t.key }
+func (t *fakeTier) Requests() chan<- Request { return t.requestsCh }
+func (t *fakeTier) Start(stoppedCh chan<- Tier) {
+	t.stoppedCh = stoppedCh
+	go func() {
+		for req := range t.requestsCh {
+			req.ResponseCh <- Response{}
+		}
+		t.stoppedCh <- t
+	}()
+}
+func (t *fakeTier) Stop() { close(t.requestsCh) }
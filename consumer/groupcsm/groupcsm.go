@@ -9,12 +9,16 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/groupmember"
 	"github.com/mailgun/kafka-pixy/consumer/msgistream"
 	"github.com/mailgun/kafka-pixy/consumer/multiplexer"
 	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
+	"github.com/mailgun/kafka-pixy/consumer/sizestats"
 	"github.com/mailgun/kafka-pixy/consumer/topiccsm"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/log"
@@ -24,6 +28,12 @@ import (
 
 // groupConsumer manages a fleet of topic consumers and disposes of those that
 // have been inactive for the `Config.Consumer.DisposeAfter` period of time.
+// Every `Config.Consumer.PartitionCheckInterval` it checks whether partitions
+// were added to consumed topics, and if so rebalances the group to start
+// consuming them.
+// Resources of the group are isolated from other groups as
+// `Config.Consumer.GroupIsolation` prescribes, and their usage is reported by
+// the `consumer.group.*` metrics.
 //
 // implements `dispatcher.Factory`.
 // implements `dispatcher.Tier`.
@@ -39,16 +49,26 @@ type T struct {
 	offsetMgrF         offsetmgr.Factory
 	groupMember        *groupmember.T
 	multiplexers       map[string]*multiplexer.T
+	events             *groupevents.T
+	sizes              *sizestats.T
+	metrics            *metrics.Registry
+	assigned           map[string][]int32
 	topicCsmLifespanCh chan *topiccsm.T
 	stopCh             chan none.T
 	wg                 sync.WaitGroup
 
+	// Partitions added to topics while the group was consuming them.
+	expandedMu sync.Mutex
+	expanded   map[string]map[int32]bool
+
 	// Exist just to be overridden in tests with mocks.
 	fetchTopicPartitionsFn func(topic string) ([]int32, error)
+	refreshMetadataFn      func(topics ...string) error
 }
 
 func New(namespace *actor.ID, group string, cfg *config.Proxy, kafkaClt sarama.Client,
-	kazooClt *kazoo.Kazoo, offsetMgrF offsetmgr.Factory,
+	kazooClt *kazoo.Kazoo, offsetMgrF offsetmgr.Factory, events *groupevents.T, sizes *sizestats.T,
+	metrics *metrics.Registry,
 ) *T {
 	supervisorActorID := namespace.NewChild(fmt.Sprintf("G:%s", group))
 	gc := &T{
@@ -60,12 +80,18 @@ func New(namespace *actor.ID, group string, cfg *config.Proxy, kafkaClt sarama.C
 		kazooClt:           kazooClt,
 		offsetMgrF:         offsetMgrF,
 		multiplexers:       make(map[string]*multiplexer.T),
+		events:             events,
+		sizes:              sizes,
+		metrics:            metrics,
 		topicCsmLifespanCh: make(chan *topiccsm.T),
 		stopCh:             make(chan none.T),
 
 		fetchTopicPartitionsFn: kafkaClt.Partitions,
+		refreshMetadataFn:      kafkaClt.RefreshMetadata,
 	}
-	gc.dispatcher = dispatcher.New(gc.supActorID, gc, cfg)
+	gc.dispatcher = dispatcher.NewWithLimit(gc.supActorID, gc, cfg,
+		cfg.Consumer.GroupIsolation.MaxTopics, consumer.ErrTooManyTopics)
+	gc.dispatcher.StopChildren()
 	return gc
 }
 
@@ -76,7 +102,7 @@ func (gc *T) KeyOf(req dispatcher.Request) string {
 
 // implements `dispatcher.Factory`.
 func (gc *T) NewTier(key string) dispatcher.Tier {
-	tc := topiccsm.New(gc.supActorID, gc.group, key, gc.cfg, gc.topicCsmLifespanCh)
+	tc := topiccsm.New(gc.supActorID, gc.group, key, gc.cfg, gc.topicCsmLifespanCh, gc.metrics)
 	return tc
 }
 
@@ -94,13 +120,30 @@ func (gc *T) Requests() chan<- dispatcher.Request {
 func (gc *T) Start(stoppedCh chan<- dispatcher.Tier) {
 	actor.Spawn(gc.supActorID, &gc.wg, func() {
 		defer func() { stoppedCh <- gc }()
+		kafkaClt := gc.kafkaClt
+		if gc.cfg.Consumer.GroupIsolation.DedicatedConnections {
+			dedicatedClt, err := sarama.NewClient(gc.cfg.Kafka.SeedPeers, gc.kafkaClt.Config())
+			if err != nil {
+				log.Errorf("<%s> failed to create dedicated Kafka client, sharing one: err=(%s)", gc.supActorID, err)
+			} else {
+				kafkaClt = dedicatedClt
+				defer dedicatedClt.Close()
+			}
+		}
 		var err error
-		gc.msgIStreamF, err = msgistream.SpawnFactory(gc.supActorID, gc.kafkaClt)
+		gc.msgIStreamF, err = msgistream.SpawnFactoryWithOpts(gc.supActorID, kafkaClt, msgistream.Opts{
+			Sizes:            gc.sizes,
+			OffsetOutOfRange: gc.cfg.Consumer.OffsetOutOfRange,
+			Metrics:          gc.metrics,
+			Group:            gc.group,
+		})
 		if err != nil {
 			// Must never happen.
 			panic(errors.Wrap(err, "failed to create sarama.Consumer"))
 		}
-		gc.groupMember = groupmember.Spawn(gc.supActorID, gc.group, gc.cfg.ClientID, gc.cfg, gc.kazooClt)
+		gc.metrics.GaugeFunc("consumer.group_queue.depth", func() int64 { return int64(gc.dispatcher.QueueDepth()) }, "group", gc.group)
+		gc.metrics.GaugeFunc("consumer.message_buffer.depth", func() int64 { return int64(gc.msgIStreamF.BufferedMessages()) }, "group", gc.group)
+		gc.groupMember = groupmember.SpawnWithOpts(gc.supActorID, gc.group, gc.cfg.ClientID, gc.cfg, gc.kazooClt, groupmember.Opts{Events: gc.events})
 		var manageWg sync.WaitGroup
 		actor.Spawn(gc.mgrActorID, &manageWg, gc.runManager)
 		gc.dispatcher.Start()
@@ -109,6 +152,8 @@ func (gc *T) Start(stoppedCh chan<- dispatcher.Tier) {
 		gc.dispatcher.Stop()
 		gc.groupMember.Stop()
 		manageWg.Wait()
+		gc.metrics.Unregister("consumer.group_queue.depth", "group", gc.group)
+		gc.metrics.Unregister("consumer.message_buffer.depth", "group", gc.group)
 		gc.msgIStreamF.Stop()
 	})
 }
@@ -129,6 +174,7 @@ func (gc *T) runManager() {
 		topicConsumers        = make(map[string]*topiccsm.T)
 		topics                []string
 		subscriptions         map[string][]string
+		members               map[string][]string
 		ok                    = true
 		nilOrRetryCh          <-chan time.Time
 		nilOrRegistryTopicsCh chan<- []string
@@ -137,7 +183,17 @@ func (gc *T) runManager() {
 		retryScheduled        = false
 		stopped               = false
 		rebalanceResultCh     = make(chan error, 1)
+		partitionCounts       = make(map[string]int)
+		nilOrCheckCh          <-chan time.Time
+		nilOrSettleCh         <-chan time.Time
+		checkInProgress       = false
+		checkResultCh         = make(chan map[string][]int32, 1)
 	)
+	if gc.cfg.Consumer.PartitionCheckInterval > 0 {
+		checkTicker := time.NewTicker(gc.cfg.Consumer.PartitionCheckInterval)
+		defer checkTicker.Stop()
+		nilOrCheckCh = checkTicker.C
+	}
 	for {
 		select {
 		case tc := <-gc.topicCsmLifespanCh:
@@ -145,9 +201,11 @@ func (gc *T) runManager() {
 			// particular topic at a time.
 			if topicConsumers[tc.Topic()] == tc {
 				delete(topicConsumers, tc.Topic())
+				delete(partitionCounts, tc.Topic())
 			} else {
 				topicConsumers[tc.Topic()] = tc
 			}
+			gc.metrics.Gauge("consumer.group.topics", "group", gc.group).Update(int64(len(topicConsumers)))
 			topics = listTopics(topicConsumers)
 			nilOrRegistryTopicsCh = gc.groupMember.Topics()
 			continue
@@ -156,6 +214,8 @@ func (gc *T) runManager() {
 			continue
 		case subscriptions, ok = <-gc.groupMember.Subscriptions():
 			nilOrRetryCh = nil
+			gc.recordMembership(members, subscriptions)
+			members = subscriptions
 			if !ok {
 				if !rebalancingInProgress {
 					goto done
@@ -180,6 +240,37 @@ func (gc *T) runManager() {
 
 		case <-nilOrRetryCh:
 			retryScheduled = false
+
+		case <-nilOrCheckCh:
+			if checkInProgress || len(topics) == 0 {
+				continue
+			}
+			topics := topics
+			actor.Spawn(gc.mgrActorID.NewChild("partition_check"), nil, func() {
+				checkResultCh <- gc.fetchPartitions(topics)
+			})
+			checkInProgress = true
+			continue
+		case partitions := <-checkResultCh:
+			checkInProgress = false
+			added := detectAddedPartitions(partitionCounts, partitions)
+			if len(added) == 0 {
+				continue
+			}
+			gc.expandPartitions(added)
+			// Give added partitions time to settle before assigning them.
+			if nilOrSettleCh == nil {
+				nilOrSettleCh = time.After(gc.cfg.Consumer.PartitionSettleDelay)
+			}
+			continue
+		case <-nilOrSettleCh:
+			nilOrSettleCh = nil
+			// Until subscriptions are known the group is not rebalanced, and
+			// when it is, added partitions are assigned anyway.
+			if subscriptions == nil {
+				continue
+			}
+			rebalancingRequired = true
 		}
 
 		if rebalancingRequired && !rebalancingInProgress && !retryScheduled {
@@ -208,6 +299,9 @@ done:
 		}(mux)
 	}
 	wg.Wait()
+	gc.notifyAssignment(nil)
+	gc.metrics.Gauge("consumer.group.topics", "group", gc.group).Update(0)
+	gc.updateActorCount()
 }
 
 func (gc *T) runRebalancing(actorID *actor.ID, topicConsumers map[string]*topiccsm.T,
@@ -235,25 +329,167 @@ func (gc *T) runRebalancing(actorID *actor.ID, topicConsumers map[string]*topicc
 		}
 		topic := topic
 		spawnInFn := func(partition int32) multiplexer.In {
-			return partitioncsm.Spawn(gc.supActorID, gc.group, topic, partition,
-				gc.cfg, gc.groupMember, gc.msgIStreamF, gc.offsetMgrF)
+			return partitioncsm.SpawnWithOpts(gc.supActorID, gc.group, topic, partition,
+				gc.cfg, gc.groupMember, gc.msgIStreamF, gc.offsetMgrF,
+				partitioncsm.Opts{OffsetReset: gc.offsetResetOf(topic, partition, tc.OffsetReset())})
 		}
 		mux = multiplexer.New(gc.supActorID, spawnInFn)
 		gc.rewireMuxAsync(topic, &wg, mux, tc, assignedTopicPartitions)
 		gc.multiplexers[topic] = mux
 	}
 	wg.Wait()
+	// Only topics that have consumers are actually consumed.
+	for topic := range assignedPartitions {
+		if topicConsumers[topic] == nil {
+			delete(assignedPartitions, topic)
+		}
+	}
+	gc.notifyAssignment(assignedPartitions)
 	// Clean up gears for topics that do not have assigned partitions anymore.
 	for topic, mux := range gc.multiplexers {
 		if !mux.IsRunning() {
 			delete(gc.multiplexers, topic)
 		}
 	}
+	gc.updateActorCount()
 	// Notify the caller that rebalancing has completed successfully.
 	rebalanceResultCh <- nil
 	return
 }
 
+// notifyAssignment emits events of partitions revoked since the previous call,
+// followed by events of newly assigned partitions.
+func (gc *T) notifyAssignment(assigned map[string][]int32) {
+	partitionCount := 0
+	for _, partitions := range assigned {
+		partitionCount += len(partitions)
+	}
+	gc.metrics.Gauge("consumer.group.partitions", "group", gc.group).Update(int64(partitionCount))
+	for topic, partitions := range gc.assigned {
+		gc.events.Notify(gc.group, groupevents.Revoked, topic, subtractPartitions(partitions, assigned[topic]))
+	}
+	for topic, partitions := range assigned {
+		gc.events.Notify(gc.group, groupevents.Assigned, topic, subtractPartitions(partitions, gc.assigned[topic]))
+	}
+	gc.assigned = assigned
+}
+
+// fetchPartitions refreshes metadata of the topics and returns their
+// partitions. Topics which partitions could not be fetched are omitted.
+func (gc *T) fetchPartitions(topics []string) map[string][]int32 {
+	if err := gc.refreshMetadataFn(topics...); err != nil {
+		log.Errorf("<%s> failed to refresh metadata: topics=%v, err=(%s)", gc.mgrActorID, topics, err)
+	}
+	partitions := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		topicPartitions, err := gc.fetchTopicPartitionsFn(topic)
+		if err != nil {
+			log.Errorf("<%s> failed to get partition list: topic=%s, err=(%s)", gc.mgrActorID, topic, err)
+			continue
+		}
+		partitions[topic] = topicPartitions
+	}
+	return partitions
+}
+
+// expandPartitions emits events of partitions added to topics, and makes the
+// group consume them from the earliest offset, see offsetResetOf.
+func (gc *T) expandPartitions(added map[string][]int32) {
+	gc.expandedMu.Lock()
+	if gc.expanded == nil {
+		gc.expanded = make(map[string]map[int32]bool)
+	}
+	for topic, partitions := range added {
+		if gc.expanded[topic] == nil {
+			gc.expanded[topic] = make(map[int32]bool)
+		}
+		for _, partition := range partitions {
+			gc.expanded[topic][partition] = true
+		}
+	}
+	gc.expandedMu.Unlock()
+	for topic, partitions := range added {
+		log.Infof("<%s> partitions added: topic=%s, partitions=%v", gc.mgrActorID, topic, partitions)
+		gc.events.Notify(gc.group, groupevents.Expanded, topic, partitions)
+	}
+}
+
+// offsetResetOf returns where the group starts consuming a partition that it
+// has no committed offset for. Partitions added while the group was consuming
+// the topic are consumed from the earliest offset, unless consume requests
+// tell otherwise, for otherwise messages produced to them before they were
+// assigned would be skipped.
+func (gc *T) offsetResetOf(topic string, partition int32, offsetReset consumer.OffsetReset) consumer.OffsetReset {
+	if !offsetReset.IsZero() {
+		return offsetReset
+	}
+	gc.expandedMu.Lock()
+	defer gc.expandedMu.Unlock()
+	if gc.expanded[topic][partition] {
+		offsetReset.Policy = config.OffsetResetEarliest
+	}
+	return offsetReset
+}
+
+// detectAddedPartitions updates partition counts of topics, and returns
+// partitions added to topics since the previous call. Partitions of topics
+// seen for the first time are not considered added.
+func detectAddedPartitions(counts map[string]int, partitions map[string][]int32) map[string][]int32 {
+	var added map[string][]int32
+	for topic, topicPartitions := range partitions {
+		prev, ok := counts[topic]
+		counts[topic] = len(topicPartitions)
+		if !ok || len(topicPartitions) <= prev {
+			continue
+		}
+		var topicAdded []int32
+		for _, partition := range topicPartitions {
+			if partition >= int32(prev) {
+				topicAdded = append(topicAdded, partition)
+			}
+		}
+		if len(topicAdded) == 0 {
+			continue
+		}
+		sort.Sort(Int32Slice(topicAdded))
+		if added == nil {
+			added = make(map[string][]int32)
+		}
+		added[topic] = topicAdded
+	}
+	return added
+}
+
+// recordMembership records transitions of members that joined or left the
+// group, as told by successive subscriptions.
+func (gc *T) recordMembership(prev, next map[string][]string) {
+	for _, member := range subtractMembers(next, prev) {
+		gc.events.Record(gc.group, groupevents.Transition{Kind: groupevents.MemberJoined, Member: member})
+	}
+	for _, member := range subtractMembers(prev, next) {
+		gc.events.Record(gc.group, groupevents.Transition{Kind: groupevents.MemberLeft, Member: member})
+	}
+}
+
+// subtractMembers returns sorted IDs of members in `lhs` that are not in
+// `rhs`.
+func subtractMembers(lhs, rhs map[string][]string) []string {
+	var members []string
+	for member := range lhs {
+		if _, ok := rhs[member]; !ok {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// updateActorCount reports the number of goroutines running on behalf of the
+// group, that is mostly partition consumers and their message streams.
+func (gc *T) updateActorCount() {
+	gc.metrics.Gauge("consumer.group.actors", "group", gc.group).Update(int64(actor.Count(gc.supActorID)))
+}
+
 // rewireMuxAsync calls muxInputs in another goroutine.
 func (gc *T) rewireMuxAsync(topic string, wg *sync.WaitGroup, mux *multiplexer.T, tc *topiccsm.T, assigned []int32) {
 	actor.Spawn(gc.supActorID.NewChild("rewire", topic), wg, func() {
@@ -291,8 +527,25 @@ func (gc *T) resolvePartitions(subscriptions map[string][]string) (
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to get partition list, topic=%s", topic)
 		}
-		subscribersToPartitions := assignTopicPartitions(topicPartitions, topicsToMembers[topic])
+		// Pinned partitions are excluded from automatic assignment.
+		pins := gc.partitionPins(topic)
+		var unpinned, pinnedToMe []int32
+		for _, partition := range topicPartitions {
+			member, ok := pins[partition]
+			if !ok {
+				unpinned = append(unpinned, partition)
+				continue
+			}
+			if member == gc.cfg.ClientID {
+				pinnedToMe = append(pinnedToMe, partition)
+			}
+		}
+		subscribersToPartitions := assignTopicPartitions(unpinned, topicsToMembers[topic])
 		assignedTopicPartitions := subscribersToPartitions[gc.cfg.ClientID]
+		if len(pinnedToMe) > 0 {
+			assignedTopicPartitions = append(append([]int32(nil), assignedTopicPartitions...), pinnedToMe...)
+			sort.Sort(Int32Slice(assignedTopicPartitions))
+		}
 		if len(assignedTopicPartitions) > 0 {
 			assignedPartitions[topic] = assignedTopicPartitions
 		}
@@ -300,6 +553,24 @@ func (gc *T) resolvePartitions(subscriptions map[string][]string) (
 	return assignedPartitions, nil
 }
 
+// partitionPins returns partitions of the topic pinned to particular group
+// members, mapped to the member IDs.
+func (gc *T) partitionPins(topic string) map[int32]string {
+	var pins map[int32]string
+	for _, pin := range gc.cfg.PartitionPins {
+		if pin.Group != gc.group || pin.Topic != topic {
+			continue
+		}
+		if pins == nil {
+			pins = make(map[int32]string)
+		}
+		for _, partition := range pin.Partitions {
+			pins[partition] = pin.Member
+		}
+	}
+	return pins
+}
+
 // assignTopicPartitions divides topic partitions among all consumer group
 // members subscribed to the topic. The algorithm used closely resembles the
 // one implemented by the standard Java High-Level consumer
@@ -335,6 +606,24 @@ func assignTopicPartitions(partitions []int32, subscribers []string) map[string]
 	return subscribersToPartitions
 }
 
+// subtractPartitions returns partitions from `a` that are not in `b`.
+func subtractPartitions(a, b []int32) []int32 {
+	var diff []int32
+	for _, p := range a {
+		found := false
+		for _, q := range b {
+			if p == q {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, p)
+		}
+	}
+	return diff
+}
+
 func listTopics(topicConsumers map[string]*topiccsm.T) []string {
 	topics := make([]string, 0, len(topicConsumers))
 	for topic := range topicConsumers {
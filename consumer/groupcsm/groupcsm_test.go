@@ -6,6 +6,8 @@ import (
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	. "gopkg.in/check.v1"
 )
@@ -126,6 +128,47 @@ func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 	})
 }
 
+// Pinned partitions are assigned to the members they are pinned to, and the
+// rest are distributed among the subscribed members as usual.
+func (s *GroupConsumerSuite) TestResolvePartitionsPinned(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.PartitionPins = []config.PartitionPin{
+		{Group: "g", Topic: "t1", Partitions: []int32{1}, Member: "b"},
+		{Group: "g", Topic: "t1", Partitions: []int32{5}, Member: "a"},
+		{Group: "g", Topic: "t2", Partitions: []int32{1}, Member: "x"},
+		{Group: "other", Topic: "t1", Partitions: []int32{2, 3}, Member: "b"},
+	}
+	gc := T{
+		cfg:   cfg,
+		group: "g",
+		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
+			return []int32{1, 2, 3, 4, 5}, nil
+		},
+	}
+	subscriptions := map[string][]string{
+		"a": {"t1", "t2"},
+		"b": {"t1", "t2"},
+	}
+
+	// When
+	cfg.ClientID = "a"
+	aPartitions, errA := gc.resolvePartitions(subscriptions)
+	cfg.ClientID = "b"
+	bPartitions, errB := gc.resolvePartitions(subscriptions)
+
+	// Then
+	c.Assert(errA, IsNil)
+	c.Assert(aPartitions, DeepEquals, map[string][]int32{
+		"t1": {2, 3, 5},
+		"t2": {2, 3},
+	})
+	c.Assert(errB, IsNil)
+	c.Assert(bPartitions, DeepEquals, map[string][]int32{
+		"t1": {1, 4},
+		"t2": {4, 5},
+	})
+}
+
 func (s *GroupConsumerSuite) TestResolvePartitionsEmpty(c *C) {
 	cfg := config.DefaultProxy()
 	cfg.ClientID = "c"
@@ -161,3 +204,112 @@ func (s *GroupConsumerSuite) TestResolvePartitionsError(c *C) {
 	c.Assert(err.Error(), Equals, "failed to get partition list, topic=t1: Kaboom!")
 	c.Assert(topicsToPartitions, IsNil)
 }
+
+// Revoked partitions are reported before assigned ones, and only changes are
+// reported.
+func (s *GroupConsumerSuite) TestNotifyAssignment(c *C) {
+	gc := T{group: "g", events: groupevents.New()}
+
+	// When
+	gc.notifyAssignment(map[string][]int32{"t1": {1, 2}})
+	gc.notifyAssignment(map[string][]int32{"t1": {2, 3}})
+	gc.notifyAssignment(nil)
+
+	// Then
+	events, _ := gc.events.Since("g", 0)
+	c.Assert(len(events), Equals, 4)
+	for i, want := range []struct {
+		kind       groupevents.Kind
+		partitions []int32
+	}{
+		{groupevents.Assigned, []int32{1, 2}},
+		{groupevents.Revoked, []int32{1}},
+		{groupevents.Assigned, []int32{3}},
+		{groupevents.Revoked, []int32{2, 3}},
+	} {
+		c.Assert(events[i].Seq, Equals, int64(i+1))
+		c.Assert(events[i].Kind, Equals, want.kind)
+		c.Assert(events[i].Topic, Equals, "t1")
+		c.Assert(events[i].Partitions, DeepEquals, want.partitions)
+	}
+}
+
+// Partitions are only considered added if the partition count of a topic has
+// grown since it was seen last time.
+func (s *GroupConsumerSuite) TestDetectAddedPartitions(c *C) {
+	counts := make(map[string]int)
+
+	// When/Then
+	c.Assert(detectAddedPartitions(counts, map[string][]int32{"t1": {0, 1}, "t2": {0}}), IsNil)
+	c.Assert(detectAddedPartitions(counts, map[string][]int32{"t1": {3, 0, 2, 1}}),
+		DeepEquals, map[string][]int32{"t1": {2, 3}})
+	c.Assert(detectAddedPartitions(counts, map[string][]int32{"t1": {0, 1, 2, 3}, "t2": {0}}), IsNil)
+	// A topic recreated with fewer partitions.
+	c.Assert(detectAddedPartitions(counts, map[string][]int32{"t1": {0}}), IsNil)
+	c.Assert(detectAddedPartitions(counts, map[string][]int32{"t1": {0, 1}, "t2": {0, 1, 2}}),
+		DeepEquals, map[string][]int32{"t1": {1}, "t2": {1, 2}})
+	c.Assert(counts, DeepEquals, map[string]int{"t1": 2, "t2": 3})
+}
+
+// Added partitions are reported by events, and consumed from the earliest
+// offset unless consume requests tell otherwise.
+func (s *GroupConsumerSuite) TestExpandPartitions(c *C) {
+	var refreshed []string
+	gc := T{
+		mgrActorID: s.ns.NewChild("manager"),
+		group:      "g",
+		events:     groupevents.New(),
+		refreshMetadataFn: func(topics ...string) error {
+			refreshed = append(refreshed, topics...)
+			return errors.New("kaboom")
+		},
+		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
+			if topic == "t2" {
+				return nil, errors.New("unknown topic")
+			}
+			return []int32{0, 1, 2}, nil
+		},
+	}
+	partitions := gc.fetchPartitions([]string{"t1", "t2"})
+	c.Assert(refreshed, DeepEquals, []string{"t1", "t2"})
+	c.Assert(partitions, DeepEquals, map[string][]int32{"t1": {0, 1, 2}})
+
+	// When
+	gc.expandPartitions(detectAddedPartitions(map[string]int{"t1": 1}, partitions))
+
+	// Then
+	events, _ := gc.events.Since("g", 0)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, groupevents.Expanded)
+	c.Assert(events[0].Topic, Equals, "t1")
+	c.Assert(events[0].Partitions, DeepEquals, []int32{1, 2})
+
+	c.Assert(gc.offsetResetOf("t1", 0, consumer.OffsetReset{}), DeepEquals, consumer.OffsetReset{})
+	c.Assert(gc.offsetResetOf("t1", 2, consumer.OffsetReset{}),
+		DeepEquals, consumer.OffsetReset{Policy: config.OffsetResetEarliest})
+	c.Assert(gc.offsetResetOf("t2", 2, consumer.OffsetReset{}), DeepEquals, consumer.OffsetReset{})
+	latest := consumer.OffsetReset{Policy: config.OffsetResetLatest}
+	c.Assert(gc.offsetResetOf("t1", 2, latest), DeepEquals, latest)
+}
+
+// Members that join and leave the group are recorded as transitions.
+func (s *GroupConsumerSuite) TestRecordMembership(c *C) {
+	gc := T{group: "g", events: groupevents.New()}
+
+	// When
+	gc.recordMembership(nil, map[string][]string{"m1": {"t1"}, "m2": {"t1"}})
+	gc.recordMembership(map[string][]string{"m1": {"t1"}, "m2": {"t1"}}, map[string][]string{"m2": {"t2"}, "m3": {"t1"}})
+
+	// Then
+	gs := gc.events.State("g")
+	c.Assert(gs.Counts, DeepEquals, map[groupevents.TransitionKind]int64{
+		groupevents.MemberJoined: 3,
+		groupevents.MemberLeft:   1,
+	})
+	var members []string
+	for _, tr := range gs.Recent {
+		members = append(members, string(tr.Kind)+":"+tr.Member)
+	}
+	c.Assert(members, DeepEquals, []string{
+		"member_joined:m1", "member_joined:m2", "member_joined:m3", "member_left:m1"})
+}
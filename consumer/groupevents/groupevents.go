@@ -0,0 +1,179 @@
+package groupevents
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+const (
+	// Assigned is the kind of events emitted when partitions of a topic are
+	// assigned to this Kafka-Pixy instance as a member of a consumer group.
+	Assigned Kind = "assigned"
+
+	// Revoked is the kind of events emitted when partitions of a topic are
+	// taken away from this Kafka-Pixy instance. By the time the event is
+	// emitted offsets of the partitions have already been committed.
+	Revoked Kind = "revoked"
+
+	// Expanded is the kind of events emitted when partitions are added to
+	// a topic consumed by a consumer group. The event lists the added
+	// partitions, they are assigned once `consumer.partition_settle_delay`
+	// elapses.
+	Expanded Kind = "expanded"
+
+	// Number of most recent events retained per consumer group.
+	historySize = 64
+)
+
+type Kind string
+
+// Event describes a change of partition assignment of a consumer group.
+type Event struct {
+	// Sequence number of the event within the consumer group. It is
+	// monotonically increasing and allows clients to resume watching
+	// from where they left off.
+	Seq        int64     `json:"seq"`
+	Kind       Kind      `json:"kind"`
+	Topic      string    `json:"topic"`
+	Partitions []int32   `json:"partitions"`
+	Time       time.Time `json:"time"`
+}
+
+// T keeps a bounded history of partition assignment events for every consumer
+// group and lets any number of watchers wait for new ones. It also keeps a
+// summary of state machine transitions of every group, see Record. A nil
+// instance is valid, it just ignores all events.
+type T struct {
+	mu          sync.Mutex
+	groups      map[string]*groupHistory
+	transitions map[string]*groupTransitions
+	listeners   map[Kind][]func(group string, ev Event)
+	metrics     *metrics.Registry
+
+	// Closed when the first event of a consumer group is recorded, so that
+	// watchers of groups that have no history yet can wait for it. Groups
+	// get a history only when an event is recorded for them, so that
+	// watching groups that this instance does not host takes no memory.
+	newGroupCh chan none.T
+}
+
+type groupHistory struct {
+	lastSeq   int64
+	events    []Event
+	changedCh chan none.T
+}
+
+// New creates an empty event history.
+func New() *T {
+	return &T{
+		groups:      make(map[string]*groupHistory),
+		transitions: make(map[string]*groupTransitions),
+		listeners:   make(map[Kind][]func(group string, ev Event)),
+		newGroupCh:  make(chan none.T),
+	}
+}
+
+// OnNotify registers a function to be called with every event of the
+// specified kind. It is called asynchronously, so that a slow listener does
+// not hold up the rebalancing that emitted the event. Hence listeners can be
+// called with several events concurrently and out of order.
+func (t *T) OnNotify(kind Kind, fn func(group string, ev Event)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listeners[kind] = append(t.listeners[kind], fn)
+}
+
+// Notify records an event of the specified kind for a consumer group and
+// wakes up all watchers of the group.
+func (t *T) Notify(group string, kind Kind, topic string, partitions []int32) {
+	if t == nil || len(partitions) == 0 {
+		return
+	}
+	t.mu.Lock()
+	gh := t.group(group)
+	gh.lastSeq++
+	ev := Event{
+		Seq:        gh.lastSeq,
+		Kind:       kind,
+		Topic:      topic,
+		Partitions: append([]int32(nil), partitions...),
+		Time:       time.Now().UTC(),
+	}
+	gh.events = append(gh.events, ev)
+	if len(gh.events) > historySize {
+		gh.events = gh.events[len(gh.events)-historySize:]
+	}
+	close(gh.changedCh)
+	gh.changedCh = make(chan none.T)
+	listeners := t.listeners[kind]
+	t.mu.Unlock()
+	if len(listeners) > 0 {
+		go func() {
+			for _, fn := range listeners {
+				fn(group, ev)
+			}
+		}()
+	}
+}
+
+// Since returns retained events of a consumer group with sequence numbers
+// greater then `seq`, along with a channel that is closed when a new event is
+// recorded for the group. If the returned list is empty, then callers can
+// wait on the channel and call Since again. If the group has no events yet,
+// then the channel is closed when the first event of any group is recorded.
+func (t *T) Since(group string, seq int64) ([]Event, <-chan none.T) {
+	if t == nil {
+		return nil, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gh := t.groups[group]
+	if gh == nil {
+		return nil, t.newGroupCh
+	}
+	var events []Event
+	for _, ev := range gh.events {
+		if ev.Seq > seq {
+			events = append(events, ev)
+		}
+	}
+	return events, gh.changedCh
+}
+
+// Wait returns events of a consumer group with sequence numbers greater then
+// `seq`. If there are none, then it blocks until an event is recorded, the
+// timeout expires, or `cancelCh` is closed, whatever happens first. An empty
+// list is returned in the last two cases.
+func (t *T) Wait(group string, seq int64, timeout time.Duration, cancelCh <-chan struct{}) []Event {
+	timeoutCh := time.After(timeout)
+	for {
+		events, changedCh := t.Since(group, seq)
+		if len(events) > 0 {
+			return events
+		}
+		select {
+		case <-changedCh:
+		case <-timeoutCh:
+			return nil
+		case <-cancelCh:
+			return nil
+		}
+	}
+}
+
+func (t *T) group(group string) *groupHistory {
+	gh := t.groups[group]
+	if gh == nil {
+		gh = &groupHistory{changedCh: make(chan none.T)}
+		t.groups[group] = gh
+		close(t.newGroupCh)
+		t.newGroupCh = make(chan none.T)
+	}
+	return gh
+}
@@ -0,0 +1,236 @@
+package groupevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/metrics"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type GroupEventsSuite struct{}
+
+var _ = Suite(&GroupEventsSuite{})
+
+// A nil instance ignores events and waits until the timeout expires.
+func (s *GroupEventsSuite) TestNil(c *C) {
+	var t *T
+	t.Notify("g", Assigned, "t", []int32{1})
+	events, _ := t.Since("g", 0)
+	c.Assert(events, IsNil)
+	c.Assert(t.Wait("g", 0, 10*time.Millisecond, nil), IsNil)
+	t.Record("g", Transition{Kind: FetchStarted})
+	c.Assert(t.State("g").Counts, HasLen, 0)
+	t.OnNotify(Expanded, func(string, Event) {})
+}
+
+func (s *GroupEventsSuite) TestSince(c *C) {
+	t := New()
+	t.Notify("g1", Assigned, "t", []int32{1, 2})
+	t.Notify("g2", Assigned, "t", []int32{3})
+	t.Notify("g1", Revoked, "t", []int32{2})
+	t.Notify("g1", Revoked, "t", nil)
+
+	// When
+	all, _ := t.Since("g1", 0)
+	last, _ := t.Since("g1", 1)
+	none, _ := t.Since("g1", 2)
+
+	// Then
+	c.Assert(len(all), Equals, 2)
+	c.Assert(all[0].Kind, Equals, Assigned)
+	c.Assert(all[0].Partitions, DeepEquals, []int32{1, 2})
+	c.Assert(last, DeepEquals, all[1:])
+	c.Assert(last[0].Seq, Equals, int64(2))
+	c.Assert(last[0].Kind, Equals, Revoked)
+	c.Assert(none, IsNil)
+}
+
+// Only the most recent events are retained.
+func (s *GroupEventsSuite) TestHistorySize(c *C) {
+	t := New()
+	for i := 0; i < historySize+10; i++ {
+		t.Notify("g", Assigned, "t", []int32{int32(i)})
+	}
+
+	// When
+	events, _ := t.Since("g", 0)
+
+	// Then
+	c.Assert(len(events), Equals, historySize)
+	c.Assert(events[0].Seq, Equals, int64(11))
+}
+
+// Wait blocks until an event is recorded.
+func (s *GroupEventsSuite) TestWait(c *C) {
+	t := New()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		t.Notify("g", Assigned, "t", []int32{1})
+	}()
+
+	// When
+	events := t.Wait("g", 0, 3*time.Second, nil)
+
+	// Then
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Partitions, DeepEquals, []int32{1})
+}
+
+// Listeners are called with events of the kind they registered for.
+func (s *GroupEventsSuite) TestOnNotify(c *C) {
+	t := New()
+	expandedCh := make(chan Event, 2)
+	t.OnNotify(Expanded, func(group string, ev Event) {
+		c.Check(group, Equals, "g")
+		// The instance is not locked while listeners are called.
+		t.Since(group, 0)
+		expandedCh <- ev
+	})
+
+	// When
+	t.Notify("g", Assigned, "t", []int32{1})
+	t.Notify("g", Expanded, "t", []int32{4, 5})
+	t.Notify("g", Expanded, "t", nil)
+
+	// Then
+	select {
+	case ev := <-expandedCh:
+		c.Assert(ev.Seq, Equals, int64(2))
+		c.Assert(ev.Topic, Equals, "t")
+		c.Assert(ev.Partitions, DeepEquals, []int32{4, 5})
+	case <-time.After(3 * time.Second):
+		c.Fatal("listener is not called")
+	}
+	select {
+	case ev := <-expandedCh:
+		c.Fatalf("unexpected event: %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Listeners do not hold up Notify.
+func (s *GroupEventsSuite) TestOnNotifyAsync(c *C) {
+	t := New()
+	releaseCh := make(chan struct{})
+	defer close(releaseCh)
+	t.OnNotify(Assigned, func(string, Event) { <-releaseCh })
+
+	// When
+	t.Notify("g", Assigned, "t", []int32{1})
+	t.Notify("g", Assigned, "t", []int32{2})
+
+	// Then
+	events, _ := t.Since("g", 0)
+	c.Assert(len(events), Equals, 2)
+}
+
+// Watching a group that has no events does not make a history for it, and
+// watchers are woken up once its first event is recorded.
+func (s *GroupEventsSuite) TestWaitUnknownGroup(c *C) {
+	t := New()
+	events, changedCh := t.Since("g", 0)
+	c.Assert(events, IsNil)
+	c.Assert(t.groups, HasLen, 0)
+
+	// When
+	t.Notify("g", Assigned, "t", []int32{1})
+
+	// Then
+	select {
+	case <-changedCh:
+	default:
+		c.Fatal("watcher is not woken up")
+	}
+	events, _ = t.Since("g", 0)
+	c.Assert(len(events), Equals, 1)
+}
+
+func (s *GroupEventsSuite) TestWaitCancelled(c *C) {
+	t := New()
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+	c.Assert(t.Wait("g", 0, 3*time.Second, cancelCh), IsNil)
+}
+
+// Transitions are counted per kind, along with the time of the last one.
+func (s *GroupEventsSuite) TestRecord(c *C) {
+	registry := metrics.New()
+	t := NewWithMetrics(registry)
+	t.Record("g1", Transition{Kind: PartitionClaimed, Topic: "t", Partition: 1})
+	t.Record("g1", Transition{Kind: FetchStarted, Topic: "t", Partition: 1})
+	t.Record("g2", Transition{Kind: PartitionClaimed, Topic: "t", Partition: 2})
+	t.Record("g1", Transition{Kind: PartitionClaimed, Topic: "t", Partition: 3})
+
+	// When
+	gs := t.State("g1")
+
+	// Then
+	c.Assert(gs.Counts, DeepEquals, map[TransitionKind]int64{PartitionClaimed: 2, FetchStarted: 1})
+	c.Assert(gs.LastTransitions[PartitionClaimed], Equals, gs.Recent[2].Time)
+	c.Assert(gs.LastTransitions[FetchStarted], Equals, gs.Recent[1].Time)
+	c.Assert(len(gs.Recent), Equals, 3)
+	c.Assert(gs.Recent[2].Partition, Equals, int32(3))
+	c.Assert(registry.Counter("consumer.group.transitions", "group", "g1", "kind", "partition_claimed").Count(), Equals, int64(2))
+	c.Assert(t.State("g3").Counts, HasLen, 0)
+}
+
+// Only the most recent transitions are retained, but all are counted.
+func (s *GroupEventsSuite) TestTransitionHistorySize(c *C) {
+	t := New()
+	for i := 0; i < transitionHistorySize+10; i++ {
+		t.Record("g", Transition{Kind: FetchStopped, Partition: int32(i)})
+	}
+
+	// When
+	gs := t.State("g")
+
+	// Then
+	c.Assert(len(gs.Recent), Equals, transitionHistorySize)
+	c.Assert(gs.Recent[0].Partition, Equals, int32(10))
+	c.Assert(gs.Counts[FetchStopped], Equals, int64(transitionHistorySize+10))
+}
+
+// Claims are reported sorted until they are cleared.
+func (s *GroupEventsSuite) TestClaims(c *C) {
+	t := New()
+	t.SetClaim("g", Claim{Topic: "t2", Partition: 1, Owner: "m1", Retries: 1})
+	t.SetClaim("g", Claim{Topic: "t1", Partition: 2, Owner: "m2", Retries: 1})
+	t.SetClaim("g", Claim{Topic: "t1", Partition: 1, Owner: "m2", Retries: 1})
+	t.SetClaim("g", Claim{Topic: "t1", Partition: 1, Owner: "m3", Retries: 2, Failed: true})
+	t.ClearClaim("g", "t1", 2)
+	t.ClearClaim("g2", "t1", 1)
+
+	// When
+	gs := t.State("g")
+
+	// Then
+	c.Assert(gs.Claims, DeepEquals, []Claim{
+		{Topic: "t1", Partition: 1, Owner: "m3", Retries: 2, Failed: true},
+		{Topic: "t2", Partition: 1, Owner: "m1", Retries: 1},
+	})
+	c.Assert(gs.Counts, HasLen, 0)
+}
+
+// A pending rebalancing is reported until it is cleared.
+func (s *GroupEventsSuite) TestPendingRebalance(c *C) {
+	t := New()
+	since := time.Now().UTC()
+	t.SetPendingRebalance("g", PendingRebalance{Since: since, Due: since.Add(time.Second), Changes: 1})
+	t.SetPendingRebalance("g", PendingRebalance{Since: since, Due: since.Add(2 * time.Second), Changes: 2})
+
+	// When
+	gs := t.State("g")
+	t.ClearPendingRebalance("g")
+
+	// Then
+	c.Assert(gs.PendingRebalance, DeepEquals, &PendingRebalance{Since: since, Due: since.Add(2 * time.Second), Changes: 2})
+	c.Assert(t.State("g").PendingRebalance, IsNil)
+	var nilT *T
+	nilT.SetPendingRebalance("g", PendingRebalance{Changes: 1})
+	c.Assert(nilT.State("g").PendingRebalance, IsNil)
+}
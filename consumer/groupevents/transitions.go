@@ -0,0 +1,251 @@
+package groupevents
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/log"
+)
+
+const (
+	// MemberJoined is recorded when a member shows up in subscriptions of
+	// the group, and MemberLeft when it disappears from them.
+	MemberJoined TransitionKind = "member_joined"
+	MemberLeft   TransitionKind = "member_left"
+
+	// PartitionClaimed is recorded when this Kafka-Pixy instance claims a
+	// partition in ZooKeeper, and PartitionReleased when it releases it.
+	PartitionClaimed  TransitionKind = "partition_claimed"
+	PartitionReleased TransitionKind = "partition_released"
+
+	// ClaimWaitStarted is recorded when a partition cannot be claimed right
+	// away, because it is still owned by another member. ClaimWaitTimedOut
+	// is recorded when the claim fails for `Consumer.ClaimTimeout` expired.
+	ClaimWaitStarted  TransitionKind = "claim_wait_started"
+	ClaimWaitTimedOut TransitionKind = "claim_wait_timed_out"
+
+	// FetchStarted and FetchStopped are recorded when fetching messages of a
+	// claimed partition starts and stops.
+	FetchStarted TransitionKind = "fetch_started"
+	FetchStopped TransitionKind = "fetch_stopped"
+
+	// Number of most recent transitions retained per consumer group. There
+	// are a few per partition on every rebalancing, so it is larger than the
+	// history of assignment events.
+	transitionHistorySize = 256
+)
+
+type TransitionKind string
+
+// Transition describes a change of a consumer group state machine. Topic and
+// Partition are empty for member transitions, and Member is empty for the
+// others.
+type Transition struct {
+	Kind      TransitionKind
+	Topic     string
+	Partition int32
+	Member    string
+	Time      time.Time
+}
+
+// GroupState summarizes state machine transitions of a consumer group
+// recorded since the proxy was started.
+type GroupState struct {
+	// Number of transitions of each kind.
+	Counts map[TransitionKind]int64
+
+	// Time of the last transition of each kind.
+	LastTransitions map[TransitionKind]time.Time
+
+	// Most recent transitions, oldest first.
+	Recent []Transition
+
+	// Partitions that this Kafka-Pixy instance failed to claim so far,
+	// sorted by topic and partition.
+	Claims []Claim
+
+	// Rebalancing that membership changes of the group are coalesced into,
+	// nil if there is none pending.
+	PendingRebalance *PendingRebalance
+}
+
+// PendingRebalance describes a rebalancing held until the end of the
+// rebalance window, see `Consumer.RebalanceWindow`.
+type PendingRebalance struct {
+	// When the first membership change of the window was noticed.
+	Since time.Time
+
+	// When subscriptions are going to be fetched and the group rebalanced.
+	Due time.Time
+
+	// Number of membership changes noticed since.
+	Changes int
+}
+
+// Claim describes a partition that this Kafka-Pixy instance is waiting to
+// claim, or has given up claiming, for it is owned by another member.
+type Claim struct {
+	Topic     string
+	Partition int32
+
+	// Member that owned the partition as of the last attempt to claim it.
+	Owner string
+
+	WaitingSince time.Time
+	Retries      int
+
+	// True if the claim timeout expired. Failed claims are reported until
+	// the next attempt to claim the partition.
+	Failed bool
+}
+
+type groupTransitions struct {
+	counts map[TransitionKind]int64
+	last   map[TransitionKind]time.Time
+	recent []Transition
+	claims map[claimID]Claim
+	rebal  *PendingRebalance
+}
+
+type claimID struct {
+	topic     string
+	partition int32
+}
+
+// NewWithMetrics creates an empty event history that also counts state
+// machine transitions in the `consumer.group.transitions` metric.
+func NewWithMetrics(registry *metrics.Registry) *T {
+	t := New()
+	t.metrics = registry
+	return t
+}
+
+// Record records a state machine transition of a consumer group, logging it
+// and counting it in metrics. Unlike assignment events transitions are not
+// watched, they are only summarized by State.
+func (t *T) Record(group string, tr Transition) {
+	if t == nil {
+		return
+	}
+	tr.Time = time.Now().UTC()
+	log.Infof("group transition: group=%s, kind=%s, topic=%s, partition=%d, member=%s",
+		group, tr.Kind, tr.Topic, tr.Partition, tr.Member)
+	t.metrics.Counter("consumer.group.transitions", "group", group, "kind", string(tr.Kind)).Inc(1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gt := t.groupTransitions(group)
+	gt.counts[tr.Kind]++
+	gt.last[tr.Kind] = tr.Time
+	gt.recent = append(gt.recent, tr)
+	if len(gt.recent) > transitionHistorySize {
+		gt.recent = gt.recent[len(gt.recent)-transitionHistorySize:]
+	}
+}
+
+// SetClaim records the state of a partition that cannot be claimed, for it
+// is owned by another member.
+func (t *T) SetClaim(group string, cl Claim) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groupTransitions(group).claims[claimID{cl.Topic, cl.Partition}] = cl
+}
+
+// ClearClaim forgets about a partition that has been claimed, or is not going
+// to be claimed anymore.
+func (t *T) ClearClaim(group, topic string, partition int32) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gt := t.transitions[group]; gt != nil {
+		delete(gt.claims, claimID{topic, partition})
+	}
+}
+
+// SetPendingRebalance records the state of a rebalancing that is held until
+// the end of the rebalance window.
+func (t *T) SetPendingRebalance(group string, pr PendingRebalance) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groupTransitions(group).rebal = &pr
+}
+
+// ClearPendingRebalance forgets about a pending rebalancing when it is done.
+func (t *T) ClearPendingRebalance(group string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gt := t.transitions[group]; gt != nil {
+		gt.rebal = nil
+	}
+}
+
+// State returns a summary of state machine transitions of a consumer group.
+// Maps of the returned state are empty if nothing has been recorded for the
+// group.
+func (t *T) State(group string) GroupState {
+	gs := GroupState{
+		Counts:          make(map[TransitionKind]int64),
+		LastTransitions: make(map[TransitionKind]time.Time),
+	}
+	if t == nil {
+		return gs
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gt := t.transitions[group]
+	if gt == nil {
+		return gs
+	}
+	for kind, count := range gt.counts {
+		gs.Counts[kind] = count
+	}
+	for kind, last := range gt.last {
+		gs.LastTransitions[kind] = last
+	}
+	gs.Recent = append([]Transition(nil), gt.recent...)
+	for _, cl := range gt.claims {
+		gs.Claims = append(gs.Claims, cl)
+	}
+	sort.Sort(byTopicPartition(gs.Claims))
+	if gt.rebal != nil {
+		pr := *gt.rebal
+		gs.PendingRebalance = &pr
+	}
+	return gs
+}
+
+func (t *T) groupTransitions(group string) *groupTransitions {
+	gt := t.transitions[group]
+	if gt == nil {
+		gt = &groupTransitions{
+			counts: make(map[TransitionKind]int64),
+			last:   make(map[TransitionKind]time.Time),
+			claims: make(map[claimID]Claim),
+		}
+		t.transitions[group] = gt
+	}
+	return gt
+}
+
+type byTopicPartition []Claim
+
+func (a byTopicPartition) Len() int      { return len(a) }
+func (a byTopicPartition) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byTopicPartition) Less(i, j int) bool {
+	if a[i].Topic != a[j].Topic {
+		return a[i].Topic < a[j].Topic
+	}
+	return a[i].Partition < a[j].Partition
+}
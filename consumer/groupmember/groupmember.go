@@ -7,6 +7,7 @@ import (
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/log"
 	"github.com/pkg/errors"
@@ -19,6 +20,10 @@ import (
 // first several failures to claim a partition as an error.
 const safeClaimRetriesCount = 10
 
+// ErrClaimTimeout is returned when a partition is owned by another member of
+// the group for longer than the claim timeout.
+var ErrClaimTimeout = errors.New("claim timeout")
+
 // T maintains a consumer group member registration in ZooKeeper, watches for
 // other members to join, leave and update their subscriptions, and generates
 // notifications of such changes.
@@ -32,6 +37,7 @@ type T struct {
 	subscriptions    map[string][]string
 	topicsCh         chan []string
 	subscriptionsCh  chan map[string][]string
+	events           *groupevents.T
 	stopCh           chan none.T
 	wg               sync.WaitGroup
 }
@@ -39,6 +45,19 @@ type T struct {
 // Spawn creates a consumer group member instance and starts its background
 // goroutines.
 func Spawn(namespace *actor.ID, group, memberID string, cfg *config.Proxy, kazooClt *kazoo.Kazoo) *T {
+	return SpawnWithOpts(namespace, group, memberID, cfg, kazooClt, Opts{})
+}
+
+// Opts are optional parameters of a consumer group member.
+type Opts struct {
+	// If given, then partition claim transitions are recorded to it.
+	Events *groupevents.T
+}
+
+// SpawnWithOpts is like Spawn, but takes optional parameters.
+func SpawnWithOpts(namespace *actor.ID, group, memberID string, cfg *config.Proxy, kazooClt *kazoo.Kazoo,
+	opts Opts,
+) *T {
 	groupZNode := kazooClt.Consumergroup(group)
 	groupMemberZNode := groupZNode.Instance(memberID)
 	gm := &T{
@@ -49,6 +68,7 @@ func Spawn(namespace *actor.ID, group, memberID string, cfg *config.Proxy, kazoo
 		groupMemberZNode: groupMemberZNode,
 		topicsCh:         make(chan []string),
 		subscriptionsCh:  make(chan map[string][]string),
+		events:           opts.Events,
 		stopCh:           make(chan none.T),
 	}
 	actor.Spawn(gm.actorID, &gm.wg, gm.run)
@@ -73,25 +93,61 @@ func (gm *T) Subscriptions() <-chan map[string][]string {
 // consumer group. It blocks until either succeeds or canceled by the caller. It
 // returns a function that should be called to release the claim.
 func (gm *T) ClaimPartition(claimerActorID *actor.ID, topic string, partition int32, cancelCh <-chan none.T) func() {
+	release, _ := gm.ClaimPartitionWithTimeout(claimerActorID, topic, partition, 0, cancelCh)
+	return release
+}
+
+// ClaimPartitionWithTimeout is like ClaimPartition, but if `timeout` is
+// positive and the partition is still owned by another member when it
+// expires, then it gives up and returns an error caused by ErrClaimTimeout
+// that tells who owns the partition. While the claim is pending, and after
+// it has failed, its state is reported to group events.
+func (gm *T) ClaimPartitionWithTimeout(claimerActorID *actor.ID, topic string, partition int32,
+	timeout time.Duration, cancelCh <-chan none.T,
+) (func(), error) {
 	beginAt := time.Now()
 	retries := 0
 	logFailureFn := log.Infof
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timeoutCh = time.After(timeout)
+	}
 	err := gm.groupMemberZNode.ClaimPartition(topic, partition)
+	if err != nil {
+		gm.RecordTransition(groupevents.Transition{Kind: groupevents.ClaimWaitStarted, Topic: topic, Partition: partition})
+	}
 	for err != nil {
 		if retries++; retries > safeClaimRetriesCount {
 			logFailureFn = log.Errorf
 		}
-		logFailureFn("<%s> failed to claim partition: via=%s, retries=%d, took=%s, err=(%s)",
-			claimerActorID, gm.actorID, retries, millisSince(beginAt), err)
+		claim := groupevents.Claim{
+			Topic:        topic,
+			Partition:    partition,
+			Owner:        gm.partitionOwner(topic, partition),
+			WaitingSince: beginAt.UTC(),
+			Retries:      retries,
+		}
+		gm.events.SetClaim(gm.group, claim)
+		logFailureFn("<%s> failed to claim partition: via=%s, owner=%s, retries=%d, took=%s, err=(%s)",
+			claimerActorID, gm.actorID, claim.Owner, retries, millisSince(beginAt), err)
 		select {
 		case <-time.After(gm.cfg.Consumer.RetryBackoff):
+		case <-timeoutCh:
+			claim.Failed = true
+			gm.events.SetClaim(gm.group, claim)
+			gm.RecordTransition(groupevents.Transition{Kind: groupevents.ClaimWaitTimedOut, Topic: topic, Partition: partition})
+			return func() {}, errors.Wrapf(ErrClaimTimeout, "owner=%s, retries=%d, took=%s",
+				claim.Owner, retries, millisSince(beginAt))
 		case <-cancelCh:
-			return func() {}
+			gm.events.ClearClaim(gm.group, topic, partition)
+			return func() {}, nil
 		}
 		err = gm.groupMemberZNode.ClaimPartition(topic, partition)
 	}
+	gm.events.ClearClaim(gm.group, topic, partition)
 	log.Infof("<%s> partition claimed: via=%s, retries=%d, took=%s",
 		claimerActorID, gm.actorID, retries, millisSince(beginAt))
+	gm.RecordTransition(groupevents.Transition{Kind: groupevents.PartitionClaimed, Topic: topic, Partition: partition})
 	return func() {
 		beginAt := time.Now()
 		retries := 0
@@ -108,7 +164,24 @@ func (gm *T) ClaimPartition(claimerActorID *actor.ID, topic string, partition in
 		}
 		log.Infof("<%s> partition released: via=%s, retries=%d, took=%s",
 			claimerActorID, gm.actorID, retries, millisSince(beginAt))
+		gm.RecordTransition(groupevents.Transition{Kind: groupevents.PartitionReleased, Topic: topic, Partition: partition})
+	}, nil
+}
+
+// partitionOwner returns ID of the member that owns a partition, or an empty
+// string if it cannot be told.
+func (gm *T) partitionOwner(topic string, partition int32) string {
+	owner, err := gm.groupZNode.PartitionOwner(topic, partition)
+	if err != nil || owner == nil {
+		return ""
 	}
+	return owner.ID
+}
+
+// RecordTransition records a state machine transition of the group, if the
+// member was spawned with events.
+func (gm *T) RecordTransition(tr groupevents.Transition) {
+	gm.events.Record(gm.group, tr)
 }
 
 // Stop signals the consumer group member to stop and blocks until its
@@ -120,6 +193,7 @@ func (gm *T) Stop() {
 
 func (gm *T) run() {
 	defer close(gm.subscriptionsCh)
+	defer gm.events.ClearPendingRebalance(gm.group)
 
 	// Ensure a group ZNode exist.
 	err := gm.groupZNode.Create()
@@ -153,7 +227,10 @@ func (gm *T) run() {
 		shouldSubmitTopics       = false
 		shouldFetchMembers       = false
 		shouldFetchSubscriptions = false
-		members                  []*kazoo.ConsumergroupInstance
+		shouldRejoin             = false
+		members                  kazoo.ConsumergroupInstanceList
+		membershipChanged        = false
+		pendingRebalance         groupevents.PendingRebalance
 	)
 	for {
 		select {
@@ -163,9 +240,19 @@ func (gm *T) run() {
 		case nilOrSubscriptionsCh <- pendingSubscriptions:
 			nilOrSubscriptionsCh = nil
 			gm.subscriptions = pendingSubscriptions
+			// An evicted member registers again only after it is notified
+			// of subscriptions without itself, so that it releases all
+			// partitions first.
+			if shouldRejoin {
+				shouldRejoin = false
+				if !shouldSubmitTopics {
+					pendingTopics, shouldSubmitTopics = gm.topics, true
+				}
+			}
 		case <-nilOrGroupUpdatedCh:
 			nilOrGroupUpdatedCh = nil
 			shouldFetchMembers = true
+			membershipChanged = true
 		case <-nilOrTimeoutCh:
 		case <-gm.stopCh:
 			return
@@ -194,7 +281,12 @@ func (gm *T) run() {
 			// To avoid unnecessary rebalancing in case of a deregister/register
 			// sequences that happen when a member updates its topic subscriptions,
 			// we delay subscription fetching.
-			nilOrTimeoutCh = time.After(gm.cfg.Consumer.RebalanceDelay)
+			delay := gm.cfg.Consumer.RebalanceDelay
+			if membershipChanged {
+				membershipChanged = false
+				delay = gm.delayRebalance(&pendingRebalance)
+			}
+			nilOrTimeoutCh = time.After(delay)
 			continue
 		}
 
@@ -207,7 +299,17 @@ func (gm *T) run() {
 			}
 			shouldFetchSubscriptions = false
 			log.Infof("<%s> fetched subscriptions: %v", gm.actorID, pendingSubscriptions)
-			if subscriptionsEqual(pendingSubscriptions, gm.subscriptions) {
+			if pendingRebalance.Changes > 0 {
+				pendingRebalance = groupevents.PendingRebalance{}
+				gm.events.ClearPendingRebalance(gm.group)
+			}
+			// The member registration can be deleted by an administrator
+			// to evict the member from the group.
+			if gm.topics != nil && members.Find(gm.groupMemberZNode.ID) == nil {
+				log.Warningf("<%s> evicted from the group", gm.actorID)
+				shouldRejoin = true
+			}
+			if !shouldRejoin && subscriptionsEqual(pendingSubscriptions, gm.subscriptions) {
 				nilOrSubscriptionsCh = nil
 				pendingSubscriptions = nil
 				log.Infof("<%s> redundant group update ignored: %v", gm.actorID, gm.subscriptions)
@@ -218,6 +320,27 @@ func (gm *T) run() {
 	}
 }
 
+// delayRebalance accounts for a membership change of the group and returns
+// how long to wait before fetching subscriptions. The first change opens a
+// rebalance window, and all changes that follow within it are coalesced into
+// one rebalancing at the end of it, that is no earlier than RebalanceDelay
+// after the last change.
+func (gm *T) delayRebalance(pending *groupevents.PendingRebalance) time.Duration {
+	now := time.Now().UTC()
+	if pending.Changes == 0 {
+		pending.Since = now
+	}
+	pending.Changes++
+	delay := gm.cfg.Consumer.RebalanceDelay
+	if untilEnd := pending.Since.Add(gm.cfg.Consumer.RebalanceWindow).Sub(now); untilEnd > delay {
+		delay = untilEnd
+	}
+	pending.Due = now.Add(delay)
+	gm.events.SetPendingRebalance(gm.group, *pending)
+	log.Infof("<%s> rebalance pending: changes=%d, due=%s", gm.actorID, pending.Changes, pending.Due)
+	return delay
+}
+
 // fetchSubscriptions retrieves registration records for the specified members
 // from ZooKeeper.
 //
@@ -7,8 +7,10 @@ import (
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
 	"github.com/wvanbergen/kazoo-go"
 	. "gopkg.in/check.v1"
 )
@@ -224,6 +226,40 @@ func (s *GroupMemberSuite) TestMembershipChanges(c *C) {
 	c.Assert(<-gm3.Subscriptions(), DeepEquals, membership)
 }
 
+// Membership changes within the rebalance window are coalesced into one
+// update at the end of the window, that is reported as pending until then.
+func (s *GroupMemberSuite) TestRebalanceWindow(c *C) {
+	// Given
+	cfg := config.DefaultProxy()
+	cfg.Consumer.RebalanceDelay = 50 * time.Millisecond
+	cfg.Consumer.RebalanceWindow = 500 * time.Millisecond
+	events := groupevents.New()
+	gm1 := SpawnWithOpts(s.ns.NewChild("m1"), "g1", "m1", cfg, s.kazooClt, Opts{Events: events})
+	defer gm1.Stop()
+	gm1.Topics() <- []string{"foo"}
+	c.Assert(<-gm1.Subscriptions(), DeepEquals, map[string][]string{"m1": {"foo"}})
+	gm2 := Spawn(s.ns.NewChild("m2"), "g1", "m2", cfg, s.kazooClt)
+	defer gm2.Stop()
+	gm3 := Spawn(s.ns.NewChild("m3"), "g1", "m3", cfg, s.kazooClt)
+	defer gm3.Stop()
+	begin := time.Now()
+
+	// When
+	gm2.Topics() <- []string{"foo"}
+	time.Sleep(100 * time.Millisecond)
+	gm3.Topics() <- []string{"foo"}
+	time.Sleep(100 * time.Millisecond)
+	pending := events.State("g1").PendingRebalance
+
+	// Then
+	c.Assert(pending, NotNil)
+	c.Assert(pending.Changes, Equals, 2)
+	c.Assert(<-gm1.Subscriptions(), DeepEquals,
+		map[string][]string{"m1": {"foo"}, "m2": {"foo"}, "m3": {"foo"}})
+	c.Assert(time.Since(begin) >= 500*time.Millisecond, Equals, true)
+	c.Assert(events.State("g1").PendingRebalance, IsNil)
+}
+
 // When one of the group members generates a rapid sequence of subscription
 // changes so that at the end its subscription is the same as in the beginning
 // of the sequence then other members won't be notified of such changes.
@@ -256,6 +292,29 @@ func (s *GroupMemberSuite) TestRedundantUpdateIgnored(c *C) {
 	}
 }
 
+// If the registration of a member is deleted by somebody else, then the
+// member is notified of subscriptions without itself, and then registers
+// again.
+func (s *GroupMemberSuite) TestEvicted(c *C) {
+	// Given
+	cfg := config.DefaultProxy()
+	cfg.Consumer.RebalanceDelay = 100 * time.Millisecond
+	gm1 := Spawn(s.ns.NewChild("m1"), "g1", "m1", cfg, s.kazooClt)
+	defer gm1.Stop()
+	gm1.Topics() <- []string{"foo", "bar"}
+	c.Assert(<-gm1.Subscriptions(), DeepEquals,
+		map[string][]string{"m1": {"bar", "foo"}})
+
+	// When
+	err := s.kazooClt.Consumergroup("g1").Instance("m1").Deregister()
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Assert(<-gm1.Subscriptions(), DeepEquals, map[string][]string{})
+	c.Assert(<-gm1.Subscriptions(), DeepEquals,
+		map[string][]string{"m1": {"bar", "foo"}})
+}
+
 // When a group registrator claims a topic partitions it becomes its owner.
 func (s *GroupMemberSuite) TestClaimPartition(c *C) {
 	// Given
@@ -305,6 +364,36 @@ func (s *GroupMemberSuite) TestClaimPartitionClaimed(c *C) {
 	c.Assert(owner, Equals, "m1")
 }
 
+// If a partition is owned by another member for longer than the claim
+// timeout, then the claim fails, telling who owns the partition.
+func (s *GroupMemberSuite) TestClaimPartitionTimeout(c *C) {
+	// Given
+	cfg := config.DefaultProxy()
+	cfg.Consumer.RetryBackoff = 50 * time.Millisecond
+	events := groupevents.New()
+	gm1 := Spawn(s.ns.NewChild("m1"), "g1", "m1", cfg, s.kazooClt)
+	defer gm1.Stop()
+	gm2 := SpawnWithOpts(s.ns.NewChild("m2"), "g1", "m2", cfg, s.kazooClt, Opts{Events: events})
+	defer gm2.Stop()
+	cancelCh := make(chan none.T)
+	claim1 := gm1.ClaimPartition(s.ns, "foo", 1, cancelCh)
+	defer claim1()
+
+	// When
+	claim2, err := gm2.ClaimPartitionWithTimeout(s.ns, "foo", 1, 200*time.Millisecond, cancelCh)
+	defer claim2()
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrClaimTimeout)
+	c.Assert(err, ErrorMatches, "owner=m1, .*: claim timeout")
+	gs := events.State("g1")
+	c.Assert(gs.Counts[groupevents.ClaimWaitStarted], Equals, int64(1))
+	c.Assert(gs.Counts[groupevents.ClaimWaitTimedOut], Equals, int64(1))
+	c.Assert(len(gs.Claims), Equals, 1)
+	c.Assert(gs.Claims[0].Owner, Equals, "m1")
+	c.Assert(gs.Claims[0].Failed, Equals, true)
+}
+
 // It is ok to claim the same partition twice by the same group member.
 func (s *GroupMemberSuite) TestClaimPartitionTwice(c *C) {
 	// Given
@@ -0,0 +1,132 @@
+package keyqueue
+
+import (
+	"sort"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+)
+
+// T preserves the order of messages with the same key within a partition,
+// while messages with different keys are dispatched in parallel. A message is
+// admitted for offering only when there is no message with the same key in
+// flight, otherwise it is queued until all messages with the key before it
+// are acknowledged. Messages without a key are always admitted. It is not
+// safe for concurrent use. A nil instance is valid, it admits everything.
+type T struct {
+	maxQueueSize int
+
+	// Offsets of in flight messages by key, and keys of them by offset.
+	inFlight   map[string]int64
+	offsetKeys map[int64]string
+
+	queues    map[string][]consumer.Message
+	fullCount int
+}
+
+// New creates a key queue where at most `maxQueueSize` messages can wait for
+// a message with the same key to be acknowledged.
+func New(maxQueueSize int) *T {
+	return &T{
+		maxQueueSize: maxQueueSize,
+		inFlight:     make(map[string]int64),
+		offsetKeys:   make(map[int64]string),
+		queues:       make(map[string][]consumer.Message),
+	}
+}
+
+// Admit tells whether a message can be offered right away, in which case it is
+// considered in flight until it is acknowledged. Otherwise the message is
+// queued behind the in flight message with the same key.
+func (t *T) Admit(msg consumer.Message) bool {
+	if t == nil || msg.Key == nil {
+		return true
+	}
+	key := string(msg.Key)
+	if _, ok := t.inFlight[key]; !ok {
+		t.inFlight[key] = msg.Offset
+		t.offsetKeys[msg.Offset] = key
+		return true
+	}
+	queue := append(t.queues[key], msg)
+	t.queues[key] = queue
+	if len(queue) == t.maxQueueSize {
+		t.fullCount++
+	}
+	return false
+}
+
+// Full tells whether the queue of any key has reached the maximum size, and
+// so no more messages should be read from the partition, for they might have
+// the same key.
+func (t *T) Full() bool {
+	return t != nil && t.fullCount > 0
+}
+
+// OnAcked releases the key of an acknowledged message. If there is a message
+// queued behind it, then it is admitted and returned.
+func (t *T) OnAcked(offset int64) (consumer.Message, bool) {
+	if t == nil {
+		return consumer.Message{}, false
+	}
+	key, ok := t.offsetKeys[offset]
+	if !ok {
+		return consumer.Message{}, false
+	}
+	delete(t.offsetKeys, offset)
+	delete(t.inFlight, key)
+	return t.admitNext(key, offset)
+}
+
+// OnCheckpoint releases keys of all messages before the specified offset, as
+// a checkpoint acknowledges them at once. The messages with the released
+// keys that are admitted as a result are returned in the offset order of the
+// keys they replace.
+func (t *T) OnCheckpoint(offset int64) []consumer.Message {
+	if t == nil {
+		return nil
+	}
+	var admitted []consumer.Message
+	for key, inFlightOffset := range t.inFlight {
+		if inFlightOffset >= offset {
+			continue
+		}
+		delete(t.offsetKeys, inFlightOffset)
+		delete(t.inFlight, key)
+		if msg, ok := t.admitNext(key, offset); ok {
+			admitted = append(admitted, msg)
+		}
+	}
+	sort.Sort(byOffset(admitted))
+	return admitted
+}
+
+// admitNext admits the first queued message with the key, that is at or
+// after the specified offset. Messages before it are acknowledged already.
+func (t *T) admitNext(key string, offset int64) (consumer.Message, bool) {
+	queue := t.queues[key]
+	if len(queue) == t.maxQueueSize {
+		t.fullCount--
+	}
+	for len(queue) > 0 && queue[0].Offset < offset {
+		queue = queue[1:]
+	}
+	if len(queue) == 0 {
+		delete(t.queues, key)
+		return consumer.Message{}, false
+	}
+	msg := queue[0]
+	if len(queue) == 1 {
+		delete(t.queues, key)
+	} else {
+		t.queues[key] = queue[1:]
+	}
+	t.inFlight[key] = msg.Offset
+	t.offsetKeys[msg.Offset] = key
+	return msg, true
+}
+
+type byOffset []consumer.Message
+
+func (a byOffset) Len() int           { return len(a) }
+func (a byOffset) Less(i, j int) bool { return a[i].Offset < a[j].Offset }
+func (a byOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
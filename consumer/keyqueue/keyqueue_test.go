@@ -0,0 +1,104 @@
+package keyqueue
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type KeyQueueSuite struct{}
+
+var _ = Suite(&KeyQueueSuite{})
+
+func msg(offset int64, key string) consumer.Message {
+	return consumer.Message{Offset: offset, Key: []byte(key)}
+}
+
+// Messages with a key that is in flight are queued, and released one by one
+// in order as their predecessors are acknowledged.
+func (s *KeyQueueSuite) TestOrder(c *C) {
+	kq := New(10)
+	c.Assert(kq.Admit(msg(1, "a")), Equals, true)
+	c.Assert(kq.Admit(msg(2, "b")), Equals, true)
+	c.Assert(kq.Admit(msg(3, "a")), Equals, false)
+	c.Assert(kq.Admit(msg(4, "a")), Equals, false)
+	c.Assert(kq.Admit(msg(5, "c")), Equals, true)
+
+	// When/Then
+	_, ok := kq.OnAcked(2)
+	c.Assert(ok, Equals, false)
+	released, ok := kq.OnAcked(1)
+	c.Assert(ok, Equals, true)
+	c.Assert(released.Offset, Equals, int64(3))
+	_, ok = kq.OnAcked(1)
+	c.Assert(ok, Equals, false)
+	released, ok = kq.OnAcked(3)
+	c.Assert(ok, Equals, true)
+	c.Assert(released.Offset, Equals, int64(4))
+	_, ok = kq.OnAcked(4)
+	c.Assert(ok, Equals, false)
+	c.Assert(kq.Admit(msg(6, "a")), Equals, true)
+}
+
+// Messages without a key are never queued.
+func (s *KeyQueueSuite) TestNoKey(c *C) {
+	kq := New(1)
+	c.Assert(kq.Admit(consumer.Message{Offset: 1}), Equals, true)
+	c.Assert(kq.Admit(consumer.Message{Offset: 2}), Equals, true)
+	c.Assert(kq.Full(), Equals, false)
+	_, ok := kq.OnAcked(1)
+	c.Assert(ok, Equals, false)
+}
+
+// The queue is full once any key has the maximum number of messages queued.
+func (s *KeyQueueSuite) TestFull(c *C) {
+	kq := New(2)
+	kq.Admit(msg(1, "a"))
+	kq.Admit(msg(2, "a"))
+	c.Assert(kq.Full(), Equals, false)
+
+	// When
+	kq.Admit(msg(3, "a"))
+
+	// Then
+	c.Assert(kq.Full(), Equals, true)
+	kq.OnAcked(1)
+	c.Assert(kq.Full(), Equals, false)
+}
+
+// A checkpoint releases all keys in flight before it, and skips queued
+// messages that it acknowledges too.
+func (s *KeyQueueSuite) TestCheckpoint(c *C) {
+	kq := New(10)
+	kq.Admit(msg(1, "a"))
+	kq.Admit(msg(2, "b"))
+	kq.Admit(msg(3, "a"))
+	kq.Admit(msg(4, "b"))
+	kq.Admit(msg(5, "a"))
+	kq.Admit(msg(6, "c"))
+
+	// When
+	released := kq.OnCheckpoint(4)
+
+	// Then
+	c.Assert(len(released), Equals, 2)
+	c.Assert(released[0].Offset, Equals, int64(4))
+	c.Assert(released[1].Offset, Equals, int64(5))
+	c.Assert(kq.Admit(msg(7, "c")), Equals, false)
+}
+
+// A nil key queue admits everything.
+func (s *KeyQueueSuite) TestNil(c *C) {
+	var kq *T
+	c.Assert(kq.Admit(msg(1, "a")), Equals, true)
+	c.Assert(kq.Admit(msg(2, "a")), Equals, true)
+	c.Assert(kq.Full(), Equals, false)
+	_, ok := kq.OnAcked(1)
+	c.Assert(ok, Equals, false)
+	c.Assert(kq.OnCheckpoint(3), IsNil)
+}
@@ -7,8 +7,11 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/mapper"
+	"github.com/mailgun/kafka-pixy/consumer/sizestats"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/log"
 )
@@ -28,6 +31,17 @@ type Factory interface {
 	// otherwise offset is returned unchanged.
 	SpawnMessageIStream(namespace *actor.ID, topic string, partition int32, offset int64) (T, int64, error)
 
+	// OffsetForTime returns the offset of the first message in the given
+	// topic/partition with a timestamp not earlier than `t`. If there is no
+	// such message, then the newest offset is returned. Message timestamps
+	// are only available if Kafka is version 0.10.1+, with older versions the
+	// result is approximated by log segment modification times.
+	OffsetForTime(topic string, partition int32, t time.Time) (int64, error)
+
+	// BufferedMessages returns the number of messages fetched by all message
+	// streams of the factory that have not been read from them yet.
+	BufferedMessages() int
+
 	// Stop shuts down the consumer. It must be called after all child partition
 	// consumers have already been closed.
 	Stop()
@@ -79,6 +93,25 @@ type factory struct {
 	children     map[instanceID]*msgIStream
 	childrenLock sync.Mutex
 	mapper       *mapper.T
+	sizes        *sizestats.T
+	outOfRange   string
+	metrics      *metrics.Registry
+	group        string
+}
+
+// Opts are optional parameters of a factory.
+type Opts struct {
+	// If given, then sizes of all fetched messages are recorded to it.
+	Sizes *sizestats.T
+
+	// Where message streams resume if their offset goes out of range: one
+	// of config.OffsetReset* constants. If empty, then they stop instead.
+	OffsetOutOfRange string
+
+	// If given, then offsets skipped by message streams are counted in the
+	// `consumer.skipped_offsets` metric tagged with Group.
+	Metrics *metrics.Registry
+	Group   string
 }
 
 type instanceID struct {
@@ -90,11 +123,20 @@ type instanceID struct {
 // is still necessary to call Stop() on the underlying client after shutting
 // down this factory.
 func SpawnFactory(namespace *actor.ID, kafkaClt sarama.Client) (Factory, error) {
+	return SpawnFactoryWithOpts(namespace, kafkaClt, Opts{})
+}
+
+// SpawnFactoryWithOpts is like SpawnFactory, but takes optional parameters.
+func SpawnFactoryWithOpts(namespace *actor.ID, kafkaClt sarama.Client, opts Opts) (Factory, error) {
 	f := &factory{
-		namespace: namespace.NewChild("msg_stream_f"),
-		kafkaClt:  kafkaClt,
-		saramaCfg: kafkaClt.Config(),
-		children:  make(map[instanceID]*msgIStream),
+		namespace:  namespace.NewChild("msg_stream_f"),
+		kafkaClt:   kafkaClt,
+		saramaCfg:  kafkaClt.Config(),
+		children:   make(map[instanceID]*msgIStream),
+		sizes:      opts.Sizes,
+		outOfRange: opts.OffsetOutOfRange,
+		metrics:    opts.Metrics,
+		group:      opts.Group,
 	}
 	f.mapper = mapper.Spawn(f.namespace, f)
 	return f, nil
@@ -121,6 +163,28 @@ func (f *factory) SpawnMessageIStream(namespace *actor.ID, topic string, partiti
 }
 
 // implements `Factory`.
+func (f *factory) OffsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	offset, err := f.kafkaClt.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return f.kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	}
+	return offset, nil
+}
+
+// implements `Factory`.
+func (f *factory) BufferedMessages() int {
+	f.childrenLock.Lock()
+	defer f.childrenLock.Unlock()
+	count := 0
+	for _, mis := range f.children {
+		count += len(mis.messagesCh)
+	}
+	return count
+}
+
 func (f *factory) Stop() {
 	f.mapper.Stop()
 }
@@ -195,6 +259,10 @@ type msgIStream struct {
 	nilOrBrokerRequestsCh     chan<- fetchReq
 	nilOrReassignRetryTimerCh <-chan time.Time
 	lastReassignTime          time.Time
+
+	// Offsets jumped over by an out of range reset, that are reported as
+	// skipped by the next fetched message.
+	skipped int64
 }
 
 func (f *factory) spawnMsgIStream(namespace *actor.ID, id instanceID, offset int64) *msgIStream {
@@ -284,9 +352,18 @@ pullMessagesLoop:
 				log.Infof("<%s> fetch failed: err=%s", mis.actorID, err)
 				mis.reportError(err)
 				if err == sarama.ErrOffsetOutOfRange {
-					// There's no point in retrying this it will just fail the
-					// same way, therefore is nothing to do but give up.
-					goto done
+					if mis.f.outOfRange == "" {
+						// There's no point in retrying this it will just fail
+						// the same way, therefore is nothing to do but give up.
+						goto done
+					}
+					if err = mis.resetOutOfRange(); err != nil {
+						log.Errorf("<%s> failed to reset offset: err=(%s)", mis.actorID, err)
+						mis.triggerOrScheduleReassign("offset reset error")
+						continue pullMessagesLoop
+					}
+					mis.nilOrBrokerRequestsCh = mis.assignedBrokerRequestCh
+					continue pullMessagesLoop
 				}
 				mis.triggerOrScheduleReassign("fetch error")
 				continue pullMessagesLoop
@@ -339,6 +416,27 @@ func (mis *msgIStream) triggerOrScheduleReassign(reason string) {
 	mis.nilOrReassignRetryTimerCh = time.After(mis.f.saramaCfg.Consumer.Retry.Backoff)
 }
 
+// resetOutOfRange moves the stream to the oldest or the newest offset of the
+// partition, as the factory out of range policy tells. If it moves forward,
+// then the offsets jumped over are reported as skipped.
+func (mis *msgIStream) resetOutOfRange() error {
+	whence := sarama.OffsetOldest
+	if mis.f.outOfRange == config.OffsetResetLatest {
+		whence = sarama.OffsetNewest
+	}
+	offset, err := mis.f.kafkaClt.GetOffset(mis.id.topic, mis.id.partition, whence)
+	if err != nil {
+		return err
+	}
+	log.Warningf("<%s> offset out of range: offset=%d, policy=%s, newOffset=%d",
+		mis.actorID, mis.offset, mis.f.outOfRange, offset)
+	if offset > mis.offset {
+		mis.skipped += offset - mis.offset
+	}
+	mis.offset = offset
+	return nil
+}
+
 // parseFetchResult parses a fetch response received a broker.
 func (mis *msgIStream) parseFetchResult(cid *actor.ID, fetchResult fetchRes) ([]consumer.Message, error) {
 	if fetchResult.Err != nil {
@@ -381,7 +479,25 @@ func (mis *msgIStream) parseFetchResult(cid *actor.ID, fetchResult fetchRes) ([]
 
 	// we got messages, reset our fetch size in case it was increased for a previous request
 	mis.fetchSize = mis.f.saramaCfg.Consumer.Fetch.Default
-	var fetchedMessages []consumer.Message
+	msgCount := 0
+	for _, msgBlock := range block.MsgSet.Messages {
+		msgCount += len(msgBlock.Messages())
+	}
+	// Messages reference keys and values of the fetch response rather than
+	// copying them, all the way to the API frontends.
+	fetchedMessages := make([]consumer.Message, 0, msgCount)
+	var sizes sizestats.Batch
+	// Fetch requests are at most v2, so brokers of Kafka 0.11.0.0 and later
+	// down-convert record batches to message sets, and drop transaction
+	// control records along the way. They only show as gaps in offsets here,
+	// that are reported as skipped like ones left by compaction.
+	//
+	// TODO Count and optionally surface control records once the vendored
+	// Shopify/sarama decodes record batches.
+	// Offsets jumped over by an out of range reset are counted as skipped
+	// right before the first fetched message.
+	nextOffset := mis.offset - mis.skipped
+	var skipped int64
 	for _, msgBlock := range block.MsgSet.Messages {
 		lastMsgIdx := len(msgBlock.Messages()) - 1
 		baseOffset := msgBlock.Offset - msgBlock.Messages()[lastMsgIdx].Offset
@@ -401,15 +517,24 @@ func (mis *msgIStream) parseFetchResult(cid *actor.ID, fetchResult fetchRes) ([]
 				Offset:        offset,
 				Timestamp:     msg.Msg.Timestamp,
 				HighWaterMark: block.HighWaterMarkOffset,
+				Skipped:       offset - nextOffset,
 			}
+			skipped += consumerMessage.Skipped
+			nextOffset = offset + 1
 			fetchedMessages = append(fetchedMessages, consumerMessage)
+			sizes.Add(len(msg.Msg.Key)+len(msg.Msg.Value), msgBlock.Msg.Codec)
 			mis.lag = block.HighWaterMarkOffset - offset
 		}
 	}
+	mis.f.sizes.Record(mis.id.topic, &sizes)
 
 	if len(fetchedMessages) == 0 {
 		return nil, sarama.ErrIncompleteResponse
 	}
+	mis.skipped = 0
+	if skipped > 0 {
+		mis.f.metrics.Counter("consumer.skipped_offsets", "group", mis.f.group, "topic", mis.id.topic).Inc(skipped)
+	}
 	return fetchedMessages, nil
 }
 
@@ -515,6 +640,22 @@ func (be *brokerExecutor) runExecutor() {
 			continue
 		}
 		// Make a batch fetch request for all hungry message streams.
+		//
+		// TODO Use incremental fetch sessions (KIP-227) with brokers that
+		// support them, to stop resending the full partition list on every
+		// fetch. That requires FetchRequest v7 (Kafka 1.1), while the vendored
+		// sarama only encodes versions up to v2 and does not allow custom
+		// request types, so it has to be upgraded first.
+		//
+		// TODO Support the read_committed isolation level, that skips
+		// messages of aborted and still open transactions. That requires
+		// FetchRequest v4 (Kafka 0.11) and record batches, so it is blocked
+		// on the same sarama upgrade.
+		//
+		// TODO Pass the rack of the host in FetchRequest v11 (Kafka 2.4), and
+		// follow the preferred read replica returned by brokers (KIP-392), to
+		// fetch from the closest replica rather than the leader. It is blocked
+		// on the same sarama upgrade.
 		req := &sarama.FetchRequest{
 			MinBytes:    be.config.Consumer.Fetch.Min,
 			MaxWaitTime: int32(be.config.Consumer.MaxWaitTime / time.Millisecond),
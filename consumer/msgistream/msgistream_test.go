@@ -7,6 +7,8 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/mailgun/log"
 	. "gopkg.in/check.v1"
@@ -454,6 +456,56 @@ func (s *MsgIStreamSuite) TestNonSequentialOffsets(c *C) {
 	c.Assert((<-pc.Messages()).Offset, Equals, int64(11))
 }
 
+// If the offset goes out of range and the factory has an out of range policy,
+// then the stream resumes where the policy tells. Offsets jumped over, and
+// gaps between fetched messages are reported as skipped.
+func (s *MsgIStreamSuite) TestOutOfRangeReset(c *C) {
+	// Given
+	broker0 := sarama.NewMockBroker(c, 0)
+	defer broker0.Close()
+	fetchResponse1 := new(sarama.FetchResponse)
+	fetchResponse1.AddError("my_topic", 0, sarama.ErrOffsetOutOfRange)
+	fetchResponse2 := &sarama.FetchResponse{}
+	fetchResponse2.AddMessage("my_topic", 0, nil, testMsg, 1234)
+	fetchResponse2.AddMessage("my_topic", 0, nil, testMsg, 1236)
+	fetchResponse3 := &sarama.FetchResponse{}
+	fetchResponse3.AddError("my_topic", 0, sarama.ErrNoError)
+	broker0.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(c).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 1234).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 7),
+		"FetchRequest": sarama.NewMockSequence(fetchResponse1, fetchResponse2, fetchResponse3),
+	})
+
+	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
+	defer client.Close()
+	registry := metrics.New()
+	f, err := SpawnFactoryWithOpts(s.ns, client, Opts{
+		OffsetOutOfRange: config.OffsetResetLatest,
+		Metrics:          registry,
+		Group:            "g1",
+	})
+	c.Assert(err, IsNil)
+	defer f.Stop()
+
+	// When
+	pc, _, err := f.SpawnMessageIStream(s.ns.NewChild("my_topic", 0), "my_topic", 0, 101)
+	c.Assert(err, IsNil)
+	defer pc.Stop()
+
+	// Then
+	msg := <-pc.Messages()
+	c.Assert(msg.Offset, Equals, int64(1234))
+	c.Assert(msg.Skipped, Equals, int64(1133))
+	msg = <-pc.Messages()
+	c.Assert(msg.Offset, Equals, int64(1236))
+	c.Assert(msg.Skipped, Equals, int64(1))
+	c.Assert(registry.Counter("consumer.skipped_offsets", "group", "g1", "topic", "my_topic").Count(), Equals, int64(1134))
+}
+
 // If leadership for a partition is changing then consumer resolves the new
 // leader and switches to it.
 func (s *MsgIStreamSuite) TestRebalancingMultiplePartitions(c *C) {
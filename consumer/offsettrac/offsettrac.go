@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
@@ -15,6 +16,15 @@ import (
 
 const (
 	base64EncodeMap = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+	// offsetResetMetaPrefix starts metadata of an initial offset that was
+	// chosen by an offset reset policy. It is not in the base64 alphabet, so
+	// it cannot be confused with encoded acked ranges.
+	offsetResetMetaPrefix = "reset:"
+
+	// checkpointMetaSep separates encoded acked ranges from a checkpoint in
+	// offset metadata. It is not in the base64 alphabet either.
+	checkpointMetaSep = "|"
 )
 
 var (
@@ -39,9 +49,52 @@ func init() {
 type T struct {
 	actorID      *actor.ID
 	offerTimeout time.Duration
+	maxMetaSize  int
+	metaTrimmed  bool
+	retryDelay   func(retryNo int) time.Duration
+	maxAttempts  int
 	offset       offsetmgr.Offset
 	ackedRanges  []ackedRange
 	offers       []offer
+
+	// Number of offers that have been nacked since they were last offered.
+	nackedCount int
+
+	// The last checkpoint encoded to be appended to offset metadata, or an
+	// empty string if there has been none.
+	checkpoint string
+}
+
+// Checkpoint is an offset committed explicitly along with an opaque
+// metadata blob of a client, e.g. ID of a downstream transaction that
+// messages up to the offset were stored with. All messages before the offset
+// are considered acknowledged.
+type Checkpoint struct {
+	Offset int64
+	Meta   string
+}
+
+// ParseCheckpoint returns the last checkpoint stored in offset metadata, if
+// any. Metadata of checkpoints is retained in offset metadata until the next
+// checkpoint, even if acknowledgements move the offset further.
+func ParseCheckpoint(meta string) (Checkpoint, bool) {
+	_, encoded := splitMeta(meta)
+	if encoded == "" {
+		return Checkpoint{}, false
+	}
+	sepIdx := strings.Index(encoded, ":")
+	if sepIdx < 0 {
+		return Checkpoint{}, false
+	}
+	offset, err := strconv.ParseInt(encoded[:sepIdx], 10, 64)
+	if err != nil {
+		return Checkpoint{}, false
+	}
+	return Checkpoint{Offset: offset, Meta: encoded[sepIdx+1:]}, true
+}
+
+func (cp Checkpoint) encode() string {
+	return strconv.FormatInt(cp.Offset, 10) + ":" + cp.Meta
 }
 
 // SparseAcks2Str returns human readable representation of sparsely committed
@@ -60,18 +113,54 @@ func SparseAcks2Str(offset offsetmgr.Offset) string {
 	return buf.String()
 }
 
+// OffsetResetMeta returns metadata to commit an initial offset chosen by the
+// specified offset reset policy with.
+func OffsetResetMeta(reset consumer.OffsetReset) string {
+	return offsetResetMetaPrefix + reset.String()
+}
+
+// Opts are optional parameters of an offset tracker.
+type Opts struct {
+	// Maximum number of bytes of acked ranges to encode into offset metadata,
+	// including the last checkpoint if there is one. If ranges do not fit,
+	// then those furthest from the committed offset are
+	// left out. They are still acked as long as the tracker lives, but
+	// messages in them are redelivered if the partition is consumed by
+	// another tracker after the offset is committed. Zero means no limit.
+	MaxMetaSize int
+
+	// Returns how long a message that has not been acked within the offer
+	// timeout is withheld before it is retried for the retryNo'th time. If
+	// nil, then messages are retried as soon as their offers expire.
+	RetryDelay func(retryNo int) time.Duration
+
+	// Maximum number of times a message is offered. A message nacked as
+	// retryable after it has been offered that many times is not retried,
+	// but handled as if it was nacked as fatal. Zero means no limit.
+	MaxAttempts int
+}
+
 // New creates a new offset tracker instance.
 func New(actorID *actor.ID, offset offsetmgr.Offset, offerTimeout time.Duration) *T {
+	return NewWithOpts(actorID, offset, offerTimeout, Opts{})
+}
+
+// NewWithOpts is like New but allows specifying optional parameters.
+func NewWithOpts(actorID *actor.ID, offset offsetmgr.Offset, offerTimeout time.Duration, opts Opts) *T {
 	ot := T{
 		actorID:      actorID,
 		offerTimeout: offerTimeout,
+		maxMetaSize:  opts.MaxMetaSize,
+		retryDelay:   opts.RetryDelay,
+		maxAttempts:  opts.MaxAttempts,
 		offset:       offset,
 	}
 	var err error
+	_, ot.checkpoint = splitMeta(offset.Meta)
 	ot.ackedRanges, err = decodeAckedRanges(offset.Val, offset.Meta)
 	if err != nil {
 		ot.ackedRanges = nil
-		ot.offset.Meta = ""
+		ot.offset.Meta = ot.withCheckpoint("")
 		log.Errorf("<%v> bad sparse acks: %v, err=%+v", ot.actorID, offset, err)
 	}
 	return &ot
@@ -121,11 +210,130 @@ func (ot *T) OnAcked(offset int64) (offsetmgr.Offset, int) {
 			ot.actorID, offerMissing, duplicateAck)
 	}
 	if !duplicateAck {
-		ot.offset.Meta = encodeAckedRanges(ot.offset.Val, ot.ackedRanges)
+		ot.updateMeta()
 	}
 	return ot.offset, len(ot.offers)
 }
 
+// OnNacked should be called when a client fails to process a message, see
+// consumer.Nack* constants. Unless the nack is fatal, the message is retried
+// after the retry delay rather than after the offer timeout. A fatal nack
+// restarts the offer timeout instead, so that the message is not retried
+// while the caller routes it to the dead letter topic and acks it. So does a
+// retryable nack of a message that has been offered MaxAttempts times, and
+// the returned message has AttemptsExhausted set then. The offered message is
+// returned, or false if the message is not offered.
+func (ot *T) OnNacked(offset int64, class string) (consumer.Message, bool) {
+	return ot.onNacked(time.Now(), offset, class)
+}
+func (ot *T) onNacked(now time.Time, offset int64, class string) (consumer.Message, bool) {
+	i := sort.Search(len(ot.offers), func(i int) bool {
+		return ot.offers[i].msg.Offset >= offset
+	})
+	if i >= len(ot.offers) || ot.offers[i].msg.Offset != offset {
+		return consumer.Message{}, false
+	}
+	o := &ot.offers[i]
+	if class == consumer.NackFatal {
+		o.deadline = now.Add(ot.offerTimeout)
+		return o.msg, true
+	}
+	if class == consumer.NackRetryable && ot.maxAttempts > 0 && o.retryNo+1 >= ot.maxAttempts {
+		o.deadline = now.Add(ot.offerTimeout)
+		msg := o.msg
+		msg.AttemptsExhausted = true
+		return msg, true
+	}
+	o.deadline = now
+	if ot.retryDelay != nil {
+		o.deadline = now.Add(ot.retryDelay(o.retryNo + 1))
+	}
+	if !o.nacked {
+		o.nacked = true
+		ot.nackedCount++
+	}
+	return o.msg, true
+}
+
+// OnSkipped should be called when a message is acknowledged without ever
+// being offered, e.g. because it is older than the topic message TTL. It
+// returns an offset to be submitted.
+func (ot *T) OnSkipped(offset int64) offsetmgr.Offset {
+	if ot.updateAckedRanges(offset) {
+		ot.updateMeta()
+	}
+	return ot.offset
+}
+
+// OnGap should be called when a range of offsets [from, to) turns out to have
+// no messages, e.g. because they have been compacted away. The offsets are
+// considered acknowledged. It returns an offset to be submitted.
+func (ot *T) OnGap(from, to int64) offsetmgr.Offset {
+	if ot.ackRange(from, to) {
+		ot.updateMeta()
+	}
+	return ot.offset
+}
+
+// OnCheckpoint should be called when a client commits a checkpoint. All
+// messages before the checkpoint offset are considered acknowledged, and
+// their offers are dropped. It returns an offset to be submitted and a total
+// number of offered messages. A checkpoint cannot go behind the acknowledged
+// offset, `consumer.ErrCheckpointBehind` is returned if it does.
+func (ot *T) OnCheckpoint(cp Checkpoint) (offsetmgr.Offset, int, error) {
+	if cp.Offset < ot.offset.Val {
+		return ot.offset, len(ot.offers), errors.Wrapf(consumer.ErrCheckpointBehind,
+			"checkpoint=%d, offset=%d", cp.Offset, ot.offset.Val)
+	}
+	ot.offset.Val = cp.Offset
+	for len(ot.ackedRanges) > 0 && ot.ackedRanges[0].from <= ot.offset.Val {
+		if ot.ackedRanges[0].to > ot.offset.Val {
+			ot.offset.Val = ot.ackedRanges[0].to
+		}
+		ot.ackedRanges = ot.ackedRanges[1:]
+	}
+	i := sort.Search(len(ot.offers), func(i int) bool {
+		return ot.offers[i].msg.Offset >= ot.offset.Val
+	})
+	for _, o := range ot.offers[:i] {
+		if o.nacked {
+			ot.nackedCount--
+		}
+	}
+	ot.offers = append(ot.offers[:0], ot.offers[i:]...)
+	ot.checkpoint = cp.encode()
+	ot.updateMeta()
+	return ot.offset, len(ot.offers), nil
+}
+
+// updateMeta encodes acked ranges along with the last checkpoint into the
+// offset metadata.
+func (ot *T) updateMeta() {
+	// The checkpoint is never trimmed, ranges have to fit into what is left.
+	// Every encoded range takes at least two bytes, so none fits into one.
+	maxSize := ot.maxMetaSize
+	if maxSize > 0 && ot.checkpoint != "" {
+		maxSize -= len(checkpointMetaSep) + len(ot.checkpoint)
+		if maxSize < 1 {
+			maxSize = 1
+		}
+	}
+	encoded, trimmed := encodeAckedRanges(ot.offset.Val, ot.ackedRanges, maxSize)
+	if trimmed && !ot.metaTrimmed {
+		log.Warningf("<%s> sparse acks trimmed to fit: maxSize=%d, ranges=%d",
+			ot.actorID, ot.maxMetaSize, len(ot.ackedRanges))
+	}
+	ot.metaTrimmed = trimmed
+	ot.offset.Meta = ot.withCheckpoint(encoded)
+}
+
+func (ot *T) withCheckpoint(encodedRanges string) string {
+	if ot.checkpoint == "" {
+		return encodedRanges
+	}
+	return encodedRanges + checkpointMetaSep + ot.checkpoint
+}
+
 func (ot *T) removeOffer(offset int64) bool {
 	offersCount := len(ot.offers)
 	i := sort.Search(offersCount, func(i int) bool {
@@ -134,6 +342,9 @@ func (ot *T) removeOffer(offset int64) bool {
 	if i >= offersCount || ot.offers[i].msg.Offset != offset {
 		return false
 	}
+	if ot.offers[i].nacked {
+		ot.nackedCount--
+	}
 	offersCount -= 1
 	copy(ot.offers[i:offersCount], ot.offers[i+1:])
 	ot.offers[offersCount].msg = consumer.Message{} // Makes it subject for garbage collection.
@@ -157,8 +368,10 @@ func (ot *T) IsAcked(msg consumer.Message) bool {
 	return false
 }
 
-// NextRetry returns a next message to be retried along with the retry attempt
-// number. If there are no messages to be retried then nil is returned.
+// NextRetry returns a next message to be retried along with the number of
+// times its offer has expired without an ack. Retries of nacked messages do
+// not count, for clients asked for them. If there are no messages to be
+// retried then nil is returned.
 func (ot *T) NextRetry() (consumer.Message, int, bool) {
 	return ot.nextRetry(time.Now())
 }
@@ -166,17 +379,24 @@ func (ot *T) nextRetry(now time.Time) (consumer.Message, int, bool) {
 	for i := range ot.offers {
 		o := &ot.offers[i]
 		if o.deadline.Before(now) {
-			o.deadline = now.Add(ot.offerTimeout)
 			o.retryNo += 1
-			return o.msg, o.retryNo, true
+			o.deadline = ot.offerDeadline(now, o.retryNo)
+			if o.nacked {
+				o.nacked = false
+				ot.nackedCount--
+			} else {
+				o.expiredNo += 1
+			}
+			return o.msg, o.expiredNo, true
 		}
 		// When we reach the first never retried offer with a deadline set in
 		// the future it is guaranteed that all further offers in the list have
 		// not expired yet. BUT it is only true if messages are offered in the
 		// order of their offsets. Which is indeed how partition consumer is
 		// doing it. However the offset tracker API allows any order. So the
-		// following logic is not valid in general case.
-		if o.retryNo == 0 {
+		// following logic is not valid in general case. Nor is it if there
+		// are nacked offers, for they are retried ahead of their turn.
+		if o.retryNo == 0 && ot.nackedCount == 0 {
 			return consumer.Message{}, -1, false
 		}
 	}
@@ -190,7 +410,8 @@ func (ot *T) ShouldWait4Ack() (bool, time.Duration) {
 }
 func (ot *T) shouldWait4Ack(now time.Time) (bool, time.Duration) {
 	for _, o := range ot.offers {
-		if o.deadline.After(now) {
+		// Nacked messages are not going to be acked.
+		if o.deadline.After(now) && !o.nacked {
 			timeout := o.deadline.Sub(now)
 			log.Infof("<%s> waiting for acks: count=%d, offset=%d, timeout=%v",
 				ot.actorID, len(ot.offers), o.offset, timeout)
@@ -248,25 +469,100 @@ func (ot *T) updateAckedRanges(offset int64) bool {
 	return true
 }
 
+// ackRange marks offsets [from, to) as acknowledged, merging the range with
+// overlapping and adjacent acked ranges. It returns false if all of the
+// offsets have already been acknowledged.
+func (ot *T) ackRange(from, to int64) bool {
+	if from < ot.offset.Val {
+		from = ot.offset.Val
+	}
+	if from >= to {
+		return false
+	}
+	ar := ackedRange{from, to}
+	merged := make([]ackedRange, 0, len(ot.ackedRanges)+1)
+	inserted := false
+	for _, r := range ot.ackedRanges {
+		switch {
+		case r.to < ar.from:
+			merged = append(merged, r)
+		case ar.to < r.from:
+			if !inserted {
+				merged = append(merged, ar)
+				inserted = true
+			}
+			merged = append(merged, r)
+		default:
+			if r.from <= ar.from && ar.to <= r.to {
+				return false
+			}
+			if r.from < ar.from {
+				ar.from = r.from
+			}
+			if r.to > ar.to {
+				ar.to = r.to
+			}
+		}
+	}
+	if !inserted {
+		merged = append(merged, ar)
+	}
+	if merged[0].from == ot.offset.Val {
+		ot.offset.Val = merged[0].to
+		merged = merged[1:]
+	}
+	ot.ackedRanges = merged
+	return true
+}
+
 func (ot *T) newOffer(msg consumer.Message) offer {
-	return offer{msg, msg.Offset, 0, time.Now().Add(ot.offerTimeout)}
+	return offer{msg, msg.Offset, 0, ot.offerDeadline(time.Now(), 0), false, 0}
 }
 
-func encodeAckedRanges(base int64, ackedRanges []ackedRange) string {
+// offerDeadline returns the time when a message offered at `now` for the
+// retryNo'th time should be retried, unless it is acked by then.
+func (ot *T) offerDeadline(now time.Time, retryNo int) time.Time {
+	deadline := now.Add(ot.offerTimeout)
+	if ot.retryDelay != nil {
+		deadline = deadline.Add(ot.retryDelay(retryNo + 1))
+	}
+	return deadline
+}
+
+// encodeAckedRanges returns acked ranges encoded to be stored in offset
+// metadata. If `maxSize` is positive, then only as many leading ranges as fit
+// into that many bytes are encoded, and true is returned if some ranges were
+// left out.
+func encodeAckedRanges(base int64, ackedRanges []ackedRange, maxSize int) (string, bool) {
 	ackedRangesCount := len(ackedRanges)
 	if ackedRangesCount == 0 {
-		return ""
+		return "", false
 	}
 	buf := make([]byte, 0, ackedRangesCount*4)
 	for _, ar := range ackedRanges {
+		fitSize := len(buf)
 		buf = ar.encode(base, buf)
+		if maxSize > 0 && len(buf) > maxSize {
+			return string(buf[:fitSize]), true
+		}
 		base = ar.to
 	}
-	return string(buf)
+	return string(buf), false
 }
 
-func decodeAckedRanges(base int64, encoded string) ([]ackedRange, error) {
-	if encoded == "" {
+// splitMeta splits offset metadata into encoded acked ranges and an encoded
+// checkpoint.
+func splitMeta(meta string) (string, string) {
+	sepIdx := strings.Index(meta, checkpointMetaSep)
+	if sepIdx < 0 {
+		return meta, ""
+	}
+	return meta[:sepIdx], meta[sepIdx+1:]
+}
+
+func decodeAckedRanges(base int64, meta string) ([]ackedRange, error) {
+	encoded, _ := splitMeta(meta)
+	if encoded == "" || strings.HasPrefix(encoded, offsetResetMetaPrefix) {
 		return nil, nil
 	}
 	ackedRanges := make([]ackedRange, 0, len(encoded)/2)
@@ -341,6 +637,12 @@ type offer struct {
 	offset   int64
 	retryNo  int
 	deadline time.Time
+
+	// True if the message has been nacked since it was last offered.
+	nacked bool
+
+	// Number of retries caused by expiry of the offer rather than by nacks.
+	expiredNo int
 }
 
 type ackedRange struct {
@@ -7,6 +7,7 @@ import (
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/pkg/errors"
 	. "gopkg.in/check.v1"
 )
 
@@ -139,6 +140,10 @@ func (s *OffsetTrackerSuite) TestNewOffsetAdjusted(c *C) {
 			offsetmgr.Offset{1000, "a@b"},
 			offsetmgr.Offset{1000, ""},
 		},
+		/* 4 */ {
+			offsetmgr.Offset{1000, "reset:earliest"},
+			offsetmgr.Offset{1000, "reset:earliest"},
+		},
 	} {
 		// When
 		ot := New(s.ns, tc.initial, -1)
@@ -149,8 +154,8 @@ func (s *OffsetTrackerSuite) TestNewOffsetAdjusted(c *C) {
 }
 
 func (s *OffsetTrackerSuite) TestIsAcked(c *C) {
-	meta := encodeAckedRanges(301, []ackedRange{
-		{302, 305}, {307, 309}, {310, 313}})
+	meta, _ := encodeAckedRanges(301, []ackedRange{
+		{302, 305}, {307, 309}, {310, 313}}, 0)
 	offset := offsetmgr.Offset{301, meta}
 	ot := New(s.ns, offset, -1)
 	for i, tc := range []struct {
@@ -180,6 +185,151 @@ func (s *OffsetTrackerSuite) TestIsAcked(c *C) {
 	}
 }
 
+// Acked ranges that do not fit into the max metadata size are left out of
+// the committed offset metadata, but are still considered acked by the
+// tracker.
+func (s *OffsetTrackerSuite) TestMaxMetaSize(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, -1, Opts{MaxMetaSize: 4})
+	ot.OnAcked(302)
+	ot.OnAcked(305)
+
+	// When
+	offset, _ := ot.OnAcked(310)
+
+	// Then
+	c.Assert(offset.Val, Equals, int64(300))
+	c.Assert(SparseAcks2Str(offset), Equals, "2-3,5-6")
+	c.Assert(ot.IsAcked(consumer.Message{Offset: 310}), Equals, true)
+	ot2 := New(s.ns, offset, -1)
+	c.Assert(ot2.IsAcked(consumer.Message{Offset: 305}), Equals, true)
+	c.Assert(ot2.IsAcked(consumer.Message{Offset: 310}), Equals, false)
+
+	// When: acking enough messages to merge ranges makes them fit again.
+	ot.OnAcked(303)
+	ot.OnAcked(304)
+	offset, _ = ot.OnAcked(300)
+	offset, _ = ot.OnAcked(301)
+
+	// Then
+	c.Assert(offset.Val, Equals, int64(306))
+	c.Assert(SparseAcks2Str(offset), Equals, "4-5")
+}
+
+// A checkpoint acks all messages before its offset, and its metadata is
+// retained through following acks.
+func (s *OffsetTrackerSuite) TestOnCheckpoint(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	for i := int64(300); i < 310; i++ {
+		ot.OnOffered(consumer.Message{Offset: i})
+	}
+	ot.OnAcked(303)
+	ot.OnAcked(306)
+	ot.OnAcked(307)
+
+	// When
+	offset, offeredCount, err := ot.OnCheckpoint(Checkpoint{Offset: 306, Meta: "txn|1"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(offset.Val, Equals, int64(308))
+	c.Assert(offeredCount, Equals, 2)
+	c.Assert(SparseAcks2Str(offset), Equals, "")
+	cp, ok := ParseCheckpoint(offset.Meta)
+	c.Assert(ok, Equals, true)
+	c.Assert(cp, Equals, Checkpoint{Offset: 306, Meta: "txn|1"})
+
+	// When: acks go on after the checkpoint.
+	offset, _ = ot.OnAcked(309)
+
+	// Then
+	c.Assert(offset.Val, Equals, int64(308))
+	c.Assert(SparseAcks2Str(offset), Equals, "1-2")
+	cp, ok = ParseCheckpoint(offset.Meta)
+	c.Assert(ok, Equals, true)
+	c.Assert(cp, Equals, Checkpoint{Offset: 306, Meta: "txn|1"})
+
+	// When: the checkpoint survives a tracker restart.
+	ot2 := New(s.ns, offset, -1)
+	offset, _, err = ot2.OnCheckpoint(Checkpoint{Offset: 309, Meta: "txn|2"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(offset.Val, Equals, int64(310))
+	cp, _ = ParseCheckpoint(offset.Meta)
+	c.Assert(cp, Equals, Checkpoint{Offset: 309, Meta: "txn|2"})
+}
+
+// Skipped messages are acknowledged without being offered, while offers of
+// other messages stay.
+func (s *OffsetTrackerSuite) TestOnSkipped(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	ot.OnOffered(consumer.Message{Offset: 301})
+
+	// When
+	ot.OnSkipped(300)
+	offset := ot.OnSkipped(302)
+
+	// Then
+	c.Assert(offset.Val, Equals, int64(301))
+	c.Assert(ot.IsAcked(consumer.Message{Offset: 302}), Equals, true)
+	offset, offeredCount := ot.OnAcked(301)
+	c.Assert(offeredCount, Equals, 0)
+	c.Assert(offset, Equals, offsetmgr.Offset{Val: 303})
+}
+
+// Gaps are acknowledged as a whole, merging with adjacent acked ranges, and
+// the offset moves past them once everything before is acknowledged.
+func (s *OffsetTrackerSuite) TestOnGap(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	ot.OnOffered(consumer.Message{Offset: 300})
+	ot.OnOffered(consumer.Message{Offset: 1000})
+	ot.OnAcked(1000)
+
+	// When
+	offset := ot.OnGap(301, 1000)
+
+	// Then
+	c.Assert(offset.Val, Equals, int64(300))
+	c.Assert(SparseAcks2Str(offset), Equals, "1-701")
+	c.Assert(ot.OnGap(400, 500), Equals, offset)
+	offset, offeredCount := ot.OnAcked(300)
+	c.Assert(offeredCount, Equals, 0)
+	c.Assert(offset, Equals, offsetmgr.Offset{Val: 1001})
+	c.Assert(ot.OnGap(1001, 1010), Equals, offsetmgr.Offset{Val: 1010})
+}
+
+// A checkpoint cannot go behind the acked offset.
+func (s *OffsetTrackerSuite) TestOnCheckpointBehind(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	ot.OnAcked(300)
+
+	// When
+	offset, _, err := ot.OnCheckpoint(Checkpoint{Offset: 300, Meta: "txn1"})
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, consumer.ErrCheckpointBehind)
+	c.Assert(offset, Equals, offsetmgr.Offset{Val: 301})
+	_, ok := ParseCheckpoint(offset.Meta)
+	c.Assert(ok, Equals, false)
+}
+
+// Acked ranges have to fit into what is left of the max metadata size after
+// the checkpoint.
+func (s *OffsetTrackerSuite) TestCheckpointMaxMetaSize(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, -1, Opts{MaxMetaSize: 10})
+	ot.OnCheckpoint(Checkpoint{Offset: 300, Meta: "txn"})
+	ot.OnAcked(302)
+
+	// When
+	offset, _ := ot.OnAcked(305)
+
+	// Then
+	c.Assert(offset.Meta, Equals, "CB|300:txn")
+	c.Assert(SparseAcks2Str(offset), Equals, "2-3")
+	cp, _ := ParseCheckpoint(offset.Meta)
+	c.Assert(cp, Equals, Checkpoint{Offset: 300, Meta: "txn"})
+}
+
 func (s *OffsetTrackerSuite) TestOfferAckLoop(c *C) {
 	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
 	for i, tc := range []struct {
@@ -239,6 +389,43 @@ func (s *OffsetTrackerSuite) TestOfferAckLoop(c *C) {
 	}
 }
 
+// Retries are delayed beyond the offer timeout as RetryDelay tells.
+func (s *OffsetTrackerSuite) TestNextRetryDelay(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second, Opts{
+		RetryDelay: func(retryNo int) time.Duration { return time.Duration(retryNo) * time.Second },
+	})
+	begin := time.Now()
+	ot.OnOffered(consumer.Message{Offset: 301})
+	c.Assert(ot.offers[0].deadline.Sub(begin) >= 6*time.Second, Equals, true)
+	ot.offers[0].deadline = begin.Add(6 * time.Second)
+
+	for i, tc := range []struct {
+		millis     int
+		offset     int64
+		retryCount int
+	}{
+		/* 0 */ {millis: 5001},
+		/* 1 */ {millis: 6001, offset: 301, retryCount: 1},
+		/* 2 */ {millis: 13001},
+		/* 3 */ {millis: 13002, offset: 301, retryCount: 2},
+		/* 4 */ {millis: 21002},
+		/* 5 */ {millis: 21003, offset: 301, retryCount: 3},
+	} {
+		// When
+		now := begin.Add(time.Duration(tc.millis) * time.Millisecond)
+		msg, retryCount, ok := ot.nextRetry(now)
+
+		// Then
+		if tc.offset == 0 {
+			c.Assert(ok, Equals, false, Commentf("case: %d", i))
+			continue
+		}
+		c.Assert(ok, Equals, true, Commentf("case: %d", i))
+		c.Assert(msg.Offset, Equals, tc.offset, Commentf("case: %d", i))
+		c.Assert(retryCount, Equals, tc.retryCount, Commentf("case: %d", i))
+	}
+}
+
 func (s *OffsetTrackerSuite) TestNextRetry(c *C) {
 	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
 	msgs := []consumer.Message{
@@ -295,6 +482,113 @@ func (s *OffsetTrackerSuite) TestNextRetry(c *C) {
 	}
 }
 
+// A nacked message is retried after the retry delay, ahead of messages
+// offered before it. A fatal nack restarts the offer timeout instead.
+func (s *OffsetTrackerSuite) TestOnNacked(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second, Opts{
+		RetryDelay: func(retryNo int) time.Duration { return time.Duration(retryNo) * time.Second },
+	})
+	for _, msg := range []consumer.Message{{Offset: 301}, {Offset: 302, Value: []byte("foo")}, {Offset: 303}} {
+		ot.OnOffered(msg)
+	}
+	begin := time.Now()
+	for i := range ot.offers {
+		ot.offers[i].deadline = begin.Add(6 * time.Second)
+	}
+
+	// When
+	msg, ok := ot.onNacked(begin, 302, consumer.NackRetryable)
+	_, fatalOk := ot.onNacked(begin, 303, consumer.NackFatal)
+	_, missingOk := ot.onNacked(begin, 304, consumer.NackRetryable)
+
+	// Then
+	c.Assert(ok, Equals, true)
+	c.Assert(string(msg.Value), Equals, "foo")
+	c.Assert(fatalOk, Equals, true)
+	c.Assert(missingOk, Equals, false)
+	c.Assert(ot.offers[2].deadline, Equals, begin.Add(5*time.Second))
+
+	_, _, ok = ot.nextRetry(begin.Add(999 * time.Millisecond))
+	c.Assert(ok, Equals, false)
+	msg, expiredNo, ok := ot.nextRetry(begin.Add(1001 * time.Millisecond))
+	c.Assert(ok, Equals, true)
+	c.Assert(msg.Offset, Equals, int64(302))
+	c.Assert(expiredNo, Equals, 0)
+	c.Assert(ot.nackedCount, Equals, 0)
+}
+
+// Retries of nacked messages do not count as expired offers, so however many
+// times a client nacks a message it does not hit the retries emergency break.
+func (s *OffsetTrackerSuite) TestNextRetryNacked(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second, Opts{
+		RetryDelay: func(retryNo int) time.Duration { return time.Second },
+	})
+	ot.OnOffered(consumer.Message{Offset: 301})
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		// When
+		ot.onNacked(now, 301, consumer.NackRetryable)
+		now = now.Add(1001 * time.Millisecond)
+		msg, expiredNo, ok := ot.nextRetry(now)
+
+		// Then
+		c.Assert(ok, Equals, true, Commentf("nack #%d", i))
+		c.Assert(msg.Offset, Equals, int64(301), Commentf("nack #%d", i))
+		c.Assert(expiredNo, Equals, 0, Commentf("nack #%d", i))
+	}
+	_, expiredNo, ok := ot.nextRetry(now.Add(7 * time.Second))
+	c.Assert(ok, Equals, true)
+	c.Assert(expiredNo, Equals, 1)
+	c.Assert(ot.offers[0].retryNo, Equals, 6)
+}
+
+// A message nacked as retryable once it has been offered MaxAttempts times is
+// not retried, but returned to be produced to the dead letter topic.
+func (s *OffsetTrackerSuite) TestOnNackedMaxAttempts(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second, Opts{
+		RetryDelay:  func(retryNo int) time.Duration { return time.Second },
+		MaxAttempts: 2,
+	})
+	ot.OnOffered(consumer.Message{Offset: 301})
+	begin := time.Now()
+	msg, ok := ot.onNacked(begin, 301, consumer.NackRetryable)
+	c.Assert(ok, Equals, true)
+	c.Assert(msg.AttemptsExhausted, Equals, false)
+	_, _, ok = ot.nextRetry(begin.Add(1001 * time.Millisecond))
+	c.Assert(ok, Equals, true)
+
+	// When
+	msg, ok = ot.onNacked(begin.Add(2*time.Second), 301, consumer.NackRetryable)
+
+	// Then
+	c.Assert(ok, Equals, true)
+	c.Assert(msg.AttemptsExhausted, Equals, true)
+	c.Assert(ot.offers[0].msg.AttemptsExhausted, Equals, false)
+	c.Assert(ot.offers[0].deadline, Equals, begin.Add(7*time.Second))
+	c.Assert(ot.nackedCount, Equals, 0)
+	_, _, ok = ot.nextRetry(begin.Add(3 * time.Second))
+	c.Assert(ok, Equals, false)
+}
+
+// Nacked messages are not worth waiting for.
+func (s *OffsetTrackerSuite) TestShouldWait4AckNacked(c *C) {
+	ot := NewWithOpts(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second, Opts{
+		RetryDelay: func(retryNo int) time.Duration { return time.Second },
+	})
+	ot.OnOffered(consumer.Message{Offset: 301})
+	begin := time.Now()
+
+	// When
+	ot.onNacked(begin, 301, consumer.NackThrottle)
+
+	// Then
+	wait, _ := ot.shouldWait4Ack(begin)
+	c.Assert(wait, Equals, false)
+	ot.OnAcked(301)
+	c.Assert(ot.nackedCount, Equals, 0)
+}
+
 func (s *OffsetTrackerSuite) TestShouldWait4Ack(c *C) {
 	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
 	msgs := []consumer.Message{
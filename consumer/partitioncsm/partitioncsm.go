@@ -5,10 +5,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/groupmember"
+	"github.com/mailgun/kafka-pixy/consumer/keyqueue"
 	"github.com/mailgun/kafka-pixy/consumer/msgistream"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrac"
 	"github.com/mailgun/kafka-pixy/none"
@@ -25,7 +28,9 @@ var (
 
 	// Following variables are supposed to be constants but they were defined
 	// as variables to allow overriding in tests:
-	check4RetryInterval   = time.Second
+	check4RetryInterval = time.Second
+	// Retries of nacked messages do not count towards these, see
+	// offsettrac.T.NextRetry. They are bounded by `redelivery.max_attempts`.
 	retriesHighWaterMark  = 1
 	retriesEmergencyBreak = 3 * retriesHighWaterMark
 	offeredHighWaterMark  = 100
@@ -45,11 +50,21 @@ type T struct {
 	groupMember *groupmember.T
 	msgIStreamF msgistream.Factory
 	offsetMgrF  offsetmgr.Factory
+	offsetReset consumer.OffsetReset
 	messagesCh  chan consumer.Message
 	eventsCh    chan consumer.Event
 	stopCh      chan none.T
 	wg          sync.WaitGroup
 
+	// Checkpoints that have been submitted, but not committed yet, in the
+	// order they were submitted.
+	checkpoints []pendingCheckpoint
+
+	// Messages skipped for being older than the topic max message age, that
+	// have not been logged yet. They are logged in batches.
+	expiredCount int
+	expiredFrom  int64
+
 	// For tests only!
 	firstMsgFetched bool
 }
@@ -58,6 +73,26 @@ type T struct {
 func Spawn(namespace *actor.ID, group, topic string, partition int32, cfg *config.Proxy,
 	groupMember *groupmember.T, msgIStreamF msgistream.Factory, offsetMgrF offsetmgr.Factory,
 ) *T {
+	return SpawnWithOpts(namespace, group, topic, partition, cfg, groupMember, msgIStreamF, offsetMgrF, Opts{})
+}
+
+// Opts are optional parameters of a partition consumer.
+type Opts struct {
+	// If the group has no committed offset for the partition, then
+	// consumption starts where it tells. The policy is recorded in the
+	// metadata of the initial offset commit. If zero, then
+	// `consumer.offset_reset` is used.
+	OffsetReset consumer.OffsetReset
+}
+
+// SpawnWithOpts is like Spawn, but takes optional parameters.
+func SpawnWithOpts(namespace *actor.ID, group, topic string, partition int32, cfg *config.Proxy,
+	groupMember *groupmember.T, msgIStreamF msgistream.Factory, offsetMgrF offsetmgr.Factory, opts Opts,
+) *T {
+	offsetReset := opts.OffsetReset
+	if offsetReset.IsZero() {
+		offsetReset.Policy = cfg.Consumer.OffsetReset
+	}
 	pc := &T{
 		actorID:     namespace.NewChild(fmt.Sprintf("P:%s_%d", topic, partition)),
 		cfg:         cfg,
@@ -67,11 +102,12 @@ func Spawn(namespace *actor.ID, group, topic string, partition int32, cfg *confi
 		groupMember: groupMember,
 		msgIStreamF: msgIStreamF,
 		offsetMgrF:  offsetMgrF,
+		offsetReset: offsetReset,
 		messagesCh:  make(chan consumer.Message, 1),
 		eventsCh:    make(chan consumer.Event, 1),
 		stopCh:      make(chan none.T),
 	}
-	actor.Spawn(pc.actorID, &pc.wg, pc.run)
+	actor.Spawn(pc.actorID, &pc.wg, pc.runSupervised)
 	return pc
 }
 
@@ -85,9 +121,45 @@ func (pc *T) Messages() <-chan consumer.Message {
 	return pc.messagesCh
 }
 
-func (pc *T) run() {
+// runSupervised runs the partition consumer restarting it if it panics, as
+// `Consumer.Restart` prescribes. A restarted partition consumer resumes from
+// the last committed offset.
+func (pc *T) runSupervised() {
 	defer close(pc.messagesCh)
-	defer pc.groupMember.ClaimPartition(pc.actorID, pc.topic, pc.partition, pc.stopCh)()
+	restarted := false
+	actor.Supervise(pc.actorID, actor.RestartPolicy(pc.cfg.Consumer.Restart), pc.stopCh, func() {
+		if restarted {
+			pc.discardStale()
+		}
+		restarted = true
+		pc.run()
+	})
+}
+
+// discardStale drops a message and events left in the channels by a crashed
+// run. The message has not been offered yet, so it is fetched again.
+func (pc *T) discardStale() {
+	for {
+		select {
+		case <-pc.messagesCh:
+		case <-pc.eventsCh:
+		default:
+			return
+		}
+	}
+}
+
+func (pc *T) run() {
+	release, err := pc.groupMember.ClaimPartitionWithTimeout(pc.actorID, pc.topic, pc.partition,
+		pc.cfg.Consumer.ClaimTimeout, pc.stopCh)
+	defer release()
+	if err != nil {
+		// The partition is not consumed by this member until the next
+		// rebalancing, that stops the partition consumer.
+		log.Errorf("<%s> gave up claiming partition: err=(%s)", pc.actorID, err)
+		<-pc.stopCh
+		return
+	}
 
 	om, err := pc.offsetMgrF.SpawnOffsetManager(pc.actorID, pc.group, pc.topic, pc.partition)
 	if err != nil {
@@ -109,16 +181,32 @@ func (pc *T) run() {
 	}
 	submittedOffset := committedOffset
 
+	// If nothing has been committed yet, then the offset reset policy tells
+	// where to start.
+	initialOffsetVal := committedOffset.Val
+	noCommittedOffset := committedOffset.Val == sarama.OffsetNewest
+	if noCommittedOffset {
+		initialOffsetVal = pc.resetOffset()
+	}
+
 	// Initialize the message input stream to read from the initial offset.
-	mis, realOffsetVal, err := pc.msgIStreamF.SpawnMessageIStream(pc.actorID, pc.topic, pc.partition, committedOffset.Val)
+	mis, realOffsetVal, err := pc.msgIStreamF.SpawnMessageIStream(pc.actorID, pc.topic, pc.partition, initialOffsetVal)
 	if err != nil {
 		// Must never happen!
-		panic(errors.Wrapf(err, "<%s> failed to start message stream, offset=%d", pc.actorID, committedOffset.Val))
+		panic(errors.Wrapf(err, "<%s> failed to start message stream, offset=%d", pc.actorID, initialOffsetVal))
 	}
-	defer mis.Stop()
+	pc.groupMember.RecordTransition(groupevents.Transition{Kind: groupevents.FetchStarted, Topic: pc.topic, Partition: pc.partition})
+	defer func() {
+		mis.Stop()
+		pc.groupMember.RecordTransition(groupevents.Transition{Kind: groupevents.FetchStopped, Topic: pc.topic, Partition: pc.partition})
+	}()
 
 	// If the real initial offset is not what had been committed then adjust.
-	if committedOffset.Val != realOffsetVal {
+	if noCommittedOffset {
+		log.Infof("<%s> no committed offset: offsetReset=%s, offset=%d", pc.actorID, pc.offsetReset, realOffsetVal)
+		submittedOffset = offsetmgr.Offset{Val: realOffsetVal, Meta: offsettrac.OffsetResetMeta(pc.offsetReset)}
+		om.SubmitOffset(submittedOffset)
+	} else if committedOffset.Val != realOffsetVal {
 		log.Errorf("<%s> invalid initial offset: %d, sparseAcks=%s",
 			pc.actorID, committedOffset.Val, offsettrac.SparseAcks2Str(committedOffset))
 		submittedOffset = offsetmgr.Offset{Val: realOffsetVal, Meta: ""}
@@ -127,7 +215,14 @@ func (pc *T) run() {
 	log.Infof("<%s> initialized: offset=%d, sparseAcks=%s",
 		pc.actorID, submittedOffset.Val, offsettrac.SparseAcks2Str(submittedOffset))
 	pc.notifyTestInitialized(submittedOffset)
-	ot := offsettrac.New(pc.actorID, submittedOffset, pc.cfg.Consumer.AckTimeout)
+	redelivery := pc.cfg.TopicRedelivery(pc.topic)
+	ot := offsettrac.NewWithOpts(pc.actorID, submittedOffset, pc.cfg.Consumer.AckTimeout, offsettrac.Opts{
+		MaxMetaSize: pc.cfg.Consumer.MaxSparseAcksSize,
+		RetryDelay:  redelivery.Delay,
+		MaxAttempts: redelivery.MaxAttempts,
+	})
+	maxOffered := pc.maxOffered()
+	kq := pc.newKeyQueue()
 
 	var (
 		nilOrIStreamMessagesCh = mis.Messages()
@@ -136,20 +231,49 @@ func (pc *T) run() {
 		msg                    consumer.Message
 		msgOk                  = false
 		retryNo                int
+
+		// While a partition is throttled by nacks of a client, messages
+		// are not offered until the channel fires.
+		throttledCh <-chan time.Time
+		throttleNo  int
+
+		// Messages read from the input stream ahead of msg, to be offered
+		// as its followers in a claim.
+		pending []consumer.Message
+
+		// Messages released by the key queue, when messages with the same
+		// key before them were acknowledged, to be offered next.
+		released []consumer.Message
 	)
 	defer retryTicker.Stop()
 	for {
+		nilOrUnthrottledCh := nilOrMessagesCh
+		if throttledCh != nil {
+			nilOrUnthrottledCh = nil
+		}
 		select {
 		case msg = <-nilOrIStreamMessagesCh:
-			if ot.IsAcked(msg) {
+			pc.ackSkipped(msg, ot, om, &submittedOffset)
+			if ot.IsAcked(msg) || pc.skipExpired(msg, ot, om, &submittedOffset) {
 				continue
 			}
+			pc.logExpired()
 			msg.EventsCh = pc.eventsCh
+			if !kq.Admit(msg) {
+				if kq.Full() {
+					log.Warningf("<%s> key queue full: offset=%d", pc.actorID, msg.Offset)
+					nilOrIStreamMessagesCh = nil
+				}
+				continue
+			}
+			pending = pc.readAhead(mis, ot, om, &submittedOffset, pending)
+			msg.Following = append([]consumer.Message(nil), pending...)
 			msgOk = true
 			pc.notifyTestFetched()
 			nilOrIStreamMessagesCh = nil
 			nilOrMessagesCh = pc.messagesCh
 		case <-retryTicker.C:
+			pc.logExpired()
 			if msgOk {
 				continue
 			}
@@ -166,16 +290,19 @@ func (pc *T) run() {
 			}
 			nilOrIStreamMessagesCh = nil
 			nilOrMessagesCh = pc.messagesCh
-		case nilOrMessagesCh <- msg:
+		case nilOrUnthrottledCh <- msg:
 			nilOrMessagesCh = nil
+		case <-throttledCh:
+			throttledCh = nil
 		case event := <-pc.eventsCh:
 			switch event.T {
 			case consumer.EvOffered:
-				if event.Offset != msg.Offset {
+				followingCount, offeredCount, ok := offerClaim(ot, msg, event.Offset)
+				if !ok {
 					// Must never happen!
 					panic(errors.Wrapf(err, "<%s> invalid offer offset %d, want=%d", pc.actorID, event.Offset, msg.Offset))
 				}
-				offeredCount := ot.OnOffered(msg)
+				pending = pending[followingCount:]
 				msg, retryNo, msgOk = ot.NextRetry()
 				if msgOk {
 					log.Warningf("<%s> retrying: offset=%d, no=%d", pc.actorID, msg.Offset, retryNo)
@@ -189,41 +316,110 @@ func (pc *T) run() {
 					nilOrMessagesCh = pc.messagesCh
 					continue
 				}
-				if offeredCount > offeredHighWaterMark {
+				switch {
+				case offeredCount > maxOffered:
 					log.Warningf("<%s> offered count above HWM: %d", pc.actorID, offeredCount)
 					nilOrIStreamMessagesCh = nil
-				} else {
+				case len(released) > 0:
+					msg, released = released[0], released[1:]
+					msgOk = true
+					nilOrMessagesCh = pc.messagesCh
+				case len(pending) > 0:
+					msg, pending = pc.nextClaim(mis, ot, om, &submittedOffset, pending)
+					msgOk = true
+					nilOrMessagesCh = pc.messagesCh
+				case kq.Full():
+					nilOrIStreamMessagesCh = nil
+				default:
 					nilOrIStreamMessagesCh = mis.Messages()
 				}
-			case consumer.EvAcked:
+			case consumer.EvAcked, consumer.EvCheckpoint:
 				var offeredCount int
-				submittedOffset, offeredCount = ot.OnAcked(event.Offset)
-				om.SubmitOffset(submittedOffset)
-				if !msgOk && offeredCount <= offeredHighWaterMark {
-					nilOrIStreamMessagesCh = mis.Messages()
+				if event.T == consumer.EvAcked {
+					throttleNo = 0
+					submittedOffset, offeredCount = ot.OnAcked(event.Offset)
+					om.SubmitOffset(submittedOffset)
+					if releasedMsg, ok := kq.OnAcked(event.Offset); ok {
+						released = append(released, releasedMsg)
+					}
+				} else {
+					var ok bool
+					submittedOffset, offeredCount, ok = pc.onCheckpoint(event, ot, om)
+					if ok {
+						released = append(released, kq.OnCheckpoint(event.Offset)...)
+					}
+				}
+				if !msgOk && offeredCount <= maxOffered {
+					if len(released) > 0 {
+						msg, released = released[0], released[1:]
+						msgOk = true
+						nilOrMessagesCh = pc.messagesCh
+						continue
+					}
+					if len(pending) > 0 {
+						msg, pending = pc.nextClaim(mis, ot, om, &submittedOffset, pending)
+						msgOk = true
+						nilOrMessagesCh = pc.messagesCh
+						continue
+					}
+					if !kq.Full() {
+						nilOrIStreamMessagesCh = mis.Messages()
+					}
+				}
+			case consumer.EvNacked:
+				onNacked(ot, event)
+				if event.Class == consumer.NackThrottle {
+					throttleNo++
+					throttledCh = time.After(redelivery.Delay(throttleNo))
 				}
 			}
 		case committedOffset = <-om.CommittedOffsets():
+			pc.resolveCheckpoints(committedOffset)
 		case <-pc.stopCh:
 			goto wait4Ack
 		}
 	}
 wait4Ack:
-	for ok, timeout := ot.ShouldWait4Ack(); ok; ok, timeout = ot.ShouldWait4Ack() {
+	pc.logExpired()
+	// Hand the partition off: wait for acknowledgements of offered messages
+	// for no longer than the handoff timeout, commit the acked offset, and
+	// only then release the partition (deferred) so that the next owner starts
+	// consuming right after the last acked message.
+	handoffTimeoutCh := time.After(pc.cfg.ConsumerHandoffTimeout())
+handoff:
+	for {
+		// An offer of the last message read from Messages() may still be
+		// in the events channel, and it has to be accounted for, otherwise
+		// the message is delivered again by the next partition owner.
 		select {
 		case event := <-pc.eventsCh:
-			if event.T == consumer.EvAcked {
-				submittedOffset, _ = ot.OnAcked(event.Offset)
-				om.SubmitOffset(submittedOffset)
-			}
-		case <-time.After(timeout):
+			submittedOffset = pc.handleHandoffEvent(event, ot, om, msg, msgOk, submittedOffset)
 			continue
+		default:
+		}
+		ok, timeout := ot.ShouldWait4Ack()
+		if !ok {
+			break
+		}
+		select {
+		case event := <-pc.eventsCh:
+			submittedOffset = pc.handleHandoffEvent(event, ot, om, msg, msgOk, submittedOffset)
+		case <-time.After(timeout):
+		case <-handoffTimeoutCh:
+			log.Warningf("<%s> handoff timeout expired", pc.actorID)
+			break handoff
 		}
 	}
 	om.Stop()
 	// Drain committed offsets.
 	for committedOffset = range om.CommittedOffsets() {
+		pc.resolveCheckpoints(committedOffset)
 	}
+	for _, pcp := range pc.checkpoints {
+		pcp.doneCh <- errors.Errorf("partition released before checkpoint %d was committed",
+			pcp.checkpoint.Offset)
+	}
+	pc.checkpoints = nil
 	// Reset `om` to prevent the deferred panic offset manager cleanup function
 	// from running and calling `Stop()` on the already stopped offset manager.
 	om = nil
@@ -235,6 +431,202 @@ wait4Ack:
 		pc.actorID, committedOffset.Val, offsettrac.SparseAcks2Str(committedOffset))
 }
 
+// handleHandoffEvent updates the offset tracker with an event received while
+// the partition is being handed off, and returns the updated submitted offset.
+func (pc *T) handleHandoffEvent(event consumer.Event, ot *offsettrac.T, om offsetmgr.T,
+	msg consumer.Message, msgOk bool, submittedOffset offsetmgr.Offset,
+) offsetmgr.Offset {
+	switch event.T {
+	case consumer.EvOffered:
+		if msgOk {
+			offerClaim(ot, msg, event.Offset)
+		}
+	case consumer.EvAcked:
+		submittedOffset, _ = ot.OnAcked(event.Offset)
+		om.SubmitOffset(submittedOffset)
+	case consumer.EvCheckpoint:
+		submittedOffset, _, _ = pc.onCheckpoint(event, ot, om)
+	case consumer.EvNacked:
+		onNacked(ot, event)
+	}
+	return submittedOffset
+}
+
+// onNacked applies a nack event to the offset tracker, and sends the offered
+// message back if the event asks for it.
+func onNacked(ot *offsettrac.T, event consumer.Event) {
+	msg, ok := ot.OnNacked(event.Offset, event.Class)
+	if event.OfferedCh == nil {
+		return
+	}
+	if ok {
+		event.OfferedCh <- msg
+	}
+	close(event.OfferedCh)
+}
+
+// pendingCheckpoint is a checkpoint waiting for its offset to be committed.
+type pendingCheckpoint struct {
+	checkpoint offsettrac.Checkpoint
+	doneCh     chan<- error
+}
+
+// onCheckpoint applies a checkpoint event to the offset tracker and submits
+// the resulting offset. It returns the submitted offset, the total number of
+// offered messages, and whether the checkpoint was accepted. The outcome is
+// reported to the event done channel right away if the checkpoint is
+// rejected, or when the offset is committed.
+func (pc *T) onCheckpoint(event consumer.Event, ot *offsettrac.T, om offsetmgr.T) (offsetmgr.Offset, int, bool) {
+	cp := offsettrac.Checkpoint{Offset: event.Offset, Meta: event.Meta}
+	offset, offeredCount, err := ot.OnCheckpoint(cp)
+	if err != nil {
+		event.DoneCh <- err
+		return offset, offeredCount, false
+	}
+	om.SubmitOffset(offset)
+	pc.checkpoints = append(pc.checkpoints, pendingCheckpoint{cp, event.DoneCh})
+	return offset, offeredCount, true
+}
+
+// resolveCheckpoints reports success of pending checkpoints that are
+// committed with the specified offset, either as is or superseded by a later
+// checkpoint.
+func (pc *T) resolveCheckpoints(committedOffset offsetmgr.Offset) {
+	committed, ok := offsettrac.ParseCheckpoint(committedOffset.Meta)
+	if !ok {
+		return
+	}
+	resolvedCount := 0
+	for i, pcp := range pc.checkpoints {
+		if pcp.checkpoint == committed || pcp.checkpoint.Offset < committed.Offset {
+			resolvedCount = i + 1
+		}
+	}
+	for _, pcp := range pc.checkpoints[:resolvedCount] {
+		pcp.doneCh <- nil
+	}
+	pc.checkpoints = pc.checkpoints[resolvedCount:]
+}
+
+// readAhead reads messages that are already available in the input stream
+// and appends them to `pending`, until there are enough of them to fill up a
+// claim of `Consumer.MaxClaimSize` messages along with the message they follow.
+// Expired messages and skipped offsets are acknowledged, updating
+// `submittedOffset`.
+func (pc *T) readAhead(mis msgistream.T, ot *offsettrac.T, om offsetmgr.T, submittedOffset *offsetmgr.Offset,
+	pending []consumer.Message,
+) []consumer.Message {
+	for len(pending) < pc.maxClaimSize()-1 {
+		select {
+		case msg := <-mis.Messages():
+			pc.ackSkipped(msg, ot, om, submittedOffset)
+			if ot.IsAcked(msg) || pc.skipExpired(msg, ot, om, submittedOffset) {
+				continue
+			}
+			msg.EventsCh = pc.eventsCh
+			pending = append(pending, msg)
+		default:
+			return pending
+		}
+	}
+	return pending
+}
+
+// nextClaim makes the first pending message the head of a new claim, with
+// the rest of pending messages, topped up from the input stream, following.
+func (pc *T) nextClaim(mis msgistream.T, ot *offsettrac.T, om offsetmgr.T, submittedOffset *offsetmgr.Offset,
+	pending []consumer.Message,
+) (consumer.Message, []consumer.Message) {
+	msg := pending[0]
+	pending = pc.readAhead(mis, ot, om, submittedOffset, pending[1:])
+	msg.Following = append([]consumer.Message(nil), pending...)
+	return msg, pending
+}
+
+// ackSkipped acknowledges offsets that have no messages right before a
+// message, if any, and submits the resulting offset. Otherwise committed
+// offsets would never move past gaps left by compaction.
+func (pc *T) ackSkipped(msg consumer.Message, ot *offsettrac.T, om offsetmgr.T, submittedOffset *offsetmgr.Offset) {
+	if msg.Skipped <= 0 {
+		return
+	}
+	*submittedOffset = ot.OnGap(msg.Offset-msg.Skipped, msg.Offset)
+	om.SubmitOffset(*submittedOffset)
+}
+
+// skipExpired acknowledges a message without offering it, if it is older than
+// the topic max message age, and submits the resulting offset. It returns
+// false if the message has not expired.
+func (pc *T) skipExpired(msg consumer.Message, ot *offsettrac.T, om offsetmgr.T, submittedOffset *offsetmgr.Offset) bool {
+	maxAge := pc.cfg.TopicMaxMessageAge(pc.topic)
+	if maxAge <= 0 || msg.Timestamp.IsZero() || time.Since(msg.Timestamp) <= maxAge {
+		return false
+	}
+	if pc.expiredCount == 0 {
+		pc.expiredFrom = msg.Offset
+	}
+	pc.expiredCount++
+	*submittedOffset = ot.OnSkipped(msg.Offset)
+	om.SubmitOffset(*submittedOffset)
+	return true
+}
+
+// logExpired logs messages skipped as expired since the last call, if any.
+func (pc *T) logExpired() {
+	if pc.expiredCount == 0 {
+		return
+	}
+	log.Infof("<%s> skipped expired messages: count=%d, fromOffset=%d, maxAge=%v",
+		pc.actorID, pc.expiredCount, pc.expiredFrom, pc.cfg.TopicMaxMessageAge(pc.topic))
+	pc.expiredCount = 0
+}
+
+// offerClaim registers offers of a message and of its followers up to the one
+// with the specified offset in the offset tracker. It returns the number of
+// offered followers and the total number of offered messages, or false if the
+// offset does not belong to the claim.
+func offerClaim(ot *offsettrac.T, msg consumer.Message, offset int64) (int, int, bool) {
+	followingCount := -1
+	if offset == msg.Offset {
+		followingCount = 0
+	}
+	for i := 0; followingCount < 0 && i < len(msg.Following); i++ {
+		if msg.Following[i].Offset == offset {
+			followingCount = i + 1
+		}
+	}
+	if followingCount < 0 {
+		return 0, 0, false
+	}
+	following := msg.Following[:followingCount]
+	// Offers are retried as they are, without followers.
+	msg.Following = nil
+	offeredCount := ot.OnOffered(msg)
+	for _, followingMsg := range following {
+		offeredCount = ot.OnOffered(followingMsg)
+	}
+	return followingCount, offeredCount, true
+}
+
+// resetOffset returns an offset to start consuming the partition from in
+// accordance with the offset reset policy. It returns either an actual offset
+// value or one of the sarama.OffsetNewest and sarama.OffsetOldest constants.
+func (pc *T) resetOffset() int64 {
+	if !pc.offsetReset.Time.IsZero() {
+		offset, err := pc.msgIStreamF.OffsetForTime(pc.topic, pc.partition, pc.offsetReset.Time)
+		if err == nil {
+			return offset
+		}
+		log.Errorf("<%s> failed to get offset by time, falling back to latest: time=%s, err=(%s)",
+			pc.actorID, pc.offsetReset, err)
+		return sarama.OffsetNewest
+	}
+	if pc.offsetReset.Policy == config.OffsetResetEarliest {
+		return sarama.OffsetOldest
+	}
+	return sarama.OffsetNewest
+}
+
 func (pc *T) Stop() {
 	close(pc.stopCh)
 	pc.wg.Wait()
@@ -247,6 +639,35 @@ func (pc *T) notifyTestInitialized(initialOffset offsetmgr.Offset) {
 	}
 }
 
+// maxOffered returns how many messages of the partition can be offered but
+// not yet acknowledged before reading from the partition is suspended.
+func (pc *T) maxOffered() int {
+	switch pc.cfg.TopicDispatch(pc.topic) {
+	case config.DispatchUnordered, config.DispatchKey:
+		return pc.cfg.Consumer.MaxInFlight
+	}
+	return offeredHighWaterMark
+}
+
+// maxClaimSize returns how many consecutive messages of the partition can be
+// claimed by a consume request. Claims are not supported in the key dispatch
+// mode, for consecutive messages may have the same key.
+func (pc *T) maxClaimSize() int {
+	if pc.cfg.TopicDispatch(pc.topic) == config.DispatchKey {
+		return 1
+	}
+	return pc.cfg.Consumer.MaxClaimSize
+}
+
+// newKeyQueue returns a key queue if the topic is in the key dispatch mode,
+// or nil otherwise.
+func (pc *T) newKeyQueue() *keyqueue.T {
+	if pc.cfg.TopicDispatch(pc.topic) != config.DispatchKey {
+		return nil
+	}
+	return keyqueue.New(pc.cfg.Consumer.MaxKeyQueueSize)
+}
+
 // notifyTestFetched sends a signal to FirstMessageFetchedCh channel the
 // first time it is called.
 func (pc *T) notifyTestFetched() {
@@ -186,7 +186,7 @@ func (s *PartitionCsmSuite) TestOfferIvalid(c *C) {
 	// When
 	msg, ok := <-pc.Messages()
 	c.Assert(ok, Equals, true)
-	msg.EventsCh <- consumer.Event{consumer.EvOffered, msg.Offset + 1}
+	msg.EventsCh <- consumer.Event{T: consumer.EvOffered, Offset: msg.Offset + 1}
 
 	// Then
 	_, ok = <-pc.Messages()
@@ -237,6 +237,31 @@ func (s *PartitionCsmSuite) TestOfferedTooMany(c *C) {
 	}
 }
 
+// Partitions of unordered topics can have up to consumer.max_in_flight
+// messages offered but not acknowledged, rather than offeredHighWaterMark.
+func (s *PartitionCsmSuite) TestOfferedUnordered(c *C) {
+	offeredHighWaterMark = 1
+	s.cfg.Consumer.MaxInFlight = 3
+	s.cfg.Consumer.TopicDispatch = map[string]string{topic: config.DispatchUnordered}
+	s.cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: sarama.OffsetOldest}})
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgIStreamF, s.offsetMgrF)
+	defer pc.Stop()
+
+	// Read and confirm offered messages up to the max in flight+1 limit.
+	for i := 0; i < 4; i++ {
+		msg := <-pc.Messages()
+		sendEOffered(msg)
+	}
+
+	// No more message should be returned.
+	select {
+	case msg := <-pc.Messages():
+		c.Errorf("No messages should be available above max in flight: %v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
 // If some offered messages are not committed on stop. Then they are encoded in
 // the committed offset metadata.
 func (s *PartitionCsmSuite) TestSparseAckedCommitted(c *C) {
@@ -315,6 +340,63 @@ func (s *PartitionCsmSuite) TestSparseAckedAfterStop(c *C) {
 	c.Assert(offsettrac.SparseAcks2Str(offsetsAfter[partition]), Equals, "1-3,4-7")
 }
 
+// If a message read from Messages() is offered after the partition consumer
+// has been signalled to stop, then its acknowledgement is still waited for,
+// so that the message is not delivered again by the next partition owner.
+func (s *PartitionCsmSuite) TestOfferedAfterStop(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	s.cfg.Consumer.AckTimeout = 300 * time.Millisecond
+	s.cfg.Consumer.HandoffTimeout = 300 * time.Millisecond
+	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: sarama.OffsetOldest}})
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgIStreamF, s.offsetMgrF)
+	msg := <-pc.Messages()
+
+	// When
+	go pc.Stop() // Stop asynchronously.
+	time.Sleep(50 * time.Millisecond)
+	sendEOffered(msg)
+	time.Sleep(100 * time.Millisecond)
+	sendEAcked(msg)
+
+	// Wait for partition consumer to stop.
+	for {
+		if _, ok := <-pc.Messages(); !ok {
+			break
+		}
+	}
+	// Then
+	offsetsAfter := s.kh.GetCommittedOffsets(group, topic)
+	c.Assert(offsetsAfter[partition].Val, Equals, offsetsBefore[partition]+1)
+}
+
+// A partition consumer waits for acks no longer than Consumer.HandoffTimeout
+// after it has been signalled to stop.
+func (s *PartitionCsmSuite) TestHandoffTimeout(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	s.cfg.Consumer.AckTimeout = 3 * time.Second
+	s.cfg.Consumer.HandoffTimeout = 200 * time.Millisecond
+	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: sarama.OffsetOldest}})
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgIStreamF, s.offsetMgrF)
+	var messages []consumer.Message
+	for i := 0; i < 2; i++ {
+		msg := <-pc.Messages()
+		sendEOffered(msg)
+		messages = append(messages, msg)
+	}
+	sendEAcked(messages[0])
+
+	// When
+	begin := time.Now()
+	pc.Stop()
+
+	// Then
+	c.Assert(time.Since(begin) < time.Second, Equals, true)
+	offsetsAfter := s.kh.GetCommittedOffsets(group, topic)
+	c.Assert(offsetsAfter[partition].Val, Equals, offsetsBefore[partition]+1)
+}
+
 // If the max retries limit is reached for a message that results in
 // termination of the partition consumer. Note that offset is properly
 // committed to reflect sparsely acknowledged regions.
@@ -441,6 +523,46 @@ func (s *PartitionCsmSuite) TestRetryNoMoreMessages(c *C) {
 	c.Assert(offsettrac.SparseAcks2Str(offsetsAfter[partition]), Equals, "")
 }
 
+// Retries of nacked messages do not count towards the retries emergency
+// break, so a message that a client keeps nacking does not stop the partition.
+func (s *PartitionCsmSuite) TestNackedRetriesNoEmergencyBreak(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	retriesEmergencyBreak = 2
+	retriesHighWaterMark = 1
+	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: sarama.OffsetOldest}})
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgIStreamF, s.offsetMgrF)
+	defer pc.Stop()
+
+	// When
+	nackedOffset := offsetsBefore[partition]
+	nackCount := 0
+	for i := 0; nackCount <= retriesEmergencyBreak+1; i++ {
+		c.Assert(i < 100, Equals, true, Commentf("nacked message is not retried"))
+		msg, ok := <-pc.Messages()
+		c.Assert(ok, Equals, true, Commentf("partition consumer stopped after %d nacks", nackCount))
+		sendEOffered(msg)
+		if msg.Offset != nackedOffset {
+			sendEAcked(msg)
+			continue
+		}
+		sendENacked(msg)
+		nackCount++
+	}
+
+	// Then
+	for i := 0; ; i++ {
+		c.Assert(i < 100, Equals, true, Commentf("nacked message is not retried"))
+		msg, ok := <-pc.Messages()
+		c.Assert(ok, Equals, true, Commentf("partition consumer stopped after %d nacks", nackCount))
+		sendEOffered(msg)
+		sendEAcked(msg)
+		if msg.Offset == nackedOffset {
+			break
+		}
+	}
+}
+
 func (s *PartitionCsmSuite) TestAckedOnStop(c *C) {
 	offsetBefore := s.kh.GetNewestOffsets(topic)[partition] - 10
 	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: offsetBefore}})
@@ -479,7 +601,7 @@ func (s *PartitionCsmSuite) TestAckedOnStop(c *C) {
 func sendEOffered(msg consumer.Message) {
 	log.Infof("*** sending `offered`: offset=%d", msg.Offset)
 	select {
-	case msg.EventsCh <- consumer.Event{consumer.EvOffered, msg.Offset}:
+	case msg.EventsCh <- consumer.Event{T: consumer.EvOffered, Offset: msg.Offset}:
 	case <-time.After(500 * time.Millisecond):
 		log.Infof("*** timeout sending `offered`: offset=%d", msg.Offset)
 	}
@@ -488,8 +610,17 @@ func sendEOffered(msg consumer.Message) {
 func sendEAcked(msg consumer.Message) {
 	log.Infof("*** sending `acked`: offset=%d", msg.Offset)
 	select {
-	case msg.EventsCh <- consumer.Event{consumer.EvAcked, msg.Offset}:
+	case msg.EventsCh <- consumer.Event{T: consumer.EvAcked, Offset: msg.Offset}:
 	case <-time.After(500 * time.Millisecond):
 		log.Infof("*** timeout sending `acked`: offset=%d", msg.Offset)
 	}
 }
+
+func sendENacked(msg consumer.Message) {
+	log.Infof("*** sending `nacked`: offset=%d", msg.Offset)
+	select {
+	case msg.EventsCh <- consumer.Nack(msg.Offset, consumer.NackRetryable, nil):
+	case <-time.After(500 * time.Millisecond):
+		log.Infof("*** timeout sending `nacked`: offset=%d", msg.Offset)
+	}
+}
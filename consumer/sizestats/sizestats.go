@@ -0,0 +1,115 @@
+package sizestats
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// Buckets are upper bounds, exclusive, of message size histogram buckets. The
+// histogram has one more bucket for messages that are larger than that.
+var Buckets = []int{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// Stats describes sizes of messages fetched from a topic. A message size is
+// the total length of its key and value after decompression.
+type Stats struct {
+	Messages int64
+	Bytes    int64
+
+	// Number of messages fetched in compressed message sets, by codec.
+	Compressed map[string]int64
+
+	// Histogram[i] is the number of messages smaller than Buckets[i], but
+	// not smaller than Buckets[i-1].
+	Histogram []int64
+}
+
+// T accumulates per topic statistics of sizes of fetched messages. It is safe
+// for concurrent use. A nil instance is valid, it just ignores everything.
+type T struct {
+	mu     sync.Mutex
+	topics map[string]*Stats
+}
+
+// Batch collects sizes of messages fetched with one response, so that they
+// are added to T with a single lock.
+type Batch struct {
+	sizes  []int
+	codecs []sarama.CompressionCodec
+}
+
+// New creates an empty statistics instance.
+func New() *T {
+	return &T{topics: make(map[string]*Stats)}
+}
+
+// Add records the size of a message fetched in a message set compressed with
+// the specified codec.
+func (b *Batch) Add(size int, codec sarama.CompressionCodec) {
+	b.sizes = append(b.sizes, size)
+	b.codecs = append(b.codecs, codec)
+}
+
+// Record adds sizes of a batch of messages fetched from a topic.
+func (t *T) Record(topic string, b *Batch) {
+	if t == nil || len(b.sizes) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts := t.topics[topic]
+	if ts == nil {
+		ts = &Stats{Compressed: make(map[string]int64), Histogram: make([]int64, len(Buckets)+1)}
+		t.topics[topic] = ts
+	}
+	for i, size := range b.sizes {
+		ts.Messages++
+		ts.Bytes += int64(size)
+		if codec := b.codecs[i]; codec != sarama.CompressionNone {
+			ts.Compressed[codecName(codec)]++
+		}
+		ts.Histogram[bucketOf(size)]++
+	}
+}
+
+// Stats returns a snapshot of statistics of all topics.
+func (t *T) Stats() map[string]Stats {
+	stats := make(map[string]Stats)
+	if t == nil {
+		return stats
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic, ts := range t.topics {
+		snapshot := *ts
+		snapshot.Compressed = make(map[string]int64, len(ts.Compressed))
+		for codec, count := range ts.Compressed {
+			snapshot.Compressed[codec] = count
+		}
+		snapshot.Histogram = append([]int64(nil), ts.Histogram...)
+		stats[topic] = snapshot
+	}
+	return stats
+}
+
+func bucketOf(size int) int {
+	for i, bound := range Buckets {
+		if size < bound {
+			return i
+		}
+	}
+	return len(Buckets)
+}
+
+func codecName(codec sarama.CompressionCodec) string {
+	switch codec {
+	case sarama.CompressionGZIP:
+		return "gzip"
+	case sarama.CompressionSnappy:
+		return "snappy"
+	case sarama.CompressionLZ4:
+		return "lz4"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,66 @@
+package sizestats
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type SizeStatsSuite struct{}
+
+var _ = Suite(&SizeStatsSuite{})
+
+// A nil instance ignores records and has no stats.
+func (s *SizeStatsSuite) TestNil(c *C) {
+	var t *T
+	var b Batch
+	b.Add(10, sarama.CompressionNone)
+	t.Record("foo", &b)
+	c.Assert(t.Stats(), DeepEquals, map[string]Stats{})
+}
+
+func (s *SizeStatsSuite) TestRecord(c *C) {
+	t := New()
+	var b1, b2 Batch
+	b1.Add(10, sarama.CompressionNone)
+	b1.Add(256, sarama.CompressionGZIP)
+	b2.Add(2<<20, sarama.CompressionSnappy)
+	b2.Add(100, sarama.CompressionGZIP)
+
+	// When
+	t.Record("foo", &b1)
+	t.Record("foo", &b2)
+	t.Record("bar", &Batch{})
+
+	// Then
+	stats := t.Stats()
+	c.Assert(len(stats), Equals, 1)
+	c.Assert(stats["foo"], DeepEquals, Stats{
+		Messages:   4,
+		Bytes:      10 + 256 + 2<<20 + 100,
+		Compressed: map[string]int64{"gzip": 2, "snappy": 1},
+		Histogram:  []int64{2, 1, 0, 0, 0, 0, 0, 1},
+	})
+}
+
+// Snapshots are not affected by further records.
+func (s *SizeStatsSuite) TestSnapshot(c *C) {
+	t := New()
+	var b Batch
+	b.Add(10, sarama.CompressionGZIP)
+	t.Record("foo", &b)
+
+	// When
+	snapshot := t.Stats()
+	t.Record("foo", &b)
+
+	// Then
+	c.Assert(snapshot["foo"].Messages, Equals, int64(1))
+	c.Assert(snapshot["foo"].Compressed["gzip"], Equals, int64(1))
+	c.Assert(snapshot["foo"].Histogram[0], Equals, int64(1))
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/metrics"
 )
 
 // T implements a consumer request dispatch tier responsible for a particular
@@ -27,24 +28,33 @@ type T struct {
 	lifespanCh chan<- *T
 	requestsCh chan dispatcher.Request
 	messagesCh chan consumer.Message
+	metrics    *metrics.Registry
 	wg         sync.WaitGroup
+
+	offsetResetMu sync.Mutex
+	offsetReset   consumer.OffsetReset
 }
 
 // Creates a topic consumer instance. It should be explicitly started in
-// accordance with the `dispatcher.Tier` contract.
-func New(namespace *actor.ID, group, topic string, cfg *config.Proxy, lifespanCh chan<- *T) *T {
+// accordance with the `dispatcher.Tier` contract. While it is running, the
+// number of requests waiting in its queue is reported to the
+// `consumer.request_queue.depth` gauge of `metrics`.
+func New(namespace *actor.ID, group, topic string, cfg *config.Proxy, lifespanCh chan<- *T,
+	metrics *metrics.Registry,
+) *T {
 	return &T{
 		actorID:    namespace.NewChild(fmt.Sprintf("T:%s", topic)),
 		cfg:        cfg,
 		group:      group,
 		topic:      topic,
 		lifespanCh: lifespanCh,
-		requestsCh: make(chan dispatcher.Request, cfg.Consumer.ChannelBufferSize),
+		requestsCh: make(chan dispatcher.Request, cfg.ConsumerRequestQueueSize()),
 
 		// Messages channel must be non-buffered. Otherwise we might end up
 		// buffering a message from a partition that no longer belongs to this
 		// consumer group member.
 		messagesCh: make(chan consumer.Message),
+		metrics:    metrics,
 	}
 }
 
@@ -53,6 +63,14 @@ func (tc *T) Topic() string {
 	return tc.topic
 }
 
+// OffsetReset returns the offset reset policy requested by the most recent
+// consume request that requested one.
+func (tc *T) OffsetReset() consumer.OffsetReset {
+	tc.offsetResetMu.Lock()
+	defer tc.offsetResetMu.Unlock()
+	return tc.offsetReset
+}
+
 // implements `multiplexer.Out`
 func (tc *T) Messages() chan<- consumer.Message {
 	return tc.messagesCh
@@ -70,8 +88,11 @@ func (tc *T) Requests() chan<- dispatcher.Request {
 
 // implements `dispatcher.Tier`.
 func (tc *T) Start(stoppedCh chan<- dispatcher.Tier) {
+	tags := []string{"group", tc.group, "topic", tc.topic}
+	tc.metrics.GaugeFunc("consumer.request_queue.depth", func() int64 { return int64(len(tc.requestsCh)) }, tags...)
 	actor.Spawn(tc.actorID, &tc.wg, func() {
 		defer func() { stoppedCh <- tc }()
+		defer tc.metrics.Unregister("consumer.request_queue.depth", tags...)
 		tc.run()
 	})
 }
@@ -90,6 +111,17 @@ func (tc *T) run() {
 
 	timeoutResult := dispatcher.Response{Err: consumer.ErrRequestTimeout}
 	for consumeReq := range tc.requestsCh {
+		if !consumeReq.Opts.OffsetReset.IsZero() {
+			tc.offsetResetMu.Lock()
+			tc.offsetReset = consumeReq.Opts.OffsetReset
+			tc.offsetResetMu.Unlock()
+		}
+		// Start requests are done as soon as they get here, for the topic
+		// consumer has been created to handle them.
+		if consumeReq.Kind == dispatcher.KindStart {
+			consumeReq.ResponseCh <- dispatcher.Response{}
+			continue
+		}
 		requestAge := time.Now().UTC().Sub(consumeReq.Timestamp)
 		ttl := tc.cfg.Consumer.LongPollingTimeout - requestAge
 		// The request has been waiting in the buffer for too long. If we
@@ -103,7 +135,17 @@ func (tc *T) run() {
 
 		select {
 		case msg := <-tc.messagesCh:
-			msg.EventsCh <- consumer.Event{consumer.EvOffered, msg.Offset}
+			// A partition consumer offers followers of the message as a
+			// claim, the request takes as many of them as it is allowed to,
+			// and the offer of the last taken one covers all before it.
+			if claimSize := consumeReq.Opts.ClaimSize(tc.cfg.Consumer.MaxClaimSize); len(msg.Following) >= claimSize {
+				msg.Following = msg.Following[:claimSize-1]
+			}
+			lastOffset := msg.Offset
+			if n := len(msg.Following); n > 0 {
+				lastOffset = msg.Following[n-1].Offset
+			}
+			msg.EventsCh <- consumer.Event{T: consumer.EvOffered, Offset: lastOffset}
 			consumeReq.ResponseCh <- dispatcher.Response{Msg: msg}
 		case <-time.After(ttl):
 			consumeReq.ResponseCh <- timeoutResult
@@ -0,0 +1,140 @@
+package coordination
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// Backend keeps named leases, each held by at most one Kafka-Pixy instance
+// of a cluster at a time. Names are unique within a cluster, backends tell
+// leases of different clusters apart themselves.
+type Backend interface {
+	// TryAcquire acquires a lease for `holder` for `ttl`, or renews it if
+	// the holder has it already. It returns false if the lease is held by
+	// another holder, and has not expired.
+	TryAcquire(name, holder string, ttl time.Duration) (bool, error)
+
+	// Release gives a lease up, if it is held by `holder`, so that another
+	// holder can acquire it right away.
+	Release(name, holder string) error
+
+	// Close releases resources of the backend.
+	Close()
+}
+
+// New creates the backend configured in the `coordination` section of a
+// proxy config, or returns nil if coordination is disabled.
+func New(cluster string, cfg *config.Proxy) (Backend, error) {
+	switch cfg.Coordination.Backend {
+	case config.CoordinationNone:
+		return nil, nil
+	case config.CoordinationZooKeeper:
+		return NewZooKeeper(cluster, cfg)
+	case config.CoordinationKubernetes:
+		return NewKubernetes(cluster, cfg)
+	}
+	return nil, errors.Errorf("bad backend: %s", cfg.Coordination.Backend)
+}
+
+// Lease keeps trying to acquire a lease, and renews it while it is held,
+// until stopped. A nil instance is valid, it is always held, so that every
+// instance carries out its task when coordination is disabled.
+type Lease struct {
+	actorID *actor.ID
+	backend Backend
+	name    string
+	holder  string
+	ttl     time.Duration
+	stopCh  chan none.T
+	wg      sync.WaitGroup
+
+	mu sync.Mutex
+	// When the lease was last acquired or renewed, zero if it is not held.
+	renewedAt time.Time
+}
+
+// SpawnLease starts acquiring the lease `name` for `holder`. It returns nil
+// if `backend` is nil.
+func SpawnLease(namespace *actor.ID, backend Backend, name, holder string, ttl time.Duration) *Lease {
+	if backend == nil {
+		return nil
+	}
+	l := &Lease{
+		actorID: namespace.NewChild("lease_" + name),
+		backend: backend,
+		name:    name,
+		holder:  holder,
+		ttl:     ttl,
+		stopCh:  make(chan none.T),
+	}
+	actor.Spawn(l.actorID, &l.wg, l.run)
+	return l
+}
+
+// Held tells whether the lease is held at the moment. If it could not be
+// renewed, then it is considered held until it expires.
+func (l *Lease) Held() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.renewedAt.IsZero() && time.Since(l.renewedAt) < l.ttl
+}
+
+// Stop stops renewing the lease, and releases it if it is held.
+func (l *Lease) Stop() {
+	if l == nil {
+		return
+	}
+	close(l.stopCh)
+	l.wg.Wait()
+}
+
+func (l *Lease) run() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		l.tryAcquire()
+		select {
+		case <-ticker.C:
+		case <-l.stopCh:
+			if !l.Held() {
+				return
+			}
+			if err := l.backend.Release(l.name, l.holder); err != nil {
+				log.Errorf("<%s> failed to release: err=(%s)", l.actorID, err)
+			}
+			return
+		}
+	}
+}
+
+func (l *Lease) tryAcquire() {
+	wasHeld := l.Held()
+	acquiredAt := time.Now()
+	acquired, err := l.backend.TryAcquire(l.name, l.holder, l.ttl)
+	if err != nil {
+		log.Errorf("<%s> failed to acquire: err=(%s)", l.actorID, err)
+		return
+	}
+	l.mu.Lock()
+	if acquired {
+		l.renewedAt = acquiredAt
+	} else {
+		l.renewedAt = time.Time{}
+	}
+	l.mu.Unlock()
+	switch {
+	case acquired && !wasHeld:
+		log.Infof("<%s> acquired: holder=%s", l.actorID, l.holder)
+	case !acquired && wasHeld:
+		log.Warningf("<%s> lost: holder=%s", l.actorID, l.holder)
+	}
+}
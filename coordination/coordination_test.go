@@ -0,0 +1,280 @@
+package coordination
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CoordinationSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&CoordinationSuite{})
+
+func (s *CoordinationSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.Coordination.LeaseTTL = 3 * time.Second
+	s.cfg.Coordination.Kubernetes.Namespace = "pixy"
+	s.cfg.Coordination.Kubernetes.TokenFile = ""
+}
+
+// Coordination is disabled by default.
+func (s *CoordinationSuite) TestNewNone(c *C) {
+	backend, err := New("default", s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(backend, IsNil)
+
+	lease := SpawnLease(actor.RootID, backend, "alerts", "pixy1", time.Second)
+	c.Assert(lease, IsNil)
+	c.Assert(lease.Held(), Equals, true)
+	lease.Stop()
+}
+
+// fakeAPIServer keeps Lease objects like the API server does, rejecting
+// updates with stale resource versions.
+type fakeAPIServer struct {
+	mu       sync.Mutex
+	leases   map[string]k8sLease
+	versions int
+	// If set, then all updates are rejected as if leases were updated
+	// concurrently.
+	conflict bool
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const prefix = "/apis/coordination.k8s.io/v1/namespaces/pixy/leases"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	var lease k8sLease
+	if r.Method != http.MethodGet {
+		json.NewDecoder(r.Body).Decode(&lease)
+	}
+	current, ok := f.leases[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(current)
+		return
+	case http.MethodPost:
+		name = lease.Metadata.Name
+		if _, ok := f.leases[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	case http.MethodPut:
+		if !ok || f.conflict || current.Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
+	f.versions++
+	lease.Metadata.ResourceVersion = strconv.Itoa(f.versions)
+	f.leases[name] = lease
+	json.NewEncoder(w).Encode(lease)
+}
+
+func (s *CoordinationSuite) spawnAPIServer() (*fakeAPIServer, *httptest.Server) {
+	f := &fakeAPIServer{leases: make(map[string]k8sLease)}
+	srv := httptest.NewServer(f)
+	s.cfg.Coordination.Backend = config.CoordinationKubernetes
+	s.cfg.Coordination.Kubernetes.APIURL = srv.URL
+	return f, srv
+}
+
+// A lease is held by the first instance to acquire it until it is released
+// or expires, and then it passes to another instance.
+func (s *CoordinationSuite) TestKubernetes(c *C) {
+	f, srv := s.spawnAPIServer()
+	defer srv.Close()
+	backend, err := New("Foo_Bar", s.cfg)
+	c.Assert(err, IsNil)
+	defer backend.Close()
+
+	// When/Then
+	acquired, err := backend.TryAcquire("alerts", "pixy1", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, true)
+	acquired, err = backend.TryAcquire("alerts", "pixy2", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, false)
+	acquired, err = backend.TryAcquire("alerts", "pixy1", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, true)
+
+	c.Assert(backend.Release("alerts", "pixy2"), IsNil)
+	c.Assert(f.leases["kafka-pixy-foo-bar-alerts"].Spec.HolderIdentity, Equals, "pixy1")
+	c.Assert(backend.Release("alerts", "pixy1"), IsNil)
+	acquired, err = backend.TryAcquire("alerts", "pixy2", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, true)
+
+	// Expired
+	spec := f.leases["kafka-pixy-foo-bar-alerts"].Spec
+	renewedAt, _ := time.Parse(microTimeLayout, spec.RenewTime)
+	lease := f.leases["kafka-pixy-foo-bar-alerts"]
+	lease.Spec.RenewTime = renewedAt.Add(-2 * time.Second).Format(microTimeLayout)
+	f.leases["kafka-pixy-foo-bar-alerts"] = lease
+	acquired, err = backend.TryAcquire("alerts", "pixy1", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, true)
+
+	lease = f.leases["kafka-pixy-foo-bar-alerts"]
+	c.Assert(lease.Kind, Equals, "Lease")
+	c.Assert(lease.Spec.HolderIdentity, Equals, "pixy1")
+	c.Assert(lease.Spec.LeaseDurationSeconds, Equals, 1)
+	c.Assert(lease.Spec.LeaseTransitions, Equals, 2)
+}
+
+// When an instance updates a lease concurrently, the update that loses the
+// race does not acquire it.
+func (s *CoordinationSuite) TestKubernetesConflict(c *C) {
+	f, srv := s.spawnAPIServer()
+	defer srv.Close()
+	backend, err := New("default", s.cfg)
+	c.Assert(err, IsNil)
+	acquired, err := backend.TryAcquire("alerts", "pixy1", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, true)
+	lease := f.leases["kafka-pixy-default-alerts"]
+	lease.Spec.HolderIdentity = ""
+	f.leases["kafka-pixy-default-alerts"] = lease
+	f.conflict = true
+
+	// When
+	acquired, err = backend.TryAcquire("alerts", "pixy2", time.Second)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(acquired, Equals, false)
+}
+
+func (s *CoordinationSuite) TestKubernetesError(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("leases is forbidden"))
+	}))
+	defer srv.Close()
+	s.cfg.Coordination.Backend = config.CoordinationKubernetes
+	s.cfg.Coordination.Kubernetes.APIURL = srv.URL
+	backend, err := New("default", s.cfg)
+	c.Assert(err, IsNil)
+
+	// When
+	_, err = backend.TryAcquire("alerts", "pixy1", time.Second)
+
+	// Then
+	c.Assert(err, ErrorMatches, "failed to get lease: status=403, body=leases is forbidden")
+}
+
+func (s *CoordinationSuite) TestKubernetesNotInPod(c *C) {
+	s.cfg.Coordination.Backend = config.CoordinationKubernetes
+	s.cfg.Coordination.Kubernetes.APIURL = ""
+	defer setEnv("KUBERNETES_SERVICE_HOST", "")()
+
+	// When
+	_, err := New("default", s.cfg)
+
+	// Then
+	c.Assert(err, ErrorMatches, "api_url is not set, and not running in a pod")
+}
+
+type fakeBackend struct {
+	mu       sync.Mutex
+	holder   string
+	err      error
+	released []string
+}
+
+func (b *fakeBackend) TryAcquire(name, holder string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return false, b.err
+	}
+	if b.holder == "" {
+		b.holder = holder
+	}
+	return b.holder == holder, nil
+}
+
+func (b *fakeBackend) Release(name, holder string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released = append(b.released, holder)
+	if b.holder == holder {
+		b.holder = ""
+	}
+	return nil
+}
+
+func (b *fakeBackend) Close() {}
+
+func (b *fakeBackend) set(holder string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holder, b.err = holder, err
+}
+
+// A lease is held while it is renewed, it is lost when another holder gets
+// it, and when it cannot be renewed it is held until it expires.
+func (s *CoordinationSuite) TestLease(c *C) {
+	backend := &fakeBackend{holder: "pixy2"}
+	lease := SpawnLease(actor.RootID, backend, "alerts", "pixy1", 150*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.Assert(lease.Held(), Equals, false)
+
+	// When/Then
+	backend.set("", nil)
+	time.Sleep(70 * time.Millisecond)
+	c.Assert(lease.Held(), Equals, true)
+
+	backend.set("pixy1", errors.New("kaboom"))
+	time.Sleep(70 * time.Millisecond)
+	c.Assert(lease.Held(), Equals, true)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(lease.Held(), Equals, false)
+
+	backend.set("pixy1", nil)
+	time.Sleep(70 * time.Millisecond)
+	c.Assert(lease.Held(), Equals, true)
+
+	lease.Stop()
+	c.Assert(backend.released, DeepEquals, []string{"pixy1"})
+	c.Assert(backend.holder, Equals, "")
+}
+
+func setEnv(key, value string) func() {
+	prev, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package coordination
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	microTimeLayout             = "2006-01-02T15:04:05.000000Z07:00"
+	maxLeaseNameLen             = 253
+)
+
+// Kubernetes keeps leases as Lease objects of the coordination.k8s.io/v1
+// API, named `kafka-pixy-<cluster>-<name>`. It talks to the API server over
+// plain HTTP, authenticated with the pod service account, so it needs no
+// Kubernetes client library. Concurrent updates are resolved by the API
+// server with optimistic locking on the resource version.
+type Kubernetes struct {
+	url       string
+	namespace string
+	cluster   string
+	token     string
+	httpClt   *http.Client
+}
+
+// NewKubernetes creates a Kubernetes API client as configured in the
+// `coordination.kubernetes` section of a proxy config.
+func NewKubernetes(cluster string, cfg *config.Proxy) (*Kubernetes, error) {
+	k8sCfg := cfg.Coordination.Kubernetes
+	k := &Kubernetes{
+		url:       strings.TrimSuffix(k8sCfg.APIURL, "/"),
+		namespace: k8sCfg.Namespace,
+		cluster:   cluster,
+		httpClt:   &http.Client{Timeout: cfg.Coordination.LeaseTTL / 3},
+	}
+	if k.url == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("api_url is not set, and not running in a pod")
+		}
+		k.url = "https://" + net.JoinHostPort(host, port)
+	}
+	if k.namespace == "" {
+		namespace, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read namespace")
+		}
+		k.namespace = strings.TrimSpace(string(namespace))
+	}
+	if k8sCfg.TokenFile != "" {
+		token, err := ioutil.ReadFile(k8sCfg.TokenFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to read token")
+		}
+		k.token = strings.TrimSpace(string(token))
+	}
+	if strings.HasPrefix(k.url, "https://") && k8sCfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(k8sCfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA certificate")
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("no certificates in %s", k8sCfg.CAFile)
+		}
+		k.httpClt.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: caPool},
+		}
+	}
+	return k, nil
+}
+
+type k8sLease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+	Spec       k8sLeaseSpec  `json:"spec"`
+}
+
+type k8sObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type k8sLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// expired tells whether a lease was not renewed within its duration.
+func (s *k8sLeaseSpec) expired(now time.Time) bool {
+	renewedAt, err := time.Parse(microTimeLayout, s.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewedAt.Add(time.Duration(s.LeaseDurationSeconds) * time.Second))
+}
+
+// TryAcquire implements Backend.
+func (k *Kubernetes) TryAcquire(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	nowStr := now.Format(microTimeLayout)
+	leaseDuration := int(ttl / time.Second)
+	if leaseDuration < 1 {
+		leaseDuration = 1
+	}
+	var lease k8sLease
+	status, err := k.do(http.MethodGet, k.leasePath(name), nil, &lease)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get lease")
+	}
+	if status == http.StatusNotFound {
+		lease = k8sLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   k8sObjectMeta{Name: k.leaseName(name), Namespace: k.namespace},
+			Spec: k8sLeaseSpec{
+				HolderIdentity:       holder,
+				LeaseDurationSeconds: leaseDuration,
+				AcquireTime:          nowStr,
+				RenewTime:            nowStr,
+			},
+		}
+		status, err = k.do(http.MethodPost, k.leasesPath(), lease, nil)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to create lease")
+		}
+		// Another instance created it first.
+		return status != http.StatusConflict, nil
+	}
+	spec := &lease.Spec
+	if spec.HolderIdentity != holder {
+		if spec.HolderIdentity != "" && !spec.expired(now) {
+			return false, nil
+		}
+		spec.HolderIdentity = holder
+		spec.AcquireTime = nowStr
+		spec.LeaseTransitions++
+	}
+	spec.LeaseDurationSeconds = leaseDuration
+	spec.RenewTime = nowStr
+	status, err = k.do(http.MethodPut, k.leasePath(name), lease, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update lease")
+	}
+	// The lease was updated by another instance in the meantime.
+	return status != http.StatusConflict, nil
+}
+
+// Release implements Backend. The Lease object is kept with no holder, so
+// that its transitions keep being counted.
+func (k *Kubernetes) Release(name, holder string) error {
+	var lease k8sLease
+	status, err := k.do(http.MethodGet, k.leasePath(name), nil, &lease)
+	if err != nil {
+		return errors.Wrap(err, "failed to get lease")
+	}
+	if status == http.StatusNotFound || lease.Spec.HolderIdentity != holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	if _, err := k.do(http.MethodPut, k.leasePath(name), lease, nil); err != nil {
+		return errors.Wrap(err, "failed to update lease")
+	}
+	return nil
+}
+
+// Close implements Backend.
+func (k *Kubernetes) Close() {}
+
+func (k *Kubernetes) leasesPath() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", k.namespace)
+}
+
+func (k *Kubernetes) leasePath(name string) string {
+	return k.leasesPath() + "/" + k.leaseName(name)
+}
+
+// leaseName makes a valid object name of a lease name, that is a lowercase
+// DNS subdomain.
+func (k *Kubernetes) leaseName(name string) string {
+	leaseName := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, "kafka-pixy-"+k.cluster+"-"+name)
+	if len(leaseName) > maxLeaseNameLen {
+		leaseName = leaseName[:maxLeaseNameLen]
+	}
+	return strings.TrimRight(leaseName, "-.")
+}
+
+// do makes a request to the API server. Not found and conflict statuses are
+// returned rather than reported as errors, for callers to handle them.
+func (k *Kubernetes) do(method, path string, body, result interface{}) (int, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return 0, errors.Wrap(err, "failed to marshal request")
+		}
+	}
+	rq, err := http.NewRequest(method, k.url+path, bytes.NewReader(encoded))
+	if err != nil {
+		return 0, err
+	}
+	rq.Header.Set("Accept", "application/json")
+	rq.Header.Set("Content-Type", "application/json")
+	if k.token != "" {
+		rq.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	rs, err := k.httpClt.Do(rq)
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode == http.StatusNotFound || rs.StatusCode == http.StatusConflict {
+		return rs.StatusCode, nil
+	}
+	if rs.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(rs.Body)
+		return rs.StatusCode, errors.Errorf("status=%d, body=%s", rs.StatusCode, bytes.TrimSpace(msg))
+	}
+	if result == nil {
+		return rs.StatusCode, nil
+	}
+	if err := json.NewDecoder(rs.Body).Decode(result); err != nil {
+		return rs.StatusCode, errors.Wrap(err, "failed to decode response")
+	}
+	return rs.StatusCode, nil
+}
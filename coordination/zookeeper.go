@@ -0,0 +1,92 @@
+package coordination
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ZooKeeper keeps leases as ephemeral znodes
+// `<chroot>/kafka-pixy/leases/<cluster>/<name>` with the holder as data. A
+// lease is held until released, or until the ZooKeeper session of the holder
+// expires, so TTL does not apply.
+type ZooKeeper struct {
+	conn *zk.Conn
+	path string
+}
+
+// NewZooKeeper connects to the ZooKeeper of a cluster.
+func NewZooKeeper(cluster string, cfg *config.Proxy) (*ZooKeeper, error) {
+	conn, _, err := zk.Connect(cfg.ZooKeeper.SeedPeers, cfg.ZooKeeper.SessionTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to ZooKeeper")
+	}
+	chroot := strings.TrimSuffix(cfg.ZooKeeper.Chroot, "/")
+	return &ZooKeeper{conn: conn, path: fmt.Sprintf("%s/kafka-pixy/leases/%s", chroot, cluster)}, nil
+}
+
+// TryAcquire implements Backend.
+func (z *ZooKeeper) TryAcquire(name, holder string, ttl time.Duration) (bool, error) {
+	if err := z.ensurePath(); err != nil {
+		return false, err
+	}
+	leasePath := z.path + "/" + name
+	_, err := z.conn.Create(leasePath, []byte(holder), zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err == nil {
+		return true, nil
+	}
+	if err != zk.ErrNodeExists {
+		return false, errors.Wrapf(err, "failed to create %s", leasePath)
+	}
+	data, _, err := z.conn.Get(leasePath)
+	if err == zk.ErrNoNode {
+		// It was released in the meantime, so it is up for grabs again on
+		// the next attempt.
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get %s", leasePath)
+	}
+	return string(data) == holder, nil
+}
+
+// Release implements Backend.
+func (z *ZooKeeper) Release(name, holder string) error {
+	leasePath := z.path + "/" + name
+	data, stat, err := z.conn.Get(leasePath)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %s", leasePath)
+	}
+	if string(data) != holder {
+		return nil
+	}
+	if err := z.conn.Delete(leasePath, stat.Version); err != nil && err != zk.ErrNoNode {
+		return errors.Wrapf(err, "failed to delete %s", leasePath)
+	}
+	return nil
+}
+
+// Close implements Backend.
+func (z *ZooKeeper) Close() {
+	z.conn.Close()
+}
+
+// ensurePath creates persistent parent znodes of leases that do not exist.
+func (z *ZooKeeper) ensurePath() error {
+	node := ""
+	for _, part := range strings.Split(strings.TrimPrefix(z.path, "/"), "/") {
+		node += "/" + part
+		_, err := z.conn.Create(node, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return errors.Wrapf(err, "failed to create %s", node)
+		}
+	}
+	return nil
+}
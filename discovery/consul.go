@@ -0,0 +1,142 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// Consul registers instances as services of the local Consul agent, each
+// with a TTL health check that is updated with the instance health.
+type Consul struct {
+	url             string
+	token           string
+	ttl             time.Duration
+	deregisterAfter time.Duration
+	httpClt         *http.Client
+
+	mu sync.Mutex
+	// Tags of the last registration, nil if the service has to be
+	// registered again.
+	tags []string
+}
+
+// NewConsul creates a Consul registry client as configured in `discovery`.
+func NewConsul(cfg *config.Discovery) *Consul {
+	return &Consul{
+		url:             cfg.URL,
+		token:           cfg.Token,
+		ttl:             cfg.TTL,
+		deregisterAfter: cfg.DeregisterAfter,
+		httpClt:         &http.Client{Timeout: cfg.TTL / 3},
+	}
+}
+
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Tags    []string          `json:"Tags"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Meta    map[string]string `json:"Meta,omitempty"`
+	Check   consulCheck       `json:"Check"`
+}
+
+type consulCheck struct {
+	CheckID                        string `json:"CheckID"`
+	TTL                            string `json:"TTL"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter"`
+	Status                         string `json:"Status,omitempty"`
+}
+
+type consulCheckUpdate struct {
+	Status string `json:"Status"`
+	Output string `json:"Output"`
+}
+
+// Register implements Registry. The service is only registered again if
+// its tags changed, otherwise just its health check is updated.
+func (c *Consul) Register(inst Instance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := "passing"
+	if !inst.Healthy {
+		status = "critical"
+	}
+	if c.tags == nil || !reflect.DeepEqual(c.tags, inst.Tags) {
+		service := consulService{
+			ID:      inst.ID,
+			Name:    inst.Service,
+			Tags:    inst.Tags,
+			Address: inst.Host,
+			Port:    inst.Port,
+			Check: consulCheck{
+				CheckID:                        checkID(inst),
+				TTL:                            c.ttl.String(),
+				DeregisterCriticalServiceAfter: c.deregisterAfter.String(),
+				Status:                         status,
+			},
+		}
+		if inst.GRPCAddr != "" {
+			service.Meta = map[string]string{"grpc_addr": inst.GRPCAddr}
+		}
+		if err := c.put("/v1/agent/service/register", service); err != nil {
+			return errors.Wrap(err, "failed to register service")
+		}
+		c.tags = append([]string{}, inst.Tags...)
+	}
+	update := consulCheckUpdate{Status: status, Output: inst.Output}
+	if err := c.put("/v1/agent/check/update/"+checkID(inst), update); err != nil {
+		// The agent may have lost the registration, e.g. if it restarted.
+		c.tags = nil
+		return errors.Wrap(err, "failed to update check")
+	}
+	return nil
+}
+
+// Deregister implements Registry.
+func (c *Consul) Deregister(inst Instance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tags = nil
+	return c.put("/v1/agent/service/deregister/"+inst.ID, nil)
+}
+
+func (c *Consul) put(path string, body interface{}) error {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return errors.Wrap(err, "failed to marshal request")
+		}
+	}
+	rq, err := http.NewRequest(http.MethodPut, c.url+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		rq.Header.Set("X-Consul-Token", c.token)
+	}
+	rs, err := c.httpClt.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(rs.Body)
+		return errors.Errorf("status=%d, body=%s", rs.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func checkID(inst Instance) string {
+	return "service:" + inst.ID
+}
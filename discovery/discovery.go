@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// Instance is a registration of a Kafka-Pixy instance in a registry.
+type Instance struct {
+	ID      string `json:"id"`
+	Service string `json:"service"`
+
+	// Address of the HTTP API.
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// Address of the gRPC API, if it is enabled.
+	GRPCAddr string `json:"grpc_addr,omitempty"`
+
+	Tags    []string `json:"tags"`
+	Healthy bool     `json:"healthy"`
+
+	// Why the instance is not healthy.
+	Output string `json:"output,omitempty"`
+}
+
+// Status is the part of a registration that changes while an instance runs.
+type Status struct {
+	Tags    []string
+	Healthy bool
+	Output  string
+}
+
+// Registry is a service registry that instances register in.
+type Registry interface {
+	// Register creates the registration of an instance, or refreshes it,
+	// so that it does not expire.
+	Register(inst Instance) error
+
+	// Deregister removes the registration of an instance.
+	Deregister(inst Instance) error
+}
+
+// T keeps an instance registered in a registry while it runs, refreshing
+// the registration with the current status every third of `discovery.ttl`.
+// A nil instance is valid, it registers nowhere.
+type T struct {
+	actorID  *actor.ID
+	registry Registry
+	instance Instance
+	interval time.Duration
+	status   func() Status
+	stopCh   chan none.T
+	wg       sync.WaitGroup
+}
+
+// New creates a registrar of the instance in the registry configured in the
+// `discovery` section, or returns nil if it is not configured. `status` is
+// called to get the current tags and health every time the registration is
+// refreshed.
+func New(namespace *actor.ID, cfg *config.App, status func() Status) (*T, error) {
+	var registry Registry
+	switch cfg.Discovery.Registry {
+	case "":
+		return nil, nil
+	case config.RegistryConsul:
+		registry = NewConsul(&cfg.Discovery)
+	case config.RegistryEtcd:
+		registry = NewEtcd(&cfg.Discovery)
+	default:
+		return nil, errors.Errorf("bad registry: %s", cfg.Discovery.Registry)
+	}
+	host, port, err := advertiseAddr(cfg)
+	if err != nil {
+		return nil, err
+	}
+	instance := Instance{
+		ID:       cfg.Discovery.ID,
+		Service:  cfg.Discovery.ServiceName,
+		Host:     host,
+		Port:     port,
+		GRPCAddr: cfg.GRPCAddr,
+	}
+	if instance.ID == "" {
+		instance.ID = cfg.Discovery.ServiceName + "-" + host + "-" + strconv.Itoa(port)
+	}
+	return NewWithRegistry(namespace, &cfg.Discovery, instance, registry, status), nil
+}
+
+// NewWithRegistry creates a registrar of `instance` in a custom registry.
+func NewWithRegistry(namespace *actor.ID, cfg *config.Discovery, instance Instance,
+	registry Registry, status func() Status,
+) *T {
+	return &T{
+		actorID:  namespace.NewChild("discovery"),
+		registry: registry,
+		instance: instance,
+		interval: cfg.TTL / 3,
+		status:   status,
+		stopCh:   make(chan none.T),
+	}
+}
+
+// Start registers the instance, and keeps refreshing the registration until
+// Stop is called.
+func (t *T) Start() {
+	if t == nil {
+		return
+	}
+	actor.Spawn(t.actorID, &t.wg, t.run)
+}
+
+// Stop deregisters the instance, so that clients do not discover it
+// anymore, and returns when it is done.
+func (t *T) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+func (t *T) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	registered := false
+	for {
+		inst := t.current()
+		if err := t.registry.Register(inst); err != nil {
+			log.Errorf("<%s> failed to register: id=%s, err=(%s)", t.actorID, inst.ID, err)
+			registered = false
+		} else if !registered {
+			log.Infof("<%s> registered: id=%s, tags=%v", t.actorID, inst.ID, inst.Tags)
+			registered = true
+		}
+		select {
+		case <-ticker.C:
+		case <-t.stopCh:
+			if err := t.registry.Deregister(inst); err != nil {
+				log.Errorf("<%s> failed to deregister: id=%s, err=(%s)", t.actorID, inst.ID, err)
+				return
+			}
+			log.Infof("<%s> deregistered: id=%s", t.actorID, inst.ID)
+			return
+		}
+	}
+}
+
+func (t *T) current() Instance {
+	status := t.status()
+	inst := t.instance
+	inst.Tags = status.Tags
+	inst.Healthy = status.Healthy
+	inst.Output = status.Output
+	return inst
+}
+
+// advertiseAddr returns the host and the port that clients should reach the
+// HTTP API at.
+func advertiseAddr(cfg *config.App) (string, int, error) {
+	addr := cfg.Discovery.AdvertiseAddr
+	if addr == "" {
+		addr = cfg.TCPAddr
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "bad advertise address: %s", addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "bad advertise address: %s", addr)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			return "", 0, errors.Wrap(err, "failed to get host name")
+		}
+	}
+	return host, port, nil
+}
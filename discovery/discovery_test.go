@@ -0,0 +1,212 @@
+package discovery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type DiscoverySuite struct {
+	cfg *config.App
+
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+}
+
+var _ = Suite(&DiscoverySuite{})
+
+func (s *DiscoverySuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultApp("default")
+	s.cfg.TCPAddr = "10.0.0.1:19092"
+	s.requests = nil
+	s.bodies = make(map[string]string)
+}
+
+// serve starts a fake registry, that records requests and responds to them
+// with the bodies given by path.
+func (s *DiscoverySuite) serve(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.bodies[r.URL.Path] = string(body)
+		s.mu.Unlock()
+		w.Write([]byte(responses[r.URL.Path]))
+	}))
+}
+
+func (s *DiscoverySuite) TestNew(c *C) {
+	s.cfg.Discovery.Registry = config.RegistryConsul
+	s.cfg.GRPCAddr = "10.0.0.1:19091"
+
+	// When
+	t, err := New(actor.RootID, s.cfg, func() Status { return Status{} })
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(t.instance, DeepEquals, Instance{
+		ID:       "kafka-pixy-10.0.0.1-19092",
+		Service:  "kafka-pixy",
+		Host:     "10.0.0.1",
+		Port:     19092,
+		GRPCAddr: "10.0.0.1:19091",
+	})
+}
+
+// Registration is disabled unless a registry is configured, and the host
+// name is advertised for listeners on all interfaces.
+func (s *DiscoverySuite) TestNewDisabled(c *C) {
+	t, err := New(actor.RootID, s.cfg, nil)
+	c.Assert(err, IsNil)
+	c.Assert(t, IsNil)
+	t.Start()
+	t.Stop()
+
+	s.cfg.Discovery.Registry = config.RegistryEtcd
+	s.cfg.TCPAddr = "0.0.0.0:19092"
+	t, err = New(actor.RootID, s.cfg, nil)
+	c.Assert(err, IsNil)
+	c.Assert(t.instance.Host, Not(Equals), "0.0.0.0")
+	c.Assert(t.instance.Host, Not(Equals), "")
+}
+
+// The service is registered with a TTL check, that is updated with the
+// instance health on every refresh, and the service is registered again only
+// if tags change.
+func (s *DiscoverySuite) TestConsul(c *C) {
+	srv := s.serve(nil)
+	defer srv.Close()
+	s.cfg.Discovery.URL = srv.URL
+	s.cfg.Discovery.Token = "secret"
+	consul := NewConsul(&s.cfg.Discovery)
+	inst := Instance{ID: "i1", Service: "kafka-pixy", Host: "h1", Port: 19092, Tags: []string{"cluster:default"}, Healthy: true}
+
+	// When
+	c.Assert(consul.Register(inst), IsNil)
+	c.Assert(consul.Register(inst), IsNil)
+	inst.Healthy, inst.Output = false, "cluster default not ready"
+	c.Assert(consul.Register(inst), IsNil)
+	inst.Tags = []string{"cluster:default", "cluster:bar"}
+	c.Assert(consul.Register(inst), IsNil)
+	c.Assert(consul.Deregister(inst), IsNil)
+
+	// Then
+	c.Assert(s.requests, DeepEquals, []string{
+		"PUT /v1/agent/service/register",
+		"PUT /v1/agent/check/update/service:i1",
+		"PUT /v1/agent/check/update/service:i1",
+		"PUT /v1/agent/check/update/service:i1",
+		"PUT /v1/agent/service/register",
+		"PUT /v1/agent/check/update/service:i1",
+		"PUT /v1/agent/service/deregister/i1",
+	})
+	var service consulService
+	c.Assert(json.Unmarshal([]byte(s.bodies["/v1/agent/service/register"]), &service), IsNil)
+	c.Assert(service.Tags, DeepEquals, []string{"cluster:default", "cluster:bar"})
+	c.Assert(service.Check, DeepEquals, consulCheck{
+		CheckID:                        "service:i1",
+		TTL:                            "15s",
+		DeregisterCriticalServiceAfter: "1m0s",
+		Status:                         "critical",
+	})
+	c.Assert(s.bodies["/v1/agent/check/update/service:i1"], Equals,
+		`{"Status":"critical","Output":"cluster default not ready"}`)
+}
+
+// A key is put under a lease, that is kept alive on refresh, and revoked on
+// deregistration. If the lease expired, then a new one is granted.
+func (s *DiscoverySuite) TestEtcd(c *C) {
+	responses := map[string]string{
+		"/v3/lease/grant":     `{"ID":"7587","TTL":"15"}`,
+		"/v3/lease/keepalive": `{"result":{"ID":"7587","TTL":"15"}}`,
+	}
+	srv := s.serve(responses)
+	defer srv.Close()
+	s.cfg.Discovery.URL = srv.URL
+	etcd := NewEtcd(&s.cfg.Discovery)
+	inst := Instance{ID: "i1", Service: "kafka-pixy", Host: "h1", Port: 19092, Healthy: true}
+
+	// When
+	c.Assert(etcd.Register(inst), IsNil)
+	c.Assert(etcd.Register(inst), IsNil)
+	responses["/v3/lease/keepalive"] = `{"result":{"ID":"7587"}}`
+	c.Assert(etcd.Register(inst), IsNil)
+	c.Assert(etcd.Deregister(inst), IsNil)
+
+	// Then
+	c.Assert(s.requests, DeepEquals, []string{
+		"POST /v3/lease/grant",
+		"POST /v3/kv/put",
+		"POST /v3/lease/keepalive",
+		"POST /v3/lease/keepalive",
+		"POST /v3/lease/grant",
+		"POST /v3/kv/put",
+		"POST /v3/lease/revoke",
+	})
+	var put etcdPutRq
+	c.Assert(json.Unmarshal([]byte(s.bodies["/v3/kv/put"]), &put), IsNil)
+	c.Assert(string(put.Key), Equals, "/services/kafka-pixy/i1")
+	c.Assert(put.Lease, Equals, json.Number("7587"))
+	var registered Instance
+	c.Assert(json.Unmarshal(put.Value, &registered), IsNil)
+	c.Assert(registered, DeepEquals, inst)
+	c.Assert(s.bodies["/v3/lease/revoke"], Equals, `{"ID":7587}`)
+}
+
+type testRegistry struct {
+	mu         sync.Mutex
+	registered []Instance
+	removed    []Instance
+}
+
+func (r *testRegistry) Register(inst Instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered = append(r.registered, inst)
+	return nil
+}
+
+func (r *testRegistry) Deregister(inst Instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removed = append(r.removed, inst)
+	return nil
+}
+
+// Registration is refreshed with the current status until the registrar is
+// stopped, and then the instance is deregistered.
+func (s *DiscoverySuite) TestRegistrar(c *C) {
+	s.cfg.Discovery.TTL = 30 * time.Millisecond
+	registry := &testRegistry{}
+	inst := Instance{ID: "i1", Service: "kafka-pixy"}
+	t := NewWithRegistry(actor.RootID, &s.cfg.Discovery, inst, registry, func() Status {
+		return Status{Tags: []string{"cluster:default"}, Healthy: true}
+	})
+
+	// When
+	t.Start()
+	time.Sleep(50 * time.Millisecond)
+	t.Stop()
+
+	// Then
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	c.Assert(len(registry.registered) > 1, Equals, true)
+	c.Assert(registry.registered[0], DeepEquals, Instance{
+		ID: "i1", Service: "kafka-pixy", Tags: []string{"cluster:default"}, Healthy: true})
+	c.Assert(registry.removed, HasLen, 1)
+	c.Assert(registry.removed[0].ID, Equals, "i1")
+}
@@ -0,0 +1,154 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// Etcd registers instances as JSON values of keys
+// `<etcd_prefix>/<service_name>/<id>`, attached to a lease that expires
+// after `discovery.ttl` unless it is kept alive. It uses the JSON gateway of
+// the etcd v3 API, so it needs no etcd client library.
+type Etcd struct {
+	url     string
+	token   string
+	prefix  string
+	ttl     time.Duration
+	httpClt *http.Client
+
+	mu      sync.Mutex
+	leaseID json.Number
+	// Value put last under the current lease.
+	value []byte
+}
+
+// NewEtcd creates an etcd registry client as configured in `discovery`.
+func NewEtcd(cfg *config.Discovery) *Etcd {
+	return &Etcd{
+		url:     cfg.URL,
+		token:   cfg.Token,
+		prefix:  cfg.EtcdPrefix,
+		ttl:     cfg.TTL,
+		httpClt: &http.Client{Timeout: cfg.TTL / 3},
+	}
+}
+
+type etcdLeaseRq struct {
+	TTL int64       `json:"TTL,omitempty"`
+	ID  json.Number `json:"ID,omitempty"`
+}
+
+type etcdLeaseRs struct {
+	ID  json.Number `json:"ID"`
+	TTL json.Number `json:"TTL"`
+}
+
+type etcdKeepAliveRs struct {
+	Result etcdLeaseRs `json:"result"`
+}
+
+type etcdPutRq struct {
+	Key   []byte      `json:"key"`
+	Value []byte      `json:"value"`
+	Lease json.Number `json:"lease"`
+}
+
+// Register implements Registry. The key is only put if the value changed,
+// otherwise just the lease is kept alive. If the lease expired, then a new
+// one is granted.
+func (e *Etcd) Register(inst Instance) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leaseID != "" {
+		var rs etcdKeepAliveRs
+		if err := e.post("/v3/lease/keepalive", etcdLeaseRq{ID: e.leaseID}, &rs); err != nil {
+			return errors.Wrap(err, "failed to keep lease alive")
+		}
+		if ttl, _ := rs.Result.TTL.Int64(); ttl <= 0 {
+			e.leaseID, e.value = "", nil
+		}
+	}
+	if e.leaseID == "" {
+		ttl := int64(e.ttl / time.Second)
+		if ttl < 1 {
+			ttl = 1
+		}
+		var rs etcdLeaseRs
+		if err := e.post("/v3/lease/grant", etcdLeaseRq{TTL: ttl}, &rs); err != nil {
+			return errors.Wrap(err, "failed to grant lease")
+		}
+		e.leaseID = rs.ID
+	}
+	value, err := json.Marshal(inst)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal instance")
+	}
+	if bytes.Equal(value, e.value) {
+		return nil
+	}
+	rq := etcdPutRq{Key: []byte(e.key(inst)), Value: value, Lease: e.leaseID}
+	if err := e.post("/v3/kv/put", rq, nil); err != nil {
+		return errors.Wrap(err, "failed to put key")
+	}
+	e.value = value
+	return nil
+}
+
+// Deregister implements Registry. Revoking the lease deletes the key.
+func (e *Etcd) Deregister(inst Instance) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leaseID == "" {
+		return nil
+	}
+	if err := e.post("/v3/lease/revoke", etcdLeaseRq{ID: e.leaseID}, nil); err != nil {
+		return errors.Wrap(err, "failed to revoke lease")
+	}
+	e.leaseID, e.value = "", nil
+	return nil
+}
+
+func (e *Etcd) key(inst Instance) string {
+	return e.prefix + "/" + inst.Service + "/" + inst.ID
+}
+
+// post makes a request to the gateway. Byte slices are base64 encoded in
+// JSON, that is what the gateway expects of keys and values.
+func (e *Etcd) post(path string, body, result interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal request")
+	}
+	rq, err := http.NewRequest(http.MethodPost, e.url+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		rq.Header.Set("Authorization", e.token)
+	}
+	rs, err := e.httpClt.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(rs.Body)
+		return errors.Errorf("status=%d, body=%s", rs.StatusCode, bytes.TrimSpace(msg))
+	}
+	if result == nil {
+		return nil
+	}
+	// Keep-alive responses are streamed, so only the first object is read.
+	if err := json.NewDecoder(rs.Body).Decode(result); err != nil {
+		return errors.Wrap(err, "failed to decode response")
+	}
+	return nil
+}
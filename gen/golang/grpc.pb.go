@@ -15,9 +15,22 @@ It has these top-level messages:
 	ConsRs
 	AckRq
 	AckRs
+	NackRq
+	NackRs
 	PartitionOffset
 	GetOffsetsRq
 	GetOffsetsRs
+	CheckpointRq
+	CheckpointRs
+	Checkpoint
+	GetCheckpointsRs
+	FlushRq
+	FlushRs
+	WatchGroupEventsRq
+	GroupEv
+	ListConsumersRq
+	GroupConsumers
+	Consumer
 */
 package pb
 
@@ -41,6 +54,56 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type NackRq_ErrorClass int32
+
+const (
+	NackRq_RETRYABLE NackRq_ErrorClass = 0
+	NackRq_FATAL     NackRq_ErrorClass = 1
+	NackRq_THROTTLE  NackRq_ErrorClass = 2
+)
+
+var NackRq_ErrorClass_name = map[int32]string{
+	0: "RETRYABLE",
+	1: "FATAL",
+	2: "THROTTLE",
+}
+var NackRq_ErrorClass_value = map[string]int32{
+	"RETRYABLE": 0,
+	"FATAL":     1,
+	"THROTTLE":  2,
+}
+
+func (x NackRq_ErrorClass) String() string {
+	return proto.EnumName(NackRq_ErrorClass_name, int32(x))
+}
+func (NackRq_ErrorClass) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{6, 0} }
+
+type GroupEv_Kind int32
+
+const (
+	GroupEv_ASSIGNED GroupEv_Kind = 0
+	GroupEv_REVOKED  GroupEv_Kind = 1
+	// Partitions were added to the topic, they are assigned after
+	// consumer.partition_settle_delay.
+	GroupEv_EXPANDED GroupEv_Kind = 2
+)
+
+var GroupEv_Kind_name = map[int32]string{
+	0: "ASSIGNED",
+	1: "REVOKED",
+	2: "EXPANDED",
+}
+var GroupEv_Kind_value = map[string]int32{
+	"ASSIGNED": 0,
+	"REVOKED":  1,
+	"EXPANDED": 2,
+}
+
+func (x GroupEv_Kind) String() string {
+	return proto.EnumName(GroupEv_Kind_name, int32(x))
+}
+func (GroupEv_Kind) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{18, 0} }
+
 type ProdRq struct {
 	// Name of a Kafka cluster to operate on.
 	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
@@ -62,6 +125,30 @@ type ProdRq struct {
 	// written to all ISR and response provides partition+offset where it was
 	// actually written.
 	AsyncMode bool `protobuf:"varint,6,opt,name=async_mode,json=asyncMode" json:"async_mode,omitempty"`
+	// If given, then the message is kept by Kafka-Pixy until this RFC3339
+	// timestamp, and produced to the topic when it is due. The method returns
+	// as soon as the message is persisted, and partition and offset returned
+	// in response should be ignored, as in async_mode.
+	DeliverAt string `protobuf:"bytes,7,opt,name=deliver_at,json=deliverAt" json:"deliver_at,omitempty"`
+	// Acknowledgement level to produce the message with: "none", "leader" or
+	// "all". It has to be either the default level of the cluster, or one of
+	// those listed in producer.allowed_acks. By default the default level of
+	// the cluster is used.
+	Acks string `protobuf:"bytes,8,opt,name=acks" json:"acks,omitempty"`
+	// If true then a tombstone, that is a message with a null body, is
+	// produced and message is ignored. It deletes the key from a compacted
+	// topic, hence key_undefined must be false.
+	MessageUndefined bool `protobuf:"varint,9,opt,name=message_undefined,json=messageUndefined" json:"message_undefined,omitempty"`
+	// If given in async_mode, then the outcome of the request is posted as
+	// JSON to this URL. It has to start with one of the prefixes listed in
+	// producer.outcomes.url_prefixes.
+	CallbackUrl string `protobuf:"bytes,10,opt,name=callback_url,json=callbackUrl" json:"callback_url,omitempty"`
+	// If given in async_mode, then the outcome of the request is produced as
+	// JSON to this topic, keyed by correlation_id.
+	ResultTopic string `protobuf:"bytes,11,opt,name=result_topic,json=resultTopic" json:"result_topic,omitempty"`
+	// Identifies the request in its outcome. It is required if either
+	// callback_url or result_topic is given.
+	CorrelationId string `protobuf:"bytes,12,opt,name=correlation_id,json=correlationId" json:"correlation_id,omitempty"`
 }
 
 func (m *ProdRq) Reset()                    { *m = ProdRq{} }
@@ -111,6 +198,48 @@ func (m *ProdRq) GetAsyncMode() bool {
 	return false
 }
 
+func (m *ProdRq) GetDeliverAt() string {
+	if m != nil {
+		return m.DeliverAt
+	}
+	return ""
+}
+
+func (m *ProdRq) GetAcks() string {
+	if m != nil {
+		return m.Acks
+	}
+	return ""
+}
+
+func (m *ProdRq) GetMessageUndefined() bool {
+	if m != nil {
+		return m.MessageUndefined
+	}
+	return false
+}
+
+func (m *ProdRq) GetCallbackUrl() string {
+	if m != nil {
+		return m.CallbackUrl
+	}
+	return ""
+}
+
+func (m *ProdRq) GetResultTopic() string {
+	if m != nil {
+		return m.ResultTopic
+	}
+	return ""
+}
+
+func (m *ProdRq) GetCorrelationId() string {
+	if m != nil {
+		return m.CorrelationId
+	}
+	return ""
+}
+
 type ProdRs struct {
 	// Partition the message was written to. The value only makes sense if
 	// ProdReq.async_mode was false.
@@ -118,6 +247,8 @@ type ProdRs struct {
 	// Offset the message was written to. The value only makes sense if
 	// ProdReq.async_mode was false.
 	Offset int64 `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	// Acknowledgement level the message was produced with.
+	Acks string `protobuf:"bytes,3,opt,name=acks" json:"acks,omitempty"`
 }
 
 func (m *ProdRs) Reset()                    { *m = ProdRs{} }
@@ -139,6 +270,13 @@ func (m *ProdRs) GetOffset() int64 {
 	return 0
 }
 
+func (m *ProdRs) GetAcks() string {
+	if m != nil {
+		return m.Acks
+	}
+	return ""
+}
+
 type ConsNAckRq struct {
 	// Name of a Kafka cluster to operate on.
 	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
@@ -156,6 +294,16 @@ type ConsNAckRq struct {
 	// should be acknowledged by the request.
 	AckPartition int32 `protobuf:"varint,6,opt,name=ack_partition,json=ackPartition" json:"ack_partition,omitempty"`
 	AckOffset    int64 `protobuf:"varint,7,opt,name=ack_offset,json=ackOffset" json:"ack_offset,omitempty"`
+	// Where to start consuming partitions that the group has no committed
+	// offsets for: `earliest`, `latest`, or an RFC3339 timestamp of the first
+	// message to consume. If empty, then `consumer.offset_reset` configured
+	// for the proxy is used.
+	OffsetReset string `protobuf:"bytes,8,opt,name=offset_reset,json=offsetReset" json:"offset_reset,omitempty"`
+	// Maximum number of consecutive messages of a partition to claim with the
+	// request. The number of returned messages is also limited by
+	// `consumer.max_claim_size` configured for the proxy. If 0, then only one
+	// message is returned.
+	MaxMessages int32 `protobuf:"varint,9,opt,name=max_messages,json=maxMessages" json:"max_messages,omitempty"`
 }
 
 func (m *ConsNAckRq) Reset()                    { *m = ConsNAckRq{} }
@@ -212,6 +360,20 @@ func (m *ConsNAckRq) GetAckOffset() int64 {
 	return 0
 }
 
+func (m *ConsNAckRq) GetOffsetReset() string {
+	if m != nil {
+		return m.OffsetReset
+	}
+	return ""
+}
+
+func (m *ConsNAckRq) GetMaxMessages() int32 {
+	if m != nil {
+		return m.MaxMessages
+	}
+	return 0
+}
+
 type ConsRs struct {
 	// Partition the message was read from.
 	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
@@ -224,6 +386,25 @@ type ConsRs struct {
 	KeyUndefined bool `protobuf:"varint,4,opt,name=key_undefined,json=keyUndefined" json:"key_undefined,omitempty"`
 	// Message body
 	Message []byte `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+	// Messages that immediately follow the returned one in the same partition
+	// and that were claimed by the request along with it. Each of them has to
+	// be acknowledged individually.
+	Following []*ConsRs `protobuf:"bytes,6,rep,name=following" json:"following,omitempty"`
+	// High watermark of the partition as of when the message was fetched,
+	// that is the offset of the message that is going to be produced next.
+	HighWatermark int64 `protobuf:"varint,7,opt,name=high_watermark,json=highWatermark" json:"high_watermark,omitempty"`
+	// Number of messages in the partition that come after the read one, as
+	// of when it was fetched.
+	Lag int64 `protobuf:"varint,8,opt,name=lag" json:"lag,omitempty"`
+	// If true then the message is re-delivered by a replay request. It has to
+	// be acknowledged as usual, but that does not affect the group offsets.
+	Replay bool `protobuf:"varint,9,opt,name=replay" json:"replay,omitempty"`
+	// If true then the message is a tombstone, that is its body is null
+	// rather than empty.
+	MessageUndefined bool `protobuf:"varint,10,opt,name=message_undefined,json=messageUndefined" json:"message_undefined,omitempty"`
+	// Number of offsets right before the message that have no messages,
+	// e.g. because they were compacted away or removed by retention.
+	Skipped int64 `protobuf:"varint,11,opt,name=skipped" json:"skipped,omitempty"`
 }
 
 func (m *ConsRs) Reset()                    { *m = ConsRs{} }
@@ -266,6 +447,48 @@ func (m *ConsRs) GetMessage() []byte {
 	return nil
 }
 
+func (m *ConsRs) GetFollowing() []*ConsRs {
+	if m != nil {
+		return m.Following
+	}
+	return nil
+}
+
+func (m *ConsRs) GetHighWatermark() int64 {
+	if m != nil {
+		return m.HighWatermark
+	}
+	return 0
+}
+
+func (m *ConsRs) GetLag() int64 {
+	if m != nil {
+		return m.Lag
+	}
+	return 0
+}
+
+func (m *ConsRs) GetReplay() bool {
+	if m != nil {
+		return m.Replay
+	}
+	return false
+}
+
+func (m *ConsRs) GetMessageUndefined() bool {
+	if m != nil {
+		return m.MessageUndefined
+	}
+	return false
+}
+
+func (m *ConsRs) GetSkipped() int64 {
+	if m != nil {
+		return m.Skipped
+	}
+	return 0
+}
+
 type AckRq struct {
 	// Name of a Kafka cluster to operate on.
 	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
@@ -327,6 +550,85 @@ func (m *AckRs) String() string            { return proto.CompactTextString(m) }
 func (*AckRs) ProtoMessage()               {}
 func (*AckRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
+type NackRq struct {
+	// Name of a Kafka cluster to operate on.
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// Name of a topic.
+	Topic string `protobuf:"bytes,2,opt,name=topic" json:"topic,omitempty"`
+	// Name of a consumer group.
+	Group string `protobuf:"bytes,3,opt,name=group" json:"group,omitempty"`
+	// Partition that the nacked message was consumed from.
+	Partition int32 `protobuf:"varint,4,opt,name=partition" json:"partition,omitempty"`
+	// Offset in the partition that the nacked message was consumed from.
+	Offset int64 `protobuf:"varint,5,opt,name=offset" json:"offset,omitempty"`
+	// Tells why the message could not be processed.
+	ErrorClass NackRq_ErrorClass `protobuf:"varint,6,opt,name=error_class,json=errorClass,enum=NackRq_ErrorClass" json:"error_class,omitempty"`
+	// Optional human readable reason, that is only logged.
+	Reason string `protobuf:"bytes,7,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *NackRq) Reset()                    { *m = NackRq{} }
+func (m *NackRq) String() string            { return proto.CompactTextString(m) }
+func (*NackRq) ProtoMessage()               {}
+func (*NackRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *NackRq) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
+}
+
+func (m *NackRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *NackRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *NackRq) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *NackRq) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *NackRq) GetErrorClass() NackRq_ErrorClass {
+	if m != nil {
+		return m.ErrorClass
+	}
+	return NackRq_RETRYABLE
+}
+
+func (m *NackRq) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+type NackRs struct {
+}
+
+func (m *NackRs) Reset()                    { *m = NackRs{} }
+func (m *NackRs) String() string            { return proto.CompactTextString(m) }
+func (*NackRs) ProtoMessage()               {}
+func (*NackRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
 type PartitionOffset struct {
 	// The Partition this structure describes
 	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
@@ -349,7 +651,7 @@ type PartitionOffset struct {
 func (m *PartitionOffset) Reset()                    { *m = PartitionOffset{} }
 func (m *PartitionOffset) String() string            { return proto.CompactTextString(m) }
 func (*PartitionOffset) ProtoMessage()               {}
-func (*PartitionOffset) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*PartitionOffset) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
 func (m *PartitionOffset) GetPartition() int32 {
 	if m != nil {
@@ -419,7 +721,7 @@ type GetOffsetsRq struct {
 func (m *GetOffsetsRq) Reset()                    { *m = GetOffsetsRq{} }
 func (m *GetOffsetsRq) String() string            { return proto.CompactTextString(m) }
 func (*GetOffsetsRq) ProtoMessage()               {}
-func (*GetOffsetsRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (*GetOffsetsRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
 
 func (m *GetOffsetsRq) GetCluster() string {
 	if m != nil {
@@ -449,7 +751,7 @@ type GetOffsetsRs struct {
 func (m *GetOffsetsRs) Reset()                    { *m = GetOffsetsRs{} }
 func (m *GetOffsetsRs) String() string            { return proto.CompactTextString(m) }
 func (*GetOffsetsRs) ProtoMessage()               {}
-func (*GetOffsetsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+func (*GetOffsetsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
 
 func (m *GetOffsetsRs) GetOffsets() []*PartitionOffset {
 	if m != nil {
@@ -458,110 +760,588 @@ func (m *GetOffsetsRs) GetOffsets() []*PartitionOffset {
 	return nil
 }
 
-func init() {
-	proto.RegisterType((*ProdRq)(nil), "ProdRq")
-	proto.RegisterType((*ProdRs)(nil), "ProdRs")
-	proto.RegisterType((*ConsNAckRq)(nil), "ConsNAckRq")
-	proto.RegisterType((*ConsRs)(nil), "ConsRs")
-	proto.RegisterType((*AckRq)(nil), "AckRq")
-	proto.RegisterType((*AckRs)(nil), "AckRs")
-	proto.RegisterType((*PartitionOffset)(nil), "PartitionOffset")
-	proto.RegisterType((*GetOffsetsRq)(nil), "GetOffsetsRq")
-	proto.RegisterType((*GetOffsetsRs)(nil), "GetOffsetsRs")
+type CheckpointRq struct {
+	// Name of a Kafka cluster to operate on.
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// Name of a topic.
+	Topic string `protobuf:"bytes,2,opt,name=topic" json:"topic,omitempty"`
+	// Name of a consumer group.
+	Group string `protobuf:"bytes,3,opt,name=group" json:"group,omitempty"`
+	// Partition to commit the checkpoint to.
+	Partition int32 `protobuf:"varint,4,opt,name=partition" json:"partition,omitempty"`
+	// Offset of the first message that is not covered by the checkpoint.
+	Offset int64 `protobuf:"varint,5,opt,name=offset" json:"offset,omitempty"`
+	// Client metadata to commit along with the offset. It is limited by
+	// config.yaml:proxies.<cluster>.consumer.max_checkpoint_size.
+	Metadata string `protobuf:"bytes,6,opt,name=metadata" json:"metadata,omitempty"`
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
-
-// Client API for KafkaPixy service
+func (m *CheckpointRq) Reset()                    { *m = CheckpointRq{} }
+func (m *CheckpointRq) String() string            { return proto.CompactTextString(m) }
+func (*CheckpointRq) ProtoMessage()               {}
+func (*CheckpointRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
 
-type KafkaPixyClient interface {
-	// Produce writes a message to a Kafka topic.
-	//
-	// If ProdReq.async_mode is false (default value) then the request will
-	// block until the message is written to all ISR. In this case the respose
-	// will contain the partition and offset of the message. This has to be
-	// used to achive at-least-once deliverability guarantee.
-	// If ProdReq.async_mode is true, then Kafka-Pixy returns immediately after
-	// it gets the request and performs write on the backgroud. This mode
-	// ensures highest throughput but messages can be lost, e.g. if the host
-	// crashes before Kafka-Pixy has a chance to complete write.
-	//
-	// Hash of ProdReq.key_value is used to determine a partition that the
-	// message should be written to. If you want a message to go to an random
-	// partition then set ProdReq.key_undefined to true. Note that if both
-	// ProdReq.key_undefined and ProdReq.key_value are left default, which is
-	// empty string and false respectively, then messages will be consitently
-	// written to a partiticular partition selected by the hash of an empty
-	// string.
-	//
-	// gRPC error codes:
-	//  * Invalid Argument (3): see the status description for details;
-	//  * Internal (13): see the status description and logs for details;
-	Produce(ctx context.Context, in *ProdRq, opts ...grpc.CallOption) (*ProdRs, error)
-	// Consume reads a message from a topic and optionally acknowledges a
-	// message previously consumed from the same topic.
-	//
-	// Requests are performed in long polling fation, that is if all available
-	// messages have been consumed then the request will block for
-	// config.yaml:proxies.<cluster>.consumer.long_polling_timeout waiting for
-	// new messages. If no new messages is produced while waiting the request
-	// will return gRPC error with 408 status code.
-	//
-	// To consume the first message set ConsNAckReq.no_ack to true, since there
-	// is no message to acknowledge at this point. In the second and all
-	// subsequent calls of the method set ConsNAckReq.ack_partition and
-	// ConsNAckReq.ack_offset to the respective values of ConsRes returned by
-	// the previous method call. To acknowledge the last consumed message before
-	// teminating the application call Ack method.
-	//
-	// If a message is not acknowledged within
-	// config.yaml:proxies.<cluster>.consumer.ack_timeout the it will be returned
-	// by Kafka-Pixy in ConsRes again possibly to another application.
-	//
-	// If at-least-once delivery guarantee and retries are not desirable, then
-	// you can set ConsNAckReq.auto_ack to true and Kafka-Pixy will acknowledge
-	// messages automatically before returning them in ConsRes.
-	//
-	// gRPC error codes:
-	//  * Not Found (5): It just means that all message has been consumed and
-	//    the long polling timeout has elaspsed. Just keep calling this method
-	//    in a loop;
-	//  * Resource Exhausted (8): too many consume requests. Either reduce the
-	//    number of consuming threads or increase
-	//    config.yaml:proxies.<cluster>.consumer.channel_buffer_size;
-	//  * Invalid Argument (3): see the status description for details;
-	//  * Internal (13): see the status description and logs for details;
-	ConsumeNAck(ctx context.Context, in *ConsNAckRq, opts ...grpc.CallOption) (*ConsRs, error)
-	// Ack acknowledges a message earlier consumed from a topic.
-	//
-	// This method is provided solely to acknowledge the last consumed message
-	// before the application terminates. In all other cases ConsumeNAck should
-	// be used.
-	//
-	// gRPC error codes:
-	//  * Invalid Argument (3): see the status description for details;
-	//  * Internal (13): see the status description and logs for details;
-	Ack(ctx context.Context, in *AckRq, opts ...grpc.CallOption) (*AckRs, error)
-	// Fetches partition offsets for the specified topic and group
-	//
-	// gRPC error codes:
-	//  * Invalid Argument (3): If unable to find the cluster named in the request
-	//  * Internal (13): If Kafka returns an error on offset request
-	//  * NotFound (5): If the group and or topic does not exist
-	GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error)
+func (m *CheckpointRq) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
 }
 
-type kafkaPixyClient struct {
-	cc *grpc.ClientConn
+func (m *CheckpointRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
 }
 
-func NewKafkaPixyClient(cc *grpc.ClientConn) KafkaPixyClient {
+func (m *CheckpointRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *CheckpointRq) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *CheckpointRq) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *CheckpointRq) GetMetadata() string {
+	if m != nil {
+		return m.Metadata
+	}
+	return ""
+}
+
+type CheckpointRs struct {
+}
+
+func (m *CheckpointRs) Reset()                    { *m = CheckpointRs{} }
+func (m *CheckpointRs) String() string            { return proto.CompactTextString(m) }
+func (*CheckpointRs) ProtoMessage()               {}
+func (*CheckpointRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+type Checkpoint struct {
+	// Partition the checkpoint was committed to.
+	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	// Offset that the checkpoint was committed with.
+	Offset int64 `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	// Client metadata that the checkpoint was committed with.
+	Metadata string `protobuf:"bytes,3,opt,name=metadata" json:"metadata,omitempty"`
+	// Offset committed by the group at the moment. It is ahead of the
+	// checkpoint offset if messages after it have been acknowledged.
+	Committed int64 `protobuf:"varint,4,opt,name=committed" json:"committed,omitempty"`
+}
+
+func (m *Checkpoint) Reset()                    { *m = Checkpoint{} }
+func (m *Checkpoint) String() string            { return proto.CompactTextString(m) }
+func (*Checkpoint) ProtoMessage()               {}
+func (*Checkpoint) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *Checkpoint) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *Checkpoint) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *Checkpoint) GetMetadata() string {
+	if m != nil {
+		return m.Metadata
+	}
+	return ""
+}
+
+func (m *Checkpoint) GetCommitted() int64 {
+	if m != nil {
+		return m.Committed
+	}
+	return 0
+}
+
+type GetCheckpointsRs struct {
+	Checkpoints []*Checkpoint `protobuf:"bytes,1,rep,name=checkpoints" json:"checkpoints,omitempty"`
+}
+
+func (m *GetCheckpointsRs) Reset()                    { *m = GetCheckpointsRs{} }
+func (m *GetCheckpointsRs) String() string            { return proto.CompactTextString(m) }
+func (*GetCheckpointsRs) ProtoMessage()               {}
+func (*GetCheckpointsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *GetCheckpointsRs) GetCheckpoints() []*Checkpoint {
+	if m != nil {
+		return m.Checkpoints
+	}
+	return nil
+}
+
+type FlushRq struct {
+	// Name of a Kafka cluster to operate on.
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// How long to wait for pending messages, in milliseconds. If zero, then
+	// config.yaml:proxies.<cluster>.producer.shutdown_timeout is used.
+	TimeoutMs int64 `protobuf:"varint,2,opt,name=timeout_ms,json=timeoutMs" json:"timeout_ms,omitempty"`
+}
+
+func (m *FlushRq) Reset()                    { *m = FlushRq{} }
+func (m *FlushRq) String() string            { return proto.CompactTextString(m) }
+func (*FlushRq) ProtoMessage()               {}
+func (*FlushRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *FlushRq) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
+}
+
+func (m *FlushRq) GetTimeoutMs() int64 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type FlushRs struct {
+	// Number of pending messages that were acknowledged by Kafka.
+	Acked int64 `protobuf:"varint,1,opt,name=acked" json:"acked,omitempty"`
+	// Number of pending messages that failed to be produced.
+	Failed int64 `protobuf:"varint,2,opt,name=failed" json:"failed,omitempty"`
+	// Number of messages that were still pending by the timeout.
+	Pending int64 `protobuf:"varint,3,opt,name=pending" json:"pending,omitempty"`
+}
+
+func (m *FlushRs) Reset()                    { *m = FlushRs{} }
+func (m *FlushRs) String() string            { return proto.CompactTextString(m) }
+func (*FlushRs) ProtoMessage()               {}
+func (*FlushRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *FlushRs) GetAcked() int64 {
+	if m != nil {
+		return m.Acked
+	}
+	return 0
+}
+
+func (m *FlushRs) GetFailed() int64 {
+	if m != nil {
+		return m.Failed
+	}
+	return 0
+}
+
+func (m *FlushRs) GetPending() int64 {
+	if m != nil {
+		return m.Pending
+	}
+	return 0
+}
+
+type WatchGroupEventsRq struct {
+	// Name of a Kafka cluster
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// Name of a consumer group.
+	Group string `protobuf:"bytes,2,opt,name=group" json:"group,omitempty"`
+	// Only events with sequence numbers greater then this are streamed.
+	Since int64 `protobuf:"varint,3,opt,name=since" json:"since,omitempty"`
+}
+
+func (m *WatchGroupEventsRq) Reset()                    { *m = WatchGroupEventsRq{} }
+func (m *WatchGroupEventsRq) String() string            { return proto.CompactTextString(m) }
+func (*WatchGroupEventsRq) ProtoMessage()               {}
+func (*WatchGroupEventsRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+func (m *WatchGroupEventsRq) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
+}
+
+func (m *WatchGroupEventsRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *WatchGroupEventsRq) GetSince() int64 {
+	if m != nil {
+		return m.Since
+	}
+	return 0
+}
+
+type GroupEv struct {
+	// Sequence number of the event within the consumer group.
+	Seq  int64        `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Kind GroupEv_Kind `protobuf:"varint,2,opt,name=kind,enum=GroupEv_Kind" json:"kind,omitempty"`
+	// Name of the topic the partitions belong to.
+	Topic      string  `protobuf:"bytes,3,opt,name=topic" json:"topic,omitempty"`
+	Partitions []int32 `protobuf:"varint,4,rep,packed,name=partitions" json:"partitions,omitempty"`
+	// Unix time in milliseconds when the event happened.
+	Timestamp int64 `protobuf:"varint,5,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *GroupEv) Reset()                    { *m = GroupEv{} }
+func (m *GroupEv) String() string            { return proto.CompactTextString(m) }
+func (*GroupEv) ProtoMessage()               {}
+func (*GroupEv) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *GroupEv) GetSeq() int64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *GroupEv) GetKind() GroupEv_Kind {
+	if m != nil {
+		return m.Kind
+	}
+	return GroupEv_ASSIGNED
+}
+
+func (m *GroupEv) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *GroupEv) GetPartitions() []int32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func (m *GroupEv) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+type ListConsumersRq struct {
+	// Name of a Kafka cluster
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// Name of a topic
+	Topic string `protobuf:"bytes,2,opt,name=topic" json:"topic,omitempty"`
+	// If not empty, only groups with names matching this regular expression
+	// are streamed.
+	Filter string `protobuf:"bytes,3,opt,name=filter" json:"filter,omitempty"`
+	// Only groups with names greater then this are streamed. It is supposed
+	// to be the last group received from a previous call.
+	After string `protobuf:"bytes,4,opt,name=after" json:"after,omitempty"`
+	// The maximum number of groups to stream. Zero means no limit.
+	Limit int32 `protobuf:"varint,5,opt,name=limit" json:"limit,omitempty"`
+}
+
+func (m *ListConsumersRq) Reset()                    { *m = ListConsumersRq{} }
+func (m *ListConsumersRq) String() string            { return proto.CompactTextString(m) }
+func (*ListConsumersRq) ProtoMessage()               {}
+func (*ListConsumersRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+func (m *ListConsumersRq) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
+}
+
+func (m *ListConsumersRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *ListConsumersRq) GetFilter() string {
+	if m != nil {
+		return m.Filter
+	}
+	return ""
+}
+
+func (m *ListConsumersRq) GetAfter() string {
+	if m != nil {
+		return m.After
+	}
+	return ""
+}
+
+func (m *ListConsumersRq) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+type GroupConsumers struct {
+	// Name of a consumer group.
+	Group     string      `protobuf:"bytes,1,opt,name=group" json:"group,omitempty"`
+	Consumers []*Consumer `protobuf:"bytes,2,rep,name=consumers" json:"consumers,omitempty"`
+	// If not empty, then consumers of the group could not be fetched.
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *GroupConsumers) Reset()                    { *m = GroupConsumers{} }
+func (m *GroupConsumers) String() string            { return proto.CompactTextString(m) }
+func (*GroupConsumers) ProtoMessage()               {}
+func (*GroupConsumers) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *GroupConsumers) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *GroupConsumers) GetConsumers() []*Consumer {
+	if m != nil {
+		return m.Consumers
+	}
+	return nil
+}
+
+func (m *GroupConsumers) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type Consumer struct {
+	// ID of a consumer group member, e.g. of a Kafka-Pixy instance.
+	ClientId string `protobuf:"bytes,1,opt,name=client_id,json=clientId" json:"client_id,omitempty"`
+	// Partitions of the topic assigned to the member.
+	Partitions []int32 `protobuf:"varint,2,rep,packed,name=partitions" json:"partitions,omitempty"`
+}
+
+func (m *Consumer) Reset()                    { *m = Consumer{} }
+func (m *Consumer) String() string            { return proto.CompactTextString(m) }
+func (*Consumer) ProtoMessage()               {}
+func (*Consumer) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+func (m *Consumer) GetClientId() string {
+	if m != nil {
+		return m.ClientId
+	}
+	return ""
+}
+
+func (m *Consumer) GetPartitions() []int32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*ProdRq)(nil), "ProdRq")
+	proto.RegisterType((*ProdRs)(nil), "ProdRs")
+	proto.RegisterType((*ConsNAckRq)(nil), "ConsNAckRq")
+	proto.RegisterType((*ConsRs)(nil), "ConsRs")
+	proto.RegisterType((*AckRq)(nil), "AckRq")
+	proto.RegisterType((*AckRs)(nil), "AckRs")
+	proto.RegisterType((*NackRq)(nil), "NackRq")
+	proto.RegisterType((*NackRs)(nil), "NackRs")
+	proto.RegisterType((*PartitionOffset)(nil), "PartitionOffset")
+	proto.RegisterType((*GetOffsetsRq)(nil), "GetOffsetsRq")
+	proto.RegisterType((*GetOffsetsRs)(nil), "GetOffsetsRs")
+	proto.RegisterType((*CheckpointRq)(nil), "CheckpointRq")
+	proto.RegisterType((*CheckpointRs)(nil), "CheckpointRs")
+	proto.RegisterType((*Checkpoint)(nil), "Checkpoint")
+	proto.RegisterType((*GetCheckpointsRs)(nil), "GetCheckpointsRs")
+	proto.RegisterType((*FlushRq)(nil), "FlushRq")
+	proto.RegisterType((*FlushRs)(nil), "FlushRs")
+	proto.RegisterType((*WatchGroupEventsRq)(nil), "WatchGroupEventsRq")
+	proto.RegisterType((*GroupEv)(nil), "GroupEv")
+	proto.RegisterType((*ListConsumersRq)(nil), "ListConsumersRq")
+	proto.RegisterType((*GroupConsumers)(nil), "GroupConsumers")
+	proto.RegisterType((*Consumer)(nil), "Consumer")
+	proto.RegisterEnum("NackRq_ErrorClass", NackRq_ErrorClass_name, NackRq_ErrorClass_value)
+	proto.RegisterEnum("GroupEv_Kind", GroupEv_Kind_name, GroupEv_Kind_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for KafkaPixy service
+
+type KafkaPixyClient interface {
+	// Produce writes a message to a Kafka topic.
+	//
+	// If ProdReq.async_mode is false (default value) then the request will
+	// block until the message is written to all ISR. In this case the respose
+	// will contain the partition and offset of the message. This has to be
+	// used to achive at-least-once deliverability guarantee.
+	// If ProdReq.async_mode is true, then Kafka-Pixy returns immediately after
+	// it gets the request and performs write on the backgroud. This mode
+	// ensures highest throughput but messages can be lost, e.g. if the host
+	// crashes before Kafka-Pixy has a chance to complete write.
+	//
+	// Hash of ProdReq.key_value is used to determine a partition that the
+	// message should be written to. If you want a message to go to an random
+	// partition then set ProdReq.key_undefined to true. Note that if both
+	// ProdReq.key_undefined and ProdReq.key_value are left default, which is
+	// empty string and false respectively, then messages will be consitently
+	// written to a partiticular partition selected by the hash of an empty
+	// string.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Internal (13): see the status description and logs for details;
+	Produce(ctx context.Context, in *ProdRq, opts ...grpc.CallOption) (*ProdRs, error)
+	// Consume reads a message from a topic and optionally acknowledges a
+	// message previously consumed from the same topic.
+	//
+	// Requests are performed in long polling fation, that is if all available
+	// messages have been consumed then the request will block for
+	// config.yaml:proxies.<cluster>.consumer.long_polling_timeout waiting for
+	// new messages. If no new messages is produced while waiting the request
+	// will return gRPC error with 408 status code.
+	//
+	// To consume the first message set ConsNAckReq.no_ack to true, since there
+	// is no message to acknowledge at this point. In the second and all
+	// subsequent calls of the method set ConsNAckReq.ack_partition and
+	// ConsNAckReq.ack_offset to the respective values of ConsRes returned by
+	// the previous method call. To acknowledge the last consumed message before
+	// teminating the application call Ack method.
+	//
+	// If a message is not acknowledged within
+	// config.yaml:proxies.<cluster>.consumer.ack_timeout the it will be returned
+	// by Kafka-Pixy in ConsRes again possibly to another application.
+	//
+	// If at-least-once delivery guarantee and retries are not desirable, then
+	// you can set ConsNAckReq.auto_ack to true and Kafka-Pixy will acknowledge
+	// messages automatically before returning them in ConsRes.
+	//
+	// gRPC error codes:
+	//  * Not Found (5): It just means that all message has been consumed and
+	//    the long polling timeout has elaspsed. Just keep calling this method
+	//    in a loop;
+	//  * Resource Exhausted (8): too many consume requests. Either reduce the
+	//    number of consuming threads or increase
+	//    config.yaml:proxies.<cluster>.consumer.channel_buffer_size;
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Internal (13): see the status description and logs for details;
+	ConsumeNAck(ctx context.Context, in *ConsNAckRq, opts ...grpc.CallOption) (*ConsRs, error)
+	// Ack acknowledges a message earlier consumed from a topic.
+	//
+	// This method is provided solely to acknowledge the last consumed message
+	// before the application terminates. In all other cases ConsumeNAck should
+	// be used.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Internal (13): see the status description and logs for details;
+	Ack(ctx context.Context, in *AckRq, opts ...grpc.CallOption) (*AckRs, error)
+	// Nack negatively acknowledges a message earlier consumed from a topic,
+	// that is tells that the client failed to process it. Retryable nacks
+	// have the message offered again after the redelivery backoff rather
+	// than after the ack timeout. Fatal nacks have it produced to the dead
+	// letter topic and acknowledged. Throttle nacks have it offered again
+	// after the backoff, and the partition held back meanwhile. See
+	// config.yaml:proxies.<cluster>.consumer.redelivery.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Permission Denied (7): if the topic is not allowed by the consumer ACL;
+	//  * Internal (13): see the status description and logs for details;
+	Nack(ctx context.Context, in *NackRq, opts ...grpc.CallOption) (*NackRs, error)
+	// Fetches partition offsets for the specified topic and group
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on offset request
+	//  * NotFound (5): If the group and or topic does not exist
+	GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error)
+	// WatchGroupEvents streams partition assignment changes of a consumer
+	// group on this Kafka-Pixy instance. Events are only emitted for groups
+	// that the instance consumes on behalf of its clients. When partitions
+	// are revoked their offsets have already been committed, so stateful
+	// consumers can flush per-partition caches on GroupEv.Kind.REVOKED.
+	//
+	// The stream never ends on its own, cancel it when done. To resume
+	// watching after reconnect set WatchGroupEventsRq.since to the seq of
+	// the last received event.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	WatchGroupEvents(ctx context.Context, in *WatchGroupEventsRq, opts ...grpc.CallOption) (KafkaPixy_WatchGroupEventsClient, error)
+	// StreamOffsets is like GetOffsets, except partition offsets are streamed
+	// one by one, so that huge topics can be processed as they come.
+	//
+	// gRPC error codes: same as GetOffsets.
+	StreamOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (KafkaPixy_StreamOffsetsClient, error)
+	// ListConsumers streams consumers of a topic in all consumer groups, one
+	// group at a time, as soon as they are fetched from ZooKeeper. Groups are
+	// streamed in the order of their names.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Permission Denied (7): if the topic is not allowed by the admin ACL;
+	//  * Internal (13): see the status description and logs for details;
+	ListConsumers(ctx context.Context, in *ListConsumersRq, opts ...grpc.CallOption) (KafkaPixy_ListConsumersClient, error)
+	// Checkpoint commits an offset of a partition along with opaque client
+	// metadata in one offset commit, e.g. ID of a downstream transaction that
+	// messages before the offset were stored with. All messages of the
+	// partition before the offset are considered acknowledged. It goes
+	// through the same path as acks, so they cannot override each other, and
+	// it returns only after the offset is committed to Kafka. The partition
+	// has to be consumed by the group.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Permission Denied (7): if the topic is not allowed by the consumer ACL;
+	//  * Failed Precondition (9): if the offset is behind the acknowledged one;
+	//  * Internal (13): see the status description and logs for details;
+	Checkpoint(ctx context.Context, in *CheckpointRq, opts ...grpc.CallOption) (*CheckpointRs, error)
+	// GetCheckpoints returns the last checkpoints committed by a consumer
+	// group to partitions of a topic. Partitions that have never been
+	// checkpointed are not included.
+	//
+	// gRPC error codes: same as GetOffsets.
+	GetCheckpoints(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetCheckpointsRs, error)
+	// Flush waits for all messages produced via Kafka-Pixy by the time of the
+	// call, including asynchronously produced ones, to be either
+	// acknowledged by Kafka or failed, and tells how many ended up which
+	// way. Messages that are neither by the timeout are reported as pending.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	Flush(ctx context.Context, in *FlushRq, opts ...grpc.CallOption) (*FlushRs, error)
+}
+
+type kafkaPixyClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewKafkaPixyClient(cc *grpc.ClientConn) KafkaPixyClient {
 	return &kafkaPixyClient{cc}
 }
 
@@ -592,6 +1372,15 @@ func (c *kafkaPixyClient) Ack(ctx context.Context, in *AckRq, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *kafkaPixyClient) Nack(ctx context.Context, in *NackRq, opts ...grpc.CallOption) (*NackRs, error) {
+	out := new(NackRs)
+	err := grpc.Invoke(ctx, "/KafkaPixy/Nack", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *kafkaPixyClient) GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error) {
 	out := new(GetOffsetsRs)
 	err := grpc.Invoke(ctx, "/KafkaPixy/GetOffsets", in, out, c.cc, opts...)
@@ -601,6 +1390,129 @@ func (c *kafkaPixyClient) GetOffsets(ctx context.Context, in *GetOffsetsRq, opts
 	return out, nil
 }
 
+func (c *kafkaPixyClient) WatchGroupEvents(ctx context.Context, in *WatchGroupEventsRq, opts ...grpc.CallOption) (KafkaPixy_WatchGroupEventsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KafkaPixy_serviceDesc.Streams[0], c.cc, "/KafkaPixy/WatchGroupEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kafkaPixyWatchGroupEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KafkaPixy_WatchGroupEventsClient interface {
+	Recv() (*GroupEv, error)
+	grpc.ClientStream
+}
+
+type kafkaPixyWatchGroupEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *kafkaPixyWatchGroupEventsClient) Recv() (*GroupEv, error) {
+	m := new(GroupEv)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kafkaPixyClient) StreamOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (KafkaPixy_StreamOffsetsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KafkaPixy_serviceDesc.Streams[1], c.cc, "/KafkaPixy/StreamOffsets", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kafkaPixyStreamOffsetsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KafkaPixy_StreamOffsetsClient interface {
+	Recv() (*PartitionOffset, error)
+	grpc.ClientStream
+}
+
+type kafkaPixyStreamOffsetsClient struct {
+	grpc.ClientStream
+}
+
+func (x *kafkaPixyStreamOffsetsClient) Recv() (*PartitionOffset, error) {
+	m := new(PartitionOffset)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kafkaPixyClient) ListConsumers(ctx context.Context, in *ListConsumersRq, opts ...grpc.CallOption) (KafkaPixy_ListConsumersClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KafkaPixy_serviceDesc.Streams[2], c.cc, "/KafkaPixy/ListConsumers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kafkaPixyListConsumersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KafkaPixy_ListConsumersClient interface {
+	Recv() (*GroupConsumers, error)
+	grpc.ClientStream
+}
+
+type kafkaPixyListConsumersClient struct {
+	grpc.ClientStream
+}
+
+func (x *kafkaPixyListConsumersClient) Recv() (*GroupConsumers, error) {
+	m := new(GroupConsumers)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kafkaPixyClient) Checkpoint(ctx context.Context, in *CheckpointRq, opts ...grpc.CallOption) (*CheckpointRs, error) {
+	out := new(CheckpointRs)
+	err := grpc.Invoke(ctx, "/KafkaPixy/Checkpoint", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) GetCheckpoints(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetCheckpointsRs, error) {
+	out := new(GetCheckpointsRs)
+	err := grpc.Invoke(ctx, "/KafkaPixy/GetCheckpoints", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) Flush(ctx context.Context, in *FlushRq, opts ...grpc.CallOption) (*FlushRs, error) {
+	out := new(FlushRs)
+	err := grpc.Invoke(ctx, "/KafkaPixy/Flush", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for KafkaPixy service
 
 type KafkaPixyServer interface {
@@ -671,6 +1583,19 @@ type KafkaPixyServer interface {
 	//  * Invalid Argument (3): see the status description for details;
 	//  * Internal (13): see the status description and logs for details;
 	Ack(context.Context, *AckRq) (*AckRs, error)
+	// Nack negatively acknowledges a message earlier consumed from a topic,
+	// that is tells that the client failed to process it. Retryable nacks
+	// have the message offered again after the redelivery backoff rather
+	// than after the ack timeout. Fatal nacks have it produced to the dead
+	// letter topic and acknowledged. Throttle nacks have it offered again
+	// after the backoff, and the partition held back meanwhile. See
+	// config.yaml:proxies.<cluster>.consumer.redelivery.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Permission Denied (7): if the topic is not allowed by the consumer ACL;
+	//  * Internal (13): see the status description and logs for details;
+	Nack(context.Context, *NackRq) (*NackRs, error)
 	// Fetches partition offsets for the specified topic and group
 	//
 	// gRPC error codes:
@@ -678,6 +1603,61 @@ type KafkaPixyServer interface {
 	//  * Internal (13): If Kafka returns an error on offset request
 	//  * NotFound (5): If the group and or topic does not exist
 	GetOffsets(context.Context, *GetOffsetsRq) (*GetOffsetsRs, error)
+	// WatchGroupEvents streams partition assignment changes of a consumer
+	// group on this Kafka-Pixy instance. Events are only emitted for groups
+	// that the instance consumes on behalf of its clients. When partitions
+	// are revoked their offsets have already been committed, so stateful
+	// consumers can flush per-partition caches on GroupEv.Kind.REVOKED.
+	//
+	// The stream never ends on its own, cancel it when done. To resume
+	// watching after reconnect set WatchGroupEventsRq.since to the seq of
+	// the last received event.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	WatchGroupEvents(*WatchGroupEventsRq, KafkaPixy_WatchGroupEventsServer) error
+	// StreamOffsets is like GetOffsets, except partition offsets are streamed
+	// one by one, so that huge topics can be processed as they come.
+	//
+	// gRPC error codes: same as GetOffsets.
+	StreamOffsets(*GetOffsetsRq, KafkaPixy_StreamOffsetsServer) error
+	// ListConsumers streams consumers of a topic in all consumer groups, one
+	// group at a time, as soon as they are fetched from ZooKeeper. Groups are
+	// streamed in the order of their names.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Permission Denied (7): if the topic is not allowed by the admin ACL;
+	//  * Internal (13): see the status description and logs for details;
+	ListConsumers(*ListConsumersRq, KafkaPixy_ListConsumersServer) error
+	// Checkpoint commits an offset of a partition along with opaque client
+	// metadata in one offset commit, e.g. ID of a downstream transaction that
+	// messages before the offset were stored with. All messages of the
+	// partition before the offset are considered acknowledged. It goes
+	// through the same path as acks, so they cannot override each other, and
+	// it returns only after the offset is committed to Kafka. The partition
+	// has to be consumed by the group.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	//  * Permission Denied (7): if the topic is not allowed by the consumer ACL;
+	//  * Failed Precondition (9): if the offset is behind the acknowledged one;
+	//  * Internal (13): see the status description and logs for details;
+	Checkpoint(context.Context, *CheckpointRq) (*CheckpointRs, error)
+	// GetCheckpoints returns the last checkpoints committed by a consumer
+	// group to partitions of a topic. Partitions that have never been
+	// checkpointed are not included.
+	//
+	// gRPC error codes: same as GetOffsets.
+	GetCheckpoints(context.Context, *GetOffsetsRq) (*GetCheckpointsRs, error)
+	// Flush waits for all messages produced via Kafka-Pixy by the time of the
+	// call, including asynchronously produced ones, to be either
+	// acknowledged by Kafka or failed, and tells how many ended up which
+	// way. Messages that are neither by the timeout are reported as pending.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	Flush(context.Context, *FlushRq) (*FlushRs, error)
 }
 
 func RegisterKafkaPixyServer(s *grpc.Server, srv KafkaPixyServer) {
@@ -738,6 +1718,24 @@ func _KafkaPixy_Ack_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KafkaPixy_Nack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NackRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).Nack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixy/Nack",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).Nack(ctx, req.(*NackRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _KafkaPixy_GetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetOffsetsRq)
 	if err := dec(in); err != nil {
@@ -756,6 +1754,123 @@ func _KafkaPixy_GetOffsets_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KafkaPixy_WatchGroupEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchGroupEventsRq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KafkaPixyServer).WatchGroupEvents(m, &kafkaPixyWatchGroupEventsServer{stream})
+}
+
+type KafkaPixy_WatchGroupEventsServer interface {
+	Send(*GroupEv) error
+	grpc.ServerStream
+}
+
+type kafkaPixyWatchGroupEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *kafkaPixyWatchGroupEventsServer) Send(m *GroupEv) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KafkaPixy_StreamOffsets_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetOffsetsRq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KafkaPixyServer).StreamOffsets(m, &kafkaPixyStreamOffsetsServer{stream})
+}
+
+type KafkaPixy_StreamOffsetsServer interface {
+	Send(*PartitionOffset) error
+	grpc.ServerStream
+}
+
+type kafkaPixyStreamOffsetsServer struct {
+	grpc.ServerStream
+}
+
+func (x *kafkaPixyStreamOffsetsServer) Send(m *PartitionOffset) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KafkaPixy_ListConsumers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListConsumersRq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KafkaPixyServer).ListConsumers(m, &kafkaPixyListConsumersServer{stream})
+}
+
+type KafkaPixy_ListConsumersServer interface {
+	Send(*GroupConsumers) error
+	grpc.ServerStream
+}
+
+type kafkaPixyListConsumersServer struct {
+	grpc.ServerStream
+}
+
+func (x *kafkaPixyListConsumersServer) Send(m *GroupConsumers) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KafkaPixy_Checkpoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckpointRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).Checkpoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixy/Checkpoint",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).Checkpoint(ctx, req.(*CheckpointRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_GetCheckpoints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOffsetsRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).GetCheckpoints(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixy/GetCheckpoints",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).GetCheckpoints(ctx, req.(*GetOffsetsRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_Flush_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FlushRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).Flush(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixy/Flush",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).Flush(ctx, req.(*FlushRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _KafkaPixy_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "KafkaPixy",
 	HandlerType: (*KafkaPixyServer)(nil),
@@ -772,52 +1887,132 @@ var _KafkaPixy_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Ack",
 			Handler:    _KafkaPixy_Ack_Handler,
 		},
+		{
+			MethodName: "Nack",
+			Handler:    _KafkaPixy_Nack_Handler,
+		},
 		{
 			MethodName: "GetOffsets",
 			Handler:    _KafkaPixy_GetOffsets_Handler,
 		},
+		{
+			MethodName: "Checkpoint",
+			Handler:    _KafkaPixy_Checkpoint_Handler,
+		},
+		{
+			MethodName: "GetCheckpoints",
+			Handler:    _KafkaPixy_GetCheckpoints_Handler,
+		},
+		{
+			MethodName: "Flush",
+			Handler:    _KafkaPixy_Flush_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchGroupEvents",
+			Handler:       _KafkaPixy_WatchGroupEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamOffsets",
+			Handler:       _KafkaPixy_StreamOffsets_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListConsumers",
+			Handler:       _KafkaPixy_ListConsumers_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "grpc.proto",
 }
 
 func init() { proto.RegisterFile("grpc.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 546 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xb4, 0x54, 0x41, 0x8e, 0xd3, 0x30,
-	0x14, 0xad, 0x27, 0x4d, 0xd2, 0xfc, 0xb6, 0x62, 0x64, 0x15, 0x08, 0x85, 0x11, 0x55, 0x46, 0x48,
-	0x15, 0x42, 0x59, 0x0c, 0x3b, 0x16, 0x48, 0x85, 0x05, 0x0b, 0x04, 0x54, 0x16, 0xb0, 0x60, 0x53,
-	0xb9, 0x8e, 0x5b, 0x45, 0x6e, 0xe3, 0x10, 0x3b, 0x88, 0xac, 0xb9, 0x04, 0xe2, 0x1a, 0x5c, 0x82,
-	0x03, 0x70, 0x20, 0x64, 0x3b, 0x6d, 0x29, 0xd2, 0x08, 0x69, 0x34, 0xb3, 0xaa, 0xdf, 0x7f, 0x76,
-	0xf3, 0xde, 0xfb, 0xfe, 0x06, 0x58, 0x57, 0x25, 0x4b, 0xcb, 0x4a, 0x6a, 0x99, 0xfc, 0x44, 0x10,
-	0xcc, 0x2b, 0x99, 0x91, 0xcf, 0x38, 0x86, 0x90, 0x6d, 0x6a, 0xa5, 0x79, 0x15, 0xa3, 0x09, 0x9a,
-	0x46, 0x64, 0x07, 0xf1, 0x08, 0x7c, 0x2d, 0xcb, 0x9c, 0xc5, 0x27, 0xb6, 0xee, 0x00, 0xbe, 0x0f,
-	0x91, 0xe0, 0xcd, 0xe2, 0x0b, 0xdd, 0xd4, 0x3c, 0xf6, 0x26, 0x68, 0x3a, 0x20, 0x3d, 0xc1, 0x9b,
-	0x8f, 0x06, 0xe3, 0x73, 0x18, 0x1a, 0xb2, 0x2e, 0x32, 0xbe, 0xca, 0x0b, 0x9e, 0xc5, 0xdd, 0x09,
-	0x9a, 0xf6, 0xc8, 0x40, 0xf0, 0xe6, 0xc3, 0xae, 0x66, 0xbe, 0xb8, 0xe5, 0x4a, 0xd1, 0x35, 0x8f,
-	0x7d, 0x7b, 0x7e, 0x07, 0xf1, 0x19, 0x00, 0x55, 0x4d, 0xc1, 0x16, 0x5b, 0x99, 0xf1, 0x38, 0xb0,
-	0x67, 0x23, 0x5b, 0x79, 0x23, 0x33, 0x9e, 0x3c, 0x6f, 0x45, 0x2b, 0xfc, 0x00, 0xa2, 0x92, 0x56,
-	0x3a, 0xd7, 0xb9, 0x2c, 0xac, 0x6c, 0x9f, 0x1c, 0x0a, 0xf8, 0x0e, 0x04, 0x72, 0xb5, 0x52, 0x5c,
-	0x5b, 0xe5, 0x1e, 0x69, 0x51, 0xf2, 0x0b, 0x01, 0xbc, 0x94, 0x85, 0x7a, 0x3b, 0x63, 0xe2, 0x0a,
-	0xce, 0x47, 0xe0, 0xaf, 0x2b, 0x59, 0x97, 0xd6, 0x75, 0x44, 0x1c, 0xc0, 0xb7, 0x21, 0x28, 0xe4,
-	0x82, 0x32, 0xd1, 0x7a, 0xf5, 0x0b, 0x39, 0x63, 0x02, 0xdf, 0x83, 0x1e, 0xad, 0xb5, 0x23, 0x7c,
-	0x4b, 0x84, 0x06, 0x1b, 0xea, 0x1c, 0x86, 0x94, 0x89, 0xc5, 0xc1, 0x40, 0x60, 0x0d, 0x0c, 0x28,
-	0x13, 0xf3, 0xbd, 0x07, 0x13, 0x05, 0x13, 0x8b, 0xd6, 0x47, 0x68, 0x7d, 0x44, 0x94, 0x89, 0x77,
-	0xce, 0xca, 0x0f, 0x04, 0x81, 0xb1, 0x72, 0xd5, 0x2c, 0x6e, 0xb2, 0x8d, 0xc9, 0x37, 0x04, 0xfe,
-	0x75, 0x46, 0x7c, 0xe4, 0xb0, 0x7b, 0xb9, 0x43, 0xff, 0xa8, 0xdb, 0xa1, 0x13, 0xa1, 0x92, 0xdf,
-	0x08, 0x6e, 0xed, 0x83, 0x75, 0xf9, 0xfd, 0x27, 0xb4, 0x11, 0xf8, 0x4b, 0xbe, 0xce, 0x8b, 0x36,
-	0x33, 0x07, 0xf0, 0x29, 0x78, 0xbc, 0xc8, 0xac, 0x34, 0x8f, 0x98, 0xa5, 0xd9, 0xc7, 0x64, 0x5d,
-	0x68, 0x2b, 0xca, 0x23, 0x0e, 0x5c, 0x26, 0xc8, 0x9c, 0xdf, 0xd0, 0xb5, 0xed, 0xb6, 0x47, 0xcc,
-	0x12, 0x8f, 0xa1, 0xb7, 0xe5, 0x9a, 0x66, 0x54, 0x53, 0xdb, 0xe2, 0x88, 0xec, 0x31, 0x7e, 0x08,
-	0x7d, 0x55, 0xd2, 0x4a, 0x71, 0x73, 0x85, 0x54, 0xdc, 0xb3, 0x34, 0xb8, 0xd2, 0x8c, 0x09, 0x95,
-	0xbc, 0x87, 0xc1, 0x2b, 0xae, 0x9d, 0x1f, 0x75, 0x5d, 0x59, 0x27, 0xcf, 0x8e, 0xfe, 0x55, 0xe1,
-	0xc7, 0x10, 0x3a, 0xf9, 0x2a, 0x46, 0x13, 0x6f, 0xda, 0xbf, 0x38, 0x4d, 0xff, 0xc9, 0x92, 0xec,
-	0x36, 0x5c, 0x7c, 0x47, 0x10, 0xbd, 0xa6, 0x2b, 0x41, 0xe7, 0xf9, 0xd7, 0x06, 0x9f, 0x41, 0x68,
-	0xa6, 0xb5, 0x66, 0x1c, 0x87, 0xa9, 0x7b, 0x6c, 0xc6, 0xed, 0x42, 0x25, 0x1d, 0xfc, 0x08, 0xfa,
-	0xe6, 0x02, 0xd7, 0x5b, 0x6e, 0xc6, 0x11, 0xf7, 0xd3, 0xc3, 0x64, 0x8e, 0xc3, 0xd4, 0xdd, 0xed,
-	0xa4, 0x83, 0xef, 0x82, 0x67, 0xe8, 0x20, 0x75, 0x8c, 0xfb, 0x35, 0xc4, 0x13, 0x80, 0x83, 0x50,
-	0x3c, 0x4c, 0xff, 0xce, 0x62, 0x7c, 0x04, 0x55, 0xd2, 0x79, 0xd1, 0xfd, 0x74, 0x52, 0x2e, 0x97,
-	0x81, 0x7d, 0xfd, 0x9e, 0xfe, 0x09, 0x00, 0x00, 0xff, 0xff, 0xe8, 0x12, 0x35, 0x0f, 0x0b, 0x05,
-	0x00, 0x00,
+	// 1319 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xc4, 0x57, 0x5f, 0x8f, 0xdb, 0x44,
+	0x10, 0x4f, 0xe2, 0x38, 0x8e, 0x27, 0xc9, 0x35, 0x5d, 0x0e, 0x30, 0xa1, 0x07, 0xd7, 0xad, 0x2a,
+	0x4e, 0x50, 0x0c, 0xba, 0x16, 0x1e, 0x78, 0x4b, 0x7b, 0xe9, 0x51, 0xf5, 0x7a, 0x3d, 0xdc, 0xb4,
+	0x85, 0xbe, 0x58, 0x7b, 0xf6, 0x26, 0x67, 0xf9, 0x6f, 0xbd, 0x4e, 0x7b, 0x27, 0xf1, 0x80, 0x84,
+	0x10, 0x6f, 0x7c, 0x0c, 0xbe, 0x08, 0x5f, 0x81, 0x17, 0x24, 0x3e, 0x0c, 0xda, 0x3f, 0x8e, 0x9d,
+	0x94, 0x2b, 0xd2, 0xa9, 0xa8, 0x4f, 0xd9, 0xdf, 0xcc, 0xec, 0xee, 0xcc, 0xfc, 0x66, 0xc6, 0x1b,
+	0x80, 0x79, 0x9e, 0x79, 0x76, 0x96, 0xa7, 0x45, 0x8a, 0x7f, 0xd2, 0xa0, 0x73, 0x94, 0xa7, 0xbe,
+	0xf3, 0x1c, 0x59, 0x60, 0x78, 0xd1, 0x82, 0x15, 0x34, 0xb7, 0x9a, 0xdb, 0xcd, 0x1d, 0xd3, 0x29,
+	0x21, 0xda, 0x04, 0xbd, 0x48, 0xb3, 0xc0, 0xb3, 0x5a, 0x42, 0x2e, 0x01, 0xfa, 0x10, 0xcc, 0x90,
+	0x9e, 0xb9, 0x2f, 0x48, 0xb4, 0xa0, 0x96, 0xb6, 0xdd, 0xdc, 0xe9, 0x3b, 0xdd, 0x90, 0x9e, 0x3d,
+	0xe1, 0x18, 0x5d, 0x83, 0x01, 0x57, 0x2e, 0x12, 0x9f, 0xce, 0x82, 0x84, 0xfa, 0x56, 0x7b, 0xbb,
+	0xb9, 0xd3, 0x75, 0xfa, 0x21, 0x3d, 0x7b, 0x5c, 0xca, 0xf8, 0x8d, 0x31, 0x65, 0x8c, 0xcc, 0xa9,
+	0xa5, 0x8b, 0xfd, 0x25, 0x44, 0x5b, 0x00, 0x84, 0x9d, 0x25, 0x9e, 0x1b, 0xa7, 0x3e, 0xb5, 0x3a,
+	0x62, 0xaf, 0x29, 0x24, 0x0f, 0x52, 0x5f, 0xa8, 0x7d, 0x1a, 0x05, 0x2f, 0x68, 0xee, 0x92, 0xc2,
+	0x32, 0x84, 0x57, 0xa6, 0x92, 0x8c, 0x0b, 0x84, 0xa0, 0x4d, 0xbc, 0x90, 0x59, 0x5d, 0xa1, 0x10,
+	0x6b, 0xf4, 0x19, 0x5c, 0x56, 0x87, 0xd7, 0x9c, 0x32, 0xc5, 0xc1, 0x43, 0xa5, 0xa8, 0x1c, 0xbb,
+	0x0a, 0x7d, 0x8f, 0x44, 0xd1, 0x31, 0xf1, 0x42, 0x77, 0x91, 0x47, 0x16, 0x88, 0x83, 0x7a, 0xa5,
+	0xec, 0x71, 0x1e, 0x71, 0x93, 0x9c, 0xb2, 0x45, 0x54, 0xb8, 0x32, 0x35, 0x3d, 0x69, 0x22, 0x65,
+	0x53, 0x91, 0xa0, 0xeb, 0xb0, 0xe1, 0xa5, 0x79, 0x4e, 0x23, 0x52, 0x04, 0x69, 0xe2, 0x06, 0xbe,
+	0xd5, 0x17, 0x46, 0x83, 0x9a, 0xf4, 0x9e, 0x8f, 0x1d, 0xc5, 0x00, 0x43, 0x57, 0xc0, 0xcc, 0x48,
+	0x5e, 0x04, 0x5c, 0x21, 0x38, 0xd0, 0x9d, 0x4a, 0x80, 0xde, 0x83, 0x4e, 0x3a, 0x9b, 0x31, 0x5a,
+	0x08, 0x1a, 0x34, 0x47, 0xa1, 0x65, 0xb4, 0x5a, 0x15, 0x2d, 0xfe, 0xad, 0x05, 0x70, 0x27, 0x4d,
+	0xd8, 0xe1, 0xd8, 0x0b, 0x2f, 0x40, 0xed, 0x26, 0xe8, 0xf3, 0x3c, 0x5d, 0x64, 0xea, 0x4c, 0x09,
+	0xd0, 0xbb, 0xd0, 0x49, 0x52, 0x97, 0x78, 0xa1, 0x22, 0x53, 0x4f, 0xd2, 0xb1, 0x17, 0xa2, 0x0f,
+	0xa0, 0x4b, 0x16, 0x85, 0x54, 0xe8, 0x42, 0x61, 0x70, 0xcc, 0x55, 0xd7, 0x60, 0xc0, 0x53, 0x58,
+	0x05, 0xd5, 0x11, 0x41, 0xf5, 0x89, 0x17, 0x1e, 0x2d, 0xe3, 0xe2, 0x5c, 0x7b, 0xa1, 0xab, 0x62,
+	0x33, 0x44, 0x6c, 0x26, 0xf1, 0xc2, 0x87, 0x32, 0xbc, 0xab, 0xd0, 0x97, 0x2a, 0x37, 0xa7, 0xdc,
+	0x40, 0x92, 0xda, 0x93, 0x32, 0x87, 0x2a, 0x93, 0x98, 0x9c, 0xba, 0x8a, 0x46, 0x26, 0x68, 0xd5,
+	0x9d, 0x5e, 0x4c, 0x4e, 0x1f, 0x28, 0x11, 0xfe, 0xab, 0x05, 0x1d, 0x9e, 0x90, 0x0b, 0x67, 0xf9,
+	0xff, 0xac, 0xf6, 0xeb, 0x60, 0xce, 0xd2, 0x28, 0x4a, 0x5f, 0x06, 0xc9, 0xdc, 0xea, 0x6c, 0x6b,
+	0x3b, 0xbd, 0x5d, 0xc3, 0x96, 0xde, 0x3a, 0x95, 0x86, 0xd7, 0xd3, 0x49, 0x30, 0x3f, 0x71, 0x5f,
+	0x92, 0x82, 0xe6, 0x31, 0xc9, 0x43, 0x95, 0xac, 0x01, 0x97, 0x3e, 0x2d, 0x85, 0x68, 0x08, 0x5a,
+	0x44, 0xe6, 0x22, 0x4f, 0x9a, 0xc3, 0x97, 0x3c, 0xa6, 0x9c, 0x66, 0x11, 0x39, 0x53, 0x05, 0xaf,
+	0xd0, 0xbf, 0xf7, 0x04, 0x9c, 0xd3, 0x13, 0x16, 0x18, 0x2c, 0x0c, 0xb2, 0x8c, 0xfa, 0xa2, 0xd6,
+	0x35, 0xa7, 0x84, 0xf8, 0xe7, 0x26, 0xe8, 0x6f, 0xb2, 0xce, 0x56, 0x08, 0x6a, 0x9f, 0x4f, 0x90,
+	0x5e, 0x27, 0x08, 0x1b, 0xd2, 0x09, 0x86, 0x7f, 0x6d, 0x41, 0xe7, 0x90, 0xbc, 0x6d, 0x7f, 0xd0,
+	0x4d, 0xe8, 0xd1, 0x3c, 0x4f, 0x73, 0xd7, 0x8b, 0x08, 0x63, 0xa2, 0xf2, 0x37, 0x76, 0x91, 0x2d,
+	0x3d, 0xb3, 0x27, 0x5c, 0x75, 0x87, 0x6b, 0x1c, 0xa0, 0xcb, 0xb5, 0x64, 0x8a, 0xb0, 0x34, 0x51,
+	0x43, 0x4d, 0x21, 0x7c, 0x0b, 0xa0, 0xda, 0x81, 0x06, 0x60, 0x3a, 0x93, 0xa9, 0xf3, 0xc3, 0xf8,
+	0xf6, 0xc1, 0x64, 0xd8, 0x40, 0x26, 0xe8, 0x77, 0xc7, 0xd3, 0xf1, 0xc1, 0xb0, 0x89, 0xfa, 0xd0,
+	0x9d, 0x7e, 0xeb, 0x3c, 0x9c, 0x4e, 0x0f, 0x26, 0xc3, 0x16, 0xee, 0xaa, 0x44, 0x30, 0xfc, 0x67,
+	0x13, 0x2e, 0x2d, 0x3b, 0x4e, 0x35, 0xd6, 0xeb, 0xfb, 0x60, 0x13, 0xf4, 0x63, 0x3a, 0x0f, 0x12,
+	0xd5, 0x06, 0x12, 0xf0, 0xda, 0xa2, 0x89, 0x2f, 0xd2, 0xa3, 0x39, 0x7c, 0xc9, 0xed, 0xbc, 0x74,
+	0x91, 0x14, 0x22, 0x31, 0x9a, 0x23, 0xc1, 0xb9, 0x49, 0x51, 0xb5, 0xd9, 0xa9, 0x6a, 0x73, 0x04,
+	0xdd, 0x98, 0x16, 0xc4, 0x27, 0x05, 0x51, 0x31, 0x2f, 0x31, 0xfa, 0x18, 0x7a, 0x2c, 0x23, 0x39,
+	0xa3, 0x6e, 0x6d, 0x9c, 0x83, 0x14, 0x8d, 0xf9, 0x98, 0x9b, 0x42, 0x7f, 0x9f, 0x16, 0x32, 0x1e,
+	0xf6, 0xa6, 0xf8, 0xc6, 0xdf, 0xac, 0x9c, 0xca, 0xd0, 0xa7, 0x60, 0x48, 0xf7, 0x99, 0xd5, 0x14,
+	0xcd, 0x39, 0xb4, 0xd7, 0x72, 0xe9, 0x94, 0x06, 0xf8, 0xf7, 0x26, 0xf4, 0xef, 0x9c, 0x50, 0x2f,
+	0xcc, 0xd2, 0x20, 0x29, 0xde, 0x72, 0x09, 0xd6, 0x73, 0xdb, 0x59, 0xcd, 0x2d, 0xde, 0x58, 0xf1,
+	0x93, 0xe1, 0x1f, 0x01, 0x2a, 0x7c, 0xc1, 0x19, 0x59, 0xbf, 0x4f, 0x5b, 0xe3, 0xf2, 0x0a, 0x98,
+	0x5e, 0x1a, 0xc7, 0x41, 0x51, 0xa8, 0xf1, 0xa8, 0x39, 0x95, 0x00, 0x8f, 0x61, 0xb8, 0x4f, 0x8b,
+	0xca, 0x01, 0x9e, 0xf6, 0xcf, 0xa1, 0xe7, 0x55, 0x02, 0x95, 0xfa, 0x9e, 0x5d, 0xf3, 0xba, 0xae,
+	0xc7, 0xb7, 0xc1, 0xb8, 0x1b, 0x2d, 0xd8, 0xc9, 0x6b, 0x73, 0xbe, 0x05, 0x50, 0x04, 0x31, 0x4d,
+	0x17, 0x85, 0x1b, 0x33, 0xe5, 0xbd, 0xa9, 0x24, 0x0f, 0x18, 0xfe, 0xae, 0x3c, 0x83, 0x71, 0x1e,
+	0x88, 0x17, 0x52, 0x5f, 0x9c, 0xa0, 0x39, 0x12, 0xf0, 0xc8, 0x67, 0x24, 0x88, 0xa8, 0x5f, 0x46,
+	0x2e, 0x11, 0xbf, 0x31, 0xa3, 0x89, 0xcf, 0xe7, 0xb7, 0xec, 0x8d, 0x12, 0xe2, 0x67, 0x80, 0x9e,
+	0x92, 0xc2, 0x3b, 0xd9, 0xe7, 0x3c, 0x4e, 0x5e, 0xd0, 0xe4, 0xbf, 0x0b, 0x55, 0xf2, 0xdf, 0xaa,
+	0xf3, 0xbf, 0x09, 0x3a, 0x0b, 0x12, 0x8f, 0xaa, 0xd3, 0x25, 0xc0, 0x7f, 0x34, 0xc1, 0x50, 0xe7,
+	0xf2, 0xce, 0x62, 0xf4, 0xb9, 0xf2, 0x96, 0x2f, 0xd1, 0x55, 0x68, 0x87, 0x41, 0x22, 0x3d, 0xdd,
+	0xd8, 0x1d, 0xd8, 0xca, 0xd2, 0xbe, 0x1f, 0x24, 0xbe, 0x23, 0x54, 0x55, 0x09, 0x6a, 0xf5, 0x12,
+	0xfc, 0x08, 0x60, 0xc9, 0x35, 0xb3, 0xda, 0xdb, 0xda, 0x8e, 0xee, 0xd4, 0x24, 0x9c, 0x4a, 0x9e,
+	0x32, 0x56, 0x90, 0x38, 0x53, 0x15, 0x57, 0x09, 0xf0, 0x17, 0xd0, 0xe6, 0x37, 0xf0, 0x51, 0x34,
+	0x7e, 0xf4, 0xe8, 0xde, 0xfe, 0xe1, 0x64, 0x6f, 0xd8, 0x40, 0x3d, 0x30, 0x9c, 0xc9, 0x93, 0x87,
+	0xf7, 0x27, 0x7b, 0x72, 0x4a, 0x4d, 0xbe, 0x3f, 0x1a, 0x1f, 0xee, 0x4d, 0xf6, 0x86, 0x2d, 0xfc,
+	0x4b, 0x13, 0x2e, 0x1d, 0x04, 0xac, 0xe0, 0x1f, 0xbc, 0x45, 0x4c, 0xf3, 0x8b, 0x34, 0x32, 0xe7,
+	0x25, 0x88, 0xb8, 0xb9, 0x8c, 0x44, 0x21, 0xc1, 0xe2, 0x8c, 0x8b, 0xdb, 0xd2, 0x9a, 0xcc, 0x94,
+	0x34, 0x0a, 0xe2, 0x40, 0xb6, 0x8b, 0xee, 0x48, 0x80, 0x29, 0x6c, 0x88, 0x14, 0x2d, 0xfd, 0xa8,
+	0xb8, 0x68, 0xd6, 0xb9, 0xf8, 0x84, 0x57, 0xb2, 0x32, 0xb1, 0x5a, 0xa2, 0x2a, 0x4d, 0xbb, 0xdc,
+	0xe4, 0x98, 0x5e, 0x7d, 0xbb, 0x18, 0xed, 0x65, 0x76, 0x05, 0xc0, 0xfb, 0xd0, 0x2d, 0x8d, 0xf9,
+	0xa3, 0xc2, 0x8b, 0x02, 0x9a, 0x14, 0xfc, 0x71, 0x28, 0x2f, 0xe9, 0x4a, 0xc1, 0x3d, 0x7f, 0x8d,
+	0x86, 0xd6, 0x3a, 0x0d, 0xbb, 0x7f, 0x6b, 0x60, 0xde, 0x27, 0xb3, 0x90, 0x1c, 0x05, 0xa7, 0x67,
+	0x68, 0x0b, 0x0c, 0xfe, 0x8a, 0x5c, 0x78, 0x14, 0x19, 0xb6, 0x7c, 0xd1, 0x8f, 0xd4, 0x82, 0xe1,
+	0x06, 0xba, 0x0e, 0x3d, 0x75, 0x2b, 0x7f, 0x12, 0xa2, 0x9e, 0x5d, 0xbd, 0x0e, 0x47, 0xe5, 0x5b,
+	0x03, 0x37, 0xd0, 0xfb, 0xa0, 0x71, 0x75, 0xc7, 0x96, 0x1a, 0xf9, 0xcb, 0x15, 0x23, 0x68, 0xf3,
+	0x4f, 0x09, 0x32, 0xd4, 0x07, 0x6c, 0xa4, 0x16, 0x5c, 0x77, 0x03, 0xa0, 0x9a, 0x97, 0x68, 0x60,
+	0xd7, 0x47, 0xf2, 0x68, 0x05, 0x72, 0xeb, 0xaf, 0x60, 0xb8, 0xde, 0x10, 0xe8, 0x1d, 0xfb, 0xd5,
+	0x1e, 0x19, 0x75, 0xcb, 0x8a, 0xc5, 0x8d, 0x2f, 0x9b, 0xe8, 0x16, 0x0c, 0x1e, 0x15, 0x39, 0x25,
+	0xf1, 0x39, 0xf7, 0xbc, 0x32, 0x93, 0xc5, 0xae, 0xaf, 0x61, 0xb0, 0x52, 0x5a, 0x68, 0x68, 0xaf,
+	0x95, 0xda, 0xe8, 0x92, 0xbd, 0xca, 0xba, 0xd8, 0x77, 0x63, 0x65, 0x1a, 0x0e, 0xea, 0x43, 0xe7,
+	0xf9, 0x68, 0x05, 0xf2, 0x90, 0x6e, 0xc1, 0xc6, 0xea, 0xf4, 0x5a, 0x77, 0xee, 0xb2, 0xbd, 0x3e,
+	0xdd, 0x70, 0x03, 0x6d, 0x81, 0x2e, 0x86, 0x0d, 0xea, 0xda, 0x6a, 0x70, 0x8d, 0xca, 0x15, 0xc3,
+	0x8d, 0xdb, 0xed, 0x67, 0xad, 0xec, 0xf8, 0xb8, 0x23, 0xfe, 0xa6, 0xdd, 0xfc, 0x67, 0x00, 0x9c,
+	0x5c, 0x9b, 0x4c, 0xb4, 0x0d, 0x00, 0x00,
 }
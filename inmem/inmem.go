@@ -0,0 +1,768 @@
+// Package inmem implements an in-memory simulation of a Kafka cluster that
+// can be used instead of a real Kafka/ZooKeeper cluster to run integration
+// tests of Kafka-Pixy applications. It provides producer, consumer and admin
+// operations with the same semantics that the real implementations have, but
+// all data is kept in memory and lost when the instance is stopped.
+//
+// Partition assignment is deterministic: keyed messages are distributed using
+// the partitioner that the real producer is configured with, keyless messages
+// are distributed in round-robin fashion, and all partitions of a topic are
+// consumed by the only member of every consumer group.
+package inmem
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/keyqueue"
+	"github.com/mailgun/kafka-pixy/consumer/offsettrac"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/pkg/errors"
+)
+
+// T is an in-memory Kafka cluster. It implements the same set of methods as
+// producer.T, consumer.T, and admin.T do.
+type T struct {
+	actorID *actor.ID
+	cfg     *config.Proxy
+
+	mu         sync.Mutex
+	topics     map[string]*topic
+	groups     map[groupTopic]*groupState
+	offsets    map[groupTopicPartition]offsetmgr.Offset
+	quotas     map[admin.QuotaEntity]map[string]float64
+	brokerCfg  map[string]string
+	producedCh chan none.T
+	stopCh     chan none.T
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+type topic struct {
+	partitions [][]record
+	nextRR     int32
+}
+
+type record struct {
+	key       []byte
+	value     []byte
+	timestamp time.Time
+}
+
+type groupTopic struct {
+	group string
+	topic string
+}
+
+type groupTopicPartition struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// groupState represents a consumer group member that consumes all partitions
+// of a particular topic.
+type groupState struct {
+	partitions []*partitionState
+	nextRR     int
+}
+
+type partitionState struct {
+	actorID  *actor.ID
+	ot       *offsettrac.T
+	kq       *keyqueue.T
+	next     int64
+	eventsCh chan consumer.Event
+
+	// Messages released by the key queue, to be offered before new ones.
+	released []consumer.Message
+
+	// Throttle nacks hold the partition back from being offered until then.
+	throttledUntil time.Time
+	throttleNo     int
+}
+
+// Spawn creates an in-memory Kafka cluster instance. Topics listed in the
+// `in_memory.topics` config section are created right away, all other topics
+// are created on first use.
+func Spawn(namespace *actor.ID, cfg *config.Proxy) *T {
+	im := &T{
+		actorID:    namespace.NewChild("inmem"),
+		cfg:        cfg,
+		topics:     make(map[string]*topic),
+		groups:     make(map[groupTopic]*groupState),
+		offsets:    make(map[groupTopicPartition]offsetmgr.Offset),
+		quotas:     make(map[admin.QuotaEntity]map[string]float64),
+		brokerCfg:  make(map[string]string),
+		producedCh: make(chan none.T),
+		stopCh:     make(chan none.T),
+	}
+	for name, partitions := range cfg.InMemory.Topics {
+		im.topics[name] = &topic{partitions: make([][]record, partitions)}
+	}
+	return im
+}
+
+// Stop terminates internal goroutines. All offsets acknowledged before the
+// call are committed by the time it returns.
+func (im *T) Stop() {
+	im.stopOnce.Do(func() {
+		close(im.stopCh)
+		im.wg.Wait()
+	})
+}
+
+// Produce appends a message to a partition of the specified topic. If key is
+// nil then the partition is selected in round-robin fashion, otherwise the key
+// hash defines the partition.
+func (im *T) Produce(topic string, key, message sarama.Encoder) (*sarama.ProducerMessage, error) {
+	prodMsg := &sarama.ProducerMessage{Topic: topic, Key: key, Value: message}
+	var rec record
+	var err error
+	if key != nil {
+		if rec.key, err = key.Encode(); err != nil {
+			return prodMsg, errors.Wrap(err, "failed to encode key")
+		}
+	}
+	if message != nil {
+		if rec.value, err = message.Encode(); err != nil {
+			return prodMsg, errors.Wrap(err, "failed to encode message")
+		}
+	}
+	rec.timestamp = time.Now()
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	t := im.getTopic(topic)
+	partitionCount := int32(len(t.partitions))
+	if key == nil {
+		prodMsg.Partition = t.nextRR
+		t.nextRR = (t.nextRR + 1) % partitionCount
+	} else {
+		partitioner := im.cfg.ProducerPartitioner()(topic)
+		if prodMsg.Partition, err = partitioner.Partition(prodMsg, partitionCount); err != nil {
+			return prodMsg, errors.Wrap(err, "failed to select partition")
+		}
+	}
+	prodMsg.Offset = int64(len(t.partitions[prodMsg.Partition]))
+	t.partitions[prodMsg.Partition] = append(t.partitions[prodMsg.Partition], rec)
+
+	// Wake up all consumers waiting for new messages.
+	im.wakeUp()
+	return prodMsg, nil
+}
+
+// AsyncProduce is an asynchronous counterpart of the `Produce` function. The
+// message is stored before the call returns, errors are silently ignored.
+func (im *T) AsyncProduce(topic string, key, message sarama.Encoder) {
+	im.Produce(topic, key, message)
+}
+
+// AsyncProduceWithCallback is like AsyncProduce, except `callback` is called
+// with the outcome before the call returns.
+func (im *T) AsyncProduceWithCallback(topic string, key, message sarama.Encoder,
+	callback func(msg *sarama.ProducerMessage, err error),
+) {
+	callback(im.Produce(topic, key, message))
+}
+
+// Consume implements consumer.T. Messages that have not been acknowledged
+// within `consumer.ack_timeout` are offered again.
+func (im *T) Consume(group, topic string) (consumer.Message, error) {
+	return im.ConsumeWithOpts(group, topic, consumer.ConsumeOpts{})
+}
+
+// ConsumeWithOpts implements consumer.T.
+func (im *T) ConsumeWithOpts(group, topic string, opts consumer.ConsumeOpts) (consumer.Message, error) {
+	timeoutCh := time.After(im.cfg.Consumer.LongPollingTimeout)
+	for {
+		im.mu.Lock()
+		msg, ok := im.nextMessage(group, topic, opts)
+		producedCh := im.producedCh
+		im.mu.Unlock()
+		if ok {
+			return msg, nil
+		}
+		select {
+		case <-producedCh:
+		case <-time.After(im.cfg.Consumer.AckTimeout):
+			// Offered messages might have to be retried by now.
+		case <-timeoutCh:
+			return consumer.Message{}, consumer.ErrRequestTimeout
+		case <-im.stopCh:
+			return consumer.Message{}, consumer.ErrRequestTimeout
+		}
+	}
+}
+
+// GetGroupOffsets implements admin.T.
+func (im *T) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	t, ok := im.topics[topic]
+	if !ok {
+		return nil, errors.Wrap(sarama.ErrUnknownTopicOrPartition, "failed to get topic partitions")
+	}
+	offsets := make([]admin.PartitionOffset, len(t.partitions))
+	for i, records := range t.partitions {
+		partition := int32(i)
+		offsets[i] = admin.PartitionOffset{
+			Partition: partition,
+			End:       int64(len(records)),
+			Offset:    sarama.OffsetNewest,
+		}
+		if offset, ok := im.offsets[groupTopicPartition{group, topic, partition}]; ok {
+			offsets[i].Offset = offset.Val
+			offsets[i].Metadata = offset.Meta
+		}
+	}
+	return offsets, nil
+}
+
+// SetGroupOffsets implements admin.T. Messages offered to the group before
+// the call are forgotten, and consumption resumes from the new offsets.
+func (im *T) SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	t := im.getTopic(topic)
+	for _, po := range offsets {
+		if po.Partition < 0 || int(po.Partition) >= len(t.partitions) {
+			return errors.Wrapf(sarama.ErrUnknownTopicOrPartition,
+				"failed to commit offset, partition=%d", po.Partition)
+		}
+	}
+	gs := im.groups[groupTopic{group, topic}]
+	for _, po := range offsets {
+		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
+		im.offsets[groupTopicPartition{group, topic, po.Partition}] = offset
+		if gs != nil {
+			ps := gs.partitions[po.Partition]
+			ps.ot = im.newOffsetTracker(ps.actorID, topic, offset)
+			ps.kq = im.newKeyQueue(topic)
+			ps.next = offset.Val
+			ps.released = nil
+		}
+	}
+	return nil
+}
+
+// OffsetForTime implements admin.T.
+func (im *T) OffsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	records, err := im.partitionRecords(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	return resetOffset(records, consumer.OffsetReset{Time: t}), nil
+}
+
+// PartitionOffsets implements admin.T.
+func (im *T) PartitionOffsets(topic string, partition int32) (int64, int64, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	records, err := im.partitionRecords(topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	return 0, int64(len(records)), nil
+}
+
+// TopicOffsets implements admin.T.
+func (im *T) TopicOffsets(topic string) ([]admin.PartitionOffset, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	t, ok := im.topics[topic]
+	if !ok {
+		return nil, errors.Wrap(sarama.ErrUnknownTopicOrPartition, "failed to get topic partitions")
+	}
+	offsets := make([]admin.PartitionOffset, len(t.partitions))
+	for i, records := range t.partitions {
+		offsets[i] = admin.PartitionOffset{Partition: int32(i), End: int64(len(records))}
+	}
+	return offsets, nil
+}
+
+// ReadMessages implements admin.T.
+func (im *T) ReadMessages(topic string, partition int32, offset int64, count int) ([]consumer.Message, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	records, err := im.partitionRecords(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	var messages []consumer.Message
+	for ; offset < int64(len(records)) && len(messages) < count; offset++ {
+		messages = append(messages, consumer.Message{
+			Topic:         topic,
+			Partition:     partition,
+			Offset:        offset,
+			Key:           records[offset].key,
+			Value:         records[offset].value,
+			Timestamp:     records[offset].timestamp,
+			HighWaterMark: int64(len(records)),
+		})
+	}
+	return messages, nil
+}
+
+// partitionRecords returns records of a topic partition. It must be called
+// under the lock.
+func (im *T) partitionRecords(topic string, partition int32) ([]record, error) {
+	t, ok := im.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(t.partitions) {
+		return nil, errors.Wrapf(sarama.ErrUnknownTopicOrPartition, "partition=%d", partition)
+	}
+	return t.partitions[partition], nil
+}
+
+// GetTopicConsumers implements admin.T. A group that has ever consumed from
+// a topic is reported to consume all its partitions.
+func (im *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	gs, ok := im.groups[groupTopic{group, topic}]
+	if !ok {
+		return nil, admin.ErrInvalidParam(errors.New("either group or topic is incorrect"))
+	}
+	partitions := make([]int32, len(gs.partitions))
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return map[string][]int32{im.cfg.ClientID: partitions}, nil
+}
+
+// GetAllTopicConsumers implements admin.T.
+func (im *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error) {
+	consumers, _, err := im.GetTopicConsumersPage(topic, admin.Page{})
+	return consumers, err
+}
+
+// GetTopicConsumersPage implements admin.T.
+func (im *T) GetTopicConsumersPage(topic string, pg admin.Page) (map[string]map[string][]int32, bool, error) {
+	consumers := make(map[string]map[string][]int32)
+	more, err := im.ScanTopicConsumers(topic, pg, func(group string, groupConsumers map[string][]int32, err error) {
+		consumers[group] = groupConsumers
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return consumers, more, nil
+}
+
+// ScanTopicConsumers implements admin.T.
+func (im *T) ScanTopicConsumers(topic string, pg admin.Page, fn func(group string, consumers map[string][]int32, err error)) (bool, error) {
+	im.mu.Lock()
+	var groups []string
+	for gt := range im.groups {
+		if gt.topic == topic {
+			groups = append(groups, gt.group)
+		}
+	}
+	im.mu.Unlock()
+	sort.Strings(groups)
+	groups, more := pg.Select(groups)
+
+	for _, group := range groups {
+		groupConsumers, err := im.GetTopicConsumers(group, topic)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to fetch group `%s` data", group)
+		}
+		fn(group, groupConsumers, nil)
+	}
+	return more, nil
+}
+
+// RebalanceGroup implements admin.T. There is nobody to rebalance with in
+// memory mode.
+func (im *T) RebalanceGroup(group string) error {
+	return errors.New("not supported in in-memory mode")
+}
+
+// EvictGroupMember implements admin.T.
+func (im *T) EvictGroupMember(group, memberID string) error {
+	return errors.New("not supported in in-memory mode")
+}
+
+// ListGroups implements admin.T.
+func (im *T) ListGroups() ([]string, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	seen := make(map[string]bool)
+	groups := []string{}
+	for gt := range im.groups {
+		if !seen[gt.group] {
+			seen[gt.group] = true
+			groups = append(groups, gt.group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// ListTopics implements admin.T.
+func (im *T) ListTopics() ([]string, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	topics := make([]string, 0, len(im.topics))
+	for name := range im.topics {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// DescribeClientQuotas implements admin.T.
+func (im *T) DescribeClientQuotas() ([]admin.ClientQuotas, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	quotas := make([]admin.ClientQuotas, 0, len(im.quotas))
+	for entity, values := range im.quotas {
+		quotas = append(quotas, admin.ClientQuotas{Entity: entity, Values: admin.AlterQuotas(values, nil)})
+	}
+	admin.SortClientQuotas(quotas)
+	return quotas, nil
+}
+
+// AlterClientQuotas implements admin.T. Quotas are kept but not enforced.
+func (im *T) AlterClientQuotas(entity admin.QuotaEntity, alter map[string]*float64) error {
+	if err := admin.CheckQuotaAlteration(entity, alter); err != nil {
+		return err
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	values := admin.AlterQuotas(im.quotas[entity], alter)
+	if len(values) == 0 {
+		delete(im.quotas, entity)
+		return nil
+	}
+	im.quotas[entity] = values
+	return nil
+}
+
+// DescribeBrokerConfigs implements admin.T. The cluster has the only broker
+// with ID 0.
+func (im *T) DescribeBrokerConfigs(brokerID int32) ([]admin.BrokerConfig, error) {
+	if brokerID != 0 {
+		return nil, errors.Wrapf(admin.ErrBrokerNotFound, "broker=%d", brokerID)
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	configs := make([]admin.BrokerConfig, 0, len(im.brokerCfg))
+	for name, value := range im.brokerCfg {
+		configs = append(configs, admin.NewBrokerConfig(name, value, admin.ConfigSourceDynamic))
+	}
+	admin.SortBrokerConfigs(configs)
+	return configs, nil
+}
+
+// AlterBrokerConfigs implements admin.T. Configs are kept but have no effect.
+func (im *T) AlterBrokerConfigs(brokerID int32, alter map[string]*string) error {
+	if err := admin.CheckBrokerConfigAlteration(alter); err != nil {
+		return err
+	}
+	if brokerID != 0 {
+		return errors.Wrapf(admin.ErrBrokerNotFound, "broker=%d", brokerID)
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for name, value := range alter {
+		if value == nil {
+			delete(im.brokerCfg, name)
+			continue
+		}
+		im.brokerCfg[name] = *value
+	}
+	return nil
+}
+
+// CreateTopic implements admin.T. Replication factor is ignored since there
+// is nothing to replicate in memory.
+func (im *T) CreateTopic(name string, partitions, replicationFactor int) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.topics[name]; ok {
+		return admin.ErrTopicExists
+	}
+	im.topics[name] = &topic{partitions: make([][]record, partitions)}
+	return nil
+}
+
+// MetadataStats implements producer.T. There is no Kafka metadata to cache
+// in memory mode.
+func (im *T) MetadataStats() producer.MetadataStats {
+	return producer.MetadataStats{}
+}
+
+// InvalidateMetadata implements producer.T. There is no Kafka metadata to
+// refresh in memory mode.
+func (im *T) InvalidateMetadata(topic string) {}
+
+// WarmUp implements producer.T. There is no Kafka metadata to fetch in
+// memory mode.
+func (im *T) WarmUp(topics ...string) error {
+	return nil
+}
+
+// Flush implements producer.T. Messages are committed as soon as they are
+// produced in memory mode, so there is never anything to flush.
+func (im *T) Flush(timeout time.Duration) producer.FlushResult {
+	return producer.FlushResult{}
+}
+
+// InvalidateCache implements admin.T. Nothing is cached in memory mode.
+func (im *T) InvalidateCache() {}
+
+// CacheStats implements admin.T.
+func (im *T) CacheStats() admin.CacheStats {
+	return admin.CacheStats{}
+}
+
+// getTopic returns a topic with the specified name creating it if it does not
+// exist. It must be called under the lock.
+func (im *T) getTopic(name string) *topic {
+	t, ok := im.topics[name]
+	if !ok {
+		t = &topic{partitions: make([][]record, im.cfg.InMemory.Partitions)}
+		im.topics[name] = t
+	}
+	return t
+}
+
+// nextMessage returns a message to be retried or a next new message from the
+// first partition that has one. Partitions are checked in round-robin order
+// starting from the one that follows the partition the last message was taken
+// from. New messages that come right after the selected one in the same
+// partition are claimed along with it, as many as the request allows. It must
+// be called under the lock.
+func (im *T) nextMessage(group, topic string, opts consumer.ConsumeOpts) (consumer.Message, bool) {
+	gs := im.getGroupState(group, topic, opts.OffsetReset)
+	t := im.topics[topic]
+	claimSize := opts.ClaimSize(im.cfg.Consumer.MaxClaimSize)
+	if im.cfg.TopicDispatch(topic) == config.DispatchKey {
+		claimSize = 1
+	}
+	partitionCount := len(gs.partitions)
+	for i := 0; i < partitionCount; i++ {
+		partition := (gs.nextRR + i) % partitionCount
+		ps := gs.partitions[partition]
+		if time.Now().Before(ps.throttledUntil) {
+			continue
+		}
+		if msg, _, ok := ps.ot.NextRetry(); ok {
+			gs.nextRR = (partition + 1) % partitionCount
+			return msg, true
+		}
+		records := t.partitions[partition]
+		msg, ok := im.nextRecord(ps, group, topic, partition, records)
+		if !ok {
+			continue
+		}
+		for len(msg.Following) < claimSize-1 {
+			followingMsg, ok := im.nextRecord(ps, group, topic, partition, records)
+			if !ok {
+				break
+			}
+			msg.Following = append(msg.Following, followingMsg)
+		}
+		gs.nextRR = (partition + 1) % partitionCount
+		return msg, true
+	}
+	return consumer.Message{}, false
+}
+
+// nextRecord offers a message released by the key queue, or the next not yet
+// acknowledged record of a partition that the key queue admits. Records older
+// than the topic max message age are acknowledged and skipped, like it is
+// done by the real consumer. It must be called under the lock.
+func (im *T) nextRecord(ps *partitionState, group, topic string, partition int, records []record) (consumer.Message, bool) {
+	if len(ps.released) > 0 {
+		msg := ps.released[0]
+		ps.released = ps.released[1:]
+		ps.ot.OnOffered(msg)
+		return msg, true
+	}
+	for !ps.kq.Full() && ps.next < int64(len(records)) {
+		msg := consumer.Message{
+			Topic:         topic,
+			Partition:     int32(partition),
+			Offset:        ps.next,
+			Key:           records[ps.next].key,
+			Value:         records[ps.next].value,
+			Timestamp:     records[ps.next].timestamp,
+			HighWaterMark: int64(len(records)),
+			EventsCh:      ps.eventsCh,
+		}
+		ps.next++
+		if ps.ot.IsAcked(msg) {
+			continue
+		}
+		if maxAge := im.cfg.TopicMaxMessageAge(topic); maxAge > 0 && time.Since(msg.Timestamp) > maxAge {
+			im.offsets[groupTopicPartition{group, topic, int32(partition)}] = ps.ot.OnSkipped(msg.Offset)
+			continue
+		}
+		if !ps.kq.Admit(msg) {
+			continue
+		}
+		ps.ot.OnOffered(msg)
+		return msg, true
+	}
+	return consumer.Message{}, false
+}
+
+// getGroupState returns the state of the specified group/topic creating it if
+// necessary. Partitions that do not have committed offsets are consumed from
+// where the offset reset policy tells, and the initial offset is committed
+// with the policy recorded in metadata, like it is done by the real consumer.
+// It must be called under the lock.
+func (im *T) getGroupState(group, topic string, reset consumer.OffsetReset) *groupState {
+	gt := groupTopic{group, topic}
+	gs, ok := im.groups[gt]
+	if ok {
+		return gs
+	}
+	if reset.IsZero() {
+		reset.Policy = im.cfg.Consumer.OffsetReset
+	}
+	t := im.getTopic(topic)
+	gs = &groupState{partitions: make([]*partitionState, len(t.partitions))}
+	for i, records := range t.partitions {
+		gtp := groupTopicPartition{group, topic, int32(i)}
+		offset, ok := im.offsets[gtp]
+		if !ok {
+			offset = offsetmgr.Offset{Val: resetOffset(records, reset), Meta: offsettrac.OffsetResetMeta(reset)}
+			im.offsets[gtp] = offset
+		}
+		ps := &partitionState{
+			actorID:  im.actorID.NewChild(group, topic, i),
+			next:     offset.Val,
+			eventsCh: make(chan consumer.Event, im.cfg.Consumer.ChannelBufferSize),
+		}
+		ps.ot = im.newOffsetTracker(ps.actorID, topic, offset)
+		ps.kq = im.newKeyQueue(topic)
+		gs.partitions[i] = ps
+		actor.Spawn(ps.actorID, &im.wg, func() { im.runAcker(gtp, ps) })
+	}
+	im.groups[gt] = gs
+	return gs
+}
+
+// resetOffset returns an offset in a partition to start consuming from in
+// accordance with the offset reset policy.
+func resetOffset(records []record, reset consumer.OffsetReset) int64 {
+	switch {
+	case !reset.Time.IsZero():
+		return int64(sort.Search(len(records), func(i int) bool {
+			return !records[i].timestamp.Before(reset.Time)
+		}))
+	case reset.Policy == config.OffsetResetEarliest:
+		return 0
+	default:
+		return int64(len(records))
+	}
+}
+
+// newOffsetTracker creates an offset tracker for a partition of a topic
+// configured the same way as in the real consumer.
+func (im *T) newOffsetTracker(actorID *actor.ID, topic string, offset offsetmgr.Offset) *offsettrac.T {
+	redelivery := im.cfg.TopicRedelivery(topic)
+	return offsettrac.NewWithOpts(actorID, offset, im.cfg.Consumer.AckTimeout, offsettrac.Opts{
+		MaxMetaSize: im.cfg.Consumer.MaxSparseAcksSize,
+		RetryDelay:  redelivery.Delay,
+		MaxAttempts: redelivery.MaxAttempts,
+	})
+}
+
+// newKeyQueue returns a key queue for a partition of a topic in the key
+// dispatch mode, or nil otherwise.
+func (im *T) newKeyQueue(topic string) *keyqueue.T {
+	if im.cfg.TopicDispatch(topic) != config.DispatchKey {
+		return nil
+	}
+	return keyqueue.New(im.cfg.Consumer.MaxKeyQueueSize)
+}
+
+// runAcker applies acknowledgements sent to a partition events channel and
+// commits resulting offsets.
+func (im *T) runAcker(gtp groupTopicPartition, ps *partitionState) {
+	for {
+		select {
+		case event := <-ps.eventsCh:
+			im.applyEvent(gtp, ps, event)
+		case <-im.stopCh:
+			// Apply acknowledgements that are still in the channel buffer.
+			for {
+				select {
+				case event := <-ps.eventsCh:
+					im.applyEvent(gtp, ps, event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (im *T) applyEvent(gtp groupTopicPartition, ps *partitionState, event consumer.Event) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	switch event.T {
+	case consumer.EvAcked:
+		ps.throttleNo = 0
+		offset, _ := ps.ot.OnAcked(event.Offset)
+		im.offsets[gtp] = offset
+		if msg, ok := ps.kq.OnAcked(event.Offset); ok {
+			im.release(ps, msg)
+		}
+	case consumer.EvCheckpoint:
+		// Offsets are committed as soon as they are set, so is the
+		// checkpoint.
+		offset, _, err := ps.ot.OnCheckpoint(offsettrac.Checkpoint{Offset: event.Offset, Meta: event.Meta})
+		im.offsets[gtp] = offset
+		if err == nil {
+			im.release(ps, ps.kq.OnCheckpoint(event.Offset)...)
+		}
+		event.DoneCh <- err
+	case consumer.EvNacked:
+		msg, ok := ps.ot.OnNacked(event.Offset, event.Class)
+		if event.Class == consumer.NackThrottle {
+			ps.throttleNo++
+			redelivery := im.cfg.TopicRedelivery(gtp.topic)
+			ps.throttledUntil = time.Now().Add(redelivery.Delay(ps.throttleNo))
+		}
+		if event.OfferedCh != nil {
+			if ok {
+				event.OfferedCh <- msg
+			}
+			close(event.OfferedCh)
+		}
+		// Consumers waiting for messages may have the nacked one now.
+		im.wakeUp()
+	}
+}
+
+// release queues messages released by the key queue of a partition to be
+// offered, and wakes up consumers waiting for messages. It must be called
+// under the lock.
+func (im *T) release(ps *partitionState, messages ...consumer.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	ps.released = append(ps.released, messages...)
+	im.wakeUp()
+}
+
+// wakeUp wakes up consumers waiting for messages. It must be called under the
+// lock.
+func (im *T) wakeUp() {
+	close(im.producedCh)
+	im.producedCh = make(chan none.T)
+}
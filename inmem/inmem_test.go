@@ -0,0 +1,425 @@
+package inmem
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type InMemSuite struct {
+	ns  *actor.ID
+	cfg *config.Proxy
+}
+
+var _ = Suite(&InMemSuite{})
+
+func (s *InMemSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging(c)
+}
+
+func (s *InMemSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+	s.cfg = testhelpers.NewTestProxyCfg("test")
+	s.cfg.InMemory.Enabled = true
+	s.cfg.InMemory.Topics = map[string]int{"foo": 4}
+	s.cfg.Consumer.LongPollingTimeout = 100 * time.Millisecond
+	s.cfg.Consumer.AckTimeout = 200 * time.Millisecond
+}
+
+// Keyless messages are distributed in round-robin fashion, and messages with
+// the same key always end up in the same partition.
+func (s *InMemSuite) TestProducePartitioning(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+
+	// When
+	var keyless []int32
+	for i := 0; i < 5; i++ {
+		prodMsg, err := im.Produce("foo", nil, sarama.StringEncoder("m"))
+		c.Assert(err, IsNil)
+		keyless = append(keyless, prodMsg.Partition)
+	}
+	keyed1, err := im.Produce("foo", sarama.StringEncoder("k"), sarama.StringEncoder("m"))
+	c.Assert(err, IsNil)
+	keyed2, err := im.Produce("foo", sarama.StringEncoder("k"), sarama.StringEncoder("m"))
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Assert(keyless, DeepEquals, []int32{0, 1, 2, 3, 0})
+	c.Assert(keyed2.Partition, Equals, keyed1.Partition)
+	c.Assert(keyed2.Offset, Equals, keyed1.Offset+1)
+}
+
+// Keyed messages are distributed by the configured partitioner.
+func (s *InMemSuite) TestProducePartitioner(c *C) {
+	s.cfg.Producer.Partitioner = config.PartitionerMurmur2Random
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+
+	// When
+	prodMsg, err := im.Produce("foo", sarama.StringEncoder("foobar"), sarama.StringEncoder("m"))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(prodMsg.Partition, Equals, int32(2))
+}
+
+// Topics that are not mentioned in the config are created on first use with
+// the default number of partitions.
+func (s *InMemSuite) TestAutoCreateTopic(c *C) {
+	s.cfg.InMemory.Partitions = 2
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	_, err := im.GetGroupOffsets("g1", "bar")
+	c.Assert(errors.Cause(err), Equals, sarama.ErrUnknownTopicOrPartition)
+
+	// When
+	_, err = im.Produce("bar", nil, sarama.StringEncoder("m"))
+	c.Assert(err, IsNil)
+
+	// Then
+	offsets, err := im.GetGroupOffsets("g1", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(offsets, DeepEquals, []admin.PartitionOffset{
+		{Partition: 0, End: 1, Offset: sarama.OffsetNewest},
+		{Partition: 1, End: 0, Offset: sarama.OffsetNewest},
+	})
+}
+
+// A topic can be created explicitly with a custom number of partitions, but
+// only once.
+func (s *InMemSuite) TestCreateTopic(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+
+	// When
+	err := im.CreateTopic("bar", 3, 1)
+	errExists := im.CreateTopic("foo", 3, 1)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(errExists, Equals, admin.ErrTopicExists)
+	offsets, err := im.GetGroupOffsets("g1", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(len(offsets), Equals, 3)
+}
+
+// Altered broker configs are reported as dynamic, sensitive ones cannot be
+// set.
+func (s *InMemSuite) TestAlterBrokerConfigs(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	maxBytes, password := "2097152", "secret"
+
+	// When
+	err := im.AlterBrokerConfigs(0, map[string]*string{"message.max.bytes": &maxBytes, "log.cleaner.threads": &maxBytes})
+
+	// Then
+	c.Assert(err, IsNil)
+	configs, err := im.DescribeBrokerConfigs(0)
+	c.Assert(err, IsNil)
+	c.Assert(configs, DeepEquals, []admin.BrokerConfig{
+		{Name: "log.cleaner.threads", Value: "2097152", Source: admin.ConfigSourceDynamic},
+		{Name: "message.max.bytes", Value: "2097152", Source: admin.ConfigSourceDynamic},
+	})
+
+	// When
+	err = im.AlterBrokerConfigs(0, map[string]*string{"message.max.bytes": nil})
+
+	// Then
+	c.Assert(err, IsNil)
+	configs, err = im.DescribeBrokerConfigs(0)
+	c.Assert(err, IsNil)
+	c.Assert(configs, HasLen, 1)
+	c.Assert(configs[0].Name, Equals, "log.cleaner.threads")
+	err = im.AlterBrokerConfigs(0, map[string]*string{"ssl.key.password": &password})
+	c.Assert(errors.Cause(err), Equals, admin.ErrInvalidBrokerConfig)
+	_, err = im.DescribeBrokerConfigs(1)
+	c.Assert(errors.Cause(err), Equals, admin.ErrBrokerNotFound)
+}
+
+// A group that has never committed offsets consumes from the newest offsets,
+// and a long polling timeout is returned if there is nothing to consume.
+func (s *InMemSuite) TestConsumeNewest(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	im.Produce("foo", nil, sarama.StringEncoder("old"))
+
+	// When
+	_, err := im.Consume("g1", "foo")
+
+	// Then
+	c.Assert(err, Equals, consumer.ErrRequestTimeout)
+}
+
+// A consume request can override the offset reset policy for partitions that
+// the group has no committed offsets for, and the chosen policy is recorded in
+// the initial offset metadata.
+func (s *InMemSuite) TestConsumeWithOffsetReset(c *C) {
+	s.cfg.InMemory.Topics = map[string]int{"foo": 1}
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	im.Produce("foo", nil, sarama.StringEncoder("m0"))
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	im.Produce("foo", nil, sarama.StringEncoder("m1"))
+
+	// When
+	msgEarliest, err := im.ConsumeWithOpts("g1", "foo", consumer.ConsumeOpts{
+		OffsetReset: consumer.OffsetReset{Policy: config.OffsetResetEarliest},
+	})
+	c.Assert(err, IsNil)
+	msgSince, err := im.ConsumeWithOpts("g2", "foo", consumer.ConsumeOpts{OffsetReset: consumer.OffsetReset{Time: since}})
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Assert(string(msgEarliest.Value), Equals, "m0")
+	c.Assert(string(msgSince.Value), Equals, "m1")
+	offsets, err := im.GetGroupOffsets("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Offset, Equals, int64(0))
+	c.Assert(offsets[0].Metadata, Equals, "reset:earliest")
+	offsets, err = im.GetGroupOffsets("g2", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Metadata, Equals, "reset:"+since.UTC().Format(time.RFC3339Nano))
+}
+
+// A consume request blocks until a message is produced.
+func (s *InMemSuite) TestConsumeLongPolling(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	_, err := im.Consume("g1", "foo")
+	c.Assert(err, Equals, consumer.ErrRequestTimeout)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		im.Produce("foo", sarama.StringEncoder("k"), sarama.StringEncoder("m"))
+	}()
+
+	// When
+	msg, err := im.Consume("g1", "foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Key), Equals, "k")
+	c.Assert(string(msg.Value), Equals, "m")
+	c.Assert(msg.Offset, Equals, int64(0))
+}
+
+// Acknowledged offsets are committed, and messages that have not been
+// acknowledged in time are offered again.
+func (s *InMemSuite) TestAckAndRetry(c *C) {
+	s.cfg.InMemory.Topics = map[string]int{"foo": 1}
+	s.cfg.Consumer.LongPollingTimeout = 500 * time.Millisecond
+	im := Spawn(s.ns, s.cfg)
+	im.Consume("g1", "foo")
+	for i := 0; i < 3; i++ {
+		im.Produce("foo", nil, sarama.StringEncoder("m"))
+	}
+	msg0, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	msg1, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	msg2, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+
+	// When
+	msg0.EventsCh <- consumer.Ack(msg0.Offset)
+	msg2.EventsCh <- consumer.Ack(msg2.Offset)
+	retried, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	im.Stop()
+
+	// Then
+	c.Assert(retried.Offset, Equals, msg1.Offset)
+	offsets, err := im.GetGroupOffsets("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Offset, Equals, int64(1))
+}
+
+// A request can claim several consecutive messages of a partition, but no
+// more than `consumer.max_claim_size`, and each of them is acked separately.
+func (s *InMemSuite) TestConsumeClaim(c *C) {
+	s.cfg.InMemory.Topics = map[string]int{"foo": 1}
+	s.cfg.Consumer.MaxClaimSize = 3
+	s.cfg.Consumer.LongPollingTimeout = 500 * time.Millisecond
+	im := Spawn(s.ns, s.cfg)
+	im.Consume("g1", "foo")
+	for i := 0; i < 5; i++ {
+		im.Produce("foo", nil, sarama.StringEncoder(fmt.Sprintf("m%d", i)))
+	}
+
+	// When
+	msg, err := im.ConsumeWithOpts("g1", "foo", consumer.ConsumeOpts{MaxMessages: 5})
+	c.Assert(err, IsNil)
+	msg.EventsCh <- consumer.Ack(msg.Offset)
+	for _, followingMsg := range msg.Following {
+		msg.EventsCh <- consumer.Ack(followingMsg.Offset)
+	}
+	next, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	im.Stop()
+
+	// Then
+	c.Assert(string(msg.Value), Equals, "m0")
+	c.Assert(len(msg.Following), Equals, 2)
+	c.Assert(msg.Following[0].Offset, Equals, int64(1))
+	c.Assert(string(msg.Following[1].Value), Equals, "m2")
+	c.Assert(len(next.Following), Equals, 0)
+	c.Assert(next.Offset, Equals, int64(3))
+	c.Assert(next.HighWaterMark, Equals, int64(5))
+	c.Assert(next.Lag(), Equals, int64(1))
+	offsets, err := im.GetGroupOffsets("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Offset, Equals, int64(3))
+}
+
+// A checkpoint acks all messages before its offset, and it is returned with
+// group offsets.
+func (s *InMemSuite) TestCheckpoint(c *C) {
+	s.cfg.InMemory.Topics = map[string]int{"foo": 1}
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	im.Consume("g1", "foo")
+	for i := 0; i < 4; i++ {
+		im.Produce("foo", nil, sarama.StringEncoder(fmt.Sprintf("m%d", i)))
+	}
+	msg, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	_, err = im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+
+	// When
+	doneCh := make(chan error, 1)
+	msg.EventsCh <- consumer.Checkpoint(2, "txn1", doneCh)
+	c.Assert(<-doneCh, IsNil)
+
+	// Then
+	next, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(next.Value), Equals, "m2")
+	offsets, err := im.GetGroupOffsets("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Offset, Equals, int64(2))
+	c.Assert(offsets[0].Metadata, Equals, "|2:txn1")
+
+	// When: a checkpoint behind the acked offset is rejected.
+	msg.EventsCh <- consumer.Checkpoint(1, "txn0", doneCh)
+
+	// Then
+	c.Assert(errors.Cause(<-doneCh), Equals, consumer.ErrCheckpointBehind)
+}
+
+// In the key dispatch mode a message is withheld until the message with the
+// same key before it is acknowledged, while messages with other keys are not.
+func (s *InMemSuite) TestKeyDispatch(c *C) {
+	s.cfg.InMemory.Topics = map[string]int{"foo": 1}
+	s.cfg.Consumer.TopicDispatch = map[string]string{"foo": config.DispatchKey}
+	s.cfg.Consumer.AckTimeout = 5 * time.Second
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	im.Consume("g1", "foo")
+	for i, key := range []string{"a", "a", "b"} {
+		im.Produce("foo", sarama.StringEncoder(key), sarama.StringEncoder(fmt.Sprintf("m%d", i)))
+	}
+	msg0, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	msg2, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(msg2.Value), Equals, "m2")
+	_, err = im.Consume("g1", "foo")
+	c.Assert(err, Equals, consumer.ErrRequestTimeout)
+
+	// When
+	msg0.EventsCh <- consumer.Ack(msg0.Offset)
+
+	// Then
+	msg1, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(msg1.Value), Equals, "m1")
+}
+
+// Messages older than the topic max message age are skipped, and their
+// offsets are committed as if they were acknowledged.
+func (s *InMemSuite) TestMaxMessageAge(c *C) {
+	s.cfg.InMemory.Topics = map[string]int{"foo": 1}
+	s.cfg.Consumer.TopicMaxMessageAge = map[string]time.Duration{"foo": 200 * time.Millisecond}
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	im.Consume("g1", "foo")
+	im.Produce("foo", nil, sarama.StringEncoder("m0"))
+	im.Produce("foo", nil, sarama.StringEncoder("m1"))
+	time.Sleep(300 * time.Millisecond)
+	im.Produce("foo", nil, sarama.StringEncoder("m2"))
+
+	// When
+	msg, err := im.Consume("g1", "foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m2")
+	offsets, err := im.GetGroupOffsets("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Offset, Equals, int64(2))
+}
+
+// Consumption resumes from offsets set via the admin API.
+func (s *InMemSuite) TestSetGroupOffsets(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	for i := 0; i < 4; i++ {
+		im.Produce("foo", nil, sarama.StringEncoder("m"))
+	}
+
+	// When
+	err := im.SetGroupOffsets("g1", "foo", []admin.PartitionOffset{{Partition: 2, Offset: 0}})
+	c.Assert(err, IsNil)
+
+	// Then
+	msg, err := im.Consume("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(msg.Partition, Equals, int32(2))
+	c.Assert(msg.Offset, Equals, int64(0))
+	consumers, err := im.GetAllTopicConsumers("foo")
+	c.Assert(err, IsNil)
+	c.Assert(consumers, DeepEquals, map[string]map[string][]int32{
+		"g1": {"test": {0, 1, 2, 3}},
+	})
+}
+
+// Groups are scanned in the order of names, page by page.
+func (s *InMemSuite) TestScanTopicConsumers(c *C) {
+	im := Spawn(s.ns, s.cfg)
+	defer im.Stop()
+	for _, group := range []string{"g3", "g1", "g2"} {
+		im.Consume(group, "foo")
+	}
+
+	// When
+	var groups []string
+	more, err := im.ScanTopicConsumers("foo", admin.Page{Limit: 2}, func(group string, consumers map[string][]int32, err error) {
+		groups = append(groups, group)
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(more, Equals, true)
+	c.Assert(groups, DeepEquals, []string{"g1", "g2"})
+	more, err = im.ScanTopicConsumers("foo", admin.Page{After: "g2", Limit: 2}, func(group string, consumers map[string][]int32, err error) {
+		c.Assert(group, Equals, "g3")
+	})
+	c.Assert(err, IsNil)
+	c.Assert(more, Equals, false)
+}
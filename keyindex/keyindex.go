@@ -0,0 +1,212 @@
+package keyindex
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotIndexed is returned when a key is looked up in a topic that is
+	// not listed in `key_index.topics`.
+	ErrNotIndexed = errors.New("topic not indexed")
+
+	// ErrKeyNotFound is returned when a topic has no message with the key,
+	// or the latest one is a tombstone.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrStale is returned when an index has not caught up with the end of
+	// its topic within `key_index.max_staleness`.
+	ErrStale = errors.New("key index stale")
+)
+
+// Source provides messages that indexes are built from. It is implemented by
+// admin.T and inmem.T.
+type Source interface {
+	TopicOffsets(topic string) ([]admin.PartitionOffset, error)
+	ReadMessages(topic string, partition int32, offset int64, count int) ([]consumer.Message, error)
+}
+
+// T keeps the latest message of every key of configured compacted topics in
+// memory, and catches up with the ends of the topics every refresh interval.
+// A nil instance is valid, it has no topics indexed.
+type T struct {
+	actorID      *actor.ID
+	source       Source
+	interval     time.Duration
+	maxStaleness time.Duration
+	batchSize    int
+	indexes      map[string]*index
+	stopCh       chan none.T
+	wg           sync.WaitGroup
+}
+
+type index struct {
+	mu     sync.RWMutex
+	values map[string]consumer.Message
+
+	// Offsets of the next messages to be read, by partition. They are only
+	// accessed by the refresh goroutine.
+	nextOffsets map[int32]int64
+
+	// When the last refresh that caught up with the topic end started. It
+	// is zero until the first one succeeds.
+	syncedAt time.Time
+}
+
+// Spawn starts building indexes of the configured topics. It returns nil if
+// there are no topics configured.
+func Spawn(namespace *actor.ID, cfg *config.Proxy, source Source) *T {
+	if len(cfg.KeyIndex.Topics) == 0 {
+		return nil
+	}
+	t := newT(namespace, cfg, source)
+	actor.Spawn(t.actorID, &t.wg, t.run)
+	return t
+}
+
+func newT(namespace *actor.ID, cfg *config.Proxy, source Source) *T {
+	t := &T{
+		actorID:      namespace.NewChild("key_index"),
+		source:       source,
+		interval:     cfg.KeyIndex.RefreshInterval,
+		maxStaleness: cfg.KeyIndex.MaxStaleness,
+		batchSize:    cfg.Consumer.ChannelBufferSize,
+		indexes:      make(map[string]*index, len(cfg.KeyIndex.Topics)),
+		stopCh:       make(chan none.T),
+	}
+	for _, topic := range cfg.KeyIndex.Topics {
+		t.indexes[topic] = &index{
+			values:      make(map[string]consumer.Message),
+			nextOffsets: make(map[int32]int64),
+		}
+	}
+	return t
+}
+
+// Get returns the latest message with `key` in an indexed topic.
+func (t *T) Get(topic string, key []byte) (consumer.Message, error) {
+	if t == nil {
+		return consumer.Message{}, errors.Wrapf(ErrNotIndexed, "topic=%s", topic)
+	}
+	idx, ok := t.indexes[topic]
+	if !ok {
+		return consumer.Message{}, errors.Wrapf(ErrNotIndexed, "topic=%s", topic)
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if idx.syncedAt.IsZero() {
+		return consumer.Message{}, errors.Wrapf(ErrStale, "topic=%s, not loaded yet", topic)
+	}
+	if staleness := time.Since(idx.syncedAt); staleness > t.maxStaleness {
+		return consumer.Message{}, errors.Wrapf(ErrStale, "topic=%s, staleness=%v", topic, staleness)
+	}
+	msg, ok := idx.values[string(key)]
+	if !ok {
+		return consumer.Message{}, errors.Wrapf(ErrKeyNotFound, "topic=%s", topic)
+	}
+	return msg, nil
+}
+
+// Stop stops refreshing indexes.
+func (t *T) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+func (t *T) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.refreshAll()
+		select {
+		case <-ticker.C:
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+func (t *T) refreshAll() {
+	for topic, idx := range t.indexes {
+		if err := t.refresh(topic, idx); err != nil {
+			select {
+			case <-t.stopCh:
+				return
+			default:
+			}
+			log.Errorf("<%s> failed to refresh: topic=%s, err=(%s)", t.actorID, topic, err)
+		}
+	}
+}
+
+// refresh reads messages of all partitions of a topic from where the
+// previous refresh stopped up to the partition ends as of the call.
+func (t *T) refresh(topic string, idx *index) error {
+	startedAt := time.Now()
+	offsets, err := t.source.TopicOffsets(topic)
+	if err != nil {
+		return err
+	}
+	for _, po := range offsets {
+		offset, ok := idx.nextOffsets[po.Partition]
+		// Messages before the partition begin have been removed, so the
+		// index may have missed some, if it has fallen that far behind.
+		if !ok || offset < po.Begin {
+			offset = po.Begin
+		}
+		for offset < po.End {
+			select {
+			case <-t.stopCh:
+				return errors.New("stopped")
+			default:
+			}
+			count := t.batchSize
+			if remaining := po.End - offset; remaining < int64(count) {
+				count = int(remaining)
+			}
+			messages, err := t.source.ReadMessages(topic, po.Partition, offset, count)
+			if err != nil {
+				return errors.Wrapf(err, "partition=%d, offset=%d", po.Partition, offset)
+			}
+			if len(messages) == 0 {
+				break
+			}
+			idx.mu.Lock()
+			for _, msg := range messages {
+				if msg.Offset >= po.End {
+					break
+				}
+				offset = msg.Offset + 1
+				if msg.Key == nil {
+					continue
+				}
+				if msg.Value == nil {
+					delete(idx.values, string(msg.Key))
+					continue
+				}
+				// Messages reference buffers of whole fetch responses, that
+				// should not be kept alive by a few indexed values.
+				msg.Key = append([]byte(nil), msg.Key...)
+				msg.Value = append([]byte(nil), msg.Value...)
+				idx.values[string(msg.Key)] = msg
+			}
+			idx.mu.Unlock()
+		}
+		idx.nextOffsets[po.Partition] = offset
+	}
+	idx.mu.Lock()
+	idx.syncedAt = startedAt
+	idx.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,157 @@
+package keyindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type KeyIndexSuite struct {
+	source *testSource
+	t      *T
+}
+
+var _ = Suite(&KeyIndexSuite{})
+
+// testSource serves messages of a single partition topic.
+type testSource struct {
+	begin    int64
+	messages []consumer.Message
+	reads    int
+}
+
+func (ts *testSource) TopicOffsets(topic string) ([]admin.PartitionOffset, error) {
+	return []admin.PartitionOffset{{Begin: ts.begin, End: int64(len(ts.messages))}}, nil
+}
+
+func (ts *testSource) ReadMessages(topic string, partition int32, offset int64, count int) ([]consumer.Message, error) {
+	ts.reads++
+	end := offset + int64(count)
+	if end > int64(len(ts.messages)) {
+		end = int64(len(ts.messages))
+	}
+	return ts.messages[offset:end], nil
+}
+
+func (ts *testSource) produce(key string, value []byte) {
+	ts.messages = append(ts.messages, consumer.Message{
+		Topic:  "foo",
+		Offset: int64(len(ts.messages)),
+		Key:    []byte(key),
+		Value:  value,
+	})
+}
+
+func (s *KeyIndexSuite) SetUpTest(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.KeyIndex.Topics = []string{"foo"}
+	cfg.Consumer.ChannelBufferSize = 2
+	s.source = &testSource{}
+	s.t = newT(actor.RootID, cfg, s.source)
+}
+
+func (s *KeyIndexSuite) get(c *C, key string) string {
+	msg, err := s.t.Get("foo", []byte(key))
+	if errors.Cause(err) == ErrKeyNotFound {
+		return ""
+	}
+	c.Assert(err, IsNil)
+	return string(msg.Value)
+}
+
+// Keys map to their latest values, and tombstones remove keys.
+func (s *KeyIndexSuite) TestGet(c *C) {
+	s.source.produce("a", []byte("1"))
+	s.source.produce("b", []byte("2"))
+	s.source.produce("a", []byte("3"))
+	s.source.produce("b", nil)
+	s.source.produce("c", []byte("4"))
+
+	// When
+	err := s.t.refresh("foo", s.t.indexes["foo"])
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(s.get(c, "a"), Equals, "3")
+	c.Assert(s.get(c, "b"), Equals, "")
+	c.Assert(s.get(c, "c"), Equals, "4")
+	c.Assert(s.source.reads, Equals, 3)
+}
+
+// A refresh only reads messages produced since the previous one.
+func (s *KeyIndexSuite) TestRefreshIncremental(c *C) {
+	s.source.produce("a", []byte("1"))
+	s.source.produce("b", []byte("2"))
+	c.Assert(s.t.refresh("foo", s.t.indexes["foo"]), IsNil)
+	s.source.produce("a", []byte("3"))
+	s.source.reads = 0
+
+	// When
+	err := s.t.refresh("foo", s.t.indexes["foo"])
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(s.source.reads, Equals, 1)
+	c.Assert(s.get(c, "a"), Equals, "3")
+	c.Assert(s.get(c, "b"), Equals, "2")
+}
+
+// Lookups fail until an index is loaded, and when it has not caught up for
+// longer than the max staleness.
+func (s *KeyIndexSuite) TestStale(c *C) {
+	s.source.produce("a", []byte("1"))
+
+	_, err := s.t.Get("foo", []byte("a"))
+	c.Assert(errors.Cause(err), Equals, ErrStale)
+
+	c.Assert(s.t.refresh("foo", s.t.indexes["foo"]), IsNil)
+	c.Assert(s.get(c, "a"), Equals, "1")
+
+	s.t.indexes["foo"].syncedAt = time.Now().Add(-time.Minute)
+	_, err = s.t.Get("foo", []byte("a"))
+	c.Assert(err, ErrorMatches, "topic=foo, staleness=.*: key index stale")
+}
+
+func (s *KeyIndexSuite) TestNotIndexed(c *C) {
+	_, err := s.t.Get("bar", []byte("a"))
+	c.Assert(errors.Cause(err), Equals, ErrNotIndexed)
+
+	var nilT *T
+	_, err = nilT.Get("foo", []byte("a"))
+	c.Assert(errors.Cause(err), Equals, ErrNotIndexed)
+	nilT.Stop()
+}
+
+// Spawned indexes are loaded right away.
+func (s *KeyIndexSuite) TestSpawn(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.KeyIndex.Topics = []string{"foo"}
+	s.source.produce("a", []byte("1"))
+
+	// When
+	t := Spawn(actor.RootID, cfg, s.source)
+	defer t.Stop()
+
+	// Then
+	for i := 0; ; i++ {
+		msg, err := t.Get("foo", []byte("a"))
+		if err == nil {
+			c.Assert(string(msg.Value), Equals, "1")
+			break
+		}
+		c.Assert(errors.Cause(err), Equals, ErrStale)
+		c.Assert(i < 100, Equals, true)
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(Spawn(actor.RootID, config.DefaultProxy(), s.source), IsNil)
+}
@@ -5,10 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/logging"
@@ -19,6 +22,7 @@ import (
 const (
 	defaultLoggingCfg = `[{"name": "console", "severity": "info"}]`
 	defaultCluster    = "_"
+	peerDialTimeout   = 5 * time.Second
 )
 
 var (
@@ -26,10 +30,14 @@ var (
 	cmdConfig         string
 	cmdTCPAddr        string
 	cmdUnixAddr       string
+	cmdDiagAddr       string
 	cmdKafkaPeers     string
 	cmdZookeeperPeers string
 	cmdPIDFile        string
 	cmdLoggingJSONCfg string
+	cmdValidateConfig bool
+	cmdStrictConfig   bool
+	cmdCheckPeers     bool
 )
 
 func init() {
@@ -37,14 +45,22 @@ func init() {
 	flag.StringVar(&cmdGRPCAddr, "grpcAddr", "", "TCP address that the gRPC API should listen on")
 	flag.StringVar(&cmdTCPAddr, "tcpAddr", "", "TCP address that the HTTP API should listen on")
 	flag.StringVar(&cmdUnixAddr, "unixAddr", "", "Unix domain socket address that the HTTP API should listen on")
+	flag.StringVar(&cmdDiagAddr, "diagAddr", "", "TCP address that the diagnostics server should listen on, e.g. localhost:19093")
 	flag.StringVar(&cmdKafkaPeers, "kafkaPeers", "", "Comma separated list of brokers")
 	flag.StringVar(&cmdZookeeperPeers, "zookeeperPeers", "", "Comma separated list of ZooKeeper nodes followed by optional chroot")
 	flag.StringVar(&cmdPIDFile, "pidFile", "", "Path to the PID file")
 	flag.StringVar(&cmdLoggingJSONCfg, "logging", defaultLoggingCfg, "Logging configuration")
+	flag.BoolVar(&cmdValidateConfig, "validate-config", false, "Validate the configuration, report problems, and exit with non-zero status if there are any")
+	flag.BoolVar(&cmdStrictConfig, "strict-config", false, "Refuse to start if the configuration file has unknown keys")
+	flag.BoolVar(&cmdCheckPeers, "check-peers", false, "With -validate-config, also check that Kafka brokers and ZooKeeper nodes are reachable")
 	flag.Parse()
 }
 
 func main() {
+	if cmdValidateConfig {
+		os.Exit(validateConfig())
+	}
+
 	cfg, err := makeConfig()
 	if err != nil {
 		fmt.Printf("Failed to load config: err=(%s)\n", err)
@@ -88,15 +104,40 @@ func main() {
 	svc.Stop()
 }
 
+// makeConfig returns the config given either in a file or by command line
+// parameters, with environment variable overrides applied on top.
 func makeConfig() (*config.App, error) {
+	cfg, err := makeBaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func makeBaseConfig() (*config.App, error) {
 	var cfg *config.App
 	// If a YAML configuration file is provided, then load it and ignore all
 	// parameters provided on the command line.
 	if cmdConfig != "" {
-		var err error
-		if cfg, err = config.FromYAMLFile(cmdConfig); err != nil {
+		data, err := ioutil.ReadFile(cmdConfig)
+		if err != nil {
 			return nil, err
 		}
+		if cfg, err = config.FromYAML(data); err != nil {
+			return nil, err
+		}
+		if cmdStrictConfig {
+			unknownKeys, err := config.UnknownKeys(data)
+			if err != nil {
+				return nil, err
+			}
+			if len(unknownKeys) != 0 {
+				return nil, fmt.Errorf("unknown keys: %s", strings.Join(unknownKeys, ", "))
+			}
+		}
 		return cfg, nil
 	}
 
@@ -110,6 +151,9 @@ func makeConfig() (*config.App, error) {
 	if cmdUnixAddr != "" {
 		cfg.UnixAddr = cmdUnixAddr
 	}
+	if cmdDiagAddr != "" {
+		cfg.DiagAddr = cmdDiagAddr
+	}
 	if cmdKafkaPeers != "" {
 		cfg.Proxies[defaultCluster].Kafka.SeedPeers = strings.Split(cmdKafkaPeers, ",")
 	}
@@ -125,6 +169,70 @@ func makeConfig() (*config.App, error) {
 	return cfg, nil
 }
 
+// validateConfig reports all problems found in the configuration to stdout,
+// and returns the process exit status. Unknown keys are always reported as
+// problems, regardless of -strict-config.
+func validateConfig() int {
+	cmdStrictConfig = false
+	cfg, err := makeConfig()
+	if err != nil {
+		fmt.Printf("Invalid config: err=(%s)\n", err)
+		return 1
+	}
+	var problems []string
+	if cmdConfig != "" {
+		data, err := ioutil.ReadFile(cmdConfig)
+		if err != nil {
+			fmt.Printf("Failed to read config: err=(%s)\n", err)
+			return 1
+		}
+		unknownKeys, err := config.UnknownKeys(data)
+		if err != nil {
+			fmt.Printf("Invalid config: err=(%s)\n", err)
+			return 1
+		}
+		for _, key := range unknownKeys {
+			problems = append(problems, "unknown key: "+key)
+		}
+	}
+	problems = append(problems, cfg.Inconsistencies()...)
+	if cmdCheckPeers {
+		problems = append(problems, checkPeers(cfg)...)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) != 0 {
+		fmt.Printf("Config has %d problem(s)\n", len(problems))
+		return 1
+	}
+	fmt.Println("Config is valid")
+	return 0
+}
+
+// checkPeers tries to connect to Kafka brokers and ZooKeeper nodes of all
+// clusters, and returns descriptions of those that cannot be reached.
+func checkPeers(cfg *config.App) []string {
+	var problems []string
+	for cluster, pxyCfg := range cfg.Proxies {
+		for kind, addrs := range map[string][]string{
+			"kafka":     pxyCfg.Kafka.SeedPeers,
+			"zookeeper": pxyCfg.ZooKeeper.SeedPeers,
+		} {
+			for _, addr := range addrs {
+				conn, err := net.DialTimeout("tcp", addr, peerDialTimeout)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("cluster=%s: %s peer %s is unreachable: %s", cluster, kind, addr, err))
+					continue
+				}
+				conn.Close()
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
 func initLogging() error {
 	var loggingCfg []log.Config
 	if err := json.Unmarshal([]byte(cmdLoggingJSONCfg), &loggingCfg); err != nil {
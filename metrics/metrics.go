@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// Metric is a metric registered with a registry along with its identity.
+type Metric struct {
+	Name string
+
+	// Tags are key/value pairs that tell metrics of the same name apart,
+	// e.g. topic=foo.
+	Tags map[string]string
+
+	// One of go-metrics types, e.g. gometrics.Timer.
+	Value interface{}
+}
+
+// Registry keeps metrics identified by a name and a set of tags. It is safe
+// for concurrent use. A nil instance is valid, it returns metrics that
+// discard everything recorded to them.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*Metric
+}
+
+// New creates an empty registry.
+func New() *Registry {
+	return &Registry{metrics: make(map[string]*Metric)}
+}
+
+// Timer returns a timer with the specified name and tags given as a list of
+// key/value pairs. It is created on the first call.
+func (r *Registry) Timer(name string, tags ...string) gometrics.Timer {
+	if r == nil {
+		return gometrics.NilTimer{}
+	}
+	return r.getOrRegister(name, tags, func() interface{} { return gometrics.NewTimer() }).(gometrics.Timer)
+}
+
+// Counter is like Timer but for counters.
+func (r *Registry) Counter(name string, tags ...string) gometrics.Counter {
+	if r == nil {
+		return gometrics.NilCounter{}
+	}
+	return r.getOrRegister(name, tags, func() interface{} { return gometrics.NewCounter() }).(gometrics.Counter)
+}
+
+// Gauge is like Timer but for gauges.
+func (r *Registry) Gauge(name string, tags ...string) gometrics.Gauge {
+	if r == nil {
+		return gometrics.NilGauge{}
+	}
+	return r.getOrRegister(name, tags, func() interface{} { return gometrics.NewGauge() }).(gometrics.Gauge)
+}
+
+// GaugeFunc registers a gauge whose value is obtained by calling `fn` every
+// time the gauge is sampled. It replaces a metric registered with the same
+// name and tags before, if any.
+func (r *Registry) GaugeFunc(name string, fn func() int64, tags ...string) {
+	if r == nil {
+		return
+	}
+	key, tagMap := keyOf(name, tags)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[key] = &Metric{Name: name, Tags: tagMap, Value: gometrics.NewFunctionalGauge(fn)}
+}
+
+// Unregister removes a metric with the specified name and tags, e.g. the one
+// registered with GaugeFunc when the thing it measures is gone.
+func (r *Registry) Unregister(name string, tags ...string) {
+	if r == nil {
+		return
+	}
+	key, _ := keyOf(name, tags)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.metrics, key)
+}
+
+// Each calls `fn` for every registered metric in the order of their names
+// and tags.
+func (r *Registry) Each(fn func(m *Metric)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.metrics))
+	for key := range r.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	metrics := make([]*Metric, len(keys))
+	for i, key := range keys {
+		metrics[i] = r.metrics[key]
+	}
+	r.mu.Unlock()
+	for _, m := range metrics {
+		fn(m)
+	}
+}
+
+func (r *Registry) getOrRegister(name string, tags []string, newFn func() interface{}) interface{} {
+	key, tagMap := keyOf(name, tags)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[key]; ok {
+		return m.Value
+	}
+	m := &Metric{Name: name, Tags: tagMap, Value: newFn()}
+	r.metrics[key] = m
+	return m.Value
+}
+
+// keyOf returns a key that identifies a metric in a registry along with tags
+// given as a list of key/value pairs converted to a map.
+func keyOf(name string, tags []string) (string, map[string]string) {
+	key := name
+	tagMap := make(map[string]string, len(tags)/2)
+	for i := 0; i+1 < len(tags); i += 2 {
+		tagMap[tags[i]] = tags[i+1]
+	}
+	tagKeys := make([]string, 0, len(tagMap))
+	for k := range tagMap {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	for _, k := range tagKeys {
+		key += "," + k + "=" + tagMap[k]
+	}
+	return key, tagMap
+}
+
+// Values returns a metric snapshot as a map of value names to values. Timer
+// durations are given in milliseconds.
+func Values(metric interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	switch metric := metric.(type) {
+	case gometrics.Counter:
+		values["count"] = metric.Count()
+	case gometrics.Gauge:
+		values["value"] = metric.Value()
+	case gometrics.Timer:
+		t := metric.Snapshot()
+		ps := t.Percentiles([]float64{0.5, 0.95, 0.99, 0.999})
+		values["count"] = t.Count()
+		values["min"] = float64(t.Min()) / 1e6
+		values["max"] = float64(t.Max()) / 1e6
+		values["mean"] = t.Mean() / 1e6
+		values["p50"] = ps[0] / 1e6
+		values["p95"] = ps[1] / 1e6
+		values["p99"] = ps[2] / 1e6
+		values["p999"] = ps[3] / 1e6
+		values["rate1m"] = t.Rate1()
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+// A nil registry returns metrics that discard everything.
+func (s *MetricsSuite) TestNil(c *C) {
+	var r *Registry
+	r.Timer("foo", "topic", "bar").Update(time.Second)
+	r.Counter("foo").Inc(1)
+	r.Gauge("foo").Update(1)
+	r.GaugeFunc("foo", func() int64 { return 1 })
+	r.Unregister("foo")
+	r.Each(func(m *Metric) { c.Error("unexpected metric", m.Name) })
+}
+
+// Metrics are told apart by tags regardless of their order.
+func (s *MetricsSuite) TestTags(c *C) {
+	r := New()
+	r.Timer("latency", "topic", "foo", "acks", "all").Update(2 * time.Millisecond)
+	r.Timer("latency", "acks", "all", "topic", "foo").Update(4 * time.Millisecond)
+	r.Timer("latency", "topic", "bar", "acks", "all").Update(time.Millisecond)
+	r.Counter("errors", "topic", "foo").Inc(3)
+
+	// When
+	var metrics []*Metric
+	r.Each(func(m *Metric) { metrics = append(metrics, m) })
+
+	// Then
+	c.Assert(len(metrics), Equals, 3)
+	c.Assert(metrics[0].Name, Equals, "errors")
+	c.Assert(Values(metrics[0].Value)["count"], Equals, int64(3))
+	c.Assert(metrics[1].Tags, DeepEquals, map[string]string{"topic": "bar", "acks": "all"})
+	c.Assert(metrics[2].Tags, DeepEquals, map[string]string{"topic": "foo", "acks": "all"})
+	values := Values(metrics[2].Value)
+	c.Assert(values["count"], Equals, int64(2))
+	c.Assert(values["min"], Equals, 2.0)
+	c.Assert(values["max"], Equals, 4.0)
+	c.Assert(values["mean"], Equals, 3.0)
+}
+
+func (s *MetricsSuite) TestGauge(c *C) {
+	r := New()
+	r.Gauge("topics", "group", "foo").Update(3)
+
+	// When
+	r.Gauge("topics", "group", "foo").Update(2)
+
+	// Then
+	var metrics []*Metric
+	r.Each(func(m *Metric) { metrics = append(metrics, m) })
+	c.Assert(len(metrics), Equals, 1)
+	c.Assert(Values(metrics[0].Value), DeepEquals, map[string]interface{}{"value": int64(2)})
+}
+
+// Functional gauges are sampled on every read, and can be replaced and
+// unregistered.
+func (s *MetricsSuite) TestGaugeFunc(c *C) {
+	r := New()
+	depth := int64(3)
+	r.GaugeFunc("depth", func() int64 { return 1 }, "group", "foo")
+	r.GaugeFunc("depth", func() int64 { return depth }, "group", "foo")
+	r.GaugeFunc("depth", func() int64 { return 7 }, "group", "bar")
+
+	// When
+	r.Unregister("depth", "group", "bar")
+	depth = 5
+
+	// Then
+	var metrics []*Metric
+	r.Each(func(m *Metric) { metrics = append(metrics, m) })
+	c.Assert(len(metrics), Equals, 1)
+	c.Assert(metrics[0].Tags, DeepEquals, map[string]string{"group": "foo"})
+	c.Assert(Values(metrics[0].Value), DeepEquals, map[string]interface{}{"value": int64(5)})
+}
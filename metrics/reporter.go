@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+// Sample is a single value of a metric taken at flush time, e.g. the p99 field
+// of the `produce.latency` timer.
+type Sample struct {
+	Name  string
+	Field string
+	Tags  map[string]string
+	Value float64
+}
+
+// Backend is implemented by monitoring systems that metrics can be emitted to.
+type Backend interface {
+	// Emit sends a batch of samples to the monitoring system.
+	Emit(samples []Sample) error
+
+	// Close releases resources held by the backend.
+	Close() error
+}
+
+// Reporter periodically takes samples of all metrics in a registry and emits
+// them to a backend.
+type Reporter struct {
+	actorID  *actor.ID
+	registry *Registry
+	backend  Backend
+	interval time.Duration
+	tags     map[string]string
+	stopCh   chan none.T
+	wg       sync.WaitGroup
+}
+
+// SpawnReporter starts a reporter that emits metrics of `registry` to
+// `backend` every `interval`. Tags given as a list of key/value pairs are
+// attached to all samples, e.g. cluster=default.
+func SpawnReporter(namespace *actor.ID, registry *Registry, backend Backend, interval time.Duration, tags ...string) *Reporter {
+	r := &Reporter{
+		actorID:  namespace.NewChild("metrics_reporter"),
+		registry: registry,
+		backend:  backend,
+		interval: interval,
+		tags:     make(map[string]string, len(tags)/2),
+		stopCh:   make(chan none.T),
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		r.tags[tags[i]] = tags[i+1]
+	}
+	actor.Spawn(r.actorID, &r.wg, r.run)
+	return r
+}
+
+// Stop emits metrics one last time, stops the reporter and closes the backend.
+func (r *Reporter) Stop() {
+	close(r.stopCh)
+	r.wg.Wait()
+}
+
+func (r *Reporter) run() {
+	defer r.backend.Close()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.stopCh:
+			r.flush()
+			return
+		}
+	}
+}
+
+func (r *Reporter) flush() {
+	samples := Samples(r.registry, r.tags)
+	if len(samples) == 0 {
+		return
+	}
+	if err := r.backend.Emit(samples); err != nil {
+		log.Errorf("<%s> failed to emit metrics: err=(%s)", r.actorID, err)
+	}
+}
+
+// Samples returns values of all metrics in a registry ordered by metric name,
+// tags and field. Tags of every sample are merged with `extraTags`.
+func Samples(registry *Registry, extraTags map[string]string) []Sample {
+	var samples []Sample
+	registry.Each(func(m *Metric) {
+		tags := make(map[string]string, len(m.Tags)+len(extraTags))
+		for k, v := range extraTags {
+			tags[k] = v
+		}
+		for k, v := range m.Tags {
+			tags[k] = v
+		}
+		values := Values(m.Value)
+		fields := make([]string, 0, len(values))
+		for field := range values {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			var value float64
+			switch v := values[field].(type) {
+			case int64:
+				value = float64(v)
+			case float64:
+				value = v
+			default:
+				continue
+			}
+			samples = append(samples, Sample{Name: m.Name, Field: field, Tags: tags, Value: value})
+		}
+	})
+	return samples
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxPacketSize keeps statsd datagrams within the MTU of most networks.
+const maxPacketSize = 1432
+
+// nameReplacer substitutes characters that have special meaning in the statsd
+// protocol.
+var nameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// StatsD is a backend that emits samples as gauges over UDP to a statsd daemon
+// or a DogStatsD agent. Plain statsd does not support tags, so tag keys and
+// values are folded into metric names, e.g. `produce.latency.topic.foo.p99`.
+// DogStatsD gets them as tags, e.g. `produce.latency.p99|#topic:foo`.
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsD bool
+	tags      []string
+}
+
+// NewStatsD creates a plain statsd backend.
+func NewStatsD(addr, prefix string) (*StatsD, error) {
+	return newStatsD(addr, prefix, false, nil)
+}
+
+// NewDogStatsD creates a DogStatsD backend. Tags in the `key:value` form are
+// attached to all emitted samples.
+func NewDogStatsD(addr, prefix string, tags []string) (*StatsD, error) {
+	return newStatsD(addr, prefix, true, tags)
+}
+
+func newStatsD(addr, prefix string, dogStatsD bool, tags []string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to statsd, addr=%s", addr)
+	}
+	return &StatsD{conn: conn, prefix: prefix, dogStatsD: dogStatsD, tags: tags}, nil
+}
+
+// Emit implements Backend.
+func (s *StatsD) Emit(samples []Sample) error {
+	var packet bytes.Buffer
+	for _, sample := range samples {
+		line := s.format(sample)
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return errors.Wrap(err, "failed to send packet")
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if _, err := s.conn.Write(packet.Bytes()); err != nil {
+			return errors.Wrap(err, "failed to send packet")
+		}
+	}
+	return nil
+}
+
+// Close implements Backend.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+func (s *StatsD) format(sample Sample) string {
+	tagKeys := make([]string, 0, len(sample.Tags))
+	for k := range sample.Tags {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+
+	var line bytes.Buffer
+	line.WriteString(s.prefix)
+	line.WriteString(nameReplacer.Replace(sample.Name))
+	if !s.dogStatsD {
+		for _, k := range tagKeys {
+			line.WriteByte('.')
+			line.WriteString(nameReplacer.Replace(k))
+			line.WriteByte('.')
+			line.WriteString(strings.Replace(nameReplacer.Replace(sample.Tags[k]), ".", "_", -1))
+		}
+	}
+	line.WriteByte('.')
+	line.WriteString(sample.Field)
+	line.WriteByte(':')
+	line.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+	line.WriteString("|g")
+	if s.dogStatsD && len(tagKeys)+len(s.tags) > 0 {
+		line.WriteString("|#")
+		for i, k := range tagKeys {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(nameReplacer.Replace(k))
+			line.WriteByte(':')
+			line.WriteString(nameReplacer.Replace(sample.Tags[k]))
+		}
+		for i, tag := range s.tags {
+			if i > 0 || len(tagKeys) > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(tag)
+		}
+	}
+	return line.String()
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+type StatsDSuite struct {
+	conn *net.UDPConn
+}
+
+var _ = Suite(&StatsDSuite{})
+
+func (s *StatsDSuite) SetUpTest(c *C) {
+	var err error
+	s.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+}
+
+func (s *StatsDSuite) TearDownTest(c *C) {
+	s.conn.Close()
+}
+
+// Plain statsd gets tags folded into metric names.
+func (s *StatsDSuite) TestStatsD(c *C) {
+	backend, err := NewStatsD(s.conn.LocalAddr().String(), "pixy.")
+	c.Assert(err, IsNil)
+	defer backend.Close()
+
+	// When
+	err = backend.Emit([]Sample{
+		{Name: "produce.errors", Field: "count", Tags: map[string]string{"topic": "foo.bar", "acks": "all"}, Value: 3},
+		{Name: "produce.latency", Field: "p99", Value: 1.5},
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(s.receive(c), DeepEquals, []string{
+		"pixy.produce.errors.acks.all.topic.foo_bar.count:3|g",
+		"pixy.produce.latency.p99:1.5|g",
+	})
+}
+
+// DogStatsD gets both sample and global tags.
+func (s *StatsDSuite) TestDogStatsD(c *C) {
+	backend, err := NewDogStatsD(s.conn.LocalAddr().String(), "", []string{"env:test"})
+	c.Assert(err, IsNil)
+	defer backend.Close()
+
+	// When
+	err = backend.Emit([]Sample{
+		{Name: "produce.errors", Field: "count", Tags: map[string]string{"topic": "foo.bar", "acks": "all"}, Value: 3},
+		{Name: "produce.latency", Field: "p99", Value: 1.5},
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(s.receive(c), DeepEquals, []string{
+		"produce.errors.count:3|g|#acks:all,topic:foo.bar,env:test",
+		"produce.latency.p99:1.5|g|#env:test",
+	})
+}
+
+// Samples that do not fit into one packet are split between several.
+func (s *StatsDSuite) TestPacketSize(c *C) {
+	backend, err := NewStatsD(s.conn.LocalAddr().String(), "")
+	c.Assert(err, IsNil)
+	defer backend.Close()
+	samples := make([]Sample, 100)
+	for i := range samples {
+		samples[i] = Sample{Name: strings.Repeat("x", 50), Field: "count", Value: 1}
+	}
+
+	// When
+	err = backend.Emit(samples)
+
+	// Then
+	c.Assert(err, IsNil)
+	var lines []string
+	for len(lines) < len(samples) {
+		packet := s.receivePacket(c)
+		c.Assert(len(packet) <= maxPacketSize, Equals, true)
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	c.Assert(len(lines), Equals, len(samples))
+}
+
+// The reporter emits metrics on every tick, tagged with the reporter tags.
+func (s *StatsDSuite) TestReporter(c *C) {
+	backend, err := NewDogStatsD(s.conn.LocalAddr().String(), "", nil)
+	c.Assert(err, IsNil)
+	r := New()
+	r.Counter("produce.errors", "topic", "foo").Inc(1)
+
+	// When
+	reporter := SpawnReporter(actor.RootID, r, backend, 50*time.Millisecond, "cluster", "default")
+	defer reporter.Stop()
+
+	// Then
+	c.Assert(s.receive(c), DeepEquals, []string{"produce.errors.count:1|g|#cluster:default,topic:foo"})
+	c.Assert(s.receive(c), DeepEquals, []string{"produce.errors.count:1|g|#cluster:default,topic:foo"})
+}
+
+// The reporter emits metrics one last time when stopped.
+func (s *StatsDSuite) TestReporterStop(c *C) {
+	backend, err := NewStatsD(s.conn.LocalAddr().String(), "")
+	c.Assert(err, IsNil)
+	r := New()
+	r.Counter("produce.errors").Inc(2)
+	reporter := SpawnReporter(actor.RootID, r, backend, time.Hour)
+
+	// When
+	reporter.Stop()
+
+	// Then
+	c.Assert(s.receive(c), DeepEquals, []string{"produce.errors.count:2|g"})
+}
+
+func (s *StatsDSuite) receive(c *C) []string {
+	return strings.Split(s.receivePacket(c), "\n")
+}
+
+func (s *StatsDSuite) receivePacket(c *C) string {
+	buf := make([]byte, 65536)
+	s.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := s.conn.Read(buf)
+	c.Assert(err, IsNil)
+	return string(buf[:n])
+}
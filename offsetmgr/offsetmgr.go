@@ -2,13 +2,16 @@ package offsetmgr
 
 import (
 	"math"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/chaos"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer/mapper"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/log"
 	"github.com/pkg/errors"
 )
@@ -31,6 +34,10 @@ type Factory interface {
 	// new one can be started.
 	SpawnOffsetManager(namespace *actor.ID, group, topic string, partition int32) (T, error)
 
+	// Failures returns partitions which offsets have not been committed for
+	// longer than `Consumer.OffsetsCommitFailureThreshold` due to errors.
+	Failures() []Failure
+
 	// Stop waits for the spawned offset managers to stop and then terminates. Note
 	// that all spawned offset managers has to be explicitly stopped by calling
 	// their Stop method.
@@ -71,6 +78,15 @@ type Offset struct {
 	Meta string
 }
 
+// Failure describes a partition which offsets keep failing to be committed.
+type Failure struct {
+	Group     string    `json:"group"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Since     time.Time `json:"since"`
+	Error     string    `json:"error"`
+}
+
 var (
 	errNoCoordinator  = errors.New("failed to resolve coordinator")
 	errRequestTimeout = errors.New("request timeout")
@@ -80,15 +96,36 @@ var (
 	testReportErrors bool
 )
 
+// Opts are optional parameters of a factory.
+type Opts struct {
+	// If given, then faults configured in it are injected into offset commit
+	// requests.
+	Faults *chaos.T
+
+	// If given, then offset commit failures are reported to it.
+	Metrics *metrics.Registry
+}
+
 // SpawnFactory creates a new offset manager factory from the given client.
 func SpawnFactory(namespace *actor.ID, cfg *config.Proxy, kafkaClt sarama.Client) Factory {
+	return SpawnFactoryWithOpts(namespace, cfg, kafkaClt, Opts{})
+}
+
+// SpawnFactoryWithOpts is like SpawnFactory, but takes optional parameters.
+func SpawnFactoryWithOpts(namespace *actor.ID, cfg *config.Proxy, kafkaClt sarama.Client, opts Opts) Factory {
 	f := &factory{
 		namespace: namespace.NewChild("offset_mgr_f"),
 		kafkaClt:  kafkaClt,
 		cfg:       cfg,
+		faults:    opts.Faults,
+		metrics:   opts.Metrics,
 		children:  make(map[instanceID]*offsetMgr),
+		failures:  make(map[instanceID]Failure),
 	}
 	f.mapper = mapper.Spawn(f.namespace, f)
+	f.metrics.GaugeFunc("consumer.offset_commit.failing_partitions", func() int64 {
+		return int64(len(f.Failures()))
+	})
 	return f
 }
 
@@ -98,9 +135,13 @@ type factory struct {
 	namespace    *actor.ID
 	kafkaClt     sarama.Client
 	cfg          *config.Proxy
+	faults       *chaos.T
+	metrics      *metrics.Registry
 	mapper       *mapper.T
 	children     map[instanceID]*offsetMgr
 	childrenLock sync.Mutex
+	failures     map[instanceID]Failure
+	failuresLock sync.Mutex
 }
 
 type instanceID struct {
@@ -124,6 +165,21 @@ func (f *factory) SpawnOffsetManager(namespace *actor.ID, group, topic string, p
 	return om, nil
 }
 
+// implements `Factory`
+func (f *factory) Failures() []Failure {
+	threshold := time.Now().UTC().Add(-f.cfg.Consumer.OffsetsCommitFailureThreshold)
+	f.failuresLock.Lock()
+	defer f.failuresLock.Unlock()
+	var failures []Failure
+	for _, failure := range f.failures {
+		if failure.Since.Before(threshold) {
+			failures = append(failures, failure)
+		}
+	}
+	sort.Sort(byGroupTopicPartition(failures))
+	return failures
+}
+
 // implements `mapper.Resolver`.
 func (f *factory) ResolveBroker(pw mapper.Worker) (*sarama.Broker, error) {
 	om := pw.(*offsetMgr)
@@ -144,6 +200,7 @@ func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 		aggrActorID:     f.namespace.NewChild("broker", brokerConn.ID(), "aggr"),
 		execActorID:     f.namespace.NewChild("broker", brokerConn.ID(), "exec"),
 		cfg:             f.cfg,
+		faults:          f.faults,
 		conn:            brokerConn,
 		requestsCh:      make(chan submitReq),
 		batchRequestsCh: make(chan map[string]map[instanceID]submitReq),
@@ -165,13 +222,14 @@ func (f *factory) spawnOffsetManager(namespace *actor.ID, id instanceID) *offset
 	if testReportErrors {
 		om.testErrorsCh = make(chan error, f.cfg.Consumer.ChannelBufferSize)
 	}
-	actor.Spawn(om.actorID, &om.wg, om.run)
+	actor.Spawn(om.actorID, &om.wg, om.runSupervised)
 	return om
 }
 
 // implements `Factory.Stop()`
 func (f *factory) Stop() {
 	f.mapper.Stop()
+	f.metrics.Unregister("consumer.offset_commit.failing_partitions")
 }
 
 // implements `T`
@@ -188,8 +246,14 @@ type offsetMgr struct {
 	assignedBrokerRequestsCh  chan<- submitReq
 	nilOrBrokerRequestsCh     chan<- submitReq
 	nilOrReassignRetryTimerCh <-chan time.Time
+	nilOrResubmitTimerCh      <-chan time.Time
 	lastReassignTime          time.Time
 
+	// The number of consecutive failures to fetch or commit an offset, and
+	// when the first of them happened.
+	failureCount int
+	failingSince time.Time
+
 	// To be used in tests only!
 	testErrorsCh chan error
 }
@@ -215,6 +279,9 @@ func (om *offsetMgr) Stop() {
 	om.f.childrenLock.Lock()
 	delete(om.f.children, om.id)
 	om.f.childrenLock.Unlock()
+	om.f.failuresLock.Lock()
+	delete(om.f.failures, om.id)
+	om.f.failuresLock.Unlock()
 	om.f.mapper.OnWorkerStopped(om)
 }
 
@@ -227,11 +294,27 @@ func (om *offsetMgr) String() string {
 	return om.actorID.String()
 }
 
-func (om *offsetMgr) run() {
+// runSupervised runs the offset manager restarting it if it panics, as
+// `Consumer.Restart` prescribes. A restarted offset manager requests a broker
+// assignment to fetch the committed offset again.
+func (om *offsetMgr) runSupervised() {
 	defer close(om.committedOffsetsCh)
 	if om.testErrorsCh != nil {
 		defer close(om.testErrorsCh)
 	}
+	restarted := false
+	actor.Supervise(om.actorID, actor.RestartPolicy(om.f.cfg.Consumer.Restart), nil, func() {
+		if restarted {
+			om.assignedBrokerRequestsCh = nil
+			om.nilOrBrokerRequestsCh = nil
+			om.f.mapper.TriggerReassign(om)
+		}
+		restarted = true
+		om.run()
+	})
+}
+
+func (om *offsetMgr) run() {
 	var (
 		lastCommittedOffset   = Offset{Val: math.MinInt64}
 		lastSubmitRequest     = submitReq{offset: lastCommittedOffset}
@@ -248,11 +331,11 @@ func (om *offsetMgr) run() {
 		select {
 		case bw := <-om.assignmentCh:
 			log.Infof("<%s> assigned %s", om.actorID, bw)
+			om.nilOrReassignRetryTimerCh = nil
 			if bw == nil {
 				om.triggerOrScheduleReassign(errNoCoordinator, "no broker assigned")
 				continue
 			}
-			om.nilOrReassignRetryTimerCh = nil
 
 			be := bw.(*brokerExecutor)
 			om.assignedBrokerRequestsCh = be.requestsCh
@@ -265,6 +348,7 @@ func (om *offsetMgr) run() {
 				}
 				om.committedOffsetsCh <- initialOffset
 				initialOffsetFetched = true
+				om.recordSuccess()
 			}
 			if lastSubmitRequest.offset != lastCommittedOffset {
 				om.nilOrBrokerRequestsCh = om.assignedBrokerRequestsCh
@@ -279,7 +363,10 @@ func (om *offsetMgr) run() {
 			}
 			lastSubmitRequest = submitReq
 			lastSubmitRequest.resultCh = submitResponseCh
-			om.nilOrBrokerRequestsCh = om.assignedBrokerRequestsCh
+			// A scheduled resubmit will send the latest offset anyway.
+			if om.nilOrResubmitTimerCh == nil {
+				om.nilOrBrokerRequestsCh = om.assignedBrokerRequestsCh
+			}
 
 		case om.nilOrBrokerRequestsCh <- lastSubmitRequest:
 			om.nilOrBrokerRequestsCh = nil
@@ -287,9 +374,17 @@ func (om *offsetMgr) run() {
 
 		case submitRes := <-submitResponseCh:
 			if err := om.getCommitError(submitRes.kafkaRes); err != nil {
+				// While a new coordinator is loading offsets of the group it
+				// is its coordinator all right, so there is no need to
+				// resolve it again, only to retry a bit later.
+				if err == sarama.ErrOffsetsLoadInProgress {
+					om.scheduleResubmit(err)
+					continue
+				}
 				om.triggerOrScheduleReassign(err, "offset commit failed")
 				continue
 			}
+			om.recordSuccess()
 			lastCommittedOffset = submitRes.req.offset
 			om.committedOffsetsCh <- lastCommittedOffset
 			if stopped && lastSubmitRequest.offset == lastCommittedOffset {
@@ -297,30 +392,102 @@ func (om *offsetMgr) run() {
 			}
 		case <-commitTicker.C:
 			isRequestTimeout := time.Now().UTC().Sub(lastSubmitTime) > offsetCommitTimeout
-			if isRequestTimeout && lastSubmitRequest.offset != lastCommittedOffset {
+			if isRequestTimeout && lastSubmitRequest.offset != lastCommittedOffset && om.nilOrResubmitTimerCh == nil {
 				om.triggerOrScheduleReassign(errRequestTimeout, "offset commit failed")
 			}
+		case <-om.nilOrResubmitTimerCh:
+			om.nilOrResubmitTimerCh = nil
+			om.nilOrBrokerRequestsCh = om.assignedBrokerRequestsCh
 		case <-om.nilOrReassignRetryTimerCh:
 			om.f.mapper.TriggerReassign(om)
 			log.Infof("<%s> reassign triggered by timeout", om.actorID)
-			om.nilOrReassignRetryTimerCh = time.After(om.f.cfg.Consumer.RetryBackoff)
+			om.nilOrReassignRetryTimerCh = time.After(om.retryBackoff())
 		}
 	}
 }
 
+// triggerOrScheduleReassign makes the mapper resolve the group coordinator
+// again, and so does every retry backoff until a broker is assigned. That
+// handles coordinator failover as well as network errors.
 func (om *offsetMgr) triggerOrScheduleReassign(err error, reason string) {
 	om.reportError(err)
+	om.recordFailure(err)
 	om.assignedBrokerRequestsCh = nil
 	om.nilOrBrokerRequestsCh = nil
+	om.nilOrResubmitTimerCh = nil
+	// If a reassign is already pending, e.g. a request timed out while the
+	// coordinator is being resolved, then it is not a new failure.
+	if om.nilOrReassignRetryTimerCh != nil {
+		return
+	}
+	om.failureCount++
 	now := time.Now().UTC()
-	if now.Sub(om.lastReassignTime) > om.f.cfg.Consumer.RetryBackoff {
+	retryBackoff := om.retryBackoff()
+	if now.Sub(om.lastReassignTime) > retryBackoff {
 		log.Infof("<%s> trigger reassign: reason=%s, err=(%s)", om.actorID, reason, err)
 		om.lastReassignTime = now
 		om.f.mapper.TriggerReassign(om)
 	} else {
 		log.Infof("<%s> schedule reassign: reason=%s, err=(%s)", om.actorID, reason, err)
 	}
-	om.nilOrReassignRetryTimerCh = time.After(om.f.cfg.Consumer.RetryBackoff)
+	om.nilOrReassignRetryTimerCh = time.After(retryBackoff)
+}
+
+// scheduleResubmit makes the last submitted offset be sent to the assigned
+// broker again after the retry backoff.
+func (om *offsetMgr) scheduleResubmit(err error) {
+	om.reportError(err)
+	om.recordFailure(err)
+	om.failureCount++
+	retryBackoff := om.retryBackoff()
+	log.Infof("<%s> schedule resubmit: in=%v, err=(%s)", om.actorID, retryBackoff, err)
+	om.nilOrBrokerRequestsCh = nil
+	om.nilOrResubmitTimerCh = time.After(retryBackoff)
+}
+
+// retryBackoff returns how long to wait before retrying after the current
+// streak of failures. It starts at `Consumer.RetryBackoff` and doubles with
+// every consecutive failure up to `Consumer.OffsetsCommitMaxBackoff`.
+func (om *offsetMgr) retryBackoff() time.Duration {
+	retryBackoff := om.f.cfg.Consumer.RetryBackoff
+	for i := 1; i < om.failureCount && retryBackoff < om.f.cfg.Consumer.OffsetsCommitMaxBackoff; i++ {
+		retryBackoff *= 2
+	}
+	if retryBackoff > om.f.cfg.Consumer.OffsetsCommitMaxBackoff {
+		retryBackoff = om.f.cfg.Consumer.OffsetsCommitMaxBackoff
+	}
+	return retryBackoff
+}
+
+// recordFailure makes the partition be reported as failing since the first
+// failure of the current streak.
+func (om *offsetMgr) recordFailure(err error) {
+	if om.failingSince.IsZero() {
+		om.failingSince = time.Now().UTC()
+	}
+	om.f.metrics.Counter("consumer.offset_commit.failures", "group", om.id.group).Inc(1)
+
+	om.f.failuresLock.Lock()
+	om.f.failures[om.id] = Failure{
+		Group:     om.id.group,
+		Topic:     om.id.topic,
+		Partition: om.id.partition,
+		Since:     om.failingSince,
+		Error:     err.Error(),
+	}
+	om.f.failuresLock.Unlock()
+}
+
+func (om *offsetMgr) recordSuccess() {
+	if om.failingSince.IsZero() {
+		return
+	}
+	log.Infof("<%s> recovered: failures=%d, since=%v", om.actorID, om.failureCount, om.failingSince)
+	om.failureCount = 0
+	om.failingSince = time.Time{}
+	om.f.failuresLock.Lock()
+	delete(om.f.failures, om.id)
+	om.f.failuresLock.Unlock()
 }
 
 func (om *offsetMgr) fetchInitialOffset(conn *sarama.Broker) (Offset, error) {
@@ -384,13 +551,15 @@ type submitRes struct {
 }
 
 // brokerExecutor aggregates submitted offsets from partition offset managers
-// and periodically commits them to Kafka.
+// and periodically commits them to Kafka, with one request per consumer group
+// every commit interval.
 //
 // implements `mapper.Executor`.
 type brokerExecutor struct {
 	aggrActorID     *actor.ID
 	execActorID     *actor.ID
 	cfg             *config.Proxy
+	faults          *chaos.T
 	conn            *sarama.Broker
 	requestsCh      chan submitReq
 	batchRequestsCh chan map[string]map[instanceID]submitReq
@@ -408,11 +577,16 @@ func (be *brokerExecutor) Stop() {
 	be.wg.Wait()
 }
 
+// runAggregator collects submitted offsets and hands them over to the
+// executor once per commit interval, so that offsets of all partitions of a
+// group coordinated by the broker are committed with a single request.
 func (be *brokerExecutor) runAggregator() {
 	defer close(be.batchRequestsCh)
 
 	batchRequests := make(map[string]map[instanceID]submitReq)
 	var nilOrOffsetBatchesCh chan map[string]map[instanceID]submitReq
+	commitTicker := time.NewTicker(be.cfg.Consumer.OffsetsCommitInterval)
+	defer commitTicker.Stop()
 	for {
 		select {
 		case req, ok := <-be.requestsCh:
@@ -425,7 +599,12 @@ func (be *brokerExecutor) runAggregator() {
 				batchRequests[req.id.group] = groupRequests
 			}
 			groupRequests[req.id] = req
-			nilOrOffsetBatchesCh = be.batchRequestsCh
+		case <-commitTicker.C:
+			// If the executor is still busy with the previous batch, then
+			// requests keep piling up in the current one until it is ready.
+			if len(batchRequests) > 0 {
+				nilOrOffsetBatchesCh = be.batchRequestsCh
+			}
 		case nilOrOffsetBatchesCh <- batchRequests:
 			nilOrOffsetBatchesCh = nil
 			batchRequests = make(map[string]map[instanceID]submitReq)
@@ -434,53 +613,62 @@ func (be *brokerExecutor) runAggregator() {
 }
 
 func (be *brokerExecutor) runExecutor() {
-	var nilOrBatchRequestsCh chan map[string]map[instanceID]submitReq
 	var lastErr error
 	var lastErrTime time.Time
-	commitTicker := time.NewTicker(be.cfg.Consumer.OffsetsCommitInterval)
-	defer commitTicker.Stop()
 offsetCommitLoop:
-	for {
-		select {
-		case <-commitTicker.C:
-			nilOrBatchRequestsCh = be.batchRequestsCh
-		case batchRequest, ok := <-nilOrBatchRequestsCh:
-			if !ok {
-				return
+	for batchRequest := range be.batchRequestsCh {
+		// Ignore submit requests for awhile after a connection failure to
+		// allow the Kafka cluster some time to recuperate. Ignored requests
+		// will be retried by originating partition offset managers.
+		if time.Now().UTC().Sub(lastErrTime) < be.cfg.Consumer.RetryBackoff {
+			continue offsetCommitLoop
+		}
+		for group, groupRequests := range batchRequest {
+			kafkaReq := &sarama.OffsetCommitRequest{
+				Version:                 1,
+				ConsumerGroup:           group,
+				ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
 			}
-			// Ignore submit requests for awhile after a connection failure to
-			// allow the Kafka cluster some time to recuperate. Ignored requests
-			// will be retried by originating partition offset managers.
-			if time.Now().UTC().Sub(lastErrTime) < be.cfg.Consumer.RetryBackoff {
+			for _, req := range groupRequests {
+				kafkaReq.AddBlock(req.id.topic, req.id.partition, req.offset.Val, sarama.ReceiveTime, req.offset.Meta)
+			}
+			// An injected commit failure is handled like a broker error
+			// except that the connection is not reset.
+			if lastErr = be.faults.Inject(chaos.OpCommit); lastErr != nil {
+				lastErrTime = time.Now().UTC()
+				log.Infof("<%s> commit failed: err=(%v)", be.execActorID, lastErr)
 				continue offsetCommitLoop
 			}
-			nilOrBatchRequestsCh = nil
-			for group, groupRequests := range batchRequest {
-				kafkaReq := &sarama.OffsetCommitRequest{
-					Version:                 1,
-					ConsumerGroup:           group,
-					ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
-				}
-				for _, req := range groupRequests {
-					kafkaReq.AddBlock(req.id.topic, req.id.partition, req.offset.Val, sarama.ReceiveTime, req.offset.Meta)
-				}
-				var kafkaRes *sarama.OffsetCommitResponse
-				kafkaRes, lastErr = be.conn.CommitOffset(kafkaReq)
-				if lastErr != nil {
-					lastErrTime = time.Now().UTC()
-					be.conn.Close()
-					log.Infof("<%s> connection reset: err=(%v)", be.execActorID, lastErr)
-					continue offsetCommitLoop
-				}
-				// Fan the response out to the partition offset managers.
-				for _, req := range groupRequests {
-					req.resultCh <- submitRes{req, kafkaRes}
-				}
+			var kafkaRes *sarama.OffsetCommitResponse
+			kafkaRes, lastErr = be.conn.CommitOffset(kafkaReq)
+			if lastErr != nil {
+				lastErrTime = time.Now().UTC()
+				be.conn.Close()
+				log.Infof("<%s> connection reset: err=(%v)", be.execActorID, lastErr)
+				continue offsetCommitLoop
+			}
+			// Fan the response out to the partition offset managers.
+			for _, req := range groupRequests {
+				req.resultCh <- submitRes{req, kafkaRes}
 			}
 		}
 	}
 }
 
+type byGroupTopicPartition []Failure
+
+func (p byGroupTopicPartition) Len() int      { return len(p) }
+func (p byGroupTopicPartition) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byGroupTopicPartition) Less(i, j int) bool {
+	if p[i].Group != p[j].Group {
+		return p[i].Group < p[j].Group
+	}
+	if p[i].Topic != p[j].Topic {
+		return p[i].Topic < p[j].Topic
+	}
+	return p[i].Partition < p[j].Partition
+}
+
 func (be *brokerExecutor) String() string {
 	if be == nil {
 		return "<nil>"
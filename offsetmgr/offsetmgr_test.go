@@ -186,6 +186,144 @@ func (s *OffsetMgrSuite) TestCommitError(c *C) {
 	c.Assert(committedOffset, DeepEquals, Offset{1000, "foo"})
 }
 
+// If the coordinator is still loading offsets of the group, then a commit is
+// retried on the same coordinator without resolving it again.
+func (s *OffsetMgrSuite) TestCommitLoadInProgress(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(c).
+			SetCoordinator("g1", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1234, "foo", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrOffsetsLoadInProgress),
+	})
+
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.RetryBackoff = 200 * time.Millisecond
+	cfg.Consumer.OffsetsCommitInterval = 50 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+
+	om, err := f.SpawnOffsetManager(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+
+	// When
+	om.SubmitOffset(Offset{1000, "foo"})
+	var wg sync.WaitGroup
+	actor.Spawn(actor.RootID.NewChild("stopper"), &wg, om.Stop)
+
+	// Then
+	err = <-om.(*offsetMgr).testErrorsCh
+	c.Assert(err, Equals, sarama.ErrOffsetsLoadInProgress)
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError),
+	})
+
+	wg.Wait()
+	committedOffset := lastCommittedOffset(broker1, "g1", "t1", 7)
+	c.Assert(committedOffset, DeepEquals, Offset{1000, "foo"})
+	coordinatorRequestCount := 0
+	for _, rr := range broker1.History() {
+		if _, ok := rr.Request.(*sarama.ConsumerMetadataRequest); ok {
+			coordinatorRequestCount++
+		}
+	}
+	c.Assert(coordinatorRequestCount, Equals, 1)
+}
+
+// Partitions which offsets fail to be committed for longer than the failure
+// threshold are reported until a commit succeeds.
+func (s *OffsetMgrSuite) TestFailures(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(c).
+			SetCoordinator("g1", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1234, "foo", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNotCoordinatorForConsumer),
+	})
+
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.RetryBackoff = 50 * time.Millisecond
+	cfg.Consumer.OffsetsCommitInterval = 50 * time.Millisecond
+	cfg.Consumer.OffsetsCommitFailureThreshold = 200 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+
+	om, err := f.SpawnOffsetManager(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	<-om.CommittedOffsets() // Ignore initial offset.
+
+	// When
+	om.SubmitOffset(Offset{1000, "foo"})
+	err = <-om.(*offsetMgr).testErrorsCh
+	c.Assert(err, Equals, sarama.ErrNotCoordinatorForConsumer)
+
+	// Then
+	c.Assert(f.Failures(), IsNil)
+	time.Sleep(300 * time.Millisecond)
+	failures := f.Failures()
+	c.Assert(len(failures), Equals, 1)
+	c.Assert(failures[0].Group, Equals, "g1")
+	c.Assert(failures[0].Topic, Equals, "t1")
+	c.Assert(failures[0].Partition, Equals, int32(7))
+	c.Assert(failures[0].Error, Equals, sarama.ErrNotCoordinatorForConsumer.Error())
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(c).
+			SetCoordinator("g1", broker1),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError),
+	})
+	c.Assert(<-om.CommittedOffsets(), DeepEquals, Offset{1000, "foo"})
+	c.Assert(f.Failures(), IsNil)
+	om.Stop()
+}
+
+// Retries back off exponentially with consecutive failures.
+func (s *OffsetMgrSuite) TestRetryBackoff(c *C) {
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.RetryBackoff = 100 * time.Millisecond
+	cfg.Consumer.OffsetsCommitMaxBackoff = time.Second
+	om := &offsetMgr{f: &factory{cfg: cfg}}
+
+	for i, expected := range []time.Duration{
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		// When
+		om.failureCount = i
+
+		// Then
+		c.Assert(om.retryBackoff(), Equals, expected, Commentf("failures=%d", i))
+	}
+}
+
 // If offset a response received from Kafka for an offset commit request does
 // not contain information for a submitted offset, then offset manager keeps,
 // retrying until it succeeds.
@@ -385,6 +523,65 @@ func (s *OffsetMgrSuite) TestCommitDifferentGroups(c *C) {
 	c.Assert(committedOffset2, DeepEquals, Offset{2019, "bar3"})
 }
 
+// Offsets submitted for different partitions of a group during a commit
+// interval are committed with a single request.
+func (s *OffsetMgrSuite) TestCommitCoalesced(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(c).
+			SetCoordinator("g1", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1000, "foo", sarama.ErrNoError).
+			SetOffset("g1", "t1", 8, 2000, "bar", sarama.ErrNoError).
+			SetOffset("g1", "t2", 9, 3000, "bazz", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError).
+			SetError("g1", "t1", 8, sarama.ErrNoError).
+			SetError("g1", "t2", 9, sarama.ErrNoError),
+	})
+
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.OffsetsCommitInterval = 200 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+	om1, err := f.SpawnOffsetManager(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	om2, err := f.SpawnOffsetManager(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	om3, err := f.SpawnOffsetManager(s.ns.NewChild("g1", "t2", 9), "g1", "t2", 9)
+	c.Assert(err, IsNil)
+	<-om1.CommittedOffsets() // Ignore initial offsets.
+	<-om2.CommittedOffsets()
+	<-om3.CommittedOffsets()
+
+	// When
+	om1.SubmitOffset(Offset{1001, "foo1"})
+	om2.SubmitOffset(Offset{2001, "bar1"})
+	om3.SubmitOffset(Offset{3001, "bazz1"})
+	om1.Stop()
+	om2.Stop()
+	om3.Stop()
+
+	// Then
+	var commitRequests []*sarama.OffsetCommitRequest
+	for _, rr := range broker1.History() {
+		if req, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			commitRequests = append(commitRequests, req)
+		}
+	}
+	c.Assert(len(commitRequests), Equals, 1)
+	c.Assert(lastCommittedOffset(broker1, "g1", "t1", 7), DeepEquals, Offset{1001, "foo1"})
+	c.Assert(lastCommittedOffset(broker1, "g1", "t1", 8), DeepEquals, Offset{2001, "bar1"})
+	c.Assert(lastCommittedOffset(broker1, "g1", "t2", 9), DeepEquals, Offset{3001, "bazz1"})
+}
+
 func (s *OffsetMgrSuite) TestCommitNetworkError(c *C) {
 	// Given
 	broker1 := sarama.NewMockBroker(c, 101)
@@ -0,0 +1,116 @@
+// Package partitioner implements partitioners compatible with those of
+// librdkafka, so that messages produced with the same key by Kafka-Pixy and
+// by librdkafka based producers, e.g. C++ and Python ones, end up in the same
+// partition of a topic.
+package partitioner
+
+import (
+	"hash/crc32"
+
+	"github.com/Shopify/sarama"
+)
+
+// partitioner selects a partition by a hash of the message key, and falls
+// back to a random partition for keys that `isRandom` tells to.
+type partitioner struct {
+	random   sarama.Partitioner
+	hash     func(key []byte) uint32
+	isRandom func(key []byte) bool
+}
+
+// NewConsistent returns a partitioner that behaves like the librdkafka
+// `consistent` one: a partition is selected by the CRC32 hash of the key, and
+// nil and empty keys are all mapped to the same partition.
+func NewConsistent(topic string) sarama.Partitioner {
+	return &partitioner{hash: crc32.ChecksumIEEE, isRandom: never}
+}
+
+// NewConsistentRandom returns a partitioner that behaves like the librdkafka
+// `consistent_random` one, that is the librdkafka default: as `consistent`,
+// but nil and empty keys are mapped to random partitions.
+func NewConsistentRandom(topic string) sarama.Partitioner {
+	return &partitioner{
+		random:   sarama.NewRandomPartitioner(topic),
+		hash:     crc32.ChecksumIEEE,
+		isRandom: func(key []byte) bool { return len(key) == 0 },
+	}
+}
+
+// NewMurmur2 returns a partitioner that behaves like the librdkafka
+// `murmur2` one: a partition is selected by the positive murmur2 hash of the
+// key, as the Java producer does, and nil keys are all mapped to the same
+// partition.
+func NewMurmur2(topic string) sarama.Partitioner {
+	return &partitioner{hash: murmur2, isRandom: never}
+}
+
+// NewMurmur2Random returns a partitioner that behaves like the librdkafka
+// `murmur2_random` one, that is the Java producer default: as `murmur2`, but
+// nil keys are mapped to random partitions.
+func NewMurmur2Random(topic string) sarama.Partitioner {
+	return &partitioner{
+		random:   sarama.NewRandomPartitioner(topic),
+		hash:     murmur2,
+		isRandom: func(key []byte) bool { return key == nil },
+	}
+}
+
+// Partition implements sarama.Partitioner.
+func (p *partitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	var key []byte
+	if msg.Key != nil {
+		var err error
+		if key, err = msg.Key.Encode(); err != nil {
+			return -1, err
+		}
+	}
+	if p.isRandom(key) {
+		return p.random.Partition(msg, numPartitions)
+	}
+	return int32(p.hash(key) % uint32(numPartitions)), nil
+}
+
+// RequiresConsistency implements sarama.Partitioner.
+func (p *partitioner) RequiresConsistency() bool {
+	return true
+}
+
+func never(key []byte) bool {
+	return false
+}
+
+// murmur2 returns the murmur2 hash of data with the sign bit cleared, as
+// `org.apache.kafka.common.utils.Utils.toPositive(Utils.murmur2(data))` does.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h & 0x7fffffff
+}
@@ -0,0 +1,140 @@
+package partitioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PartitionerSuite struct{}
+
+var _ = Suite(&PartitionerSuite{})
+
+// Hashes match those of the Java producer, taken from the Kafka UtilsTest.
+func (s *PartitionerSuite) TestMurmur2(c *C) {
+	for i, tc := range []struct {
+		data string
+		hash int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	} {
+		// When
+		hash := murmur2([]byte(tc.data))
+
+		// Then
+		c.Assert(hash, Equals, uint32(tc.hash)&0x7fffffff, Commentf("case #%d", i))
+	}
+}
+
+func partition(c *C, p sarama.Partitioner, key sarama.Encoder, numPartitions int32) int32 {
+	partition, err := p.Partition(&sarama.ProducerMessage{Key: key}, numPartitions)
+	c.Assert(err, IsNil)
+	return partition
+}
+
+func (s *PartitionerSuite) TestConsistent(c *C) {
+	p := NewConsistent("foo")
+	// CRC32 of "123456789" is 0xCBF43926.
+	c.Assert(partition(c, p, sarama.StringEncoder("123456789"), 10), Equals, int32(0xCBF43926%10))
+	c.Assert(partition(c, p, sarama.StringEncoder(""), 10), Equals, int32(0))
+	c.Assert(partition(c, p, nil, 10), Equals, int32(0))
+}
+
+// Keys are hashed as by the consistent partitioner, but nil and empty keys
+// are mapped to random partitions.
+func (s *PartitionerSuite) TestConsistentRandom(c *C) {
+	p := NewConsistentRandom("foo")
+	c.Assert(partition(c, p, sarama.StringEncoder("123456789"), 10), Equals, int32(0xCBF43926%10))
+	c.Assert(spread(c, p, sarama.StringEncoder("")), Equals, true)
+	c.Assert(spread(c, p, nil), Equals, true)
+}
+
+func (s *PartitionerSuite) TestMurmur2Partitioner(c *C) {
+	p := NewMurmur2("foo")
+	c.Assert(partition(c, p, sarama.StringEncoder("foobar"), 7), Equals, int32((uint32(0xd0e47bbe)&0x7fffffff)%7))
+	c.Assert(partition(c, p, nil, 7), Equals, partition(c, p, sarama.StringEncoder(""), 7))
+	c.Assert(spread(c, p, nil), Equals, false)
+}
+
+// Keys are hashed as by the murmur2 partitioner, but nil keys are mapped to
+// random partitions.
+func (s *PartitionerSuite) TestMurmur2Random(c *C) {
+	p := NewMurmur2Random("foo")
+	c.Assert(partition(c, p, sarama.StringEncoder("foobar"), 7), Equals, int32((uint32(0xd0e47bbe)&0x7fffffff)%7))
+	c.Assert(spread(c, p, sarama.StringEncoder("")), Equals, false)
+	c.Assert(spread(c, p, nil), Equals, true)
+}
+
+// spread tells whether messages with the key end up in more than one
+// partition.
+func spread(c *C, p sarama.Partitioner, key sarama.Encoder) bool {
+	first := partition(c, p, key, 100)
+	for i := 0; i < 100; i++ {
+		if partition(c, p, key, 100) != first {
+			return true
+		}
+	}
+	return false
+}
+
+// Keyless messages stay in a partition for the maximum number of messages,
+// and then move to another one.
+func (s *PartitionerSuite) TestStickyMaxMessages(c *C) {
+	p := newSticky(sarama.NewHashPartitioner("foo"), 3, time.Hour, time.Now)
+
+	// When
+	var partitions []int32
+	for i := 0; i < 6; i++ {
+		partitions = append(partitions, partition(c, p, nil, 10))
+	}
+
+	// Then
+	c.Assert(partitions[1], Equals, partitions[0])
+	c.Assert(partitions[2], Equals, partitions[0])
+	c.Assert(partitions[3], Not(Equals), partitions[0])
+	c.Assert(partitions[4], Equals, partitions[3])
+	c.Assert(partitions[5], Equals, partitions[3])
+}
+
+// Keyless messages move to another partition when the maximum duration
+// elapses.
+func (s *PartitionerSuite) TestStickyMaxDuration(c *C) {
+	now := time.Now()
+	p := newSticky(sarama.NewHashPartitioner("foo"), 100, time.Second, func() time.Time { return now })
+	first := partition(c, p, nil, 10)
+	now = now.Add(999 * time.Millisecond)
+	c.Assert(partition(c, p, nil, 10), Equals, first)
+
+	// When
+	now = now.Add(time.Millisecond)
+
+	// Then
+	c.Assert(partition(c, p, nil, 10), Not(Equals), first)
+}
+
+// Messages with a key are partitioned by the wrapped partitioner, and do not
+// count towards the keyless ones.
+func (s *PartitionerSuite) TestStickyKeyed(c *C) {
+	p := newSticky(NewMurmur2("foo"), 2, time.Hour, time.Now)
+	first := partition(c, p, nil, 7)
+
+	// When
+	for i := 0; i < 5; i++ {
+		c.Assert(partition(c, p, sarama.StringEncoder("foobar"), 7), Equals, int32((uint32(0xd0e47bbe)&0x7fffffff)%7))
+	}
+
+	// Then
+	c.Assert(partition(c, p, nil, 7), Equals, first)
+	c.Assert(partition(c, p, nil, 1), Equals, int32(0))
+}
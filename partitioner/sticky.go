@@ -0,0 +1,86 @@
+package partitioner
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// sticky sends all messages without a key to the same partition until either
+// `maxMessages` of them have been sent there or `maxDuration` has elapsed
+// since it was selected, and then moves on to another random partition. That
+// makes batches of keyless messages larger than when they are sprayed across
+// all partitions, and so compressed better. Messages with a key are
+// partitioned by the wrapped partitioner.
+//
+// It is not safe for concurrent use, but sarama.AsyncProducer only calls a
+// partitioner from a single goroutine of its topic.
+type sticky struct {
+	keyed       sarama.Partitioner
+	maxMessages int
+	maxDuration time.Duration
+	rand        *rand.Rand
+	now         func() time.Time
+
+	partition int32
+	count     int
+	expiresAt time.Time
+}
+
+// NewSticky returns a constructor of partitioners that send messages without
+// a key to the same partition for up to `maxMessages` messages or
+// `maxDuration`, whichever comes first, and partition messages with a key
+// using `keyed` partitioners.
+func NewSticky(keyed sarama.PartitionerConstructor, maxMessages int, maxDuration time.Duration) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return newSticky(keyed(topic), maxMessages, maxDuration, time.Now)
+	}
+}
+
+func newSticky(keyed sarama.Partitioner, maxMessages int, maxDuration time.Duration, now func() time.Time) *sticky {
+	return &sticky{
+		keyed:       keyed,
+		maxMessages: maxMessages,
+		maxDuration: maxDuration,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:         now,
+		partition:   -1,
+	}
+}
+
+// Partition implements sarama.Partitioner.
+func (p *sticky) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if msg.Key != nil {
+		return p.keyed.Partition(msg, numPartitions)
+	}
+	now := p.now()
+	if p.partition < 0 || p.partition >= numPartitions || p.count >= p.maxMessages || !now.Before(p.expiresAt) {
+		p.partition = p.next(numPartitions)
+		p.count = 0
+		p.expiresAt = now.Add(p.maxDuration)
+	}
+	p.count++
+	return p.partition, nil
+}
+
+// next selects a random partition other than the current one, unless there
+// is no other.
+func (p *sticky) next(numPartitions int32) int32 {
+	if numPartitions == 1 {
+		return 0
+	}
+	if p.partition < 0 || p.partition >= numPartitions {
+		return p.rand.Int31n(numPartitions)
+	}
+	partition := p.rand.Int31n(numPartitions - 1)
+	if partition >= p.partition {
+		partition++
+	}
+	return partition
+}
+
+// RequiresConsistency implements sarama.Partitioner.
+func (p *sticky) RequiresConsistency() bool {
+	return p.keyed.RequiresConsistency()
+}
@@ -0,0 +1,128 @@
+package producer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+type BufferSuite struct {
+	ns *actor.ID
+}
+
+var _ = Suite(&BufferSuite{})
+
+func (s *BufferSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+}
+
+// fakeAsyncProducer hands messages produced to it over to tests, that decide
+// when they are acknowledged.
+type fakeAsyncProducer struct {
+	inputCh     chan *sarama.ProducerMessage
+	successesCh chan *sarama.ProducerMessage
+	errorsCh    chan *sarama.ProducerError
+}
+
+func newFakeAsyncProducer() *fakeAsyncProducer {
+	return &fakeAsyncProducer{
+		inputCh:     make(chan *sarama.ProducerMessage),
+		successesCh: make(chan *sarama.ProducerMessage),
+		errorsCh:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (fap *fakeAsyncProducer) AsyncClose() {
+	close(fap.successesCh)
+	close(fap.errorsCh)
+}
+
+func (fap *fakeAsyncProducer) Close() error                              { fap.AsyncClose(); return nil }
+func (fap *fakeAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return fap.inputCh }
+func (fap *fakeAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return fap.successesCh }
+func (fap *fakeAsyncProducer) Errors() <-chan *sarama.ProducerError      { return fap.errorsCh }
+
+func (s *BufferSuite) spawn(fap *fakeAsyncProducer, maxMsgs, maxBytes int) *T {
+	p := &T{
+		mergerActorID:     s.ns.NewChild("merger"),
+		dispatcherActorID: s.ns.NewChild("dispatcher"),
+		saramaProducer:    fap,
+		metadataCache:     spawnMetadataCache(s.ns.NewChild("metadata"), &fakeMetadataClient{}, time.Hour, 0),
+		flushes:           newFlushTracker(),
+		shutdownTimeout:   50 * time.Millisecond,
+		maxBufferedMsgs:   int64(maxMsgs),
+		maxBufferedBytes:  int64(maxBytes),
+		dispatcherCh:      make(chan *sarama.ProducerMessage, 10),
+		resultCh:          make(chan produceResult, 10),
+	}
+	actor.Spawn(p.mergerActorID, &p.wg, p.runMerger)
+	actor.Spawn(p.dispatcherActorID, &p.wg, p.runDispatcher)
+	return p
+}
+
+func (s *BufferSuite) assertInput(c *C, fap *fakeAsyncProducer, expected string) *sarama.ProducerMessage {
+	select {
+	case msg := <-fap.inputCh:
+		c.Assert(msg.Value, Equals, sarama.StringEncoder(expected))
+		return msg
+	case <-time.After(3 * time.Second):
+		c.Fatalf("message not dispatched: %s", expected)
+	}
+	return nil
+}
+
+func (s *BufferSuite) assertNoInput(c *C, fap *fakeAsyncProducer) {
+	select {
+	case msg := <-fap.inputCh:
+		c.Fatalf("unexpected message dispatched: %v", msg.Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Messages are held in the dispatch queue while the maximum number of them is
+// buffered, and dispatched as buffered ones get acknowledged.
+func (s *BufferSuite) TestMaxMessages(c *C) {
+	fap := newFakeAsyncProducer()
+	p := s.spawn(fap, 2, 0)
+	defer p.Stop()
+	for _, value := range []string{"a", "b", "c"} {
+		p.AsyncProduce("foo", nil, sarama.StringEncoder(value))
+	}
+	msgA := s.assertInput(c, fap, "a")
+	msgB := s.assertInput(c, fap, "b")
+	s.assertNoInput(c, fap)
+	c.Assert(atomic.LoadInt64(&p.bufferedMsgs), Equals, int64(2))
+
+	// When
+	fap.successesCh <- msgA
+
+	// Then
+	msgC := s.assertInput(c, fap, "c")
+	fap.successesCh <- msgB
+	fap.successesCh <- msgC
+}
+
+// Messages are held in the dispatch queue while the maximum number of bytes
+// is buffered, but a message larger than that is accepted into an empty
+// buffer.
+func (s *BufferSuite) TestMaxBytes(c *C) {
+	fap := newFakeAsyncProducer()
+	p := s.spawn(fap, 0, 3)
+	defer p.Stop()
+	for _, value := range []string{"abcd", "e"} {
+		p.AsyncProduce("foo", nil, sarama.StringEncoder(value))
+	}
+	msgABCD := s.assertInput(c, fap, "abcd")
+	s.assertNoInput(c, fap)
+	c.Assert(atomic.LoadInt64(&p.bufferedBytes), Equals, int64(4))
+
+	// When
+	fap.successesCh <- msgABCD
+
+	// Then
+	s.assertInput(c, fap, "e")
+	c.Assert(atomic.LoadInt64(&p.bufferedBytes), Equals, int64(1))
+}
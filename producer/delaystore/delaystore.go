@@ -0,0 +1,252 @@
+package delaystore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	msgFileExt = ".json"
+	tmpFileExt = ".tmp"
+)
+
+// Message is a message waiting to be produced.
+type Message struct {
+	Topic string `json:"topic"`
+
+	// Nil key means that the message is produced to a random partition.
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	DeliverAt time.Time `json:"deliver_at"`
+
+	// Name of the file that the message is stored in.
+	file string
+}
+
+// ProduceFn is called to produce a message when it is due. If it returns an
+// error, then producing is retried after a backoff.
+type ProduceFn func(msg Message) error
+
+// T keeps messages on disk until they are due, and then produces them. Every
+// message is stored in a file of its own, that is only removed after the
+// message is produced, so messages pending when the process stops are
+// produced after it is started again.
+type T struct {
+	actorID      *actor.ID
+	dir          string
+	produce      ProduceFn
+	retryBackoff time.Duration
+	mu           sync.Mutex
+	pending      []*Message
+	seq          int64
+	wakeupCh     chan none.T
+	stopCh       chan none.T
+	wg           sync.WaitGroup
+}
+
+// Spawn loads messages stored in `dir`, creating it if necessary, and starts
+// producing messages as they become due.
+func Spawn(namespace *actor.ID, dir string, retryBackoff time.Duration, produce ProduceFn) (*T, error) {
+	t := &T{
+		actorID:      namespace.NewChild("delay_store"),
+		dir:          dir,
+		produce:      produce,
+		retryBackoff: retryBackoff,
+		wakeupCh:     make(chan none.T, 1),
+		stopCh:       make(chan none.T),
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	log.Infof("<%s> loaded delayed messages: dir=%s, count=%d", t.actorID, dir, len(t.pending))
+	actor.Spawn(t.actorID, &t.wg, t.run)
+	return t, nil
+}
+
+// Add stores a message on disk, and schedules it to be produced when due. It
+// returns after the message is persisted.
+func (t *T) Add(msg Message) error {
+	t.mu.Lock()
+	t.seq++
+	seq := t.seq
+	t.mu.Unlock()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+	msg.file = fmt.Sprintf("%020d-%010d%s", msg.DeliverAt.UnixNano(), seq, msgFileExt)
+	tmpPath := filepath.Join(t.dir, msg.file+tmpFileExt)
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to store message")
+	}
+	if err := os.Rename(tmpPath, filepath.Join(t.dir, msg.file)); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to store message")
+	}
+	t.mu.Lock()
+	t.insert(&msg)
+	t.mu.Unlock()
+	t.wakeup()
+	return nil
+}
+
+// Count returns the number of messages waiting to be produced.
+func (t *T) Count() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// Stop stops producing messages. Messages that are not due yet stay on disk.
+func (t *T) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+// load reads messages from the store directory, and removes leftovers of
+// writes interrupted by a crash.
+func (t *T) load() error {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create delay store")
+	}
+	files, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to read delay store")
+	}
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(t.dir, name)
+		if strings.HasSuffix(name, tmpFileExt) {
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(name, msgFileExt) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read delayed message %s", name)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Errorf("<%s> skipping corrupted delayed message: file=%s, err=(%s)", t.actorID, name, err)
+			continue
+		}
+		msg.file = name
+		var seq int64
+		if _, err := fmt.Sscanf(name[strings.IndexByte(name, '-')+1:], "%d", &seq); err == nil && seq > t.seq {
+			t.seq = seq
+		}
+		t.pending = append(t.pending, &msg)
+	}
+	// File names start with zero padded due times, and ReadDir returns them
+	// in lexical order, but a stable sort keeps messages in order even if
+	// the clock was set back between restarts.
+	sort.Stable(byDeliverAt(t.pending))
+	return nil
+}
+
+// insert adds a message to the pending list, keeping it ordered by due time.
+// Messages due at the same time are kept in the order they were added.
+func (t *T) insert(msg *Message) {
+	i := sort.Search(len(t.pending), func(i int) bool {
+		return t.pending[i].DeliverAt.After(msg.DeliverAt)
+	})
+	t.pending = append(t.pending, nil)
+	copy(t.pending[i+1:], t.pending[i:])
+	t.pending[i] = msg
+}
+
+func (t *T) wakeup() {
+	select {
+	case t.wakeupCh <- none.V:
+	default:
+	}
+}
+
+func (t *T) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-t.wakeupCh:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-t.stopCh:
+			return
+		}
+		timer.Reset(t.produceDue(time.Now()))
+	}
+}
+
+// produceDue produces all messages that are due, and returns how long to wait
+// until the next one is.
+func (t *T) produceDue(now time.Time) time.Duration {
+	for {
+		t.mu.Lock()
+		if len(t.pending) == 0 {
+			t.mu.Unlock()
+			return time.Hour
+		}
+		msg := t.pending[0]
+		if wait := msg.DeliverAt.Sub(now); wait > 0 {
+			t.mu.Unlock()
+			return wait
+		}
+		t.mu.Unlock()
+
+		if err := t.produce(*msg); err != nil {
+			log.Errorf("<%s> failed to produce delayed message: topic=%s, file=%s, err=(%s)",
+				t.actorID, msg.Topic, msg.file, err)
+			return t.retryBackoff
+		}
+		if err := os.Remove(filepath.Join(t.dir, msg.file)); err != nil {
+			log.Errorf("<%s> failed to remove delivered message: file=%s, err=(%s)", t.actorID, msg.file, err)
+		}
+		t.mu.Lock()
+		t.remove(msg)
+		t.mu.Unlock()
+	}
+}
+
+// remove deletes a message from the pending list. It is looked up rather than
+// assumed to be first, for a message due earlier could have been added while
+// it was being produced.
+func (t *T) remove(msg *Message) {
+	for i := range t.pending {
+		if t.pending[i] == msg {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+type byDeliverAt []*Message
+
+func (a byDeliverAt) Len() int           { return len(a) }
+func (a byDeliverAt) Less(i, j int) bool { return a[i].DeliverAt.Before(a[j].DeliverAt) }
+func (a byDeliverAt) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
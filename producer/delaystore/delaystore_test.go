@@ -0,0 +1,121 @@
+package delaystore
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type DelayStoreSuite struct {
+	dir       string
+	mu        sync.Mutex
+	produced  []string
+	failures  int
+	deliverCh chan string
+}
+
+var _ = Suite(&DelayStoreSuite{})
+
+func (s *DelayStoreSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.produced = nil
+	s.failures = 0
+	s.deliverCh = make(chan string, 100)
+}
+
+func (s *DelayStoreSuite) produce(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("kaboom")
+	}
+	s.produced = append(s.produced, string(msg.Value))
+	s.deliverCh <- string(msg.Value)
+	return nil
+}
+
+func (s *DelayStoreSuite) waitDelivered(c *C, count int) []string {
+	for i := 0; i < count; i++ {
+		select {
+		case <-s.deliverCh:
+		case <-time.After(3 * time.Second):
+			c.Fatalf("timeout waiting for message #%d", i)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.produced...)
+}
+
+// Messages are produced in the order they are due, rather than added.
+func (s *DelayStoreSuite) TestOrder(c *C) {
+	ds, err := Spawn(actor.RootID, s.dir, time.Second, s.produce)
+	c.Assert(err, IsNil)
+	defer ds.Stop()
+	now := time.Now()
+
+	// When
+	c.Assert(ds.Add(Message{Topic: "foo", Value: []byte("3"), DeliverAt: now.Add(300 * time.Millisecond)}), IsNil)
+	c.Assert(ds.Add(Message{Topic: "foo", Value: []byte("1"), DeliverAt: now.Add(100 * time.Millisecond)}), IsNil)
+	c.Assert(ds.Add(Message{Topic: "foo", Value: []byte("2"), DeliverAt: now.Add(200 * time.Millisecond)}), IsNil)
+	c.Assert(ds.Add(Message{Topic: "foo", Value: []byte("0"), DeliverAt: now.Add(-time.Second)}), IsNil)
+
+	// Then
+	c.Assert(s.waitDelivered(c, 4), DeepEquals, []string{"0", "1", "2", "3"})
+	c.Assert(ds.Count(), Equals, 0)
+	files, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+// Messages that were not due when the store was stopped are produced after
+// it is spawned again.
+func (s *DelayStoreSuite) TestRestart(c *C) {
+	ds, err := Spawn(actor.RootID, s.dir, time.Second, s.produce)
+	c.Assert(err, IsNil)
+	deliverAt := time.Now().Add(500 * time.Millisecond)
+	c.Assert(ds.Add(Message{Topic: "foo", Key: []byte("bar"), Value: []byte("1"), DeliverAt: deliverAt}), IsNil)
+	c.Assert(ds.Add(Message{Topic: "foo", Value: []byte("2"), DeliverAt: deliverAt}), IsNil)
+	ds.Stop()
+	// A leftover of an interrupted write is ignored.
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "garbage.json.tmp"), []byte("{"), 0600), IsNil)
+
+	// When
+	ds, err = Spawn(actor.RootID, s.dir, time.Second, s.produce)
+	c.Assert(err, IsNil)
+	defer ds.Stop()
+
+	// Then
+	c.Assert(ds.Count(), Equals, 2)
+	c.Assert(s.waitDelivered(c, 2), DeepEquals, []string{"1", "2"})
+	c.Assert(time.Now().Before(deliverAt), Equals, false)
+	files, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+// If a message fails to be produced, then it is retried after a backoff.
+func (s *DelayStoreSuite) TestRetry(c *C) {
+	s.failures = 2
+	ds, err := Spawn(actor.RootID, s.dir, 50*time.Millisecond, s.produce)
+	c.Assert(err, IsNil)
+	defer ds.Stop()
+
+	// When
+	c.Assert(ds.Add(Message{Topic: "foo", Value: []byte("1"), DeliverAt: time.Now()}), IsNil)
+
+	// Then
+	c.Assert(s.waitDelivered(c, 1), DeepEquals, []string{"1"})
+	c.Assert(s.failures, Equals, 0)
+}
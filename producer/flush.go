@@ -0,0 +1,118 @@
+package producer
+
+import (
+	"sync"
+	"time"
+)
+
+// FlushResult tells what happened to messages that were pending when a flush
+// was requested.
+type FlushResult struct {
+	Acked  int
+	Failed int
+
+	// Messages that were neither acknowledged nor failed by the time the
+	// flush timed out.
+	Pending int
+}
+
+// Add returns the sum of two flush results.
+func (fr FlushResult) Add(other FlushResult) FlushResult {
+	return FlushResult{
+		Acked:   fr.Acked + other.Acked,
+		Failed:  fr.Failed + other.Failed,
+		Pending: fr.Pending + other.Pending,
+	}
+}
+
+// flushTracker keeps track of messages submitted to a producer that are not
+// done yet, that is neither acknowledged nor failed, and lets flushes wait
+// for all messages submitted before them to be done. Messages are numbered in
+// the order they are submitted.
+type flushTracker struct {
+	mu      sync.Mutex
+	lastSeq int64
+	pending map[int64]bool
+	flushes []*pendingFlush
+}
+
+type pendingFlush struct {
+	upToSeq int64
+	result  FlushResult
+	doneCh  chan FlushResult
+}
+
+func newFlushTracker() *flushTracker {
+	return &flushTracker{pending: make(map[int64]bool)}
+}
+
+// submitted registers a new pending message and returns its sequence number.
+func (ft *flushTracker) submitted() int64 {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.lastSeq++
+	ft.pending[ft.lastSeq] = true
+	return ft.lastSeq
+}
+
+// done reports the outcome of a pending message to flushes waiting for it.
+func (ft *flushTracker) done(seq int64, err error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if !ft.pending[seq] {
+		return
+	}
+	delete(ft.pending, seq)
+	flushes := ft.flushes[:0]
+	for _, pf := range ft.flushes {
+		if seq <= pf.upToSeq {
+			if err == nil {
+				pf.result.Acked++
+			} else {
+				pf.result.Failed++
+			}
+			pf.result.Pending--
+			if pf.result.Pending == 0 {
+				pf.doneCh <- pf.result
+				continue
+			}
+		}
+		flushes = append(flushes, pf)
+	}
+	ft.flushes = flushes
+}
+
+// flush waits for all messages that are pending at the time of the call to
+// be done, but no longer than `timeout`.
+func (ft *flushTracker) flush(timeout time.Duration) FlushResult {
+	ft.mu.Lock()
+	if len(ft.pending) == 0 {
+		ft.mu.Unlock()
+		return FlushResult{}
+	}
+	// Messages are numbered as they are submitted, so all pending ones have
+	// sequence numbers up to the last one.
+	pf := &pendingFlush{
+		upToSeq: ft.lastSeq,
+		result:  FlushResult{Pending: len(ft.pending)},
+		doneCh:  make(chan FlushResult, 1),
+	}
+	ft.flushes = append(ft.flushes, pf)
+	ft.mu.Unlock()
+
+	select {
+	case result := <-pf.doneCh:
+		return result
+	case <-time.After(timeout):
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for i, other := range ft.flushes {
+		if other == pf {
+			ft.flushes = append(ft.flushes[:i], ft.flushes[i+1:]...)
+			return pf.result
+		}
+	}
+	// The flush completed right as it timed out.
+	return <-pf.doneCh
+}
@@ -0,0 +1,48 @@
+package producer
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type FlushTrackerSuite struct{}
+
+var _ = Suite(&FlushTrackerSuite{})
+
+// A flush waits for messages submitted before it only, and counts their
+// outcomes.
+func (s *FlushTrackerSuite) TestFlush(c *C) {
+	ft := newFlushTracker()
+	seq1 := ft.submitted()
+	seq2 := ft.submitted()
+	resultCh := make(chan FlushResult, 1)
+	go func() { resultCh <- ft.flush(3 * time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+	seq3 := ft.submitted()
+
+	// When
+	ft.done(seq1, nil)
+	ft.done(seq3, nil)
+	ft.done(seq2, errors.New("kaboom"))
+
+	// Then
+	c.Assert(<-resultCh, Equals, FlushResult{Acked: 1, Failed: 1})
+}
+
+// A flush with nothing pending returns right away, and a flush that times out
+// reports messages that are not done yet.
+func (s *FlushTrackerSuite) TestFlushTimeout(c *C) {
+	ft := newFlushTracker()
+	c.Assert(ft.flush(time.Hour), Equals, FlushResult{})
+	seq1 := ft.submitted()
+	ft.submitted()
+	ft.done(seq1, nil)
+
+	// When
+	result := ft.flush(50 * time.Millisecond)
+
+	// Then
+	c.Assert(result, Equals, FlushResult{Pending: 1})
+}
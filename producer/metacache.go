@@ -0,0 +1,214 @@
+package producer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+// MetadataStats describes the state of the producer topic metadata cache.
+type MetadataStats struct {
+	Refreshes     int64
+	RefreshErrors int64
+	Invalidations int64
+	Topics        map[string]TopicMetadata
+}
+
+// TopicMetadata is a snapshot of topic metadata taken on the last refresh.
+type TopicMetadata struct {
+	Partitions  int
+	Leaders     map[int32]int32
+	RefreshedAt time.Time
+}
+
+// metadataClient is a subset of `sarama.Client` used by the metadata cache.
+type metadataClient interface {
+	RefreshMetadata(topics ...string) error
+	Partitions(topic string) ([]int32, error)
+	Leader(topic string, partitionID int32) (*sarama.Broker, error)
+}
+
+// metadataCache keeps metadata of topics that messages are produced to up to
+// date. All topics are refreshed every `refreshInterval`. When a produce
+// request fails because a partition leader moved, the topic is invalidated,
+// and all topics invalidated within `refreshBackoff` are refreshed with a
+// single metadata request. That prevents metadata request storms when a
+// broker restarts and produce requests to all its partitions fail at once.
+type metadataCache struct {
+	actorID         *actor.ID
+	clt             metadataClient
+	refreshInterval time.Duration
+	refreshBackoff  time.Duration
+	invalidatedCh   chan none.T
+	stopCh          chan none.T
+	wg              sync.WaitGroup
+
+	mu            sync.Mutex
+	topics        map[string]*TopicMetadata
+	invalidated   map[string]bool
+	refreshes     int64
+	refreshErrors int64
+	invalidations int64
+}
+
+func spawnMetadataCache(actorID *actor.ID, clt metadataClient, refreshInterval, refreshBackoff time.Duration) *metadataCache {
+	mc := &metadataCache{
+		actorID:         actorID,
+		clt:             clt,
+		refreshInterval: refreshInterval,
+		refreshBackoff:  refreshBackoff,
+		invalidatedCh:   make(chan none.T, 1),
+		stopCh:          make(chan none.T),
+		topics:          make(map[string]*TopicMetadata),
+		invalidated:     make(map[string]bool),
+	}
+	actor.Spawn(mc.actorID, &mc.wg, mc.run)
+	return mc
+}
+
+func (mc *metadataCache) stop() {
+	close(mc.stopCh)
+	mc.wg.Wait()
+}
+
+// touch makes the cache track metadata of a topic.
+func (mc *metadataCache) touch(topic string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, ok := mc.topics[topic]; !ok {
+		mc.topics[topic] = &TopicMetadata{}
+	}
+}
+
+// invalidate schedules refresh of a topic metadata.
+func (mc *metadataCache) invalidate(topic string) {
+	mc.mu.Lock()
+	if _, ok := mc.topics[topic]; !ok {
+		mc.topics[topic] = &TopicMetadata{}
+	}
+	mc.invalidated[topic] = true
+	mc.invalidations++
+	mc.mu.Unlock()
+	select {
+	case mc.invalidatedCh <- none.V:
+	default:
+	}
+}
+
+// warmUp makes the cache track metadata of topics, and refreshes it right
+// away rather than on the next periodic refresh.
+func (mc *metadataCache) warmUp(topics []string) error {
+	for _, topic := range topics {
+		mc.touch(topic)
+	}
+	sorted := append([]string(nil), topics...)
+	sort.Strings(sorted)
+	return mc.refresh(sorted)
+}
+
+func (mc *metadataCache) stats() MetadataStats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	stats := MetadataStats{
+		Refreshes:     mc.refreshes,
+		RefreshErrors: mc.refreshErrors,
+		Invalidations: mc.invalidations,
+		Topics:        make(map[string]TopicMetadata, len(mc.topics)),
+	}
+	for topic, tm := range mc.topics {
+		stats.Topics[topic] = *tm
+	}
+	return stats
+}
+
+func (mc *metadataCache) run() {
+	ticker := time.NewTicker(mc.refreshInterval)
+	defer ticker.Stop()
+	var lastRefreshedAt time.Time
+	var nilOrBackoffCh <-chan time.Time
+	for {
+		select {
+		case <-ticker.C:
+			mc.refresh(mc.takeTopics(true))
+			lastRefreshedAt = time.Now()
+		case <-mc.invalidatedCh:
+			if nilOrBackoffCh == nil {
+				nilOrBackoffCh = time.After(mc.refreshBackoff - time.Since(lastRefreshedAt))
+			}
+		case <-nilOrBackoffCh:
+			nilOrBackoffCh = nil
+			mc.refresh(mc.takeTopics(false))
+			lastRefreshedAt = time.Now()
+		case <-mc.stopCh:
+			return
+		}
+	}
+}
+
+// takeTopics returns sorted names of invalidated topics, or all tracked
+// topics if `all` is true, and clears the invalidated set.
+func (mc *metadataCache) takeTopics(all bool) []string {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var topics []string
+	if all {
+		for topic := range mc.topics {
+			topics = append(topics, topic)
+		}
+	} else {
+		for topic := range mc.invalidated {
+			topics = append(topics, topic)
+		}
+	}
+	mc.invalidated = make(map[string]bool)
+	sort.Strings(topics)
+	return topics
+}
+
+func (mc *metadataCache) refresh(topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	err := mc.clt.RefreshMetadata(topics...)
+	if err != nil {
+		log.Errorf("<%s> failed to refresh metadata: topics=%v, err=(%s)", mc.actorID, topics, err)
+	}
+	snapshots := make(map[string]TopicMetadata, len(topics))
+	if err == nil {
+		now := time.Now().UTC()
+		for _, topic := range topics {
+			partitions, err := mc.clt.Partitions(topic)
+			if err != nil {
+				continue
+			}
+			tm := TopicMetadata{Partitions: len(partitions), Leaders: make(map[int32]int32), RefreshedAt: now}
+			for _, partition := range partitions {
+				if leader, err := mc.clt.Leader(topic, partition); err == nil {
+					tm.Leaders[partition] = leader.ID()
+				}
+			}
+			snapshots[topic] = tm
+		}
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.refreshes++
+	if err != nil {
+		mc.refreshErrors++
+		return err
+	}
+	for topic, tm := range snapshots {
+		tm := tm
+		if prev := mc.topics[topic]; prev != nil && prev.Partitions > 0 && tm.Partitions > prev.Partitions {
+			log.Infof("<%s> partitions added: topic=%s, count=%d->%d", mc.actorID, topic, prev.Partitions, tm.Partitions)
+		}
+		mc.topics[topic] = &tm
+	}
+	return nil
+}
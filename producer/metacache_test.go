@@ -0,0 +1,101 @@
+package producer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+type MetadataCacheSuite struct {
+	ns *actor.ID
+}
+
+var _ = Suite(&MetadataCacheSuite{})
+
+func (s *MetadataCacheSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+}
+
+type fakeMetadataClient struct {
+	mu        sync.Mutex
+	refreshes [][]string
+}
+
+func (fmc *fakeMetadataClient) RefreshMetadata(topics ...string) error {
+	fmc.mu.Lock()
+	defer fmc.mu.Unlock()
+	fmc.refreshes = append(fmc.refreshes, topics)
+	return nil
+}
+
+func (fmc *fakeMetadataClient) Partitions(topic string) ([]int32, error) {
+	return []int32{0, 1}, nil
+}
+
+func (fmc *fakeMetadataClient) Leader(topic string, partitionID int32) (*sarama.Broker, error) {
+	return sarama.NewBroker("localhost:9092"), nil
+}
+
+func (fmc *fakeMetadataClient) getRefreshes() [][]string {
+	fmc.mu.Lock()
+	defer fmc.mu.Unlock()
+	return fmc.refreshes
+}
+
+// Topics invalidated within the refresh backoff are refreshed with a single
+// metadata request.
+func (s *MetadataCacheSuite) TestInvalidateCoalesced(c *C) {
+	clt := &fakeMetadataClient{}
+	mc := spawnMetadataCache(s.ns, clt, time.Hour, 100*time.Millisecond)
+	defer mc.stop()
+
+	// When
+	mc.invalidate("foo")
+	mc.invalidate("bar")
+	mc.invalidate("foo")
+	time.Sleep(300 * time.Millisecond)
+
+	// Then
+	c.Assert(clt.getRefreshes(), DeepEquals, [][]string{{"bar", "foo"}})
+	stats := mc.stats()
+	c.Assert(stats.Refreshes, Equals, int64(1))
+	c.Assert(stats.Invalidations, Equals, int64(3))
+	c.Assert(stats.Topics["foo"].Partitions, Equals, 2)
+	c.Assert(stats.Topics["foo"].Leaders, DeepEquals, map[int32]int32{0: -1, 1: -1})
+	c.Assert(stats.Topics["foo"].RefreshedAt.IsZero(), Equals, false)
+}
+
+// All tracked topics are refreshed periodically.
+func (s *MetadataCacheSuite) TestPeriodicRefresh(c *C) {
+	clt := &fakeMetadataClient{}
+	mc := spawnMetadataCache(s.ns, clt, 100*time.Millisecond, time.Second)
+	defer mc.stop()
+
+	// When
+	mc.touch("foo")
+	mc.touch("bar")
+	time.Sleep(150 * time.Millisecond)
+
+	// Then
+	c.Assert(clt.getRefreshes(), DeepEquals, [][]string{{"bar", "foo"}})
+	c.Assert(mc.stats().Topics["bar"].Partitions, Equals, 2)
+}
+
+// Warmed up topics are refreshed right away and tracked afterwards.
+func (s *MetadataCacheSuite) TestWarmUp(c *C) {
+	clt := &fakeMetadataClient{}
+	mc := spawnMetadataCache(s.ns, clt, time.Hour, time.Second)
+	defer mc.stop()
+
+	// When
+	err := mc.warmUp([]string{"foo", "bar"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(clt.getRefreshes(), DeepEquals, [][]string{{"bar", "foo"}})
+	c.Assert(mc.stats().Topics["foo"].Partitions, Equals, 2)
+	c.Assert(mc.takeTopics(true), DeepEquals, []string{"bar", "foo"})
+}
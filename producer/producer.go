@@ -3,11 +3,13 @@ package producer
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/log"
 	"github.com/pkg/errors"
 )
@@ -29,11 +31,25 @@ type T struct {
 	dispatcherActorID *actor.ID
 	saramaClient      sarama.Client
 	saramaProducer    sarama.AsyncProducer
+	metadataCache     *metadataCache
+	flushes           *flushTracker
 	shutdownTimeout   time.Duration
+	slowThreshold     time.Duration
+	requiredAcks      string
+	stampTimestamps   bool
+	maxBufferedMsgs   int64
+	maxBufferedBytes  int64
+	metrics           *metrics.Registry
 	dispatcherCh      chan *sarama.ProducerMessage
 	resultCh          chan produceResult
 	wg                sync.WaitGroup
 
+	// Messages handed over to `saramaProducer` that have not been acknowledged
+	// or failed yet. They are updated by the dispatcher goroutine only, but
+	// read by metric gauges.
+	bufferedMsgs  int64
+	bufferedBytes int64
+
 	// To be used in tests only
 	testDroppedMsgCh chan<- *sarama.ProducerMessage
 }
@@ -43,11 +59,43 @@ type produceResult struct {
 	Err error
 }
 
+// produceCtx is stored in the metadata of all produced messages.
+type produceCtx struct {
+	// Nil for asynchronously produced messages.
+	replyCh   chan produceResult
+	startedAt time.Time
+	seq       int64
+
+	// Only set for asynchronously produced messages that have their
+	// outcomes reported.
+	callback func(msg *sarama.ProducerMessage, err error)
+}
+
 // Spawn creates a producer instance and starts its internal goroutines.
 func Spawn(namespace *actor.ID, cfg *config.Proxy) (*T, error) {
+	return SpawnWithOpts(namespace, cfg, Opts{})
+}
+
+// Opts are optional parameters of a producer.
+type Opts struct {
+	// If given, then latencies of all produce requests are recorded to the
+	// `produce.latency` timer of the registry tagged by topic and required
+	// acks, failures to the `produce.errors` counter, and the depth of the
+	// dispatch queue to the `producer.dispatch_queue.depth` gauge tagged by
+	// required acks. Messages and bytes buffered by the Kafka client are
+	// recorded to the `producer.buffer.messages` and `producer.buffer.bytes`
+	// gauges tagged by required acks.
+	Metrics *metrics.Registry
+}
+
+// SpawnWithOpts is like Spawn, but takes optional parameters.
+func SpawnWithOpts(namespace *actor.ID, cfg *config.Proxy, opts Opts) (*T, error) {
 	saramaCfg := cfg.SaramaProdCfg()
 	saramaCfg.Producer.Return.Successes = true
 	saramaCfg.Producer.Return.Errors = true
+	// Metadata of topics that messages are produced to is refreshed by
+	// metadataCache.
+	saramaCfg.Metadata.RefreshFrequency = 0
 
 	saramaClient, err := sarama.NewClient(cfg.Kafka.SeedPeers, saramaCfg)
 	if err != nil {
@@ -65,9 +113,24 @@ func Spawn(namespace *actor.ID, cfg *config.Proxy) (*T, error) {
 		saramaClient:      saramaClient,
 		saramaProducer:    saramaProducer,
 		shutdownTimeout:   cfg.Producer.ShutdownTimeout,
-		dispatcherCh:      make(chan *sarama.ProducerMessage, cfg.Producer.ChannelBufferSize),
+		slowThreshold:     cfg.Producer.SlowProduceThreshold,
+		requiredAcks:      cfg.Producer.RequiredAcks,
+		stampTimestamps:   cfg.Producer.StampTimestamps,
+		maxBufferedMsgs:   int64(cfg.Producer.MaxBufferedMessages),
+		maxBufferedBytes:  int64(cfg.Producer.MaxBufferedBytes),
+		flushes:           newFlushTracker(),
+		metrics:           opts.Metrics,
+		dispatcherCh:      make(chan *sarama.ProducerMessage, cfg.ProducerDispatchQueueSize()),
 		resultCh:          make(chan produceResult, cfg.Producer.ChannelBufferSize),
 	}
+	opts.Metrics.GaugeFunc("producer.dispatch_queue.depth", func() int64 { return int64(len(p.dispatcherCh)) },
+		"acks", p.requiredAcks)
+	opts.Metrics.GaugeFunc("producer.buffer.messages", func() int64 { return atomic.LoadInt64(&p.bufferedMsgs) },
+		"acks", p.requiredAcks)
+	opts.Metrics.GaugeFunc("producer.buffer.bytes", func() int64 { return atomic.LoadInt64(&p.bufferedBytes) },
+		"acks", p.requiredAcks)
+	p.metadataCache = spawnMetadataCache(prodNamespace.NewChild("metadata"), saramaClient,
+		cfg.Producer.MetadataRefreshInterval, cfg.Producer.MetadataRefreshBackoff)
 	actor.Spawn(p.mergerActorID, &p.wg, p.runMerger)
 	actor.Spawn(p.dispatcherActorID, &p.wg, p.runDispatcher)
 	return p, nil
@@ -77,6 +140,25 @@ func Spawn(namespace *actor.ID, cfg *config.Proxy) (*T, error) {
 func (p *T) Stop() {
 	close(p.dispatcherCh)
 	p.wg.Wait()
+	p.metadataCache.stop()
+}
+
+// MetadataStats returns the state of the topic metadata cache.
+func (p *T) MetadataStats() MetadataStats {
+	return p.metadataCache.stats()
+}
+
+// InvalidateMetadata schedules a refresh of the topic metadata, e.g. when
+// partitions were added to the topic, so that messages are partitioned among
+// all of them without waiting for the next periodic refresh.
+func (p *T) InvalidateMetadata(topic string) {
+	p.metadataCache.invalidate(topic)
+}
+
+// WarmUp fetches metadata of the specified topics, so that the first messages
+// produced to them do not have to wait for it.
+func (p *T) WarmUp(topics ...string) error {
+	return p.metadataCache.warmUp(topics)
 }
 
 // Produce submits a message to the specified `topic` of the Kafka cluster
@@ -93,8 +175,9 @@ func (p *T) Produce(topic string, key, message sarama.Encoder) (*sarama.Producer
 		Topic:    topic,
 		Key:      key,
 		Value:    message,
-		Metadata: replyCh,
+		Metadata: &produceCtx{replyCh: replyCh, startedAt: time.Now(), seq: p.flushes.submitted()},
 	}
+	p.stamp(prodMsg)
 	p.dispatcherCh <- prodMsg
 	result := <-replyCh
 	return result.Msg, result.Err
@@ -104,13 +187,50 @@ func (p *T) Produce(topic string, key, message sarama.Encoder) (*sarama.Producer
 // Errors are silently ignored.
 func (p *T) AsyncProduce(topic string, key, message sarama.Encoder) {
 	prodMsg := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   key,
-		Value: message,
+		Topic:    topic,
+		Key:      key,
+		Value:    message,
+		Metadata: &produceCtx{startedAt: time.Now(), seq: p.flushes.submitted()},
 	}
+	p.stamp(prodMsg)
 	p.dispatcherCh <- prodMsg
 }
 
+// AsyncProduceWithCallback is like AsyncProduce, except `callback` is called
+// with the message once it is either acknowledged or failed. It is called by
+// an internal goroutine of the producer, and must not block.
+func (p *T) AsyncProduceWithCallback(topic string, key, message sarama.Encoder,
+	callback func(msg *sarama.ProducerMessage, err error),
+) {
+	prodMsg := &sarama.ProducerMessage{
+		Topic:    topic,
+		Key:      key,
+		Value:    message,
+		Metadata: &produceCtx{startedAt: time.Now(), seq: p.flushes.submitted(), callback: callback},
+	}
+	p.stamp(prodMsg)
+	p.dispatcherCh <- prodMsg
+}
+
+// stamp timestamps a message with the time it was submitted, if configured
+// so. Otherwise sarama timestamps it when it is added to a batch.
+//
+// TODO Stamp a header instead, once the vendored sarama supports record
+// headers, so that consumers can tell messages stamped by the proxy from
+// those timestamped by other producers.
+func (p *T) stamp(prodMsg *sarama.ProducerMessage) {
+	if p.stampTimestamps {
+		prodMsg.Timestamp = prodMsg.Metadata.(*produceCtx).startedAt
+	}
+}
+
+// Flush waits for all messages submitted to the producer by the time of the
+// call to be either acknowledged by Kafka or failed, but no longer than
+// `timeout`, and tells how many ended up which way.
+func (p *T) Flush(timeout time.Duration) FlushResult {
+	return p.flushes.flush(timeout)
+}
+
 // merge receives both message acknowledgements and producer errors from the
 // respective `sarama.AsyncProducer` channels, constructs `ProducerResult`s out
 // of them and sends the constructed `ProducerResult` instances to `resultCh`
@@ -151,11 +271,13 @@ mergeLoop:
 // submits them to the embedded `sarama.AsyncProducer`. The dispatcher main
 // purpose is to prevent loss of messages during shutdown. It achieves that by
 // allowing some graceful period after it stops receiving messages and stopping
-// the embedded `sarama.AsyncProducer`.
+// the embedded `sarama.AsyncProducer`. It also stops receiving messages while
+// the embedded `sarama.AsyncProducer` has as many of them buffered as allowed.
 func (p *T) runDispatcher() {
 	nilOrDispatcherCh := p.dispatcherCh
 	var nilOrProdInputCh chan<- *sarama.ProducerMessage
 	pendingMsgCount := 0
+	pendingBytes := 0
 	// The normal operation loop is implemented as two-stroke machine. On the
 	// first stroke a message is received from `dispatchCh`, and on the second
 	// it is sent to `prodInputCh`. Note that producer results can be received
@@ -169,14 +291,23 @@ func (p *T) runDispatcher() {
 				goto gracefulShutdown
 			}
 			pendingMsgCount += 1
+			pendingBytes += messageSize(prodMsg)
+			p.setBuffered(pendingMsgCount, pendingBytes)
 			nilOrDispatcherCh = nil
 			nilOrProdInputCh = p.saramaProducer.Input()
 		case nilOrProdInputCh <- prodMsg:
-			nilOrDispatcherCh = p.dispatcherCh
 			nilOrProdInputCh = nil
+			if !p.bufferFull(pendingMsgCount, pendingBytes) {
+				nilOrDispatcherCh = p.dispatcherCh
+			}
 		case prodResult := <-p.resultCh:
 			pendingMsgCount -= 1
+			pendingBytes -= messageSize(prodResult.Msg)
+			p.setBuffered(pendingMsgCount, pendingBytes)
 			p.handleProduceResult(prodResult)
+			if nilOrProdInputCh == nil && !p.bufferFull(pendingMsgCount, pendingBytes) {
+				nilOrDispatcherCh = p.dispatcherCh
+			}
 		}
 	}
 gracefulShutdown:
@@ -189,6 +320,8 @@ gracefulShutdown:
 			goto shutdownNow
 		case prodResult := <-p.resultCh:
 			pendingMsgCount -= 1
+			pendingBytes -= messageSize(prodResult.Msg)
+			p.setBuffered(pendingMsgCount, pendingBytes)
 			p.handleProduceResult(prodResult)
 		}
 	}
@@ -198,17 +331,61 @@ shutdownNow:
 	for prodResult := range p.resultCh {
 		p.handleProduceResult(prodResult)
 	}
+	p.setBuffered(0, 0)
+}
+
+// bufferFull tells whether the embedded `sarama.AsyncProducer` has as many
+// messages buffered as allowed. A message is always accepted into an empty
+// buffer, however large it is.
+func (p *T) bufferFull(msgCount, bytes int) bool {
+	return (p.maxBufferedMsgs > 0 && int64(msgCount) >= p.maxBufferedMsgs) ||
+		(p.maxBufferedBytes > 0 && int64(bytes) >= p.maxBufferedBytes)
+}
+
+func (p *T) setBuffered(msgCount, bytes int) {
+	atomic.StoreInt64(&p.bufferedMsgs, int64(msgCount))
+	atomic.StoreInt64(&p.bufferedBytes, int64(bytes))
+}
+
+// messageSize returns the total length of the key and value of a message.
+func messageSize(msg *sarama.ProducerMessage) int {
+	size := 0
+	if msg.Key != nil {
+		size += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		size += msg.Value.Length()
+	}
+	return size
 }
 
 // handleProduceResult inspects a production results and if it is an error
 // then logs it.
 func (p *T) handleProduceResult(result produceResult) {
-	if replyCh, ok := result.Msg.Metadata.(chan produceResult); ok {
-		replyCh <- result
+	if ctx, ok := result.Msg.Metadata.(*produceCtx); ok {
+		latency := time.Since(ctx.startedAt)
+		p.metrics.Timer("produce.latency", "topic", result.Msg.Topic, "acks", p.requiredAcks).Update(latency)
+		if p.slowThreshold > 0 && latency >= p.slowThreshold {
+			log.Warningf("<%v> slow produce: topic=%s, partition=%d, offset=%d, latency=%v, err=(%v)",
+				p.dispatcherActorID, result.Msg.Topic, result.Msg.Partition, result.Msg.Offset, latency, result.Err)
+		}
+		if ctx.replyCh != nil {
+			ctx.replyCh <- result
+		}
+		if ctx.callback != nil {
+			ctx.callback(result.Msg, result.Err)
+		}
+		p.flushes.done(ctx.seq, result.Err)
 	}
 	if result.Err == nil {
+		p.metadataCache.touch(result.Msg.Topic)
 		return
 	}
+	switch result.Err {
+	case sarama.ErrNotLeaderForPartition, sarama.ErrLeaderNotAvailable:
+		p.metadataCache.invalidate(result.Msg.Topic)
+	}
+	p.metrics.Counter("produce.errors", "topic", result.Msg.Topic, "acks", p.requiredAcks).Inc(1)
 	prodMsgRepr := fmt.Sprintf(`{Topic: "%s", Key: "%s", Value: "%s"}`,
 		result.Msg.Topic, encoderRepr(result.Msg.Key), encoderRepr(result.Msg.Value))
 	log.Errorf("<%v> Failed to submit message: msg=%v, err=(%s)",
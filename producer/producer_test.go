@@ -7,6 +7,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
 	"github.com/pkg/errors"
@@ -68,6 +69,24 @@ func (s *ProducerSuite) TestProduce(c *C) {
 	p.Stop()
 }
 
+// Latencies of both sync and async produce requests are recorded per topic
+// and required acks.
+func (s *ProducerSuite) TestProduceLatencyMetrics(c *C) {
+	registry := metrics.New()
+	p, _ := SpawnWithOpts(s.ns, s.cfg, Opts{Metrics: registry})
+
+	// When
+	_, err := p.Produce("test.4", sarama.StringEncoder("1"), sarama.StringEncoder("Foo"))
+	c.Assert(err, IsNil)
+	p.AsyncProduce("test.4", sarama.StringEncoder("1"), sarama.StringEncoder("Bar"))
+	p.Stop()
+
+	// Then
+	timer := registry.Timer("produce.latency", "topic", "test.4", "acks", "wait_for_all")
+	c.Assert(timer.Count(), Equals, int64(2))
+	c.Assert(timer.Max() > 0, Equals, true)
+}
+
 func (s *ProducerSuite) TestProduceInvalidTopic(c *C) {
 	p, _ := Spawn(s.ns, s.cfg)
 
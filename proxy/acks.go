@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/pkg/errors"
+)
+
+// Levels of acknowledgement reliability that produce requests can ask for,
+// see `Producer.AllowedAcks`.
+const (
+	AcksNone   = "none"
+	AcksLeader = "leader"
+	AcksAll    = "all"
+)
+
+// ErrAcksNotAllowed is returned when a produce request asks for a level of
+// acknowledgement reliability that is not allowed by the proxy config.
+var ErrAcksNotAllowed = errors.New("acks level not allowed")
+
+// ackLevels maps names of acknowledgement levels in the config to their
+// names in the API.
+var ackLevels = map[string]string{
+	"no_response":    AcksNone,
+	"wait_for_local": AcksLeader,
+	"wait_for_all":   AcksAll,
+}
+
+// spawnAckProducers makes a producer available for every level of
+// acknowledgement reliability that produce requests may ask for. The default
+// level is served by the main producer.
+func (p *T) spawnAckProducers(cfg *config.Proxy) error {
+	p.defaultAcks = ackLevels[cfg.Producer.RequiredAcks]
+	p.ackProducers = map[string]producerT{p.defaultAcks: p.producer}
+	for _, cfgAcks := range cfg.Producer.AllowedAcks {
+		acks := ackLevels[cfgAcks]
+		if _, ok := p.ackProducers[acks]; ok {
+			continue
+		}
+		// The in-memory cluster commits messages right away, whatever the
+		// level.
+		if cfg.InMemory.Enabled {
+			p.ackProducers[acks] = p.producer
+			continue
+		}
+		ackCfg := *cfg
+		ackCfg.Producer.RequiredAcks = cfgAcks
+		prod, err := producer.SpawnWithOpts(p.actorID.NewChild("acks_"+acks), &ackCfg, producer.Opts{Metrics: p.metrics})
+		if err != nil {
+			return errors.Wrapf(err, "failed to spawn producer, acks=%s", acks)
+		}
+		p.ackProducers[acks] = prod
+	}
+	return nil
+}
+
+// producerFor returns the producer of the acknowledgement level that a
+// produce request asked for, along with the effective level. An empty
+// `acks` means the default level.
+func (p *T) producerFor(acks string) (producerT, string, error) {
+	if acks == "" {
+		return p.producer, p.defaultAcks, nil
+	}
+	prod, ok := p.ackProducers[acks]
+	if !ok {
+		return nil, "", errors.Wrapf(ErrAcksNotAllowed, "acks=%s", acks)
+	}
+	return prod, acks, nil
+}
+
+// DefaultAcks returns the acknowledgement level that messages are produced
+// with unless a produce request asks for another one.
+func (p *T) DefaultAcks() string {
+	return p.defaultAcks
+}
+
+// stopAckProducers stops producers of levels other than the default one.
+func (p *T) stopAckProducers() {
+	for _, prod := range p.ackProducers {
+		if prod != p.producer {
+			prod.Stop()
+		}
+	}
+}
+
+// Flush waits for all messages produced via the proxy by the time of the call,
+// with any acknowledgement level, to be either acknowledged by Kafka or
+// failed, but no longer than `timeout`, or `producer.shutdown_timeout` if it
+// is zero. Delayed messages that are not due yet are not flushed.
+func (p *T) Flush(timeout time.Duration) producer.FlushResult {
+	if timeout <= 0 {
+		timeout = p.cfg.Producer.ShutdownTimeout
+	}
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		result producer.FlushResult
+	)
+	flushed := make(map[producerT]bool, len(p.ackProducers))
+	for _, prod := range p.ackProducers {
+		if flushed[prod] {
+			continue
+		}
+		flushed[prod] = true
+		wg.Add(1)
+		go func(prod producerT) {
+			defer wg.Done()
+			prodResult := prod.Flush(timeout)
+			mu.Lock()
+			result = result.Add(prodResult)
+			mu.Unlock()
+		}(prod)
+	}
+	wg.Wait()
+	return result
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type AcksSuite struct {
+	pxy *T
+}
+
+var _ = Suite(&AcksSuite{})
+
+func (s *AcksSuite) SetUpTest(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.InMemory.Partitions = 1
+	cfg.Producer.AllowedAcks = []string{"wait_for_local"}
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "acks", cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *AcksSuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+}
+
+// If a produce request does not ask for an acknowledgement level, then the
+// default one is used and reported.
+func (s *AcksSuite) TestProduceDefaultAcks(c *C) {
+	// When
+	prodMsg, acks, err := s.pxy.ProduceWithAcks("foo", nil, sarama.StringEncoder("bar"), "")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(prodMsg.Offset, Equals, int64(0))
+	c.Assert(acks, Equals, AcksAll)
+	c.Assert(s.pxy.DefaultAcks(), Equals, AcksAll)
+}
+
+// The default level and the levels listed in the config can be asked for.
+func (s *AcksSuite) TestProduceAllowedAcks(c *C) {
+	// When
+	_, leaderAcks, leaderErr := s.pxy.ProduceWithAcks("foo", nil, sarama.StringEncoder("bar"), AcksLeader)
+	_, allAcks, allErr := s.pxy.ProduceWithAcks("foo", nil, sarama.StringEncoder("bar"), AcksAll)
+	asyncAcks, asyncErr := s.pxy.AsyncProduceWithAcks("foo", nil, sarama.StringEncoder("bar"), AcksLeader)
+
+	// Then
+	c.Assert(leaderErr, IsNil)
+	c.Assert(leaderAcks, Equals, AcksLeader)
+	c.Assert(allErr, IsNil)
+	c.Assert(allAcks, Equals, AcksAll)
+	c.Assert(asyncErr, IsNil)
+	c.Assert(asyncAcks, Equals, AcksLeader)
+}
+
+// Levels that are not listed in the config are rejected.
+func (s *AcksSuite) TestProduceAcksNotAllowed(c *C) {
+	// When
+	_, _, err := s.pxy.ProduceWithAcks("foo", nil, sarama.StringEncoder("bar"), AcksNone)
+	_, asyncErr := s.pxy.AsyncProduceWithAcks("foo", nil, sarama.StringEncoder("bar"), "bogus")
+	delayedErr := s.pxy.ProduceAtWithAcks("foo", nil, sarama.StringEncoder("bar"), time.Now().Add(time.Hour), AcksLeader)
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrAcksNotAllowed)
+	c.Assert(errors.Cause(asyncErr), Equals, ErrAcksNotAllowed)
+	c.Assert(errors.Cause(delayedErr), Equals, ErrAcksNotAllowed)
+	c.Assert(s.pxy.topicStats.Stats("foo").Produced.Messages, Equals, int64(0))
+}
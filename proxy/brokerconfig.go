@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/pkg/errors"
+)
+
+// ErrBrokerConfigsReadOnly is returned by AlterBrokerConfigs unless
+// `admin.alter_broker_configs` is enabled.
+var ErrBrokerConfigsReadOnly = errors.New("broker configs are read-only")
+
+// DescribeBrokerConfigs returns dynamic config entries of a broker, e.g. to
+// verify settings like `message.max.bytes` that affect the proxy behavior.
+func (p *T) DescribeBrokerConfigs(brokerID int32) ([]admin.BrokerConfig, error) {
+	return p.admin.DescribeBrokerConfigs(brokerID)
+}
+
+// AlterBrokerConfigs sets and removes dynamic config entries of a broker as
+// `alter` prescribes, a nil value removes an entry. It fails with
+// ErrBrokerConfigsReadOnly unless `admin.alter_broker_configs` is enabled.
+func (p *T) AlterBrokerConfigs(brokerID int32, alter map[string]*string) error {
+	if !p.cfg.Admin.AlterBrokerConfigs {
+		return ErrBrokerConfigsReadOnly
+	}
+	return p.admin.AlterBrokerConfigs(brokerID, alter)
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// ErrCopyNotFound is returned when a copy job with the requested ID does not
+// exist.
+var ErrCopyNotFound = errors.New("copy not found")
+
+// CopyRq describes a range of messages of a partition to be copied to another
+// topic. Bounds are given either as offsets or as timestamps, a timestamp
+// bound takes precedence over the respective offset bound. A zero ToOffset
+// with no ToTime means up to the end of the partition as of when the copy
+// starts.
+type CopyRq struct {
+	Partition  int32
+	FromOffset int64
+	ToOffset   int64
+	FromTime   time.Time
+	ToTime     time.Time
+
+	// Cluster that messages are copied to, it is only used for reporting.
+	ToCluster string
+	ToTopic   string
+}
+
+// CopyProgress reports the state of a copy job.
+type CopyProgress struct {
+	ID        string
+	Topic     string
+	Partition int32
+	ToCluster string
+	ToTopic   string
+
+	// Resolved range of offsets to copy, ToOffset is exclusive.
+	FromOffset int64
+	ToOffset   int64
+
+	// Offset of the next message to copy.
+	NextOffset int64
+	Copied     int64
+
+	Started  time.Time
+	Finished time.Time
+	Error    string
+}
+
+// Done tells whether the copy job has finished, either successfully or not.
+func (cp CopyProgress) Done() bool {
+	return !cp.Finished.IsZero()
+}
+
+type copyJob struct {
+	mu       sync.Mutex
+	progress CopyProgress
+}
+
+func (j *copyJob) snapshot() CopyProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// Copy starts copying a range of messages from `topic` to another topic of
+// the proxy `dst`, that can be this proxy too. Messages are read from Kafka
+// directly and produced with the same keys, so they land in the partitions of
+// the target topic that the keys map to. Copying goes on in the background,
+// the returned progress has an ID the job can be looked up by with
+// CopyProgress.
+//
+// TODO Preserve message headers. The vendored sarama predates record
+// headers, so neither the consumer nor the producer has them.
+func (p *T) Copy(topic string, rq CopyRq, dst *T) (CopyProgress, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return CopyProgress{}, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return CopyProgress{}, err
+	}
+	toTopic, err := dst.topicName(rq.ToTopic)
+	if err != nil {
+		return CopyProgress{}, err
+	}
+	if err := dst.prodACL.check(toTopic); err != nil {
+		return CopyProgress{}, err
+	}
+	begin, end, err := p.admin.PartitionOffsets(topic, rq.Partition)
+	if err != nil {
+		return CopyProgress{}, err
+	}
+	fromOffset, toOffset := rq.FromOffset, rq.ToOffset
+	if !rq.FromTime.IsZero() {
+		if fromOffset, err = p.admin.OffsetForTime(topic, rq.Partition, rq.FromTime); err != nil {
+			return CopyProgress{}, err
+		}
+	}
+	switch {
+	case !rq.ToTime.IsZero():
+		if toOffset, err = p.admin.OffsetForTime(topic, rq.Partition, rq.ToTime); err != nil {
+			return CopyProgress{}, err
+		}
+	case toOffset == 0:
+		toOffset = end
+	}
+	// The end is fixed when the copy starts, so that copying to the same
+	// topic does not chase its own messages.
+	if fromOffset < begin {
+		fromOffset = begin
+	}
+	if toOffset > end {
+		toOffset = end
+	}
+	if toOffset < fromOffset {
+		toOffset = fromOffset
+	}
+
+	p.copiesMu.Lock()
+	job := &copyJob{progress: CopyProgress{
+		ID:         strconv.Itoa(len(p.copies) + 1),
+		Topic:      topic,
+		Partition:  rq.Partition,
+		ToCluster:  rq.ToCluster,
+		ToTopic:    toTopic,
+		FromOffset: fromOffset,
+		ToOffset:   toOffset,
+		NextOffset: fromOffset,
+		Started:    time.Now().UTC(),
+	}}
+	p.copies = append(p.copies, job)
+	p.copiesMu.Unlock()
+
+	progress := job.snapshot()
+	log.Infof("<%s> copy started: id=%s, topic=%s, partition=%d, offsets=[%d, %d), to=%s/%s",
+		p.actorID, progress.ID, topic, rq.Partition, fromOffset, toOffset, rq.ToCluster, toTopic)
+	actor.Spawn(p.actorID.NewChild("copy", progress.ID), &p.copiesWg, func() {
+		p.runCopy(job, dst)
+	})
+	return progress, nil
+}
+
+// CopyProgress returns the progress of a copy job.
+func (p *T) CopyProgress(id string) (CopyProgress, error) {
+	p.copiesMu.Lock()
+	defer p.copiesMu.Unlock()
+	for _, job := range p.copies {
+		if job.progress.ID == id {
+			return job.snapshot(), nil
+		}
+	}
+	return CopyProgress{}, errors.Wrapf(ErrCopyNotFound, "id=%s", id)
+}
+
+// Copies returns the progress of all copy jobs started since the proxy was
+// spawned, in the order they were started.
+func (p *T) Copies() []CopyProgress {
+	p.copiesMu.Lock()
+	progresses := make([]CopyProgress, 0, len(p.copies))
+	for _, job := range p.copies {
+		progresses = append(progresses, job.snapshot())
+	}
+	p.copiesMu.Unlock()
+	return progresses
+}
+
+// runCopy copies messages in batches until the end of the range is reached,
+// an error occurs, or the proxy is stopped.
+func (p *T) runCopy(job *copyJob, dst *T) {
+	progress := job.snapshot()
+	offset := progress.NextOffset
+	err := func() error {
+		for offset < progress.ToOffset {
+			select {
+			case <-p.copiesStopCh:
+				return errors.New("proxy stopped")
+			default:
+			}
+			count := p.cfg.Consumer.ChannelBufferSize
+			if remaining := progress.ToOffset - offset; remaining < int64(count) {
+				count = int(remaining)
+			}
+			messages, err := p.admin.ReadMessages(progress.Topic, progress.Partition, offset, count)
+			if err != nil {
+				return err
+			}
+			if len(messages) == 0 {
+				// Messages have been removed by retention since the copy
+				// started.
+				return nil
+			}
+			for _, msg := range messages {
+				if msg.Offset >= progress.ToOffset {
+					break
+				}
+				var key sarama.Encoder
+				if msg.Key != nil {
+					key = sarama.ByteEncoder(msg.Key)
+				}
+				if _, err := dst.Produce(progress.ToTopic, key, sarama.ByteEncoder(msg.Value)); err != nil {
+					return errors.Wrapf(err, "failed to copy offset=%d", msg.Offset)
+				}
+				offset = msg.Offset + 1
+				job.mu.Lock()
+				job.progress.NextOffset = offset
+				job.progress.Copied++
+				job.mu.Unlock()
+			}
+		}
+		return nil
+	}()
+
+	job.mu.Lock()
+	job.progress.Finished = time.Now().UTC()
+	if err != nil {
+		job.progress.Error = err.Error()
+	}
+	progress = job.progress
+	job.mu.Unlock()
+	if err != nil {
+		log.Errorf("<%s> copy failed: id=%s, copied=%d, nextOffset=%d, err=(%s)",
+			p.actorID, progress.ID, progress.Copied, progress.NextOffset, err)
+		return
+	}
+	log.Infof("<%s> copy done: id=%s, copied=%d", p.actorID, progress.ID, progress.Copied)
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type CopySuite struct {
+	cfg *config.Proxy
+	pxy *T
+}
+
+var _ = Suite(&CopySuite{})
+
+func (s *CopySuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.InMemory.Enabled = true
+	s.cfg.InMemory.Partitions = 1
+	s.cfg.Consumer.OffsetReset = config.OffsetResetEarliest
+	s.cfg.Consumer.ChannelBufferSize = 2
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "copy", s.cfg)
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		_, err := s.pxy.Produce("foo", sarama.StringEncoder(fmt.Sprintf("k%d", i)), sarama.StringEncoder(fmt.Sprintf("m%d", i)))
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *CopySuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+}
+
+func waitCopyDone(c *C, pxy *T, id string) CopyProgress {
+	for i := 0; i < 100; i++ {
+		progress, err := pxy.CopyProgress(id)
+		c.Assert(err, IsNil)
+		if progress.Done() {
+			return progress
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("copy %s is not done", id)
+	return CopyProgress{}
+}
+
+// Messages of the range are copied with their keys in order.
+func (s *CopySuite) TestCopy(c *C) {
+	// When
+	progress, err := s.pxy.Copy("foo", CopyRq{FromOffset: 1, ToOffset: 4, ToTopic: "bar"}, s.pxy)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(progress.ID, Equals, "1")
+	c.Assert(progress.FromOffset, Equals, int64(1))
+	c.Assert(progress.ToOffset, Equals, int64(4))
+	progress = waitCopyDone(c, s.pxy, progress.ID)
+	c.Assert(progress.Error, Equals, "")
+	c.Assert(progress.Copied, Equals, int64(3))
+	c.Assert(progress.NextOffset, Equals, int64(4))
+
+	for i := 1; i < 4; i++ {
+		msg, err := s.pxy.Consume("g1", "bar", AutoAck())
+		c.Assert(err, IsNil)
+		c.Assert(string(msg.Key), Equals, fmt.Sprintf("k%d", i))
+		c.Assert(string(msg.Value), Equals, fmt.Sprintf("m%d", i))
+	}
+	c.Assert(s.pxy.Copies(), DeepEquals, []CopyProgress{progress})
+}
+
+// Copying to the same topic stops at the end of the partition as of when the
+// copy started.
+func (s *CopySuite) TestCopySameTopic(c *C) {
+	// When
+	progress, err := s.pxy.Copy("foo", CopyRq{ToTopic: "foo"}, s.pxy)
+
+	// Then
+	c.Assert(err, IsNil)
+	progress = waitCopyDone(c, s.pxy, progress.ID)
+	c.Assert(progress.Copied, Equals, int64(5))
+	offsets, err := s.pxy.GetGroupOffsets("g1", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].End, Equals, int64(10))
+}
+
+// Messages can be copied to a topic of another proxy.
+func (s *CopySuite) TestCopyToOtherProxy(c *C) {
+	dst, err := Spawn(actor.RootID, "copy_dst", s.cfg)
+	c.Assert(err, IsNil)
+	defer dst.Stop()
+
+	// When
+	progress, err := s.pxy.Copy("foo", CopyRq{FromOffset: 3, ToCluster: "dst", ToTopic: "foo"}, dst)
+
+	// Then
+	c.Assert(err, IsNil)
+	progress = waitCopyDone(c, s.pxy, progress.ID)
+	c.Assert(progress.Copied, Equals, int64(2))
+	c.Assert(progress.ToCluster, Equals, "dst")
+	msg, err := dst.Consume("g1", "foo", AutoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m3")
+}
+
+func (s *CopySuite) TestCopyErrors(c *C) {
+	s.pxy.Stop()
+	s.cfg.TopicACL.Produce.Deny = []string{"^secret"}
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "copy", s.cfg)
+	c.Assert(err, IsNil)
+
+	// When
+	_, forbiddenErr := s.pxy.Copy("foo", CopyRq{ToTopic: "secret"}, s.pxy)
+	_, unknownErr := s.pxy.Copy("foo", CopyRq{Partition: 1, ToTopic: "bar"}, s.pxy)
+	_, notFoundErr := s.pxy.CopyProgress("1")
+
+	// Then
+	c.Assert(errors.Cause(forbiddenErr), Equals, ErrTopicForbidden)
+	c.Assert(errors.Cause(unknownErr), Equals, sarama.ErrUnknownTopicOrPartition)
+	c.Assert(errors.Cause(notFoundErr), Equals, ErrCopyNotFound)
+}
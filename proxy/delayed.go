@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/producer/delaystore"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidDelay is returned when a message cannot be produced with the
+// requested delay.
+var ErrInvalidDelay = errors.New("invalid delay")
+
+// spawnDelayStore starts the store of delayed messages, if delayed production
+// is enabled.
+func (p *T) spawnDelayStore(cluster string) error {
+	if p.cfg.Producer.Delayed.Dir == "" {
+		return nil
+	}
+	var err error
+	p.delayed, err = delaystore.Spawn(p.actorID, filepath.Join(p.cfg.Producer.Delayed.Dir, cluster),
+		p.cfg.Producer.RetryBackoff, p.produceDelayed)
+	return err
+}
+
+// ProduceAt submits a message to be produced to `topic` when `deliverAt`
+// comes. The message is checked against the naming policy and the ACL right
+// away, and then kept in the delay store until it is due. It is produced as
+// soon as possible if `deliverAt` is in the past, and if the proxy was not
+// running when it was due.
+func (p *T) ProduceAt(topic string, key, message sarama.Encoder, deliverAt time.Time) error {
+	return p.ProduceAtWithAcks(topic, key, message, deliverAt, "")
+}
+
+// ProduceAtWithAcks is like ProduceAt, but fails unless `acks` is empty or
+// the default level of acknowledgement reliability, for delayed messages are
+// always produced with the default level.
+func (p *T) ProduceAtWithAcks(topic string, key, message sarama.Encoder, deliverAt time.Time, acks string) error {
+	if acks != "" && acks != p.defaultAcks {
+		return errors.Wrapf(ErrAcksNotAllowed, "acks=%s, delayed messages are produced with acks=%s", acks, p.defaultAcks)
+	}
+	if p.delayed == nil {
+		return errors.Wrap(ErrInvalidDelay, "delayed production is disabled")
+	}
+	if deliverAt.Sub(time.Now()) > p.cfg.Producer.Delayed.MaxDelay {
+		return errors.Wrapf(ErrInvalidDelay, "deliverAt is more than %v ahead", p.cfg.Producer.Delayed.MaxDelay)
+	}
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.prodACL.check(topic); err != nil {
+		return err
+	}
+	if err := p.ensureTopic(topic); err != nil {
+		return err
+	}
+	msg := delaystore.Message{Topic: topic, DeliverAt: deliverAt.UTC()}
+	if key != nil {
+		if msg.Key, err = key.Encode(); err != nil {
+			return errors.Wrap(err, "failed to encode key")
+		}
+	}
+	if message != nil {
+		if msg.Value, err = message.Encode(); err != nil {
+			return errors.Wrap(err, "failed to encode message")
+		}
+	}
+	return p.delayed.Add(msg)
+}
+
+// produceDelayed produces a delayed message when it is due.
+func (p *T) produceDelayed(msg delaystore.Message) error {
+	var key sarama.Encoder
+	if msg.Key != nil {
+		key = sarama.ByteEncoder(msg.Key)
+	}
+	// Nil value means that the message is a tombstone.
+	var message sarama.Encoder
+	if msg.Value != nil {
+		message = sarama.ByteEncoder(msg.Value)
+	}
+	if _, err := p.producer.Produce(msg.Topic, key, message); err != nil {
+		return err
+	}
+	p.topicStats.Produced(msg.Topic, encodedLen(key)+encodedLen(message))
+	return nil
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type DelayedSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&DelayedSuite{})
+
+func (s *DelayedSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.InMemory.Enabled = true
+	s.cfg.InMemory.Partitions = 1
+	s.cfg.Producer.Delayed.Dir = c.MkDir()
+	s.cfg.Producer.Delayed.MaxDelay = time.Hour
+}
+
+// A delayed message is produced when it is due, even if the proxy was
+// restarted in between.
+func (s *DelayedSuite) TestProduceAt(c *C) {
+	pxy, err := Spawn(actor.RootID, "delayed", s.cfg)
+	c.Assert(err, IsNil)
+	deliverAt := time.Now().Add(300 * time.Millisecond)
+
+	// When
+	err = pxy.ProduceAt("foo", sarama.StringEncoder("bar"), sarama.StringEncoder("1"), deliverAt)
+	c.Assert(err, IsNil)
+	pxy.Stop()
+	pxy, err = Spawn(actor.RootID, "delayed", s.cfg)
+	c.Assert(err, IsNil)
+	defer pxy.Stop()
+
+	// Then
+	c.Assert(pxy.delayed.Count(), Equals, 1)
+	msg, err := pxy.ConsumeLocal("g1", "foo", NoAck(), consumer.ConsumeOpts{})
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Key), Equals, "bar")
+	c.Assert(string(msg.Value), Equals, "1")
+	c.Assert(time.Now().Before(deliverAt), Equals, false)
+}
+
+func (s *DelayedSuite) TestProduceAtErrors(c *C) {
+	s.cfg.TopicACL.Produce.Deny = []string{"^secret"}
+	pxy, err := Spawn(actor.RootID, "delayed", s.cfg)
+	c.Assert(err, IsNil)
+	defer pxy.Stop()
+
+	// When
+	tooLateErr := pxy.ProduceAt("foo", nil, sarama.StringEncoder("1"), time.Now().Add(2*time.Hour))
+	forbiddenErr := pxy.ProduceAt("secret", nil, sarama.StringEncoder("1"), time.Now())
+
+	// Then
+	c.Assert(errors.Cause(tooLateErr), Equals, ErrInvalidDelay)
+	c.Assert(tooLateErr, ErrorMatches, "deliverAt is more than 1h0m0s ahead: invalid delay")
+	c.Assert(errors.Cause(forbiddenErr), Equals, ErrTopicForbidden)
+	c.Assert(pxy.delayed.Count(), Equals, 0)
+}
+
+func (s *DelayedSuite) TestProduceAtDisabled(c *C) {
+	s.cfg.Producer.Delayed.Dir = ""
+	pxy, err := Spawn(actor.RootID, "delayed", s.cfg)
+	c.Assert(err, IsNil)
+	defer pxy.Stop()
+
+	// When
+	err = pxy.ProduceAt("foo", nil, sarama.StringEncoder("1"), time.Now())
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrInvalidDelay)
+}
@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/seedpeers"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// Clusters that a proxy with failover configured can be connected to.
+const (
+	FailoverPrimary = "primary"
+	FailoverStandby = "standby"
+)
+
+// ErrFailoverDisabled is returned when failover status is requested for a
+// proxy that has no standby cluster configured.
+var ErrFailoverDisabled = errors.New("failover not configured")
+
+// FailoverStatus tells which cluster a proxy with failover configured is
+// connected to, and how healthy both clusters are.
+type FailoverStatus struct {
+	// Either FailoverPrimary or FailoverStandby.
+	Active  string
+	Primary ClusterHealth
+	Standby ClusterHealth
+
+	// How many times the proxy switched clusters, and when it did last.
+	Switches   int
+	SwitchedAt time.Time
+}
+
+// ClusterHealth is the outcome of recent health checks of a cluster.
+type ClusterHealth struct {
+	SeedPeers []string
+
+	// Addresses that the seed peers resolved to on the last check.
+	ResolvedPeers []string
+
+	Healthy              bool
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	LastError            string
+	CheckedAt            time.Time
+}
+
+// failover checks health of the primary and the standby clusters of a proxy
+// every `failover.check_interval`, and replaces the proxy in the set with one
+// connected to the other cluster when the active one keeps failing checks,
+// and with one connected to the primary cluster once it recovers.
+type failover struct {
+	actorID *actor.ID
+	set     *Set
+	cluster string
+	cfgs    map[string]*config.Proxy
+	check   func(cfg *config.Proxy) ([]string, error)
+	stopCh  chan none.T
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	status FailoverStatus
+}
+
+func spawnFailover(set *Set, cluster string, cfg *config.Proxy,
+	check func(cfg *config.Proxy) ([]string, error),
+) *failover {
+	f := &failover{
+		actorID: actor.RootID.NewChild(cluster).NewChild("failover"),
+		set:     set,
+		cluster: cluster,
+		cfgs: map[string]*config.Proxy{
+			FailoverPrimary: cfg,
+			FailoverStandby: cfg.StandbyCfg(),
+		},
+		check:  check,
+		stopCh: make(chan none.T),
+		status: FailoverStatus{
+			Active:  FailoverPrimary,
+			Primary: ClusterHealth{SeedPeers: cfg.Kafka.SeedPeers, Healthy: true},
+			Standby: ClusterHealth{SeedPeers: cfg.Failover.KafkaSeedPeers},
+		},
+	}
+	actor.Spawn(f.actorID, &f.wg, f.run)
+	return f
+}
+
+func (f *failover) stop() {
+	close(f.stopCh)
+	f.wg.Wait()
+}
+
+func (f *failover) getStatus() FailoverStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *failover) run() {
+	ticker := time.NewTicker(f.cfgs[FailoverPrimary].Failover.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.checkAndSwitch()
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+// checkAndSwitch checks health of both clusters, and switches the proxy to
+// the other cluster if the thresholds tell so. If a proxy to the other cluster
+// cannot be spawned, then the current one stays, and it is retried on the
+// next check.
+func (f *failover) checkAndSwitch() {
+	var (
+		wg      sync.WaitGroup
+		checkMu sync.Mutex
+		checked = make(map[string]ClusterHealth, 2)
+	)
+	f.mu.Lock()
+	health := map[string]ClusterHealth{
+		FailoverPrimary: f.status.Primary,
+		FailoverStandby: f.status.Standby,
+	}
+	f.mu.Unlock()
+	for name, cfg := range f.cfgs {
+		wg.Add(1)
+		go func(name string, cfg *config.Proxy, ch ClusterHealth) {
+			defer wg.Done()
+			resolved, err := f.check(cfg)
+			ch.ResolvedPeers = resolved
+			ch.CheckedAt = time.Now().UTC()
+			ch.Healthy = err == nil
+			if err != nil {
+				ch.ConsecutiveFailures++
+				ch.ConsecutiveSuccesses = 0
+				ch.LastError = err.Error()
+			} else {
+				ch.ConsecutiveSuccesses++
+				ch.ConsecutiveFailures = 0
+				ch.LastError = ""
+			}
+			checkMu.Lock()
+			checked[name] = ch
+			checkMu.Unlock()
+		}(name, cfg, health[name])
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	f.status.Primary, f.status.Standby = checked[FailoverPrimary], checked[FailoverStandby]
+	active, target := f.status.Active, f.target()
+	f.mu.Unlock()
+	if target == "" {
+		return
+	}
+	log.Warningf("<%s> switching clusters: from=%s, to=%s, seedPeers=%v",
+		f.actorID, active, target, f.cfgs[target].Kafka.SeedPeers)
+	if err := f.set.replace(f.cluster, f.cfgs[target]); err != nil {
+		log.Errorf("<%s> failed to switch clusters: to=%s, err=(%s)", f.actorID, target, err)
+		return
+	}
+	f.mu.Lock()
+	f.status.Active = target
+	f.status.Switches++
+	f.status.SwitchedAt = time.Now().UTC()
+	f.mu.Unlock()
+}
+
+// target returns the cluster that the proxy should switch to, or an empty
+// string if it should stay with the active one. It must be called with the
+// mutex held.
+func (f *failover) target() string {
+	failoverCfg := f.cfgs[FailoverPrimary].Failover
+	primary, standby := f.status.Primary, f.status.Standby
+	switch f.status.Active {
+	case FailoverPrimary:
+		if primary.ConsecutiveFailures >= failoverCfg.FailureThreshold && standby.Healthy {
+			return FailoverStandby
+		}
+	case FailoverStandby:
+		if primary.ConsecutiveSuccesses >= failoverCfg.FailBackThreshold {
+			return FailoverPrimary
+		}
+		if standby.ConsecutiveFailures >= failoverCfg.FailureThreshold && primary.Healthy {
+			return FailoverPrimary
+		}
+	}
+	return ""
+}
+
+// checkCluster resolves SRV records and host names of the Kafka seed peers
+// of a cluster afresh, and makes sure that at least one of the brokers
+// responds to a metadata request within `failover.check_timeout`. It returns
+// the resolved addresses.
+func checkCluster(cfg *config.Proxy) ([]string, error) {
+	timeout := cfg.Failover.CheckTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	resolved, err := seedpeers.Resolve(ctx, net.DefaultResolver, cfg.Kafka.SeedPeers)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.ClientID = cfg.ClientID
+	saramaCfg.Version = cfg.SaramaKafkaVersion()
+	saramaCfg.Net.DialTimeout = timeout
+	saramaCfg.Net.ReadTimeout = timeout
+	saramaCfg.Net.WriteTimeout = timeout
+	var lastErr error
+	for _, addr := range resolved {
+		broker := sarama.NewBroker(addr)
+		if err := broker.Open(saramaCfg); err != nil {
+			lastErr = err
+			continue
+		}
+		_, err := broker.GetMetadata(&sarama.MetadataRequest{})
+		broker.Close()
+		if err == nil {
+			return resolved, nil
+		}
+		lastErr = err
+	}
+	return resolved, errors.Wrap(lastErr, "no broker responded")
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type FailoverSuite struct {
+	cfg     *config.Proxy
+	set     *Set
+	f       *failover
+	mu      sync.Mutex
+	healthy map[string]bool
+}
+
+var _ = Suite(&FailoverSuite{})
+
+func (s *FailoverSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.InMemory.Enabled = true
+	s.cfg.Kafka.SeedPeers = []string{"primary:9092"}
+	s.cfg.Failover.KafkaSeedPeers = []string{"standby:9092"}
+	s.cfg.Failover.ZooKeeperSeedPeers = []string{"standby:2181"}
+	s.cfg.Failover.CheckInterval = time.Hour
+	s.cfg.Failover.FailureThreshold = 2
+	s.cfg.Failover.FailBackThreshold = 3
+	s.healthy = map[string]bool{"primary:9092": true, "standby:9092": true}
+	pxy, err := Spawn(actor.RootID, "foo", s.cfg)
+	c.Assert(err, IsNil)
+	s.set = NewSet(map[string]*T{"foo": pxy}, pxy)
+	s.f = spawnFailover(s.set, "foo", s.cfg, s.check)
+	s.set.failovers["foo"] = s.f
+}
+
+func (s *FailoverSuite) TearDownTest(c *C) {
+	s.set.Stop()
+}
+
+func (s *FailoverSuite) check(cfg *config.Proxy) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.healthy[cfg.Kafka.SeedPeers[0]] {
+		return nil, errors.New("kaboom")
+	}
+	return cfg.Kafka.SeedPeers, nil
+}
+
+func (s *FailoverSuite) setHealthy(seedPeer string, healthy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthy[seedPeer] = healthy
+}
+
+func (s *FailoverSuite) assertActive(c *C, active string, seedPeers []string) {
+	status, err := s.set.FailoverStatus("foo")
+	c.Assert(err, IsNil)
+	c.Assert(status.Active, Equals, active)
+	pxy, err := s.set.Get("")
+	c.Assert(err, IsNil)
+	c.Assert(pxy.KafkaSeedPeers(), DeepEquals, seedPeers)
+}
+
+// The proxy fails over to the standby cluster after the primary fails enough
+// checks in a row, and fails back after it passes enough of them.
+func (s *FailoverSuite) TestFailoverAndBack(c *C) {
+	s.setHealthy("primary:9092", false)
+	s.f.checkAndSwitch()
+	s.assertActive(c, FailoverPrimary, []string{"primary:9092"})
+
+	// When
+	s.f.checkAndSwitch()
+
+	// Then
+	s.assertActive(c, FailoverStandby, []string{"standby:9092"})
+	status, _ := s.set.FailoverStatus("foo")
+	c.Assert(status.Switches, Equals, 1)
+	c.Assert(status.Primary.LastError, Equals, "kaboom")
+	c.Assert(status.Standby.ResolvedPeers, DeepEquals, []string{"standby:9092"})
+
+	// When
+	s.setHealthy("primary:9092", true)
+	s.f.checkAndSwitch()
+	s.f.checkAndSwitch()
+	s.assertActive(c, FailoverStandby, []string{"standby:9092"})
+	s.f.checkAndSwitch()
+
+	// Then
+	s.assertActive(c, FailoverPrimary, []string{"primary:9092"})
+	status, _ = s.set.FailoverStatus("foo")
+	c.Assert(status.Switches, Equals, 2)
+}
+
+// The proxy stays with a failing primary cluster if the standby is not
+// healthy either.
+func (s *FailoverSuite) TestStandbyUnhealthy(c *C) {
+	s.setHealthy("primary:9092", false)
+	s.setHealthy("standby:9092", false)
+
+	// When
+	for i := 0; i < 3; i++ {
+		s.f.checkAndSwitch()
+	}
+
+	// Then
+	s.assertActive(c, FailoverPrimary, []string{"primary:9092"})
+	status, _ := s.set.FailoverStatus("foo")
+	c.Assert(status.Primary.ConsecutiveFailures, Equals, 3)
+	c.Assert(status.Switches, Equals, 0)
+}
+
+// Proxies without a standby cluster have no failover status.
+func (s *FailoverSuite) TestFailoverDisabled(c *C) {
+	bar := newTestProxy("bar")
+	set := NewSet(map[string]*T{"bar": bar}, bar)
+
+	// When
+	_, err := set.FailoverStatus("bar")
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrFailoverDisabled)
+	_, err = set.FailoverStatus("baz")
+	c.Assert(errors.Cause(err), Equals, ErrProxyNotFound)
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// FanOutResult is the outcome of producing a message to one of the topics of
+// a fan-out produce.
+type FanOutResult struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+// ProduceFanOut submits the same message to several topics, and returns
+// results in the order of topics. All topics are checked against the naming
+// policy and the ACL, and made sure to exist, before the message is submitted
+// to any of them, so that a bad topic in the list does not leave the message
+// produced to only some of them. Past that point the message is produced to
+// each topic on its own, and failures are reported in results.
+//
+// TODO Wrap a fan-out into a Kafka transaction, so that the message is
+// produced either to all topics or to none. The vendored sarama does not
+// support transactional producers, it needs to be upgraded first.
+func (p *T) ProduceFanOut(topics []string, key, message sarama.Encoder) ([]FanOutResult, error) {
+	topics, err := p.prepareFanOut(topics)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]FanOutResult, len(topics))
+	var wg sync.WaitGroup
+	for i, topic := range topics {
+		results[i].Topic = topic
+		wg.Add(1)
+		go func(result *FanOutResult) {
+			defer wg.Done()
+			if result.Err = p.faults.Inject(chaos.OpProduce); result.Err != nil {
+				return
+			}
+			prodMsg, err := p.producer.Produce(result.Topic, key, message)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Partition, result.Offset = prodMsg.Partition, prodMsg.Offset
+			p.topicStats.Produced(result.Topic, encodedLen(key)+encodedLen(message))
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// AsyncProduceFanOut is an asynchronous counterpart of ProduceFanOut. An
+// error is only returned if any of the topics fails the checks, and then the
+// message is not submitted to any of them.
+func (p *T) AsyncProduceFanOut(topics []string, key, message sarama.Encoder) error {
+	topics, err := p.prepareFanOut(topics)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if err := p.faults.Inject(chaos.OpProduce); err != nil {
+			log.Errorf("<%s> message dropped: topic=%s, err=(%s)", p.actorID, topic, err)
+			continue
+		}
+		p.producer.AsyncProduce(topic, key, message)
+		p.topicStats.Produced(topic, encodedLen(key)+encodedLen(message))
+	}
+	return nil
+}
+
+// prepareFanOut normalizes names of fan-out topics, drops duplicates, and
+// makes sure that the message can be produced to all of them.
+func (p *T) prepareFanOut(topics []string) ([]string, error) {
+	if len(topics) == 0 {
+		return nil, errors.Wrap(ErrInvalidName, "no topics given")
+	}
+	seen := make(map[string]bool, len(topics))
+	prepared := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		topic, err := p.topicName(topic)
+		if err != nil {
+			return nil, err
+		}
+		if seen[topic] {
+			continue
+		}
+		seen[topic] = true
+		if err := p.prodACL.check(topic); err != nil {
+			return nil, err
+		}
+		if err := p.ensureTopic(topic); err != nil {
+			return nil, err
+		}
+		prepared = append(prepared, topic)
+	}
+	return prepared, nil
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type FanOutSuite struct {
+	pxy *T
+}
+
+var _ = Suite(&FanOutSuite{})
+
+func (s *FanOutSuite) SetUpTest(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.InMemory.Partitions = 1
+	cfg.TopicACL.Produce.Deny = []string{"^secret"}
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "fanout", cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *FanOutSuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+}
+
+// A message is produced to every topic once, even if a topic is given twice.
+func (s *FanOutSuite) TestProduceFanOut(c *C) {
+	// When
+	results, err := s.pxy.ProduceFanOut([]string{"raw", "normalized", "raw"}, nil, sarama.StringEncoder("foo"))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, []FanOutResult{
+		{Topic: "raw", Partition: 0, Offset: 0},
+		{Topic: "normalized", Partition: 0, Offset: 0},
+	})
+	c.Assert(s.pxy.topicStats.Stats("raw").Produced.Messages, Equals, int64(1))
+	c.Assert(s.pxy.topicStats.Stats("normalized").Produced.Messages, Equals, int64(1))
+}
+
+// If any of the topics is forbidden, then the message is produced to none.
+func (s *FanOutSuite) TestProduceFanOutForbidden(c *C) {
+	// When
+	_, err := s.pxy.ProduceFanOut([]string{"raw", "secret"}, nil, sarama.StringEncoder("foo"))
+	asyncErr := s.pxy.AsyncProduceFanOut([]string{"raw", "secret"}, nil, sarama.StringEncoder("foo"))
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrTopicForbidden)
+	c.Assert(errors.Cause(asyncErr), Equals, ErrTopicForbidden)
+	c.Assert(s.pxy.topicStats.Stats("raw").Produced.Messages, Equals, int64(0))
+}
+
+func (s *FanOutSuite) TestProduceFanOutNoTopics(c *C) {
+	_, err := s.pxy.ProduceFanOut(nil, nil, sarama.StringEncoder("foo"))
+	c.Assert(errors.Cause(err), Equals, ErrInvalidName)
+}
@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+)
+
+// recordE2ELatency records the time from the message timestamp to now, that
+// is when the message is delivered to a consumer. With
+// `producer.stamp_timestamps` enabled, messages produced via the proxy are
+// timestamped as their produce requests are accepted, so it is the latency of
+// the whole pipeline, as opposed to `produce.latency` that ends with a broker
+// acknowledgement. Messages produced to Kafka older than 0.10.0.0 have no
+// timestamps, and are skipped.
+//
+// TODO Only record messages that carry a produce time header stamped by the
+// proxy, once the vendored sarama supports record headers. Until then latency
+// of messages produced elsewhere is measured from their own timestamps.
+func (p *T) recordE2ELatency(group, topic string, msg consumer.Message) {
+	if msg.Timestamp.IsZero() {
+		return
+	}
+	latency := time.Since(msg.Timestamp)
+	if latency < 0 {
+		// Clocks of the producer and the proxy are out of sync.
+		latency = 0
+	}
+	p.metrics.Timer("consume.e2e_latency", "group", group, "topic", topic).Update(latency)
+}
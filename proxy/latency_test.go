@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/metrics"
+	. "gopkg.in/check.v1"
+)
+
+type LatencySuite struct{}
+
+var _ = Suite(&LatencySuite{})
+
+// End-to-end latency is recorded per group and topic for consumed messages,
+// and only if enabled.
+func (s *LatencySuite) TestE2ELatency(c *C) {
+	for i, enabled := range []bool{false, true} {
+		cfg := config.DefaultProxy()
+		cfg.InMemory.Enabled = true
+		cfg.InMemory.Partitions = 1
+		cfg.Consumer.OffsetReset = config.OffsetResetEarliest
+		cfg.Consumer.EndToEndLatency = enabled
+		pxy, err := Spawn(actor.RootID, "latency", cfg)
+		c.Assert(err, IsNil)
+		_, err = pxy.Produce("foo", nil, sarama.StringEncoder("bar"))
+		c.Assert(err, IsNil)
+		time.Sleep(20 * time.Millisecond)
+
+		// When
+		_, err = pxy.Consume("g1", "foo", AutoAck())
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		timer := pxy.Metrics().Timer("consume.e2e_latency", "group", "g1", "topic", "foo")
+		if !enabled {
+			c.Assert(timer.Count(), Equals, int64(0), Commentf("case #%d", i))
+		} else {
+			c.Assert(timer.Count(), Equals, int64(1), Commentf("case #%d", i))
+			c.Assert(timer.Min() >= int64(20*time.Millisecond), Equals, true, Commentf("case #%d", i))
+		}
+		pxy.Stop()
+	}
+}
+
+// Messages with no timestamps are skipped, and those timestamped ahead of the
+// proxy clock are recorded with zero latency.
+func (s *LatencySuite) TestE2ELatencyTimestamps(c *C) {
+	pxy := &T{metrics: metrics.New()}
+
+	// When
+	pxy.recordE2ELatency("g1", "foo", consumer.Message{})
+	pxy.recordE2ELatency("g1", "foo", consumer.Message{Timestamp: time.Now().Add(time.Minute)})
+
+	// Then
+	timer := pxy.metrics.Timer("consume.e2e_latency", "group", "g1", "topic", "foo")
+	c.Assert(timer.Count(), Equals, int64(1))
+	c.Assert(timer.Max(), Equals, int64(0))
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Lifecycle operations sent by forwarded lifecycle requests.
+	LifecycleStart = "start"
+	LifecycleStop  = "stop"
+)
+
+// StartConsumer makes the proxy start consuming a topic on behalf of a group
+// ahead of the first consume request, so that partitions are assigned and
+// messages are fetched by the time it comes. The offset reset policy applies
+// as if it was given with the following consume requests. A started consumer
+// stops if it is not consumed from for `Config.Consumer.RegistrationTimeout`.
+func (p *T) StartConsumer(group, topic string, offsetReset consumer.OffsetReset) error {
+	return p.lifecycle(group, topic, LifecycleStart, offsetReset, true)
+}
+
+// StopConsumer makes the proxy stop consuming a topic on behalf of a group
+// right away, rather than after `Config.Consumer.RegistrationTimeout` of
+// inactivity. Offsets of acknowledged messages are committed, and partitions
+// of the topic are released to other members of the group.
+func (p *T) StopConsumer(group, topic string) error {
+	return p.lifecycle(group, topic, LifecycleStop, consumer.OffsetReset{}, true)
+}
+
+// LifecycleLocal is like StartConsumer or StopConsumer, depending on `op`,
+// except the request is never forwarded to the home instance of the group.
+// It is used to serve requests forwarded by peers.
+func (p *T) LifecycleLocal(group, topic, op string, offsetReset consumer.OffsetReset) error {
+	return p.lifecycle(group, topic, op, offsetReset, false)
+}
+
+func (p *T) lifecycle(group, topic, op string, offsetReset consumer.OffsetReset, forward bool) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return err
+	}
+	if op == LifecycleStart {
+		if err := p.checkTopicExists(topic); errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			return err
+		}
+		if !offsetReset.Time.IsZero() && p.kafkaClt != nil {
+			if err := p.cfg.CheckKafkaFeature(config.KafkaFeatureOffsetsByTime); err != nil {
+				return err
+			}
+		}
+	}
+	// Consumers are run by the home instance of the group, along with the
+	// rest of the group traffic.
+	if targetID := p.router.target(group, ""); forward && p.router.isRemote(targetID) {
+		_, err := p.router.forward(PeerLifecyclePath, targetID, PeerRq{
+			Group: group, Topic: topic, Lifecycle: op, OffsetReset: offsetReset.String(),
+		})
+		if errors.Cause(err) != ErrPeerUnavailable {
+			return err
+		}
+		log.Warningf("<%s> running lifecycle locally: group=%s, op=%s, err=(%s)", p.actorID, group, op, err)
+	}
+	p.consumerMu.RLock()
+	defer p.consumerMu.RUnlock()
+	if p.consumer == nil {
+		return ErrConsumerUnavailable
+	}
+	// Consumers that do not support lifecycle control have nothing running
+	// between requests, so there is nothing to start or stop.
+	lc, ok := p.consumer.(consumer.Lifecycle)
+	if !ok {
+		return nil
+	}
+	switch op {
+	case LifecycleStart:
+		return lc.StartTopic(group, topic, consumer.ConsumeOpts{OffsetReset: offsetReset})
+	case LifecycleStop:
+		return lc.StopTopic(group, topic)
+	}
+	return errors.Wrapf(ErrInvalidName, "bad lifecycle operation: %s", op)
+}
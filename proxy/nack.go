@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidNack is returned if a nack class is not one of consumer.Nack*
+// constants, or if a message is nacked as fatal, but there is no dead letter
+// topic configured for its topic.
+var ErrInvalidNack = errors.New("invalid nack")
+
+// Nack negatively acknowledges a message consumed from a partition of a topic
+// by a consumer group, that is tells that a client failed to process it. How
+// the message is handled depends on the nack class, see consumer.Nack*
+// constants. The reason is only logged. A fatal nack returns after the
+// message is produced to the dead letter topic and acknowledged, and so does
+// a retryable one of a message that has run out of `redelivery.max_attempts`.
+func (p *T) Nack(group, topic string, partition int32, offset int64, class, reason string) error {
+	return p.nack(group, topic, partition, offset, class, reason, "", true)
+}
+
+// NackWithAffinity is like Nack, except if routing is enabled and `affinity`
+// is ID of one of the peers, then the request is served by that peer rather
+// than by the home instance of the group.
+func (p *T) NackWithAffinity(group, topic string, partition int32, offset int64, class, reason, affinity string) error {
+	return p.nack(group, topic, partition, offset, class, reason, affinity, true)
+}
+
+// NackLocal is like Nack, except the request is never forwarded to the home
+// instance of the group. It is used to serve requests forwarded by peers.
+func (p *T) NackLocal(group, topic string, partition int32, offset int64, class, reason string) error {
+	return p.nack(group, topic, partition, offset, class, reason, "", false)
+}
+
+func (p *T) nack(group, topic string, partition int32, offset int64, class, reason, affinity string, forward bool) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return err
+	}
+	if partition < 0 {
+		return errors.Errorf("bad partition: %d", partition)
+	}
+	if offset < 0 {
+		return errors.Errorf("bad offset: %d", offset)
+	}
+	if !consumer.IsValidNackClass(class) {
+		return errors.Wrapf(ErrInvalidNack, "bad class: %s", class)
+	}
+	deadLetterTopic := p.cfg.TopicRedelivery(topic).DeadLetterTopic
+	if class == consumer.NackFatal && deadLetterTopic == "" {
+		return errors.Wrapf(ErrInvalidNack, "no dead letter topic configured for %s", topic)
+	}
+	if targetID := p.router.target(group, affinity); forward && p.router.isRemote(targetID) {
+		_, err := p.router.forward(PeerNackPath, targetID, PeerRq{
+			Group: group, Topic: topic, AckPartition: partition, AckOffset: offset,
+			NackClass: class, NackReason: reason,
+		})
+		return err
+	}
+	if err := p.faults.Inject(chaos.OpAck); err != nil {
+		return err
+	}
+	log.Infof("<%s> nacked: group=%s, topic=%s, partition=%d, offset=%d, class=%s, reason=%q",
+		p.actorID, group, topic, partition, offset, class, reason)
+	if msg, ok := p.nackReplay(group, topic, partition, offset, class); ok {
+		if class != consumer.NackFatal {
+			return nil
+		}
+		if err := p.produceDeadLetter(deadLetterTopic, msg); err != nil {
+			return err
+		}
+		p.ackReplay(group, topic, partition, offset)
+		return nil
+	}
+	eventsChID := eventsChID{group, topic, partition}
+	p.eventsChMapMu.RLock()
+	eventsCh, ok := p.eventsChMap[eventsChID]
+	p.eventsChMapMu.RUnlock()
+	if !ok {
+		return errors.New("acks channel missing")
+	}
+	// The nack is waited to be applied, so that the message is not consumed
+	// again before it takes effect.
+	offeredCh := make(chan consumer.Message, 1)
+	timeoutCh := time.After(p.cfg.Consumer.LongPollingTimeout)
+	select {
+	case eventsCh <- consumer.Nack(offset, class, offeredCh):
+	case <-timeoutCh:
+		return errors.New("nack timeout")
+	}
+	var msg consumer.Message
+	select {
+	case msg, ok = <-offeredCh:
+		if !ok {
+			return errors.Errorf("message not offered: partition=%d, offset=%d", partition, offset)
+		}
+	case <-timeoutCh:
+		return errors.New("nack timeout")
+	}
+	if class != consumer.NackFatal && !msg.AttemptsExhausted {
+		return nil
+	}
+	if msg.AttemptsExhausted {
+		log.Warningf("<%s> attempts exhausted: group=%s, topic=%s, partition=%d, offset=%d",
+			p.actorID, group, topic, partition, offset)
+	}
+	// If the message cannot be produced, then it is left unacknowledged to be
+	// retried after the ack timeout.
+	if err := p.produceDeadLetter(deadLetterTopic, msg); err != nil {
+		return err
+	}
+	select {
+	case eventsCh <- consumer.Ack(offset):
+	case <-timeoutCh:
+		return errors.New("ack timeout")
+	}
+	return nil
+}
+
+// produceDeadLetter produces a message nacked as fatal to the dead letter
+// topic, with the same key and value.
+func (p *T) produceDeadLetter(topic string, msg consumer.Message) error {
+	var key sarama.Encoder
+	if msg.Key != nil {
+		key = sarama.ByteEncoder(msg.Key)
+	}
+	// Nil value means that the message is a tombstone.
+	var message sarama.Encoder
+	if msg.Value != nil {
+		message = sarama.ByteEncoder(msg.Value)
+	}
+	if _, err := p.producer.Produce(topic, key, message); err != nil {
+		return errors.Wrapf(err, "failed to produce to dead letter topic %s", topic)
+	}
+	p.topicStats.Produced(topic, encodedLen(key)+encodedLen(message))
+	return nil
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type NackSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&NackSuite{})
+
+func (s *NackSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.InMemory.Enabled = true
+	s.cfg.InMemory.Partitions = 1
+	s.cfg.Consumer.OffsetReset = config.OffsetResetEarliest
+	s.cfg.Consumer.LongPollingTimeout = 100 * time.Millisecond
+}
+
+// spawn starts a proxy with two messages produced to the topic, and the first
+// of them consumed without an ack.
+func (s *NackSuite) spawn(c *C) (*T, consumer.Message) {
+	pxy, err := Spawn(actor.RootID, "nack", s.cfg)
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		_, err := pxy.Produce("foo", nil, sarama.StringEncoder(fmt.Sprintf("m%d", i)))
+		c.Assert(err, IsNil)
+	}
+	msg, err := pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m0")
+	return pxy, msg
+}
+
+// A message nacked as retryable is offered again without waiting for the ack
+// timeout.
+func (s *NackSuite) TestRetryable(c *C) {
+	pxy, msg := s.spawn(c)
+	defer pxy.Stop()
+
+	// When
+	err := pxy.Nack("g1", "foo", msg.Partition, msg.Offset, consumer.NackRetryable, "downstream failed")
+
+	// Then
+	c.Assert(err, IsNil)
+	msg, err = pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m0")
+}
+
+// A message nacked as fatal is produced to the dead letter topic and
+// acknowledged.
+func (s *NackSuite) TestFatal(c *C) {
+	s.cfg.Consumer.Redelivery.DeadLetterTopic = "foo.dlq"
+	pxy, msg := s.spawn(c)
+	defer pxy.Stop()
+
+	// When
+	err := pxy.Nack("g1", "foo", msg.Partition, msg.Offset, consumer.NackFatal, "bad payload")
+
+	// Then
+	c.Assert(err, IsNil)
+	deadMsg, err := pxy.Consume("g2", "foo.dlq", AutoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(deadMsg.Value), Equals, "m0")
+	msg, err = pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m1")
+	for i := 0; i < 100; i++ {
+		offsets, err := pxy.GetGroupOffsets("g1", "foo")
+		c.Assert(err, IsNil)
+		if offsets[0].Offset == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("nacked message is not acknowledged")
+}
+
+// A message nacked as retryable once it has been offered max attempts times
+// is produced to the dead letter topic, as if it was nacked as fatal.
+func (s *NackSuite) TestMaxAttempts(c *C) {
+	s.cfg.Consumer.Redelivery.DeadLetterTopic = "foo.dlq"
+	s.cfg.Consumer.Redelivery.MaxAttempts = 2
+	pxy, msg := s.spawn(c)
+	defer pxy.Stop()
+	err := pxy.Nack("g1", "foo", msg.Partition, msg.Offset, consumer.NackRetryable, "")
+	c.Assert(err, IsNil)
+	msg, err = pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m0")
+
+	// When
+	err = pxy.Nack("g1", "foo", msg.Partition, msg.Offset, consumer.NackRetryable, "")
+
+	// Then
+	c.Assert(err, IsNil)
+	deadMsg, err := pxy.Consume("g2", "foo.dlq", AutoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(deadMsg.Value), Equals, "m0")
+	msg, err = pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m1")
+}
+
+// A partition nacked with the throttle class is not offered from for the
+// redelivery backoff.
+func (s *NackSuite) TestThrottle(c *C) {
+	s.cfg.Consumer.Redelivery.Backoff = 300 * time.Millisecond
+	pxy, msg := s.spawn(c)
+	defer pxy.Stop()
+
+	// When
+	err := pxy.Nack("g1", "foo", msg.Partition, msg.Offset, consumer.NackThrottle, "")
+
+	// Then
+	c.Assert(err, IsNil)
+	_, err = pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, Equals, consumer.ErrRequestTimeout)
+	time.Sleep(300 * time.Millisecond)
+	msg, err = pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m0")
+}
+
+func (s *NackSuite) TestInvalid(c *C) {
+	pxy, msg := s.spawn(c)
+	defer pxy.Stop()
+
+	for i, tc := range []struct {
+		class  string
+		errMsg string
+	}{
+		{"", "bad class: : invalid nack"},
+		{"bogus", "bad class: bogus: invalid nack"},
+		{consumer.NackFatal, "no dead letter topic configured for foo: invalid nack"},
+	} {
+		// When
+		err := pxy.Nack("g1", "foo", msg.Partition, msg.Offset, tc.class, "")
+
+		// Then
+		c.Assert(errors.Cause(err), Equals, ErrInvalidNack, Commentf("case #%d", i))
+		c.Assert(err.Error(), Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidName is the cause of errors returned when a topic or group name
+// violates the proxy naming policy.
+var ErrInvalidName = errors.New("invalid name")
+
+// nameRules is a compiled representation of config.NameRules.
+type nameRules struct {
+	kind             string
+	pattern          *regexp.Regexp
+	patternStr       string
+	maxLength        int
+	reservedPrefixes []string
+}
+
+func newNameRules(kind string, cfg config.NameRules) (*nameRules, error) {
+	nr := nameRules{
+		kind:             kind,
+		patternStr:       cfg.Pattern,
+		maxLength:        cfg.MaxLength,
+		reservedPrefixes: cfg.ReservedPrefixes,
+	}
+	if cfg.Pattern != "" {
+		var err error
+		if nr.pattern, err = regexp.Compile("^(?:" + cfg.Pattern + ")$"); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s name pattern: %s", kind, cfg.Pattern)
+		}
+	}
+	return &nr, nil
+}
+
+// check returns an error caused by ErrInvalidName if the specified name does
+// not comply with the rules.
+func (nr *nameRules) check(name string) error {
+	if name == "" {
+		return errors.Wrapf(ErrInvalidName, "%s name is empty", nr.kind)
+	}
+	if nr.maxLength > 0 && utf8.RuneCountInString(name) > nr.maxLength {
+		return errors.Wrapf(ErrInvalidName, "%s name `%s` is longer than %d characters",
+			nr.kind, name, nr.maxLength)
+	}
+	if nr.pattern != nil && !nr.pattern.MatchString(name) {
+		return errors.Wrapf(ErrInvalidName, "%s name `%s` does not match `%s`",
+			nr.kind, name, nr.patternStr)
+	}
+	for _, prefix := range nr.reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return errors.Wrapf(ErrInvalidName, "%s name `%s` has reserved prefix `%s`",
+				nr.kind, name, prefix)
+		}
+	}
+	return nil
+}
+
+// topicName normalizes a topic name and checks it against the naming policy.
+func (p *T) topicName(topic string) (string, error) {
+	if p.cfg.Names.Lowercase {
+		topic = strings.ToLower(topic)
+	}
+	return topic, p.topicRules.check(topic)
+}
+
+// groupName normalizes a group name and checks it against the naming policy.
+func (p *T) groupName(group string) (string, error) {
+	if p.cfg.Names.Lowercase {
+		group = strings.ToLower(group)
+	}
+	return group, p.groupRules.check(group)
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type NamesSuite struct{}
+
+var _ = Suite(&NamesSuite{})
+
+func (s *NamesSuite) TestCheck(c *C) {
+	nr, err := newNameRules("group", config.NameRules{
+		Pattern:          "[a-z_]+",
+		MaxLength:        8,
+		ReservedPrefixes: []string{"test_", "tmp_"},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(nr.check("foo_bar"), IsNil)
+	for name, msg := range map[string]string{
+		"":          "group name is empty",
+		"foo_bar_1": "group name `foo_bar_1` is longer than 8 characters",
+		"foo-bar":   "group name `foo-bar` does not match `\\[a-z_\\]\\+`",
+		"tmp_foo":   "group name `tmp_foo` has reserved prefix `tmp_`",
+	} {
+		err := nr.check(name)
+		c.Assert(err, ErrorMatches, msg+": invalid name")
+		c.Assert(errors.Cause(err), Equals, ErrInvalidName)
+	}
+}
+
+// Default topic rules reflect Kafka topic name restrictions.
+func (s *NamesSuite) TestDefaultTopicRules(c *C) {
+	nr, err := newNameRules("topic", config.DefaultProxy().Names.Topic)
+	c.Assert(err, IsNil)
+
+	c.Assert(nr.check("Foo.bar-1_2"), IsNil)
+	c.Assert(nr.check("foo bar"), ErrorMatches, ".*does not match.*")
+	c.Assert(nr.check(string(make([]byte, 250))), ErrorMatches, ".*is longer than 249 characters.*")
+}
+
+func (s *NamesSuite) TestLowercase(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.Names.Lowercase = true
+	p := T{cfg: cfg}
+	p.topicRules, _ = newNameRules("topic", cfg.Names.Topic)
+	p.groupRules, _ = newNameRules("group", cfg.Names.Group)
+
+	topic, err := p.topicName("Foo.Bar")
+	c.Assert(err, IsNil)
+	c.Assert(topic, Equals, "foo.bar")
+	group, err := p.groupName("MyGroup")
+	c.Assert(err, IsNil)
+	c.Assert(group, Equals, "mygroup")
+}
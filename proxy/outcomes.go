@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidOutcomeReceiver is returned when an asynchronous produce request
+// asks for its outcome to be reported somewhere it is not allowed to.
+var ErrInvalidOutcomeReceiver = errors.New("invalid outcome receiver")
+
+// OutcomeReceiver tells where the outcome of an asynchronously produced
+// message is reported to. Either or both of URL and Topic can be set, and the
+// zero value means that the outcome is not reported at all.
+type OutcomeReceiver struct {
+	// ID given by the client to correlate the outcome with the message.
+	ID string
+
+	// URL that the outcome is posted to as JSON.
+	URL string
+
+	// Topic that the outcome is produced to as JSON, keyed by ID.
+	Topic string
+}
+
+// IsZero tells whether the outcome is not to be reported.
+func (rcv OutcomeReceiver) IsZero() bool {
+	return rcv.URL == "" && rcv.Topic == ""
+}
+
+// Outcome is the final result of producing a message asynchronously.
+type Outcome struct {
+	ID    string `json:"id"`
+	Topic string `json:"topic"`
+
+	// Partition and Offset are -1 if the message failed to be produced.
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Error     string `json:"error,omitempty"`
+}
+
+type queuedOutcome struct {
+	receiver OutcomeReceiver
+	outcome  Outcome
+}
+
+// outcomeReporter reports outcomes of asynchronously produced messages to
+// receivers that produce requests asked for, one at a time, in the order they
+// are known.
+type outcomeReporter struct {
+	actorID *actor.ID
+	cfg     *config.Proxy
+	httpClt *http.Client
+	metrics *metrics.Registry
+	queueCh chan queuedOutcome
+	wg      sync.WaitGroup
+
+	// Outcomes are produced to result topics with `produce` until
+	// stopProducing is called.
+	produceMu sync.Mutex
+	produce   func(topic string, key, message sarama.Encoder)
+}
+
+func spawnOutcomeReporter(namespace *actor.ID, cfg *config.Proxy, registry *metrics.Registry,
+	produce func(topic string, key, message sarama.Encoder),
+) *outcomeReporter {
+	rep := &outcomeReporter{
+		actorID: namespace.NewChild("outcomes"),
+		cfg:     cfg,
+		httpClt: &http.Client{Timeout: cfg.Producer.Outcomes.Timeout},
+		metrics: registry,
+		queueCh: make(chan queuedOutcome, cfg.Producer.Outcomes.QueueSize),
+		produce: produce,
+	}
+	actor.Spawn(rep.actorID, &rep.wg, rep.run)
+	return rep
+}
+
+// checkURL makes sure that outcomes can be posted to a callback URL.
+func (rep *outcomeReporter) checkURL(url string) error {
+	for _, prefix := range rep.cfg.Producer.Outcomes.URLPrefixes {
+		if strings.HasPrefix(url, prefix) {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrInvalidOutcomeReceiver, "callback URL not allowed: %s", url)
+}
+
+// report queues the outcome of a message to be reported. It never blocks, if
+// the queue is full, then the outcome is dropped.
+func (rep *outcomeReporter) report(receiver OutcomeReceiver, msg *sarama.ProducerMessage, err error) {
+	outcome := Outcome{ID: receiver.ID, Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	if err != nil {
+		outcome.Partition, outcome.Offset, outcome.Error = -1, -1, err.Error()
+	}
+	select {
+	case rep.queueCh <- queuedOutcome{receiver: receiver, outcome: outcome}:
+	default:
+		rep.metrics.Counter("produce.outcomes.dropped").Inc(1)
+		log.Errorf("<%s> outcome dropped: id=%s, topic=%s", rep.actorID, outcome.ID, outcome.Topic)
+	}
+}
+
+// stopProducing makes the reporter drop outcomes to be produced to result
+// topics from now on. It is called before the producer is stopped.
+func (rep *outcomeReporter) stopProducing() {
+	rep.produceMu.Lock()
+	rep.produce = nil
+	rep.produceMu.Unlock()
+}
+
+// stop reports all queued outcomes and makes the reporter goroutine exit. No
+// outcomes can be reported after it is called.
+func (rep *outcomeReporter) stop() {
+	close(rep.queueCh)
+	rep.wg.Wait()
+}
+
+func (rep *outcomeReporter) run() {
+	for qo := range rep.queueCh {
+		body, err := json.Marshal(qo.outcome)
+		if err != nil {
+			log.Errorf("<%s> failed to marshal outcome: id=%s, err=(%s)", rep.actorID, qo.outcome.ID, err)
+			continue
+		}
+		if qo.receiver.URL != "" {
+			if err := rep.post(qo.receiver.URL, body); err != nil {
+				rep.metrics.Counter("produce.outcomes.failures", "receiver", "url").Inc(1)
+				log.Errorf("<%s> failed to post outcome: id=%s, url=%s, err=(%s)",
+					rep.actorID, qo.outcome.ID, qo.receiver.URL, err)
+			}
+		}
+		if qo.receiver.Topic != "" {
+			rep.produceMu.Lock()
+			if rep.produce != nil {
+				rep.produce(qo.receiver.Topic, sarama.StringEncoder(qo.outcome.ID), sarama.ByteEncoder(body))
+			} else {
+				rep.metrics.Counter("produce.outcomes.failures", "receiver", "topic").Inc(1)
+				log.Errorf("<%s> outcome not produced while stopping: id=%s, topic=%s",
+					rep.actorID, qo.outcome.ID, qo.receiver.Topic)
+			}
+			rep.produceMu.Unlock()
+		}
+	}
+}
+
+func (rep *outcomeReporter) post(url string, body []byte) error {
+	rs, err := rep.httpClt.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rs.Body.Close()
+	if rs.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("callback failed, status=%d", rs.StatusCode)
+	}
+	return nil
+}
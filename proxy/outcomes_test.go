@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type OutcomesSuite struct {
+	pxy       *T
+	srv       *httptest.Server
+	outcomeCh chan Outcome
+}
+
+var _ = Suite(&OutcomesSuite{})
+
+func (s *OutcomesSuite) SetUpTest(c *C) {
+	s.outcomeCh = make(chan Outcome, 10)
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var outcome Outcome
+		if err := json.NewDecoder(r.Body).Decode(&outcome); err == nil {
+			s.outcomeCh <- outcome
+		}
+	}))
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.InMemory.Partitions = 1
+	cfg.TopicACL.Produce.Deny = []string{"^secret"}
+	cfg.Producer.Outcomes.URLPrefixes = []string{s.srv.URL + "/hooks/"}
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "outcomes", cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *OutcomesSuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+	s.srv.Close()
+}
+
+// Outcomes are posted to callback URLs and produced to result topics.
+func (s *OutcomesSuite) TestReport(c *C) {
+	receiver := OutcomeReceiver{ID: "m1", URL: s.srv.URL + "/hooks/a", Topic: "results"}
+
+	// When
+	_, err := s.pxy.AsyncProduceWithOutcome("foo", nil, sarama.StringEncoder("bar"), "", receiver)
+
+	// Then
+	c.Assert(err, IsNil)
+	expected := Outcome{ID: "m1", Topic: "foo", Partition: 0, Offset: 0}
+	select {
+	case outcome := <-s.outcomeCh:
+		c.Assert(outcome, Equals, expected)
+	case <-time.After(3 * time.Second):
+		c.Fatal("outcome not posted")
+	}
+	var msgs []consumer.Message
+	for deadline := time.Now().Add(3 * time.Second); len(msgs) == 0 && time.Now().Before(deadline); {
+		msgs, _ = s.pxy.admin.ReadMessages("results", 0, 0, 1)
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(len(msgs), Equals, 1)
+	encoded, _ := json.Marshal(expected)
+	c.Assert(string(msgs[0].Key), Equals, "m1")
+	c.Assert(string(msgs[0].Value), Equals, string(encoded))
+}
+
+// Outcomes can only be reported with an ID, to allowed URLs and topics.
+func (s *OutcomesSuite) TestInvalidReceiver(c *C) {
+	for i, receiver := range []OutcomeReceiver{
+		{URL: s.srv.URL + "/hooks/a"},
+		{ID: "m1", URL: s.srv.URL + "/elsewhere"},
+		{ID: "m1", URL: "http://example.com/hooks/a"},
+	} {
+		// When
+		_, err := s.pxy.AsyncProduceWithOutcome("foo", nil, sarama.StringEncoder("bar"), "", receiver)
+
+		// Then
+		c.Assert(errors.Cause(err), Equals, ErrInvalidOutcomeReceiver, Commentf("case #%d", i))
+	}
+	_, err := s.pxy.AsyncProduceWithOutcome("foo", nil, sarama.StringEncoder("bar"), "", OutcomeReceiver{ID: "m1", Topic: "secret"})
+	c.Assert(errors.Cause(err), Equals, ErrTopicForbidden)
+}
@@ -7,17 +7,40 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/admin/lagwatch"
+	"github.com/mailgun/kafka-pixy/alerts"
+	"github.com/mailgun/kafka-pixy/chaos"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/consumerimpl"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
+	"github.com/mailgun/kafka-pixy/consumer/offsettrac"
+	"github.com/mailgun/kafka-pixy/consumer/sizestats"
+	"github.com/mailgun/kafka-pixy/coordination"
+	"github.com/mailgun/kafka-pixy/inmem"
+	"github.com/mailgun/kafka-pixy/keyindex"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/mailgun/kafka-pixy/producer/delaystore"
+	"github.com/mailgun/kafka-pixy/seedpeers"
+	"github.com/mailgun/kafka-pixy/tap"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/topicstats"
 	"github.com/mailgun/log"
 	"github.com/pkg/errors"
 )
 
 const (
 	initEventsChMapCapacity = 256
+
+	// topicCreateTimeout is how long to wait for an auto-created topic to get
+	// partition leaders elected.
+	topicCreateTimeout = 10 * time.Second
+
+	// maxConsumerRespawnAttempts is how many times Rebalance tries to spawn a
+	// new consumer before it gives up.
+	maxConsumerRespawnAttempts = 5
 )
 
 var (
@@ -25,21 +48,129 @@ var (
 	autoAck = Ack{partition: -2}
 )
 
+// ErrConsumerUnavailable is returned by consume requests if Rebalance failed
+// to spawn a new consumer. It is returned until a Rebalance call succeeds.
+var ErrConsumerUnavailable = errors.New("consumer unavailable")
+
 // T implements a proxy to a particular Kafka/ZooKeeper cluster.
 type T struct {
-	actorID    *actor.ID
-	cfg        *config.Proxy
-	producer   *producer.T
-	kafkaClt   sarama.Client
+	actorID  *actor.ID
+	cfg      *config.Proxy
+	producer producerT
+	kafkaClt sarama.Client
+
+	// Seed peers with SRV records expanded, nil in the in-memory mode.
+	seedPeers *seedpeers.T
+
+	// Producers of acknowledgement levels that produce requests may ask
+	// for, keyed by AcksXXX, including the default one.
+	ackProducers map[string]producerT
+	defaultAcks  string
+	outcomes     *outcomeReporter
+
 	offsetMgrF offsetmgr.Factory
+	admin      adminT
+	faults     *chaos.T
+	tenants    *tenancy.T
+	prodACL    *topicFilter
+	consACL    *topicFilter
+	adminACL   *topicFilter
+	topicRules *nameRules
+	groupRules *nameRules
+	router     *router
+
+	// groupEvents outlives consumers replaced by Rebalance, so that watchers
+	// do not miss assignment changes caused by it.
+	groupEvents *groupevents.T
+
+	// sizes outlives consumers replaced by Rebalance too.
+	sizes *sizestats.T
+
+	// topicStats keeps track of messages produced and consumed via the
+	// proxy, as opposed to sizes that accounts messages fetched from Kafka.
+	topicStats *topicstats.T
+
+	lagWatch *lagwatch.T
+	alerts   *alerts.T
+	tap      *tap.T
+
+	// coordinator keeps leases of tasks that only one of the instances
+	// serving the cluster carries out. It is nil if coordination is
+	// disabled, and then alertsLease is nil too, that is always held.
+	coordinator coordination.Backend
+	alertsLease *coordination.Lease
+	keyIndex    *keyindex.T
+
+	// delayed keeps messages produced with a delay until they are due. It
+	// is nil if delayed production is disabled.
+	delayed *delaystore.T
+
+	metrics  *metrics.Registry
+	reporter *metrics.Reporter
+
+	// consumerMu guards the consumer that can be replaced by Rebalance.
+	consumerMu sync.RWMutex
 	consumer   consumer.T
-	admin      *admin.T
 
 	// FIXME: We never remove stale elements from eventsChMap. It is sort of ok
 	// FIXME: since the number of group/topic/partition combinations is fairly
 	// FIXME: limited and should not cause any significant system memory usage.
 	eventsChMapMu sync.RWMutex
 	eventsChMap   map[eventsChID]chan<- consumer.Event
+
+	// replays holds messages to be re-delivered to groups, see Replay.
+	replaysMu sync.Mutex
+	replays   map[replayID]*replayQueue
+
+	// copies holds all copy jobs started since the proxy was spawned, see
+	// Copy. IDs of jobs are their positions in the list plus one.
+	copiesMu     sync.Mutex
+	copies       []*copyJob
+	copiesWg     sync.WaitGroup
+	copiesStopCh chan struct{}
+
+	// Closed when warm-up is done, see Ready.
+	readyCh      chan struct{}
+	warmUpStopCh chan struct{}
+	warmUpWg     sync.WaitGroup
+}
+
+// producerT is implemented by producer.T and inmem.T.
+type producerT interface {
+	Produce(topic string, key, message sarama.Encoder) (*sarama.ProducerMessage, error)
+	AsyncProduce(topic string, key, message sarama.Encoder)
+	AsyncProduceWithCallback(topic string, key, message sarama.Encoder, callback func(msg *sarama.ProducerMessage, err error))
+	MetadataStats() producer.MetadataStats
+	InvalidateMetadata(topic string)
+	WarmUp(topics ...string) error
+	Flush(timeout time.Duration) producer.FlushResult
+	Stop()
+}
+
+// adminT is implemented by admin.T and inmem.T.
+type adminT interface {
+	GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error)
+	SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) error
+	GetTopicConsumers(group, topic string) (map[string][]int32, error)
+	GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error)
+	GetTopicConsumersPage(topic string, pg admin.Page) (map[string]map[string][]int32, bool, error)
+	ScanTopicConsumers(topic string, pg admin.Page, fn func(group string, consumers map[string][]int32, err error)) (bool, error)
+	ListGroups() ([]string, error)
+	RebalanceGroup(group string) error
+	EvictGroupMember(group, memberID string) error
+	ListTopics() ([]string, error)
+	CreateTopic(topic string, partitions, replicationFactor int) error
+	InvalidateCache()
+	CacheStats() admin.CacheStats
+	OffsetForTime(topic string, partition int32, t time.Time) (int64, error)
+	PartitionOffsets(topic string, partition int32) (int64, int64, error)
+	TopicOffsets(topic string) ([]admin.PartitionOffset, error)
+	ReadMessages(topic string, partition int32, offset int64, count int) ([]consumer.Message, error)
+	DescribeClientQuotas() ([]admin.ClientQuotas, error)
+	AlterClientQuotas(entity admin.QuotaEntity, alter map[string]*float64) error
+	DescribeBrokerConfigs(brokerID int32) ([]admin.BrokerConfig, error)
+	AlterBrokerConfigs(brokerID int32, alter map[string]*string) error
+	Stop()
 }
 
 type Ack struct {
@@ -60,6 +191,15 @@ func NewAck(partition int32, offset int64) (Ack, error) {
 	return Ack{partition, offset}, nil
 }
 
+// Message returns the partition and the offset of the acknowledged message,
+// or false if the ack value does not acknowledge a particular message.
+func (a Ack) Message() (int32, int64, bool) {
+	if a == noAck || a == autoAck {
+		return 0, 0, false
+	}
+	return a.partition, a.offset, true
+}
+
 // NoAck returns an ack value that should be passed to proxy.Consume function
 // when a caller does not want to acknowledge anything.
 func NoAck() Ack {
@@ -80,38 +220,181 @@ type eventsChID struct {
 
 // Spawn creates a proxy instance and starts its internal goroutines.
 func Spawn(namespace *actor.ID, name string, cfg *config.Proxy) (*T, error) {
+	registry := metrics.New()
 	p := T{
-		actorID:     namespace.NewChild(name),
-		cfg:         cfg,
-		eventsChMap: make(map[eventsChID]chan<- consumer.Event, initEventsChMapCapacity),
+		actorID:      namespace.NewChild(name),
+		cfg:          cfg,
+		eventsChMap:  make(map[eventsChID]chan<- consumer.Event, initEventsChMapCapacity),
+		replays:      make(map[replayID]*replayQueue),
+		copiesStopCh: make(chan struct{}),
+		readyCh:      make(chan struct{}),
+		warmUpStopCh: make(chan struct{}),
+		tenants:      tenancy.New(cfg.Tenants, cfg.OperatorTokens),
+		groupEvents:  groupevents.NewWithMetrics(registry),
+		sizes:        sizestats.New(),
+		topicStats:   topicstats.New(),
+		metrics:      registry,
+		router:       newRouter(name, cfg),
 	}
+	p.lagWatch = lagwatch.New(p.actorID, func(group, topic string) ([]admin.PartitionOffset, error) {
+		return p.admin.GetGroupOffsets(group, topic)
+	}, cfg.Admin.LagWatchInterval)
 	var err error
-
+	if p.topicRules, err = newNameRules("topic", cfg.Names.Topic); err != nil {
+		return nil, err
+	}
+	if p.groupRules, err = newNameRules("group", cfg.Names.Group); err != nil {
+		return nil, err
+	}
+	if p.prodACL, err = newTopicFilter(cfg.TopicACL.Produce); err != nil {
+		return nil, errors.Wrap(err, "invalid produce topic ACL")
+	}
+	if p.consACL, err = newTopicFilter(cfg.TopicACL.Consume); err != nil {
+		return nil, errors.Wrap(err, "invalid consume topic ACL")
+	}
+	if p.adminACL, err = newTopicFilter(cfg.TopicACL.Admin); err != nil {
+		return nil, errors.Wrap(err, "invalid admin topic ACL")
+	}
+	if p.reporter, err = spawnMetricsReporter(p.actorID, name, cfg, p.metrics); err != nil {
+		return nil, errors.Wrap(err, "failed to spawn metrics reporter")
+	}
+	if cfg.FaultInjection.Enabled {
+		p.faults = chaos.New()
+		log.Warningf("<%s> fault injection enabled", p.actorID)
+	}
+	if cfg.InMemory.Enabled {
+		im := inmem.Spawn(p.actorID, cfg)
+		p.producer, p.consumer, p.admin = im, im, im
+		log.Infof("<%s> using in-memory Kafka cluster", p.actorID)
+		if err := p.spawnAckProducers(cfg); err != nil {
+			return nil, err
+		}
+		p.outcomes = spawnOutcomeReporter(p.actorID, cfg, p.metrics, p.producer.AsyncProduce)
+		p.tap = tap.New(name, cfg, p.producer.AsyncProduce, p.metrics)
+		if err := p.spawnDelayStore(name); err != nil {
+			return nil, errors.Wrap(err, "failed to spawn delay store")
+		}
+		p.spawnAlerts(name)
+		p.keyIndex = keyindex.Spawn(p.actorID, cfg, p.admin)
+		actor.Spawn(p.actorID.NewChild("warm_up"), &p.warmUpWg, p.runWarmUp)
+		return &p, nil
+	}
+	// TODO Support SASL/GSSAPI (Kerberos) authentication with brokers,
+	// configured with a keytab and a krb5.conf, renewing tickets before they
+	// expire. The vendored sarama only implements SASL/PLAIN, and GSSAPI needs
+	// sarama 1.22+ along with the gokrb5 library, so they have to be vendored
+	// first.
+	//
+	// TODO Support SASL/OAUTHBEARER with a pluggable token provider, and the
+	// AWS_MSK_IAM mechanism on top of it, refreshing credentials of the IAM
+	// role before tokens expire. That needs the same sarama upgrade, for the
+	// vendored broker hardcodes the SASL/PLAIN handshake when it connects.
+	if p.seedPeers, err = seedpeers.Spawn(p.actorID, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to resolve seed peers")
+	}
+	// Clients are created with the peers that SRV records point to as of
+	// now, those created later get the peers as of then.
+	peersCfg := p.seedPeers.Apply(cfg)
 	saramaCfg := sarama.NewConfig()
+	saramaCfg.Version = cfg.SaramaKafkaVersion()
 	saramaCfg.ClientID = cfg.ClientID
 	saramaCfg.ChannelBufferSize = cfg.Consumer.ChannelBufferSize
-	if p.kafkaClt, err = sarama.NewClient(cfg.Kafka.SeedPeers, saramaCfg); err != nil {
+	if p.kafkaClt, err = sarama.NewClient(peersCfg.Kafka.SeedPeers, saramaCfg); err != nil {
 		return nil, errors.Wrap(err, "failed to create Kafka client")
 	}
-	p.offsetMgrF = offsetmgr.SpawnFactory(p.actorID, cfg, p.kafkaClt)
-	if p.producer, err = producer.Spawn(p.actorID, cfg); err != nil {
+	p.offsetMgrF = offsetmgr.SpawnFactoryWithOpts(p.actorID, cfg, p.kafkaClt, offsetmgr.Opts{Faults: p.faults, Metrics: p.metrics})
+	if p.producer, err = producer.SpawnWithOpts(p.actorID, peersCfg, producer.Opts{Metrics: p.metrics}); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn producer")
 	}
-	if p.consumer, err = consumerimpl.Spawn(p.actorID, cfg, p.offsetMgrF); err != nil {
+	if err := p.spawnAckProducers(peersCfg); err != nil {
+		return nil, err
+	}
+	p.outcomes = spawnOutcomeReporter(p.actorID, cfg, p.metrics, p.producer.AsyncProduce)
+	p.tap = tap.New(name, cfg, p.producer.AsyncProduce, p.metrics)
+	if p.consumer, err = consumerimpl.SpawnWithOpts(p.actorID, peersCfg, p.offsetMgrF, consumerimpl.Opts{Events: p.groupEvents, Sizes: p.sizes, Metrics: p.metrics}); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn consumer")
 	}
-	if p.admin, err = admin.Spawn(p.actorID, cfg); err != nil {
+	// When consumers notice partitions added to a topic, producers refresh
+	// its metadata to start producing to them as well.
+	p.groupEvents.OnNotify(groupevents.Expanded, func(group string, ev groupevents.Event) {
+		for _, prod := range p.ackProducers {
+			prod.InvalidateMetadata(ev.Topic)
+		}
+	})
+	if p.admin, err = admin.SpawnWithOpts(p.actorID, cfg, admin.Opts{SeedPeers: p.seedPeers}); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn admin")
 	}
+	if err := p.spawnDelayStore(name); err != nil {
+		return nil, errors.Wrap(err, "failed to spawn delay store")
+	}
+	if p.coordinator, err = coordination.New(name, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to create coordinator")
+	}
+	p.spawnAlerts(name)
+	p.keyIndex = keyindex.Spawn(p.actorID, cfg, p.admin)
+	actor.Spawn(p.actorID.NewChild("warm_up"), &p.warmUpWg, p.runWarmUp)
 	return &p, nil
 }
 
+// spawnAlerts starts evaluating alert rules, if there are any configured.
+func (p *T) spawnAlerts(cluster string) {
+	var notifiers []alerts.Notifier
+	if p.cfg.Alerts.Webhook.URL != "" {
+		notifiers = append(notifiers, alerts.NewWebhook(p.cfg.Alerts.Webhook.URL, p.cfg.Alerts.Webhook.Timeout))
+	}
+	if p.cfg.Alerts.Topic != "" {
+		notifiers = append(notifiers, alerts.NewTopic(p.cfg.Alerts.Topic, p.producer.AsyncProduce))
+	}
+	// All instances evaluate rules, so that any of them can tell what
+	// alerts are firing, but only the lease holder notifies about them.
+	if len(p.cfg.Alerts.Rules) > 0 && p.coordinator != nil {
+		p.alertsLease = coordination.SpawnLease(p.actorID, p.coordinator, "alerts",
+			p.cfg.ClientID, p.cfg.Coordination.LeaseTTL)
+		for i, notifier := range notifiers {
+			notifiers[i] = &leaderNotifier{notifier, p.alertsLease}
+		}
+	}
+	p.alerts = alerts.SpawnWithMetrics(p.actorID, cluster, p.cfg, p.admin, p.metrics, notifiers...)
+}
+
+// leaderNotifier sends alerts to a notifier only while a lease is held.
+type leaderNotifier struct {
+	alerts.Notifier
+	lease *coordination.Lease
+}
+
+// Notify implements alerts.Notifier.
+func (ln *leaderNotifier) Notify(alert alerts.Alert) error {
+	if !ln.lease.Held() {
+		return nil
+	}
+	return ln.Notifier.Notify(alert)
+}
+
 // Stop terminates the proxy instances synchronously.
 func (p *T) Stop() {
+	// Warm-up, lag watch, alerts, key indexes, the delay store, and copy
+	// jobs use admin, producer and consumer, so they have to be stopped
+	// first.
+	close(p.warmUpStopCh)
+	p.warmUpWg.Wait()
+	p.lagWatch.Stop()
+	p.alerts.Stop()
+	p.alertsLease.Stop()
+	p.keyIndex.Stop()
+	p.delayed.Stop()
+	close(p.copiesStopCh)
+	p.copiesWg.Wait()
 	var wg sync.WaitGroup
 	if p.producer != nil {
+		// Outcomes of messages still in flight are reported as the
+		// producers stop, but none can be produced to result topics then.
+		p.outcomes.stopProducing()
 		actor.Spawn(p.actorID.NewChild("producer_stop"), &wg, p.producer.Stop)
+		actor.Spawn(p.actorID.NewChild("ack_producers_stop"), &wg, p.stopAckProducers)
 	}
+	p.consumerMu.Lock()
+	defer p.consumerMu.Unlock()
 	if p.consumer != nil {
 		actor.Spawn(p.actorID.NewChild("consumer_stop"), &wg, p.consumer.Stop)
 	}
@@ -119,12 +402,43 @@ func (p *T) Stop() {
 		actor.Spawn(p.actorID.NewChild("admin_stop"), &wg, p.admin.Stop)
 	}
 	wg.Wait()
+	if p.outcomes != nil {
+		p.outcomes.stop()
+	}
 	if p.offsetMgrF != nil {
 		p.offsetMgrF.Stop()
 	}
 	if p.kafkaClt != nil {
 		p.kafkaClt.Close()
 	}
+	if p.coordinator != nil {
+		p.coordinator.Close()
+	}
+	if p.seedPeers != nil {
+		p.seedPeers.Stop()
+	}
+	if p.reporter != nil {
+		p.reporter.Stop()
+	}
+}
+
+// spawnMetricsReporter starts emitting metrics to the configured backend. It
+// returns nil if metrics are not emitted anywhere.
+func spawnMetricsReporter(namespace *actor.ID, cluster string, cfg *config.Proxy, registry *metrics.Registry) (*metrics.Reporter, error) {
+	var backend metrics.Backend
+	var err error
+	switch cfg.Metrics.Backend {
+	case config.MetricsBackendStatsD:
+		backend, err = metrics.NewStatsD(cfg.Metrics.StatsD.Addr, cfg.Metrics.StatsD.Prefix)
+	case config.MetricsBackendDogStatsD:
+		backend, err = metrics.NewDogStatsD(cfg.Metrics.StatsD.Addr, cfg.Metrics.StatsD.Prefix, cfg.Metrics.StatsD.Tags)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metrics.SpawnReporter(namespace, registry, backend, cfg.Metrics.FlushInterval, "cluster", cluster), nil
 }
 
 // Produce submits a message to the specified `topic` of the Kafka cluster
@@ -136,13 +450,191 @@ func (p *T) Stop() {
 // Errors usually indicate a catastrophic failure of the Kafka cluster, or
 // missing topic if there cluster is not configured to auto create topics.
 func (p *T) Produce(topic string, key, message sarama.Encoder) (*sarama.ProducerMessage, error) {
-	return p.producer.Produce(topic, key, message)
+	prodMsg, _, err := p.ProduceWithAcks(topic, key, message, "")
+	return prodMsg, err
+}
+
+// ProduceWithAcks is like Produce, but the message is produced with the level
+// of acknowledgement reliability `acks`, one of AcksXXX, if it is allowed by
+// `Producer.AllowedAcks`. An empty `acks` means `Producer.RequiredAcks`. The
+// effective level is returned.
+func (p *T) ProduceWithAcks(topic string, key, message sarama.Encoder, acks string) (*sarama.ProducerMessage, string, error) {
+	prod, acks, err := p.producerFor(acks)
+	if err != nil {
+		return nil, "", err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := p.prodACL.check(topic); err != nil {
+		return nil, "", err
+	}
+	if err := p.ensureTopic(topic); err != nil {
+		return nil, "", err
+	}
+	if err := p.faults.Inject(chaos.OpProduce); err != nil {
+		return nil, "", err
+	}
+	prodMsg, err := prod.Produce(topic, key, message)
+	if err != nil {
+		return nil, "", err
+	}
+	p.topicStats.Produced(topic, encodedLen(key)+encodedLen(message))
+	p.tap.Produced(topic, key, message, prodMsg.Partition, prodMsg.Offset)
+	return prodMsg, acks, nil
 }
 
 // AsyncProduce is an asynchronously counterpart of the `Produce` function.
-// Errors are silently ignored.
-func (p *T) AsyncProduce(topic string, key, message sarama.Encoder) {
-	p.producer.AsyncProduce(topic, key, message)
+// Errors that occur after the message has been accepted are silently ignored.
+// An error is only returned if the topic is forbidden by the proxy ACL, or if
+// it does not exist and could not be created as `Producer.UnknownTopics`
+// prescribes.
+func (p *T) AsyncProduce(topic string, key, message sarama.Encoder) error {
+	_, err := p.AsyncProduceWithAcks(topic, key, message, "")
+	return err
+}
+
+// AsyncProduceWithAcks is an asynchronous counterpart of ProduceWithAcks.
+func (p *T) AsyncProduceWithAcks(topic string, key, message sarama.Encoder, acks string) (string, error) {
+	return p.AsyncProduceWithOutcome(topic, key, message, acks, OutcomeReceiver{})
+}
+
+// AsyncProduceWithOutcome is like AsyncProduceWithAcks, except the outcome of
+// the message, that is either the partition and offset it was written to or
+// the error it failed with, is reported to `receiver`. Callback URLs must
+// start with one of `Producer.Outcomes.URLPrefixes`, and result topics must
+// be allowed by the produce topic ACL. Outcomes are reported at most once,
+// and are lost if Kafka-Pixy is restarted before they are.
+func (p *T) AsyncProduceWithOutcome(topic string, key, message sarama.Encoder, acks string, receiver OutcomeReceiver) (string, error) {
+	prod, acks, err := p.producerFor(acks)
+	if err != nil {
+		return "", err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return "", err
+	}
+	if err := p.prodACL.check(topic); err != nil {
+		return "", err
+	}
+	if !receiver.IsZero() {
+		if receiver, err = p.checkOutcomeReceiver(receiver); err != nil {
+			return "", err
+		}
+	}
+	if err := p.ensureTopic(topic); err != nil {
+		return "", err
+	}
+	if err := p.faults.Inject(chaos.OpProduce); err != nil {
+		log.Errorf("<%s> message dropped: topic=%s, err=(%s)", p.actorID, topic, err)
+		if !receiver.IsZero() {
+			p.outcomes.report(receiver, &sarama.ProducerMessage{Topic: topic}, err)
+		}
+		return acks, nil
+	}
+	if receiver.IsZero() {
+		prod.AsyncProduce(topic, key, message)
+	} else {
+		prod.AsyncProduceWithCallback(topic, key, message, func(msg *sarama.ProducerMessage, err error) {
+			p.outcomes.report(receiver, msg, err)
+		})
+	}
+	p.topicStats.Produced(topic, encodedLen(key)+encodedLen(message))
+	p.tap.Produced(topic, key, message, -1, -1)
+	return acks, nil
+}
+
+// checkOutcomeReceiver makes sure that outcomes can be reported to a receiver,
+// and returns it with the result topic name normalized.
+func (p *T) checkOutcomeReceiver(receiver OutcomeReceiver) (OutcomeReceiver, error) {
+	if receiver.ID == "" {
+		return receiver, errors.Wrap(ErrInvalidOutcomeReceiver, "id is required")
+	}
+	if receiver.URL != "" {
+		if err := p.outcomes.checkURL(receiver.URL); err != nil {
+			return receiver, err
+		}
+	}
+	if receiver.Topic != "" {
+		topic, err := p.topicName(receiver.Topic)
+		if err != nil {
+			return receiver, err
+		}
+		if err := p.prodACL.check(topic); err != nil {
+			return receiver, err
+		}
+		receiver.Topic = topic
+	}
+	return receiver, nil
+}
+
+// encodedLen returns the length of an encoded message key or value, that can
+// be nil.
+func encodedLen(e sarama.Encoder) int {
+	if e == nil {
+		return 0
+	}
+	return e.Length()
+}
+
+// ensureTopic makes sure that a topic exists before a message is produced to
+// it, as `Producer.UnknownTopics` prescribes. In the `broker` mode, and in the
+// in-memory mode, it is up to the cluster to deal with unknown topics.
+func (p *T) ensureTopic(topic string) error {
+	if p.kafkaClt == nil || p.cfg.Producer.UnknownTopics == config.UnknownTopicsBroker {
+		return nil
+	}
+	// Other metadata errors are left to the producer to retry.
+	err := p.checkTopicExists(topic)
+	if errors.Cause(err) != sarama.ErrUnknownTopicOrPartition {
+		return nil
+	}
+	if p.cfg.Producer.UnknownTopics != config.UnknownTopicsCreate {
+		return err
+	}
+	autoCreate := p.cfg.Producer.AutoCreate
+	err = p.admin.CreateTopic(topic, autoCreate.Partitions, autoCreate.ReplicationFactor)
+	switch err {
+	case nil:
+		log.Infof("<%s> topic created: topic=%s, partitions=%d, replicationFactor=%d",
+			p.actorID, topic, autoCreate.Partitions, autoCreate.ReplicationFactor)
+	case admin.ErrTopicExists:
+	default:
+		return errors.Wrapf(err, "failed to create topic `%s`", topic)
+	}
+
+	// Wait for the controller to elect leaders of the new topic partitions.
+	deadline := time.Now().Add(topicCreateTimeout)
+	for {
+		if p.kafkaClt.RefreshMetadata(topic) == nil {
+			partitions, _ := p.kafkaClt.Partitions(topic)
+			writable, _ := p.kafkaClt.WritablePartitions(topic)
+			if len(partitions) > 0 && len(writable) == len(partitions) {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("topic `%s` created but not ready after %v", topic, topicCreateTimeout)
+		}
+		time.Sleep(topicCreateTimeout / 50)
+	}
+}
+
+// checkTopicExists returns an error caused by `ErrUnknownTopicOrPartition` if
+// the topic does not exist in the Kafka cluster. In the in-memory mode all
+// topics exist.
+func (p *T) checkTopicExists(topic string) error {
+	if p.kafkaClt == nil {
+		return nil
+	}
+	if _, err := p.kafkaClt.Partitions(topic); err != nil {
+		if err == sarama.ErrUnknownTopicOrPartition {
+			return errors.Wrapf(err, "topic `%s` does not exist", topic)
+		}
+		return errors.Wrapf(err, "failed to get topic `%s` metadata", topic)
+	}
+	return nil
 }
 
 // Consume consumes a message from the specified topic on behalf of the
@@ -157,7 +649,66 @@ func (p *T) AsyncProduce(topic string, key, message sarama.Encoder) {
 // available for consumption. In that case the user should back off a bit
 // and then repeat the request.
 func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
-	if ack != noAck && ack != autoAck {
+	return p.consume(group, topic, ack, "", consumer.ConsumeOpts{}, true)
+}
+
+// ConsumeWithAffinity is like Consume, except if routing is enabled and
+// `affinity` is ID of one of the peers, then the request is served by that
+// peer rather than by the home instance of the group. Optional parameters
+// given in `opts` override the proxy defaults. If the request claims several
+// messages, then they are all auto acknowledged in the auto-ack mode.
+func (p *T) ConsumeWithAffinity(group, topic string, ack Ack, affinity string, opts consumer.ConsumeOpts) (consumer.Message, error) {
+	return p.consume(group, topic, ack, affinity, opts, true)
+}
+
+// ConsumeLocal is like ConsumeWithAffinity, except the request is never
+// forwarded to the home instance of the group. It is used to serve requests
+// forwarded by peers.
+func (p *T) ConsumeLocal(group, topic string, ack Ack, opts consumer.ConsumeOpts) (consumer.Message, error) {
+	return p.consume(group, topic, ack, "", opts, false)
+}
+
+func (p *T) consume(group, topic string, ack Ack, affinity string, opts consumer.ConsumeOpts, forward bool) (consumer.Message, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return consumer.Message{}, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return consumer.Message{}, err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return consumer.Message{}, err
+	}
+	if err := p.checkTopicExists(topic); errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+		return consumer.Message{}, err
+	}
+	if !opts.OffsetReset.Time.IsZero() && p.kafkaClt != nil {
+		if err := p.cfg.CheckKafkaFeature(config.KafkaFeatureOffsetsByTime); err != nil {
+			return consumer.Message{}, err
+		}
+	}
+	if targetID := p.router.target(group, affinity); forward && p.router.isRemote(targetID) {
+		rs, err := p.router.forward(PeerConsumePath, targetID, PeerRq{
+			Group: group, Topic: topic, AckPartition: ack.partition, AckOffset: ack.offset,
+			OffsetReset: opts.OffsetReset.String(), MaxMessages: opts.MaxMessages,
+		})
+		if errors.Cause(err) != ErrPeerUnavailable {
+			if err != nil {
+				return consumer.Message{}, err
+			}
+			msg := fromPeerRs(topic, rs)
+			for _, followingRs := range rs.Following {
+				msg.Following = append(msg.Following, fromPeerRs(topic, followingRs))
+			}
+			return msg, nil
+		}
+		log.Warningf("<%s> consuming locally: group=%s, err=(%s)", p.actorID, group, err)
+	}
+	if err := p.faults.Inject(chaos.OpConsume); err != nil {
+		return consumer.Message{}, err
+	}
+	if ack != noAck && ack != autoAck && !p.ackReplay(group, topic, ack.partition, ack.offset) {
 		p.eventsChMapMu.RLock()
 		eventsChID := eventsChID{group, topic, ack.partition}
 		eventsCh, ok := p.eventsChMap[eventsChID]
@@ -173,11 +724,47 @@ func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
 			}()
 		}
 	}
-	msg, err := p.consumer.Consume(group, topic)
+	// Replayed messages are served ahead of live ones. If they cannot be
+	// read, then live messages are served, and reading is retried by the
+	// next request.
+	msg, ok, err := p.nextReplay(group, topic)
+	if err != nil {
+		log.Errorf("<%s> failed to read replayed messages: group=%s, topic=%s, err=(%s)", p.actorID, group, topic, err)
+	}
+	if ok {
+		p.topicStats.Consumed(group, topic, len(msg.Key)+len(msg.Value))
+		if ack == autoAck {
+			p.ackReplay(group, topic, msg.Partition, msg.Offset)
+		}
+		return msg, nil
+	}
+
+	p.consumerMu.RLock()
+	if p.consumer == nil {
+		err = ErrConsumerUnavailable
+	} else {
+		msg, err = p.consumer.ConsumeWithOpts(group, topic, opts)
+	}
+	p.consumerMu.RUnlock()
 	if err != nil {
 		return consumer.Message{}, err
 	}
 
+	p.topicStats.Consumed(group, topic, len(msg.Key)+len(msg.Value))
+	for _, followingMsg := range msg.Following {
+		p.topicStats.Consumed(group, topic, len(followingMsg.Key)+len(followingMsg.Value))
+	}
+	p.tap.Consumed(group, topic, msg.Partition, msg.Offset, msg.Key, msg.Value)
+	for _, followingMsg := range msg.Following {
+		p.tap.Consumed(group, topic, followingMsg.Partition, followingMsg.Offset, followingMsg.Key, followingMsg.Value)
+	}
+	if p.cfg.Consumer.EndToEndLatency {
+		p.recordE2ELatency(group, topic, msg)
+		for _, followingMsg := range msg.Following {
+			p.recordE2ELatency(group, topic, followingMsg)
+		}
+	}
+
 	eventsChID := eventsChID{group, topic, msg.Partition}
 	p.eventsChMapMu.Lock()
 	p.eventsChMap[eventsChID] = msg.EventsCh
@@ -185,11 +772,69 @@ func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
 
 	if ack == autoAck {
 		msg.EventsCh <- consumer.Ack(msg.Offset)
+		for _, followingMsg := range msg.Following {
+			msg.EventsCh <- consumer.Ack(followingMsg.Offset)
+		}
 	}
 	return msg, nil
 }
 
+// fromPeerRs returns a message consumed from the topic by a peer.
+func fromPeerRs(topic string, rs PeerRs) consumer.Message {
+	return consumer.Message{
+		Key:           rs.Key,
+		Value:         rs.Value,
+		Topic:         topic,
+		Partition:     rs.Partition,
+		Offset:        rs.Offset,
+		Timestamp:     rs.Timestamp,
+		HighWaterMark: rs.HighWaterMark,
+		Replay:        rs.Replay,
+		Skipped:       rs.Skipped,
+	}
+}
+
 func (p *T) Ack(group, topic string, ack Ack) error {
+	return p.ack(group, topic, ack, "", true)
+}
+
+// AckWithAffinity is like Ack, except if routing is enabled and `affinity` is
+// ID of one of the peers, then the request is served by that peer rather than
+// by the home instance of the group.
+func (p *T) AckWithAffinity(group, topic string, ack Ack, affinity string) error {
+	return p.ack(group, topic, ack, affinity, true)
+}
+
+// AckLocal is like Ack, except the request is never forwarded to the home
+// instance of the group. It is used to serve requests forwarded by peers.
+func (p *T) AckLocal(group, topic string, ack Ack) error {
+	return p.ack(group, topic, ack, "", false)
+}
+
+func (p *T) ack(group, topic string, ack Ack, affinity string, forward bool) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return err
+	}
+	if targetID := p.router.target(group, affinity); forward && p.router.isRemote(targetID) {
+		_, err := p.router.forward(PeerAckPath, targetID, PeerRq{
+			Group: group, Topic: topic, AckPartition: ack.partition, AckOffset: ack.offset,
+		})
+		return err
+	}
+	if err := p.faults.Inject(chaos.OpAck); err != nil {
+		return err
+	}
+	if p.ackReplay(group, topic, ack.partition, ack.offset) {
+		return nil
+	}
 	eventsChID := eventsChID{group, topic, ack.partition}
 	p.eventsChMapMu.RLock()
 	eventsCh, ok := p.eventsChMap[eventsChID]
@@ -205,22 +850,235 @@ func (p *T) Ack(group, topic string, ack Ack) error {
 	return nil
 }
 
+// Checkpoint commits an offset of a partition along with client metadata
+// on behalf of a consumer group, in one offset commit. All messages of the
+// partition before the offset are considered acknowledged. Unlike
+// SetGroupOffsets it goes through the partition consumer, so it cannot be
+// overridden by acknowledgements that are in flight. It blocks until the
+// offset is committed to Kafka, and fails if the partition is not consumed by
+// the group.
+func (p *T) Checkpoint(group, topic string, partition int32, offset int64, meta string) error {
+	return p.checkpoint(group, topic, partition, offset, meta, "", true)
+}
+
+// CheckpointWithAffinity is like Checkpoint, except if routing is enabled and
+// `affinity` is ID of one of the peers, then the request is served by that
+// peer rather than by the home instance of the group.
+func (p *T) CheckpointWithAffinity(group, topic string, partition int32, offset int64, meta, affinity string) error {
+	return p.checkpoint(group, topic, partition, offset, meta, affinity, true)
+}
+
+// CheckpointLocal is like Checkpoint, except the request is never forwarded
+// to the home instance of the group. It is used to serve requests forwarded
+// by peers.
+func (p *T) CheckpointLocal(group, topic string, partition int32, offset int64, meta string) error {
+	return p.checkpoint(group, topic, partition, offset, meta, "", false)
+}
+
+func (p *T) checkpoint(group, topic string, partition int32, offset int64, meta, affinity string, forward bool) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return err
+	}
+	if partition < 0 {
+		return errors.Errorf("bad partition: %d", partition)
+	}
+	if offset < 0 {
+		return errors.Errorf("bad offset: %d", offset)
+	}
+	if len(meta) > p.cfg.Consumer.MaxCheckpointSize {
+		return errors.Errorf("checkpoint metadata too large: %d bytes, max=%d",
+			len(meta), p.cfg.Consumer.MaxCheckpointSize)
+	}
+	if targetID := p.router.target(group, affinity); forward && p.router.isRemote(targetID) {
+		_, err := p.router.forward(PeerCheckpointPath, targetID, PeerRq{
+			Group: group, Topic: topic, AckPartition: partition, AckOffset: offset, CheckpointMeta: meta,
+		})
+		return err
+	}
+	eventsChID := eventsChID{group, topic, partition}
+	p.eventsChMapMu.RLock()
+	eventsCh, ok := p.eventsChMap[eventsChID]
+	p.eventsChMapMu.RUnlock()
+	if !ok {
+		return errors.New("acks channel missing")
+	}
+	doneCh := make(chan error, 1)
+	timeoutCh := time.After(p.cfg.Consumer.LongPollingTimeout)
+	select {
+	case eventsCh <- consumer.Checkpoint(offset, meta, doneCh):
+	case <-timeoutCh:
+		return errors.New("checkpoint timeout")
+	}
+	select {
+	case err := <-doneCh:
+		return err
+	case <-timeoutCh:
+		// The checkpoint may still be committed, GetCheckpoints tells.
+		return errors.New("checkpoint commit timeout")
+	}
+}
+
+// PartitionCheckpoint is the last checkpoint committed by a consumer group to
+// a partition, see Checkpoint.
+type PartitionCheckpoint struct {
+	Partition int32
+	Offset    int64
+	Meta      string
+
+	// Offset committed by the group at the moment. It is ahead of the
+	// checkpoint offset if messages after it have been acknowledged.
+	Committed int64
+}
+
+// GetCheckpoints returns the last checkpoints committed by a consumer group
+// to partitions of a topic. Partitions that have never been checkpointed are
+// not included.
+func (p *T) GetCheckpoints(group, topic string) ([]PartitionCheckpoint, error) {
+	offsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := []PartitionCheckpoint{}
+	for _, po := range offsets {
+		cp, ok := offsettrac.ParseCheckpoint(po.Metadata)
+		if !ok {
+			continue
+		}
+		checkpoints = append(checkpoints, PartitionCheckpoint{
+			Partition: po.Partition,
+			Offset:    cp.Offset,
+			Meta:      cp.Meta,
+			Committed: po.Offset,
+		})
+	}
+	return checkpoints, nil
+}
+
 // GetGroupOffsets for every partition of the specified topic it returns the
 // current offset range along with the latest offset and metadata committed by
 // the specified consumer group.
 func (p *T) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
 	return p.admin.GetGroupOffsets(group, topic)
 }
 
+// EffectiveConfig returns producer and consumer parameters in effect for a
+// consumer group consuming a topic, along with the config layers that they
+// come from. Partitions pinned to members for the group and the topic, if any,
+// are returned as the `partition_pins` parameter.
+func (p *T) EffectiveConfig(group, topic string) ([]config.Param, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
+	params := p.cfg.Effective(topic)
+	pins := make(map[string][]int32)
+	for _, pin := range p.cfg.PartitionPins {
+		if pin.Group == group && pin.Topic == topic {
+			pins[pin.Member] = append(pins[pin.Member], pin.Partitions...)
+		}
+	}
+	if len(pins) != 0 {
+		params = append(params, config.Param{Name: "partition_pins", Value: pins, Source: config.SourceProxy})
+	}
+	return params, nil
+}
+
 // SetGroupOffsets commits specific offset values along with metadata for a list
 // of partitions of a particular topic on behalf of the specified group.
 func (p *T) SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return err
+	}
 	return p.admin.SetGroupOffsets(group, topic, offsets)
 }
 
+// DryRunSetGroupOffsets validates a SetGroupOffsets request and returns the
+// changes that it would make, without committing anything.
+func (p *T) DryRunSetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) ([]admin.OffsetChange, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
+	current, err := p.admin.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	return admin.PlanOffsetChanges(current, offsets)
+}
+
+// WatchLag starts watching offsets and lag of a consumer group in all
+// partitions of a topic. Close should be called on the returned watcher when
+// it is not needed anymore.
+func (p *T) WatchLag(group, topic string, opts lagwatch.Opts) (*lagwatch.Watcher, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
+	return p.lagWatch.Watch(group, topic, opts), nil
+}
+
 // GetTopicConsumers returns client-id -> consumed-partitions-list mapping
 // for a clients from a particular consumer group and a particular topic.
 func (p *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
 	return p.admin.GetTopicConsumers(group, topic)
 }
 
@@ -228,5 +1086,260 @@ func (p *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 // mapping for a particular topic. Warning, the function performs scan of all
 // consumer groups registered in ZooKeeper and therefore can take a lot of time.
 func (p *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
 	return p.admin.GetAllTopicConsumers(topic)
 }
+
+// GetTopicConsumersPage is like GetAllTopicConsumers, except only consumer
+// groups on the specified page are scanned. It also tells whether there are
+// more groups to scan after the page.
+func (p *T) GetTopicConsumersPage(topic string, pg admin.Page) (map[string]map[string][]int32, bool, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, false, err
+	}
+	return p.admin.GetTopicConsumersPage(topic, pg)
+}
+
+// ScanTopicConsumers is like GetTopicConsumersPage, except it calls `fn` with
+// consumers of every group as soon as they are fetched, rather than returning
+// all of them at the end of the scan.
+func (p *T) ScanTopicConsumers(topic string, pg admin.Page, fn func(group string, consumers map[string][]int32, err error)) (bool, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return false, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return false, err
+	}
+	return p.admin.ScanTopicConsumers(topic, pg, fn)
+}
+
+// ListTopics returns a page of sorted names of topics that admin operations
+// are allowed on by the topic ACL. It also tells whether there are more topics
+// after the page.
+func (p *T) ListTopics(pg admin.Page) ([]string, bool, error) {
+	topics, err := p.admin.ListTopics()
+	if err != nil {
+		return nil, false, err
+	}
+	allowed := topics[:0]
+	for _, topic := range topics {
+		if p.adminACL.check(topic) == nil {
+			allowed = append(allowed, topic)
+		}
+	}
+	page, more := pg.Select(allowed)
+	return page, more, nil
+}
+
+// ListGroups returns a page of sorted names of consumer groups. It also tells
+// whether there are more groups after the page.
+func (p *T) ListGroups(pg admin.Page) ([]string, bool, error) {
+	groups, err := p.admin.ListGroups()
+	if err != nil {
+		return nil, false, err
+	}
+	page, more := pg.Select(groups)
+	return page, more, nil
+}
+
+// RebalanceGroup makes all members of a consumer group release their
+// partitions and rebalance, regardless of what proxies they run in. Messages
+// that were consumed but not acknowledged before the call will be retried.
+func (p *T) RebalanceGroup(group string) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	return p.admin.RebalanceGroup(group)
+}
+
+// EvictGroupMember removes a member, that is a proxy with the specified client
+// ID, from a consumer group and releases partitions that it owns, so that
+// other members take them over. If the member is alive, then it joins the
+// group again after releasing its partitions.
+func (p *T) EvictGroupMember(group, memberID string) error {
+	group, err := p.groupName(group)
+	if err != nil {
+		return err
+	}
+	return p.admin.EvictGroupMember(group, memberID)
+}
+
+// ProducerMetadataStats returns the state of the producer topic metadata
+// cache.
+func (p *T) ProducerMetadataStats() producer.MetadataStats {
+	return p.producer.MetadataStats()
+}
+
+// Alerts returns alerts that are firing at the moment.
+func (p *T) Alerts() []alerts.Alert {
+	return p.alerts.Firing()
+}
+
+// OffsetCommitFailures returns partitions which offsets have not been
+// committed for longer than `consumer.offsets_commit_failure_threshold`.
+func (p *T) OffsetCommitFailures() []offsetmgr.Failure {
+	if p.offsetMgrF == nil {
+		return nil
+	}
+	return p.offsetMgrF.Failures()
+}
+
+// Metrics returns the registry of all metrics recorded by the proxy.
+func (p *T) Metrics() *metrics.Registry {
+	return p.metrics
+}
+
+// MessageSizeStats returns statistics of sizes of messages fetched from every
+// topic consumed by this instance.
+func (p *T) MessageSizeStats() map[string]sizestats.Stats {
+	return p.sizes.Stats()
+}
+
+// TopicStats returns statistics of messages produced to and consumed from a
+// topic via this instance since it started.
+func (p *T) TopicStats(topic string) (topicstats.Stats, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return topicstats.Stats{}, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return topicstats.Stats{}, err
+	}
+	return p.topicStats.Stats(topic), nil
+}
+
+// LookupKey returns the latest message with `key` in a topic listed in
+// `key_index.topics`, as of at most `key_index.max_staleness` ago.
+func (p *T) LookupKey(topic string, key []byte) (consumer.Message, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return consumer.Message{}, err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return consumer.Message{}, err
+	}
+	return p.keyIndex.Get(topic, key)
+}
+
+// InvalidateAdminCache drops all ZooKeeper data cached by consumers queries.
+func (p *T) InvalidateAdminCache() {
+	p.admin.InvalidateCache()
+}
+
+// AdminCacheStats returns statistics of the ZooKeeper data cache used by
+// consumers queries.
+func (p *T) AdminCacheStats() admin.CacheStats {
+	return p.admin.CacheStats()
+}
+
+// GroupState returns a summary of state machine transitions of a consumer
+// group, such as members joining and leaving, and partitions being claimed,
+// released and fetched, recorded since the proxy was spawned.
+func (p *T) GroupState(group string) (groupevents.GroupState, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return groupevents.GroupState{}, err
+	}
+	return p.groupEvents.State(group), nil
+}
+
+// WatchGroupEvents returns partition assignment events of a consumer group
+// with sequence numbers greater then `seq`. If there are none, then it blocks
+// for at most `Consumer.LongPollingTimeout` or until `cancelCh` is closed, and
+// returns an empty list. Events of topics that are not allowed for consumption
+// by the topic ACL are skipped.
+func (p *T) WatchGroupEvents(group string, seq int64, cancelCh <-chan struct{}) ([]groupevents.Event, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(p.cfg.Consumer.LongPollingTimeout)
+	for {
+		events := p.groupEvents.Wait(group, seq, deadline.Sub(time.Now()), cancelCh)
+		if len(events) == 0 {
+			return nil, nil
+		}
+		allowed := events[:0]
+		for _, ev := range events {
+			if p.consACL.check(ev.Topic) == nil {
+				allowed = append(allowed, ev)
+			}
+		}
+		if len(allowed) > 0 {
+			return allowed, nil
+		}
+		seq = events[len(events)-1].Seq
+	}
+}
+
+// AckTimeout returns how long the proxy waits for a message to be acknowledged
+// before offering it again.
+func (p *T) AckTimeout() time.Duration {
+	return p.cfg.Consumer.AckTimeout
+}
+
+// KafkaSeedPeers returns the Kafka brokers that the proxy discovers the
+// cluster from.
+func (p *T) KafkaSeedPeers() []string {
+	return p.cfg.Kafka.SeedPeers
+}
+
+// Tenants returns the registry of tenants sharing the cluster, or nil if there
+// are no tenants configured.
+func (p *T) Tenants() *tenancy.T {
+	return p.tenants
+}
+
+// Faults returns the fault injector of the proxy, or nil if fault injection
+// is disabled.
+func (p *T) Faults() *chaos.T {
+	return p.faults
+}
+
+// Rebalance forces all consumer groups of the proxy to rebalance. That is
+// done by stopping the consumer and spawning a new one in its place, that
+// connects to the current seed peers, hence consume requests are blocked
+// until all offsets are committed. Messages that
+// were consumed but not acknowledged before the call will be retried.
+//
+// Spawning is retried every `consumer.retry_backoff` up to
+// maxConsumerRespawnAttempts times. If all attempts fail, then the error is
+// returned, and consume requests fail with ErrConsumerUnavailable until the
+// next successful call.
+func (p *T) Rebalance() error {
+	if p.cfg.InMemory.Enabled {
+		return errors.New("not supported in in-memory mode")
+	}
+	p.consumerMu.Lock()
+	defer p.consumerMu.Unlock()
+	if p.consumer != nil {
+		p.consumer.Stop()
+		p.consumer = nil
+	}
+	var err error
+	for attempt := 1; attempt <= maxConsumerRespawnAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(p.cfg.Consumer.RetryBackoff)
+		}
+		var newConsumer consumer.T
+		newConsumer, err = consumerimpl.SpawnWithOpts(p.actorID, p.seedPeers.Apply(p.cfg), p.offsetMgrF, consumerimpl.Opts{Events: p.groupEvents, Sizes: p.sizes, Metrics: p.metrics})
+		if err == nil {
+			p.consumer = newConsumer
+			return nil
+		}
+		log.Errorf("<%s> failed to respawn consumer: attempt=%d, err=(%s)", p.actorID, attempt, err)
+	}
+	return errors.Wrapf(err, "failed to respawn consumer in %d attempts", maxConsumerRespawnAttempts)
+}
@@ -1,13 +1,50 @@
 package proxy
 
 import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/pkg/errors"
 )
 
+// Requests that got a proxy from a set before it was deregistered are given
+// that much time on top of the long polling timeout to complete, before the
+// proxy is stopped.
+const deregisterDrainMargin = 5 * time.Second
+
+var (
+	ErrProxyExists       = errors.New("proxy already exists")
+	ErrProxyNotFound     = errors.New("proxy does not exist")
+	ErrDefaultDeregister = errors.New("default proxy cannot be deregistered")
+)
+
 // Set represents a collection of proxy.T instances with a default value.
+// Proxies other than the default one can be registered and deregistered at
+// runtime.
 type Set struct {
+	mu         sync.RWMutex
 	proxies    map[string]*T
 	defaultPxy *T
+	dynamic    map[string]bool
+	pending    map[string]bool
+	failovers  map[string]*failover
+	stopping   sync.WaitGroup
+	stopCh     chan struct{}
+}
+
+// Member describes a proxy in a set.
+type Member struct {
+	Cluster string
+	Proxy   *T
+
+	// Whether the proxy is used by requests that do not specify a cluster.
+	Default bool
+
+	// Whether the proxy was registered at runtime, rather than configured.
+	Dynamic bool
 }
 
 // NewSet creates a proxy.Set from a cluster-to-proxy map and a default proxy.
@@ -18,17 +55,197 @@ func NewSet(proxies map[string]*T, defaultPxy *T) *Set {
 	if defaultPxy == nil {
 		panic("default proxy must be provided")
 	}
-	return &Set{proxies: proxies, defaultPxy: defaultPxy}
+	s := Set{
+		proxies:    make(map[string]*T, len(proxies)),
+		defaultPxy: defaultPxy,
+		dynamic:    make(map[string]bool),
+		pending:    make(map[string]bool),
+		failovers:  make(map[string]*failover),
+		stopCh:     make(chan struct{}),
+	}
+	for cluster, pxy := range proxies {
+		s.proxies[cluster] = pxy
+	}
+	return &s
 }
 
 // Get returns a proxy for a cluster name. If there is no proxy configured for
 // the cluster name, then the default proxy is returned.
 func (s *Set) Get(cluster string) (*T, error) {
+	s.mu.RLock()
 	if cluster == "" {
+		defer s.mu.RUnlock()
 		return s.defaultPxy, nil
 	}
-	if pxy := s.proxies[cluster]; pxy != nil {
+	pxy := s.proxies[cluster]
+	s.mu.RUnlock()
+	if pxy != nil {
 		return pxy, nil
 	}
 	return nil, errors.Errorf("proxy `%s` does not exist", cluster)
 }
+
+// Members returns all proxies of the set sorted by cluster name.
+func (s *Set) Members() []Member {
+	s.mu.RLock()
+	members := make([]Member, 0, len(s.proxies))
+	for cluster, pxy := range s.proxies {
+		members = append(members, Member{
+			Cluster: cluster,
+			Proxy:   pxy,
+			Default: pxy == s.defaultPxy,
+			Dynamic: s.dynamic[cluster],
+		})
+	}
+	s.mu.RUnlock()
+	sort.Sort(membersByCluster(members))
+	return members
+}
+
+// Register spawns a proxy to a cluster and adds it to the set. It fails with
+// ErrProxyExists if there is a proxy with the same cluster name already. If
+// the config has a standby cluster, then failover is enabled for the proxy.
+func (s *Set) Register(cluster string, cfg *config.Proxy) error {
+	s.mu.Lock()
+	if s.proxies[cluster] != nil || s.pending[cluster] {
+		s.mu.Unlock()
+		return errors.Wrapf(ErrProxyExists, "cluster=%s", cluster)
+	}
+	// The name is reserved while the proxy is being spawned, that involves
+	// connecting to the cluster, so that requests are not blocked meanwhile.
+	s.pending[cluster] = true
+	s.mu.Unlock()
+
+	pxy, err := Spawn(actor.RootID, cluster, cfg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, cluster)
+	if err != nil {
+		return errors.Wrapf(err, "failed to spawn proxy, cluster=%s", cluster)
+	}
+	s.proxies[cluster] = pxy
+	s.dynamic[cluster] = true
+	s.enableFailover(cluster, cfg)
+	return nil
+}
+
+// EnableFailover makes a proxy switch to the standby cluster of its config
+// when the primary one becomes unhealthy, and back once it recovers. It does
+// nothing if the config has no standby cluster.
+func (s *Set) EnableFailover(cluster string, cfg *config.Proxy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enableFailover(cluster, cfg)
+}
+
+func (s *Set) enableFailover(cluster string, cfg *config.Proxy) {
+	if cfg.StandbyCfg() == nil || s.failovers[cluster] != nil {
+		return
+	}
+	s.failovers[cluster] = spawnFailover(s, cluster, cfg, checkCluster)
+}
+
+// FailoverStatus tells which cluster a proxy is connected to. It fails with
+// ErrFailoverDisabled if the proxy has no standby cluster.
+func (s *Set) FailoverStatus(cluster string) (FailoverStatus, error) {
+	s.mu.RLock()
+	f := s.failovers[cluster]
+	_, ok := s.proxies[cluster]
+	s.mu.RUnlock()
+	if !ok {
+		return FailoverStatus{}, errors.Wrapf(ErrProxyNotFound, "cluster=%s", cluster)
+	}
+	if f == nil {
+		return FailoverStatus{}, errors.Wrapf(ErrFailoverDisabled, "cluster=%s", cluster)
+	}
+	return f.getStatus(), nil
+}
+
+// replace spawns a proxy with `cfg` and puts it in place of the current
+// proxy of the cluster, that is stopped in background once requests in
+// flight had time to complete.
+func (s *Set) replace(cluster string, cfg *config.Proxy) error {
+	pxy, err := Spawn(actor.RootID, cluster, cfg)
+	if err != nil {
+		return errors.Wrapf(err, "failed to spawn proxy, cluster=%s", cluster)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldPxy := s.proxies[cluster]
+	if oldPxy == nil {
+		// The proxy has been deregistered meanwhile.
+		pxy.Stop()
+		return errors.Wrapf(ErrProxyNotFound, "cluster=%s", cluster)
+	}
+	s.proxies[cluster] = pxy
+	if oldPxy == s.defaultPxy {
+		s.defaultPxy = pxy
+	}
+	s.stopDrained(oldPxy, nil)
+	return nil
+}
+
+// stopDrained stops a proxy that new requests cannot get anymore, once
+// requests in flight had time to complete, or when the set is stopped,
+// whichever comes first. If `f` is not nil, then it is stopped first.
+func (s *Set) stopDrained(pxy *T, f *failover) {
+	drainTimeout := pxy.cfg.Consumer.LongPollingTimeout + deregisterDrainMargin
+	actor.Spawn(pxy.actorID.NewChild("drain"), &s.stopping, func() {
+		if f != nil {
+			f.stop()
+		}
+		select {
+		case <-time.After(drainTimeout):
+		case <-s.stopCh:
+		}
+		pxy.Stop()
+	})
+}
+
+// Deregister removes a proxy from the set, so that new requests cannot get
+// it anymore. The proxy is stopped in background, once requests in flight
+// had time to complete, or when the set is stopped, whichever comes first.
+func (s *Set) Deregister(cluster string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pxy := s.proxies[cluster]
+	if pxy == nil {
+		return errors.Wrapf(ErrProxyNotFound, "cluster=%s", cluster)
+	}
+	if pxy == s.defaultPxy {
+		return errors.Wrapf(ErrDefaultDeregister, "cluster=%s", cluster)
+	}
+	delete(s.proxies, cluster)
+	delete(s.dynamic, cluster)
+	f := s.failovers[cluster]
+	delete(s.failovers, cluster)
+	s.stopDrained(pxy, f)
+	return nil
+}
+
+// Stop stops all proxies of the set, including those deregistered but not
+// stopped yet. It must be called when there are no requests in flight.
+func (s *Set) Stop() {
+	// Failovers replace proxies, so they have to be stopped first.
+	s.mu.Lock()
+	failovers := s.failovers
+	s.failovers = make(map[string]*failover)
+	s.mu.Unlock()
+	for _, f := range failovers {
+		f.stop()
+	}
+	close(s.stopCh)
+	var wg sync.WaitGroup
+	for _, member := range s.Members() {
+		actor.Spawn(member.Proxy.actorID.NewChild("stop"), &wg, member.Proxy.Stop)
+	}
+	wg.Wait()
+	s.stopping.Wait()
+}
+
+type membersByCluster []Member
+
+func (p membersByCluster) Len() int           { return len(p) }
+func (p membersByCluster) Less(i, j int) bool { return p[i].Cluster < p[j].Cluster }
+func (p membersByCluster) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type ProxySetSuite struct{}
+
+var _ = Suite(&ProxySetSuite{})
+
+func newTestProxy(cluster string) *T {
+	return &T{actorID: actor.RootID.NewChild(cluster), cfg: config.DefaultProxy()}
+}
+
+func (s *ProxySetSuite) TestMembers(c *C) {
+	foo, bar := newTestProxy("foo"), newTestProxy("bar")
+	set := NewSet(map[string]*T{"foo": foo, "bar": bar}, foo)
+
+	// When
+	members := set.Members()
+
+	// Then
+	c.Assert(members, DeepEquals, []Member{
+		{Cluster: "bar", Proxy: bar},
+		{Cluster: "foo", Proxy: foo, Default: true},
+	})
+}
+
+// The default proxy cannot be deregistered, for requests that do not specify
+// a cluster would have nowhere to go.
+func (s *ProxySetSuite) TestDeregisterError(c *C) {
+	foo := newTestProxy("foo")
+	set := NewSet(map[string]*T{"foo": foo}, foo)
+
+	// When/Then
+	c.Assert(errors.Cause(set.Deregister("foo")), Equals, ErrDefaultDeregister)
+	c.Assert(errors.Cause(set.Deregister("bar")), Equals, ErrProxyNotFound)
+	pxy, err := set.Get("foo")
+	c.Assert(err, IsNil)
+	c.Assert(pxy, Equals, foo)
+}
+
+// A cluster name cannot be registered twice.
+func (s *ProxySetSuite) TestRegisterExists(c *C) {
+	foo := newTestProxy("foo")
+	set := NewSet(map[string]*T{"foo": foo}, foo)
+
+	// When
+	err := set.Register("foo", config.DefaultProxy())
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrProxyExists)
+	c.Assert(err, ErrorMatches, "cluster=foo: proxy already exists")
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// DescribeClientQuotas returns all client quotas set in the cluster.
+func (p *T) DescribeClientQuotas() ([]admin.ClientQuotas, error) {
+	return p.admin.DescribeClientQuotas()
+}
+
+// AlterClientQuotas sets and removes client quotas of a user, a client ID, or
+// a client ID of a user, as `alter` prescribes. A nil value removes a quota.
+// Brokers pick changes up shortly after the call returns.
+func (p *T) AlterClientQuotas(entity admin.QuotaEntity, alter map[string]*float64) error {
+	if err := admin.CheckQuotaAlteration(entity, alter); err != nil {
+		return err
+	}
+	if p.kafkaClt != nil {
+		if entity.User != "" {
+			if err := p.cfg.CheckKafkaFeature(config.KafkaFeatureUserQuotas); err != nil {
+				return err
+			}
+		}
+		if _, ok := alter[admin.QuotaRequestPercentage]; ok {
+			if err := p.cfg.CheckKafkaFeature(config.KafkaFeatureRequestQuotas); err != nil {
+				return err
+			}
+		}
+	}
+	return p.admin.AlterClientQuotas(entity, alter)
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// PlanDeleteRecords validates a records deletion request and returns the
+// deletions that it would make, without deleting anything. Records are
+// deleted either before `offsets` given per partition, or before the first
+// record with a timestamp at or after `before` in all partitions of the topic.
+func (p *T) PlanDeleteRecords(topic string, offsets []admin.PartitionOffset, before time.Time) ([]admin.RecordsDeletion, error) {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.adminACL.check(topic); err != nil {
+		return nil, err
+	}
+	ranges, err := p.admin.TopicOffsets(topic)
+	if err != nil {
+		return nil, err
+	}
+	if !before.IsZero() {
+		if p.kafkaClt != nil {
+			if err := p.cfg.CheckKafkaFeature(config.KafkaFeatureOffsetsByTime); err != nil {
+				return nil, err
+			}
+		}
+		offsets = make([]admin.PartitionOffset, len(ranges))
+		for i, po := range ranges {
+			offset, err := p.admin.OffsetForTime(topic, po.Partition, before)
+			if err != nil {
+				return nil, err
+			}
+			offsets[i] = admin.PartitionOffset{Partition: po.Partition, Offset: offset}
+		}
+	}
+	return admin.PlanRecordsDeletion(ranges, offsets)
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidReplay is returned when a replay range does not make sense.
+var ErrInvalidReplay = errors.New("invalid replay")
+
+// ReplayRange is a range of messages of a partition to be re-delivered to a
+// group. Bounds are given either as offsets or as timestamps, a timestamp
+// bound takes precedence over the respective offset bound. A zero ToOffset
+// with no ToTime means up to the offset committed by the group.
+type ReplayRange struct {
+	Partition int32 `json:"partition"`
+
+	// Offset of the first message to replay.
+	FromOffset int64 `json:"from_offset"`
+
+	// Offset right after the last message to replay.
+	ToOffset int64 `json:"to_offset"`
+
+	FromTime time.Time `json:"from_time"`
+	ToTime   time.Time `json:"to_time"`
+}
+
+type replayID struct {
+	group string
+	topic string
+}
+
+// replayQueue holds messages to be replayed to a group consuming a topic.
+// Messages are read from Kafka in batches, as they are consumed.
+type replayQueue struct {
+	// Ranges that are yet to be read, with FromOffset moving forward as
+	// messages are read.
+	ranges   []ReplayRange
+	buffered []consumer.Message
+
+	// Messages that have been offered, but not acknowledged yet.
+	offers []replayOffer
+
+	// True while the next batch of messages is being read from Kafka.
+	reading bool
+}
+
+type replayOffer struct {
+	msg      consumer.Message
+	deadline time.Time
+}
+
+// Replay schedules ranges of messages of a topic to be re-delivered to a
+// group. Replayed messages are served by consume requests of the group ahead
+// of live messages, marked as such. They have to be acknowledged like live
+// messages, and are offered again if they are not within the ack timeout,
+// but their acknowledgements are tracked separately, so that offsets
+// committed by the group are not affected.
+//
+// Ranges are capped at the offsets that the group has committed, for only
+// messages that were consumed already can be told from live ones by offset.
+// Resolved ranges are returned in the order they were given.
+func (p *T) Replay(group, topic string, ranges []ReplayRange) ([]ReplayRange, error) {
+	return p.replay(group, topic, ranges, true)
+}
+
+// ReplayLocal is like Replay, except the request is never forwarded to the
+// home instance of the group. It is used to serve requests forwarded by
+// peers.
+func (p *T) ReplayLocal(group, topic string, ranges []ReplayRange) ([]ReplayRange, error) {
+	return p.replay(group, topic, ranges, false)
+}
+
+func (p *T) replay(group, topic string, ranges []ReplayRange, forward bool) ([]ReplayRange, error) {
+	group, err := p.groupName(group)
+	if err != nil {
+		return nil, err
+	}
+	topic, err = p.topicName(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, errors.Wrap(ErrInvalidReplay, "no ranges given")
+	}
+	// Replayed messages are served by the home instance of the group, along
+	// with the rest of the group traffic.
+	if targetID := p.router.target(group, ""); forward && p.router.isRemote(targetID) {
+		rs, err := p.router.forward(PeerReplayPath, targetID, PeerRq{Group: group, Topic: topic, Replay: ranges})
+		if errors.Cause(err) != ErrPeerUnavailable {
+			return rs.ReplayRanges, err
+		}
+		log.Warningf("<%s> replaying locally: group=%s, err=(%s)", p.actorID, group, err)
+	}
+	offsets, err := p.admin.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make([]ReplayRange, len(ranges))
+	for i, rr := range ranges {
+		if rr.Partition < 0 || int(rr.Partition) >= len(offsets) {
+			return nil, errors.Wrapf(ErrInvalidReplay, "bad partition: %d", rr.Partition)
+		}
+		po := offsets[rr.Partition]
+		if !rr.FromTime.IsZero() {
+			if rr.FromOffset, err = p.admin.OffsetForTime(topic, rr.Partition, rr.FromTime); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case !rr.ToTime.IsZero():
+			if rr.ToOffset, err = p.admin.OffsetForTime(topic, rr.Partition, rr.ToTime); err != nil {
+				return nil, err
+			}
+		case rr.ToOffset == 0:
+			rr.ToOffset = po.Offset
+		}
+		if rr.FromOffset < po.Begin {
+			rr.FromOffset = po.Begin
+		}
+		if rr.ToOffset > po.Offset {
+			rr.ToOffset = po.Offset
+		}
+		if rr.ToOffset < rr.FromOffset {
+			rr.ToOffset = rr.FromOffset
+		}
+		rr.FromTime, rr.ToTime = time.Time{}, time.Time{}
+		resolved[i] = rr
+	}
+
+	id := replayID{group, topic}
+	p.replaysMu.Lock()
+	q := p.replays[id]
+	if q == nil {
+		q = &replayQueue{}
+		p.replays[id] = q
+	}
+	for _, rr := range resolved {
+		if rr.FromOffset < rr.ToOffset {
+			q.ranges = append(q.ranges, rr)
+		}
+	}
+	p.replaysMu.Unlock()
+	log.Infof("<%s> replay scheduled: group=%s, topic=%s, ranges=%v", p.actorID, group, topic, resolved)
+	return resolved, nil
+}
+
+// nextReplay returns a message to be replayed to a group, if there is any,
+// offering it until it is acknowledged. Messages are read from Kafka without
+// holding the lock, and while one request is reading the next batch, others
+// are served live messages.
+func (p *T) nextReplay(group, topic string) (consumer.Message, bool, error) {
+	id := replayID{group, topic}
+	p.replaysMu.Lock()
+	defer p.replaysMu.Unlock()
+	q := p.replays[id]
+	if q == nil {
+		return consumer.Message{}, false, nil
+	}
+	for {
+		now := time.Now()
+		for i := range q.offers {
+			if now.After(q.offers[i].deadline) {
+				q.offers[i].deadline = now.Add(p.cfg.Consumer.AckTimeout)
+				return q.offers[i].msg, true, nil
+			}
+		}
+		if len(q.buffered) > 0 {
+			msg := q.buffered[0]
+			q.buffered = q.buffered[1:]
+			q.offers = append(q.offers, replayOffer{msg: msg, deadline: now.Add(p.cfg.Consumer.AckTimeout)})
+			return msg, true, nil
+		}
+		if len(q.ranges) == 0 {
+			if len(q.offers) == 0 && !q.reading {
+				delete(p.replays, id)
+			}
+			return consumer.Message{}, false, nil
+		}
+		if q.reading {
+			return consumer.Message{}, false, nil
+		}
+		// Only the request that reads removes ranges, so the first one stays
+		// the same while the lock is released.
+		rr := q.ranges[0]
+		count := p.cfg.Consumer.ChannelBufferSize
+		if remaining := rr.ToOffset - rr.FromOffset; remaining < int64(count) {
+			count = int(remaining)
+		}
+		q.reading = true
+		p.replaysMu.Unlock()
+		messages, err := p.admin.ReadMessages(topic, rr.Partition, rr.FromOffset, count)
+		p.replaysMu.Lock()
+		q.reading = false
+		if err != nil {
+			return consumer.Message{}, false, err
+		}
+		for _, msg := range messages {
+			if msg.Offset >= rr.ToOffset {
+				break
+			}
+			msg.Replay = true
+			q.buffered = append(q.buffered, msg)
+		}
+		if len(messages) == 0 || messages[len(messages)-1].Offset+1 >= rr.ToOffset {
+			q.ranges = q.ranges[1:]
+			continue
+		}
+		q.ranges[0].FromOffset = messages[len(messages)-1].Offset + 1
+	}
+}
+
+// ackReplay acknowledges a replayed message. It returns false if the ack is
+// not for a replayed message, and should be applied to live messages.
+func (p *T) ackReplay(group, topic string, partition int32, offset int64) bool {
+	id := replayID{group, topic}
+	p.replaysMu.Lock()
+	defer p.replaysMu.Unlock()
+	q := p.replays[id]
+	if q == nil {
+		return false
+	}
+	for i := range q.offers {
+		if q.offers[i].msg.Partition == partition && q.offers[i].msg.Offset == offset {
+			q.offers = append(q.offers[:i], q.offers[i+1:]...)
+			if len(q.offers) == 0 && len(q.buffered) == 0 && len(q.ranges) == 0 && !q.reading {
+				delete(p.replays, id)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// nackReplay returns a nacked replayed message. Unless the nack is fatal, the
+// message is offered again after the redelivery backoff. It returns false if
+// the nack is not for a replayed message.
+func (p *T) nackReplay(group, topic string, partition int32, offset int64, class string) (consumer.Message, bool) {
+	id := replayID{group, topic}
+	p.replaysMu.Lock()
+	defer p.replaysMu.Unlock()
+	q := p.replays[id]
+	if q == nil {
+		return consumer.Message{}, false
+	}
+	for i := range q.offers {
+		if q.offers[i].msg.Partition == partition && q.offers[i].msg.Offset == offset {
+			if class != consumer.NackFatal {
+				redelivery := p.cfg.TopicRedelivery(topic)
+				q.offers[i].deadline = time.Now().Add(redelivery.Delay(1))
+			}
+			return q.offers[i].msg, true
+		}
+	}
+	return consumer.Message{}, false
+}
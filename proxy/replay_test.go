@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type ReplaySuite struct {
+	pxy *T
+}
+
+var _ = Suite(&ReplaySuite{})
+
+func (s *ReplaySuite) SetUpTest(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.InMemory.Partitions = 1
+	cfg.Consumer.OffsetReset = config.OffsetResetEarliest
+	cfg.Consumer.LongPollingTimeout = 100 * time.Millisecond
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "replay", cfg)
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		_, err := s.pxy.Produce("foo", nil, sarama.StringEncoder(fmt.Sprintf("m%d", i)))
+		c.Assert(err, IsNil)
+	}
+	// Consume the first three messages, so that they can be replayed.
+	for i := 0; i < 3; i++ {
+		_, err := s.pxy.Consume("g1", "foo", AutoAck())
+		c.Assert(err, IsNil)
+	}
+	s.waitCommitted(c, 3)
+}
+
+func (s *ReplaySuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+}
+
+func (s *ReplaySuite) waitCommitted(c *C, offset int64) {
+	for i := 0; i < 100; i++ {
+		offsets, err := s.pxy.GetGroupOffsets("g1", "foo")
+		c.Assert(err, IsNil)
+		if offsets[0].Offset == offset {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("offset %d is not committed", offset)
+}
+
+// Replayed messages are served ahead of live ones, and their acks do not
+// affect the committed offset.
+func (s *ReplaySuite) TestReplay(c *C) {
+	// When
+	ranges, err := s.pxy.Replay("g1", "foo", []ReplayRange{{Partition: 0, FromOffset: 1}})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []ReplayRange{{Partition: 0, FromOffset: 1, ToOffset: 3}})
+
+	msg, err := s.pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m1")
+	c.Assert(msg.Replay, Equals, true)
+
+	ack, _ := NewAck(msg.Partition, msg.Offset)
+	msg, err = s.pxy.Consume("g1", "foo", ack)
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m2")
+	c.Assert(msg.Replay, Equals, true)
+	c.Assert(s.pxy.Ack("g1", "foo", Ack{msg.Partition, msg.Offset}), IsNil)
+
+	msg, err = s.pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m3")
+	c.Assert(msg.Replay, Equals, false)
+	s.waitCommitted(c, 3)
+	c.Assert(s.pxy.replays, HasLen, 0)
+}
+
+// A replayed message that is not acknowledged is offered again after the
+// ack timeout.
+func (s *ReplaySuite) TestReplayRetry(c *C) {
+	s.pxy.cfg.Consumer.AckTimeout = 100 * time.Millisecond
+	_, err := s.pxy.Replay("g1", "foo", []ReplayRange{{Partition: 0, FromOffset: 2}})
+	c.Assert(err, IsNil)
+	msg, err := s.pxy.Consume("g1", "foo", NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m2")
+
+	// When
+	time.Sleep(150 * time.Millisecond)
+	msg, err = s.pxy.Consume("g1", "foo", NoAck())
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Value), Equals, "m2")
+	c.Assert(msg.Replay, Equals, true)
+}
+
+// Ranges are capped at the committed offset, and a bad partition is rejected.
+func (s *ReplaySuite) TestReplayRanges(c *C) {
+	// When
+	ranges, err := s.pxy.Replay("g1", "foo", []ReplayRange{
+		{Partition: 0, FromOffset: 2, ToOffset: 5},
+		{Partition: 0, FromTime: time.Now().Add(time.Hour)},
+	})
+	_, badErr := s.pxy.Replay("g1", "foo", []ReplayRange{{Partition: 1}})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []ReplayRange{
+		{Partition: 0, FromOffset: 2, ToOffset: 3},
+		{Partition: 0, FromOffset: 5, ToOffset: 5},
+	})
+	c.Assert(errors.Cause(badErr), Equals, ErrInvalidReplay)
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HTTP API paths that peers forward requests to.
+	PeerConsumePath    = "/_peer/consume"
+	PeerAckPath        = "/_peer/ack"
+	PeerCheckpointPath = "/_peer/checkpoint"
+	PeerReplayPath     = "/_peer/replay"
+	PeerLifecyclePath  = "/_peer/lifecycle"
+	PeerNackPath       = "/_peer/nack"
+
+	// HTTP header that forwarded requests carry the routing secret in.
+	PeerSecretHeader = "X-Kafka-Pixy-Peer-Secret"
+
+	// Forwarded requests are given this much time on top of the long polling
+	// timeout to complete.
+	peerTimeoutMargin = 5 * time.Second
+)
+
+// ErrPeerUnavailable is returned when a request could not be forwarded to the
+// home instance of a consumer group.
+var ErrPeerUnavailable = errors.New("peer unavailable")
+
+// PeerRq is a consume, ack or checkpoint request forwarded to the home instance of a
+// consumer group.
+type PeerRq struct {
+	Cluster string `json:"cluster"`
+	Group   string `json:"group"`
+	Topic   string `json:"topic"`
+
+	// Ack is sent as a partition/offset pair, where partition is -1 for no
+	// ack, and -2 for auto ack, in the same way as they are encoded in Ack.
+	// Checkpoint requests send the checkpoint partition/offset pair here.
+	AckPartition int32 `json:"ack_partition"`
+	AckOffset    int64 `json:"ack_offset"`
+
+	CheckpointMeta string `json:"checkpoint_meta,omitempty"`
+
+	// Nack requests send the nacked partition/offset pair as the ack.
+	NackClass  string `json:"nack_class,omitempty"`
+	NackReason string `json:"nack_reason,omitempty"`
+
+	// OffsetReset is in the format accepted by consumer.ParseOffsetReset.
+	OffsetReset string `json:"offset_reset,omitempty"`
+	MaxMessages int    `json:"max_messages,omitempty"`
+
+	// Ranges of messages to replay, sent by replay requests.
+	Replay []ReplayRange `json:"replay,omitempty"`
+
+	// Either LifecycleStart or LifecycleStop, sent by lifecycle requests.
+	Lifecycle string `json:"lifecycle,omitempty"`
+}
+
+// PeerRs is a response to a forwarded request. It has either Error or a
+// consumed message set.
+type PeerRs struct {
+	Error string `json:"error,omitempty"`
+
+	// Key and Value are never omitted, so that empty ones are not mistaken
+	// for nil, that is for tombstones, by the receiving proxy.
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+
+	HighWaterMark int64 `json:"high_watermark,omitempty"`
+	Replay        bool  `json:"replay,omitempty"`
+	Skipped       int64 `json:"skipped,omitempty"`
+
+	// Following are messages claimed along with the returned one.
+	Following []PeerRs `json:"following,omitempty"`
+
+	// Resolved ranges returned by replay requests.
+	ReplayRanges []ReplayRange `json:"replay_ranges,omitempty"`
+}
+
+// Ack returns the ack encoded in the request.
+func (rq *PeerRq) Ack() Ack {
+	return Ack{partition: rq.AckPartition, offset: rq.AckOffset}
+}
+
+// router selects home instances of consumer groups and forwards requests to
+// them.
+type router struct {
+	cluster string
+	selfID  string
+	peerIDs []string
+	addrs   map[string]string
+	httpClt *http.Client
+
+	secretMu sync.RWMutex
+	secret   string
+}
+
+// newRouter returns nil if routing is not enabled for this instance.
+func newRouter(cluster string, cfg *config.Proxy) *router {
+	if _, ok := cfg.Routing.Peers[cfg.ClientID]; !ok {
+		return nil
+	}
+	r := router{
+		cluster: cluster,
+		selfID:  cfg.ClientID,
+		addrs:   cfg.Routing.Peers,
+		httpClt: &http.Client{Timeout: cfg.Consumer.LongPollingTimeout + peerTimeoutMargin},
+		secret:  cfg.Routing.Secret,
+	}
+	for id := range cfg.Routing.Peers {
+		r.peerIDs = append(r.peerIDs, id)
+	}
+	sort.Strings(r.peerIDs)
+	return &r
+}
+
+// getSecret returns the secret that requests between peers are authenticated
+// with.
+func (r *router) getSecret() string {
+	r.secretMu.RLock()
+	defer r.secretMu.RUnlock()
+	return r.secret
+}
+
+// setSecret replaces the routing secret, when it is rotated.
+func (r *router) setSecret(secret string) {
+	r.secretMu.Lock()
+	r.secret = secret
+	r.secretMu.Unlock()
+}
+
+// home returns ID of the home instance of a consumer group. It uses
+// rendezvous hashing, so when a peer is added or removed only groups that
+// have it as home are moved. If routing is disabled then an empty string is
+// returned.
+func (r *router) home(group string) string {
+	if r == nil {
+		return ""
+	}
+	var homeID string
+	var maxWeight uint64
+	for _, id := range r.peerIDs {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+		h.Write([]byte(group))
+		if weight := mix64(h.Sum64()); homeID == "" || weight > maxWeight {
+			homeID, maxWeight = id, weight
+		}
+	}
+	return homeID
+}
+
+// mix64 is the splitmix64 finalizer. FNV hashes of strings that differ in a
+// few bytes only are poorly distributed, so they are mixed before comparison.
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// target returns ID of the instance that requests of a consumer group should
+// be served by. That is the instance named by `affinity` if it is one of the
+// peers, or the home instance of the group otherwise.
+func (r *router) target(group, affinity string) string {
+	if r == nil {
+		return ""
+	}
+	if _, ok := r.addrs[affinity]; ok {
+		return affinity
+	}
+	return r.home(group)
+}
+
+// isRemote tells whether a request should be forwarded to the specified
+// target instance.
+func (r *router) isRemote(targetID string) bool {
+	return targetID != "" && targetID != r.selfID
+}
+
+// forward sends a request to the specified peer. Errors returned
+// by the home instance are translated back to errors of this package and the
+// consumer package, so that callers cannot tell a forwarded request from a
+// local one. If the home instance cannot be reached, then an error caused by
+// ErrPeerUnavailable is returned.
+func (r *router) forward(path, homeID string, rq PeerRq) (PeerRs, error) {
+	var rs PeerRs
+	rq.Cluster = r.cluster
+	body, err := json.Marshal(rq)
+	if err != nil {
+		return rs, err
+	}
+	httpRq, err := http.NewRequest("POST", "http://"+r.addrs[homeID]+path, bytes.NewReader(body))
+	if err != nil {
+		return rs, errors.Wrap(ErrPeerUnavailable, err.Error())
+	}
+	httpRq.Header.Set("Content-Type", "application/json")
+	httpRq.Header.Set(PeerSecretHeader, r.getSecret())
+	httpRs, err := r.httpClt.Do(httpRq)
+	if err != nil {
+		return rs, errors.Wrap(ErrPeerUnavailable, err.Error())
+	}
+	defer httpRs.Body.Close()
+	if err := json.NewDecoder(httpRs.Body).Decode(&rs); err != nil {
+		return rs, errors.Wrapf(ErrPeerUnavailable, "bad response from %s: %s", homeID, err)
+	}
+	switch httpRs.StatusCode {
+	case http.StatusOK:
+		return rs, nil
+	case http.StatusRequestTimeout:
+		return rs, consumer.ErrRequestTimeout
+	case http.StatusTooManyRequests:
+		return rs, consumer.ErrTooManyRequests
+	case http.StatusForbidden:
+		return rs, ErrTopicForbidden
+	case http.StatusConflict:
+		return rs, errors.Wrap(consumer.ErrCheckpointBehind, rs.Error)
+	case http.StatusNotFound:
+		return rs, errors.Wrap(sarama.ErrUnknownTopicOrPartition, rs.Error)
+	case http.StatusUnauthorized, http.StatusServiceUnavailable:
+		return rs, errors.Wrapf(ErrPeerUnavailable, "%s: %s", homeID, rs.Error)
+	}
+	return rs, errors.Errorf("%s: %s", homeID, rs.Error)
+}
+
+// RoutingHint returns ID and HTTP API address of the instance that requests of
+// a consumer group are served by, given the affinity that clients request.
+// If routing is disabled, then ID of this instance and an empty address are
+// returned.
+func (p *T) RoutingHint(group, affinity string) (string, string) {
+	if p.router == nil {
+		return p.cfg.ClientID, ""
+	}
+	group, _ = p.groupName(group)
+	targetID := p.router.target(group, affinity)
+	return targetID, p.router.addrs[targetID]
+}
+
+// IsPeer tells whether a forwarded request with the specified secret comes
+// from a peer. It is always false if routing is disabled.
+func (p *T) IsPeer(secret string) bool {
+	if p.router == nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(p.router.getSecret())) == 1
+}
+
+// UpdateSecrets makes the proxy use the routing secret, and the tenant and
+// operator tokens from the specified config, that should be the config the
+// proxy was created with, with secret references resolved again.
+func (p *T) UpdateSecrets(cfg *config.Proxy) {
+	if p.router != nil {
+		p.router.setSecret(cfg.Routing.Secret)
+	}
+	p.tenants.UpdateTokens(cfg.Tenants, cfg.OperatorTokens)
+}
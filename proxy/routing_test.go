@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type RoutingSuite struct{}
+
+var _ = Suite(&RoutingSuite{})
+
+func newRoutingCfg(clientID string, peers map[string]string) *config.Proxy {
+	cfg := config.DefaultProxy()
+	cfg.ClientID = clientID
+	cfg.Routing.Peers = peers
+	cfg.Routing.Secret = "s3cr3t"
+	return cfg
+}
+
+// Routing is disabled unless this instance is one of the peers.
+func (s *RoutingSuite) TestDisabled(c *C) {
+	r := newRouter("foo", newRoutingCfg("a", map[string]string{"b": "b:1", "c": "c:1"}))
+	c.Assert(r, IsNil)
+	c.Assert(r.home("g"), Equals, "")
+	c.Assert(r.isRemote(r.target("g", "b")), Equals, false)
+}
+
+// All peers select the same home for a group, and groups are spread among
+// all peers.
+func (s *RoutingSuite) TestHome(c *C) {
+	peers := map[string]string{"a": "a:1", "b": "b:1", "c": "c:1"}
+	ra := newRouter("foo", newRoutingCfg("a", peers))
+	rc := newRouter("foo", newRoutingCfg("c", peers))
+
+	homes := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		group := fmt.Sprintf("g%d", i)
+		home := ra.home(group)
+		c.Assert(rc.home(group), Equals, home)
+		c.Assert(ra.isRemote(ra.target(group, "")), Equals, home != "a")
+		homes[home]++
+	}
+	c.Assert(len(homes), Equals, 3)
+	for _, count := range homes {
+		c.Assert(count > 50, Equals, true)
+	}
+}
+
+// Removing a peer only moves groups that had it as home.
+func (s *RoutingSuite) TestHomeStable(c *C) {
+	r3 := newRouter("foo", newRoutingCfg("a", map[string]string{"a": "a:1", "b": "b:1", "c": "c:1"}))
+	r2 := newRouter("foo", newRoutingCfg("a", map[string]string{"a": "a:1", "b": "b:1"}))
+	for i := 0; i < 100; i++ {
+		group := fmt.Sprintf("g%d", i)
+		if home := r3.home(group); home != "c" {
+			c.Assert(r2.home(group), Equals, home)
+		}
+	}
+}
+
+func (s *RoutingSuite) TestForward(c *C) {
+	var rq PeerRq
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, PeerConsumePath)
+		c.Check(r.Header.Get(PeerSecretHeader), Equals, "s3cr3t")
+		json.NewDecoder(r.Body).Decode(&rq)
+		if rq.Topic == "empty" {
+			w.WriteHeader(http.StatusRequestTimeout)
+			json.NewEncoder(w).Encode(PeerRs{Error: "long polling timeout"})
+			return
+		}
+		json.NewEncoder(w).Encode(PeerRs{Value: []byte("bar"), Partition: 3, Offset: 42})
+	}))
+	defer srv.Close()
+	r := newRouter("foo", newRoutingCfg("a", map[string]string{
+		"a": "a:1", "b": strings.TrimPrefix(srv.URL, "http://"),
+	}))
+	group := "g"
+
+	// When
+	rs, err := r.forward(PeerConsumePath, "b", PeerRq{Group: group, Topic: "t", AckPartition: 1, AckOffset: 7})
+	_, errEmpty := r.forward(PeerConsumePath, "b", PeerRq{Group: group, Topic: "empty"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(rs, DeepEquals, PeerRs{Value: []byte("bar"), Partition: 3, Offset: 42})
+	c.Assert(errEmpty, Equals, consumer.ErrRequestTimeout)
+	c.Assert(rq.Cluster, Equals, "foo")
+	c.Assert(rq.Group, Equals, group)
+}
+
+// Empty keys and values of forwarded messages are not mistaken for nil ones,
+// and timestamps are preserved.
+func (s *RoutingSuite) TestForwardEmptyValue(c *C) {
+	timestamp := time.Date(2018, 3, 14, 15, 9, 26, 535000000, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(PeerRs{
+			Value: []byte{}, Partition: 3, Offset: 42, Timestamp: timestamp,
+			Following: []PeerRs{{Key: []byte{}, Partition: 3, Offset: 43}},
+		})
+	}))
+	defer srv.Close()
+	r := newRouter("foo", newRoutingCfg("a", map[string]string{
+		"a": "a:1", "b": strings.TrimPrefix(srv.URL, "http://"),
+	}))
+
+	// When
+	rs, err := r.forward(PeerConsumePath, "b", PeerRq{Group: "g", Topic: "t"})
+
+	// Then
+	c.Assert(err, IsNil)
+	msg := fromPeerRs("t", rs)
+	c.Assert(msg.Key, IsNil)
+	c.Assert(msg.Value, NotNil)
+	c.Assert(msg.Value, HasLen, 0)
+	c.Assert(msg.Timestamp.Equal(timestamp), Equals, true)
+	c.Assert(rs.Following, HasLen, 1)
+	c.Assert(rs.Following[0].Key, NotNil)
+	c.Assert(rs.Following[0].Value, IsNil)
+}
+
+// If the home instance is not reachable an error caused by ErrPeerUnavailable
+// is returned.
+func (s *RoutingSuite) TestForwardUnavailable(c *C) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+	r := newRouter("foo", newRoutingCfg("a", map[string]string{"a": "a:1", "b": addr}))
+
+	// When
+	_, err := r.forward(PeerAckPath, "b", PeerRq{Group: "g", Topic: "t"})
+
+	// Then
+	c.Assert(errors.Cause(err), Equals, ErrPeerUnavailable)
+}
+
+// A peer named by affinity is selected over the home instance, unknown
+// affinities are ignored.
+func (s *RoutingSuite) TestTarget(c *C) {
+	peers := map[string]string{"a": "a:1", "b": "b:1", "c": "c:1"}
+	r := newRouter("foo", newRoutingCfg("a", peers))
+	for i := 0; i < 10; i++ {
+		group := fmt.Sprintf("g%d", i)
+		c.Assert(r.target(group, "c"), Equals, "c")
+		c.Assert(r.target(group, "x"), Equals, r.home(group))
+		c.Assert(r.target(group, ""), Equals, r.home(group))
+	}
+}
+
+func (s *RoutingSuite) TestRoutingHint(c *C) {
+	cfg := newRoutingCfg("a", map[string]string{"a": "a:1", "b": "b:1"})
+	p := T{cfg: cfg, router: newRouter("foo", cfg)}
+	p.groupRules, _ = newNameRules("group", cfg.Names.Group)
+
+	id, addr := p.RoutingHint("g", "b")
+	c.Assert(id, Equals, "b")
+	c.Assert(addr, Equals, "b:1")
+
+	// If routing is disabled this instance serves all requests.
+	p = T{cfg: config.DefaultProxy()}
+	p.cfg.ClientID = "x"
+	id, addr = p.RoutingHint("g", "b")
+	c.Assert(id, Equals, "x")
+	c.Assert(addr, Equals, "")
+}
+
+func (s *RoutingSuite) TestIsPeer(c *C) {
+	p := T{router: newRouter("foo", newRoutingCfg("a", map[string]string{"a": "a:1"}))}
+	c.Assert(p.IsPeer("s3cr3t"), Equals, true)
+	c.Assert(p.IsPeer("foo"), Equals, false)
+	c.Assert(p.IsPeer(""), Equals, false)
+	c.Assert((&T{}).IsPeer(""), Equals, false)
+}
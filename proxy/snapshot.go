@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+)
+
+// Snapshot scans all partitions of a compacted topic from the oldest message
+// to the end as of the call, and calls `fn` with the latest message of every
+// key that starts with `keyPrefix`, in the order of partitions and offsets.
+// Keys which latest message is a tombstone, i.e. has a nil value, and
+// messages without a key are skipped. Nothing is committed on behalf of any
+// consumer group. If `fn` returns an error, then the scan stops and the error
+// is returned.
+//
+// Messages with the same key are expected to be in the same partition, so
+// keys are only tracked within the partition being scanned.
+func (p *T) Snapshot(topic string, keyPrefix []byte, fn func(msg consumer.Message) error) error {
+	topic, err := p.topicName(topic)
+	if err != nil {
+		return err
+	}
+	if err := p.consACL.check(topic); err != nil {
+		return err
+	}
+	offsets, err := p.admin.TopicOffsets(topic)
+	if err != nil {
+		return err
+	}
+	for _, po := range offsets {
+		latest, err := p.scanPartition(topic, po.Partition, po.Begin, po.End, keyPrefix)
+		if err != nil {
+			return err
+		}
+		for _, msg := range latest {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanPartition returns the latest messages of keys that start with
+// `keyPrefix` in a range of partition offsets, sorted by offset.
+func (p *T) scanPartition(topic string, partition int32, offset, end int64, keyPrefix []byte) ([]consumer.Message, error) {
+	latest := make(map[string]consumer.Message)
+	for offset < end {
+		count := p.cfg.Consumer.ChannelBufferSize
+		if remaining := end - offset; remaining < int64(count) {
+			count = int(remaining)
+		}
+		messages, err := p.admin.ReadMessages(topic, partition, offset, count)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) == 0 {
+			// Messages have been removed by retention since the scan started.
+			break
+		}
+		for _, msg := range messages {
+			if msg.Offset >= end {
+				break
+			}
+			offset = msg.Offset + 1
+			if msg.Key == nil || !bytes.HasPrefix(msg.Key, keyPrefix) {
+				continue
+			}
+			if msg.Value == nil {
+				delete(latest, string(msg.Key))
+				continue
+			}
+			latest[string(msg.Key)] = msg
+		}
+	}
+	sorted := make([]consumer.Message, 0, len(latest))
+	for _, msg := range latest {
+		sorted = append(sorted, msg)
+	}
+	sort.Sort(messagesByOffset(sorted))
+	return sorted, nil
+}
+
+type messagesByOffset []consumer.Message
+
+func (s messagesByOffset) Len() int           { return len(s) }
+func (s messagesByOffset) Less(i, j int) bool { return s[i].Offset < s[j].Offset }
+func (s messagesByOffset) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
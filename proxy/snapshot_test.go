@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type SnapshotSuite struct {
+	pxy *T
+}
+
+var _ = Suite(&SnapshotSuite{})
+
+func (s *SnapshotSuite) SetUpTest(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.InMemory.Partitions = 1
+	cfg.TopicACL.Consume.Deny = []string{"^secret"}
+	var err error
+	s.pxy, err = Spawn(actor.RootID, "snapshot", cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *SnapshotSuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+}
+
+func (s *SnapshotSuite) produce(c *C, key string, value sarama.Encoder) {
+	_, err := s.pxy.Produce("foo", sarama.StringEncoder(key), value)
+	c.Assert(err, IsNil)
+}
+
+func snapshot(c *C, pxy *T, topic, keyPrefix string) []string {
+	var entries []string
+	err := pxy.Snapshot(topic, []byte(keyPrefix), func(msg consumer.Message) error {
+		entries = append(entries, string(msg.Key)+"="+string(msg.Value))
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return entries
+}
+
+// The latest value of every key is returned in the order the values were
+// produced, and keys deleted by tombstones are skipped.
+func (s *SnapshotSuite) TestSnapshot(c *C) {
+	s.produce(c, "a", sarama.StringEncoder("1"))
+	s.produce(c, "b", sarama.StringEncoder("2"))
+	s.produce(c, "c", sarama.StringEncoder("3"))
+	s.produce(c, "a", sarama.StringEncoder("4"))
+	s.produce(c, "c", nil)
+	_, err := s.pxy.Produce("foo", nil, sarama.StringEncoder("5"))
+	c.Assert(err, IsNil)
+
+	// When
+	entries := snapshot(c, s.pxy, "foo", "")
+
+	// Then
+	c.Assert(entries, DeepEquals, []string{"b=2", "a=4"})
+}
+
+// Only keys that start with the prefix are returned.
+func (s *SnapshotSuite) TestSnapshotKeyPrefix(c *C) {
+	s.produce(c, "user:1", sarama.StringEncoder("alice"))
+	s.produce(c, "group:1", sarama.StringEncoder("admins"))
+	s.produce(c, "user:2", sarama.StringEncoder("bob"))
+
+	// When
+	entries := snapshot(c, s.pxy, "foo", "user:")
+
+	// Then
+	c.Assert(entries, DeepEquals, []string{"user:1=alice", "user:2=bob"})
+}
+
+// The scan stops at the first error returned by the callback.
+func (s *SnapshotSuite) TestSnapshotCallbackError(c *C) {
+	s.produce(c, "a", sarama.StringEncoder("1"))
+	s.produce(c, "b", sarama.StringEncoder("2"))
+	calls := 0
+
+	// When
+	err := s.pxy.Snapshot("foo", nil, func(msg consumer.Message) error {
+		calls++
+		return errors.New("kaboom")
+	})
+
+	// Then
+	c.Assert(err, ErrorMatches, "kaboom")
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *SnapshotSuite) TestSnapshotForbidden(c *C) {
+	err := s.pxy.Snapshot("secret", nil, func(msg consumer.Message) error { return nil })
+	c.Assert(errors.Cause(err), Equals, ErrTopicForbidden)
+}
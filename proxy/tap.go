@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/tap"
+	"github.com/pkg/errors"
+)
+
+// ErrTapDisabled is returned when a tap is requested from a proxy that
+// samples no topics.
+var ErrTapDisabled = errors.New("tap not configured")
+
+// Tap subscribes to messages sampled from `topics` as configured in the
+// `tap` section, or from all sampled topics if none is given. Topics must be
+// allowed by the admin topic ACL.
+func (p *T) Tap(topics []string) (*tap.Subscription, error) {
+	if p.tap == nil {
+		return nil, ErrTapDisabled
+	}
+	for i, topic := range topics {
+		topic, err := p.topicName(topic)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.adminACL.check(topic); err != nil {
+			return nil, err
+		}
+		topics[i] = topic
+	}
+	return p.tap.Subscribe(topics), nil
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"regexp"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// ErrTopicForbidden is returned when an operation is attempted on a topic that
+// is not allowed for the operation by the proxy topic ACL.
+var ErrTopicForbidden = errors.New("access to the topic is forbidden")
+
+// topicFilter is a compiled representation of config.TopicFilter.
+type topicFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func newTopicFilter(cfg config.TopicFilter) (*topicFilter, error) {
+	var tf topicFilter
+	var err error
+	if tf.allow, err = compilePatterns(cfg.Allow); err != nil {
+		return nil, err
+	}
+	if tf.deny, err = compilePatterns(cfg.Deny); err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// check returns ErrTopicForbidden if the topic does not pass the filter.
+func (tf *topicFilter) check(topic string) error {
+	allowed := len(tf.allow) == 0
+	for _, re := range tf.allow {
+		if re.MatchString(topic) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return ErrTopicForbidden
+	}
+	for _, re := range tf.deny {
+		if re.MatchString(topic) {
+			return ErrTopicForbidden
+		}
+	}
+	return nil
+}
+
+// compilePatterns compiles regular expressions so that they only match entire
+// topic names.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid topic pattern: %s", pattern)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TopicACLSuite struct{}
+
+var _ = Suite(&TopicACLSuite{})
+
+// An empty filter allows all topics.
+func (s *TopicACLSuite) TestEmpty(c *C) {
+	tf, err := newTopicFilter(config.TopicFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(tf.check("foo"), IsNil)
+	c.Assert(tf.check("__consumer_offsets"), IsNil)
+}
+
+// Patterns must match entire topic names, and deny patterns win.
+func (s *TopicACLSuite) TestAllowDeny(c *C) {
+	tf, err := newTopicFilter(config.TopicFilter{
+		Allow: []string{`orders\..*`, "events"},
+		Deny:  []string{`orders\.billing`},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(tf.check("orders.eu"), IsNil)
+	c.Assert(tf.check("events"), IsNil)
+	c.Assert(tf.check("events2"), Equals, ErrTopicForbidden)
+	c.Assert(tf.check("my.orders.eu"), Equals, ErrTopicForbidden)
+	c.Assert(tf.check("orders.billing"), Equals, ErrTopicForbidden)
+}
+
+func (s *TopicACLSuite) TestDenyOnly(c *C) {
+	tf, err := newTopicFilter(config.TopicFilter{Deny: []string{"__.*|billing"}})
+	c.Assert(err, IsNil)
+	c.Assert(tf.check("foo"), IsNil)
+	c.Assert(tf.check("__consumer_offsets"), Equals, ErrTopicForbidden)
+	c.Assert(tf.check("billing"), Equals, ErrTopicForbidden)
+	c.Assert(tf.check("billing2"), IsNil)
+}
+
+func (s *TopicACLSuite) TestInvalidPattern(c *C) {
+	_, err := newTopicFilter(config.TopicFilter{Allow: []string{"("}})
+	c.Assert(err, ErrorMatches, "invalid topic pattern: \\(: .*")
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/log"
+)
+
+// Ready tells whether the proxy has warmed up, that is fetched metadata of
+// the topics and started the consumers listed in `warm_up`, or given up on
+// the ones that failed after `warm_up.timeout`.
+func (p *T) Ready() bool {
+	select {
+	case <-p.readyCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// runWarmUp warms up everything listed in `warm_up`, retrying whatever fails
+// every `consumer.retry_backoff`, until it is all done or `warm_up.timeout`
+// expires.
+func (p *T) runWarmUp() {
+	defer close(p.readyCh)
+	topics := p.cfg.WarmUp.Topics
+	consumers := p.cfg.WarmUp.Consumers
+	if len(topics) == 0 && len(consumers) == 0 {
+		return
+	}
+	startedAt := time.Now()
+	timeoutCh := time.After(p.cfg.WarmUp.Timeout)
+	for {
+		if len(topics) > 0 {
+			if err := p.producer.WarmUp(topics...); err != nil {
+				log.Warningf("<%s> failed to warm up producer: topics=%v, err=(%s)", p.actorID, topics, err)
+			} else {
+				topics = nil
+			}
+		}
+		var failed []config.WarmUpConsumer
+		for _, wc := range consumers {
+			if err := p.StartConsumer(wc.Group, wc.Topic, consumer.OffsetReset{}); err != nil {
+				log.Warningf("<%s> failed to warm up consumer: group=%s, topic=%s, err=(%s)",
+					p.actorID, wc.Group, wc.Topic, err)
+				failed = append(failed, wc)
+			}
+		}
+		consumers = failed
+		if len(topics) == 0 && len(consumers) == 0 {
+			log.Infof("<%s> warmed up in %v", p.actorID, time.Since(startedAt))
+			return
+		}
+		select {
+		case <-time.After(p.cfg.Consumer.RetryBackoff):
+		case <-timeoutCh:
+			log.Errorf("<%s> warm-up timed out: topics=%v, consumers=%v", p.actorID, topics, consumers)
+			return
+		case <-p.warmUpStopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+type WarmUpSuite struct{}
+
+var _ = Suite(&WarmUpSuite{})
+
+// A proxy is ready as soon as everything listed in the warm-up section is
+// warmed up.
+func (s *WarmUpSuite) TestReady(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.WarmUp.Topics = []string{"foo"}
+	cfg.WarmUp.Consumers = []config.WarmUpConsumer{{Group: "g1", Topic: "foo"}}
+
+	// When
+	pxy, err := Spawn(actor.RootID, "warm_up", cfg)
+	c.Assert(err, IsNil)
+	defer pxy.Stop()
+
+	// Then
+	c.Assert(waitReady(pxy, 3*time.Second), Equals, true)
+}
+
+// Consumers that fail to warm up hold readiness back until the warm-up
+// timeout expires.
+func (s *WarmUpSuite) TestTimeout(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.TopicACL.Consume.Deny = []string{"^secret"}
+	cfg.WarmUp.Consumers = []config.WarmUpConsumer{{Group: "g1", Topic: "secret"}}
+	cfg.WarmUp.Timeout = 300 * time.Millisecond
+
+	// When
+	pxy, err := Spawn(actor.RootID, "warm_up", cfg)
+	c.Assert(err, IsNil)
+	defer pxy.Stop()
+
+	// Then
+	c.Assert(waitReady(pxy, 100*time.Millisecond), Equals, false)
+	c.Assert(waitReady(pxy, 3*time.Second), Equals, true)
+}
+
+func waitReady(pxy *T, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !pxy.Ready() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return pxy.Ready()
+}
@@ -0,0 +1,184 @@
+// Package secrets resolves references to sensitive config values, so that
+// tokens and passwords do not have to be written in config files in plain
+// text. Anywhere a string is expected in a config file, a mapping with the
+// only `secretRef` key can be given instead:
+//
+//	secret: {secretRef: "env:ROUTING_SECRET"}
+//
+// The following references are supported:
+//
+//	env:<name>            the value of an environment variable;
+//	file:<path>           the contents of a file, without trailing newlines;
+//	vault:<path>#<field>  a field of a HashiCorp Vault secret at the path,
+//	                      read from $VAULT_ADDR with $VAULT_TOKEN.
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// RefKey is the key of a mapping that references a secret.
+	RefKey = "secretRef"
+
+	envVaultAddr  = "VAULT_ADDR"
+	envVaultToken = "VAULT_TOKEN"
+	hdrVaultToken = "X-Vault-Token"
+	vaultTimeout  = 10 * time.Second
+)
+
+var providers = map[string]func(ref string) (string, error){
+	"env":   resolveEnv,
+	"file":  resolveFile,
+	"vault": resolveVault,
+}
+
+// Resolve returns the value of the secret that a reference points to.
+func Resolve(ref string) (string, error) {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return "", errors.Errorf("invalid secret reference: %s", ref)
+	}
+	provider, ok := providers[parts[0]]
+	if !ok {
+		return "", errors.Errorf("unknown secret provider: %s", parts[0])
+	}
+	value, err := provider(parts[1])
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %s", ref)
+	}
+	return value, nil
+}
+
+// ResolveYAML returns a YAML document with all secret references replaced by
+// the values they point to. If the document has no references then it is
+// returned intact, and false is returned along with it.
+func ResolveYAML(data []byte) ([]byte, bool, error) {
+	if !bytes.Contains(data, []byte(RefKey)) {
+		return data, false, nil
+	}
+	// Decoding into a MapSlice preserves the order of mappings, that matters
+	// for proxies, for the first one is the default.
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, errors.Wrap(err, "failed to parse config")
+	}
+	resolved, found, err := resolveNode(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		return data, false, nil
+	}
+	if data, err = yaml.Marshal(resolved); err != nil {
+		return nil, false, errors.Wrap(err, "failed to encode config")
+	}
+	return data, true, nil
+}
+
+// resolveNode replaces secret references in a decoded YAML node with their
+// values, and tells whether there were any.
+func resolveNode(node interface{}) (interface{}, bool, error) {
+	switch node := node.(type) {
+	case yaml.MapSlice:
+		if len(node) == 1 && node[0].Key == RefKey {
+			ref, ok := node[0].Value.(string)
+			if !ok {
+				return nil, false, errors.Errorf("%s must be a string, got %v", RefKey, node[0].Value)
+			}
+			value, err := Resolve(ref)
+			return value, true, err
+		}
+		found := false
+		for i := range node {
+			value, itemFound, err := resolveNode(node[i].Value)
+			if err != nil {
+				return nil, false, err
+			}
+			node[i].Value, found = value, found || itemFound
+		}
+		return node, found, nil
+	case []interface{}:
+		found := false
+		for i := range node {
+			value, itemFound, err := resolveNode(node[i])
+			if err != nil {
+				return nil, false, err
+			}
+			node[i], found = value, found || itemFound
+		}
+		return node, found, nil
+	default:
+		return node, false, nil
+	}
+}
+
+func resolveEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("environment variable is not set")
+	}
+	return value, nil
+}
+
+func resolveFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveVault reads a field of a secret from Vault. Both KV version 1 and
+// version 2 secret engines are supported.
+func resolveVault(ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", errors.New("expected <path>#<field>")
+	}
+	path, field := strings.Trim(parts[0], "/"), parts[1]
+	addr := os.Getenv(envVaultAddr)
+	if addr == "" {
+		return "", errors.Errorf("%s is not set", envVaultAddr)
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(hdrVaultToken, os.Getenv(envVaultToken))
+	httpClt := http.Client{Timeout: vaultTimeout}
+	res, err := httpClt.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("vault responded with %d", res.StatusCode)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", errors.Wrap(err, "invalid vault response")
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", errors.Errorf("no string field %s", field)
+	}
+	return value, nil
+}
@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type SecretsSuite struct{}
+
+var _ = Suite(&SecretsSuite{})
+
+func (s *SecretsSuite) TestResolveEnv(c *C) {
+	os.Setenv("SECRETS_TEST_VAR", "foo")
+	defer os.Unsetenv("SECRETS_TEST_VAR")
+
+	// When/Then
+	value, err := Resolve("env:SECRETS_TEST_VAR")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "foo")
+	_, err = Resolve("env:SECRETS_TEST_UNDEFINED")
+	c.Assert(err, ErrorMatches, "failed to resolve env:SECRETS_TEST_UNDEFINED: environment variable is not set")
+}
+
+// Trailing newlines that editors tend to add are trimmed.
+func (s *SecretsSuite) TestResolveFile(c *C) {
+	path := filepath.Join(c.MkDir(), "secret")
+	c.Assert(ioutil.WriteFile(path, []byte("bar\n"), 0600), IsNil)
+
+	// When
+	value, err := Resolve("file:" + path)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "bar")
+}
+
+func (s *SecretsSuite) TestResolveVault(c *C) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(hdrVaultToken) != "t1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/pixy":
+			w.Write([]byte(`{"data": {"data": {"token": "v2"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/pixy":
+			w.Write([]byte(`{"data": {"token": "v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+	os.Setenv(envVaultAddr, vault.URL)
+	defer os.Unsetenv(envVaultAddr)
+	os.Setenv(envVaultToken, "t1")
+	defer os.Unsetenv(envVaultToken)
+
+	for i, tc := range []struct {
+		ref   string
+		value string
+		err   string
+	}{
+		{ref: "vault:secret/data/pixy#token", value: "v2"},
+		{ref: "vault:/kv/pixy#token", value: "v1"},
+		{ref: "vault:kv/pixy#missing", err: "failed to resolve vault:kv/pixy#missing: no string field missing"},
+		{ref: "vault:kv/bazz#token", err: "failed to resolve vault:kv/bazz#token: vault responded with 404"},
+		{ref: "vault:kv/pixy", err: "failed to resolve vault:kv/pixy: expected <path>#<field>"},
+	} {
+		// When
+		value, err := Resolve(tc.ref)
+
+		// Then
+		if tc.err != "" {
+			c.Assert(err, ErrorMatches, tc.err, Commentf("case #%d", i))
+			continue
+		}
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Assert(value, Equals, tc.value, Commentf("case #%d", i))
+	}
+}
+
+func (s *SecretsSuite) TestResolveInvalid(c *C) {
+	_, err := Resolve("foo")
+	c.Assert(err, ErrorMatches, "invalid secret reference: foo")
+	_, err = Resolve("kms:foo")
+	c.Assert(err, ErrorMatches, "unknown secret provider: kms")
+}
+
+// References are replaced with values at any depth, in mappings and lists,
+// and the order of mappings is preserved.
+func (s *SecretsSuite) TestResolveYAML(c *C) {
+	os.Setenv("SECRETS_TEST_VAR", "foo")
+	defer os.Unsetenv("SECRETS_TEST_VAR")
+
+	// When
+	data, found, err := ResolveYAML([]byte(`
+proxies:
+  zz:
+    routing:
+      secret: {secretRef: "env:SECRETS_TEST_VAR"}
+  aa:
+    tenants:
+      t1:
+        tokens: [plain, {secretRef: "env:SECRETS_TEST_VAR"}]
+`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(string(data), Equals, ""+
+		"proxies:\n"+
+		"  zz:\n"+
+		"    routing:\n"+
+		"      secret: foo\n"+
+		"  aa:\n"+
+		"    tenants:\n"+
+		"      t1:\n"+
+		"        tokens:\n"+
+		"        - plain\n"+
+		"        - foo\n")
+}
+
+// Documents without references are returned as is.
+func (s *SecretsSuite) TestResolveYAMLNoRefs(c *C) {
+	original := []byte("client_id: 0123 # secretRef is mentioned in a comment\n")
+
+	// When
+	data, found, err := ResolveYAML(original)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+	c.Assert(string(data), Equals, string(original))
+}
+
+func (s *SecretsSuite) TestResolveYAMLError(c *C) {
+	_, _, err := ResolveYAML([]byte("secret: {secretRef: \"env:SECRETS_TEST_UNDEFINED\"}\n"))
+	c.Assert(err, ErrorMatches, "failed to resolve env:SECRETS_TEST_UNDEFINED: environment variable is not set")
+}
@@ -0,0 +1,306 @@
+package seedpeers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Resolver looks up DNS records. It is implemented by net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// T keeps lists of Kafka and ZooKeeper seed peers of a proxy with DNS SRV
+// records expanded to the `host:port` peers they point to, and expands them
+// anew every `dns.refresh_interval`. Host names are left as they are, for
+// both Kafka and ZooKeeper clients resolve them when they connect.
+type T struct {
+	actorID  *actor.ID
+	resolver Resolver
+	interval time.Duration
+	timeout  time.Duration
+	cfg      *config.Proxy
+	stopCh   chan none.T
+	wg       sync.WaitGroup
+
+	mu        sync.RWMutex
+	kafka     []string
+	zooKeeper []string
+}
+
+// Spawn expands seed peers of a proxy, and if there are SRV records among
+// them, starts expanding them periodically. It fails if any of the records
+// cannot be looked up.
+func Spawn(namespace *actor.ID, cfg *config.Proxy) (*T, error) {
+	return SpawnWithResolver(namespace, cfg, net.DefaultResolver)
+}
+
+// SpawnWithResolver is the same as Spawn, but looks records up with a
+// custom resolver.
+func SpawnWithResolver(namespace *actor.ID, cfg *config.Proxy, resolver Resolver) (*T, error) {
+	t := &T{
+		actorID:  namespace.NewChild("seed_peers"),
+		resolver: resolver,
+		interval: cfg.DNS.RefreshInterval,
+		timeout:  cfg.DNS.Timeout,
+		cfg:      cfg,
+		stopCh:   make(chan none.T),
+	}
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+	if hasSRV(cfg.Kafka.SeedPeers) || hasSRV(cfg.ZooKeeper.SeedPeers) {
+		actor.Spawn(t.actorID, &t.wg, t.run)
+	}
+	return t, nil
+}
+
+// Stop stops expanding SRV records.
+func (t *T) Stop() {
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+// Kafka returns the current Kafka seed peers.
+func (t *T) Kafka() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.kafka
+}
+
+// ZooKeeper returns the current ZooKeeper seed peers.
+func (t *T) ZooKeeper() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.zooKeeper
+}
+
+// Apply returns a copy of the proxy config with the current seed peers. If
+// there are no SRV records among the configured ones, then the config is
+// returned as is.
+func (t *T) Apply(cfg *config.Proxy) *config.Proxy {
+	if !hasSRV(cfg.Kafka.SeedPeers) && !hasSRV(cfg.ZooKeeper.SeedPeers) {
+		return cfg
+	}
+	applied := *cfg
+	applied.Kafka.SeedPeers = t.Kafka()
+	applied.ZooKeeper.SeedPeers = t.ZooKeeper()
+	return &applied
+}
+
+// ZooKeeperHostProvider returns a ZooKeeper host provider, that unlike the
+// default one, that resolves host names once on connect, resolves the
+// current seed peers again every time it runs out of servers to try.
+func (t *T) ZooKeeperHostProvider() zk.HostProvider {
+	return &zkHostProvider{t: t}
+}
+
+func (t *T) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.refresh(); err != nil {
+				log.Errorf("<%s> failed to refresh: err=(%s)", t.actorID, err)
+			}
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+func (t *T) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	kafka, err := Expand(ctx, t.resolver, t.cfg.Kafka.SeedPeers)
+	if err != nil {
+		return errors.Wrap(err, "kafka.seed_peers")
+	}
+	zooKeeper, err := Expand(ctx, t.resolver, t.cfg.ZooKeeper.SeedPeers)
+	if err != nil {
+		return errors.Wrap(err, "zoo_keeper.seed_peers")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.kafka != nil && !reflect.DeepEqual(t.kafka, kafka) {
+		log.Infof("<%s> kafka seed peers changed: %v", t.actorID, kafka)
+	}
+	if t.zooKeeper != nil && !reflect.DeepEqual(t.zooKeeper, zooKeeper) {
+		log.Infof("<%s> zookeeper seed peers changed: %v", t.actorID, zooKeeper)
+	}
+	t.kafka, t.zooKeeper = kafka, zooKeeper
+	return nil
+}
+
+// Expand replaces seed peers given as SRV record names, e.g.
+// `srv:_kafka._tcp.example.com`, with the `host:port` peers that the records
+// point to, ordered by priority. Other peers are kept as they are.
+func Expand(ctx context.Context, resolver Resolver, peers []string) ([]string, error) {
+	expanded := make([]string, 0, len(peers))
+	seen := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		name, ok := config.SeedPeerSRV(peer)
+		if !ok {
+			if !seen[peer] {
+				seen[peer] = true
+				expanded = append(expanded, peer)
+			}
+			continue
+		}
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to look up %s", peer)
+		}
+		// Records of the same priority are shuffled by weight, they are
+		// sorted here so that peers only change when records do.
+		sort.SliceStable(records, func(i, j int) bool {
+			if records[i].Priority != records[j].Priority {
+				return records[i].Priority < records[j].Priority
+			}
+			return records[i].Target < records[j].Target
+		})
+		for _, record := range records {
+			addr := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port))
+			if !seen[addr] {
+				seen[addr] = true
+				expanded = append(expanded, addr)
+			}
+		}
+	}
+	if len(expanded) == 0 {
+		return nil, errors.New("no seed peers")
+	}
+	return expanded, nil
+}
+
+// Resolve expands SRV records among seed peers, and resolves host names of
+// the resulting `host:port` peers to all their addresses. Peers that cannot
+// be resolved are skipped, unless none can.
+func Resolve(ctx context.Context, resolver Resolver, peers []string) ([]string, error) {
+	expanded, err := Expand(ctx, resolver, peers)
+	if err != nil {
+		return nil, err
+	}
+	var resolved []string
+	var lastErr error
+	for _, peer := range expanded {
+		host, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad peer: %s", peer)
+		}
+		addrs, err := resolver.LookupHost(ctx, host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, addr := range addrs {
+			resolved = append(resolved, net.JoinHostPort(addr, port))
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, errors.Wrap(lastErr, "failed to resolve seed peers")
+	}
+	sort.Strings(resolved)
+	return resolved, nil
+}
+
+func hasSRV(peers []string) bool {
+	for _, peer := range peers {
+		if _, ok := config.SeedPeerSRV(peer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// zkHostProvider implements zk.HostProvider. It is a copy of
+// zk.DNSHostProvider, except that it resolves seed peers again when it runs
+// out of servers, and keeps the servers it has if that fails.
+type zkHostProvider struct {
+	t       *T
+	mu      sync.Mutex
+	servers []string
+	curr    int
+	last    int
+}
+
+// Init is called by zk.Connect with the servers it is given, that are
+// ignored in favour of the current seed peers.
+func (hp *zkHostProvider) Init(servers []string) error {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if err := hp.resolve(); err != nil {
+		return err
+	}
+	hp.curr = -1
+	hp.last = -1
+	return nil
+}
+
+// Len returns the number of servers available.
+func (hp *zkHostProvider) Len() int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return len(hp.servers)
+}
+
+// Next returns the next server to connect to. retryStart is true if all
+// servers have been tried without Connected being called, and then the seed
+// peers are resolved again.
+func (hp *zkHostProvider) Next() (server string, retryStart bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.curr = (hp.curr + 1) % len(hp.servers)
+	retryStart = hp.curr == hp.last
+	if hp.last == -1 {
+		hp.last = 0
+	}
+	if retryStart {
+		if err := hp.resolve(); err != nil {
+			log.Errorf("<%s> failed to resolve zookeeper peers: err=(%s)", hp.t.actorID, err)
+		} else {
+			hp.curr, hp.last = 0, 0
+		}
+	}
+	return hp.servers[hp.curr], retryStart
+}
+
+// Connected notifies the provider of a successful connection.
+func (hp *zkHostProvider) Connected() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.last = hp.curr
+}
+
+// resolve must be called with the mutex held.
+func (hp *zkHostProvider) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), hp.t.timeout)
+	defer cancel()
+	peers := zk.FormatServers(append([]string(nil), hp.t.ZooKeeper()...))
+	resolved, err := Resolve(ctx, hp.t.resolver, peers)
+	if err != nil {
+		return err
+	}
+	// Randomize the order of the servers to avoid creating hotspots.
+	rand.Shuffle(len(resolved), func(i, j int) {
+		resolved[i], resolved[j] = resolved[j], resolved[i]
+	})
+	hp.servers = resolved
+	return nil
+}
@@ -0,0 +1,183 @@
+package seedpeers
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type SeedPeersSuite struct {
+	resolver *testResolver
+}
+
+var _ = Suite(&SeedPeersSuite{})
+
+// testResolver serves SRV records and host addresses from maps.
+type testResolver struct {
+	mu      sync.Mutex
+	records map[string][]*net.SRV
+	hosts   map[string][]string
+}
+
+func (r *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records, ok := r.records[name]
+	if !ok {
+		return "", nil, errors.Errorf("no such record: %s", name)
+	}
+	// Return a copy, for the caller sorts it.
+	return name, append([]*net.SRV(nil), records...), nil
+}
+
+func (r *testResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.Errorf("no such host: %s", host)
+	}
+	return addrs, nil
+}
+
+func (r *testResolver) setHosts(host string, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = addrs
+}
+
+func (s *SeedPeersSuite) SetUpTest(c *C) {
+	s.resolver = &testResolver{
+		records: map[string][]*net.SRV{
+			"_kafka._tcp.example.com": {
+				{Target: "k3.example.com.", Port: 9092, Priority: 20},
+				{Target: "k2.example.com.", Port: 9092, Priority: 10},
+				{Target: "k1.example.com.", Port: 9092, Priority: 10},
+			},
+			"_zookeeper._tcp.example.com": {
+				{Target: "zk.example.com.", Port: 2181},
+			},
+		},
+		hosts: map[string][]string{
+			"k1.example.com": {"10.0.0.1"},
+			"k2.example.com": {"10.0.0.2", "10.0.0.3"},
+			"zk.example.com": {"10.0.1.1"},
+		},
+	}
+}
+
+// SRV records are replaced with the peers they point to ordered by
+// priority, other peers are kept as they are, and duplicates are dropped.
+func (s *SeedPeersSuite) TestExpand(c *C) {
+	// When
+	expanded, err := Expand(context.Background(), s.resolver,
+		[]string{"srv:_kafka._tcp.example.com", "k1.example.com:9092", "localhost:9092"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(expanded, DeepEquals, []string{
+		"k1.example.com:9092", "k2.example.com:9092", "k3.example.com:9092", "localhost:9092"})
+
+	_, err = Expand(context.Background(), s.resolver, []string{"srv:_kafka._tcp.bogus.com"})
+	c.Assert(err, ErrorMatches, "failed to look up srv:_kafka._tcp.bogus.com: no such record.*")
+	_, err = Expand(context.Background(), s.resolver, nil)
+	c.Assert(err, ErrorMatches, "no seed peers")
+}
+
+// Host names are resolved to all their addresses, and those that cannot be
+// resolved are skipped.
+func (s *SeedPeersSuite) TestResolve(c *C) {
+	// When
+	resolved, err := Resolve(context.Background(), s.resolver, []string{"srv:_kafka._tcp.example.com"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(resolved, DeepEquals, []string{"10.0.0.1:9092", "10.0.0.2:9092", "10.0.0.3:9092"})
+
+	_, err = Resolve(context.Background(), s.resolver, []string{"bogus"})
+	c.Assert(err, ErrorMatches, "bad peer: bogus.*")
+	_, err = Resolve(context.Background(), s.resolver, []string{"k3.example.com:9092"})
+	c.Assert(err, ErrorMatches, "failed to resolve seed peers: no such host.*")
+}
+
+// Seed peers change when records they are given as do, the configured ones
+// are only replaced if there are SRV records among them.
+func (s *SeedPeersSuite) TestRefresh(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.Kafka.SeedPeers = []string{"srv:_kafka._tcp.example.com"}
+	cfg.ZooKeeper.SeedPeers = []string{"srv:_zookeeper._tcp.example.com"}
+	t, err := SpawnWithResolver(actor.RootID, cfg, s.resolver)
+	c.Assert(err, IsNil)
+	defer t.Stop()
+	c.Assert(t.Apply(cfg).Kafka.SeedPeers, DeepEquals, []string{
+		"k1.example.com:9092", "k2.example.com:9092", "k3.example.com:9092"})
+	c.Assert(t.Apply(cfg).ZooKeeper.SeedPeers, DeepEquals, []string{"zk.example.com:2181"})
+	s.resolver.mu.Lock()
+	s.resolver.records["_kafka._tcp.example.com"] = []*net.SRV{{Target: "k4.example.com.", Port: 9093}}
+	s.resolver.mu.Unlock()
+
+	// When
+	err = t.refresh()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(t.Kafka(), DeepEquals, []string{"k4.example.com:9093"})
+	c.Assert(cfg.Kafka.SeedPeers, DeepEquals, []string{"srv:_kafka._tcp.example.com"})
+	plainCfg := config.DefaultProxy()
+	c.Assert(t.Apply(plainCfg), Equals, plainCfg)
+}
+
+// A failed lookup keeps the peers found by the previous one.
+func (s *SeedPeersSuite) TestRefreshFailed(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.Kafka.SeedPeers = []string{"srv:_kafka._tcp.example.com"}
+	t, err := SpawnWithResolver(actor.RootID, cfg, s.resolver)
+	c.Assert(err, IsNil)
+	defer t.Stop()
+	s.resolver.mu.Lock()
+	delete(s.resolver.records, "_kafka._tcp.example.com")
+	s.resolver.mu.Unlock()
+
+	// When
+	err = t.refresh()
+
+	// Then
+	c.Assert(err, ErrorMatches, "kafka.seed_peers: failed to look up.*")
+	c.Assert(t.Kafka(), HasLen, 3)
+	_, err = SpawnWithResolver(actor.RootID, cfg, s.resolver)
+	c.Assert(err, ErrorMatches, "kafka.seed_peers: failed to look up.*")
+}
+
+// The ZooKeeper host provider resolves peers again once it has tried all the
+// servers it has.
+func (s *SeedPeersSuite) TestZooKeeperHostProvider(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.ZooKeeper.SeedPeers = []string{"srv:_zookeeper._tcp.example.com"}
+	t, err := SpawnWithResolver(actor.RootID, cfg, s.resolver)
+	c.Assert(err, IsNil)
+	defer t.Stop()
+	hp := t.ZooKeeperHostProvider()
+	c.Assert(hp.Init([]string{"ignored:2181"}), IsNil)
+	server, retryStart := hp.Next()
+	c.Assert(server, Equals, "10.0.1.1:2181")
+	c.Assert(retryStart, Equals, false)
+	s.resolver.setHosts("zk.example.com", "10.0.1.2")
+
+	// When
+	server, retryStart = hp.Next()
+
+	// Then
+	c.Assert(retryStart, Equals, true)
+	c.Assert(server, Equals, "10.0.1.2:2181")
+	c.Assert(hp.Len(), Equals, 1)
+}
@@ -0,0 +1,131 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// Record describes an API request served by Kafka-Pixy.
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+
+	// API that the request came from: http or grpc.
+	API        string `json:"api"`
+	RemoteAddr string `json:"remote_addr"`
+
+	// Identity of the client, if it could be established.
+	ClientID string `json:"client_id,omitempty"`
+
+	// HTTP method and path, or gRPC method, e.g. `/KafkaPixy/Produce`.
+	Method string `json:"method"`
+	Path   string `json:"path,omitempty"`
+
+	// HTTP status, or gRPC code, e.g. `NotFound`.
+	Status string `json:"status"`
+
+	Latency  time.Duration `json:"-"`
+	Topic    string        `json:"topic,omitempty"`
+	Group    string        `json:"group,omitempty"`
+	BytesIn  int64         `json:"bytes_in"`
+	BytesOut int64         `json:"bytes_out"`
+}
+
+// T writes access log records. It is safe for concurrent use. A nil instance
+// is valid, it discards all records.
+type T struct {
+	mu     sync.Mutex
+	format string
+	w      io.Writer
+	closer io.Closer
+}
+
+// New creates an access log that writes records in the specified format to w.
+func New(format string, w io.Writer) *T {
+	return &T{format: format, w: w}
+}
+
+// Open creates an access log as configured. If the log is disabled then nil
+// is returned.
+func Open(cfg config.AccessLog) (*T, error) {
+	if cfg.Format == config.AccessLogNone {
+		return nil, nil
+	}
+	if cfg.Path == "" {
+		return New(cfg.Format, os.Stdout), nil
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open access log")
+	}
+	al := New(cfg.Format, f)
+	al.closer = f
+	return al, nil
+}
+
+// Log writes a record to the access log.
+func (al *T) Log(rec *Record) {
+	if al == nil {
+		return
+	}
+	var line []byte
+	switch al.format {
+	case config.AccessLogJSON:
+		jsonRec := struct {
+			*Record
+			LatencyMs float64 `json:"latency_ms"`
+		}{rec, float64(rec.Latency) / float64(time.Millisecond)}
+		line, _ = json.Marshal(jsonRec)
+	default:
+		line = formatApache(rec)
+	}
+	line = append(line, '\n')
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.w.Write(line)
+}
+
+// Close closes the access log file, if the log writes to one.
+func (al *T) Close() error {
+	if al == nil || al.closer == nil {
+		return nil
+	}
+	return al.closer.Close()
+}
+
+// formatApache formats a record in the Apache common log format followed by
+// latency in microseconds, request ID, request size, topic and group. The
+// client identity goes to the userid field, e.g.:
+//
+//	10.0.0.1 - - [20/Mar/2017:10:12:01 +0000] "GET /topics/foo/messages" 200 157 1543 rid=3f2a9c0d5e81b7a4 in=0 topic=foo group=bar
+func formatApache(rec *Record) []byte {
+	request := rec.Method
+	if rec.Path != "" {
+		request += " " + rec.Path
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %s %d %d rid=%s in=%d",
+		dashIfEmpty(rec.RemoteAddr), dashIfEmpty(rec.ClientID), rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(request), rec.Status, rec.BytesOut, rec.Latency/time.Microsecond, rec.RequestID, rec.BytesIn)
+	if rec.Topic != "" {
+		line += " topic=" + rec.Topic
+	}
+	if rec.Group != "" {
+		line += " group=" + rec.Group
+	}
+	return []byte(line)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type AccessLogSuite struct{}
+
+var _ = Suite(&AccessLogSuite{})
+
+var testRecord = Record{
+	Time:       time.Date(2017, 3, 20, 10, 12, 1, 0, time.UTC),
+	RequestID:  "3f2a9c0d5e81b7a4",
+	API:        "http",
+	RemoteAddr: "10.0.0.1:51234",
+	Method:     "GET",
+	Path:       "/topics/foo/messages?group=bar",
+	Status:     "200",
+	Latency:    1543 * time.Microsecond,
+	Topic:      "foo",
+	Group:      "bar",
+	BytesIn:    0,
+	BytesOut:   157,
+}
+
+func (s *AccessLogSuite) TestApache(c *C) {
+	var buf bytes.Buffer
+	al := New(config.AccessLogApache, &buf)
+
+	// When
+	rec := testRecord
+	al.Log(&rec)
+
+	// Then
+	c.Assert(buf.String(), Equals, "10.0.0.1:51234 - - [20/Mar/2017:10:12:01 +0000] "+
+		"\"GET /topics/foo/messages?group=bar\" 200 157 1543 rid=3f2a9c0d5e81b7a4 in=0 topic=foo group=bar\n")
+}
+
+// The client identity is logged in the userid field.
+func (s *AccessLogSuite) TestApacheClientID(c *C) {
+	var buf bytes.Buffer
+	al := New(config.AccessLogApache, &buf)
+
+	// When
+	rec := testRecord
+	rec.ClientID = "c1"
+	rec.Topic, rec.Group = "", ""
+	al.Log(&rec)
+
+	// Then
+	c.Assert(buf.String(), Equals, "10.0.0.1:51234 - c1 [20/Mar/2017:10:12:01 +0000] "+
+		"\"GET /topics/foo/messages?group=bar\" 200 157 1543 rid=3f2a9c0d5e81b7a4 in=0\n")
+}
+
+func (s *AccessLogSuite) TestJSON(c *C) {
+	var buf bytes.Buffer
+	al := New(config.AccessLogJSON, &buf)
+
+	// When
+	rec := testRecord
+	al.Log(&rec)
+
+	// Then
+	var logged map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &logged), IsNil)
+	c.Assert(logged, DeepEquals, map[string]interface{}{
+		"time":        "2017-03-20T10:12:01Z",
+		"request_id":  "3f2a9c0d5e81b7a4",
+		"api":         "http",
+		"remote_addr": "10.0.0.1:51234",
+		"method":      "GET",
+		"path":        "/topics/foo/messages?group=bar",
+		"status":      "200",
+		"topic":       "foo",
+		"group":       "bar",
+		"bytes_in":    float64(0),
+		"bytes_out":   float64(157),
+		"latency_ms":  1.543,
+	})
+}
+
+// A nil access log discards records.
+func (s *AccessLogSuite) TestNil(c *C) {
+	al, err := Open(config.AccessLog{Format: config.AccessLogNone})
+	c.Assert(err, IsNil)
+	c.Assert(al, IsNil)
+
+	rec := testRecord
+	al.Log(&rec)
+	c.Assert(al.Close(), IsNil)
+}
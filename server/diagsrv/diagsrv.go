@@ -0,0 +1,125 @@
+package diagsrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/log"
+	"github.com/mailgun/manners"
+	"github.com/pkg/errors"
+)
+
+const (
+	hdrContentType = "Content-Type"
+
+	// HTTP request parameters.
+	prmStacks = "stacks"
+)
+
+// T is a diagnostics HTTP server. It serves net/http/pprof profiles under
+// `/debug/pprof/` and a dump of all running actors at `/debug/actors`. It is
+// supposed to listen on a separate, usually a localhost, address since the
+// data it exposes is not meant for API clients.
+type T struct {
+	actorID    *actor.ID
+	listener   net.Listener
+	httpServer *manners.GracefulServer
+	wg         sync.WaitGroup
+	errorCh    chan error
+}
+
+type actorView struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	Goroutine int64     `json:"goroutine"`
+	State     string    `json:"state"`
+	Restarts  int       `json:"restarts"`
+	Stack     string    `json:"stack,omitempty"`
+}
+
+// New creates a diagnostics server that listens on the specified TCP address.
+func New(addr string) (*T, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create listener")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/actors", handleGetActors)
+	ds := &T{
+		actorID:    actor.RootID.NewChild(fmt.Sprintf("diag://%s", addr)),
+		listener:   manners.NewListener(listener),
+		httpServer: manners.NewWithServer(&http.Server{Handler: mux}),
+		errorCh:    make(chan error, 1),
+	}
+	return ds, nil
+}
+
+// Start triggers asynchronous diagnostics server start. If it fails then the
+// error will be sent down to `ErrorCh()`.
+func (s *T) Start() {
+	actor.Spawn(s.actorID, &s.wg, func() {
+		if err := s.httpServer.Serve(s.listener); err != nil {
+			s.errorCh <- errors.Wrap(err, "diagnostics server failed")
+		}
+	})
+}
+
+// ErrorCh returns an output channel that the server running in another
+// goroutine will use if it stops with error. The channel will be closed when
+// the server is fully stopped due to an error or otherwise.
+func (s *T) ErrorCh() <-chan error {
+	return s.errorCh
+}
+
+// Stop gracefully stops the diagnostics server. Pending CPU profile and trace
+// requests are waited for.
+func (s *T) Stop() {
+	s.httpServer.Close()
+	s.wg.Wait()
+	close(s.errorCh)
+}
+
+// handleGetActors is an HTTP request handler for `GET /debug/actors`. Stacks
+// of actor goroutines are only included if the `stacks` parameter is given.
+func handleGetActors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, withStacks := r.URL.Query()[prmStacks]
+	infos := actor.Dump()
+	views := make([]actorView, len(infos))
+	for i, info := range infos {
+		views[i] = actorView{
+			ID:        info.ID,
+			StartedAt: info.StartedAt,
+			Goroutine: info.Goroutine,
+			State:     info.State,
+			Restarts:  info.Restarts,
+		}
+		if withStacks {
+			views[i].Stack = info.Stack
+		}
+	}
+	encoded, err := json.MarshalIndent(views, "", "  ")
+	if err != nil {
+		log.Errorf("Failed to send actor dump: err=%+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add(hdrContentType, "application/json")
+	if _, err := w.Write(encoded); err != nil {
+		log.Errorf("Failed to send actor dump: err=%+v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package errcode
+
+import (
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/keyindex"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/server/identity"
+	"github.com/mailgun/kafka-pixy/server/netpolicy"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+)
+
+// Codes of errors returned by the HTTP and gRPC APIs. Unlike error messages
+// they are part of the API, so clients can rely on them to decide whether a
+// request should be retried. Existing codes must never change.
+const (
+	// Generic codes of errors with no specific cause.
+	Internal        = "INTERNAL"
+	InvalidArgument = "INVALID_ARGUMENT"
+	NotFound        = "NOT_FOUND"
+	Unavailable     = "UNAVAILABLE"
+
+	Unauthenticated    = "UNAUTHENTICATED"
+	QuotaExceeded      = "QUOTA_EXCEEDED"
+	TopicForbidden     = "TOPIC_FORBIDDEN"
+	TopicNotFound      = "TOPIC_NOT_FOUND"
+	TopicExists        = "TOPIC_EXISTS"
+	FeatureUnsupported = "KAFKA_FEATURE_UNSUPPORTED"
+	LongPollingTimeout = "LONG_POLLING_TIMEOUT"
+	TooManyRequests    = "TOO_MANY_REQUESTS"
+	PeerUnavailable    = "PEER_UNAVAILABLE"
+	OffsetOutOfRange   = "OFFSET_OUT_OF_RANGE"
+	GroupRebalancing   = "GROUP_REBALANCING"
+	KafkaUnavailable   = "KAFKA_UNAVAILABLE"
+	FaultInjected      = "FAULT_INJECTED"
+	CheckpointBehind   = "CHECKPOINT_BEHIND"
+	TooManyTopics      = "TOO_MANY_TOPICS"
+	OpNotAllowed       = "OPERATION_NOT_ALLOWED"
+	AddrNotAllowed     = "ADDRESS_NOT_ALLOWED"
+	ResponseTooLarge   = "RESPONSE_TOO_LARGE"
+	AcksNotAllowed     = "ACKS_NOT_ALLOWED"
+	TopicNotIndexed    = "TOPIC_NOT_INDEXED"
+	KeyNotFound        = "KEY_NOT_FOUND"
+)
+
+var causeCodes = map[error]string{
+	tenancy.ErrUnauthenticated:                Unauthenticated,
+	tenancy.ErrQuotaExceeded:                  QuotaExceeded,
+	identity.ErrRateLimited:                   QuotaExceeded,
+	server.ErrOpNotAllowed:                    OpNotAllowed,
+	netpolicy.ErrAddrNotAllowed:               AddrNotAllowed,
+	server.ErrResponseTooLarge:                ResponseTooLarge,
+	proxy.ErrInvalidName:                      InvalidArgument,
+	proxy.ErrInvalidNack:                      InvalidArgument,
+	proxy.ErrTopicForbidden:                   TopicForbidden,
+	proxy.ErrAcksNotAllowed:                   AcksNotAllowed,
+	proxy.ErrPeerUnavailable:                  PeerUnavailable,
+	proxy.ErrConsumerUnavailable:              Unavailable,
+	admin.ErrTopicExists:                      TopicExists,
+	admin.ErrInvalidQuota:                     InvalidArgument,
+	admin.ErrBrokerNotFound:                   NotFound,
+	admin.ErrInvalidBrokerConfig:              InvalidArgument,
+	proxy.ErrBrokerConfigsReadOnly:            OpNotAllowed,
+	config.ErrKafkaFeatureUnsupported:         FeatureUnsupported,
+	consumer.ErrRequestTimeout:                LongPollingTimeout,
+	consumer.ErrTooManyRequests:               TooManyRequests,
+	consumer.ErrCheckpointBehind:              CheckpointBehind,
+	consumer.ErrTooManyTopics:                 TooManyTopics,
+	chaos.ErrInjected:                         FaultInjected,
+	keyindex.ErrNotIndexed:                    TopicNotIndexed,
+	keyindex.ErrKeyNotFound:                   KeyNotFound,
+	keyindex.ErrStale:                         Unavailable,
+	sarama.ErrUnknownTopicOrPartition:         TopicNotFound,
+	sarama.ErrOffsetOutOfRange:                OffsetOutOfRange,
+	sarama.ErrRebalanceInProgress:             GroupRebalancing,
+	sarama.ErrOutOfBrokers:                    KafkaUnavailable,
+	sarama.ErrLeaderNotAvailable:              KafkaUnavailable,
+	sarama.ErrNotLeaderForPartition:           KafkaUnavailable,
+	sarama.ErrRequestTimedOut:                 KafkaUnavailable,
+	sarama.ErrConsumerCoordinatorNotAvailable: KafkaUnavailable,
+}
+
+// Of returns the code of an error, or an empty string if the error cause does
+// not have a specific code.
+func Of(err error) string {
+	return causeCodes[errors.Cause(err)]
+}
+
+// ForHTTPStatus returns the code of an error with no specific cause that
+// results in the specified HTTP status.
+func ForHTTPStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return InvalidArgument
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return TopicForbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusRequestTimeout:
+		return LongPollingTimeout
+	case http.StatusTooManyRequests:
+		return TooManyRequests
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	}
+	return Internal
+}
+
+// ForGRPCCode is like ForHTTPStatus but for gRPC codes.
+func ForGRPCCode(code codes.Code) string {
+	switch code {
+	case codes.InvalidArgument:
+		return InvalidArgument
+	case codes.Unauthenticated:
+		return Unauthenticated
+	case codes.PermissionDenied:
+		return TopicForbidden
+	case codes.NotFound:
+		return NotFound
+	case codes.ResourceExhausted:
+		return TooManyRequests
+	case codes.Unavailable:
+		return Unavailable
+	}
+	return Internal
+}
@@ -0,0 +1,48 @@
+package errcode
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/keyindex"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/server/netpolicy"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ErrCodeSuite struct{}
+
+var _ = Suite(&ErrCodeSuite{})
+
+// Codes are determined by error causes.
+func (s *ErrCodeSuite) TestOf(c *C) {
+	c.Assert(Of(errors.Wrap(sarama.ErrUnknownTopicOrPartition, "topic `foo` does not exist")), Equals, TopicNotFound)
+	c.Assert(Of(errors.Wrap(proxy.ErrInvalidName, "bad topic")), Equals, InvalidArgument)
+	c.Assert(Of(consumer.ErrRequestTimeout), Equals, LongPollingTimeout)
+	c.Assert(Of(sarama.ErrRebalanceInProgress), Equals, GroupRebalancing)
+	c.Assert(Of(errors.Wrap(server.ErrOpNotAllowed, "mode=consume_only")), Equals, OpNotAllowed)
+	c.Assert(Of(errors.Wrap(netpolicy.ErrAddrNotAllowed, "addr=10.0.0.1")), Equals, AddrNotAllowed)
+	c.Assert(Of(errors.Wrap(server.ErrResponseTooLarge, "size=2048")), Equals, ResponseTooLarge)
+	c.Assert(Of(errors.Wrap(proxy.ErrAcksNotAllowed, "acks=none")), Equals, AcksNotAllowed)
+	c.Assert(Of(errors.Wrap(keyindex.ErrNotIndexed, "topic=foo")), Equals, TopicNotIndexed)
+	c.Assert(Of(errors.Wrap(keyindex.ErrKeyNotFound, "topic=foo")), Equals, KeyNotFound)
+	c.Assert(Of(errors.Wrap(keyindex.ErrStale, "topic=foo")), Equals, Unavailable)
+	c.Assert(Of(proxy.ErrConsumerUnavailable), Equals, Unavailable)
+	c.Assert(Of(errors.New("kaboom")), Equals, "")
+}
+
+func (s *ErrCodeSuite) TestForStatus(c *C) {
+	c.Assert(ForHTTPStatus(http.StatusBadRequest), Equals, InvalidArgument)
+	c.Assert(ForHTTPStatus(http.StatusBadGateway), Equals, Internal)
+	c.Assert(ForGRPCCode(codes.InvalidArgument), Equals, InvalidArgument)
+	c.Assert(ForGRPCCode(codes.Unknown), Equals, Internal)
+}
@@ -0,0 +1,69 @@
+package grpcsrv
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Buffers that messages are marshaled into are preallocated with that much
+// capacity, until the codec learns the size of actual messages.
+const initialMarshalBufferSize = 4096
+
+// protoCodec is a drop-in replacement for the default gRPC codec that avoids
+// allocating a proto.Buffer for every message, and marshals messages into
+// buffers of the size that the previous message marshaled with the same
+// proto.Buffer had, so that consume responses are not regrown a few times
+// while their payload is being copied in.
+type protoCodec struct {
+	bufPool sync.Pool
+}
+
+type cachedProtoBuffer struct {
+	proto.Buffer
+	lastMarshaledSize int
+}
+
+func newProtoCodec() *protoCodec {
+	return &protoCodec{
+		bufPool: sync.Pool{New: func() interface{} {
+			return &cachedProtoBuffer{lastMarshaledSize: initialMarshalBufferSize}
+		}},
+	}
+}
+
+// Marshal implements grpc.Codec. The returned slice is owned by the caller,
+// for gRPC does not tell when it is done with it.
+func (c *protoCodec) Marshal(v interface{}) ([]byte, error) {
+	cb := c.bufPool.Get().(*cachedProtoBuffer)
+	defer func() {
+		cb.SetBuf(nil)
+		c.bufPool.Put(cb)
+	}()
+	cb.SetBuf(make([]byte, 0, cb.lastMarshaledSize))
+	if err := cb.Marshal(v.(proto.Message)); err != nil {
+		return nil, err
+	}
+	out := cb.Bytes()
+	cb.lastMarshaledSize = len(out)
+	return out, nil
+}
+
+// Unmarshal implements grpc.Codec.
+func (c *protoCodec) Unmarshal(data []byte, v interface{}) error {
+	cb := c.bufPool.Get().(*cachedProtoBuffer)
+	defer func() {
+		cb.SetBuf(nil)
+		c.bufPool.Put(cb)
+	}()
+	cb.SetBuf(data)
+	msg := v.(proto.Message)
+	msg.Reset()
+	return cb.Unmarshal(msg)
+}
+
+// String implements grpc.Codec. It must be the same as of the default codec,
+// since it makes part of the content type.
+func (c *protoCodec) String() string {
+	return "proto"
+}
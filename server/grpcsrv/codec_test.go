@@ -0,0 +1,43 @@
+package grpcsrv
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CodecSuite struct{}
+
+var _ = Suite(&CodecSuite{})
+
+// Messages are marshaled the same way as by proto.Marshal, even when the size
+// hint learned from previous messages is too small.
+func (s *CodecSuite) TestRoundTrip(c *C) {
+	codec := newProtoCodec()
+	for i, rs := range []*pb.ConsRs{
+		{Partition: 1, Offset: 2, Message: []byte("foo"), KeyUndefined: true},
+		{Partition: 3, Offset: 4, Message: make([]byte, 3*initialMarshalBufferSize), KeyValue: []byte("bar"),
+			Following: []*pb.ConsRs{{Partition: 3, Offset: 5, Message: []byte("baz")}}},
+		{},
+	} {
+		expected, err := proto.Marshal(rs)
+		c.Assert(err, IsNil)
+
+		// When
+		encoded, err := codec.Marshal(rs)
+		c.Assert(err, IsNil)
+		var decoded pb.ConsRs
+		err = codec.Unmarshal(encoded, &decoded)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(encoded, DeepEquals, expected, Commentf("case #%d", i))
+		c.Assert(proto.Equal(&decoded, rs), Equals, true, Commentf("case #%d", i))
+	}
+}
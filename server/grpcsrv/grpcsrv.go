@@ -4,50 +4,107 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrac"
 	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/server/accesslog"
+	"github.com/mailgun/kafka-pixy/server/errcode"
+	"github.com/mailgun/kafka-pixy/tenancy"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 const (
 	maxRequestSize = 1 * 1024 * 1024 // 1Mb
+
+	mdAuthorization = "authorization"
+	mdAffinity      = "x-kafka-pixy-affinity"
+	mdInstance      = "x-kafka-pixy-instance"
+	mdInstanceAddr  = "x-kafka-pixy-instance-addr"
+	mdErrorCode     = "x-kafka-pixy-error-code"
+	mdRequestID     = server.MDRequestID
+	bearerPrefix    = "Bearer "
 )
 
+// methodOps maps gRPC methods to classes of operations that listener modes
+// and network policies restrict. Methods that are not listed are accepted in any mode.
+var methodOps = map[string]string{
+	"/KafkaPixy/Produce":     server.OpProduce,
+	"/KafkaPixy/Flush":       server.OpProduce,
+	"/KafkaPixy/ConsumeNAck": server.OpConsume,
+	"/KafkaPixy/Ack":         server.OpConsume,
+	"/KafkaPixy/Nack":        server.OpConsume,
+	"/KafkaPixy/Checkpoint":  server.OpConsume,
+}
+
 type T struct {
 	actorID  *actor.ID
 	listener net.Listener
 	grpcSrv  *grpc.Server
 	proxySet *proxy.Set
+	opts     server.Opts
 	wg       sync.WaitGroup
 	errorCh  chan error
+	stopCh   chan none.T
 }
 
 // New creates a gRPC server instance.
 func New(addr string, proxySet *proxy.Set) (*T, error) {
+	return NewWithOpts(addr, proxySet, server.Opts{})
+}
+
+// NewWithOpts is like New, but also reports panics in request handlers and
+// logs requests as `opts` prescribe.
+func NewWithOpts(addr string, proxySet *proxy.Set, opts server.Opts) (*T, error) {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create listener")
 	}
 
-	grpcSrv := grpc.NewServer(grpc.MaxMsgSize(maxRequestSize))
 	s := T{
 		actorID:  actor.RootID.NewChild(fmt.Sprintf("grpc://%s", addr)),
 		listener: listener,
-		grpcSrv:  grpcSrv,
 		proxySet: proxySet,
+		opts:     opts,
 		errorCh:  make(chan error, 1),
+		stopCh:   make(chan none.T),
+	}
+	maxRecvMsgSize := maxRequestSize
+	if opts.GRPC != nil {
+		maxRecvMsgSize = opts.GRPC.MaxRecvMsgSize
+	}
+	srvOpts := []grpc.ServerOption{grpc.MaxMsgSize(maxRecvMsgSize),
+		grpc.UnaryInterceptor(s.interceptUnary), grpc.StreamInterceptor(s.interceptStream),
+		grpc.CustomCodec(newProtoCodec())}
+	if opts.GRPC != nil && opts.GRPC.MaxConcurrentStreams > 0 {
+		srvOpts = append(srvOpts, grpc.MaxConcurrentStreams(uint32(opts.GRPC.MaxConcurrentStreams)))
 	}
-	pb.RegisterKafkaPixyServer(grpcSrv, &s)
+	if opts.TLS != nil {
+		srvOpts = append(srvOpts, grpc.Creds(credentials.NewTLS(opts.TLS)))
+	}
+	s.grpcSrv = grpc.NewServer(srvOpts...)
+	pb.RegisterKafkaPixyServer(s.grpcSrv, &s)
 	return &s, nil
 }
 
@@ -72,40 +129,329 @@ func (s *T) ErrorCh() <-chan error {
 // incoming requests first, and then blocks waiting for pending requests to
 // complete.
 func (s *T) Stop() {
+	close(s.stopCh)
 	s.grpcSrv.GracefulStop()
 	s.wg.Wait()
 	close(s.errorCh)
 }
 
+// interceptUnary assigns an ID to a unary request, that is returned in the
+// `x-request-id` header metadata, rejects it if the client exceeds its rate
+// limit or may not make it, and writes the request to the access log.
+// Clients can provide request IDs in the same metadata of requests. Error
+// codes are reported in the `x-kafka-pixy-error-code` trailer metadata. A
+// panic in a handler results in an Internal error carrying an incident ID,
+// rather than in a crash of the whole process.
+func (s *T) interceptUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (res interface{}, err error) {
+	rec := newRecord(ctx, info.FullMethod)
+	rec.ClientID = s.opts.Identity.FromGRPC(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(mdRequestID, rec.RequestID))
+	rec.noteRequest(req)
+	defer func() {
+		if p := recover(); p != nil {
+			err = s.handlePanic(info.FullMethod, rec.RequestID, p)
+		}
+		if err != nil {
+			err = reportErrorCode(err, rec.RequestID, func(md metadata.MD) { grpc.SetTrailer(ctx, md) })
+		} else {
+			rec.noteResponse(res)
+		}
+		s.opts.AccessLog.Log(rec.finish(err))
+	}()
+	if err := s.admit(ctx, rec.ClientID, methodOps[info.FullMethod]); err != nil {
+		return nil, err
+	}
+	if res, err = handler(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := s.checkSendSize(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// interceptStream is like interceptUnary but for streaming request handlers.
+func (s *T) interceptStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) (err error) {
+	rs := &recordingStream{ServerStream: ss, rec: newRecord(ss.Context(), info.FullMethod), srv: s}
+	rs.rec.ClientID = s.opts.Identity.FromGRPC(ss.Context())
+	ss.SetHeader(metadata.Pairs(mdRequestID, rs.rec.RequestID))
+	defer func() {
+		if p := recover(); p != nil {
+			err = s.handlePanic(info.FullMethod, rs.rec.RequestID, p)
+		}
+		if err != nil {
+			err = reportErrorCode(err, rs.rec.RequestID, ss.SetTrailer)
+		}
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		s.opts.AccessLog.Log(rs.rec.finish(err))
+	}()
+	if err := s.admit(ss.Context(), rs.rec.ClientID, methodOps[info.FullMethod]); err != nil {
+		return err
+	}
+	return handler(srv, rs)
+}
+
+// checkSendSize returns ErrResponseTooLarge if a response message exceeds
+// the maximum send message size. It has to be checked before the message is
+// given to gRPC, for the vendored version treats failures to encode
+// responses as fatal.
+func (s *T) checkSendSize(msg interface{}) error {
+	if s.opts.GRPC == nil || s.opts.GRPC.MaxSendMsgSize == 0 {
+		return nil
+	}
+	if size := messageSize(msg); size > int64(s.opts.GRPC.MaxSendMsgSize) {
+		return newError(codes.ResourceExhausted, errors.Wrapf(server.ErrResponseTooLarge,
+			"size=%d, max=%d", size, s.opts.GRPC.MaxSendMsgSize))
+	}
+	return nil
+}
+
+// admit checks a request of operation class `op` against the network policy,
+// the rate limit of the client that made it, and the listener mode.
+func (s *T) admit(ctx context.Context, clientID, op string) error {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	if err := s.opts.NetPolicy.Check("", remoteAddr); err != nil {
+		return newError(codes.PermissionDenied, err)
+	}
+	if err := s.opts.Identity.Admit(clientID); err != nil {
+		pxy, _ := s.proxySet.Get("")
+		pxy.Metrics().Counter("api.client_throttled", "client", clientID).Inc(1)
+		return newError(codes.ResourceExhausted, err)
+	}
+	if err := server.CheckMode(s.opts.Mode, op); err != nil {
+		return newError(codes.PermissionDenied, err)
+	}
+	if err := s.opts.NetPolicy.Check(op, remoteAddr); err != nil {
+		return newError(codes.PermissionDenied, err)
+	}
+	return nil
+}
+
+func (s *T) handlePanic(method, requestID string, p interface{}) error {
+	pxy, _ := s.proxySet.Get("")
+	incident := server.HandlePanic(s.actorID, pxy.Metrics(), s.opts.PanicReporter, &server.Incident{
+		API:       "grpc",
+		Op:        method,
+		RequestID: requestID,
+		Panic:     p,
+	})
+	return grpc.Errorf(codes.Internal, "internal error, incident=%s", incident.ID)
+}
+
+// record accumulates an access log record of a gRPC request.
+type record struct {
+	accesslog.Record
+	startedAt time.Time
+}
+
+// newRecord starts a record of a request. The request ID is taken from the
+// request metadata, or a new one is generated if there is none.
+func newRecord(ctx context.Context, method string) *record {
+	provided := ""
+	if md, ok := metadata.FromContext(ctx); ok && len(md[mdRequestID]) > 0 {
+		provided = md[mdRequestID][0]
+	}
+	startedAt := time.Now()
+	rec := &record{startedAt: startedAt}
+	rec.Time = startedAt.UTC()
+	rec.RequestID = server.RequestID(provided)
+	rec.API = "grpc"
+	rec.Method = method
+	if p, ok := peer.FromContext(ctx); ok {
+		rec.RemoteAddr = p.Addr.String()
+	}
+	return rec
+}
+
+// noteRequest takes the size, the topic and the group of a request message.
+func (rec *record) noteRequest(m interface{}) {
+	rec.BytesIn += messageSize(m)
+	if m, ok := m.(interface {
+		GetTopic() string
+	}); ok && rec.Topic == "" {
+		rec.Topic = m.GetTopic()
+	}
+	if m, ok := m.(interface {
+		GetGroup() string
+	}); ok && rec.Group == "" {
+		rec.Group = m.GetGroup()
+	}
+}
+
+// noteResponse takes the size of a response message.
+func (rec *record) noteResponse(m interface{}) {
+	rec.BytesOut += messageSize(m)
+}
+
+// finish completes the record with the request outcome.
+func (rec *record) finish(err error) *accesslog.Record {
+	rec.Status = grpc.Code(err).String()
+	rec.Latency = time.Since(rec.startedAt)
+	return &rec.Record
+}
+
+func messageSize(m interface{}) int64 {
+	if m, ok := m.(proto.Message); ok {
+		return int64(proto.Size(m))
+	}
+	return 0
+}
+
+// recordingStream notes messages that go through a server stream.
+type recordingStream struct {
+	grpc.ServerStream
+	srv *T
+	mu  sync.Mutex
+	rec *record
+}
+
+func (rs *recordingStream) RecvMsg(m interface{}) error {
+	err := rs.ServerStream.RecvMsg(m)
+	if err == nil {
+		rs.mu.Lock()
+		rs.rec.noteRequest(m)
+		rs.mu.Unlock()
+	}
+	return err
+}
+
+func (rs *recordingStream) SendMsg(m interface{}) error {
+	if err := rs.srv.checkSendSize(m); err != nil {
+		return err
+	}
+	err := rs.ServerStream.SendMsg(m)
+	if err == nil {
+		rs.mu.Lock()
+		rs.rec.noteResponse(m)
+		rs.mu.Unlock()
+	}
+	return err
+}
+
+// codedError is a gRPC error that remembers the API error code of its cause.
+type codedError struct {
+	grpcErr error
+	code    string
+}
+
+func (e *codedError) Error() string {
+	return e.grpcErr.Error()
+}
+
+// newError creates a gRPC error with the specified code and the message of
+// `err`, that is reported with the API error code of the `err` cause.
+func newError(code codes.Code, err error) error {
+	return &codedError{grpcErr: grpc.Errorf(code, "%s", err), code: errcode.Of(err)}
+}
+
+// reportErrorCode reports the API error code of an error returned by a
+// request handler with `setTrailer`, and returns the gRPC error to be sent
+// to the client. Errors with no specific code are reported with the code that
+// corresponds to their gRPC code.
+func reportErrorCode(err error, requestID string, setTrailer func(md metadata.MD)) error {
+	code := ""
+	if ce, ok := err.(*codedError); ok {
+		err, code = ce.grpcErr, ce.code
+	}
+	if code == "" {
+		code = errcode.ForGRPCCode(grpc.Code(err))
+	}
+	setTrailer(metadata.Pairs(mdErrorCode, code, mdRequestID, requestID))
+	return err
+}
+
 // Produce implements pb.KafkaPixyServer
 func (s *T) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
 	pxy, err := s.proxySet.Get(req.Cluster)
 	if err != nil {
-		return nil, grpc.Errorf(codes.InvalidArgument, err.Error())
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
+	}
+	topic := tenant.Apply(req.Topic)
+	if req.MessageUndefined && req.KeyUndefined {
+		return nil, newError(codes.InvalidArgument, errors.New("key is required to produce a tombstone"))
+	}
+
+	receiver := proxy.OutcomeReceiver{ID: req.CorrelationId, URL: req.CallbackUrl}
+	if req.ResultTopic != "" {
+		receiver.Topic = tenant.Apply(req.ResultTopic)
+	}
+	if !req.AsyncMode && !receiver.IsZero() {
+		return nil, newError(codes.InvalidArgument, errors.New("callback_url and result_topic require async_mode"))
+	}
+
+	if req.DeliverAt != "" {
+		if !receiver.IsZero() {
+			return nil, newError(codes.InvalidArgument, errors.New("callback_url and result_topic cannot be used with deliver_at"))
+		}
+		deliverAt, err := time.Parse(time.RFC3339, req.DeliverAt)
+		if err != nil {
+			return nil, newError(codes.InvalidArgument, errors.Errorf("invalid deliver_at: %s", req.DeliverAt))
+		}
+		if err := pxy.ProduceAtWithAcks(topic, keyEncoderFor(req), messageEncoderFor(req), deliverAt, req.Acks); err != nil {
+			switch errors.Cause(err) {
+			case proxy.ErrInvalidName, proxy.ErrInvalidDelay, proxy.ErrAcksNotAllowed, sarama.ErrUnknownTopicOrPartition:
+				return nil, newError(codes.InvalidArgument, err)
+			case proxy.ErrTopicForbidden:
+				return nil, newError(codes.PermissionDenied, err)
+			default:
+				return nil, newError(codes.Internal, err)
+			}
+		}
+		return &pb.ProdRs{Partition: -1, Offset: -1, Acks: pxy.DefaultAcks()}, nil
 	}
 
 	if req.AsyncMode {
-		pxy.AsyncProduce(req.Topic, keyEncoderFor(req), sarama.StringEncoder(req.Message))
-		return &pb.ProdRs{Partition: -1, Offset: -1}, nil
+		acks, err := pxy.AsyncProduceWithOutcome(topic, keyEncoderFor(req), messageEncoderFor(req), req.Acks, receiver)
+		if err != nil {
+			switch errors.Cause(err) {
+			case proxy.ErrInvalidName, proxy.ErrAcksNotAllowed, proxy.ErrInvalidOutcomeReceiver, sarama.ErrUnknownTopicOrPartition:
+				return nil, newError(codes.InvalidArgument, err)
+			case proxy.ErrTopicForbidden:
+				return nil, newError(codes.PermissionDenied, err)
+			default:
+				return nil, newError(codes.Internal, err)
+			}
+		}
+		return &pb.ProdRs{Partition: -1, Offset: -1, Acks: acks}, nil
 	}
 
-	prodMsg, err := pxy.Produce(req.Topic, keyEncoderFor(req), sarama.StringEncoder(req.Message))
+	prodMsg, acks, err := pxy.ProduceWithAcks(topic, keyEncoderFor(req), messageEncoderFor(req), req.Acks)
 	if err != nil {
-		switch err {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, proxy.ErrAcksNotAllowed:
+			return nil, newError(codes.InvalidArgument, err)
 		case sarama.ErrUnknownTopicOrPartition:
-			return nil, grpc.Errorf(codes.InvalidArgument, err.Error())
+			return nil, newError(codes.InvalidArgument, err)
+		case proxy.ErrTopicForbidden:
+			return nil, newError(codes.PermissionDenied, err)
 		default:
-			return nil, grpc.Errorf(codes.Internal, err.Error())
+			return nil, newError(codes.Internal, err)
 		}
 	}
-	return &pb.ProdRs{Partition: prodMsg.Partition, Offset: prodMsg.Offset}, nil
+	return &pb.ProdRs{Partition: prodMsg.Partition, Offset: prodMsg.Offset, Acks: acks}, nil
 }
 
 // ConsumeNAck implements pb.KafkaPixyServer
 func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, error) {
 	pxy, err := s.proxySet.Get(req.Cluster)
 	if err != nil {
-		return nil, grpc.Errorf(codes.InvalidArgument, err.Error())
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
 	}
 
 	var ack proxy.Ack
@@ -115,90 +461,453 @@ func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, er
 		ack = proxy.AutoAck()
 	} else {
 		if ack, err = proxy.NewAck(req.AckPartition, req.AckOffset); err != nil {
-			return nil, grpc.Errorf(codes.InvalidArgument, errors.Wrap(err, "invalid ack").Error())
+			return nil, grpc.Errorf(codes.InvalidArgument, "%s", errors.Wrap(err, "invalid ack"))
 		}
 	}
 
-	consMsg, err := pxy.Consume(req.Group, req.Topic, ack)
+	opts := consumer.ConsumeOpts{MaxMessages: int(req.MaxMessages)}
+	if opts.OffsetReset, err = consumer.ParseOffsetReset(req.OffsetReset); err != nil {
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	if opts.MaxMessages < 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid max_messages: %d", req.MaxMessages)
+	}
+
+	group := tenant.Apply(req.Group)
+	affinity := setRoutingHint(ctx, pxy, group)
+	consMsg, err := pxy.ConsumeWithAffinity(group, tenant.Apply(req.Topic), ack, affinity, opts)
 	if err != nil {
-		switch err {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, sarama.ErrUnknownTopicOrPartition, config.ErrKafkaFeatureUnsupported:
+			return nil, newError(codes.InvalidArgument, err)
 		case consumer.ErrRequestTimeout:
-			return nil, grpc.Errorf(codes.NotFound, err.Error())
-		case consumer.ErrTooManyRequests:
-			return nil, grpc.Errorf(codes.ResourceExhausted, err.Error())
+			return nil, newError(codes.NotFound, err)
+		case consumer.ErrTooManyRequests, consumer.ErrTooManyTopics:
+			return nil, newError(codes.ResourceExhausted, err)
+		case proxy.ErrTopicForbidden:
+			return nil, newError(codes.PermissionDenied, err)
+		case proxy.ErrConsumerUnavailable:
+			return nil, newError(codes.Unavailable, err)
 		default:
-			return nil, grpc.Errorf(codes.Internal, err.Error())
+			return nil, newError(codes.Internal, err)
 		}
 	}
-	res := pb.ConsRs{
-		Partition: consMsg.Partition,
-		Offset:    consMsg.Offset,
-		Message:   consMsg.Value,
+	res := &pb.ConsRs{}
+	toConsRs(&consMsg, res)
+	if len(consMsg.Following) > 0 {
+		// Following responses are allocated in one go rather than one by one.
+		following := make([]pb.ConsRs, len(consMsg.Following))
+		res.Following = make([]*pb.ConsRs, len(consMsg.Following))
+		for i := range consMsg.Following {
+			toConsRs(&consMsg.Following[i], &following[i])
+			res.Following[i] = &following[i]
+		}
+	}
+	return res, nil
+}
+
+// toConsRs fills a consume response with a consumed message. Message payloads
+// are referenced rather than copied.
+func toConsRs(consMsg *consumer.Message, res *pb.ConsRs) {
+	*res = pb.ConsRs{
+		Partition:     consMsg.Partition,
+		Offset:        consMsg.Offset,
+		Message:       consMsg.Value,
+		HighWatermark: consMsg.HighWaterMark,
+		Lag:           consMsg.Lag(),
+		Replay:        consMsg.Replay,
+		Skipped:       consMsg.Skipped,
 	}
 	if consMsg.Key == nil {
 		res.KeyUndefined = true
 	} else {
 		res.KeyValue = consMsg.Key
 	}
-	return &res, nil
+	if consMsg.Value == nil {
+		res.MessageUndefined = true
+	}
 }
 
 func (s *T) Ack(ctx context.Context, req *pb.AckRq) (*pb.AckRs, error) {
 	pxy, err := s.proxySet.Get(req.Cluster)
 	if err != nil {
-		return nil, grpc.Errorf(codes.InvalidArgument, err.Error())
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
 	}
 
 	ack, err := proxy.NewAck(req.Partition, req.Offset)
 	if err != nil {
-		return nil, grpc.Errorf(codes.InvalidArgument, errors.Wrap(err, "invalid ack").Error())
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", errors.Wrap(err, "invalid ack"))
 	}
-	if err = pxy.Ack(req.Group, req.Topic, ack); err != nil {
-		return nil, grpc.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+	group := tenant.Apply(req.Group)
+	affinity := setRoutingHint(ctx, pxy, group)
+	if err = pxy.AckWithAffinity(group, tenant.Apply(req.Topic), ack, affinity); err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			return nil, newError(codes.InvalidArgument, err)
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			return nil, newError(codes.PermissionDenied, err)
+		}
+		return nil, newError(codes.Code(http.StatusInternalServerError), err)
 	}
 	return &pb.AckRs{}, nil
 }
 
+// nackClasses maps gRPC nack error classes to consumer.Nack* constants.
+var nackClasses = map[pb.NackRq_ErrorClass]string{
+	pb.NackRq_RETRYABLE: consumer.NackRetryable,
+	pb.NackRq_FATAL:     consumer.NackFatal,
+	pb.NackRq_THROTTLE:  consumer.NackThrottle,
+}
+
+// Nack implements pb.KafkaPixyServer
+func (s *T) Nack(ctx context.Context, req *pb.NackRq) (*pb.NackRs, error) {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
+	}
+
+	class, ok := nackClasses[req.ErrorClass]
+	if !ok {
+		return nil, grpc.Errorf(codes.InvalidArgument, "bad error class: %v", req.ErrorClass)
+	}
+	group := tenant.Apply(req.Group)
+	affinity := setRoutingHint(ctx, pxy, group)
+	err = pxy.NackWithAffinity(group, tenant.Apply(req.Topic), req.Partition, req.Offset, class, req.Reason, affinity)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, proxy.ErrInvalidNack:
+			return nil, newError(codes.InvalidArgument, err)
+		case proxy.ErrTopicForbidden:
+			return nil, newError(codes.PermissionDenied, err)
+		}
+		return nil, newError(codes.Code(http.StatusInternalServerError), err)
+	}
+	return &pb.NackRs{}, nil
+}
+
+// Checkpoint implements pb.KafkaPixyServer
+func (s *T) Checkpoint(ctx context.Context, req *pb.CheckpointRq) (*pb.CheckpointRs, error) {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
+	}
+
+	group := tenant.Apply(req.Group)
+	affinity := setRoutingHint(ctx, pxy, group)
+	err = pxy.CheckpointWithAffinity(group, tenant.Apply(req.Topic), req.Partition, req.Offset, req.Metadata, affinity)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName:
+			return nil, newError(codes.InvalidArgument, err)
+		case proxy.ErrTopicForbidden:
+			return nil, newError(codes.PermissionDenied, err)
+		case consumer.ErrCheckpointBehind:
+			return nil, newError(codes.FailedPrecondition, err)
+		}
+		return nil, newError(codes.Code(http.StatusInternalServerError), err)
+	}
+	return &pb.CheckpointRs{}, nil
+}
+
+// GetCheckpoints implements pb.KafkaPixyServer
+func (s *T) GetCheckpoints(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetCheckpointsRs, error) {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := pxy.GetCheckpoints(tenant.Apply(req.Group), tenant.Apply(req.Topic))
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			return nil, newError(codes.InvalidArgument, err)
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			return nil, newError(codes.PermissionDenied, err)
+		}
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			return nil, newError(codes.NotFound, err)
+		}
+		return nil, newError(codes.Code(http.StatusInternalServerError), err)
+	}
+
+	result := pb.GetCheckpointsRs{}
+	for _, cp := range checkpoints {
+		result.Checkpoints = append(result.Checkpoints, &pb.Checkpoint{
+			Partition: cp.Partition,
+			Offset:    cp.Offset,
+			Metadata:  cp.Meta,
+			Committed: cp.Committed,
+		})
+	}
+	return &result, nil
+}
+
+// Flush implements pb.KafkaPixyServer
+func (s *T) Flush(ctx context.Context, req *pb.FlushRq) (*pb.FlushRs, error) {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	if _, err := authenticate(ctx, pxy); err != nil {
+		return nil, err
+	}
+	if req.TimeoutMs < 0 {
+		return nil, newError(codes.InvalidArgument, errors.Errorf("bad timeout_ms: %d", req.TimeoutMs))
+	}
+	result := pxy.Flush(time.Duration(req.TimeoutMs) * time.Millisecond)
+	return &pb.FlushRs{
+		Acked:   int64(result.Acked),
+		Failed:  int64(result.Failed),
+		Pending: int64(result.Pending),
+	}, nil
+}
+
 func (s *T) GetOffsets(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetOffsetsRs, error) {
 	pxy, err := s.proxySet.Get(req.Cluster)
 	if err != nil {
-		return nil, grpc.Errorf(codes.InvalidArgument, err.Error())
+		return nil, newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(ctx, pxy)
+	if err != nil {
+		return nil, err
 	}
-	partitionOffsets, err := pxy.GetGroupOffsets(req.Group, req.Topic)
+	partitionOffsets, err := pxy.GetGroupOffsets(tenant.Apply(req.Group), tenant.Apply(req.Topic))
 	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			return nil, newError(codes.InvalidArgument, err)
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			return nil, newError(codes.PermissionDenied, err)
+		}
 		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
-			return nil, grpc.Errorf(codes.NotFound, err.Error())
+			return nil, newError(codes.NotFound, err)
 		}
-		return nil, grpc.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+		return nil, newError(codes.Code(http.StatusInternalServerError), err)
 	}
 
 	result := pb.GetOffsetsRs{}
 	for _, po := range partitionOffsets {
-		row := pb.PartitionOffset{
-			Partition: po.Partition,
-			Begin:     po.Begin,
-			End:       po.End,
-			Count:     po.End - po.Begin,
-			Offset:    po.Offset,
-		}
-		if po.Offset == sarama.OffsetNewest {
-			row.Lag = 0
-		} else if po.Offset == sarama.OffsetOldest {
-			row.Lag = po.End - po.Begin
-		} else {
-			row.Lag = po.End - po.Offset
-		}
-		row.Metadata = po.Metadata
-		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
-		row.SparseAcks = offsettrac.SparseAcks2Str(offset)
-		result.Offsets = append(result.Offsets, &row)
+		result.Offsets = append(result.Offsets, toPbPartitionOffset(po))
 	}
 	return &result, nil
 }
 
+// StreamOffsets implements pb.KafkaPixyServer
+func (s *T) StreamOffsets(req *pb.GetOffsetsRq, stream pb.KafkaPixy_StreamOffsetsServer) error {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(stream.Context(), pxy)
+	if err != nil {
+		return err
+	}
+	partitionOffsets, err := pxy.GetGroupOffsets(tenant.Apply(req.Group), tenant.Apply(req.Topic))
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			return newError(codes.InvalidArgument, err)
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			return newError(codes.PermissionDenied, err)
+		}
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			return newError(codes.NotFound, err)
+		}
+		return newError(codes.Internal, err)
+	}
+	for _, po := range partitionOffsets {
+		if err := stream.Send(toPbPartitionOffset(po)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toPbPartitionOffset(po admin.PartitionOffset) *pb.PartitionOffset {
+	row := pb.PartitionOffset{
+		Partition: po.Partition,
+		Begin:     po.Begin,
+		End:       po.End,
+		Count:     po.End - po.Begin,
+		Offset:    po.Offset,
+	}
+	if po.Offset == sarama.OffsetNewest {
+		row.Lag = 0
+	} else if po.Offset == sarama.OffsetOldest {
+		row.Lag = po.End - po.Begin
+	} else {
+		row.Lag = po.End - po.Offset
+	}
+	row.Metadata = po.Metadata
+	offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
+	row.SparseAcks = offsettrac.SparseAcks2Str(offset)
+	return &row
+}
+
+// ListConsumers implements pb.KafkaPixyServer
+func (s *T) ListConsumers(req *pb.ListConsumersRq, stream pb.KafkaPixy_ListConsumersServer) error {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(stream.Context(), pxy)
+	if err != nil {
+		return err
+	}
+	pg := admin.Page{Prefix: tenant.Apply(""), Limit: int(req.Limit)}
+	if req.After != "" {
+		pg.After = tenant.Apply(req.After)
+	}
+	if req.Filter != "" {
+		if pg.Filter, err = regexp.Compile(req.Filter); err != nil {
+			return grpc.Errorf(codes.InvalidArgument, "invalid filter: %s", err)
+		}
+	}
+	var sendErr error
+	_, err = pxy.ScanTopicConsumers(tenant.Apply(req.Topic), pg, func(group string, consumers map[string][]int32, err error) {
+		group, ok := tenant.Strip(group)
+		if !ok || sendErr != nil {
+			return
+		}
+		groupConsumers := pb.GroupConsumers{Group: group}
+		if err != nil {
+			groupConsumers.Error = err.Error()
+		}
+		clientIDs := make([]string, 0, len(consumers))
+		for clientID := range consumers {
+			clientIDs = append(clientIDs, clientID)
+		}
+		sort.Strings(clientIDs)
+		for _, clientID := range clientIDs {
+			groupConsumers.Consumers = append(groupConsumers.Consumers,
+				&pb.Consumer{ClientId: clientID, Partitions: consumers[clientID]})
+		}
+		sendErr = stream.Send(&groupConsumers)
+	})
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			return newError(codes.InvalidArgument, err)
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			return newError(codes.PermissionDenied, err)
+		}
+		return newError(codes.Internal, err)
+	}
+	return sendErr
+}
+
+// WatchGroupEvents implements pb.KafkaPixyServer
+func (s *T) WatchGroupEvents(req *pb.WatchGroupEventsRq, stream pb.KafkaPixy_WatchGroupEventsServer) error {
+	pxy, err := s.proxySet.Get(req.Cluster)
+	if err != nil {
+		return newError(codes.InvalidArgument, err)
+	}
+	tenant, err := authenticate(stream.Context(), pxy)
+	if err != nil {
+		return err
+	}
+	group := tenant.Apply(req.Group)
+	since := req.Since
+	for {
+		select {
+		case <-s.stopCh:
+			return nil
+		case <-stream.Context().Done():
+			return nil
+		default:
+		}
+		events, err := pxy.WatchGroupEvents(group, since, stream.Context().Done())
+		if err != nil {
+			if errors.Cause(err) == proxy.ErrInvalidName {
+				return newError(codes.InvalidArgument, err)
+			}
+			return newError(codes.Internal, err)
+		}
+		for _, ev := range events {
+			since = ev.Seq
+			topic, ok := tenant.Strip(ev.Topic)
+			if !ok {
+				continue
+			}
+			groupEv := pb.GroupEv{
+				Seq:        ev.Seq,
+				Kind:       pb.GroupEv_ASSIGNED,
+				Topic:      topic,
+				Partitions: ev.Partitions,
+				Timestamp:  ev.Time.UnixNano() / int64(time.Millisecond),
+			}
+			switch ev.Kind {
+			case groupevents.Revoked:
+				groupEv.Kind = pb.GroupEv_REVOKED
+			case groupevents.Expanded:
+				groupEv.Kind = pb.GroupEv_EXPANDED
+			}
+			if err := stream.Send(&groupEv); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authenticate returns a tenant that a request comes from. The tenant token
+// is expected in the `authorization` metadata in `Bearer <token>` format.
+func authenticate(ctx context.Context, pxy *proxy.T) (*tenancy.Tenant, error) {
+	var token string
+	if md, ok := metadata.FromContext(ctx); ok && len(md[mdAuthorization]) > 0 {
+		token = strings.TrimPrefix(md[mdAuthorization][0], bearerPrefix)
+	}
+	tenant, err := pxy.Tenants().Authenticate(token)
+	if err != nil {
+		return nil, newError(codes.Unauthenticated, err)
+	}
+	if err := tenant.Admit(); err != nil {
+		return nil, newError(codes.ResourceExhausted, err)
+	}
+	return tenant, nil
+}
+
+// setRoutingHint reads the affinity requested by a client from the request
+// metadata and returns it. It also tells the client what Kafka-Pixy instance
+// serves requests of the consumer group in the response header metadata.
+func setRoutingHint(ctx context.Context, pxy *proxy.T, group string) string {
+	var affinity string
+	if md, ok := metadata.FromContext(ctx); ok && len(md[mdAffinity]) > 0 {
+		affinity = md[mdAffinity][0]
+	}
+	instance, addr := pxy.RoutingHint(group, affinity)
+	md := metadata.Pairs(mdInstance, instance)
+	if addr != "" {
+		md[mdInstanceAddr] = []string{addr}
+	}
+	grpc.SetHeader(ctx, md)
+	return affinity
+}
+
 func keyEncoderFor(prodReq *pb.ProdRq) sarama.Encoder {
 	if prodReq.KeyUndefined {
 		return nil
 	}
 	return sarama.ByteEncoder(prodReq.KeyValue)
 }
+
+func messageEncoderFor(prodReq *pb.ProdRq) sarama.Encoder {
+	if prodReq.MessageUndefined {
+		return nil
+	}
+	return sarama.StringEncoder(prodReq.Message)
+}
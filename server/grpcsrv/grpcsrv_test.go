@@ -0,0 +1,35 @@
+package grpcsrv
+
+import (
+	"github.com/mailgun/kafka-pixy/config"
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/server/errcode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	. "gopkg.in/check.v1"
+)
+
+type GRPCSrvSuite struct{}
+
+var _ = Suite(&GRPCSrvSuite{})
+
+// Responses larger than the maximum send message size are rejected, and
+// there is no limit by default.
+func (s *GRPCSrvSuite) TestCheckSendSize(c *C) {
+	small := &pb.ConsRs{Message: []byte("foo")}
+	large := &pb.ConsRs{Message: make([]byte, 100)}
+	limited := &T{opts: server.Opts{GRPC: &config.GRPCServer{MaxSendMsgSize: 64}}}
+	unlimited := &T{opts: server.Opts{GRPC: &config.GRPCServer{}}}
+
+	// When
+	err := limited.checkSendSize(large)
+
+	// Then
+	c.Assert(err, NotNil)
+	c.Assert(err.(*codedError).code, Equals, errcode.ResponseTooLarge)
+	c.Assert(grpc.Code(err.(*codedError).grpcErr), Equals, codes.ResourceExhausted)
+	c.Assert(limited.checkSendSize(small), IsNil)
+	c.Assert(unlimited.checkSendSize(large), IsNil)
+	c.Assert((&T{}).checkSendSize(large), IsNil)
+}
@@ -0,0 +1,125 @@
+package httpsrv
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/log"
+)
+
+const (
+	// Buffers that grew larger than that while encoding a response are not
+	// returned to the pool, so that a few huge messages do not pin memory.
+	maxPooledBufferSize = 1 << 20
+
+	jsonIndent = "  "
+)
+
+// consumeBufPool holds buffers that consume responses are encoded into. It
+// spares the hot consume path a couple of allocations per message that
+// json.MarshalIndent would make for encoding and then indenting a response.
+var consumeBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+// respondWithConsumedMessage writes a consumed message to the client the way
+// respondWithJSON would write the matching consumeHTTPResponse, but without
+// going through reflection and using a pooled buffer.
+func respondWithConsumedMessage(w http.ResponseWriter, consMsg *consumer.Message) {
+	bufPtr := consumeBufPool.Get().(*[]byte)
+	b := appendConsumeResponse((*bufPtr)[:0], consMsg, "")
+
+	w.Header().Add(hdrContentType, "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		log.Errorf("Failed to send HTTP response: status=%d, err=%+v", http.StatusOK, err)
+	}
+	if cap(b) <= maxPooledBufferSize {
+		*bufPtr = b
+		consumeBufPool.Put(bufPtr)
+	}
+}
+
+// appendConsumeResponse appends a consumed message encoded exactly as
+// json.MarshalIndent(consumeHTTPResponse, prefix, "  ") would to b.
+func appendConsumeResponse(b []byte, consMsg *consumer.Message, prefix string) []byte {
+	fieldPrefix := prefix + jsonIndent
+	b = append(b, '{', '\n')
+	b = appendJSONField(b, fieldPrefix, "key")
+	b = appendJSONBytes(b, consMsg.Key)
+	b = append(b, ",\n"...)
+	b = appendJSONField(b, fieldPrefix, "value")
+	b = appendJSONBytes(b, consMsg.Value)
+	b = append(b, ",\n"...)
+	b = appendJSONField(b, fieldPrefix, "partition")
+	b = strconv.AppendInt(b, int64(consMsg.Partition), 10)
+	b = append(b, ",\n"...)
+	b = appendJSONField(b, fieldPrefix, "offset")
+	b = strconv.AppendInt(b, consMsg.Offset, 10)
+	b = append(b, ",\n"...)
+	b = appendJSONField(b, fieldPrefix, "high_watermark")
+	b = strconv.AppendInt(b, consMsg.HighWaterMark, 10)
+	b = append(b, ",\n"...)
+	b = appendJSONField(b, fieldPrefix, "lag")
+	b = strconv.AppendInt(b, consMsg.Lag(), 10)
+	if consMsg.Replay {
+		b = append(b, ",\n"...)
+		b = appendJSONField(b, fieldPrefix, "replay")
+		b = append(b, "true"...)
+	}
+	if consMsg.Skipped != 0 {
+		b = append(b, ",\n"...)
+		b = appendJSONField(b, fieldPrefix, "skipped")
+		b = strconv.AppendInt(b, consMsg.Skipped, 10)
+	}
+	if len(consMsg.Following) > 0 {
+		elemPrefix := fieldPrefix + jsonIndent
+		b = append(b, ",\n"...)
+		b = appendJSONField(b, fieldPrefix, "following")
+		b = append(b, '[', '\n')
+		for i := range consMsg.Following {
+			if i > 0 {
+				b = append(b, ",\n"...)
+			}
+			b = append(b, elemPrefix...)
+			b = appendConsumeResponse(b, &consMsg.Following[i], elemPrefix)
+		}
+		b = append(b, '\n')
+		b = append(b, fieldPrefix...)
+		b = append(b, ']')
+	}
+	b = append(b, '\n')
+	b = append(b, prefix...)
+	return append(b, '}')
+}
+
+func appendJSONField(b []byte, prefix, name string) []byte {
+	b = append(b, prefix...)
+	b = append(b, '"')
+	b = append(b, name...)
+	return append(b, '"', ':', ' ')
+}
+
+// appendJSONBytes appends a byte slice encoded the way encoding/json does it:
+// as a base64 string, or null if the slice is nil.
+func appendJSONBytes(b, v []byte) []byte {
+	if v == nil {
+		return append(b, "null"...)
+	}
+	n := base64.StdEncoding.EncodedLen(len(v))
+	if cap(b)-len(b) < n+2 {
+		grown := make([]byte, len(b), 2*cap(b)+n+2)
+		copy(grown, b)
+		b = grown
+	}
+	b = append(b, '"')
+	base64.StdEncoding.Encode(b[len(b):len(b)+n], v)
+	b = b[:len(b)+n]
+	return append(b, '"')
+}
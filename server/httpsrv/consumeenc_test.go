@@ -0,0 +1,60 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ConsumeEncSuite struct{}
+
+var _ = Suite(&ConsumeEncSuite{})
+
+// Consume responses are encoded exactly as json.MarshalIndent would encode
+// them.
+func (s *ConsumeEncSuite) TestAppendConsumeResponse(c *C) {
+	for i, consMsg := range []consumer.Message{
+		{Value: []byte("foo"), Partition: 1, Offset: 10, HighWaterMark: 20},
+		{Key: []byte{}, Value: []byte{}, Offset: 7, HighWaterMark: 3},
+		{Value: []byte("baz"), Offset: 4, HighWaterMark: 9, Replay: true},
+		{Value: []byte("qux"), Offset: 12, HighWaterMark: 13, Skipped: 5},
+		{Key: []byte("bar"), Value: []byte(strings.Repeat("x", 10000)), Partition: 2, Offset: 1, HighWaterMark: 5,
+			Following: []consumer.Message{
+				{Key: []byte("a"), Value: []byte("1"), Partition: 2, Offset: 2, HighWaterMark: 5},
+				{Value: []byte("2"), Partition: 2, Offset: 4, HighWaterMark: 5, Skipped: 1},
+			}},
+	} {
+		expected, err := json.MarshalIndent(toConsumeHTTPResponse(consMsg), "", "  ")
+		c.Assert(err, IsNil)
+
+		// When
+		encoded := appendConsumeResponse(make([]byte, 0, 8), &consMsg, "")
+
+		// Then
+		c.Assert(string(encoded), Equals, string(expected), Commentf("case #%d", i))
+	}
+}
+
+func toConsumeHTTPResponse(consMsg consumer.Message) consumeHTTPResponse {
+	rs := consumeHTTPResponse{
+		Key:           consMsg.Key,
+		Value:         consMsg.Value,
+		Partition:     consMsg.Partition,
+		Offset:        consMsg.Offset,
+		HighWaterMark: consMsg.HighWaterMark,
+		Lag:           consMsg.Lag(),
+		Replay:        consMsg.Replay,
+		Skipped:       consMsg.Skipped,
+	}
+	for _, followingMsg := range consMsg.Following {
+		rs.Following = append(rs.Following, toConsumeHTTPResponse(followingMsg))
+	}
+	return rs
+}
@@ -0,0 +1,162 @@
+package httpsrv
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+const (
+	// Trailer that the actual status of a response is reported in, if the
+	// response had to be started with 200 to send heartbeats.
+	hdrStatus = "X-Kafka-Pixy-Status"
+
+	hdrTrailer   = "Trailer"
+	hdrKeepAlive = "Keep-Alive"
+)
+
+var (
+	// A consume response can be preceded by any number of new lines, for
+	// they are insignificant whitespace in JSON.
+	jsonHeartbeat = []byte("\n")
+	// Server-sent event streams ignore comment lines.
+	eventStreamHeartbeat = []byte(":\n\n")
+)
+
+// heartbeatWriter is a response writer that writes heartbeat bytes whenever
+// nothing has been written for an interval, so that intermediaries, e.g. load
+// balancers and gateways, do not close connections of long responses for
+// being idle.
+//
+// If a response has not been started by the first heartbeat, then it is
+// started with 200 and the `X-Kafka-Pixy-Status` trailer declared. A status
+// written after that is reported in the trailer.
+type heartbeatWriter struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	beat        []byte
+	contentType string
+	interval    time.Duration
+	stopCh      chan none.T
+	doneCh      chan none.T
+
+	mu        sync.Mutex
+	started   bool
+	lastWrite time.Time
+}
+
+// withHeartbeats returns a response writer that sends `beat` bytes every
+// heartbeat interval of the server, and a function that stops the heartbeats
+// and has to be called before the handler returns. If heartbeats are
+// disabled, or the response cannot be flushed, then `w` is returned as is.
+func (s *T) withHeartbeats(w http.ResponseWriter, beat []byte, contentType string) (http.ResponseWriter, func()) {
+	if s.opts.HTTP == nil || s.opts.HTTP.HeartbeatInterval <= 0 {
+		return w, func() {}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return w, func() {}
+	}
+	hw := &heartbeatWriter{
+		w:           w,
+		flusher:     flusher,
+		beat:        beat,
+		contentType: contentType,
+		interval:    s.opts.HTTP.HeartbeatInterval,
+		stopCh:      make(chan none.T),
+		doneCh:      make(chan none.T),
+		lastWrite:   time.Now(),
+	}
+	go hw.run()
+	return hw, hw.stop
+}
+
+func (hw *heartbeatWriter) Header() http.Header {
+	return hw.w.Header()
+}
+
+func (hw *heartbeatWriter) WriteHeader(status int) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if !hw.started {
+		hw.started = true
+		hw.w.WriteHeader(status)
+		return
+	}
+	hw.w.Header().Set(hdrStatus, strconv.Itoa(status))
+	// The access log should have the actual status too.
+	if rw, ok := hw.w.(*responseWriter); ok {
+		rw.status = status
+	}
+}
+
+func (hw *heartbeatWriter) Write(b []byte) (int, error) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.started = true
+	hw.lastWrite = time.Now()
+	return hw.w.Write(b)
+}
+
+// Flush implements http.Flusher used by streaming responses.
+func (hw *heartbeatWriter) Flush() {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.flusher.Flush()
+}
+
+func (hw *heartbeatWriter) run() {
+	defer close(hw.doneCh)
+	ticker := time.NewTicker(hw.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hw.stopCh:
+			return
+		case <-ticker.C:
+		}
+		hw.mu.Lock()
+		if time.Since(hw.lastWrite) >= hw.interval {
+			hw.beatLocked()
+		}
+		hw.mu.Unlock()
+	}
+}
+
+func (hw *heartbeatWriter) beatLocked() {
+	if !hw.started {
+		hw.started = true
+		hw.w.Header().Set(hdrTrailer, hdrStatus)
+		hw.w.Header().Set(hdrContentType, hw.contentType)
+		hw.w.WriteHeader(http.StatusOK)
+	}
+	hw.lastWrite = time.Now()
+	if _, err := hw.w.Write(hw.beat); err != nil {
+		return
+	}
+	hw.flusher.Flush()
+}
+
+func (hw *heartbeatWriter) stop() {
+	close(hw.stopCh)
+	<-hw.doneCh
+}
+
+// hintKeepAlive tells HTTP/1.1 clients and intermediaries how long the server
+// keeps idle connections open, so that they stop reusing a connection before
+// the server closes it.
+func (s *T) hintKeepAlive(w http.ResponseWriter, r *http.Request) {
+	if s.opts.HTTP == nil || !s.opts.HTTP.KeepAliveHeader || !s.opts.HTTP.KeepAlives || r.ProtoMajor != 1 {
+		return
+	}
+	idleTimeout := s.opts.HTTP.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = s.opts.HTTP.ReadTimeout
+	}
+	if seconds := int64(idleTimeout / time.Second); seconds > 0 {
+		w.Header().Set(hdrKeepAlive, "timeout="+strconv.FormatInt(seconds, 10))
+	}
+}
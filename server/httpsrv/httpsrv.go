@@ -1,25 +1,46 @@
 package httpsrv
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/admin/lagwatch"
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrac"
+	"github.com/mailgun/kafka-pixy/consumer/sizestats"
+	"github.com/mailgun/kafka-pixy/keyindex"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/server/accesslog"
+	"github.com/mailgun/kafka-pixy/server/errcode"
+	"github.com/mailgun/kafka-pixy/server/sessions"
+	"github.com/mailgun/kafka-pixy/tap"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/topicstats"
 	"github.com/mailgun/log"
 	"github.com/mailgun/manners"
 	"github.com/pkg/errors"
@@ -32,6 +53,28 @@ const (
 	// HTTP headers used by the API.
 	hdrContentLength = "Content-Length"
 	hdrContentType   = "Content-Type"
+	hdrAuthorization = "Authorization"
+	hdrAccept        = "Accept"
+	hdrCacheControl  = "Cache-Control"
+	hdrAffinity      = "X-Kafka-Pixy-Affinity"
+	hdrInstance      = "X-Kafka-Pixy-Instance"
+	hdrInstanceAddr  = "X-Kafka-Pixy-Instance-Addr"
+	hdrNextPageToken = "X-Kafka-Pixy-Next-Page-Token"
+	hdrGroupErrors   = "X-Kafka-Pixy-Group-Errors"
+	hdrAcks          = "X-Kafka-Pixy-Acks"
+
+	contentTypeEventStream = "text/event-stream"
+	contentTypeNDJSON      = "application/x-ndjson"
+
+	bearerPrefix = "Bearer "
+
+	// The number of top consuming groups reported in topic stats by default.
+	defaultTopGroups = 5
+
+	// How long and how many sampled messages are collected by default by
+	// `GET /_tap` requests that do not accept server-sent events.
+	defaultTapTimeout = time.Second
+	defaultTapLimit   = 100
 
 	// HTTP request parameters.
 	prmCluster      = "cluster"
@@ -44,26 +87,84 @@ const (
 	prmPartition    = "partition"
 	prmAckOffset    = "ackOffset"
 	prmOffset       = "offset"
+	prmOp           = "op"
+	prmErrorRate    = "errorRate"
+	prmLatency      = "latency"
+	prmSince        = "since"
+	prmLimit        = "limit"
+	prmFilter       = "filter"
+	prmPageToken    = "pageToken"
+	prmOffsetReset  = "offsetReset"
+	prmMaxMessages  = "maxMessages"
+	prmDryRun       = "dryRun"
+	prmShiftBy      = "shiftBy"
+	prmShiftTo      = "shiftTo"
+	prmInterval     = "interval"
+	prmThresholds   = "thresholds"
+	prmSession      = "session"
+	prmMember       = "member"
+	prmDeliverAt    = "deliverAt"
+	prmCopy         = "copy"
+	prmHeartbeat    = "heartbeat"
+	prmAcks         = "acks"
+	prmPrefix       = "prefix"
+	prmTimeout      = "timeout"
+	prmCallback     = "callback"
+	prmResultTopic  = "resultTopic"
+	prmID           = "id"
+	prmBefore       = "before"
+	prmUser         = "user"
+	prmClientID     = "clientId"
+	prmBroker       = "broker"
 )
 
 var (
 	EmptyResponse = map[string]interface{}{}
+
+	errSlowConsumerPaused = errors.New("slow consumer paused")
+
+	// Operator endpoints, like sessions, client quotas, broker configs,
+	// fault injection and proxy stats, concern the entire cluster or the
+	// proxy, so they are only available to operators and not to tenants.
+	errTenantForbidden = errors.New("cluster-wide operations are not available to tenants")
+
+	// Deleting records requires Kafka 0.11.0.0 or later, that the vendored
+	// sarama does not support, so records deletion can only be planned.
+	errDeleteRecordsUnsupported = errors.Wrap(config.ErrKafkaFeatureUnsupported,
+		"only dry runs of records deletion are supported")
+
+	// closedCh is passed as a cancel channel to make watch calls return
+	// without waiting.
+	closedCh = make(chan struct{})
 )
 
+func init() {
+	close(closedCh)
+}
+
 type T struct {
 	actorID    *actor.ID
 	addr       string
 	listener   net.Listener
 	httpServer *manners.GracefulServer
 	proxySet   *proxy.Set
+	opts       server.Opts
+	sessions   *sessions.T
 	wg         sync.WaitGroup
 	errorCh    chan error
+	stopCh     chan none.T
 }
 
 // New creates an HTTP server instance that will accept API requests at the
 // specified `network`/`address` and execute them with the specified `producer`,
 // `consumer`, or `admin`, depending on the request type.
 func New(addr string, proxySet *proxy.Set) (*T, error) {
+	return NewWithOpts(addr, proxySet, server.Opts{})
+}
+
+// NewWithOpts is like New, but also reports panics in request handlers and
+// logs requests as `opts` prescribe.
+func NewWithOpts(addr string, proxySet *proxy.Set, opts server.Opts) (*T, error) {
 	network := networkUnix
 	if strings.Contains(addr, ":") {
 		network = networkTCP
@@ -73,6 +174,18 @@ func New(addr string, proxySet *proxy.Set) (*T, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create listener")
 	}
+	if network == networkTCP && opts.TLS != nil {
+		// The TLS config is shared with the gRPC server, so protocols are
+		// set on a copy.
+		tlsCfg := opts.TLS.Clone()
+		tlsCfg.NextProtos = []string{"http/1.1"}
+		if opts.HTTP == nil || opts.HTTP.HTTP2 {
+			tlsCfg.NextProtos = []string{"h2", "http/1.1"}
+		}
+		// Connections have to be wrapped by manners before TLS, for it
+		// tracks their state by the underlying connection.
+		listener = manners.NewTLSListener(listener, tlsCfg)
+	}
 	// If the address is Unix Domain Socket then make it accessible for everyone.
 	if network == networkUnix {
 		if err := os.Chmod(addr, 0777); err != nil {
@@ -81,36 +194,223 @@ func New(addr string, proxySet *proxy.Set) (*T, error) {
 	}
 	// Create a graceful HTTP server instance.
 	router := mux.NewRouter()
-	httpServer := manners.NewWithServer(&http.Server{Handler: router})
 	hs := &T{
-		actorID:    actor.RootID.NewChild(fmt.Sprintf("http://%s", addr)),
-		addr:       addr,
-		listener:   manners.NewListener(listener),
-		httpServer: httpServer,
-		proxySet:   proxySet,
-		errorCh:    make(chan error, 1),
+		actorID:  actor.RootID.NewChild(fmt.Sprintf("http://%s", addr)),
+		addr:     addr,
+		listener: manners.NewListener(listener),
+		proxySet: proxySet,
+		opts:     opts,
+		sessions: opts.Sessions,
+		errorCh:  make(chan error, 1),
+		stopCh:   make(chan none.T),
+	}
+	if hs.sessions == nil {
+		hs.sessions = sessions.NewWithSlowConsumers(opts.SlowConsumers)
+	}
+	httpServer := &http.Server{
+		Handler:     hs.logRequests(router, hs.recoverPanics(router)),
+		ConnContext: hs.sessions.Open,
+		ConnState:   hs.trackConnState,
 	}
+	if opts.HTTP != nil {
+		configureHTTPServer(httpServer, *opts.HTTP)
+	}
+	hs.httpServer = manners.NewWithServer(httpServer)
 	// Configure the API request handlers.
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleProduce).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleProduce).Methods("POST")
+	if opts.Plane != server.PlaneAdmin {
+		hs.routeData(router)
+	}
+	if opts.Plane != server.PlaneData {
+		hs.routeAdmin(router)
+	}
+	// Probes are served on both planes.
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_health", prmCluster), hs.handleGetHealth).Methods("GET")
+	router.HandleFunc("/_health", hs.handleGetHealth).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_ready", prmCluster), hs.handleGetReady).Methods("GET")
+	router.HandleFunc("/_ready", hs.handleGetReady).Methods("GET")
+
+	router.HandleFunc("/_ping", hs.handlePing).Methods("GET")
+	return hs, nil
+}
+
+// configureHTTPServer applies timeouts, keep-alives and HTTP/2 settings to
+// an HTTP server.
+func configureHTTPServer(httpServer *http.Server, cfg config.HTTPServer) {
+	httpServer.ReadTimeout = cfg.ReadTimeout
+	httpServer.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+	httpServer.WriteTimeout = cfg.WriteTimeout
+	httpServer.IdleTimeout = cfg.IdleTimeout
+	httpServer.SetKeepAlivesEnabled(cfg.KeepAlives)
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleConsume).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleConsume).Methods("GET")
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+	httpServer.Protocols = &protocols
+	httpServer.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+	}
+}
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/acks", prmCluster, prmTopic), hs.handleConsume).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks", prmTopic), hs.handleConsume).Methods("POST")
+// routeData configures handlers of the data plane, that is produce and
+// consume requests.
+func (s *T) routeData(router *mux.Router) {
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), s.allowed(server.OpProduce, s.handleProduce)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), s.allowed(server.OpProduce, s.handleProduce)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), s.allowed(server.OpProduce, s.handleProduce)).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), s.allowed(server.OpProduce, s.handleProduce)).Methods("DELETE")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleGetOffsets).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleGetOffsets).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/messages", prmCluster), s.allowed(server.OpProduce, s.handleProduceFanOut)).Methods("POST")
+	router.HandleFunc("/messages", s.allowed(server.OpProduce, s.handleProduceFanOut)).Methods("POST")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleSetOffsets).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleSetOffsets).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_flush", prmCluster), s.allowed(server.OpProduce, s.handleFlush)).Methods("POST")
+	router.HandleFunc("/_flush", s.allowed(server.OpProduce, s.handleFlush)).Methods("POST")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers", prmCluster, prmTopic), hs.handleGetTopicConsumers).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers", prmTopic), hs.handleGetTopicConsumers).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), s.allowed(server.OpConsume, s.handleConsume)).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), s.allowed(server.OpConsume, s.handleConsume)).Methods("GET")
 
-	router.HandleFunc("/_ping", hs.handlePing).Methods("GET")
-	return hs, nil
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/acks", prmCluster, prmTopic), s.allowed(server.OpConsume, s.handleConsume)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks", prmTopic), s.allowed(server.OpConsume, s.handleConsume)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/checkpoints", prmCluster, prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleCheckpoint)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/checkpoints", prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleCheckpoint)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/nacks", prmCluster, prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleNack)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/nacks", prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleNack)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/replay", prmCluster, prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleReplay)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/replay", prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleReplay)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/start", prmCluster, prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleStartConsumer)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/start", prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleStartConsumer)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/stop", prmCluster, prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleStopConsumer)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/stop", prmTopic, prmGroup), s.allowed(server.OpConsume, s.handleStopConsumer)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/snapshot", prmCluster, prmTopic), s.allowed(server.OpConsume, s.handleSnapshot)).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/snapshot", prmTopic), s.allowed(server.OpConsume, s.handleSnapshot)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/keys/{%s:.+}", prmCluster, prmTopic, prmKey), s.allowed(server.OpConsume, s.handleLookupKey)).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/keys/{%s:.+}", prmTopic, prmKey), s.allowed(server.OpConsume, s.handleLookupKey)).Methods("GET")
+
+	router.HandleFunc(proxy.PeerConsumePath, s.allowed(server.OpConsume, s.handlePeerConsume)).Methods("POST")
+	router.HandleFunc(proxy.PeerAckPath, s.allowed(server.OpConsume, s.handlePeerAck)).Methods("POST")
+	router.HandleFunc(proxy.PeerCheckpointPath, s.allowed(server.OpConsume, s.handlePeerCheckpoint)).Methods("POST")
+	router.HandleFunc(proxy.PeerReplayPath, s.allowed(server.OpConsume, s.handlePeerReplay)).Methods("POST")
+	router.HandleFunc(proxy.PeerLifecyclePath, s.allowed(server.OpConsume, s.handlePeerLifecycle)).Methods("POST")
+	router.HandleFunc(proxy.PeerNackPath, s.allowed(server.OpConsume, s.handlePeerNack)).Methods("POST")
+}
+
+// routeAdmin configures handlers of the admin plane, that is everything but
+// produce and consume requests.
+func (s *T) routeAdmin(router *mux.Router) {
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics", prmCluster), s.handleListTopics).Methods("GET")
+	router.HandleFunc("/topics", s.handleListTopics).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/consumergroups", prmCluster), s.handleListGroups).Methods("GET")
+	router.HandleFunc("/consumergroups", s.handleListGroups).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), s.handleGetOffsets).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), s.handleGetOffsets).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), s.allowed(server.OpAdmin, s.handleSetOffsets)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), s.allowed(server.OpAdmin, s.handleSetOffsets)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/records", prmCluster, prmTopic), s.allowed(server.OpAdmin, s.handleDeleteRecords)).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/records", prmTopic), s.allowed(server.OpAdmin, s.handleDeleteRecords)).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers", prmCluster, prmTopic), s.handleGetTopicConsumers).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers", prmTopic), s.handleGetTopicConsumers).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/stats", prmCluster, prmTopic), s.handleGetTopicStats).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/stats", prmTopic), s.handleGetTopicStats).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/quotas", prmCluster), s.handleGetQuotas).Methods("GET")
+	router.HandleFunc("/quotas", s.handleGetQuotas).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/quotas", prmCluster), s.allowed(server.OpAdmin, s.handleAlterQuotas)).Methods("POST")
+	router.HandleFunc("/quotas", s.allowed(server.OpAdmin, s.handleAlterQuotas)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/brokers/{%s}/config", prmCluster, prmBroker), s.handleGetBrokerConfig).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/cluster/brokers/{%s}/config", prmBroker), s.handleGetBrokerConfig).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/brokers/{%s}/config", prmCluster, prmBroker), s.allowed(server.OpAdmin, s.handleAlterBrokerConfig)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/cluster/brokers/{%s}/config", prmBroker), s.allowed(server.OpAdmin, s.handleAlterBrokerConfig)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/lag/watch", prmCluster, prmTopic, prmGroup), s.handleWatchLag).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/lag/watch", prmTopic, prmGroup), s.handleWatchLag).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/checkpoints", prmCluster, prmTopic, prmGroup), s.handleGetCheckpoints).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/checkpoints", prmTopic, prmGroup), s.handleGetCheckpoints).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/config", prmCluster, prmTopic, prmGroup), s.handleGetEffectiveConfig).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/config", prmTopic, prmGroup), s.handleGetEffectiveConfig).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/consumergroups/{%s}/events", prmCluster, prmGroup), s.handleGetGroupEvents).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/consumergroups/{%s}/events", prmGroup), s.handleGetGroupEvents).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/consumergroups/{%s}/state", prmCluster, prmGroup), s.handleGetGroupState).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/consumergroups/{%s}/state", prmGroup), s.handleGetGroupState).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/consumergroups/{%s}/rebalance", prmCluster, prmGroup), s.allowed(server.OpAdmin, s.handleRebalanceGroup)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/consumergroups/{%s}/rebalance", prmGroup), s.allowed(server.OpAdmin, s.handleRebalanceGroup)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/consumergroups/{%s}/members/{%s}", prmCluster, prmGroup, prmMember), s.allowed(server.OpAdmin, s.handleEvictGroupMember)).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/consumergroups/{%s}/members/{%s}", prmGroup, prmMember), s.allowed(server.OpAdmin, s.handleEvictGroupMember)).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults", prmCluster), s.allowed(server.OpAdmin, s.handleGetFaults)).Methods("GET")
+	router.HandleFunc("/_faults", s.allowed(server.OpAdmin, s.handleGetFaults)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults", prmCluster), s.allowed(server.OpAdmin, s.handleResetFaults)).Methods("DELETE")
+	router.HandleFunc("/_faults", s.allowed(server.OpAdmin, s.handleResetFaults)).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults/rebalance", prmCluster), s.allowed(server.OpAdmin, s.handleRebalance)).Methods("POST")
+	router.HandleFunc("/_faults/rebalance", s.allowed(server.OpAdmin, s.handleRebalance)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults/{%s}", prmCluster, prmOp), s.allowed(server.OpAdmin, s.handleSetFault)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/_faults/{%s}", prmOp), s.allowed(server.OpAdmin, s.handleSetFault)).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_tenants", prmCluster), s.allowed(server.OpAdmin, s.handleGetTenants)).Methods("GET")
+	router.HandleFunc("/_tenants", s.allowed(server.OpAdmin, s.handleGetTenants)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_admin/cache", prmCluster), s.allowed(server.OpAdmin, s.handleGetAdminCache)).Methods("GET")
+	router.HandleFunc("/_admin/cache", s.allowed(server.OpAdmin, s.handleGetAdminCache)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_admin/cache", prmCluster), s.allowed(server.OpAdmin, s.handleInvalidateAdminCache)).Methods("DELETE")
+	router.HandleFunc("/_admin/cache", s.allowed(server.OpAdmin, s.handleInvalidateAdminCache)).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_producer/metadata", prmCluster), s.allowed(server.OpAdmin, s.handleGetProducerMetadata)).Methods("GET")
+	router.HandleFunc("/_producer/metadata", s.allowed(server.OpAdmin, s.handleGetProducerMetadata)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_consumer/sizes", prmCluster), s.allowed(server.OpAdmin, s.handleGetConsumerSizes)).Methods("GET")
+	router.HandleFunc("/_consumer/sizes", s.allowed(server.OpAdmin, s.handleGetConsumerSizes)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_alerts", prmCluster), s.allowed(server.OpAdmin, s.handleGetAlerts)).Methods("GET")
+	router.HandleFunc("/_alerts", s.allowed(server.OpAdmin, s.handleGetAlerts)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_tap", prmCluster), s.allowed(server.OpAdmin, s.handleGetTap)).Methods("GET")
+	router.HandleFunc("/_tap", s.allowed(server.OpAdmin, s.handleGetTap)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_metrics", prmCluster), s.handleGetMetrics).Methods("GET")
+	router.HandleFunc("/_metrics", s.handleGetMetrics).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_copies", prmCluster), s.allowed(server.OpAdmin, s.allowed(server.OpProduce, s.handleStartCopy))).Methods("POST")
+	router.HandleFunc("/_copies", s.allowed(server.OpAdmin, s.allowed(server.OpProduce, s.handleStartCopy))).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_copies", prmCluster), s.allowed(server.OpAdmin, s.handleListCopies)).Methods("GET")
+	router.HandleFunc("/_copies", s.allowed(server.OpAdmin, s.handleListCopies)).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_copies/{%s}", prmCluster, prmCopy), s.allowed(server.OpAdmin, s.handleGetCopy)).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/_copies/{%s}", prmCopy), s.allowed(server.OpAdmin, s.handleGetCopy)).Methods("GET")
+
+	router.HandleFunc("/_proxies", s.allowed(server.OpAdmin, s.handleListProxies)).Methods("GET")
+	router.HandleFunc("/_proxies", s.allowed(server.OpAdmin, s.handleRegisterProxy)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/_proxies/{%s}", prmCluster), s.allowed(server.OpAdmin, s.handleDeregisterProxy)).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_failover", prmCluster), s.allowed(server.OpAdmin, s.handleGetFailover)).Methods("GET")
+	router.HandleFunc("/_failover", s.allowed(server.OpAdmin, s.handleGetFailover)).Methods("GET")
+
+	router.HandleFunc("/_sessions", s.allowed(server.OpAdmin, s.handleGetSessions)).Methods("GET")
+	router.HandleFunc("/_sessions/slow", s.allowed(server.OpAdmin, s.handleGetSlowSessions)).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/_sessions/{%s}", prmSession), s.allowed(server.OpAdmin, s.handleEvictSession)).Methods("DELETE")
 }
 
 // Starts triggers asynchronous HTTP server start. If it fails then the error
@@ -134,305 +434,2896 @@ func (s *T) ErrorCh() <-chan error {
 // for incoming requests first, and then blocks waiting for pending requests to
 // complete.
 func (s *T) Stop() {
+	close(s.stopCh)
 	s.httpServer.Close()
 	s.wg.Wait()
 	close(s.errorCh)
 }
 
+// trackConnState forgets sessions of connections that are closed or taken
+// over by a handler.
+func (s *T) trackConnState(conn net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		s.sessions.Close(conn)
+	}
+}
+
+// logRequests assigns IDs to requests and writes them to the access log. It
+// also rejects requests from addresses that the network policy does not allow
+// any requests from, and requests of clients that exceed their rate limit.
+// Clients can provide request IDs in the `X-Request-ID` header, and they are
+// returned in the same header of responses. The router is used to get the
+// topic of a request.
+func (s *T) logRequests(router *mux.Router, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		rw := &responseWriter{
+			ResponseWriter: w,
+			requestID:      server.RequestID(r.Header.Get(server.HdrRequestID)),
+			status:         http.StatusOK,
+		}
+		rw.Header().Set(server.HdrRequestID, rw.requestID)
+		s.hintKeepAlive(rw, r)
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		clientID := s.opts.Identity.FromHTTP(r)
+		session := sessions.FromContext(r.Context())
+		session.OnRequest(clientID)
+
+		if err := s.opts.NetPolicy.Check("", r.RemoteAddr); err != nil {
+			respondWithError(rw, http.StatusForbidden, err)
+		} else if err := s.opts.Identity.Admit(clientID); err != nil {
+			pxy, _ := s.proxySet.Get("")
+			pxy.Metrics().Counter("api.client_throttled", "client", clientID).Inc(1)
+			respondWithError(rw, http.StatusTooManyRequests, err)
+		} else {
+			h.ServeHTTP(rw, r)
+		}
+
+		session.AddBytes(body.count, rw.count)
+
+		if s.opts.AccessLog == nil {
+			return
+		}
+		var match mux.RouteMatch
+		router.Match(r, &match)
+		s.opts.AccessLog.Log(&accesslog.Record{
+			Time:       startedAt.UTC(),
+			RequestID:  rw.requestID,
+			API:        "http",
+			RemoteAddr: r.RemoteAddr,
+			ClientID:   clientID,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Status:     strconv.Itoa(rw.status),
+			Latency:    time.Since(startedAt),
+			Topic:      match.Vars[prmTopic],
+			Group:      r.URL.Query().Get(prmGroup),
+			BytesIn:    body.count,
+			BytesOut:   rw.count,
+		})
+	})
+}
+
+// allowed makes a handler of operations of class `op` reject requests with
+// 403, if the mode of the listener does not accept them, or the network
+// policy does not allow them from the client address.
+func (s *T) allowed(op string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := server.CheckMode(s.opts.Mode, op); err != nil {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		if err := s.opts.NetPolicy.Check(op, r.RemoteAddr); err != nil {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// recoverPanics makes a panic in a request handler result in a 500 response
+// carrying an incident ID, rather than in a crash of the whole process.
+func (s *T) recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// The standard library uses it to abort a response silently.
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			pxy, _ := s.proxySet.Get("")
+			incident := server.HandlePanic(s.actorID, pxy.Metrics(), s.opts.PanicReporter, &server.Incident{
+				API:       "http",
+				Op:        r.Method + " " + r.URL.Path,
+				RequestID: requestIDOf(w),
+				Panic:     p,
+			})
+			respondWithJSON(w, http.StatusInternalServerError,
+				incidentHTTPResponse{"internal error", errcode.Internal, incident.ID, incident.RequestID})
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// responseWriter records the status and the size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	requestID string
+	status    int
+	count     int64
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.count += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher used by streaming responses.
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requestIDOf returns the ID of the request that is being responded to with
+// the specified response writer.
+func requestIDOf(w http.ResponseWriter) string {
+	switch w := w.(type) {
+	case *responseWriter:
+		return w.requestID
+	case *heartbeatWriter:
+		return requestIDOf(w.w)
+	}
+	return ""
+}
+
+// countingReader counts bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(b)
+	cr.count += int64(n)
+	return n, err
+}
+
 func (s *T) getProxy(r *http.Request) (*proxy.T, error) {
 	cluster := mux.Vars(r)[prmCluster]
 	return s.proxySet.Get(cluster)
 }
 
-// handleProduce is an HTTP request handler for `POST /topic/{topic}/messages`
+// handleProduce is an HTTP request handler for `POST /topic/{topic}/messages`.
+// It also handles `DELETE /topic/{topic}/messages`, that produces a tombstone,
+// a message with a nil value, for the key given in the `key` parameter.
 func (s *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
 	key := getParamBytes(r, prmKey)
 	_, isSync := r.Form[prmSync]
-
-	// Get the message body from the HTTP request.
-	if _, ok := r.Header[hdrContentLength]; !ok {
-		errorText := fmt.Sprintf("Missing %s header", hdrContentLength)
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
-		return
+	acks := r.FormValue(prmAcks)
+	receiver := proxy.OutcomeReceiver{
+		ID:  r.FormValue(prmID),
+		URL: r.FormValue(prmCallback),
 	}
-	messageSizeStr := r.Header.Get(hdrContentLength)
-	messageSize, err := strconv.Atoi(messageSizeStr)
-	if err != nil {
-		errorText := fmt.Sprintf("Invalid %s header: %s", hdrContentLength, messageSizeStr)
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
-		return
+	if resultTopic := r.FormValue(prmResultTopic); resultTopic != "" {
+		receiver.Topic = tenant.Apply(resultTopic)
 	}
-	message, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		errorText := fmt.Sprintf("Failed to read a message: err=(%s)", err)
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
-		return
+
+	var message sarama.Encoder
+	if r.Method == "DELETE" {
+		if key == nil {
+			respondWithError(w, http.StatusBadRequest, errors.Errorf("%s is required to produce a tombstone", prmKey))
+			return
+		}
+	} else {
+		data, err := readMessage(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		message = sarama.StringEncoder(data)
 	}
-	if len(message) != messageSize {
-		errorText := fmt.Sprintf("Message size does not match %s: expected=%v, actual=%v",
-			hdrContentLength, messageSize, len(message))
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+
+	// Hand the message over to the delay store, if it is not due yet. The
+	// response is returned as soon as it is persisted either way.
+	if deliverAtStr := r.FormValue(prmDeliverAt); deliverAtStr != "" {
+		if !receiver.IsZero() {
+			respondWithError(w, http.StatusBadRequest, errors.Errorf("%s and %s require an immediate async request", prmCallback, prmResultTopic))
+			return
+		}
+		deliverAt, err := time.Parse(time.RFC3339, deliverAtStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, errors.Errorf("invalid %s: %s", prmDeliverAt, deliverAtStr))
+			return
+		}
+		if err := pxy.ProduceAtWithAcks(topic, toEncoderPreservingNil(key), message, deliverAt, acks); err != nil {
+			respondWithError(w, produceErrorStatus(err), err)
+			return
+		}
+		w.Header().Set(hdrAcks, pxy.DefaultAcks())
+		respondWithJSON(w, http.StatusOK, EmptyResponse)
 		return
 	}
 
 	// Asynchronously submit the message to the Kafka cluster.
 	if !isSync {
-		pxy.AsyncProduce(topic, toEncoderPreservingNil(key), sarama.StringEncoder(message))
+		acks, err := pxy.AsyncProduceWithOutcome(topic, toEncoderPreservingNil(key), message, acks, receiver)
+		if err != nil {
+			var status int
+			switch errors.Cause(err) {
+			case proxy.ErrInvalidName, proxy.ErrAcksNotAllowed, proxy.ErrInvalidOutcomeReceiver:
+				status = http.StatusBadRequest
+			case sarama.ErrUnknownTopicOrPartition:
+				status = http.StatusNotFound
+			case proxy.ErrTopicForbidden:
+				status = http.StatusForbidden
+			default:
+				status = http.StatusInternalServerError
+			}
+			respondWithError(w, status, err)
+			return
+		}
+		w.Header().Set(hdrAcks, acks)
 		respondWithJSON(w, http.StatusOK, EmptyResponse)
 		return
 	}
 
-	prodMsg, err := pxy.Produce(topic, toEncoderPreservingNil(key), sarama.StringEncoder(message))
+	if !receiver.IsZero() {
+		respondWithError(w, http.StatusBadRequest, errors.Errorf("%s and %s require an immediate async request", prmCallback, prmResultTopic))
+		return
+	}
+	prodMsg, acks, err := pxy.ProduceWithAcks(topic, toEncoderPreservingNil(key), message, acks)
 	if err != nil {
 		var status int
-		switch err {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, proxy.ErrAcksNotAllowed:
+			status = http.StatusBadRequest
 		case sarama.ErrUnknownTopicOrPartition:
 			status = http.StatusNotFound
+		case proxy.ErrTopicForbidden:
+			status = http.StatusForbidden
 		default:
 			status = http.StatusInternalServerError
 		}
-		respondWithJSON(w, status, errorHTTPResponse{err.Error()})
+		respondWithError(w, status, err)
 		return
 	}
 
+	w.Header().Set(hdrAcks, acks)
 	respondWithJSON(w, http.StatusOK, produceHTTPResponse{
 		Partition: prodMsg.Partition,
 		Offset:    prodMsg.Offset,
+		Acks:      acks,
 	})
 }
 
-// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
-func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
+// readMessage reads the body of a produce request, making sure that it is of
+// the size that the client claims.
+func readMessage(r *http.Request) ([]byte, error) {
+	if _, ok := r.Header[hdrContentLength]; !ok {
+		return nil, errors.Errorf("Missing %s header", hdrContentLength)
+	}
+	messageSizeStr := r.Header.Get(hdrContentLength)
+	messageSize, err := strconv.Atoi(messageSizeStr)
+	if err != nil {
+		return nil, errors.Errorf("Invalid %s header: %s", hdrContentLength, messageSizeStr)
+	}
+	message, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Errorf("Failed to read a message: err=(%s)", err)
+	}
+	if len(message) != messageSize {
+		return nil, errors.Errorf("Message size does not match %s: expected=%v, actual=%v",
+			hdrContentLength, messageSize, len(message))
+	}
+	return message, nil
+}
+
+// handleProduceFanOut is an HTTP request handler for `POST /messages`, that
+// produces the same message to all topics given in `topic` parameters.
+func (s *T) handleProduceFanOut(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-	group, err := getGroupParam(r, false)
+	tenant, status, err := authenticate(r, pxy)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, status, err)
 		return
 	}
-	ack, err := parseAck(r, true)
+	key := getParamBytes(r, prmKey)
+	_, isSync := r.Form[prmSync]
+	var topics []string
+	for _, topic := range r.Form[prmTopic] {
+		topics = append(topics, tenant.Apply(topic))
+	}
+	message, err := readMessage(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	consMsg, err := pxy.Consume(group, topic, ack)
-	if err != nil {
-		var status int
-		switch err {
-		case consumer.ErrRequestTimeout:
-			status = http.StatusRequestTimeout
-		case consumer.ErrTooManyRequests:
-			status = http.StatusTooManyRequests
-		default:
-			status = http.StatusInternalServerError
+	if !isSync {
+		if err := pxy.AsyncProduceFanOut(topics, toEncoderPreservingNil(key), sarama.StringEncoder(message)); err != nil {
+			respondWithError(w, produceErrorStatus(err), err)
+			return
 		}
-		respondWithJSON(w, status, errorHTTPResponse{err.Error()})
+		respondWithJSON(w, http.StatusOK, EmptyResponse)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, consumeHTTPResponse{
-		Key:       consMsg.Key,
-		Value:     consMsg.Value,
-		Partition: consMsg.Partition,
-		Offset:    consMsg.Offset,
-	})
+	results, err := pxy.ProduceFanOut(topics, toEncoderPreservingNil(key), sarama.StringEncoder(message))
+	if err != nil {
+		respondWithError(w, produceErrorStatus(err), err)
+		return
+	}
+	// If the message was not produced to some of the topics, then the status
+	// of the first failure is returned, along with results of all topics.
+	status = http.StatusOK
+	views := make([]fanOutResultView, len(results))
+	for i, result := range results {
+		views[i].Topic, _ = tenant.Strip(result.Topic)
+		if result.Err != nil {
+			if status == http.StatusOK {
+				status = produceErrorStatus(result.Err)
+			}
+			views[i].Error = result.Err.Error()
+			continue
+		}
+		views[i].Partition, views[i].Offset = result.Partition, result.Offset
+	}
+	respondWithJSON(w, status, fanOutHTTPResponse{Results: views})
+}
+
+// produceErrorStatus returns the HTTP status of a failed produce request.
+func produceErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case proxy.ErrInvalidName, proxy.ErrInvalidDelay, proxy.ErrAcksNotAllowed:
+		return http.StatusBadRequest
+	case sarama.ErrUnknownTopicOrPartition:
+		return http.StatusNotFound
+	case proxy.ErrTopicForbidden:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
 }
 
 // handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
-func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
+func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
 	group, err := getGroupParam(r, false)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
+	group = tenant.Apply(group)
 	ack, err := parseAck(r, true)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	err = pxy.Ack(group, topic, ack)
+	opts, err := getConsumeOpts(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, EmptyResponse)
-}
-
-// handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`
-func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
 
-	pxy, err := s.getProxy(r)
-	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
-		return
+	affinity := r.Header.Get(hdrAffinity)
+	setRoutingHint(w, pxy, group, affinity)
+	sub := sessions.Subscription{Cluster: mux.Vars(r)[prmCluster], Group: group, Topic: topic}
+	session := sessions.FromContext(r.Context())
+	ackPartition, ackOffset, acksMessage := ack.Message()
+	if acksMessage {
+		s.sessions.Acked(sub, ackPartition, ackOffset)
 	}
-	topic := mux.Vars(r)[prmTopic]
-	group, err := getGroupParam(r, false)
+	if session.Slow() {
+		pxy.Metrics().Counter("consumer.slow_requests", "group", group, "topic", topic,
+			"client", s.opts.Identity.FromHTTP(r)).Inc(1)
+		switch s.opts.SlowConsumers.Action {
+		case config.SlowConsumerShrinkPrefetch:
+			opts.MaxMessages = 1
+		case config.SlowConsumerPause:
+			// The ack is still accepted, for it is what makes the session
+			// catch up.
+			if acksMessage {
+				if err := pxy.AckWithAffinity(group, topic, ack, affinity); err != nil {
+					respondWithError(w, consumeErrorStatus(err), err)
+					return
+				}
+			}
+			respondWithError(w, http.StatusTooManyRequests, errSlowConsumerPaused)
+			return
+		}
+	}
+	if _, heartbeat := r.Form[prmHeartbeat]; heartbeat {
+		var stopHeartbeats func()
+		w, stopHeartbeats = s.withHeartbeats(w, jsonHeartbeat, "application/json")
+		defer stopHeartbeats()
+	}
+	pollDone := session.StartPoll(sub)
+	consMsg, err := pxy.ConsumeWithAffinity(group, topic, ack, affinity, opts)
+	pollDone()
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, consumeErrorStatus(err), err)
 		return
 	}
-
-	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
-	if err != nil {
-		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
-			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{"Unknown topic"})
-			return
+	if ack != proxy.AutoAck() {
+		session.Consumed(sub, consMsg.Partition, consMsg.Offset, pxy.AckTimeout())
+		for _, followingMsg := range consMsg.Following {
+			session.Consumed(sub, followingMsg.Partition, followingMsg.Offset, pxy.AckTimeout())
 		}
-		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
-		return
 	}
 
-	offsetViews := make([]partitionOffsetView, len(partitionOffsets))
-	for i, po := range partitionOffsets {
-		offsetViews[i].Partition = po.Partition
-		offsetViews[i].Begin = po.Begin
-		offsetViews[i].End = po.End
-		offsetViews[i].Count = po.End - po.Begin
-		offsetViews[i].Offset = po.Offset
-		if po.Offset == sarama.OffsetNewest {
-			offsetViews[i].Lag = 0
-		} else if po.Offset == sarama.OffsetOldest {
-			offsetViews[i].Lag = po.End - po.Begin
-		} else {
-			offsetViews[i].Lag = po.End - po.Offset
-		}
-		offsetViews[i].Metadata = po.Metadata
-		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
-		offsetViews[i].SparseAcks = offsettrac.SparseAcks2Str(offset)
+	respondWithConsumedMessage(w, &consMsg)
+}
+
+// getConsumeOpts returns optional consume parameters of a request.
+func getConsumeOpts(r *http.Request) (consumer.ConsumeOpts, error) {
+	var opts consumer.ConsumeOpts
+	var err error
+	if opts.OffsetReset, err = consumer.ParseOffsetReset(r.FormValue(prmOffsetReset)); err != nil {
+		return opts, err
 	}
-	respondWithJSON(w, http.StatusOK, offsetViews)
+	maxMessagesStr := r.FormValue(prmMaxMessages)
+	if maxMessagesStr == "" {
+		return opts, nil
+	}
+	opts.MaxMessages, err = strconv.Atoi(maxMessagesStr)
+	if err != nil || opts.MaxMessages < 1 {
+		return opts, errors.Errorf("invalid %s: %s", prmMaxMessages, maxMessagesStr)
+	}
+	return opts, nil
 }
 
-// handleGetOffsets is an HTTP request handler for `POST /topic/{topic}/offsets`
-func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
+// setRoutingHint tells the client what Kafka-Pixy instance serves requests of
+// the consumer group, so that smart clients and load balancers can send
+// further requests to it directly, passing its ID in the affinity header.
+func setRoutingHint(w http.ResponseWriter, pxy *proxy.T, group, affinity string) {
+	instance, addr := pxy.RoutingHint(group, affinity)
+	w.Header().Set(hdrInstance, instance)
+	if addr != "" {
+		w.Header().Set(hdrInstanceAddr, addr)
+	}
+}
+
+// consumeErrorStatus returns an HTTP status to respond with to a consume or
+// ack request that failed with the specified error.
+func consumeErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case proxy.ErrInvalidName, config.ErrKafkaFeatureUnsupported:
+		return http.StatusBadRequest
+	case sarama.ErrUnknownTopicOrPartition:
+		return http.StatusNotFound
+	case consumer.ErrRequestTimeout:
+		return http.StatusRequestTimeout
+	case consumer.ErrTooManyRequests, consumer.ErrTooManyTopics:
+		return http.StatusTooManyRequests
+	case proxy.ErrTopicForbidden:
+		return http.StatusForbidden
+	case proxy.ErrPeerUnavailable, proxy.ErrConsumerUnavailable:
+		return http.StatusServiceUnavailable
+	case consumer.ErrCheckpointBehind:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
+func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-	group, err := getGroupParam(r, false)
+	tenant, status, err := authenticate(r, pxy)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, status, err)
 		return
 	}
-
-	body, err := ioutil.ReadAll(r.Body)
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group, err := getGroupParam(r, false)
 	if err != nil {
-		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	var partitionOffsetViews []partitionOffsetView
-	if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
-		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+	group = tenant.Apply(group)
+	ack, err := parseAck(r, true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	partitionOffsets := make([]admin.PartitionOffset, len(partitionOffsetViews))
-	for i, pov := range partitionOffsetViews {
-		partitionOffsets[i].Partition = pov.Partition
-		partitionOffsets[i].Offset = pov.Offset
-		partitionOffsets[i].Metadata = pov.Metadata
+	affinity := r.Header.Get(hdrAffinity)
+	setRoutingHint(w, pxy, group, affinity)
+	err = pxy.AckWithAffinity(group, topic, ack, affinity)
+	if ackPartition, ackOffset, ok := ack.Message(); ok && err == nil {
+		s.sessions.Acked(sessions.Subscription{Cluster: mux.Vars(r)[prmCluster], Group: group, Topic: topic},
+			ackPartition, ackOffset)
 	}
-
-	err = pxy.SetGroupOffsets(group, topic, partitionOffsets)
 	if err != nil {
-		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
-			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{"Unknown topic"})
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
 			return
 		}
-		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusInternalServerError, err)
 		return
 	}
-
 	respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
-// handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
-func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
+// handleCheckpoint is an HTTP request handler for
+// `POST /topics/{topic}/consumers/{group}/checkpoints`
+func (s *T) handleCheckpoint(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var err error
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-
-	group, err := getGroupParam(r, true)
+	tenant, status, err := authenticate(r, pxy)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		respondWithError(w, status, err)
 		return
 	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
 
-	var consumers map[string]map[string][]int32
-	if group == "" {
-		consumers, err = pxy.GetAllTopicConsumers(topic)
+	var cpv checkpointView
+	if err := json.NewDecoder(r.Body).Decode(&cpv); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+
+	affinity := r.Header.Get(hdrAffinity)
+	setRoutingHint(w, pxy, group, affinity)
+	err = pxy.CheckpointWithAffinity(group, topic, cpv.Partition, cpv.Offset, cpv.Metadata, affinity)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		case consumer.ErrCheckpointBehind:
+			respondWithError(w, http.StatusConflict, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleNack is an HTTP request handler for
+// `POST /topics/{topic}/consumers/{group}/nacks`
+func (s *T) handleNack(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+
+	var nv nackView
+	if err := json.NewDecoder(r.Body).Decode(&nv); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+
+	affinity := r.Header.Get(hdrAffinity)
+	setRoutingHint(w, pxy, group, affinity)
+	err = pxy.NackWithAffinity(group, topic, nv.Partition, nv.Offset, nv.Class, nv.Reason, affinity)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, proxy.ErrInvalidNack:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleReplay is an HTTP request handler for
+// `POST /topics/{topic}/consumers/{group}/replay`
+func (s *T) handleReplay(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+
+	var rangeViews []replayRangeView
+	if err := json.NewDecoder(r.Body).Decode(&rangeViews); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+	ranges := make([]proxy.ReplayRange, len(rangeViews))
+	for i, rrv := range rangeViews {
+		ranges[i] = proxy.ReplayRange(rrv)
+	}
+
+	setRoutingHint(w, pxy, group, r.Header.Get(hdrAffinity))
+	resolved, err := pxy.Replay(group, topic, ranges)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, proxy.ErrInvalidReplay:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		case sarama.ErrUnknownTopicOrPartition:
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	rangeViews = make([]replayRangeView, len(resolved))
+	for i, rr := range resolved {
+		rangeViews[i] = replayRangeView(rr)
+	}
+	respondWithJSON(w, http.StatusOK, rangeViews)
+}
+
+// handleStartConsumer is an HTTP request handler for
+// `POST /topics/{topic}/consumers/{group}/start`
+func (s *T) handleStartConsumer(w http.ResponseWriter, r *http.Request) {
+	s.handleLifecycle(w, r, proxy.LifecycleStart)
+}
+
+// handleStopConsumer is an HTTP request handler for
+// `POST /topics/{topic}/consumers/{group}/stop`
+func (s *T) handleStopConsumer(w http.ResponseWriter, r *http.Request) {
+	s.handleLifecycle(w, r, proxy.LifecycleStop)
+}
+
+func (s *T) handleLifecycle(w http.ResponseWriter, r *http.Request, op string) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+
+	setRoutingHint(w, pxy, group, r.Header.Get(hdrAffinity))
+	if op == proxy.LifecycleStart {
+		offsetReset, err := consumer.ParseOffsetReset(r.FormValue(prmOffsetReset))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = pxy.StartConsumer(group, topic, offsetReset)
+	} else {
+		err = pxy.StopConsumer(group, topic)
+	}
+	if err != nil {
+		respondWithError(w, consumeErrorStatus(err), err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, consumerStatusView{
+		Group:   mux.Vars(r)[prmGroup],
+		Topic:   mux.Vars(r)[prmTopic],
+		Running: op == proxy.LifecycleStart,
+	})
+}
+
+// handleFlush is an HTTP request handler for `POST /_flush`. It responds when
+// all messages produced by then are either acknowledged by Kafka or failed,
+// or with 504 if some are neither by the timeout.
+func (s *T) handleFlush(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, status, err := authenticate(r, pxy); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	var timeout time.Duration
+	if timeoutStr := r.FormValue(prmTimeout); timeoutStr != "" {
+		if timeout, err = time.ParseDuration(timeoutStr); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmTimeout, timeoutStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+	result := pxy.Flush(timeout)
+	status := http.StatusOK
+	if result.Pending > 0 {
+		status = http.StatusGatewayTimeout
+	}
+	respondWithJSON(w, status, flushView(result))
+}
+
+// handleSnapshot is an HTTP request handler for `GET /topics/{topic}/snapshot`.
+// The latest message of every key is streamed as a line of NDJSON.
+func (s *T) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	keyPrefix := []byte(r.FormValue(prmPrefix))
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	stream := &ndjsonStream{w: w, flusher: flusher}
+	err = pxy.Snapshot(topic, keyPrefix, func(msg consumer.Message) error {
+		return stream.write(snapshotEntryView{
+			Key:       msg.Key,
+			Value:     msg.Value,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+		})
+	})
+	switch {
+	case err == nil:
+		if !stream.started {
+			// An empty snapshot still has to be a valid NDJSON response.
+			w.Header().Set(hdrContentType, contentTypeNDJSON)
+			w.WriteHeader(http.StatusOK)
+		}
+	case stream.started:
+		stream.fail(err)
+	default:
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		case sarama.ErrUnknownTopicOrPartition:
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// handleLookupKey is an HTTP request handler for
+// `GET /topics/{topic}/keys/{key}`.
+func (s *T) handleLookupKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	key := []byte(mux.Vars(r)[prmKey])
+
+	msg, err := pxy.LookupKey(topic, key)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		case keyindex.ErrNotIndexed, keyindex.ErrKeyNotFound:
+			respondWithError(w, http.StatusNotFound, err)
+		case keyindex.ErrStale:
+			respondWithError(w, http.StatusServiceUnavailable, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, snapshotEntryView{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	})
+}
+
+// handleGetCheckpoints is an HTTP request handler for
+// `GET /topics/{topic}/consumers/{group}/checkpoints`
+func (s *T) handleGetCheckpoints(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+
+	checkpoints, err := pxy.GetCheckpoints(group, topic)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{Error: "Unknown topic", Code: errcode.TopicNotFound})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	checkpointViews := make([]checkpointView, len(checkpoints))
+	for i, cp := range checkpoints {
+		checkpointViews[i] = checkpointView{
+			Partition: cp.Partition,
+			Offset:    cp.Offset,
+			Metadata:  cp.Meta,
+			Committed: cp.Committed,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, checkpointViews)
+}
+
+// handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`
+func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	group = tenant.Apply(group)
+	limit, err := getLimitParam(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	afterPartition := int32(-1)
+	token, ok, err := getPageTokenParam(r)
+	if ok && err == nil {
+		var partition int64
+		partition, err = strconv.ParseInt(token, 10, 32)
+		afterPartition = int32(partition)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid page token"))
+		return
+	}
+
+	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{Error: "Unknown topic", Code: errcode.TopicNotFound})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Partition offsets are sorted by partition, so a page is a slice of them.
+	first := sort.Search(len(partitionOffsets), func(i int) bool {
+		return partitionOffsets[i].Partition > afterPartition
+	})
+	partitionOffsets = partitionOffsets[first:]
+	if limit > 0 && len(partitionOffsets) > limit {
+		partitionOffsets = partitionOffsets[:limit]
+		lastPartition := partitionOffsets[limit-1].Partition
+		w.Header().Set(hdrNextPageToken, encodePageToken(strconv.Itoa(int(lastPartition))))
+	}
+
+	if stream, ok := newNDJSONStream(w, r); ok {
+		for _, po := range partitionOffsets {
+			if err := stream.write(toPartitionOffsetView(po)); err != nil {
+				return
+			}
+		}
+		return
+	}
+	offsetViews := make([]partitionOffsetView, len(partitionOffsets))
+	for i, po := range partitionOffsets {
+		offsetViews[i] = toPartitionOffsetView(po)
+	}
+	respondWithJSON(w, http.StatusOK, offsetViews)
+}
+
+func toPartitionOffsetView(po admin.PartitionOffset) partitionOffsetView {
+	view := partitionOffsetView{
+		Partition: po.Partition,
+		Begin:     po.Begin,
+		End:       po.End,
+		Count:     po.End - po.Begin,
+		Offset:    po.Offset,
+		Lag:       po.Lag(),
+		Metadata:  po.Metadata,
+	}
+	offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
+	view.SparseAcks = offsettrac.SparseAcks2Str(offset)
+	return view
+}
+
+// handleWatchLag is an HTTP request handler for
+// `GET /topics/{topic}/consumers/{group}/lag/watch`. It streams snapshots of
+// group offsets and lag as server-sent events, or responds with one snapshot
+// if the client does not accept `text/event-stream`.
+func (s *T) handleWatchLag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+	var opts lagwatch.Opts
+	if intervalStr := r.FormValue(prmInterval); intervalStr != "" {
+		if opts.Interval, err = time.ParseDuration(intervalStr); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmInterval, intervalStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+	if thresholdsStr := r.FormValue(prmThresholds); thresholdsStr != "" {
+		for _, thresholdStr := range strings.Split(thresholdsStr, ",") {
+			threshold, err := strconv.ParseInt(strings.TrimSpace(thresholdStr), 10, 64)
+			if err != nil {
+				errorText := fmt.Sprintf("Invalid %s: %s", prmThresholds, thresholdsStr)
+				respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+				return
+			}
+			opts.Thresholds = append(opts.Thresholds, threshold)
+		}
+	}
+
+	watcher, err := pxy.WatchLag(group, topic, opts)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer watcher.Close()
+
+	// The first snapshot validates the group and topic before committing to
+	// a stream.
+	snapshot, ok := watcher.Next(r.Context().Done())
+	if !ok {
+		return
+	}
+	if err := snapshot.Err; err != nil {
+		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{Error: "Unknown topic", Code: errcode.TopicNotFound})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, snapshot.Err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || !strings.Contains(r.Header.Get(hdrAccept), contentTypeEventStream) {
+		respondWithJSON(w, http.StatusOK, toLagSnapshotView(snapshot))
+		return
+	}
+	w.Header().Set(hdrContentType, contentTypeEventStream)
+	w.Header().Set(hdrCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w, stopHeartbeats := s.withHeartbeats(w, eventStreamHeartbeat, contentTypeEventStream)
+	defer stopHeartbeats()
+	flusher = w.(http.Flusher)
+	for {
+		var event string
+		var data []byte
+		switch {
+		case snapshot.Err != nil:
+			event = "error"
+			rs := newErrorHTTPResponse(http.StatusInternalServerError, snapshot.Err)
+			rs.RequestID = requestIDOf(w)
+			data, _ = json.Marshal(rs)
+		case len(snapshot.Crossed) > 0:
+			event = "threshold"
+			data, _ = json.Marshal(toLagSnapshotView(snapshot))
+		default:
+			event = "lag"
+			data, _ = json.Marshal(toLagSnapshotView(snapshot))
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		if snapshot, ok = watcher.Next(r.Context().Done()); !ok {
+			return
+		}
+	}
+}
+
+func toLagSnapshotView(snapshot lagwatch.Snapshot) lagSnapshotView {
+	view := lagSnapshotView{
+		Time:       snapshot.Time,
+		Lag:        snapshot.Lag(),
+		Crossed:    snapshot.Crossed,
+		Partitions: make([]partitionOffsetView, len(snapshot.Offsets)),
+	}
+	for i, po := range snapshot.Offsets {
+		view.Partitions[i] = toPartitionOffsetView(po)
+	}
+	return view
+}
+
+// handleSetOffsets is an HTTP request handler for `POST /topic/{topic}/offsets`
+func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	group = tenant.Apply(group)
+	dryRun, err := getDryRunParam(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	shift, err := getShiftParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+
+	var partitionOffsets []admin.PartitionOffset
+	if shift != nil {
+		if len(bytes.TrimSpace(body)) > 0 {
+			respondWithError(w, http.StatusBadRequest,
+				errors.Errorf("either offsets or %s/%s should be provided", prmShiftBy, prmShiftTo))
+			return
+		}
+		// Offsets are fetched right before they are shifted, so that a
+		// moving partition end is taken into account.
+		partitionOffsets, err = pxy.GetGroupOffsets(group, topic)
+		if err == nil {
+			partitionOffsets = admin.ShiftOffsets(partitionOffsets, shift)
+		}
+	} else {
+		var partitionOffsetViews []partitionOffsetView
+		if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
+			errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+		partitionOffsets = make([]admin.PartitionOffset, len(partitionOffsetViews))
+		for i, pov := range partitionOffsetViews {
+			partitionOffsets[i].Partition = pov.Partition
+			partitionOffsets[i].Offset = pov.Offset
+			partitionOffsets[i].Metadata = pov.Metadata
+		}
+	}
+
+	var changes []admin.OffsetChange
+	if err == nil {
+		if dryRun {
+			changes, err = pxy.DryRunSetGroupOffsets(group, topic, partitionOffsets)
+		} else {
+			err = pxy.SetGroupOffsets(group, topic, partitionOffsets)
+		}
+	}
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{Error: "Unknown topic", Code: errcode.TopicNotFound})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if dryRun {
+		changeViews := make([]offsetChangeView, len(changes))
+		for i := range changes {
+			changeViews[i] = offsetChangeView{
+				Partition:   changes[i].Partition,
+				Begin:       changes[i].Begin,
+				End:         changes[i].End,
+				Offset:      changes[i].Offset,
+				NewOffset:   changes[i].NewOffset,
+				Metadata:    changes[i].Metadata,
+				NewMetadata: changes[i].NewMetadata,
+				OutOfRange:  changes[i].OutOfRange(),
+			}
+		}
+		respondWithJSON(w, http.StatusOK, setOffsetsDryRunView{DryRun: true, Changes: changeViews})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleDeleteRecords is an HTTP request handler for `DELETE /topics/{topic}/records`
+func (s *T) handleDeleteRecords(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	dryRun, err := getDryRunParam(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !dryRun {
+		respondWithError(w, http.StatusBadRequest, errDeleteRecordsUnsupported)
+		return
+	}
+	var before time.Time
+	if beforeStr := r.URL.Query().Get(prmBefore); beforeStr != "" {
+		if before, err = time.Parse(time.RFC3339, beforeStr); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmBefore, beforeStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+
+	var partitionOffsets []admin.PartitionOffset
+	if before.IsZero() {
+		var partitionOffsetViews []partitionOffsetView
+		if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
+			errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+		partitionOffsets = make([]admin.PartitionOffset, len(partitionOffsetViews))
+		for i, pov := range partitionOffsetViews {
+			partitionOffsets[i].Partition = pov.Partition
+			partitionOffsets[i].Offset = pov.Offset
+		}
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		respondWithError(w, http.StatusBadRequest,
+			errors.Errorf("either offsets or %s should be provided", prmBefore))
+		return
+	}
+
+	deletions, err := pxy.PlanDeleteRecords(topic, partitionOffsets, before)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName, admin.ErrOffsetOutOfRange, config.ErrKafkaFeatureUnsupported:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		case sarama.ErrUnknownTopicOrPartition:
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{Error: err.Error(), Code: errcode.TopicNotFound})
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	view := deleteRecordsView{DryRun: dryRun, Partitions: make([]recordsDeletionView, len(deletions))}
+	for i := range deletions {
+		view.Partitions[i] = recordsDeletionView{
+			Partition: deletions[i].Partition,
+			Begin:     deletions[i].Begin,
+			End:       deletions[i].End,
+			Offset:    deletions[i].Offset,
+			Deleted:   deletions[i].Deleted(),
+		}
+		view.Deleted += deletions[i].Deleted()
+	}
+	respondWithJSON(w, http.StatusOK, view)
+}
+
+// handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
+func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+
+	group, err := getGroupParam(r, true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var consumers map[string]map[string][]int32
+	if group == "" {
+		pg, err := getPageParams(r, tenant)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if stream, ok := newNDJSONStream(w, r); ok {
+			streamTopicConsumers(stream, pxy, topic, pg, tenant)
+			return
+		}
+		allConsumers, more, err := pxy.GetTopicConsumersPage(topic, pg)
+		// If data of some groups could not be fetched, then consumers of the
+		// rest of groups are still reported.
+		if groupErrors, ok := err.(admin.GroupErrors); ok {
+			log.Warningf("<%s> partial consumers of %s: %v", s.actorID, topic, err)
+			setGroupErrors(w, groupErrors, tenant)
+			err = nil
+		}
+		if err != nil {
+			if errors.Cause(err) == proxy.ErrInvalidName {
+				respondWithError(w, http.StatusBadRequest, err)
+				return
+			}
+			if errors.Cause(err) == proxy.ErrTopicForbidden {
+				respondWithError(w, http.StatusForbidden, err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// Only report groups that belong to the tenant.
+		consumers = make(map[string]map[string][]int32, len(allConsumers))
+		var lastGroup string
+		for group, groupConsumers := range allConsumers {
+			if group, ok := tenant.Strip(group); ok {
+				consumers[group] = groupConsumers
+				if group > lastGroup {
+					lastGroup = group
+				}
+			}
+		}
+		if more {
+			w.Header().Set(hdrNextPageToken, encodePageToken(lastGroup))
+		}
+	} else {
+		groupConsumers, err := pxy.GetTopicConsumers(tenant.Apply(group), topic)
+		if err != nil {
+			if errors.Cause(err) == proxy.ErrInvalidName {
+				respondWithError(w, http.StatusBadRequest, err)
+				return
+			}
+			if errors.Cause(err) == proxy.ErrTopicForbidden {
+				respondWithError(w, http.StatusForbidden, err)
+				return
+			}
+			if _, ok := err.(admin.ErrInvalidParam); ok {
+				respondWithError(w, http.StatusBadRequest, err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		consumers = make(map[string]map[string][]int32)
+		if len(groupConsumers) != 0 {
+			consumers[group] = groupConsumers
+		}
+	}
+
+	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
+	if err != nil {
+		log.Errorf("Failed to send HTTP response: status=%d, body=%v, err=%+v", http.StatusOK, encodedRes, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encodedRes = prettyfmt.CollapseJSON(encodedRes)
+
+	w.Header().Add(hdrContentType, "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(encodedRes); err != nil {
+		log.Errorf("Failed to send HTTP response: status=%d, body=%v, err=%+v", http.StatusOK, encodedRes, err)
+	}
+}
+
+// streamTopicConsumers writes consumers of every group as a separate NDJSON
+// line as soon as they are fetched from ZooKeeper. If there are more groups
+// after the page, then the last line holds a token to request the next page.
+func streamTopicConsumers(stream *ndjsonStream, pxy *proxy.T, topic string, pg admin.Page, tenant *tenancy.Tenant) {
+	var lastGroup string
+	var writeErr error
+	more, err := pxy.ScanTopicConsumers(topic, pg, func(group string, consumers map[string][]int32, err error) {
+		group, ok := tenant.Strip(group)
+		if !ok || writeErr != nil {
+			return
+		}
+		lastGroup = group
+		view := groupConsumersView{Group: group, Consumers: consumers}
+		if err != nil {
+			view.Error = err.Error()
+		}
+		writeErr = stream.write(view)
+	})
+	if err != nil {
+		stream.fail(err)
+		return
+	}
+	if more && writeErr == nil {
+		stream.write(nextPageView{NextPageToken: encodePageToken(lastGroup)})
+	}
+}
+
+// handleListTopics is an HTTP request handler for `GET /topics`
+func (s *T) handleListTopics(w http.ResponseWriter, r *http.Request) {
+	s.handleList(w, r, (*proxy.T).ListTopics)
+}
+
+// handleGetQuotas is an HTTP request handler for `GET /quotas`
+func (s *T) handleGetQuotas(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	user := r.URL.Query().Get(prmUser)
+	clientID := r.URL.Query().Get(prmClientID)
+	quotas, err := pxy.DescribeClientQuotas()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	views := []clientQuotasView{}
+	for _, cq := range quotas {
+		if (user != "" && cq.Entity.User != user) || (clientID != "" && cq.Entity.ClientID != clientID) {
+			continue
+		}
+		views = append(views, clientQuotasView{User: cq.Entity.User, ClientID: cq.Entity.ClientID, Quotas: cq.Values})
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleAlterQuotas is an HTTP request handler for `POST /quotas`
+func (s *T) handleAlterQuotas(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	entity := admin.QuotaEntity{
+		User:     r.URL.Query().Get(prmUser),
+		ClientID: r.URL.Query().Get(prmClientID),
+	}
+	var alter map[string]*float64
+	if err := json.NewDecoder(r.Body).Decode(&alter); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+	if err := pxy.AlterClientQuotas(entity, alter); err != nil {
+		switch errors.Cause(err) {
+		case admin.ErrInvalidQuota, config.ErrKafkaFeatureUnsupported:
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetBrokerConfig is an HTTP request handler for
+// `GET /cluster/brokers/{broker}/config`
+func (s *T) handleGetBrokerConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, brokerID, ok := s.getBrokerConfigParams(w, r)
+	if !ok {
+		return
+	}
+	configs, err := pxy.DescribeBrokerConfigs(brokerID)
+	if err != nil {
+		respondWithError(w, brokerConfigErrorStatus(err), err)
+		return
+	}
+	views := make([]brokerConfigView, len(configs))
+	for i, bc := range configs {
+		views[i] = brokerConfigView{
+			Name:      bc.Name,
+			Value:     bc.Value,
+			Source:    bc.Source,
+			Sensitive: bc.Sensitive,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleAlterBrokerConfig is an HTTP request handler for
+// `POST /cluster/brokers/{broker}/config`
+func (s *T) handleAlterBrokerConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, brokerID, ok := s.getBrokerConfigParams(w, r)
+	if !ok {
+		return
+	}
+	var alter map[string]*string
+	if err := json.NewDecoder(r.Body).Decode(&alter); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+	if err := pxy.AlterBrokerConfigs(brokerID, alter); err != nil {
+		respondWithError(w, brokerConfigErrorStatus(err), err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// getBrokerConfigParams returns the proxy and the broker ID that a broker
+// config request is for. If the request is invalid, then it responds with an
+// error and returns false.
+func (s *T) getBrokerConfigParams(w http.ResponseWriter, r *http.Request) (*proxy.T, int32, bool) {
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return nil, 0, false
+	}
+	brokerStr := mux.Vars(r)[prmBroker]
+	brokerID, err := strconv.ParseInt(brokerStr, 10, 32)
+	if err != nil || brokerID < 0 {
+		respondWithError(w, http.StatusBadRequest, errors.Errorf("Invalid %s: %s", prmBroker, brokerStr))
+		return nil, 0, false
+	}
+	return pxy, int32(brokerID), true
+}
+
+// brokerConfigErrorStatus returns an HTTP status to respond with to a broker
+// config request that failed with the specified error.
+func brokerConfigErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case admin.ErrInvalidBrokerConfig:
+		return http.StatusBadRequest
+	case proxy.ErrBrokerConfigsReadOnly:
+		return http.StatusForbidden
+	case admin.ErrBrokerNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// handleGetTopicStats is an HTTP request handler for
+// `GET /topics/{topic}/stats`
+func (s *T) handleGetTopicStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	limit, err := getLimitParam(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if limit == 0 {
+		limit = defaultTopGroups
+	}
+	stats, err := pxy.TopicStats(topic)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	view := topicStatsView{
+		Produced:  toThroughputView(stats.Produced),
+		Consumed:  toThroughputView(stats.Consumed),
+		TopGroups: []groupThroughputView{},
+	}
+	// Only groups that belong to the tenant are reported.
+	for _, gt := range stats.Groups {
+		if len(view.TopGroups) == limit {
+			break
+		}
+		if group, ok := tenant.Strip(gt.Group); ok {
+			view.TopGroups = append(view.TopGroups, groupThroughputView{group, toThroughputView(gt.Throughput)})
+		}
+	}
+	respondWithJSON(w, http.StatusOK, view)
+}
+
+// handleListGroups is an HTTP request handler for `GET /consumergroups`
+func (s *T) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	s.handleList(w, r, (*proxy.T).ListGroups)
+}
+
+// handleList responds with a page of names returned by `list`. If there are
+// more names after the page, then a token to request the next page with is
+// returned in the `X-Kafka-Pixy-Next-Page-Token` header.
+func (s *T) handleList(w http.ResponseWriter, r *http.Request,
+	list func(*proxy.T, admin.Page) ([]string, bool, error),
+) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	pg, err := getPageParams(r, tenant)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	names, more, err := list(pxy, pg)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i, name := range names {
+		names[i], _ = tenant.Strip(name)
+	}
+	if more && len(names) > 0 {
+		w.Header().Set(hdrNextPageToken, encodePageToken(names[len(names)-1]))
+	}
+	respondWithJSON(w, http.StatusOK, names)
+}
+
+// handleRebalanceGroup is an HTTP request handler for
+// `POST /consumergroups/{group}/rebalance`
+func (s *T) handleRebalanceGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+	log.Warningf("<%s> forced group rebalance: group=%s", s.actorID, group)
+	if err := pxy.RebalanceGroup(group); err != nil {
+		respondWithGroupAdminError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleEvictGroupMember is an HTTP request handler for
+// `DELETE /consumergroups/{group}/members/{member}`
+func (s *T) handleEvictGroupMember(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+	member := mux.Vars(r)[prmMember]
+	log.Warningf("<%s> evicting group member: group=%s, member=%s", s.actorID, group, member)
+	if err := pxy.EvictGroupMember(group, member); err != nil {
+		respondWithGroupAdminError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// respondWithGroupAdminError responds with an error returned by a consumer
+// group administration operation.
+func respondWithGroupAdminError(w http.ResponseWriter, err error) {
+	if errors.Cause(err) == proxy.ErrInvalidName {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == admin.ErrGroupNotFound || err == admin.ErrMemberNotFound {
+		respondWithError(w, http.StatusNotFound, err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, err)
+}
+
+// handleGetGroupEvents is an HTTP request handler for
+// `GET /consumergroups/{group}/events`. By default it long polls for
+// partition assignment events with sequence numbers greater then the `since`
+// parameter. If the client accepts `text/event-stream`, then events are
+// streamed as server-sent events until the client disconnects.
+func (s *T) handleGetGroupEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+	var since int64
+	if sinceStr := r.FormValue(prmSince); sinceStr != "" {
+		if since, err = strconv.ParseInt(sinceStr, 10, 64); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmSince, sinceStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+	// Get events that are already available without blocking, that also
+	// validates the group name before committing to a stream.
+	events, err := pxy.WatchGroupEvents(group, since, closedCh)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || !strings.Contains(r.Header.Get(hdrAccept), contentTypeEventStream) {
+		if len(events) == 0 {
+			events, _ = pxy.WatchGroupEvents(group, since, r.Context().Done())
+		}
+		respondWithJSON(w, http.StatusOK, toGroupEventViews(events, tenant))
+		return
+	}
+	w.Header().Set(hdrContentType, contentTypeEventStream)
+	w.Header().Set(hdrCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w, stopHeartbeats := s.withHeartbeats(w, eventStreamHeartbeat, contentTypeEventStream)
+	defer stopHeartbeats()
+	flusher = w.(http.Flusher)
+	for {
+		for _, ev := range toGroupEventViews(events, tenant) {
+			data, _ := json.Marshal(ev)
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Kind, data); err != nil {
+				return
+			}
+		}
+		if len(events) > 0 {
+			since = events[len(events)-1].Seq
+		}
+		flusher.Flush()
+		select {
+		case <-s.stopCh:
+			return
+		case <-r.Context().Done():
+			return
+		default:
+		}
+		events, _ = pxy.WatchGroupEvents(group, since, r.Context().Done())
+		// A comment line is sent on every long polling timeout to keep the
+		// connection alive.
+		if len(events) == 0 {
+			if _, err := fmt.Fprint(w, ":\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// toGroupEventViews converts assignment events to their API representation,
+// skipping events of topics that do not belong to the tenant.
+func toGroupEventViews(events []groupevents.Event, tenant *tenancy.Tenant) []groupEventView {
+	views := make([]groupEventView, 0, len(events))
+	for _, ev := range events {
+		topic, ok := tenant.Strip(ev.Topic)
+		if !ok {
+			continue
+		}
+		views = append(views, groupEventView{
+			Seq:        ev.Seq,
+			Kind:       string(ev.Kind),
+			Topic:      topic,
+			Partitions: ev.Partitions,
+			Time:       ev.Time,
+		})
+	}
+	return views
+}
+
+// handleGetGroupState is an HTTP request handler for
+// `GET /consumergroups/{group}/state`
+func (s *T) handleGetGroupState(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+	gs, err := pxy.GroupState(group)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	gsv := groupStateView{
+		Counts:          make(map[string]int64, len(gs.Counts)),
+		LastTransitions: make(map[string]time.Time, len(gs.LastTransitions)),
+		Recent:          make([]transitionView, 0, len(gs.Recent)),
+		Claims:          make([]claimView, 0, len(gs.Claims)),
+	}
+	for kind, count := range gs.Counts {
+		gsv.Counts[string(kind)] = count
+	}
+	for kind, last := range gs.LastTransitions {
+		gsv.LastTransitions[string(kind)] = last
+	}
+	for _, tr := range gs.Recent {
+		tv := transitionView{Kind: string(tr.Kind), Member: tr.Member, Time: tr.Time}
+		if tr.Topic != "" {
+			// Transitions of topics that do not belong to the tenant are
+			// skipped, like assignment events are.
+			topic, ok := tenant.Strip(tr.Topic)
+			if !ok {
+				continue
+			}
+			partition := tr.Partition
+			tv.Topic, tv.Partition = topic, &partition
+		}
+		gsv.Recent = append(gsv.Recent, tv)
+	}
+	for _, cl := range gs.Claims {
+		topic, ok := tenant.Strip(cl.Topic)
+		if !ok {
+			continue
+		}
+		gsv.Claims = append(gsv.Claims, claimView{
+			Topic:        topic,
+			Partition:    cl.Partition,
+			Owner:        cl.Owner,
+			WaitingSince: cl.WaitingSince,
+			Retries:      cl.Retries,
+			Failed:       cl.Failed,
+		})
+	}
+	if pr := gs.PendingRebalance; pr != nil {
+		gsv.PendingRebalance = &pendingRebalanceView{Since: pr.Since, Due: pr.Due, Changes: pr.Changes}
+	}
+	respondWithJSON(w, http.StatusOK, gsv)
+}
+
+// handlePeerConsume is an HTTP request handler for consume requests forwarded
+// by peers, see proxy.PeerRq.
+func (s *T) handlePeerConsume(w http.ResponseWriter, r *http.Request) {
+	s.handlePeerRequest(w, r, proxy.PeerConsumePath)
+}
+
+// handlePeerAck is an HTTP request handler for ack requests forwarded by
+// peers, see proxy.PeerRq.
+func (s *T) handlePeerAck(w http.ResponseWriter, r *http.Request) {
+	s.handlePeerRequest(w, r, proxy.PeerAckPath)
+}
+
+// handlePeerCheckpoint is an HTTP request handler for checkpoint requests
+// forwarded by peers, see proxy.PeerRq.
+func (s *T) handlePeerCheckpoint(w http.ResponseWriter, r *http.Request) {
+	s.handlePeerRequest(w, r, proxy.PeerCheckpointPath)
+}
+
+// handlePeerReplay is an HTTP request handler for replay requests forwarded
+// by peers, see proxy.PeerRq.
+func (s *T) handlePeerReplay(w http.ResponseWriter, r *http.Request) {
+	s.handlePeerRequest(w, r, proxy.PeerReplayPath)
+}
+
+// handlePeerLifecycle is an HTTP request handler for lifecycle requests
+// forwarded by peers, see proxy.PeerRq.
+func (s *T) handlePeerLifecycle(w http.ResponseWriter, r *http.Request) {
+	s.handlePeerRequest(w, r, proxy.PeerLifecyclePath)
+}
+
+// handlePeerNack is an HTTP request handler for nack requests forwarded by
+// peers, see proxy.PeerRq.
+func (s *T) handlePeerNack(w http.ResponseWriter, r *http.Request) {
+	s.handlePeerRequest(w, r, proxy.PeerNackPath)
+}
+
+func (s *T) handlePeerRequest(w http.ResponseWriter, r *http.Request, path string) {
+	defer r.Body.Close()
+
+	var rq proxy.PeerRq
+	if err := json.NewDecoder(r.Body).Decode(&rq); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, proxy.PeerRs{Error: err.Error()})
+		return
+	}
+	pxy, err := s.proxySet.Get(rq.Cluster)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, proxy.PeerRs{Error: err.Error()})
+		return
+	}
+	// Forwarded requests have tenant prefixes applied already, so they are
+	// authenticated by the routing secret instead.
+	if !pxy.IsPeer(r.Header.Get(proxy.PeerSecretHeader)) {
+		respondWithJSON(w, http.StatusUnauthorized, proxy.PeerRs{Error: "bad peer secret"})
+		return
+	}
+	switch path {
+	case proxy.PeerAckPath:
+		if err := pxy.AckLocal(rq.Group, rq.Topic, rq.Ack()); err != nil {
+			respondWithJSON(w, consumeErrorStatus(err), proxy.PeerRs{Error: err.Error()})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, proxy.PeerRs{})
+		return
+	case proxy.PeerCheckpointPath:
+		err := pxy.CheckpointLocal(rq.Group, rq.Topic, rq.AckPartition, rq.AckOffset, rq.CheckpointMeta)
+		if err != nil {
+			respondWithJSON(w, consumeErrorStatus(err), proxy.PeerRs{Error: err.Error()})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, proxy.PeerRs{})
+		return
+	case proxy.PeerNackPath:
+		err := pxy.NackLocal(rq.Group, rq.Topic, rq.AckPartition, rq.AckOffset, rq.NackClass, rq.NackReason)
+		if err != nil {
+			status := consumeErrorStatus(err)
+			if errors.Cause(err) == proxy.ErrInvalidNack {
+				status = http.StatusBadRequest
+			}
+			respondWithJSON(w, status, proxy.PeerRs{Error: err.Error()})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, proxy.PeerRs{})
+		return
+	case proxy.PeerReplayPath:
+		resolved, err := pxy.ReplayLocal(rq.Group, rq.Topic, rq.Replay)
+		if err != nil {
+			status := consumeErrorStatus(err)
+			if errors.Cause(err) == proxy.ErrInvalidReplay {
+				status = http.StatusBadRequest
+			}
+			respondWithJSON(w, status, proxy.PeerRs{Error: err.Error()})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, proxy.PeerRs{ReplayRanges: resolved})
+		return
+	case proxy.PeerLifecyclePath:
+		offsetReset, err := consumer.ParseOffsetReset(rq.OffsetReset)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, proxy.PeerRs{Error: err.Error()})
+			return
+		}
+		if err := pxy.LifecycleLocal(rq.Group, rq.Topic, rq.Lifecycle, offsetReset); err != nil {
+			respondWithJSON(w, consumeErrorStatus(err), proxy.PeerRs{Error: err.Error()})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, proxy.PeerRs{})
+		return
+	}
+	opts := consumer.ConsumeOpts{MaxMessages: rq.MaxMessages}
+	if opts.OffsetReset, err = consumer.ParseOffsetReset(rq.OffsetReset); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, proxy.PeerRs{Error: err.Error()})
+		return
+	}
+	consMsg, err := pxy.ConsumeLocal(rq.Group, rq.Topic, rq.Ack(), opts)
+	if err != nil {
+		respondWithJSON(w, consumeErrorStatus(err), proxy.PeerRs{Error: err.Error()})
+		return
+	}
+	rs := toPeerRs(consMsg)
+	for _, followingMsg := range consMsg.Following {
+		rs.Following = append(rs.Following, toPeerRs(followingMsg))
+	}
+	respondWithJSON(w, http.StatusOK, rs)
+}
+
+func toPeerRs(consMsg consumer.Message) proxy.PeerRs {
+	return proxy.PeerRs{
+		Key:           consMsg.Key,
+		Value:         consMsg.Value,
+		Partition:     consMsg.Partition,
+		Offset:        consMsg.Offset,
+		Timestamp:     consMsg.Timestamp,
+		HighWaterMark: consMsg.HighWaterMark,
+		Replay:        consMsg.Replay,
+		Skipped:       consMsg.Skipped,
+	}
+}
+
+// handleGetTenants is an HTTP request handler for `GET /_tenants`
+func (s *T) handleGetTenants(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	tenantViews := make(map[string]tenantView)
+	for name, stats := range pxy.Tenants().Stats() {
+		tenantViews[name] = tenantView{
+			Requests:  stats.Requests,
+			Throttled: stats.Throttled,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, tenantViews)
+}
+
+// handleGetAdminCache is an HTTP request handler for `GET /_admin/cache`
+func (s *T) handleGetAdminCache(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	stats := pxy.AdminCacheStats()
+	respondWithJSON(w, http.StatusOK, cacheView{
+		Entries: stats.Entries,
+		Hits:    stats.Hits,
+		Misses:  stats.Misses,
+	})
+}
+
+// handleInvalidateAdminCache is an HTTP request handler for
+// `DELETE /_admin/cache`
+func (s *T) handleInvalidateAdminCache(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	pxy.InvalidateAdminCache()
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetProducerMetadata is an HTTP request handler for
+// `GET /_producer/metadata`
+func (s *T) handleGetProducerMetadata(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	stats := pxy.ProducerMetadataStats()
+	now := time.Now()
+	view := producerMetadataView{
+		Refreshes:     stats.Refreshes,
+		RefreshErrors: stats.RefreshErrors,
+		Invalidations: stats.Invalidations,
+		Topics:        make(map[string]topicMetadataView, len(stats.Topics)),
+	}
+	for topic, tm := range stats.Topics {
+		topicView := topicMetadataView{Partitions: tm.Partitions, Leaders: tm.Leaders}
+		if !tm.RefreshedAt.IsZero() {
+			topicView.Age = now.Sub(tm.RefreshedAt).String()
+		}
+		view.Topics[topic] = topicView
+	}
+	respondWithJSON(w, http.StatusOK, view)
+}
+
+// handleGetConsumerSizes is an HTTP request handler for
+// `GET /_consumer/sizes`
+func (s *T) handleGetConsumerSizes(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	stats := pxy.MessageSizeStats()
+	views := make(map[string]messageSizesView, len(stats))
+	for topic, ts := range stats {
+		view := messageSizesView{
+			Messages:   ts.Messages,
+			Bytes:      ts.Bytes,
+			Compressed: ts.Compressed,
+			Histogram:  make([]sizeBucketView, len(ts.Histogram)),
+		}
+		for i, count := range ts.Histogram {
+			view.Histogram[i].Count = count
+			if i < len(sizestats.Buckets) {
+				view.Histogram[i].Below = sizestats.Buckets[i]
+			}
+		}
+		views[topic] = view
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleGetAlerts is an HTTP request handler for `GET /_alerts`
+func (s *T) handleGetAlerts(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, pxy.Alerts())
+}
+
+// handleGetTap is an HTTP request handler for `GET /_tap`. It streams
+// messages sampled from the `topic` parameters, or from all sampled topics,
+// as server-sent events. If the client does not accept `text/event-stream`,
+// then it responds with messages sampled within `timeout`, but no more than
+// `limit` of them.
+func (s *T) handleGetTap(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	var topics []string
+	for _, topic := range r.URL.Query()[prmTopic] {
+		topics = append(topics, tenant.Apply(topic))
+	}
+	timeout := defaultTapTimeout
+	if timeoutStr := r.FormValue(prmTimeout); timeoutStr != "" {
+		if timeout, err = time.ParseDuration(timeoutStr); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmTimeout, timeoutStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+	limit := defaultTapLimit
+	if limitStr := r.FormValue(prmLimit); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmLimit, limitStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+
+	sub, err := pxy.Tap(topics)
+	if err != nil {
+		switch {
+		case err == proxy.ErrTapDisabled:
+			respondWithError(w, http.StatusNotFound, err)
+		case errors.Cause(err) == proxy.ErrInvalidName:
+			respondWithError(w, http.StatusBadRequest, err)
+		case errors.Cause(err) == proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	defer sub.Close()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || !strings.Contains(r.Header.Get(hdrAccept), contentTypeEventStream) {
+		timeoutCh := make(chan struct{})
+		timer := time.AfterFunc(timeout, func() { close(timeoutCh) })
+		defer timer.Stop()
+		msgs := make([]tap.Message, 0, limit)
+		for len(msgs) < limit {
+			msg, ok := sub.Next(timeoutCh)
+			if !ok {
+				break
+			}
+			msgs = append(msgs, msg)
+		}
+		respondWithJSON(w, http.StatusOK, msgs)
+		return
+	}
+	w.Header().Set(hdrContentType, contentTypeEventStream)
+	w.Header().Set(hdrCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w, stopHeartbeats := s.withHeartbeats(w, eventStreamHeartbeat, contentTypeEventStream)
+	defer stopHeartbeats()
+	flusher = w.(http.Flusher)
+	flusher.Flush()
+	cancelCh := make(chan struct{})
+	go func() {
+		select {
+		case <-s.stopCh:
+		case <-r.Context().Done():
+		}
+		close(cancelCh)
+	}()
+	for {
+		msg, ok := sub.Next(cancelCh)
+		if !ok {
+			return
+		}
+		data, _ := json.Marshal(msg)
+		if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handleGetHealth is an HTTP request handler for `GET /_health`. It responds
+// with 503 if there are persistent offset commit failures.
+func (s *T) handleGetHealth(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	view := healthView{OffsetCommitFailures: pxy.OffsetCommitFailures()}
+	status := http.StatusOK
+	view.Healthy = len(view.OffsetCommitFailures) == 0
+	if !view.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, status, view)
+}
+
+// handleGetReady is an HTTP request handler for `GET /_ready`. It responds
+// with 503 until the proxy of the cluster, or all proxies if no cluster is
+// given, have warmed up.
+func (s *T) handleGetReady(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	view := readyView{Ready: true}
+	if cluster := mux.Vars(r)[prmCluster]; cluster != "" {
+		pxy, err := s.proxySet.Get(cluster)
 		if err != nil {
-			respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !pxy.Ready() {
+			view.NotReady = append(view.NotReady, cluster)
+		}
+	} else {
+		for _, member := range s.proxySet.Members() {
+			if !member.Proxy.Ready() {
+				view.NotReady = append(view.NotReady, member.Cluster)
+			}
+		}
+	}
+	status := http.StatusOK
+	if len(view.NotReady) > 0 {
+		view.Ready = false
+		status = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, status, view)
+}
+
+// handleGetMetrics is an HTTP request handler for `GET /_metrics`
+func (s *T) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	views := []metricView{}
+	pxy.Metrics().Each(func(m *metrics.Metric) {
+		views = append(views, metricView{Name: m.Name, Tags: m.Tags, Values: metrics.Values(m.Value)})
+	})
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleGetFaults is an HTTP request handler for `GET /_faults`
+func (s *T) handleGetFaults(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	_, faults, status, err := s.getFaults(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	faultViews := make(map[string]faultView)
+	for op, fault := range faults.Faults() {
+		faultViews[op] = faultView{
+			ErrorRate: fault.ErrorRate,
+			Latency:   fault.Latency.String(),
+		}
+	}
+	respondWithJSON(w, http.StatusOK, faultViews)
+}
+
+// handleSetFault is an HTTP request handler for `POST /_faults/{op}`
+func (s *T) handleSetFault(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	_, faults, status, err := s.getFaults(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	var fault chaos.Fault
+	if errorRateStr := r.FormValue(prmErrorRate); errorRateStr != "" {
+		if fault.ErrorRate, err = strconv.ParseFloat(errorRateStr, 64); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmErrorRate, errorRateStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+	if latencyStr := r.FormValue(prmLatency); latencyStr != "" {
+		if fault.Latency, err = time.ParseDuration(latencyStr); err != nil {
+			errorText := fmt.Sprintf("Invalid %s: %s", prmLatency, latencyStr)
+			respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+			return
+		}
+	}
+	op := mux.Vars(r)[prmOp]
+	if err := faults.Set(op, fault); err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Warningf("<%s> fault injected: op=%s, errorRate=%v, latency=%v",
+		s.actorID, op, fault.ErrorRate, fault.Latency)
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleResetFaults is an HTTP request handler for `DELETE /_faults`
+func (s *T) handleResetFaults(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	_, faults, status, err := s.getFaults(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	faults.Reset()
+	log.Warningf("<%s> faults reset", s.actorID)
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleRebalance is an HTTP request handler for `POST /_faults/rebalance`
+func (s *T) handleRebalance(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, _, status, err := s.getFaults(r)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	log.Warningf("<%s> forced rebalance", s.actorID)
+	if err := pxy.Rebalance(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// getFaults returns the proxy that a request is addressed to along with its
+// fault injector. If fault injection is disabled for the proxy, or the request
+// is not authenticated as made by an operator, then an error is returned along
+// with an HTTP status to respond with.
+func (s *T) getFaults(r *http.Request) (*proxy.T, *chaos.T, int, error) {
+	pxy, status, err := s.getOperatorProxy(r)
+	if err != nil {
+		return nil, nil, status, err
+	}
+	faults := pxy.Faults()
+	if faults == nil {
+		return nil, nil, http.StatusBadRequest, errors.New("fault injection is disabled")
+	}
+	return pxy, faults, http.StatusOK, nil
+}
+
+// authenticate returns a tenant that a request comes from. If the request is
+// not authenticated or the tenant quota is exceeded, then an error is returned
+// along with an HTTP status to respond with.
+func authenticate(r *http.Request, pxy *proxy.T) (*tenancy.Tenant, int, error) {
+	token := strings.TrimPrefix(r.Header.Get(hdrAuthorization), bearerPrefix)
+	tenant, err := pxy.Tenants().Authenticate(token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err := tenant.Admit(); err != nil {
+		return nil, http.StatusTooManyRequests, err
+	}
+	return tenant, http.StatusOK, nil
+}
+
+// getOperatorProxy returns the proxy that a request is addressed to, if the
+// request is authenticated as made by an operator, that is not a tenant.
+// Otherwise it returns an HTTP status to respond with along with an error.
+func (s *T) getOperatorProxy(r *http.Request) (*proxy.T, int, error) {
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if status, err := authenticateOperator(r, pxy); err != nil {
+		return nil, status, err
+	}
+	return pxy, http.StatusOK, nil
+}
+
+// authenticateOperator authenticates a request against a proxy and rejects
+// it, unless it is authenticated with an operator token, or the proxy has no
+// tenants configured.
+func authenticateOperator(r *http.Request, pxy *proxy.T) (int, error) {
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		return status, err
+	}
+	if tenant != nil {
+		return http.StatusForbidden, errTenantForbidden
+	}
+	return http.StatusOK, nil
+}
+
+// handleGetSessions is an HTTP request handler for `GET /_sessions`
+func (s *T) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, status, err := s.getOperatorProxy(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s.sessions.Sessions())
+}
+
+// handleGetSlowSessions is an HTTP request handler for `GET /_sessions/slow`
+func (s *T) handleGetSlowSessions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, status, err := s.getOperatorProxy(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s.sessions.SlowSessions())
+}
+
+// handleEvictSession is an HTTP request handler for
+// `DELETE /_sessions/{session}`
+func (s *T) handleEvictSession(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, status, err := s.getOperatorProxy(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	id := mux.Vars(r)[prmSession]
+	if !s.sessions.Evict(id) {
+		respondWithError(w, http.StatusNotFound, errors.Errorf("session %s does not exist", id))
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetEffectiveConfig is an HTTP request handler for
+// `GET /topics/{topic}/consumers/{group}/config`. The consume request
+// parameters, if given, are reported as overrides of respective parameters.
+func (s *T) handleGetEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	topic := tenant.Apply(mux.Vars(r)[prmTopic])
+	group := tenant.Apply(mux.Vars(r)[prmGroup])
+	consOpts, err := getConsumeOpts(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	params, err := pxy.EffectiveConfig(group, topic)
+	if err != nil {
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == proxy.ErrTopicForbidden {
+			respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if offsetReset := r.FormValue(prmOffsetReset); offsetReset != "" {
+		params = config.OverrideParam(params, "consumer.offset_reset", offsetReset)
+	}
+	if consOpts.MaxMessages > 0 {
+		for _, param := range params {
+			if maxClaimSize, ok := param.Value.(int); ok && param.Name == "consumer.max_claim_size" {
+				params = config.OverrideParam(params, param.Name, consOpts.ClaimSize(maxClaimSize))
+				break
+			}
+		}
+	}
+	views := make([]paramView, len(params))
+	for i, param := range params {
+		views[i] = paramView{Name: param.Name, Value: param.Value, Source: param.Source}
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleStartCopy is an HTTP request handler for `POST /_copies`
+func (s *T) handleStartCopy(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	var crv copyRqView
+	if err := json.NewDecoder(r.Body).Decode(&crv); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
+		return
+	}
+	dst := pxy
+	if crv.ToCluster != "" {
+		if dst, err = s.proxySet.Get(crv.ToCluster); err != nil {
+			respondWithError(w, http.StatusBadRequest, err)
 			return
 		}
-	} else {
-		groupConsumers, err := pxy.GetTopicConsumers(group, topic)
-		if err != nil {
-			if _, ok := err.(admin.ErrInvalidParam); ok {
-				respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
-				return
+	}
+	rq := proxy.CopyRq{
+		Partition:  crv.Partition,
+		FromOffset: crv.FromOffset,
+		ToOffset:   crv.ToOffset,
+		FromTime:   crv.FromTime,
+		ToTime:     crv.ToTime,
+		ToCluster:  crv.ToCluster,
+		ToTopic:    tenant.Apply(crv.ToTopic),
+	}
+	progress, err := pxy.Copy(tenant.Apply(crv.Topic), rq, dst)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrInvalidName:
+			respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrTopicForbidden:
+			respondWithError(w, http.StatusForbidden, err)
+		case sarama.ErrUnknownTopicOrPartition:
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	cv, _ := toCopyView(tenant, progress)
+	respondWithJSON(w, http.StatusOK, cv)
+}
+
+// handleListCopies is an HTTP request handler for `GET /_copies`
+func (s *T) handleListCopies(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	// Only jobs that copy topics of the tenant are reported.
+	views := []copyView{}
+	for _, progress := range pxy.Copies() {
+		if cv, ok := toCopyView(tenant, progress); ok {
+			views = append(views, cv)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleGetCopy is an HTTP request handler for `GET /_copies/{copy}`
+func (s *T) handleGetCopy(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, status, err := authenticate(r, pxy)
+	if err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	copyID := mux.Vars(r)[prmCopy]
+	progress, err := pxy.CopyProgress(copyID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err)
+		return
+	}
+	cv, ok := toCopyView(tenant, progress)
+	if !ok {
+		// Jobs of other tenants are reported as missing, so that their IDs
+		// cannot be probed.
+		respondWithError(w, http.StatusNotFound, errors.Wrapf(proxy.ErrCopyNotFound, "id=%s", copyID))
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cv)
+}
+
+// toCopyView returns a view of a copy job with topic names as the tenant
+// clients use them. It returns false if the job copies topics that do not
+// belong to the tenant.
+func toCopyView(tenant *tenancy.Tenant, progress proxy.CopyProgress) (copyView, bool) {
+	topic, ok := tenant.Strip(progress.Topic)
+	if !ok {
+		return copyView{}, false
+	}
+	toTopic, ok := tenant.Strip(progress.ToTopic)
+	if !ok {
+		return copyView{}, false
+	}
+	cv := copyView{
+		ID:         progress.ID,
+		Topic:      topic,
+		Partition:  progress.Partition,
+		ToCluster:  progress.ToCluster,
+		ToTopic:    toTopic,
+		FromOffset: progress.FromOffset,
+		ToOffset:   progress.ToOffset,
+		NextOffset: progress.NextOffset,
+		Copied:     progress.Copied,
+		Done:       progress.Done(),
+		Started:    progress.Started,
+		Error:      progress.Error,
+	}
+	if progress.Done() {
+		finished := progress.Finished
+		cv.Finished = &finished
+	}
+	return cv, true
+}
+
+// handleListProxies is an HTTP request handler for `GET /_proxies`
+func (s *T) handleListProxies(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if status, err := s.authenticateProxyAdmin(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	views := []proxyView{}
+	for _, member := range s.proxySet.Members() {
+		view := proxyView{
+			Cluster:        member.Cluster,
+			Default:        member.Default,
+			Dynamic:        member.Dynamic,
+			KafkaSeedPeers: member.Proxy.KafkaSeedPeers(),
+			Healthy:        len(member.Proxy.OffsetCommitFailures()) == 0,
+		}
+		if status, err := s.proxySet.FailoverStatus(member.Cluster); err == nil {
+			view.ActiveCluster = status.Active
+		}
+		views = append(views, view)
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// handleGetFailover is an HTTP request handler for `GET /_failover`. It
+// responds with which cluster, primary or standby, a proxy is connected to,
+// and with the outcome of recent health checks of both.
+func (s *T) handleGetFailover(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, status, err := s.getOperatorProxy(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	cluster := mux.Vars(r)[prmCluster]
+	if cluster == "" {
+		for _, member := range s.proxySet.Members() {
+			if member.Default {
+				cluster = member.Cluster
 			}
-			respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
-			return
 		}
-		consumers = make(map[string]map[string][]int32)
-		if len(groupConsumers) != 0 {
-			consumers[group] = groupConsumers
+	}
+	status, err := s.proxySet.FailoverStatus(cluster)
+	if err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrProxyNotFound:
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusNotFound, err)
 		}
+		return
 	}
+	respondWithJSON(w, http.StatusOK, failoverView{
+		Cluster:    cluster,
+		Active:     status.Active,
+		Primary:    newClusterHealthView(status.Primary),
+		Standby:    newClusterHealthView(status.Standby),
+		Switches:   status.Switches,
+		SwitchedAt: status.SwitchedAt,
+	})
+}
 
-	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
+// handleRegisterProxy is an HTTP request handler for `POST /_proxies`. The
+// request body is a proxy config in YAML or JSON.
+func (s *T) handleRegisterProxy(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if status, err := s.authenticateProxyAdmin(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	if !s.opts.ProxyRegistration {
+		respondWithError(w, http.StatusForbidden, errors.New("proxy registration is disabled"))
+		return
+	}
+	cluster := r.URL.Query().Get(prmCluster)
+	if cluster == "" {
+		respondWithError(w, http.StatusBadRequest, errors.Errorf("%s parameter is required", prmCluster))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Errorf("Failed to send HTTP response: status=%d, body=%v, err=%+v", http.StatusOK, encodedRes, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		respondWithError(w, http.StatusBadRequest, errors.New(errorText))
 		return
 	}
-	encodedRes = prettyfmt.CollapseJSON(encodedRes)
+	pxyCfg, err := config.ProxyFromYAML(body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.proxySet.Register(cluster, pxyCfg); err != nil {
+		if errors.Cause(err) == proxy.ErrProxyExists {
+			respondWithError(w, http.StatusConflict, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
 
-	w.Header().Add(hdrContentType, "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(encodedRes); err != nil {
-		log.Errorf("Failed to send HTTP response: status=%d, body=%v, err=%+v", http.StatusOK, encodedRes, err)
+// handleDeregisterProxy is an HTTP request handler for
+// `DELETE /_proxies/{cluster}`
+func (s *T) handleDeregisterProxy(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if status, err := s.authenticateProxyAdmin(r); err != nil {
+		respondWithError(w, status, err)
+		return
+	}
+	if !s.opts.ProxyRegistration {
+		respondWithError(w, http.StatusForbidden, errors.New("proxy registration is disabled"))
+		return
+	}
+	if err := s.proxySet.Deregister(mux.Vars(r)[prmCluster]); err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrProxyNotFound:
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// authenticateProxyAdmin authenticates a request that lists, registers or
+// deregisters proxies. Such requests concern all clusters, so they are
+// authenticated against the default proxy, that can never be deregistered,
+// and are forbidden to tenants.
+func (s *T) authenticateProxyAdmin(r *http.Request) (int, error) {
+	pxy, err := s.proxySet.Get("")
+	if err != nil {
+		return http.StatusBadRequest, err
 	}
+	return authenticateOperator(r, pxy)
 }
 
 func (s *T) handlePing(w http.ResponseWriter, r *http.Request) {
@@ -442,17 +3333,105 @@ func (s *T) handlePing(w http.ResponseWriter, r *http.Request) {
 }
 
 type produceHTTPResponse struct {
-	Partition int32 `json:"partition"`
-	Offset    int64 `json:"offset"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Acks      string `json:"acks"`
 }
 
-type consumeHTTPResponse struct {
+type snapshotEntryView struct {
 	Key       []byte `json:"key"`
 	Value     []byte `json:"value"`
 	Partition int32  `json:"partition"`
 	Offset    int64  `json:"offset"`
 }
 
+type fanOutHTTPResponse struct {
+	Results []fanOutResultView `json:"results"`
+}
+
+type fanOutResultView struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Error     string `json:"error,omitempty"`
+}
+
+// consumeHTTPResponse describes the JSON that appendConsumeResponse encodes
+// consumed messages to.
+type consumeHTTPResponse struct {
+	Key           []byte                `json:"key"`
+	Value         []byte                `json:"value"`
+	Partition     int32                 `json:"partition"`
+	Offset        int64                 `json:"offset"`
+	HighWaterMark int64                 `json:"high_watermark"`
+	Lag           int64                 `json:"lag"`
+	Replay        bool                  `json:"replay,omitempty"`
+	Skipped       int64                 `json:"skipped,omitempty"`
+	Following     []consumeHTTPResponse `json:"following,omitempty"`
+}
+
+type paramView struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+type proxyView struct {
+	Cluster        string   `json:"cluster"`
+	Default        bool     `json:"default"`
+	Dynamic        bool     `json:"dynamic"`
+	KafkaSeedPeers []string `json:"kafka_seed_peers"`
+	Healthy        bool     `json:"healthy"`
+	ActiveCluster  string   `json:"active_cluster,omitempty"`
+}
+
+type failoverView struct {
+	Cluster    string            `json:"cluster"`
+	Active     string            `json:"active"`
+	Primary    clusterHealthView `json:"primary"`
+	Standby    clusterHealthView `json:"standby"`
+	Switches   int               `json:"switches"`
+	SwitchedAt time.Time         `json:"switched_at,omitempty"`
+}
+
+type clusterHealthView struct {
+	SeedPeers            []string  `json:"seed_peers"`
+	ResolvedPeers        []string  `json:"resolved_peers"`
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	LastError            string    `json:"last_error,omitempty"`
+	CheckedAt            time.Time `json:"checked_at"`
+}
+
+func newClusterHealthView(ch proxy.ClusterHealth) clusterHealthView {
+	return clusterHealthView{
+		SeedPeers:            ch.SeedPeers,
+		ResolvedPeers:        ch.ResolvedPeers,
+		Healthy:              ch.Healthy,
+		ConsecutiveFailures:  ch.ConsecutiveFailures,
+		ConsecutiveSuccesses: ch.ConsecutiveSuccesses,
+		LastError:            ch.LastError,
+		CheckedAt:            ch.CheckedAt,
+	}
+}
+
+type healthView struct {
+	Healthy              bool                `json:"healthy"`
+	OffsetCommitFailures []offsetmgr.Failure `json:"offset_commit_failures,omitempty"`
+}
+
+type flushView struct {
+	Acked   int `json:"acked"`
+	Failed  int `json:"failed"`
+	Pending int `json:"pending"`
+}
+
+type readyView struct {
+	Ready    bool     `json:"ready"`
+	NotReady []string `json:"not_ready,omitempty"`
+}
+
 type partitionOffsetView struct {
 	Partition  int32  `json:"partition"`
 	Begin      int64  `json:"begin"`
@@ -464,8 +3443,266 @@ type partitionOffsetView struct {
 	SparseAcks string `json:"sparse_acks,omitempty"`
 }
 
+type checkpointView struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata"`
+	Committed int64  `json:"committed"`
+}
+
+type nackView struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Class     string `json:"class"`
+	Reason    string `json:"reason"`
+}
+
+type consumerStatusView struct {
+	Group   string `json:"group"`
+	Topic   string `json:"topic"`
+	Running bool   `json:"running"`
+}
+
+type replayRangeView struct {
+	Partition  int32     `json:"partition"`
+	FromOffset int64     `json:"from_offset"`
+	ToOffset   int64     `json:"to_offset"`
+	FromTime   time.Time `json:"from_time"`
+	ToTime     time.Time `json:"to_time"`
+}
+
+type copyRqView struct {
+	Topic      string    `json:"topic"`
+	Partition  int32     `json:"partition"`
+	FromOffset int64     `json:"from_offset"`
+	ToOffset   int64     `json:"to_offset"`
+	FromTime   time.Time `json:"from_time"`
+	ToTime     time.Time `json:"to_time"`
+	ToCluster  string    `json:"to_cluster"`
+	ToTopic    string    `json:"to_topic"`
+}
+
+type copyView struct {
+	ID         string     `json:"id"`
+	Topic      string     `json:"topic"`
+	Partition  int32      `json:"partition"`
+	ToCluster  string     `json:"to_cluster,omitempty"`
+	ToTopic    string     `json:"to_topic"`
+	FromOffset int64      `json:"from_offset"`
+	ToOffset   int64      `json:"to_offset"`
+	NextOffset int64      `json:"next_offset"`
+	Copied     int64      `json:"copied"`
+	Done       bool       `json:"done"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type lagSnapshotView struct {
+	Time       time.Time             `json:"time"`
+	Lag        int64                 `json:"lag"`
+	Crossed    []int64               `json:"crossed,omitempty"`
+	Partitions []partitionOffsetView `json:"partitions"`
+}
+
+type offsetChangeView struct {
+	Partition   int32  `json:"partition"`
+	Begin       int64  `json:"begin"`
+	End         int64  `json:"end"`
+	Offset      int64  `json:"offset"`
+	NewOffset   int64  `json:"new_offset"`
+	Metadata    string `json:"metadata,omitempty"`
+	NewMetadata string `json:"new_metadata,omitempty"`
+	OutOfRange  bool   `json:"out_of_range,omitempty"`
+}
+
+type setOffsetsDryRunView struct {
+	DryRun  bool               `json:"dry_run"`
+	Changes []offsetChangeView `json:"changes"`
+}
+
+type brokerConfigView struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+type clientQuotasView struct {
+	User     string             `json:"user,omitempty"`
+	ClientID string             `json:"client_id,omitempty"`
+	Quotas   map[string]float64 `json:"quotas"`
+}
+
+type recordsDeletionView struct {
+	Partition int32 `json:"partition"`
+	Begin     int64 `json:"begin"`
+	End       int64 `json:"end"`
+	Offset    int64 `json:"offset"`
+	Deleted   int64 `json:"deleted"`
+}
+
+type deleteRecordsView struct {
+	DryRun     bool                  `json:"dry_run"`
+	Deleted    int64                 `json:"deleted"`
+	Partitions []recordsDeletionView `json:"partitions"`
+}
+
+type groupEventView struct {
+	Seq        int64     `json:"seq"`
+	Kind       string    `json:"kind"`
+	Topic      string    `json:"topic"`
+	Partitions []int32   `json:"partitions"`
+	Time       time.Time `json:"time"`
+}
+
+type groupStateView struct {
+	Counts          map[string]int64     `json:"counts"`
+	LastTransitions map[string]time.Time `json:"last_transitions"`
+	Recent          []transitionView     `json:"recent"`
+	Claims          []claimView          `json:"claims"`
+
+	PendingRebalance *pendingRebalanceView `json:"pending_rebalance,omitempty"`
+}
+
+type pendingRebalanceView struct {
+	Since   time.Time `json:"since"`
+	Due     time.Time `json:"due"`
+	Changes int       `json:"changes"`
+}
+
+type transitionView struct {
+	Kind      string    `json:"kind"`
+	Topic     string    `json:"topic,omitempty"`
+	Partition *int32    `json:"partition,omitempty"`
+	Member    string    `json:"member,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+type claimView struct {
+	Topic        string    `json:"topic"`
+	Partition    int32     `json:"partition"`
+	Owner        string    `json:"owner"`
+	WaitingSince time.Time `json:"waiting_since"`
+	Retries      int       `json:"retries"`
+	Failed       bool      `json:"failed"`
+}
+
+type groupConsumersView struct {
+	Group     string             `json:"group"`
+	Consumers map[string][]int32 `json:"consumers,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+type nextPageView struct {
+	NextPageToken string `json:"next_page_token"`
+}
+
+type tenantView struct {
+	Requests  int64 `json:"requests"`
+	Throttled int64 `json:"throttled"`
+}
+
+type cacheView struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+type producerMetadataView struct {
+	Refreshes     int64                        `json:"refreshes"`
+	RefreshErrors int64                        `json:"refresh_errors"`
+	Invalidations int64                        `json:"invalidations"`
+	Topics        map[string]topicMetadataView `json:"topics"`
+}
+
+type topicMetadataView struct {
+	Partitions int             `json:"partitions"`
+	Leaders    map[int32]int32 `json:"leaders"`
+	Age        string          `json:"age,omitempty"`
+}
+
+type messageSizesView struct {
+	Messages   int64            `json:"messages"`
+	Bytes      int64            `json:"bytes"`
+	Compressed map[string]int64 `json:"compressed"`
+	Histogram  []sizeBucketView `json:"histogram"`
+}
+
+type topicStatsView struct {
+	Produced  throughputView        `json:"produced"`
+	Consumed  throughputView        `json:"consumed"`
+	TopGroups []groupThroughputView `json:"top_groups"`
+}
+
+type throughputView struct {
+	Messages     int64     `json:"messages"`
+	Bytes        int64     `json:"bytes"`
+	MessageRates ratesView `json:"messages_per_sec"`
+	ByteRates    ratesView `json:"bytes_per_sec"`
+}
+
+type groupThroughputView struct {
+	Group string `json:"group"`
+	throughputView
+}
+
+type ratesView struct {
+	M1  float64 `json:"1m"`
+	M5  float64 `json:"5m"`
+	M15 float64 `json:"15m"`
+}
+
+func toThroughputView(t topicstats.Throughput) throughputView {
+	return throughputView{
+		Messages:     t.Messages,
+		Bytes:        t.Bytes,
+		MessageRates: ratesView(t.MessageRates),
+		ByteRates:    ratesView(t.ByteRates),
+	}
+}
+
+type sizeBucketView struct {
+	Below int   `json:"below,omitempty"`
+	Count int64 `json:"count"`
+}
+
+type metricView struct {
+	Name   string                 `json:"name"`
+	Tags   map[string]string      `json:"tags,omitempty"`
+	Values map[string]interface{} `json:"values"`
+}
+
+type faultView struct {
+	ErrorRate float64 `json:"error_rate"`
+	Latency   string  `json:"latency"`
+}
+
 type errorHTTPResponse struct {
 	Error string `json:"error"`
+
+	// One of errcode constants.
+	Code string `json:"code"`
+
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// newErrorHTTPResponse creates an error response body with the code of the
+// error cause, or if it has no specific code, then with the one that
+// corresponds to the HTTP status.
+func newErrorHTTPResponse(status int, err error) errorHTTPResponse {
+	code := errcode.Of(err)
+	if code == "" {
+		code = errcode.ForHTTPStatus(status)
+	}
+	return errorHTTPResponse{Error: err.Error(), Code: code}
+}
+
+type incidentHTTPResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Incident  string `json:"incident"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // getParamBytes returns the request parameter s a slice of bytes. It works
@@ -480,8 +3717,136 @@ func getParamBytes(r *http.Request, name string) []byte {
 	return []byte(values[0])
 }
 
+// ndjsonStream writes a response as newline delimited JSON, flushing every
+// line to the client as soon as it is written.
+type ndjsonStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+// newNDJSONStream returns a stream to write a response to, if the client
+// accepts `application/x-ndjson`.
+func newNDJSONStream(w http.ResponseWriter, r *http.Request) (*ndjsonStream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || !strings.Contains(r.Header.Get(hdrAccept), contentTypeNDJSON) {
+		return nil, false
+	}
+	return &ndjsonStream{w: w, flusher: flusher}, true
+}
+
+func (ns *ndjsonStream) write(v interface{}) error {
+	if !ns.started {
+		ns.w.Header().Set(hdrContentType, contentTypeNDJSON)
+		ns.w.WriteHeader(http.StatusOK)
+		ns.started = true
+	}
+	if err := json.NewEncoder(ns.w).Encode(v); err != nil {
+		return err
+	}
+	ns.flusher.Flush()
+	return nil
+}
+
+// fail responds with an error. If the response has already started, then
+// the error is written as the last line.
+func (ns *ndjsonStream) fail(err error) {
+	if !ns.started {
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == proxy.ErrInvalidName {
+			status = http.StatusBadRequest
+		} else if errors.Cause(err) == proxy.ErrTopicForbidden {
+			status = http.StatusForbidden
+		}
+		respondWithError(ns.w, status, err)
+		return
+	}
+	rs := newErrorHTTPResponse(http.StatusInternalServerError, err)
+	rs.RequestID = requestIDOf(ns.w)
+	ns.write(rs)
+}
+
+// setGroupErrors reports errors of consumer groups that belong to the tenant
+// in the `X-Kafka-Pixy-Group-Errors` header as a JSON object.
+func setGroupErrors(w http.ResponseWriter, groupErrors admin.GroupErrors, tenant *tenancy.Tenant) {
+	errorMsgs := make(map[string]string, len(groupErrors))
+	for group, err := range groupErrors {
+		if group, ok := tenant.Strip(group); ok {
+			errorMsgs[group] = err.Error()
+		}
+	}
+	if len(errorMsgs) == 0 {
+		return
+	}
+	encoded, _ := json.Marshal(errorMsgs)
+	w.Header().Set(hdrGroupErrors, string(encoded))
+}
+
+// getPageParams returns a page of tenant names selected by the `limit`,
+// `filter`, and `pageToken` request parameters.
+func getPageParams(r *http.Request, tenant *tenancy.Tenant) (admin.Page, error) {
+	pg := admin.Page{Prefix: tenant.Apply("")}
+	var err error
+	if pg.Limit, err = getLimitParam(r); err != nil {
+		return pg, err
+	}
+	if filter := r.FormValue(prmFilter); filter != "" {
+		if pg.Filter, err = regexp.Compile(filter); err != nil {
+			return pg, errors.Wrap(err, "invalid filter")
+		}
+	}
+	token, ok, err := getPageTokenParam(r)
+	if err != nil {
+		return pg, err
+	}
+	if ok {
+		pg.After = tenant.Apply(token)
+	}
+	return pg, nil
+}
+
+// getLimitParam returns the `limit` request parameter, or zero if it is not
+// specified.
+func getLimitParam(r *http.Request) (int, error) {
+	limitStr := r.FormValue(prmLimit)
+	if limitStr == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 0 {
+		return 0, errors.Errorf("invalid limit: %s", limitStr)
+	}
+	return limit, nil
+}
+
+// getPageTokenParam returns the decoded `pageToken` request parameter, and
+// whether it is specified at all.
+func getPageTokenParam(r *http.Request) (string, bool, error) {
+	tokenStr := r.FormValue(prmPageToken)
+	if tokenStr == "" {
+		return "", false, nil
+	}
+	token, err := base64.RawURLEncoding.DecodeString(tokenStr)
+	if err != nil {
+		return "", false, errors.New("invalid page token")
+	}
+	return string(token), true, nil
+}
+
+// encodePageToken makes an opaque page token out of the last name or
+// partition on a page.
+func encodePageToken(last string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(last))
+}
+
 // respondWithJSON marshals `body` to a JSON string and sends it s an HTTP
 // response body along with the specified `status` code.
+func respondWithError(w http.ResponseWriter, status int, err error) {
+	rs := newErrorHTTPResponse(status, err)
+	rs.RequestID = requestIDOf(w)
+	respondWithJSON(w, status, rs)
+}
+
 func respondWithJSON(w http.ResponseWriter, status int, body interface{}) {
 	encodedRes, err := json.MarshalIndent(body, "", "  ")
 	if err != nil {
@@ -509,6 +3874,52 @@ func getGroupParam(r *http.Request, opt bool) (string, error) {
 	return groups[0], nil
 }
 
+// getDryRunParam tells whether a mutating request should only be validated
+// and report what it would change.
+func getDryRunParam(r *http.Request) (bool, error) {
+	dryRunStr := r.URL.Query().Get(prmDryRun)
+	if dryRunStr == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(dryRunStr)
+	if err != nil {
+		return false, errors.Errorf("invalid %s: %s", prmDryRun, dryRunStr)
+	}
+	return dryRun, nil
+}
+
+// getShiftParams returns a shift of offsets relative to their current values
+// or partition ranges, or nil if none is requested. Offsets can be shifted
+// by a number of messages, or to begin, end or a percentage of partitions,
+// e.g. `shiftTo=50%`.
+func getShiftParams(r *http.Request) (admin.Shift, error) {
+	shiftByStr := r.URL.Query().Get(prmShiftBy)
+	shiftToStr := r.URL.Query().Get(prmShiftTo)
+	switch {
+	case shiftByStr != "" && shiftToStr != "":
+		return nil, errors.Errorf("%s and %s are mutually exclusive", prmShiftBy, prmShiftTo)
+	case shiftByStr != "":
+		n, err := strconv.ParseInt(shiftByStr, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s: %s", prmShiftBy, shiftByStr)
+		}
+		return admin.ShiftBy(n), nil
+	case shiftToStr == "begin":
+		return admin.ShiftToBegin(), nil
+	case shiftToStr == "end":
+		return admin.ShiftToEnd(), nil
+	case strings.HasSuffix(shiftToStr, "%"):
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(shiftToStr, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, errors.Errorf("invalid %s: %s", prmShiftTo, shiftToStr)
+		}
+		return admin.ShiftToPercent(percent), nil
+	case shiftToStr != "":
+		return nil, errors.Errorf("invalid %s: %s", prmShiftTo, shiftToStr)
+	}
+	return nil, nil
+}
+
 // toEncoderPreservingNil converts a slice of bytes to `sarama.Encoder` but
 // returns `nil` if the passed slice is `nil`.
 func toEncoderPreservingNil(b []byte) sarama.Encoder {
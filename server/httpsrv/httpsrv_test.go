@@ -0,0 +1,744 @@
+package httpsrv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/tap"
+	. "gopkg.in/check.v1"
+)
+
+type HTTPSrvSuite struct {
+	pxy *proxy.T
+}
+
+var _ = Suite(&HTTPSrvSuite{})
+
+func (s *HTTPSrvSuite) SetUpTest(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	var err error
+	s.pxy, err = proxy.Spawn(actor.RootID, "httpsrv", cfg)
+	c.Assert(err, IsNil)
+}
+
+func (s *HTTPSrvSuite) TearDownTest(c *C) {
+	s.pxy.Stop()
+}
+
+func (s *HTTPSrvSuite) start(c *C, opts server.Opts) (*T, string) {
+	hs, err := NewWithOpts("127.0.0.1:0", proxy.NewSet(map[string]*proxy.T{"default": s.pxy}, s.pxy), opts)
+	c.Assert(err, IsNil)
+	hs.Start()
+	return hs, "http://" + hs.listener.Addr().String()
+}
+
+func status(c *C, method, url string) int {
+	rq, err := http.NewRequest(method, url, strings.NewReader("foo"))
+	c.Assert(err, IsNil)
+	rs, err := http.DefaultClient.Do(rq)
+	c.Assert(err, IsNil)
+	rs.Body.Close()
+	return rs.StatusCode
+}
+
+// The data plane serves produce and consume requests only, and the admin
+// plane serves all the others. Probes are served by both.
+func (s *HTTPSrvSuite) TestPlanes(c *C) {
+	dataSrv, dataURL := s.start(c, server.Opts{Plane: server.PlaneData})
+	defer dataSrv.Stop()
+	adminSrv, adminURL := s.start(c, server.Opts{Plane: server.PlaneAdmin})
+	defer adminSrv.Stop()
+
+	for i, tc := range []struct {
+		method string
+		path   string
+		data   int
+		admin  int
+	}{
+		{"POST", "/topics/foo/messages?sync", http.StatusOK, http.StatusNotFound},
+		{"GET", "/topics", http.StatusNotFound, http.StatusOK},
+		{"GET", "/_sessions", http.StatusNotFound, http.StatusOK},
+		{"GET", "/_ping", http.StatusOK, http.StatusOK},
+	} {
+		comment := Commentf("case #%d", i)
+		c.Assert(status(c, tc.method, dataURL+tc.path), Equals, tc.data, comment)
+		c.Assert(status(c, tc.method, adminURL+tc.path), Equals, tc.admin, comment)
+	}
+}
+
+// Without a plane all requests are served.
+func (s *HTTPSrvSuite) TestNoPlane(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	c.Assert(status(c, "GET", url+"/topics"), Equals, http.StatusOK)
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?sync"), Equals, http.StatusOK)
+}
+
+// HTTP/2 without TLS is accepted only if h2c is enabled.
+func (s *HTTPSrvSuite) TestH2C(c *C) {
+	httpCfg := config.DefaultApp("default").HTTPServer
+	plainSrv, plainURL := s.start(c, server.Opts{HTTP: &httpCfg})
+	defer plainSrv.Stop()
+	httpCfg.H2C = true
+	h2cSrv, h2cURL := s.start(c, server.Opts{HTTP: &httpCfg})
+	defer h2cSrv.Stop()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	clt := http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+	// When
+	rs, err := clt.Get(h2cURL + "/_ping")
+	_, plainErr := clt.Get(plainURL + "/_ping")
+
+	// Then
+	c.Assert(err, IsNil)
+	rs.Body.Close()
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(rs.ProtoMajor, Equals, 2)
+	c.Assert(plainErr, NotNil)
+}
+
+// HTTP/2 is negotiated with TLS clients unless it is disabled.
+func (s *HTTPSrvSuite) TestHTTP2OverTLS(c *C) {
+	// Borrow a self-signed certificate from httptest.
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	tlsCfg := &tls.Config{Certificates: certSrv.TLS.Certificates}
+	roots := x509.NewCertPool()
+	roots.AddCert(certSrv.Certificate())
+	certSrv.Close()
+
+	httpCfg := config.DefaultApp("default").HTTPServer
+	h2Srv, h2URL := s.start(c, server.Opts{TLS: tlsCfg, HTTP: &httpCfg})
+	defer h2Srv.Stop()
+	httpCfg.HTTP2 = false
+	h1Srv, h1URL := s.start(c, server.Opts{TLS: tlsCfg, HTTP: &httpCfg})
+	defer h1Srv.Stop()
+
+	clt := http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "example.com"},
+		ForceAttemptHTTP2: true,
+	}}
+	for i, tc := range []struct {
+		url        string
+		protoMajor int
+	}{
+		{h2URL, 2},
+		{h1URL, 1},
+	} {
+		comment := Commentf("case #%d", i)
+
+		// When
+		rs, err := clt.Get(strings.Replace(tc.url, "http://", "https://", 1) + "/_ping")
+
+		// Then
+		c.Assert(err, IsNil, comment)
+		rs.Body.Close()
+		c.Assert(rs.StatusCode, Equals, http.StatusOK, comment)
+		c.Assert(rs.ProtoMajor, Equals, tc.protoMajor, comment)
+	}
+}
+
+// A consume request that asks for heartbeats gets new lines while it long
+// polls, and the actual status in the trailer.
+func (s *HTTPSrvSuite) TestConsumeHeartbeats(c *C) {
+	s.pxy.Stop()
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.Consumer.LongPollingTimeout = 300 * time.Millisecond
+	var err error
+	s.pxy, err = proxy.Spawn(actor.RootID, "httpsrv", cfg)
+	c.Assert(err, IsNil)
+	_, err = s.pxy.Produce("foo", nil, sarama.StringEncoder("bar"))
+	c.Assert(err, IsNil)
+	httpCfg := config.DefaultApp("default").HTTPServer
+	httpCfg.HeartbeatInterval = 50 * time.Millisecond
+	hs, url := s.start(c, server.Opts{HTTP: &httpCfg})
+	defer hs.Stop()
+
+	// When
+	rs, err := http.Get(url + "/topics/foo/messages?group=g1&heartbeat")
+
+	// Then
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(rs.Body)
+	c.Assert(err, IsNil)
+	rs.Body.Close()
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(rs.Trailer.Get(hdrStatus), Equals, "408")
+	c.Assert(strings.HasPrefix(string(body), "\n\n"), Equals, true, Commentf("%q", body))
+	var errRs errorHTTPResponse
+	c.Assert(json.Unmarshal(body, &errRs), IsNil)
+	c.Assert(errRs.Code, Equals, "LONG_POLLING_TIMEOUT")
+}
+
+// Sampled messages are streamed as server-sent events, or returned as a JSON
+// array to clients that do not accept them.
+func (s *HTTPSrvSuite) TestTap(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	c.Assert(status(c, "GET", url+"/_tap"), Equals, http.StatusNotFound)
+	hs.Stop()
+	s.pxy.Stop()
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.Tap.Rates = map[string]float64{"foo": 1}
+	var err error
+	s.pxy, err = proxy.Spawn(actor.RootID, "httpsrv", cfg)
+	c.Assert(err, IsNil)
+	hs, url = s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	// When
+	rq, err := http.NewRequest("GET", url+"/_tap?topic=foo", nil)
+	c.Assert(err, IsNil)
+	rq.Header.Set(hdrAccept, contentTypeEventStream)
+	rs, err := http.DefaultClient.Do(rq)
+	c.Assert(err, IsNil)
+	defer rs.Body.Close()
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	_, err = s.pxy.Produce("bar", nil, sarama.StringEncoder("skipped"))
+	c.Assert(err, IsNil)
+	_, err = s.pxy.Produce("foo", sarama.StringEncoder("k1"), sarama.StringEncoder("v1"))
+	c.Assert(err, IsNil)
+
+	// Then
+	buf := make([]byte, 4096)
+	var body string
+	for !strings.Contains(body, "\n\n") {
+		n, err := rs.Body.Read(buf)
+		c.Assert(err, IsNil)
+		body += string(buf[:n])
+	}
+	c.Assert(strings.HasPrefix(body, "event: message\ndata: "), Equals, true, Commentf("%q", body))
+	var msg tap.Message
+	c.Assert(json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(body), "event: message\ndata: ")), &msg), IsNil)
+	c.Assert(msg.Topic, Equals, "foo")
+	c.Assert(string(msg.Key), Equals, "k1")
+	c.Assert(string(msg.Value), Equals, "v1")
+	c.Assert(msg.Source, Equals, tap.SourceProduce)
+
+	// When
+	rs2, err := http.Get(url + "/_tap?timeout=100ms")
+
+	// Then
+	c.Assert(err, IsNil)
+	defer rs2.Body.Close()
+	c.Assert(rs2.StatusCode, Equals, http.StatusOK)
+	var msgs []tap.Message
+	c.Assert(json.NewDecoder(rs2.Body).Decode(&msgs), IsNil)
+	c.Assert(msgs, HasLen, 0)
+	c.Assert(status(c, "GET", url+"/_tap?limit=0"), Equals, http.StatusBadRequest)
+}
+
+// A dry run reports how many records would be deleted, but records cannot be
+// deleted with supported Kafka versions yet.
+func (s *HTTPSrvSuite) TestDeleteRecords(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	for i := 0; i < 3; i++ {
+		_, err := s.pxy.Produce("foo", nil, sarama.StringEncoder("bar"))
+		c.Assert(err, IsNil)
+	}
+	deleteRecords := func(topic, query, body string) (int, deleteRecordsView) {
+		rq, err := http.NewRequest("DELETE", url+"/topics/"+topic+"/records"+query, strings.NewReader(body))
+		c.Assert(err, IsNil)
+		rs, err := http.DefaultClient.Do(rq)
+		c.Assert(err, IsNil)
+		defer rs.Body.Close()
+		var view deleteRecordsView
+		json.NewDecoder(rs.Body).Decode(&view)
+		return rs.StatusCode, view
+	}
+
+	// When
+	code, view := deleteRecords("foo", "?dryRun=true", `[{"partition": 0, "offset": -1}]`)
+
+	// Then
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(view.DryRun, Equals, true)
+	c.Assert(view.Partitions, HasLen, 1)
+	c.Assert(view.Partitions[0].Offset, Equals, view.Partitions[0].End)
+	c.Assert(view.Deleted, Equals, view.Partitions[0].End)
+
+	// When
+	code, view = deleteRecords("foo", "?dryRun=true&before="+time.Now().Add(time.Hour).Format(time.RFC3339), "")
+
+	// Then
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(view.Deleted, Equals, int64(3))
+
+	code, _ = deleteRecords("foo", "?dryRun=true", `[{"partition": 0, "offset": 100}]`)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	code, _ = deleteRecords("foo", "?dryRun=true&before=yesterday", "")
+	c.Assert(code, Equals, http.StatusBadRequest)
+	code, _ = deleteRecords("foo", "", `[{"partition": 0, "offset": -1}]`)
+	c.Assert(code, Equals, http.StatusBadRequest)
+	code, _ = deleteRecords("unknown", "?dryRun=true", "[]")
+	c.Assert(code, Equals, http.StatusNotFound)
+}
+
+func (s *HTTPSrvSuite) TestQuotas(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	alterQuotas := func(query, body string) int {
+		rs, err := http.Post(url+"/quotas"+query, "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+	getQuotas := func(query string) []clientQuotasView {
+		rs, err := http.Get(url + "/quotas" + query)
+		c.Assert(err, IsNil)
+		defer rs.Body.Close()
+		c.Assert(rs.StatusCode, Equals, http.StatusOK)
+		var views []clientQuotasView
+		c.Assert(json.NewDecoder(rs.Body).Decode(&views), IsNil)
+		return views
+	}
+
+	// When
+	c.Assert(alterQuotas("?user=alice", `{"producer_byte_rate": 1024, "consumer_byte_rate": 2048}`), Equals, http.StatusOK)
+	c.Assert(alterQuotas("?user=alice", `{"consumer_byte_rate": null}`), Equals, http.StatusOK)
+	c.Assert(alterQuotas("?clientId=bar", `{"request_percentage": 50}`), Equals, http.StatusOK)
+
+	// Then
+	c.Assert(getQuotas(""), DeepEquals, []clientQuotasView{
+		{ClientID: "bar", Quotas: map[string]float64{"request_percentage": 50}},
+		{User: "alice", Quotas: map[string]float64{"producer_byte_rate": 1024}},
+	})
+	c.Assert(getQuotas("?user=alice"), HasLen, 1)
+	c.Assert(alterQuotas("", `{"producer_byte_rate": 1024}`), Equals, http.StatusBadRequest)
+	c.Assert(alterQuotas("?user=alice", `{"producer_byte_rate": -1}`), Equals, http.StatusBadRequest)
+	c.Assert(alterQuotas("?user=alice", `[]`), Equals, http.StatusBadRequest)
+}
+
+// Broker configs are read-only unless altering them is enabled.
+func (s *HTTPSrvSuite) TestBrokerConfig(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	alterConfig := func(broker, body string) int {
+		rs, err := http.Post(url+"/cluster/brokers/"+broker+"/config", "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+	c.Assert(alterConfig("0", `{"message.max.bytes": "2097152"}`), Equals, http.StatusForbidden)
+	hs.Stop()
+	s.pxy.Stop()
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.Admin.AlterBrokerConfigs = true
+	var err error
+	s.pxy, err = proxy.Spawn(actor.RootID, "httpsrv", cfg)
+	c.Assert(err, IsNil)
+	hs, url = s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	// When
+	c.Assert(alterConfig("0", `{"message.max.bytes": "2097152"}`), Equals, http.StatusOK)
+	rs, err := http.Get(url + "/cluster/brokers/0/config")
+
+	// Then
+	c.Assert(err, IsNil)
+	defer rs.Body.Close()
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	var views []brokerConfigView
+	c.Assert(json.NewDecoder(rs.Body).Decode(&views), IsNil)
+	found := false
+	for _, bcv := range views {
+		if bcv.Name == "message.max.bytes" {
+			c.Assert(bcv, DeepEquals, brokerConfigView{Name: "message.max.bytes", Value: "2097152", Source: "DYNAMIC_BROKER_CONFIG"})
+			found = true
+		}
+	}
+	c.Assert(found, Equals, true)
+	c.Assert(status(c, "GET", url+"/cluster/brokers/1/config"), Equals, http.StatusNotFound)
+	c.Assert(status(c, "GET", url+"/cluster/brokers/foo/config"), Equals, http.StatusBadRequest)
+	c.Assert(alterConfig("0", `[]`), Equals, http.StatusBadRequest)
+	c.Assert(alterConfig("0", `{"ssl.key.password": "secret"}`), Equals, http.StatusBadRequest)
+}
+
+// Nacks are applied to consumed messages, and nacks of unknown classes are
+// rejected.
+func (s *HTTPSrvSuite) TestNack(c *C) {
+	s.pxy.Stop()
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.Consumer.OffsetReset = config.OffsetResetEarliest
+	var err error
+	s.pxy, err = proxy.Spawn(actor.RootID, "httpsrv", cfg)
+	c.Assert(err, IsNil)
+	_, err = s.pxy.Produce("foo", nil, sarama.StringEncoder("bar"))
+	c.Assert(err, IsNil)
+	msg, err := s.pxy.Consume("g1", "foo", proxy.NoAck())
+	c.Assert(err, IsNil)
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	nack := func(class string) int {
+		body := fmt.Sprintf(`{"partition": %d, "offset": %d, "class": "%s", "reason": "oops"}`,
+			msg.Partition, msg.Offset, class)
+		rs, err := http.Post(url+"/topics/foo/consumers/g1/nacks", "application/json", strings.NewReader(body))
+		c.Assert(err, IsNil)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+
+	// When/Then
+	c.Assert(nack("retryable"), Equals, http.StatusOK)
+	c.Assert(nack("bogus"), Equals, http.StatusBadRequest)
+	c.Assert(nack("fatal"), Equals, http.StatusBadRequest)
+	retried, err := s.pxy.Consume("g1", "foo", proxy.NoAck())
+	c.Assert(err, IsNil)
+	c.Assert(retried.Offset, Equals, msg.Offset)
+}
+
+// spawnWithTenants replaces the proxy with one shared by the `acme` and the
+// `globex` tenants, that authenticate with tokens of the same names.
+func (s *HTTPSrvSuite) spawnWithTenants(c *C) {
+	s.pxy.Stop()
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.Tenants = map[string]*config.Tenant{
+		"acme":   {Tokens: []string{"acme"}, Prefix: "acme."},
+		"globex": {Tokens: []string{"globex"}, Prefix: "globex."},
+	}
+	cfg.OperatorTokens = []string{"operator"}
+	var err error
+	s.pxy, err = proxy.Spawn(actor.RootID, "httpsrv", cfg)
+	c.Assert(err, IsNil)
+}
+
+// authorized sends a request with a tenant token.
+func authorized(c *C, method, url, token, body string) *http.Response {
+	rq, err := http.NewRequest(method, url, strings.NewReader(body))
+	c.Assert(err, IsNil)
+	rq.Header.Set(hdrAuthorization, bearerPrefix+token)
+	rs, err := http.DefaultClient.Do(rq)
+	c.Assert(err, IsNil)
+	return rs
+}
+
+// Tenants only see copy jobs of their own topics, named as they name them.
+func (s *HTTPSrvSuite) TestCopiesTenants(c *C) {
+	s.spawnWithTenants(c)
+	_, err := s.pxy.Produce("acme.foo", nil, sarama.StringEncoder("bar"))
+	c.Assert(err, IsNil)
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	rs := authorized(c, "POST", url+"/_copies", "acme", `{"topic": "foo", "to_topic": "bar"}`)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	var started copyView
+	c.Assert(json.NewDecoder(rs.Body).Decode(&started), IsNil)
+	rs.Body.Close()
+
+	// When
+	acmeRs := authorized(c, "GET", url+"/_copies", "acme", "")
+	globexRs := authorized(c, "GET", url+"/_copies", "globex", "")
+
+	// Then
+	var acmeViews, globexViews []copyView
+	c.Assert(json.NewDecoder(acmeRs.Body).Decode(&acmeViews), IsNil)
+	acmeRs.Body.Close()
+	c.Assert(json.NewDecoder(globexRs.Body).Decode(&globexViews), IsNil)
+	globexRs.Body.Close()
+	c.Assert(acmeViews, HasLen, 1)
+	c.Assert(acmeViews[0].ID, Equals, started.ID)
+	c.Assert(acmeViews[0].Topic, Equals, "foo")
+	c.Assert(acmeViews[0].ToTopic, Equals, "bar")
+	c.Assert(globexViews, HasLen, 0)
+
+	rs = authorized(c, "GET", url+"/_copies/"+started.ID, "acme", "")
+	rs.Body.Close()
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	rs = authorized(c, "GET", url+"/_copies/"+started.ID, "globex", "")
+	rs.Body.Close()
+	c.Assert(rs.StatusCode, Equals, http.StatusNotFound)
+	c.Assert(status(c, "GET", url+"/_copies"), Equals, http.StatusUnauthorized)
+}
+
+// Sessions, proxy stats and fault injection span all tenants, so they are not
+// available to tenants, but they are to operators.
+func (s *HTTPSrvSuite) TestOperatorEndpointsTenants(c *C) {
+	s.spawnWithTenants(c)
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	for i, tc := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/_sessions", http.StatusOK},
+		{"GET", "/_sessions/slow", http.StatusOK},
+		{"DELETE", "/_sessions/foo", http.StatusNotFound},
+		{"GET", "/_tenants", http.StatusOK},
+		{"GET", "/_admin/cache", http.StatusOK},
+		{"DELETE", "/_admin/cache", http.StatusOK},
+		{"GET", "/_producer/metadata", http.StatusOK},
+		{"GET", "/_consumer/sizes", http.StatusOK},
+		{"GET", "/_alerts", http.StatusOK},
+		{"GET", "/_metrics", http.StatusOK},
+		{"GET", "/_failover", http.StatusNotFound},
+		// Fault injection is disabled in the config.
+		{"GET", "/_faults", http.StatusBadRequest},
+		{"POST", "/_faults/produce", http.StatusBadRequest},
+		{"DELETE", "/_faults", http.StatusBadRequest},
+		{"POST", "/_faults/rebalance", http.StatusBadRequest},
+	} {
+		comment := Commentf("case #%d", i)
+		rs := authorized(c, tc.method, url+tc.path, "acme", "")
+		rs.Body.Close()
+		c.Assert(rs.StatusCode, Equals, http.StatusForbidden, comment)
+		c.Assert(status(c, tc.method, url+tc.path), Equals, http.StatusUnauthorized, comment)
+		rs = authorized(c, tc.method, url+tc.path, "operator", "")
+		rs.Body.Close()
+		c.Assert(rs.StatusCode, Equals, tc.status, comment)
+	}
+}
+
+// Proxies span all tenants, so they cannot be listed, registered or
+// deregistered by tenants.
+func (s *HTTPSrvSuite) TestProxyRegistrationTenants(c *C) {
+	s.spawnWithTenants(c)
+	hs, url := s.start(c, server.Opts{ProxyRegistration: true})
+	defer hs.Stop()
+
+	for i, tc := range []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/_proxies"},
+		{"POST", "/_proxies?cluster=foo"},
+		{"DELETE", "/_proxies/foo"},
+	} {
+		comment := Commentf("case #%d", i)
+		rs := authorized(c, tc.method, url+tc.path, "acme", "in_memory: {enabled: true}")
+		rs.Body.Close()
+		c.Assert(rs.StatusCode, Equals, http.StatusForbidden, comment)
+		c.Assert(status(c, tc.method, url+tc.path), Equals, http.StatusUnauthorized, comment)
+	}
+}
+
+// HTTP/1.1 responses tell how long idle connections are kept, if enabled.
+func (s *HTTPSrvSuite) TestKeepAliveHeader(c *C) {
+	httpCfg := config.DefaultApp("default").HTTPServer
+	httpCfg.KeepAliveHeader = true
+	hs, url := s.start(c, server.Opts{HTTP: &httpCfg})
+	defer hs.Stop()
+
+	// When
+	rs, err := http.Get(url + "/_ping")
+
+	// Then
+	c.Assert(err, IsNil)
+	rs.Body.Close()
+	c.Assert(rs.Header.Get(hdrKeepAlive), Equals, "timeout=120")
+}
+
+// The acknowledgement level that a message was produced with is reported,
+// and levels that are not allowed are rejected.
+func (s *HTTPSrvSuite) TestProduceAcks(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	// When
+	rs, err := http.Post(url+"/topics/foo/messages?sync", "text/plain", strings.NewReader("bar"))
+	c.Assert(err, IsNil)
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(rs.Body).Decode(&body), IsNil)
+	rs.Body.Close()
+
+	// Then
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(rs.Header.Get(hdrAcks), Equals, proxy.AcksAll)
+	c.Assert(body["acks"], Equals, proxy.AcksAll)
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?acks=all"), Equals, http.StatusOK)
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?acks=none"), Equals, http.StatusBadRequest)
+}
+
+// A snapshot is streamed as NDJSON, even if it is empty.
+func (s *HTTPSrvSuite) TestSnapshot(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	_, err := s.pxy.Produce("foo", sarama.StringEncoder("a"), sarama.StringEncoder("1"))
+	c.Assert(err, IsNil)
+	_, err = s.pxy.Produce("foo", sarama.StringEncoder("a"), sarama.StringEncoder("2"))
+	c.Assert(err, IsNil)
+
+	// When
+	rs, err := http.Get(url + "/topics/foo/snapshot")
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	emptyRs, err := http.Get(url + "/topics/foo/snapshot?prefix=b")
+	c.Assert(err, IsNil)
+	emptyBody, err := ioutil.ReadAll(emptyRs.Body)
+	emptyRs.Body.Close()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(rs.Header.Get(hdrContentType), Equals, contentTypeNDJSON)
+	c.Assert(string(body), Equals, `{"key":"YQ==","value":"Mg==","partition":0,"offset":1}`+"\n")
+	c.Assert(emptyRs.StatusCode, Equals, http.StatusOK)
+	c.Assert(emptyRs.Header.Get(hdrContentType), Equals, contentTypeNDJSON)
+	c.Assert(string(emptyBody), Equals, "")
+}
+
+// Keys of indexed topics can be looked up.
+func (s *HTTPSrvSuite) TestLookupKey(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.InMemory.Enabled = true
+	cfg.InMemory.Topics = map[string]int{"foo": 1}
+	cfg.KeyIndex.Topics = []string{"foo"}
+	cfg.KeyIndex.RefreshInterval = 10 * time.Millisecond
+	pxy, err := proxy.Spawn(actor.RootID, "lookup", cfg)
+	c.Assert(err, IsNil)
+	defer pxy.Stop()
+	_, err = pxy.Produce("foo", sarama.StringEncoder("a/b"), sarama.StringEncoder("1"))
+	c.Assert(err, IsNil)
+	hs, err := NewWithOpts("127.0.0.1:0", proxy.NewSet(map[string]*proxy.T{"default": pxy}, pxy), server.Opts{})
+	c.Assert(err, IsNil)
+	hs.Start()
+	defer hs.Stop()
+	url := "http://" + hs.listener.Addr().String()
+
+	// When
+	var rs *http.Response
+	for i := 0; i < 100; i++ {
+		rs, err = http.Get(url + "/topics/foo/keys/a/b")
+		c.Assert(err, IsNil)
+		if rs.StatusCode != http.StatusServiceUnavailable {
+			break
+		}
+		rs.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(rs.Body).Decode(&body), IsNil)
+	rs.Body.Close()
+
+	// Then
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, DeepEquals, map[string]interface{}{
+		"key": "YS9i", "value": "MQ==", "partition": 0.0, "offset": 0.0,
+	})
+	c.Assert(status(c, "GET", url+"/topics/foo/keys/c"), Equals, http.StatusNotFound)
+	c.Assert(status(c, "GET", url+"/topics/bar/keys/a"), Equals, http.StatusNotFound)
+}
+
+// A DELETE request produces a tombstone for a key, that removes the key from
+// snapshots of the topic.
+// Messages produced in memory are committed right away, so there is never
+// anything to flush.
+func (s *HTTPSrvSuite) TestFlush(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	_, err := s.pxy.Produce("foo", sarama.StringEncoder("a"), sarama.StringEncoder("1"))
+	c.Assert(err, IsNil)
+
+	// When
+	rs, err := http.Post(url+"/_flush?timeout=1s", "text/plain", nil)
+	c.Assert(err, IsNil)
+	var view flushView
+	err = json.NewDecoder(rs.Body).Decode(&view)
+	rs.Body.Close()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(view, Equals, flushView{})
+	c.Assert(status(c, "POST", url+"/_flush?timeout=never"), Equals, http.StatusBadRequest)
+}
+
+// Proxies are ready once they have warmed up.
+func (s *HTTPSrvSuite) TestReady(c *C) {
+	hs, url := s.start(c, server.Opts{Plane: server.PlaneData})
+	defer hs.Stop()
+	for deadline := time.Now().Add(3 * time.Second); !s.pxy.Ready() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// When/Then
+	c.Assert(status(c, "GET", url+"/_ready"), Equals, http.StatusOK)
+	c.Assert(status(c, "GET", url+"/clusters/default/_ready"), Equals, http.StatusOK)
+	c.Assert(status(c, "GET", url+"/clusters/bar/_ready"), Equals, http.StatusBadRequest)
+}
+
+// Start and stop requests respond with the consumer status, and a bad offset
+// reset policy is rejected.
+func (s *HTTPSrvSuite) TestConsumerLifecycle(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	for _, op := range []string{"start", "stop"} {
+		// When
+		rs, err := http.Post(url+"/topics/foo/consumers/bar/"+op, "text/plain", nil)
+		c.Assert(err, IsNil)
+		var view consumerStatusView
+		err = json.NewDecoder(rs.Body).Decode(&view)
+		rs.Body.Close()
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(rs.StatusCode, Equals, http.StatusOK)
+		c.Assert(view, Equals, consumerStatusView{Group: "bar", Topic: "foo", Running: op == "start"})
+	}
+	c.Assert(status(c, "POST", url+"/topics/foo/consumers/bar/start?offsetReset=never"), Equals, http.StatusBadRequest)
+}
+
+func (s *HTTPSrvSuite) TestProduceTombstone(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+	_, err := s.pxy.Produce("foo", sarama.StringEncoder("a"), sarama.StringEncoder("1"))
+	c.Assert(err, IsNil)
+
+	// When
+	deleteStatus := status(c, "DELETE", url+"/topics/foo/messages?key=a&sync")
+
+	// Then
+	c.Assert(deleteStatus, Equals, http.StatusOK)
+	rs, err := http.Get(url + "/topics/foo/snapshot")
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "")
+	c.Assert(status(c, "DELETE", url+"/topics/foo/messages?sync"), Equals, http.StatusBadRequest)
+}
+
+// Outcomes can be reported for immediate async requests only, to allowed
+// receivers, and an ID is required.
+func (s *HTTPSrvSuite) TestProduceOutcome(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	// When/Then
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?resultTopic=results&id=m1"), Equals, http.StatusOK)
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?resultTopic=results"), Equals, http.StatusBadRequest)
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?callback=http://example.com&id=m1"), Equals, http.StatusBadRequest)
+	c.Assert(status(c, "POST", url+"/topics/foo/messages?resultTopic=results&id=m1&sync"), Equals, http.StatusBadRequest)
+}
+
+// Failover status is only available for proxies with a standby cluster.
+func (s *HTTPSrvSuite) TestFailoverDisabled(c *C) {
+	hs, url := s.start(c, server.Opts{})
+	defer hs.Stop()
+
+	// When/Then
+	c.Assert(status(c, "GET", url+"/_failover"), Equals, http.StatusNotFound)
+	c.Assert(status(c, "GET", url+"/clusters/default/_failover"), Equals, http.StatusNotFound)
+	c.Assert(status(c, "GET", url+"/clusters/bar/_failover"), Equals, http.StatusBadRequest)
+}
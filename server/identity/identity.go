@@ -0,0 +1,187 @@
+package identity
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	hdrAuthorization = "Authorization"
+	mdAuthorization  = "authorization"
+	bearerPrefix     = "Bearer "
+
+	// Rate limit buckets of clients that have not made requests for that
+	// long are forgotten.
+	idleBucketTTL = time.Minute
+)
+
+var ErrRateLimited = errors.New("client request rate limit exceeded")
+
+// T identifies API clients and limits the rate of their requests. It is safe
+// for concurrent use. A nil instance is valid, it takes identities from the
+// `X-Kafka-Pixy-Client-ID` header and does not limit requests.
+type T struct {
+	cfg        config.ClientIdentity
+	ratePerSec float64
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	lastPruned time.Time
+}
+
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// request holds everything about a request that an identity can come from.
+type request struct {
+	header        func(name string) string
+	authorization string
+	tlsState      *tls.ConnectionState
+	remoteAddr    string
+}
+
+// New creates a client identity resolver as the config prescribes.
+func New(cfg config.ClientIdentity) *T {
+	return &T{
+		cfg:        cfg,
+		ratePerSec: float64(cfg.RequestsPerSecond),
+		buckets:    make(map[string]*bucket),
+	}
+}
+
+// FromHTTP returns the identity of the client that made an HTTP request, or
+// an empty string if none of the configured sources yields one.
+func (t *T) FromHTTP(r *http.Request) string {
+	return t.resolve(&request{
+		header:        r.Header.Get,
+		authorization: r.Header.Get(hdrAuthorization),
+		tlsState:      r.TLS,
+		remoteAddr:    r.RemoteAddr,
+	})
+}
+
+// FromGRPC is like FromHTTP but for the context of a gRPC request.
+func (t *T) FromGRPC(ctx context.Context) string {
+	md, _ := metadata.FromContext(ctx)
+	req := &request{
+		header: func(name string) string {
+			if values := md[strings.ToLower(name)]; len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		},
+	}
+	req.authorization = req.header(mdAuthorization)
+	if p, ok := peer.FromContext(ctx); ok {
+		req.remoteAddr = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.tlsState = &tlsInfo.State
+		}
+	}
+	return t.resolve(req)
+}
+
+// Admit should be called for every request of a client. It returns
+// ErrRateLimited if the request should be rejected. Requests of clients
+// without an identity are always admitted.
+func (t *T) Admit(identity string) error {
+	if t == nil || t.ratePerSec <= 0 || identity == "" {
+		return nil
+	}
+	return t.admit(identity, time.Now())
+}
+
+func (t *T) admit(identity string, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.lastPruned) >= idleBucketTTL {
+		for id, b := range t.buckets {
+			if now.Sub(b.lastRefill) >= idleBucketTTL {
+				delete(t.buckets, id)
+			}
+		}
+		t.lastPruned = now
+	}
+	b := t.buckets[identity]
+	if b == nil {
+		b = &bucket{tokens: t.ratePerSec, lastRefill: now}
+		t.buckets[identity] = b
+	}
+	b.tokens += now.Sub(b.lastRefill).Seconds() * t.ratePerSec
+	if b.tokens > t.ratePerSec {
+		b.tokens = t.ratePerSec
+	}
+	b.lastRefill = now
+	if b.tokens < 1 {
+		return ErrRateLimited
+	}
+	b.tokens--
+	return nil
+}
+
+func (t *T) resolve(req *request) string {
+	if t == nil {
+		return req.header("X-Kafka-Pixy-Client-ID")
+	}
+	for _, source := range t.cfg.Sources {
+		var identity string
+		switch source {
+		case config.IdentityHeader:
+			identity = req.header(t.cfg.Header)
+		case config.IdentityJWTSubject:
+			identity = jwtSubject(req.authorization)
+		case config.IdentityTLSCN:
+			if req.tlsState != nil && len(req.tlsState.PeerCertificates) > 0 {
+				identity = req.tlsState.PeerCertificates[0].Subject.CommonName
+			}
+		case config.IdentityRemoteIP:
+			identity = req.remoteAddr
+			if host, _, err := net.SplitHostPort(req.remoteAddr); err == nil {
+				identity = host
+			}
+		}
+		if identity != "" {
+			return identity
+		}
+	}
+	return ""
+}
+
+// jwtSubject returns the `sub` claim of a JWT bearer token, or an empty
+// string if the authorization value is not one. The token signature is not
+// verified, so the subject can only be trusted if tokens are verified before
+// requests reach Kafka-Pixy.
+func jwtSubject(authorization string) string {
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return ""
+	}
+	parts := strings.Split(authorization[len(bearerPrefix):], ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
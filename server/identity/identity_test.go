@@ -0,0 +1,122 @@
+package identity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type IdentitySuite struct{}
+
+var _ = Suite(&IdentitySuite{})
+
+func jwt(payload string) string {
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+}
+
+func newRequest(headers map[string]string) *http.Request {
+	r, _ := http.NewRequest("GET", "http://localhost/topics/foo/messages", nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	r.RemoteAddr = "10.0.0.1:51234"
+	return r
+}
+
+// Sources are tried in the configured order until one yields an identity.
+func (s *IdentitySuite) TestFromHTTP(c *C) {
+	t := New(config.ClientIdentity{
+		Sources: []string{config.IdentityHeader, config.IdentityJWTSubject, config.IdentityTLSCN, config.IdentityRemoteIP},
+		Header:  "X-Client-ID",
+	})
+	tlsReq := newRequest(nil)
+	tlsReq.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "cn1"}}}}
+
+	for i, tc := range []struct {
+		r        *http.Request
+		identity string
+	}{
+		{r: newRequest(map[string]string{"X-Client-ID": "c1", "Authorization": jwt(`{"sub":"s1"}`)}), identity: "c1"},
+		{r: newRequest(map[string]string{"Authorization": jwt(`{"sub":"s1"}`)}), identity: "s1"},
+		{r: newRequest(map[string]string{"Authorization": "Bearer opaque"}), identity: "10.0.0.1"},
+		{r: newRequest(map[string]string{"Authorization": jwt(`{"iss":"i1"}`)}), identity: "10.0.0.1"},
+		{r: tlsReq, identity: "cn1"},
+	} {
+		c.Assert(t.FromHTTP(tc.r), Equals, tc.identity, Commentf("case #%d", i))
+	}
+}
+
+func (s *IdentitySuite) TestFromGRPC(c *C) {
+	t := New(config.ClientIdentity{
+		Sources: []string{config.IdentityHeader, config.IdentityTLSCN, config.IdentityRemoteIP},
+		Header:  "X-Client-ID",
+	})
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 51234}
+	tlsInfo := credentials.TLSInfo{State: tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "cn1"}}},
+	}}
+
+	// When/Then
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs("x-client-id", "c1"))
+	c.Assert(t.FromGRPC(ctx), Equals, "c1")
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: addr, AuthInfo: tlsInfo})
+	c.Assert(t.FromGRPC(ctx), Equals, "cn1")
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	c.Assert(t.FromGRPC(ctx), Equals, "10.0.0.2")
+	c.Assert(t.FromGRPC(context.Background()), Equals, "")
+}
+
+// Every client has a rate limit of its own.
+func (s *IdentitySuite) TestAdmit(c *C) {
+	t := New(config.ClientIdentity{Sources: []string{config.IdentityHeader}, Header: "X-Client-ID", RequestsPerSecond: 2})
+	now := time.Now()
+
+	// When/Then
+	c.Assert(t.admit("c1", now), IsNil)
+	c.Assert(t.admit("c1", now), IsNil)
+	c.Assert(t.admit("c1", now), Equals, ErrRateLimited)
+	c.Assert(t.admit("c2", now), IsNil)
+	c.Assert(t.admit("c1", now.Add(500*time.Millisecond)), IsNil)
+	c.Assert(t.admit("c1", now.Add(500*time.Millisecond)), Equals, ErrRateLimited)
+	c.Assert(t.Admit(""), IsNil)
+}
+
+// Buckets of idle clients are forgotten.
+func (s *IdentitySuite) TestPrune(c *C) {
+	t := New(config.ClientIdentity{Sources: []string{config.IdentityHeader}, Header: "X-Client-ID", RequestsPerSecond: 1})
+	now := time.Now()
+	t.admit("c1", now)
+	t.admit("c2", now.Add(30*time.Second))
+
+	// When
+	t.admit("c2", now.Add(idleBucketTTL+time.Second))
+
+	// Then
+	c.Assert(len(t.buckets), Equals, 1)
+	c.Assert(t.buckets["c2"], NotNil)
+}
+
+// A nil instance takes identities from the default header and does not limit
+// requests.
+func (s *IdentitySuite) TestNil(c *C) {
+	var t *T
+	r := newRequest(map[string]string{"X-Kafka-Pixy-Client-ID": "c1"})
+	c.Assert(t.FromHTTP(r), Equals, "c1")
+	c.Assert(t.Admit("c1"), IsNil)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"runtime/debug"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/log"
+)
+
+// Incident describes a panic that occurred while an API request was handled.
+type Incident struct {
+	// Unique ID returned to the client, so that the incident can be found in
+	// logs and in the error tracking service.
+	ID string
+
+	// API that the request came from: http or grpc.
+	API string
+
+	// Request that caused the panic, e.g. `GET /topics/foo/messages` or
+	// `/KafkaPixy/Produce`, and its ID.
+	Op        string
+	RequestID string
+
+	Panic interface{}
+	Stack []byte
+}
+
+// PanicReporter is implemented by error tracking services, e.g. Sentry, that
+// incidents should be reported to.
+type PanicReporter interface {
+	ReportPanic(incident *Incident)
+}
+
+// HandlePanic is called by API servers when a request handler panics. The
+// incident should have API, Op, RequestID and Panic fields set, the rest is
+// filled in. The incident is logged, counted in the `api.panics` metric of
+// `registry`, and reported to `reporter` if one is given.
+func HandlePanic(actorID *actor.ID, registry *metrics.Registry, reporter PanicReporter, incident *Incident) *Incident {
+	incident.ID = NewID()
+	incident.Stack = debug.Stack()
+	log.Errorf("<%s> request handler paniced: incident=%s, op=%s, requestID=%s, panic=%v, stack=%s",
+		actorID, incident.ID, incident.Op, incident.RequestID, incident.Panic, incident.Stack)
+	registry.Counter("api.panics", "api", incident.API).Inc(1)
+	if reporter != nil {
+		reporter.ReportPanic(incident)
+	}
+	return incident
+}
+
+// NewID returns a random ID to identify incidents and requests by.
+func NewID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/metrics"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type IncidentSuite struct{}
+
+var _ = Suite(&IncidentSuite{})
+
+type testReporter struct {
+	incidents []*Incident
+}
+
+func (r *testReporter) ReportPanic(incident *Incident) {
+	r.incidents = append(r.incidents, incident)
+}
+
+// Panics are counted and reported with unique incident IDs.
+func (s *IncidentSuite) TestHandlePanic(c *C) {
+	registry := metrics.New()
+	reporter := &testReporter{}
+
+	// When
+	incident1 := HandlePanic(actor.RootID, registry, reporter, &Incident{API: "http", Op: "GET /topics", Panic: "kaboom"})
+	incident2 := HandlePanic(actor.RootID, registry, reporter, &Incident{API: "http", Op: "GET /topics", Panic: "kaboom"})
+
+	// Then
+	c.Assert(reporter.incidents, DeepEquals, []*Incident{incident1, incident2})
+	c.Assert(incident1.ID, Matches, "[0-9a-f]{16}")
+	c.Assert(incident1.ID, Not(Equals), incident2.ID)
+	c.Assert(incident1.Panic, Equals, "kaboom")
+	c.Assert(incident1.Op, Equals, "GET /topics")
+	c.Assert(string(incident1.Stack), Matches, "(?s).*TestHandlePanic.*")
+	c.Assert(registry.Counter("api.panics", "api", "http").Count(), Equals, int64(2))
+}
+
+// The reporter is optional.
+func (s *IncidentSuite) TestHandlePanicNoReporter(c *C) {
+	incident := HandlePanic(actor.RootID, nil, nil, &Incident{API: "grpc", Op: "/KafkaPixy/Produce", Panic: "kaboom"})
+	c.Assert(incident.API, Equals, "grpc")
+}
@@ -0,0 +1,108 @@
+package netpolicy
+
+import (
+	"net"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// ErrAddrNotAllowed is returned when a client may not make a request from
+// its IP address.
+var ErrAddrNotAllowed = errors.New("client address not allowed")
+
+// T decides whether API clients may make requests from their IP addresses.
+// It is safe for concurrent use. A nil instance allows all requests.
+type T struct {
+	// Filters by operation class, see server.OpXXX. Filters that apply to
+	// all requests are under the empty class.
+	filters map[string][]filter
+}
+
+type filter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// New creates a network policy checker that only allows requests allowed by
+// all of `policies`.
+func New(policies ...config.NetworkPolicy) (*T, error) {
+	t := &T{filters: make(map[string][]filter)}
+	for _, policy := range policies {
+		for op, cfg := range map[string]config.NetworkFilter{
+			"":        policy.Any,
+			"produce": policy.Produce,
+			"consume": policy.Consume,
+			"admin":   policy.Admin,
+		} {
+			if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+				continue
+			}
+			var f filter
+			var err error
+			if f.allow, err = parseNetworks(cfg.Allow); err != nil {
+				return nil, err
+			}
+			if f.deny, err = parseNetworks(cfg.Deny); err != nil {
+				return nil, err
+			}
+			t.filters[op] = append(t.filters[op], f)
+		}
+	}
+	return t, nil
+}
+
+// Check returns ErrAddrNotAllowed if a client at `remoteAddr` may not make a
+// request of operation class `op`. An empty class checks against filters
+// that apply to all requests only. Clients with no IP address, e.g. those
+// connected via a Unix domain socket, are rejected by allow lists.
+func (t *T) Check(op, remoteAddr string) error {
+	if t == nil || len(t.filters[op]) == 0 {
+		return nil
+	}
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	for _, f := range t.filters[op] {
+		if !f.allows(ip) {
+			if op == "" {
+				return errors.Wrapf(ErrAddrNotAllowed, "addr=%s", host)
+			}
+			return errors.Wrapf(ErrAddrNotAllowed, "addr=%s, op=%s", host, op)
+		}
+	}
+	return nil
+}
+
+func (f *filter) allows(ip net.IP) bool {
+	if len(f.allow) > 0 && !contains(f.allow, ip) {
+		return false
+	}
+	return !contains(f.deny, ip)
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		ipNet, err := config.ParseNetwork(network)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad network: %s", network)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
@@ -0,0 +1,74 @@
+package netpolicy
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type NetPolicySuite struct{}
+
+var _ = Suite(&NetPolicySuite{})
+
+// A nil policy allows all requests.
+func (s *NetPolicySuite) TestNil(c *C) {
+	var t *T
+	c.Assert(t.Check("", "10.0.0.1:5000"), IsNil)
+	c.Assert(t.Check("admin", "10.0.0.1:5000"), IsNil)
+}
+
+// Filters of an operation class apply to requests of the class only, and a
+// deny list takes precedence over an allow list.
+func (s *NetPolicySuite) TestCheck(c *C) {
+	var policy config.NetworkPolicy
+	policy.Any.Deny = []string{"192.168.0.66"}
+	policy.Admin.Allow = []string{"10.1.0.0/16", "fd00::/8"}
+	policy.Admin.Deny = []string{"10.1.2.0/24"}
+	t, err := New(policy)
+	c.Assert(err, IsNil)
+
+	for i, tc := range []struct {
+		op      string
+		addr    string
+		allowed bool
+	}{
+		{"", "192.168.0.1:5000", true},
+		{"", "192.168.0.66:5000", false},
+		{"admin", "192.168.0.1:5000", false},
+		{"admin", "10.1.0.7:5000", true},
+		{"admin", "10.1.2.7:5000", false},
+		{"admin", "[fd00::1]:5000", true},
+		{"admin", "@", false},
+		{"produce", "192.168.0.1:5000", true},
+	} {
+		err := t.Check(tc.op, tc.addr)
+		if tc.allowed {
+			c.Assert(err, IsNil, Commentf("case #%d", i))
+		} else {
+			c.Assert(errors.Cause(err), Equals, ErrAddrNotAllowed, Commentf("case #%d", i))
+		}
+	}
+}
+
+// Requests must be allowed by all policies.
+func (s *NetPolicySuite) TestCheckAll(c *C) {
+	var all, listener config.NetworkPolicy
+	all.Consume.Allow = []string{"10.0.0.0/8"}
+	listener.Consume.Deny = []string{"10.2.0.0/16"}
+	t, err := New(all, listener)
+	c.Assert(err, IsNil)
+
+	// When
+	allowedErr := t.Check("consume", "10.1.0.1:5000")
+	deniedErr := t.Check("consume", "10.2.0.1:5000")
+
+	// Then
+	c.Assert(allowedErr, IsNil)
+	c.Assert(deniedErr, ErrorMatches, "addr=10.2.0.1, op=consume: client address not allowed")
+}
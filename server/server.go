@@ -1,5 +1,144 @@
 package server
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/server/accesslog"
+	"github.com/mailgun/kafka-pixy/server/identity"
+	"github.com/mailgun/kafka-pixy/server/netpolicy"
+	"github.com/mailgun/kafka-pixy/server/sessions"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HTTP header and gRPC metadata key of request IDs.
+	HdrRequestID = "X-Request-ID"
+	MDRequestID  = "x-request-id"
+
+	maxRequestIDLength = 128
+
+	// Planes that HTTP API servers can serve, see Opts.Plane.
+	PlaneData  = "data"
+	PlaneAdmin = "admin"
+
+	// Classes of operations that listener modes and network policies
+	// restrict. Listener modes do not restrict admin operations.
+	OpProduce = "produce"
+	OpConsume = "consume"
+	OpAdmin   = "admin"
+)
+
+// ErrOpNotAllowed is returned when an operation is rejected by the mode of
+// the listener that received it, see config.ListenerModes.
+var ErrOpNotAllowed = errors.New("operation not allowed on this listener")
+
+// ErrResponseTooLarge is returned when a gRPC response exceeds the maximum
+// message size of the server, see config.GRPCServer.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// Opts are optional parameters of API servers.
+type Opts struct {
+	// Panics in request handlers are reported to it, if given.
+	PanicReporter PanicReporter
+
+	// All requests are logged to it, if given.
+	AccessLog *accesslog.T
+
+	// Tells what HTTP API client sessions are slow and what to do about
+	// them. If not given, then sessions are never considered slow.
+	SlowConsumers config.SlowConsumers
+
+	// Identifies clients and limits the rate of their requests. If not
+	// given, then clients are identified by the `X-Kafka-Pixy-Client-ID`
+	// header and are not rate limited.
+	Identity *identity.T
+
+	// If given, then TCP API servers accept TLS connections only.
+	TLS *tls.Config
+
+	// Timeouts, keep-alives and HTTP/2 settings of HTTP API servers. If not
+	// given, then the net/http defaults are used.
+	HTTP *config.HTTPServer
+
+	// Message size and concurrency limits of gRPC API servers. If not given,
+	// then requests are limited to 1MB and nothing else is limited.
+	GRPC *config.GRPCServer
+
+	// Whether proxies can be registered and deregistered via the HTTP API.
+	ProxyRegistration bool
+
+	// Mode of the listener, one of config.ModeXXX. Empty means that all
+	// operations are accepted.
+	Mode string
+
+	// IP networks that clients may make requests from. If not given, then
+	// requests from all addresses are accepted.
+	NetPolicy *netpolicy.T
+
+	// Requests that an HTTP API server serves: PlaneData for produce and
+	// consume requests, PlaneAdmin for all the others, or empty for all
+	// requests. Probes, `/_ping`, `/_health` and `/_ready`, are served on both
+	// planes.
+	Plane string
+
+	// HTTP API client sessions. It is shared by HTTP API servers, so that
+	// the admin plane can report and evict sessions of the data plane. If
+	// not given, then every server keeps its own sessions.
+	Sessions *sessions.T
+}
+
+// CheckMode returns ErrOpNotAllowed if operations of class `op` are not
+// accepted by a listener in `mode`.
+func CheckMode(mode, op string) error {
+	if mode == config.ModeConsumeOnly && op == OpProduce || mode == config.ModeProduceOnly && op == OpConsume {
+		return errors.Wrapf(ErrOpNotAllowed, "mode=%s, op=%s", mode, op)
+	}
+	return nil
+}
+
+// NewTLSConfig creates a TLS config of API servers as `cfg` prescribes. If
+// TLS is disabled then nil is returned.
+func NewTLSConfig(cfg config.TLS) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load certificate")
+	}
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if cfg.ClientCAFile == "" {
+		return tlsCfg, nil
+	}
+	caPEM, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read client CA file")
+	}
+	tlsCfg.ClientCAs = x509.NewCertPool()
+	if !tlsCfg.ClientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.Errorf("no certificates in client CA file, path=%s", cfg.ClientCAFile)
+	}
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsCfg, nil
+}
+
+// RequestID returns a request ID provided by a client if it is valid,
+// otherwise a new one is generated.
+func RequestID(provided string) string {
+	if provided == "" || len(provided) > maxRequestIDLength {
+		return NewID()
+	}
+	for _, r := range provided {
+		if r < 0x21 || r > 0x7e {
+			return NewID()
+		}
+	}
+	return provided
+}
+
 // T represents a server usually based on http.Server.
 type T interface {
 
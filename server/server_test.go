@@ -0,0 +1,40 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type ServerSuite struct{}
+
+var _ = Suite(&ServerSuite{})
+
+// Request IDs provided by clients are used as is.
+func (s *ServerSuite) TestRequestIDProvided(c *C) {
+	c.Assert(RequestID("3f2a-9c0d"), Equals, "3f2a-9c0d")
+}
+
+// If a client provides no request ID or an invalid one, then a new one is
+// generated.
+func (s *ServerSuite) TestRequestIDGenerated(c *C) {
+	for i, provided := range []string{"", "foo bar", "foo\nbar", strings.Repeat("x", maxRequestIDLength+1)} {
+		c.Assert(RequestID(provided), Matches, "[0-9a-f]{16}", Commentf("case #%d", i))
+	}
+}
+
+// A listener mode rejects the other class of operations only.
+func (s *ServerSuite) TestCheckMode(c *C) {
+	c.Assert(CheckMode("", OpProduce), IsNil)
+	c.Assert(CheckMode("", OpConsume), IsNil)
+	c.Assert(CheckMode(config.ModeConsumeOnly, OpConsume), IsNil)
+	c.Assert(CheckMode(config.ModeConsumeOnly, ""), IsNil)
+	c.Assert(CheckMode(config.ModeProduceOnly, OpProduce), IsNil)
+
+	err := CheckMode(config.ModeConsumeOnly, OpProduce)
+	c.Assert(errors.Cause(err), Equals, ErrOpNotAllowed)
+	c.Assert(err, ErrorMatches, "mode=consume_only, op=produce: operation not allowed on this listener")
+	c.Assert(errors.Cause(CheckMode(config.ModeProduceOnly, OpConsume)), Equals, ErrOpNotAllowed)
+}
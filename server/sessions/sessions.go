@@ -0,0 +1,374 @@
+package sessions
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// The weight of the latest ack latency in the mean ack latency of a session.
+const ackLatencyWeight = 0.2
+
+// Reasons that a session is considered slow for.
+const (
+	SlowAckLatency  = "ack_latency"
+	SlowConsumeRate = "consume_rate"
+)
+
+type contextKey struct{}
+
+// View describes a client session, that is a connection to the HTTP API
+// server, at some point in time.
+type View struct {
+	ID         string `json:"id"`
+	RemoteAddr string `json:"remote_addr"`
+
+	// Client ID provided in the last request of the session, if any.
+	ClientID string `json:"client_id,omitempty"`
+
+	ConnectedAt   time.Time `json:"connected_at"`
+	LastRequestAt time.Time `json:"last_request_at"`
+
+	// Group/topic pairs that messages were consumed from in the session.
+	Subscriptions []Subscription `json:"subscriptions"`
+
+	// Number of consume requests of the session waiting for a message.
+	PendingPolls int `json:"pending_polls"`
+
+	// Number of messages consumed in the session that are neither
+	// acknowledged, nor have their ack timeout expired.
+	InFlightAcks int   `json:"in_flight_acks"`
+	BytesIn      int64 `json:"bytes_in"`
+	BytesOut     int64 `json:"bytes_out"`
+
+	// Exponentially weighted mean latency of acks in the session.
+	AckLatencyMs float64 `json:"ack_latency_ms"`
+
+	// Number of messages per second consumed in the session during the last
+	// complete window.
+	ConsumeRate float64 `json:"consume_rate"`
+
+	// Reasons that the session is considered slow for, if it is.
+	SlowReasons []string `json:"slow_reasons,omitempty"`
+}
+
+// Subscription is a group/topic pair that messages were consumed from.
+type Subscription struct {
+	Cluster string `json:"cluster,omitempty"`
+	Group   string `json:"group"`
+	Topic   string `json:"topic"`
+}
+
+// Session represents a client connection. A nil instance is valid, it ignores
+// everything.
+type Session struct {
+	t             *T
+	conn          net.Conn
+	view          View
+	subscriptions map[Subscription]bool
+
+	// Messages consumed in the session that are not acknowledged yet.
+	inFlight map[message]inFlightMsg
+
+	ackLatency      time.Duration
+	ackLatencyKnown bool
+
+	// The number of messages consumed in the current window, and the rate of
+	// the last complete window.
+	windowStartedAt  time.Time
+	windowCount      int
+	consumeRate      float64
+	consumeRateKnown bool
+}
+
+type inFlightMsg struct {
+	consumedAt time.Time
+	expiresAt  time.Time
+}
+
+type message struct {
+	Subscription
+	partition int32
+	offset    int64
+}
+
+// T keeps track of client sessions of an HTTP API server. It is safe for
+// concurrent use.
+type T struct {
+	cfg      config.SlowConsumers
+	mu       sync.Mutex
+	nextID   int64
+	sessions map[net.Conn]*Session
+}
+
+// New creates a session registry that never considers sessions slow.
+func New() *T {
+	return NewWithSlowConsumers(config.SlowConsumers{Window: time.Minute})
+}
+
+// NewWithSlowConsumers creates a session registry that detects slow sessions
+// as the config prescribes.
+func NewWithSlowConsumers(cfg config.SlowConsumers) *T {
+	return &T{cfg: cfg, sessions: make(map[net.Conn]*Session)}
+}
+
+// Open registers a session of a new connection, and returns a context that
+// carries it. It is supposed to be used as http.Server.ConnContext.
+func (t *T) Open(ctx context.Context, conn net.Conn) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	now := time.Now().UTC()
+	session := &Session{
+		t:    t,
+		conn: conn,
+		view: View{
+			ID:            strconv.FormatInt(t.nextID, 10),
+			RemoteAddr:    conn.RemoteAddr().String(),
+			ConnectedAt:   now,
+			LastRequestAt: now,
+		},
+		subscriptions: make(map[Subscription]bool),
+		inFlight:      make(map[message]inFlightMsg),
+	}
+	t.sessions[conn] = session
+	return context.WithValue(ctx, contextKey{}, session)
+}
+
+// Close forgets the session of a closed connection.
+func (t *T) Close(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.sessions, conn)
+	t.mu.Unlock()
+}
+
+// FromContext returns the session that a request context carries, or nil if
+// there is none.
+func FromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(contextKey{}).(*Session)
+	return session
+}
+
+// Sessions returns all open sessions ordered by ID.
+func (t *T) Sessions() []View {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	views := make([]View, 0, len(t.sessions))
+	now := time.Now()
+	for _, session := range t.sessions {
+		views = append(views, session.snapshot(now))
+	}
+	sort.Sort(byID(views))
+	return views
+}
+
+// SlowSessions is like Sessions, except only slow sessions are returned.
+func (t *T) SlowSessions() []View {
+	slowViews := []View{}
+	for _, view := range t.Sessions() {
+		if len(view.SlowReasons) > 0 {
+			slowViews = append(slowViews, view)
+		}
+	}
+	return slowViews
+}
+
+// Evict closes the connection of a session, so that all its requests are
+// aborted. It returns false if there is no session with the specified ID.
+func (t *T) Evict(id string) bool {
+	t.mu.Lock()
+	var conn net.Conn
+	for c, session := range t.sessions {
+		if session.view.ID == id {
+			conn = c
+			delete(t.sessions, c)
+			break
+		}
+	}
+	t.mu.Unlock()
+	if conn == nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Acked stops counting a message as in flight in whatever session it was
+// consumed in. A message can be acknowledged in a session other than the one
+// it was consumed in.
+func (t *T) Acked(sub Subscription, partition int32, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	msg := message{sub, partition, offset}
+	now := time.Now()
+	for _, session := range t.sessions {
+		if inFlight, ok := session.inFlight[msg]; ok {
+			delete(session.inFlight, msg)
+			session.addAckLatency(now.Sub(inFlight.consumedAt))
+		}
+	}
+}
+
+// OnRequest updates the session with a request that has just started.
+func (s *Session) OnRequest(clientID string) {
+	if s == nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.view.LastRequestAt = time.Now().UTC()
+	if clientID != "" {
+		s.view.ClientID = clientID
+	}
+}
+
+// AddBytes adds the number of bytes transferred by a request to the session
+// totals.
+func (s *Session) AddBytes(in, out int64) {
+	if s == nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.view.BytesIn += in
+	s.view.BytesOut += out
+}
+
+// StartPoll counts a consume request as pending until the returned function
+// is called.
+func (s *Session) StartPoll(sub Subscription) func() {
+	if s == nil {
+		return func() {}
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.subscriptions[sub] = true
+	s.view.PendingPolls++
+	return func() {
+		s.t.mu.Lock()
+		s.view.PendingPolls--
+		s.t.mu.Unlock()
+	}
+}
+
+// Consumed counts a message consumed in the session as in flight until it is
+// acknowledged or `ackTimeout` expires, for it is offered to other clients
+// after that.
+func (s *Session) Consumed(sub Subscription, partition int32, offset int64, ackTimeout time.Duration) {
+	if s == nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	now := time.Now()
+	s.inFlight[message{sub, partition, offset}] = inFlightMsg{now, now.Add(ackTimeout)}
+	s.rollWindow(now)
+	s.windowCount++
+}
+
+// Slow tells whether the session is considered slow at the moment.
+func (s *Session) Slow() bool {
+	if s == nil {
+		return false
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	return len(s.snapshot(time.Now()).SlowReasons) > 0
+}
+
+// addAckLatency updates the mean ack latency of the session. It must be
+// called under the lock.
+func (s *Session) addAckLatency(latency time.Duration) {
+	if !s.ackLatencyKnown {
+		s.ackLatency, s.ackLatencyKnown = latency, true
+		return
+	}
+	s.ackLatency += time.Duration(ackLatencyWeight * float64(latency-s.ackLatency))
+}
+
+// rollWindow starts a new consume rate window if the current one is over. It
+// must be called under the lock.
+func (s *Session) rollWindow(now time.Time) {
+	window := s.t.cfg.Window
+	if window <= 0 {
+		return
+	}
+	if s.windowStartedAt.IsZero() {
+		s.windowStartedAt = now
+		return
+	}
+	elapsed := now.Sub(s.windowStartedAt)
+	if elapsed < window {
+		return
+	}
+	s.consumeRate, s.consumeRateKnown = 0, true
+	// If more than one window passed, then nothing was consumed during the
+	// last complete one.
+	if elapsed < 2*window {
+		s.consumeRate = float64(s.windowCount) / window.Seconds()
+	}
+	s.windowStartedAt = s.windowStartedAt.Add(elapsed / window * window)
+	s.windowCount = 0
+}
+
+// snapshot returns a view of the session dropping messages with expired ack
+// timeout, that count as acknowledged when the timeout expired. It must be
+// called under the lock.
+func (s *Session) snapshot(now time.Time) View {
+	for msg, inFlight := range s.inFlight {
+		if !now.Before(inFlight.expiresAt) {
+			delete(s.inFlight, msg)
+			s.addAckLatency(inFlight.expiresAt.Sub(inFlight.consumedAt))
+		}
+	}
+	s.rollWindow(now)
+	view := s.view
+	view.InFlightAcks = len(s.inFlight)
+	view.AckLatencyMs = float64(s.ackLatency) / float64(time.Millisecond)
+	view.ConsumeRate = s.consumeRate
+	if view.InFlightAcks > 0 {
+		cfg := s.t.cfg
+		if cfg.MaxAckLatency > 0 && s.ackLatencyKnown && s.ackLatency > cfg.MaxAckLatency {
+			view.SlowReasons = append(view.SlowReasons, SlowAckLatency)
+		}
+		if cfg.MinConsumeRate > 0 && s.consumeRateKnown && s.consumeRate < cfg.MinConsumeRate {
+			view.SlowReasons = append(view.SlowReasons, SlowConsumeRate)
+		}
+	}
+	view.Subscriptions = make([]Subscription, 0, len(s.subscriptions))
+	for sub := range s.subscriptions {
+		view.Subscriptions = append(view.Subscriptions, sub)
+	}
+	sort.Sort(bySubscription(view.Subscriptions))
+	return view
+}
+
+type byID []View
+
+func (a byID) Len() int      { return len(a) }
+func (a byID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byID) Less(i, j int) bool {
+	if len(a[i].ID) != len(a[j].ID) {
+		return len(a[i].ID) < len(a[j].ID)
+	}
+	return a[i].ID < a[j].ID
+}
+
+type bySubscription []Subscription
+
+func (a bySubscription) Len() int      { return len(a) }
+func (a bySubscription) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySubscription) Less(i, j int) bool {
+	if a[i].Cluster != a[j].Cluster {
+		return a[i].Cluster < a[j].Cluster
+	}
+	if a[i].Group != a[j].Group {
+		return a[i].Group < a[j].Group
+	}
+	return a[i].Topic < a[j].Topic
+}
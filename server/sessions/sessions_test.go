@@ -0,0 +1,178 @@
+package sessions
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type SessionsSuite struct{}
+
+var _ = Suite(&SessionsSuite{})
+
+var (
+	sub1 = Subscription{Group: "g1", Topic: "foo"}
+	sub2 = Subscription{Group: "g1", Topic: "bar"}
+)
+
+func (s *SessionsSuite) TestSessions(c *C) {
+	t := New()
+	conn1, _ := net.Pipe()
+	conn2, _ := net.Pipe()
+	session1 := FromContext(t.Open(context.Background(), conn1))
+	session2 := FromContext(t.Open(context.Background(), conn2))
+
+	// When
+	session1.OnRequest("c1")
+	session1.AddBytes(10, 200)
+	done := session1.StartPoll(sub1)
+	session1.StartPoll(sub2)()
+	session1.Consumed(sub1, 0, 13, time.Hour)
+	session1.Consumed(sub1, 1, 7, time.Hour)
+	session2.OnRequest("")
+	t.Acked(sub1, 1, 7)
+
+	// Then
+	views := t.Sessions()
+	c.Assert(len(views), Equals, 2)
+	c.Assert(views[0].ID, Equals, "1")
+	c.Assert(views[0].ClientID, Equals, "c1")
+	c.Assert(views[0].Subscriptions, DeepEquals, []Subscription{sub2, sub1})
+	c.Assert(views[0].PendingPolls, Equals, 1)
+	c.Assert(views[0].InFlightAcks, Equals, 1)
+	c.Assert(views[0].BytesIn, Equals, int64(10))
+	c.Assert(views[0].BytesOut, Equals, int64(200))
+	c.Assert(views[1].ID, Equals, "2")
+	c.Assert(views[1].ClientID, Equals, "")
+	c.Assert(views[1].Subscriptions, DeepEquals, []Subscription{})
+
+	done()
+	c.Assert(t.Sessions()[0].PendingPolls, Equals, 0)
+}
+
+// Messages are not counted as in flight after their ack timeout expires.
+func (s *SessionsSuite) TestAckTimeout(c *C) {
+	t := New()
+	conn, _ := net.Pipe()
+	session := FromContext(t.Open(context.Background(), conn))
+	session.Consumed(sub1, 0, 13, 50*time.Millisecond)
+	c.Assert(t.Sessions()[0].InFlightAcks, Equals, 1)
+
+	// When
+	time.Sleep(60 * time.Millisecond)
+
+	// Then
+	c.Assert(t.Sessions()[0].InFlightAcks, Equals, 0)
+}
+
+// Evicted sessions have their connections closed.
+func (s *SessionsSuite) TestEvict(c *C) {
+	t := New()
+	conn, peer := net.Pipe()
+	t.Open(context.Background(), conn)
+
+	// When
+	ok := t.Evict("1")
+
+	// Then
+	c.Assert(ok, Equals, true)
+	c.Assert(t.Sessions(), DeepEquals, []View{})
+	_, err := peer.Read(make([]byte, 1))
+	c.Assert(err, NotNil)
+	c.Assert(t.Evict("1"), Equals, false)
+}
+
+func (s *SessionsSuite) TestClose(c *C) {
+	t := New()
+	conn, _ := net.Pipe()
+	t.Open(context.Background(), conn)
+
+	// When
+	t.Close(conn)
+
+	// Then
+	c.Assert(t.Sessions(), DeepEquals, []View{})
+}
+
+// A session that has messages in flight is slow if its mean ack latency is
+// above the threshold.
+func (s *SessionsSuite) TestSlowAckLatency(c *C) {
+	t := NewWithSlowConsumers(config.SlowConsumers{MaxAckLatency: 30 * time.Millisecond, Window: time.Minute})
+	conn, _ := net.Pipe()
+	session := FromContext(t.Open(context.Background(), conn))
+	session.Consumed(sub1, 0, 1, time.Hour)
+	time.Sleep(40 * time.Millisecond)
+	t.Acked(sub1, 0, 1)
+	c.Assert(session.Slow(), Equals, false)
+
+	// When
+	session.Consumed(sub1, 0, 2, time.Hour)
+
+	// Then
+	c.Assert(session.Slow(), Equals, true)
+	views := t.SlowSessions()
+	c.Assert(len(views), Equals, 1)
+	c.Assert(views[0].SlowReasons, DeepEquals, []string{SlowAckLatency})
+	c.Assert(views[0].AckLatencyMs >= 40, Equals, true)
+
+	// When
+	t.Acked(sub1, 0, 2)
+
+	// Then
+	c.Assert(session.Slow(), Equals, false)
+	c.Assert(t.SlowSessions(), DeepEquals, []View{})
+}
+
+// Messages with expired ack timeout count as acknowledged at the timeout.
+func (s *SessionsSuite) TestSlowAckTimeout(c *C) {
+	t := NewWithSlowConsumers(config.SlowConsumers{MaxAckLatency: 30 * time.Millisecond, Window: time.Minute})
+	conn, _ := net.Pipe()
+	session := FromContext(t.Open(context.Background(), conn))
+	session.Consumed(sub1, 0, 1, 40*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// When
+	session.Consumed(sub1, 0, 2, time.Hour)
+
+	// Then
+	c.Assert(session.Slow(), Equals, true)
+	c.Assert(t.Sessions()[0].AckLatencyMs, Equals, float64(40))
+}
+
+// A session that has messages in flight is slow if it consumed fewer messages
+// than the threshold during the last window.
+func (s *SessionsSuite) TestSlowConsumeRate(c *C) {
+	t := NewWithSlowConsumers(config.SlowConsumers{MinConsumeRate: 100, Window: 50 * time.Millisecond})
+	conn, _ := net.Pipe()
+	session := FromContext(t.Open(context.Background(), conn))
+	session.Consumed(sub1, 0, 1, time.Hour)
+	c.Assert(session.Slow(), Equals, false)
+
+	// When
+	time.Sleep(60 * time.Millisecond)
+
+	// Then
+	views := t.SlowSessions()
+	c.Assert(len(views), Equals, 1)
+	c.Assert(views[0].SlowReasons, DeepEquals, []string{SlowConsumeRate})
+	c.Assert(views[0].ConsumeRate, Equals, float64(20))
+}
+
+// A nil session ignores everything.
+func (s *SessionsSuite) TestNil(c *C) {
+	session := FromContext(context.Background())
+	c.Assert(session, IsNil)
+	session.OnRequest("c1")
+	session.AddBytes(1, 2)
+	session.StartPoll(sub1)()
+	session.Consumed(sub1, 0, 1, time.Hour)
+	c.Assert(session.Slow(), Equals, false)
+}
@@ -3,31 +3,54 @@ package service
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/discovery"
 	"github.com/mailgun/kafka-pixy/proxy"
 	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/server/accesslog"
+	"github.com/mailgun/kafka-pixy/server/diagsrv"
 	"github.com/mailgun/kafka-pixy/server/grpcsrv"
 	"github.com/mailgun/kafka-pixy/server/httpsrv"
+	"github.com/mailgun/kafka-pixy/server/identity"
+	"github.com/mailgun/kafka-pixy/server/netpolicy"
+	"github.com/mailgun/kafka-pixy/server/sessions"
 	"github.com/mailgun/log"
 	"github.com/pkg/errors"
 )
 
 type T struct {
-	actorID *actor.ID
-	proxies map[string]*proxy.T
-	servers []server.T
-	stopCh  chan struct{}
-	wg      sync.WaitGroup
+	actorID   *actor.ID
+	proxies   map[string]*proxy.T
+	proxySet  *proxy.Set
+	servers   []server.T
+	registrar *discovery.T
+	accessLog *accesslog.T
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
 }
 
 func Spawn(cfg *config.App) (*T, error) {
+	return SpawnWithPanicReporter(cfg, nil)
+}
+
+// SpawnWithPanicReporter is like Spawn, but panics in API request handlers are
+// also reported to `reporter`, e.g. an error tracking service.
+func SpawnWithPanicReporter(cfg *config.App, reporter server.PanicReporter) (*T, error) {
+	accessLog, err := accesslog.Open(cfg.AccessLog)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open access log")
+	}
 	s := &T{
-		actorID: actor.RootID.NewChild("service"),
-		proxies: make(map[string]*proxy.T, len(cfg.Proxies)),
-		stopCh:  make(chan struct{}),
+		actorID:   actor.RootID.NewChild("service"),
+		proxies:   make(map[string]*proxy.T, len(cfg.Proxies)),
+		accessLog: accessLog,
+		stopCh:    make(chan struct{}),
 	}
 
 	for cluster, pxyCfg := range cfg.Proxies {
@@ -40,17 +63,55 @@ func Spawn(cfg *config.App) (*T, error) {
 	}
 
 	proxySet := proxy.NewSet(s.proxies, s.proxies[cfg.DefaultCluster])
+	s.proxySet = proxySet
+	for cluster, pxyCfg := range cfg.Proxies {
+		proxySet.EnableFailover(cluster, pxyCfg)
+	}
+	tlsCfg, err := server.NewTLSConfig(cfg.TLS)
+	if err != nil {
+		s.stopProxies()
+		return nil, errors.Wrap(err, "failed to configure TLS")
+	}
+	srvOpts := server.Opts{
+		PanicReporter: reporter,
+		AccessLog:     accessLog,
+		SlowConsumers: cfg.SlowConsumers,
+		Identity:      identity.New(cfg.ClientIdentity),
+		TLS:           tlsCfg,
+		HTTP:          &cfg.HTTPServer,
+		GRPC:          &cfg.GRPCServer,
+
+		ProxyRegistration: cfg.ProxyRegistration,
+	}
+	if cfg.AdminAddr != "" {
+		// Sessions are shared, so that the admin plane can tell about
+		// sessions of the data plane.
+		srvOpts.Sessions = sessions.NewWithSlowConsumers(cfg.SlowConsumers)
+	}
 
 	if cfg.GRPCAddr != "" {
-		grpcSrv, err := grpcsrv.New(cfg.GRPCAddr, proxySet)
+		srvOpts.Mode = cfg.ListenerModes.For(cfg.ListenerModes.GRPC)
+		if srvOpts.NetPolicy, err = netpolicy.New(cfg.NetworkPolicies.All, cfg.NetworkPolicies.GRPC); err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to configure gRPC network policy")
+		}
+		grpcSrv, err := grpcsrv.NewWithOpts(cfg.GRPCAddr, proxySet, srvOpts)
 		if err != nil {
 			s.stopProxies()
 			return nil, errors.Wrap(err, "failed to start gRPC server")
 		}
 		s.servers = append(s.servers, grpcSrv)
 	}
+	if cfg.AdminAddr != "" {
+		srvOpts.Plane = server.PlaneData
+	}
 	if cfg.TCPAddr != "" {
-		tcpSrv, err := httpsrv.New(cfg.TCPAddr, proxySet)
+		srvOpts.Mode = cfg.ListenerModes.For(cfg.ListenerModes.TCP)
+		if srvOpts.NetPolicy, err = netpolicy.New(cfg.NetworkPolicies.All, cfg.NetworkPolicies.TCP); err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to configure TCP network policy")
+		}
+		tcpSrv, err := httpsrv.NewWithOpts(cfg.TCPAddr, proxySet, srvOpts)
 		if err != nil {
 			s.stopProxies()
 			return nil, errors.Wrap(err, "failed to start TCP socket based HTTP API server")
@@ -58,19 +119,55 @@ func Spawn(cfg *config.App) (*T, error) {
 		s.servers = append(s.servers, tcpSrv)
 	}
 	if cfg.UnixAddr != "" {
-		unixSrv, err := httpsrv.New(cfg.UnixAddr, proxySet)
+		srvOpts.Mode = cfg.ListenerModes.For(cfg.ListenerModes.Unix)
+		if srvOpts.NetPolicy, err = netpolicy.New(cfg.NetworkPolicies.All, cfg.NetworkPolicies.Unix); err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to configure Unix socket network policy")
+		}
+		unixSrv, err := httpsrv.NewWithOpts(cfg.UnixAddr, proxySet, srvOpts)
 		if err != nil {
 			s.stopProxies()
 			return nil, errors.Wrapf(err, "failed to start Unix socket based HTTP API server")
 		}
 		s.servers = append(s.servers, unixSrv)
 	}
+	if cfg.AdminAddr != "" {
+		srvOpts.Plane = server.PlaneAdmin
+		srvOpts.Mode = cfg.ListenerModes.For(cfg.ListenerModes.Admin)
+		if srvOpts.NetPolicy, err = netpolicy.New(cfg.NetworkPolicies.All, cfg.NetworkPolicies.Admin); err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to configure admin network policy")
+		}
+		adminSrv, err := httpsrv.NewWithOpts(cfg.AdminAddr, proxySet, srvOpts)
+		if err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to start admin HTTP API server")
+		}
+		s.servers = append(s.servers, adminSrv)
+	}
 
 	if len(s.servers) == 0 {
+		s.stopProxies()
 		return nil, errors.Errorf("at least one API server should be configured")
 	}
+	if cfg.DiagAddr != "" {
+		diagSrv, err := diagsrv.New(cfg.DiagAddr)
+		if err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to start diagnostics server")
+		}
+		s.servers = append(s.servers, diagSrv)
+	}
+
+	if s.registrar, err = discovery.New(s.actorID, cfg, func() discovery.Status { return s.discoveryStatus(cfg) }); err != nil {
+		s.stopProxies()
+		return nil, errors.Wrap(err, "failed to configure discovery")
+	}
 
 	actor.Spawn(s.actorID, &s.wg, s.run)
+	if cfg.HasSecretRefs() && cfg.Secrets.RefreshInterval > 0 {
+		actor.Spawn(s.actorID.NewChild("secrets"), &s.wg, func() { s.refreshSecrets(cfg) })
+	}
 	return s, nil
 }
 
@@ -95,6 +192,9 @@ func (s *T) run() {
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(s.stopCh),
 	}
+	// The instance is advertised once it is listening, and stops being
+	// advertised before it stops serving requests.
+	s.registrar.Start()
 
 	// Wait until either an error is reported by one of the servers or a Stop
 	// is called.
@@ -104,6 +204,8 @@ func (s *T) run() {
 		log.Errorf("API server crashed: %+v", serverErr)
 	}
 
+	s.registrar.Stop()
+
 	// Initiate stop of all API servers.
 	var wg sync.WaitGroup
 	for _, fe := range s.servers {
@@ -116,10 +218,82 @@ func (s *T) run() {
 	s.stopProxies()
 }
 
+// refreshSecrets periodically resolves secret references in the config again,
+// and makes proxies use the resolved values, so that rotated credentials take
+// effect without a restart. If resolution fails, then the values resolved
+// last time stay in use.
+func (s *T) refreshSecrets(cfg *config.App) {
+	ticker := time.NewTicker(cfg.Secrets.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			resolvedCfg, err := cfg.ResolveSecrets()
+			if err != nil {
+				log.Errorf("<%s> failed to refresh secrets: err=(%s)", s.actorID, err)
+				continue
+			}
+			for _, member := range s.proxySet.Members() {
+				if pxyCfg, ok := resolvedCfg.Proxies[member.Cluster]; ok && !member.Dynamic {
+					member.Proxy.UpdateSecrets(pxyCfg)
+				}
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// discoveryStatus returns registration tags of the served clusters, and
+// whether all proxies are warmed up and commit offsets fine.
+func (s *T) discoveryStatus(cfg *config.App) discovery.Status {
+	status := discovery.Status{Healthy: true}
+	status.Tags = append(status.Tags, cfg.Discovery.Tags...)
+	var problems []string
+	for _, member := range s.proxySet.Members() {
+		status.Tags = append(status.Tags, "cluster:"+member.Cluster)
+		if member.Default {
+			status.Tags = append(status.Tags, "default_cluster:"+member.Cluster)
+		}
+		if !member.Proxy.Ready() {
+			problems = append(problems, fmt.Sprintf("cluster %s not ready", member.Cluster))
+		}
+		if failures := member.Proxy.OffsetCommitFailures(); len(failures) > 0 {
+			problems = append(problems, fmt.Sprintf("cluster %s offset commit failures: %d", member.Cluster, len(failures)))
+		}
+		if !cfg.Discovery.TopicTags {
+			continue
+		}
+		topics, _, err := member.Proxy.ListTopics(admin.Page{})
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cluster %s failed to list topics: %s", member.Cluster, err))
+			continue
+		}
+		for _, topic := range topics {
+			status.Tags = append(status.Tags, "topic:"+member.Cluster+"/"+topic)
+		}
+	}
+	if len(problems) > 0 {
+		status.Healthy = false
+		status.Output = strings.Join(problems, "; ")
+	}
+	return status
+}
+
+// stopProxies stops all proxies and closes the access log, that is not
+// needed once API servers are stopped. Once the proxy set is created, it is
+// the set that knows all proxies, including those registered at runtime.
 func (s *T) stopProxies() {
-	var wg sync.WaitGroup
-	for pxyAlias, pxy := range s.proxies {
-		actor.Spawn(s.actorID.NewChild(fmt.Sprintf("%s_stop", pxyAlias)), &wg, pxy.Stop)
+	if s.proxySet != nil {
+		s.proxySet.Stop()
+	} else {
+		var wg sync.WaitGroup
+		for pxyAlias, pxy := range s.proxies {
+			actor.Spawn(s.actorID.NewChild(fmt.Sprintf("%s_stop", pxyAlias)), &wg, pxy.Stop)
+		}
+		wg.Wait()
+	}
+	if err := s.accessLog.Close(); err != nil {
+		log.Errorf("<%s> failed to close access log: err=(%s)", s.actorID, err)
 	}
-	wg.Wait()
 }
@@ -0,0 +1,202 @@
+package tap
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+)
+
+// Sources of sampled messages.
+const (
+	SourceProduce = "produce"
+	SourceConsume = "consume"
+)
+
+// Message is a sampled message along with metadata telling where it was
+// seen. Partition and Offset are -1 for messages produced asynchronously,
+// that are sampled before they are written.
+type Message struct {
+	Cluster   string    `json:"cluster"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Group     string    `json:"group,omitempty"`
+}
+
+// T samples messages produced and consumed via a proxy at rates configured
+// per topic, and copies them to the tap topic, and to subscribers, e.g. live
+// streams of the `GET /_tap` endpoint. Consumer groups are not affected, for
+// messages are sampled as they pass through the proxy. A nil instance is
+// valid, it samples nothing.
+type T struct {
+	cluster    string
+	rates      map[string]float64
+	topic      string
+	bufferSize int
+	produce    func(topic string, key, message sarama.Encoder)
+	metrics    *metrics.Registry
+
+	mu          sync.Mutex
+	rand        *rand.Rand
+	subscribers map[*Subscription]bool
+}
+
+// New creates a tap as configured in the `tap` section of a proxy config.
+// Sampled messages are copied to the tap topic with `produce`. It returns nil
+// if no topic is sampled.
+func New(cluster string, cfg *config.Proxy, produce func(topic string, key, message sarama.Encoder), registry *metrics.Registry) *T {
+	if len(cfg.Tap.Rates) == 0 {
+		return nil
+	}
+	return &T{
+		cluster:     cluster,
+		rates:       cfg.Tap.Rates,
+		topic:       cfg.Tap.Topic,
+		bufferSize:  cfg.Tap.BufferSize,
+		produce:     produce,
+		metrics:     registry,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Produced samples a message produced to `topic`. Encoders are only encoded
+// if the message is sampled.
+func (t *T) Produced(topic string, key, message sarama.Encoder, partition int32, offset int64) {
+	if !t.sampled(topic) {
+		return
+	}
+	t.copy(Message{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       encode(key),
+		Value:     encode(message),
+		Source:    SourceProduce,
+	})
+}
+
+// Consumed samples a message delivered to a consumer of `group`.
+func (t *T) Consumed(group, topic string, partition int32, offset int64, key, value []byte) {
+	if !t.sampled(topic) {
+		return
+	}
+	t.copy(Message{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       key,
+		Value:     value,
+		Source:    SourceConsume,
+		Group:     group,
+	})
+}
+
+// Subscribe returns a subscription to messages sampled from `topics`, or
+// from all sampled topics if none is given.
+func (t *T) Subscribe(topics []string) *Subscription {
+	s := &Subscription{t: t, msgCh: make(chan Message, t.bufferSize)}
+	if len(topics) > 0 {
+		s.topics = make(map[string]bool, len(topics))
+		for _, topic := range topics {
+			s.topics[topic] = true
+		}
+	}
+	t.mu.Lock()
+	t.subscribers[s] = true
+	t.mu.Unlock()
+	return s
+}
+
+// sampled makes a sampling decision for a message of `topic`. Messages of
+// the tap topic are never sampled, so that copies are not copied again.
+func (t *T) sampled(topic string) bool {
+	if t == nil || topic == t.topic {
+		return false
+	}
+	rate, ok := t.rates[topic]
+	if !ok {
+		return false
+	}
+	if rate >= 1 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rand.Float64() < rate
+}
+
+func (t *T) copy(msg Message) {
+	msg.Cluster = t.cluster
+	msg.Time = time.Now().UTC()
+	t.metrics.Counter("tap.sampled", "topic", msg.Topic).Inc(1)
+	if t.topic != "" {
+		if encoded, err := json.Marshal(msg); err == nil {
+			t.produce(t.topic, sarama.StringEncoder(msg.Topic), sarama.ByteEncoder(encoded))
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for s := range t.subscribers {
+		if s.topics != nil && !s.topics[msg.Topic] {
+			continue
+		}
+		select {
+		case s.msgCh <- msg:
+		default:
+			// A slow subscriber must not hold up produce and consume
+			// requests, so it misses messages instead.
+			t.metrics.Counter("tap.dropped").Inc(1)
+		}
+	}
+}
+
+// Subscription receives messages sampled by a tap. Messages that a subscriber
+// is too slow to take are dropped when its buffer of `tap.buffer_size` is
+// full.
+type Subscription struct {
+	t      *T
+	topics map[string]bool
+	msgCh  chan Message
+}
+
+// Next returns the next sampled message. It blocks until there is one, or
+// until `cancelCh` is closed, in which case false is returned. Buffered
+// messages are returned even if `cancelCh` is closed already.
+func (s *Subscription) Next(cancelCh <-chan struct{}) (Message, bool) {
+	select {
+	case msg := <-s.msgCh:
+		return msg, true
+	default:
+	}
+	select {
+	case msg := <-s.msgCh:
+		return msg, true
+	case <-cancelCh:
+		return Message{}, false
+	}
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.t.mu.Lock()
+	delete(s.t.subscribers, s)
+	s.t.mu.Unlock()
+}
+
+func encode(encoder sarama.Encoder) []byte {
+	if encoder == nil {
+		return nil
+	}
+	encoded, _ := encoder.Encode()
+	return encoded
+}
@@ -0,0 +1,128 @@
+package tap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TapSuite struct {
+	cfg      *config.Proxy
+	produced []*sarama.ProducerMessage
+}
+
+var _ = Suite(&TapSuite{})
+
+func (s *TapSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.Tap.Rates = map[string]float64{"foo": 1, "bar": 0.5}
+	s.cfg.Tap.Topic = "tap"
+	s.cfg.Tap.BufferSize = 2
+	s.produced = nil
+}
+
+func (s *TapSuite) produce(topic string, key, message sarama.Encoder) {
+	s.produced = append(s.produced, &sarama.ProducerMessage{Topic: topic, Key: key, Value: message})
+}
+
+// The tap is disabled unless some topics are sampled.
+func (s *TapSuite) TestNewDisabled(c *C) {
+	s.cfg.Tap.Rates = nil
+
+	// When
+	t := New("default", s.cfg, s.produce, nil)
+
+	// Then
+	c.Assert(t, IsNil)
+	t.Produced("foo", nil, sarama.StringEncoder("m1"), 0, 1)
+	t.Consumed("g1", "foo", 0, 1, nil, []byte("m1"))
+	c.Assert(s.produced, HasLen, 0)
+}
+
+// Sampled messages are copied to the tap topic with metadata, keyed by the
+// original topic. Messages of topics that are not sampled, and of the tap
+// topic itself, are not.
+func (s *TapSuite) TestTapTopic(c *C) {
+	t := New("default", s.cfg, s.produce, nil)
+
+	// When
+	t.Produced("foo", sarama.StringEncoder("k1"), sarama.StringEncoder("m1"), 2, 7)
+	t.Consumed("g1", "foo", 3, 8, []byte("k2"), []byte("m2"))
+	t.Produced("baz", nil, sarama.StringEncoder("m3"), -1, -1)
+	t.Produced("tap", nil, sarama.StringEncoder("m4"), -1, -1)
+
+	// Then
+	c.Assert(s.produced, HasLen, 2)
+	var msgs []Message
+	for _, prodMsg := range s.produced {
+		c.Assert(prodMsg.Topic, Equals, "tap")
+		c.Assert(prodMsg.Key, Equals, sarama.StringEncoder("foo"))
+		encoded, _ := prodMsg.Value.Encode()
+		var msg Message
+		c.Assert(json.Unmarshal(encoded, &msg), IsNil)
+		c.Assert(msg.Time.IsZero(), Equals, false)
+		msgs = append(msgs, msg)
+	}
+	c.Assert(msgs[0].Cluster, Equals, "default")
+	c.Assert(msgs[0].Source, Equals, SourceProduce)
+	c.Assert(msgs[0].Partition, Equals, int32(2))
+	c.Assert(msgs[0].Offset, Equals, int64(7))
+	c.Assert(string(msgs[0].Key), Equals, "k1")
+	c.Assert(string(msgs[0].Value), Equals, "m1")
+	c.Assert(msgs[1].Source, Equals, SourceConsume)
+	c.Assert(msgs[1].Group, Equals, "g1")
+	c.Assert(string(msgs[1].Value), Equals, "m2")
+}
+
+// Topics are sampled at their rates.
+func (s *TapSuite) TestRates(c *C) {
+	t := New("default", s.cfg, s.produce, nil)
+
+	// When
+	for i := 0; i < 1000; i++ {
+		t.Produced("bar", nil, sarama.StringEncoder("m"), -1, -1)
+	}
+
+	// Then
+	c.Assert(len(s.produced) > 400 && len(s.produced) < 600, Equals, true, Commentf("%d", len(s.produced)))
+}
+
+// Subscribers get messages of topics they subscribed to, and those that do
+// not fit into their buffers are dropped.
+func (s *TapSuite) TestSubscribe(c *C) {
+	registry := metrics.New()
+	t := New("default", s.cfg, s.produce, registry)
+	all := t.Subscribe(nil)
+	defer all.Close()
+	foo := t.Subscribe([]string{"foo"})
+	closed := t.Subscribe(nil)
+	closed.Close()
+
+	// When
+	for i := 0; i < 3; i++ {
+		t.Consumed("g1", "foo", 0, int64(i), nil, nil)
+	}
+
+	// Then
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+	for i := 0; i < 2; i++ {
+		msg, ok := foo.Next(cancelCh)
+		c.Assert(ok, Equals, true)
+		c.Assert(msg.Offset, Equals, int64(i))
+	}
+	_, ok := foo.Next(cancelCh)
+	c.Assert(ok, Equals, false)
+	_, ok = closed.Next(cancelCh)
+	c.Assert(ok, Equals, false)
+	c.Assert(registry.Counter("tap.dropped").Count(), Equals, int64(2))
+	c.Assert(registry.Counter("tap.sampled", "topic", "foo").Count(), Equals, int64(3))
+}
@@ -0,0 +1,208 @@
+// Package tenancy implements isolation of tenants sharing a Kafka cluster.
+// Clients authenticate as members of a tenant with secret tokens, the tenant
+// prefix is applied to all topic and group names in their requests, and the
+// rate of their requests is limited by the tenant quota.
+package tenancy
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid tenant token")
+	ErrQuotaExceeded   = errors.New("tenant request quota exceeded")
+)
+
+// T is a registry of tenants sharing a cluster. A nil instance is valid and
+// means that tenancy is disabled.
+type T struct {
+	mu        sync.RWMutex
+	byToken   map[string]*Tenant
+	operators map[string]bool
+	tenants   []*Tenant
+}
+
+// Tenant represents a particular tenant. A nil instance is valid and
+// represents a client of a cluster that does not have tenants configured.
+// Such client has access to all topics and groups and is not rate limited.
+type Tenant struct {
+	name        string
+	prefix      string
+	ratePerSec  float64
+	mu          sync.Mutex
+	quotaTokens float64
+	lastRefill  time.Time
+	stats       Stats
+}
+
+// Stats contains request counters of a tenant.
+type Stats struct {
+	// Number of requests that were admitted.
+	Requests int64
+
+	// Number of requests that were rejected because the quota was exceeded.
+	Throttled int64
+}
+
+// New creates a tenant registry from the tenants and operator tokens config
+// sections of a proxy. If there are no tenants configured then nil is
+// returned.
+func New(cfg map[string]*config.Tenant, operatorTokens []string) *T {
+	if len(cfg) == 0 {
+		return nil
+	}
+	t := &T{byToken: make(map[string]*Tenant), operators: toSet(operatorTokens)}
+	for name, tenantCfg := range cfg {
+		tenant := &Tenant{
+			name:        name,
+			prefix:      tenantCfg.Prefix,
+			ratePerSec:  float64(tenantCfg.RequestsPerSecond),
+			quotaTokens: float64(tenantCfg.RequestsPerSecond),
+			lastRefill:  time.Now(),
+		}
+		if tenant.prefix == "" {
+			tenant.prefix = name + "."
+		}
+		for _, token := range tenantCfg.Tokens {
+			t.byToken[token] = tenant
+		}
+		t.tenants = append(t.tenants, tenant)
+	}
+	sort.Sort(tenantsByName(t.tenants))
+	return t
+}
+
+// Authenticate returns a tenant that the specified token belongs to. If
+// tenancy is disabled, or the token is an operator token, then nil tenant is
+// returned, that has access to everything including operator endpoints.
+func (t *T) Authenticate(token string) (*Tenant, error) {
+	if t == nil {
+		return nil, nil
+	}
+	t.mu.RLock()
+	tenant, ok := t.byToken[token]
+	isOperator := t.operators[token]
+	t.mu.RUnlock()
+	if isOperator {
+		return nil, nil
+	}
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return tenant, nil
+}
+
+// UpdateTokens replaces tokens of the tenants and operator tokens with those
+// specified in the config, so that rotated tokens take effect without a
+// restart. Tenants that are not already in the registry, and their prefixes
+// and quotas, are ignored, for taking them on requires a restart anyway.
+func (t *T) UpdateTokens(cfg map[string]*config.Tenant, operatorTokens []string) {
+	if t == nil {
+		return
+	}
+	byToken := make(map[string]*Tenant)
+	for _, tenant := range t.tenants {
+		if tenantCfg, ok := cfg[tenant.name]; ok {
+			for _, token := range tenantCfg.Tokens {
+				byToken[token] = tenant
+			}
+		}
+	}
+	operators := toSet(operatorTokens)
+	t.mu.Lock()
+	t.byToken = byToken
+	t.operators = operators
+	t.mu.Unlock()
+}
+
+// Stats returns tenant name -> request counters mapping.
+func (t *T) Stats() map[string]Stats {
+	stats := make(map[string]Stats)
+	if t == nil {
+		return stats
+	}
+	for _, tenant := range t.tenants {
+		tenant.mu.Lock()
+		stats[tenant.name] = tenant.stats
+		tenant.mu.Unlock()
+	}
+	return stats
+}
+
+// Name returns the tenant name.
+func (tn *Tenant) Name() string {
+	if tn == nil {
+		return ""
+	}
+	return tn.name
+}
+
+// Admit should be called for every request of the tenant clients. It returns
+// ErrQuotaExceeded if the request should be rejected.
+func (tn *Tenant) Admit() error {
+	if tn == nil {
+		return nil
+	}
+	return tn.admit(time.Now())
+}
+
+func (tn *Tenant) admit(now time.Time) error {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	if tn.ratePerSec > 0 {
+		tn.quotaTokens += now.Sub(tn.lastRefill).Seconds() * tn.ratePerSec
+		if tn.quotaTokens > tn.ratePerSec {
+			tn.quotaTokens = tn.ratePerSec
+		}
+		tn.lastRefill = now
+		if tn.quotaTokens < 1 {
+			tn.stats.Throttled++
+			return ErrQuotaExceeded
+		}
+		tn.quotaTokens--
+	}
+	tn.stats.Requests++
+	return nil
+}
+
+// Apply converts a topic or group name used by the tenant clients to the
+// actual name in the cluster.
+func (tn *Tenant) Apply(name string) string {
+	if tn == nil {
+		return name
+	}
+	return tn.prefix + name
+}
+
+// Strip converts an actual topic or group name in the cluster to the name
+// used by the tenant clients. It returns false if the name does not belong to
+// the tenant.
+func (tn *Tenant) Strip(name string) (string, bool) {
+	if tn == nil {
+		return name, true
+	}
+	if !strings.HasPrefix(name, tn.prefix) {
+		return "", false
+	}
+	return name[len(tn.prefix):], true
+}
+
+func toSet(tokens []string) map[string]bool {
+	set := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		set[token] = true
+	}
+	return set
+}
+
+type tenantsByName []*Tenant
+
+func (p tenantsByName) Len() int           { return len(p) }
+func (p tenantsByName) Less(i, j int) bool { return p[i].name < p[j].name }
+func (p tenantsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
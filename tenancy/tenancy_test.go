@@ -0,0 +1,137 @@
+package tenancy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TenancySuite struct{}
+
+var _ = Suite(&TenancySuite{})
+
+// If there are no tenants configured then all requests are allowed and names
+// are left intact.
+func (s *TenancySuite) TestDisabled(c *C) {
+	t := New(nil, nil)
+	c.Assert(t, IsNil)
+
+	// When
+	tenant, err := t.Authenticate("")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(tenant.Admit(), IsNil)
+	c.Assert(tenant.Apply("foo"), Equals, "foo")
+	name, ok := tenant.Strip("foo")
+	c.Assert(name, Equals, "foo")
+	c.Assert(ok, Equals, true)
+	c.Assert(t.Stats(), DeepEquals, map[string]Stats{})
+}
+
+func (s *TenancySuite) TestAuthenticate(c *C) {
+	t := New(map[string]*config.Tenant{
+		"a": {Tokens: []string{"a1", "a2"}},
+		"b": {Tokens: []string{"b1"}, Prefix: "bbb_"},
+	}, nil)
+
+	// When
+	tenantA, errA := t.Authenticate("a2")
+	tenantB, errB := t.Authenticate("b1")
+	_, errX := t.Authenticate("x")
+	_, errEmpty := t.Authenticate("")
+
+	// Then
+	c.Assert(errA, IsNil)
+	c.Assert(tenantA.Name(), Equals, "a")
+	c.Assert(tenantA.Apply("foo"), Equals, "a.foo")
+	c.Assert(errB, IsNil)
+	c.Assert(tenantB.Name(), Equals, "b")
+	c.Assert(tenantB.Apply("foo"), Equals, "bbb_foo")
+	c.Assert(errX, Equals, ErrUnauthenticated)
+	c.Assert(errEmpty, Equals, ErrUnauthenticated)
+}
+
+// Operator tokens authenticate as a nil tenant, that has access to everything.
+func (s *TenancySuite) TestAuthenticateOperator(c *C) {
+	t := New(map[string]*config.Tenant{
+		"a": {Tokens: []string{"a1"}},
+	}, []string{"op1"})
+
+	// When
+	tenant, err := t.Authenticate("op1")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(tenant, IsNil)
+	c.Assert(tenant.Apply("foo"), Equals, "foo")
+	c.Assert(t.Stats(), DeepEquals, map[string]Stats{"a": {}})
+}
+
+// Rotated tokens replace the old ones, while tenant state is preserved.
+func (s *TenancySuite) TestUpdateTokens(c *C) {
+	t := New(map[string]*config.Tenant{
+		"a": {Tokens: []string{"a1"}},
+		"b": {Tokens: []string{"b1"}},
+	}, []string{"op1"})
+	tenantA, err := t.Authenticate("a1")
+	c.Assert(err, IsNil)
+	c.Assert(tenantA.Admit(), IsNil)
+
+	// When
+	t.UpdateTokens(map[string]*config.Tenant{
+		"a": {Tokens: []string{"a2", "a3"}},
+		"c": {Tokens: []string{"c1"}},
+	}, []string{"op2"})
+
+	// Then
+	_, err = t.Authenticate("a1")
+	c.Assert(err, Equals, ErrUnauthenticated)
+	tenant, err := t.Authenticate("a3")
+	c.Assert(err, IsNil)
+	c.Assert(tenant, Equals, tenantA)
+	_, err = t.Authenticate("b1")
+	c.Assert(err, Equals, ErrUnauthenticated)
+	_, err = t.Authenticate("c1")
+	c.Assert(err, Equals, ErrUnauthenticated)
+	_, err = t.Authenticate("op1")
+	c.Assert(err, Equals, ErrUnauthenticated)
+	tenant, err = t.Authenticate("op2")
+	c.Assert(err, IsNil)
+	c.Assert(tenant, IsNil)
+	c.Assert(t.Stats()["a"], DeepEquals, Stats{Requests: 1})
+}
+
+func (s *TenancySuite) TestStrip(c *C) {
+	t := New(map[string]*config.Tenant{"a": {Tokens: []string{"a1"}}}, nil)
+	tenant, err := t.Authenticate("a1")
+	c.Assert(err, IsNil)
+
+	name, ok := tenant.Strip("a.foo")
+	c.Assert(name, Equals, "foo")
+	c.Assert(ok, Equals, true)
+	_, ok = tenant.Strip("b.foo")
+	c.Assert(ok, Equals, false)
+}
+
+// Requests above the quota are rejected until the quota is replenished.
+func (s *TenancySuite) TestAdmit(c *C) {
+	t := New(map[string]*config.Tenant{"a": {Tokens: []string{"a1"}, RequestsPerSecond: 2}}, nil)
+	tenant, err := t.Authenticate("a1")
+	c.Assert(err, IsNil)
+	begin := tenant.lastRefill
+
+	// When/Then
+	c.Assert(tenant.admit(begin), IsNil)
+	c.Assert(tenant.admit(begin), IsNil)
+	c.Assert(tenant.admit(begin), Equals, ErrQuotaExceeded)
+	c.Assert(tenant.admit(begin.Add(499*time.Millisecond)), Equals, ErrQuotaExceeded)
+	c.Assert(tenant.admit(begin.Add(500*time.Millisecond)), IsNil)
+	c.Assert(t.Stats(), DeepEquals, map[string]Stats{"a": {Requests: 3, Throttled: 2}})
+}
@@ -0,0 +1,214 @@
+package topicstats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+const (
+	// Moving averages assume that they are updated every 5 seconds.
+	tickInterval = 5 * time.Second
+
+	// If a topic has not been used for longer than that, then its moving
+	// averages are started over rather than updated tick by tick.
+	maxTicks = int(time.Hour / tickInterval)
+)
+
+// Rates are exponentially weighted moving averages of events per second over
+// the last 1, 5 and 15 minutes.
+type Rates struct {
+	M1  float64
+	M5  float64
+	M15 float64
+}
+
+// Throughput describes messages that flowed through the proxy in one
+// direction. A message size is the total length of its key and value.
+type Throughput struct {
+	Messages     int64
+	Bytes        int64
+	MessageRates Rates
+	ByteRates    Rates
+}
+
+// GroupThroughput describes messages consumed by a particular group.
+type GroupThroughput struct {
+	Group string
+	Throughput
+}
+
+// Stats describes usage of a topic since the proxy started.
+type Stats struct {
+	Produced Throughput
+	Consumed Throughput
+
+	// Groups that consumed from the topic, in the descending order of their
+	// 1 minute message rates.
+	Groups []GroupThroughput
+}
+
+// T keeps track of per topic produce and consume throughput. It is safe for
+// concurrent use. A nil instance is valid, it just ignores everything.
+type T struct {
+	mu     sync.Mutex
+	topics map[string]*topicMeters
+
+	// Exists just to be overridden in tests.
+	nowFn func() time.Time
+}
+
+type topicMeters struct {
+	produced *meter
+	consumed *meter
+	groups   map[string]*meter
+}
+
+type meter struct {
+	messages     int64
+	bytes        int64
+	messageRates [3]gometrics.EWMA
+	byteRates    [3]gometrics.EWMA
+	lastTickAt   time.Time
+}
+
+// New creates an empty statistics instance.
+func New() *T {
+	return &T{
+		topics: make(map[string]*topicMeters),
+		nowFn:  time.Now,
+	}
+}
+
+// Produced records a message of the specified size produced to a topic.
+func (t *T) Produced(topic string, size int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.getTopic(topic).produced.mark(t.nowFn(), size)
+}
+
+// Consumed records a message of the specified size consumed from a topic by
+// a group.
+func (t *T) Consumed(group, topic string, size int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.nowFn()
+	tm := t.getTopic(topic)
+	tm.consumed.mark(now, size)
+	m := tm.groups[group]
+	if m == nil {
+		m = newMeter(now)
+		tm.groups[group] = m
+	}
+	m.mark(now, size)
+}
+
+// Stats returns a snapshot of statistics of a topic. If nothing has been
+// produced to or consumed from the topic, then all values are zero.
+func (t *T) Stats(topic string) Stats {
+	stats := Stats{Groups: []GroupThroughput{}}
+	if t == nil {
+		return stats
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tm := t.topics[topic]
+	if tm == nil {
+		return stats
+	}
+	now := t.nowFn()
+	stats.Produced = tm.produced.throughput(now)
+	stats.Consumed = tm.consumed.throughput(now)
+	for group, m := range tm.groups {
+		stats.Groups = append(stats.Groups, GroupThroughput{group, m.throughput(now)})
+	}
+	sort.Sort(byMessageRate(stats.Groups))
+	return stats
+}
+
+// getTopic returns meters of a topic creating them if necessary. It must be
+// called under the lock.
+func (t *T) getTopic(topic string) *topicMeters {
+	tm := t.topics[topic]
+	if tm == nil {
+		now := t.nowFn()
+		tm = &topicMeters{
+			produced: newMeter(now),
+			consumed: newMeter(now),
+			groups:   make(map[string]*meter),
+		}
+		t.topics[topic] = tm
+	}
+	return tm
+}
+
+func newMeter(now time.Time) *meter {
+	m := &meter{lastTickAt: now}
+	m.reset()
+	return m
+}
+
+func (m *meter) reset() {
+	m.messageRates = [3]gometrics.EWMA{gometrics.NewEWMA1(), gometrics.NewEWMA5(), gometrics.NewEWMA15()}
+	m.byteRates = [3]gometrics.EWMA{gometrics.NewEWMA1(), gometrics.NewEWMA5(), gometrics.NewEWMA15()}
+}
+
+func (m *meter) mark(now time.Time, size int) {
+	m.tick(now)
+	m.messages++
+	m.bytes += int64(size)
+	for i := range m.messageRates {
+		m.messageRates[i].Update(1)
+		m.byteRates[i].Update(int64(size))
+	}
+}
+
+// tick updates moving averages for every tick interval that passed since the
+// last update. Events recorded in between are accounted in the first one.
+func (m *meter) tick(now time.Time) {
+	for ticks := 0; !now.Before(m.lastTickAt.Add(tickInterval)); ticks++ {
+		if ticks == maxTicks {
+			m.reset()
+			m.lastTickAt = now
+			return
+		}
+		for i := range m.messageRates {
+			m.messageRates[i].Tick()
+			m.byteRates[i].Tick()
+		}
+		m.lastTickAt = m.lastTickAt.Add(tickInterval)
+	}
+}
+
+func (m *meter) throughput(now time.Time) Throughput {
+	m.tick(now)
+	return Throughput{
+		Messages:     m.messages,
+		Bytes:        m.bytes,
+		MessageRates: rates(m.messageRates),
+		ByteRates:    rates(m.byteRates),
+	}
+}
+
+func rates(ewmas [3]gometrics.EWMA) Rates {
+	return Rates{M1: ewmas[0].Rate(), M5: ewmas[1].Rate(), M15: ewmas[2].Rate()}
+}
+
+type byMessageRate []GroupThroughput
+
+func (a byMessageRate) Len() int      { return len(a) }
+func (a byMessageRate) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byMessageRate) Less(i, j int) bool {
+	if a[i].MessageRates.M1 != a[j].MessageRates.M1 {
+		return a[i].MessageRates.M1 > a[j].MessageRates.M1
+	}
+	return a[i].Group < a[j].Group
+}
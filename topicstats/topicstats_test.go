@@ -0,0 +1,104 @@
+package topicstats
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TopicStatsSuite struct {
+	t   *T
+	now time.Time
+}
+
+var _ = Suite(&TopicStatsSuite{})
+
+func (s *TopicStatsSuite) SetUpTest(c *C) {
+	s.now = time.Date(2017, 3, 20, 10, 0, 0, 0, time.UTC)
+	s.t = New()
+	s.t.nowFn = func() time.Time { return s.now }
+}
+
+func (s *TopicStatsSuite) TestProduced(c *C) {
+	for i := 0; i < 10; i++ {
+		s.t.Produced("foo", 100)
+	}
+
+	// When
+	s.now = s.now.Add(5 * time.Second)
+	stats := s.t.Stats("foo")
+
+	// Then
+	c.Assert(stats.Produced.Messages, Equals, int64(10))
+	c.Assert(stats.Produced.Bytes, Equals, int64(1000))
+	c.Assert(stats.Produced.MessageRates, DeepEquals, Rates{M1: 2, M5: 2, M15: 2})
+	c.Assert(stats.Produced.ByteRates, DeepEquals, Rates{M1: 200, M5: 200, M15: 200})
+	c.Assert(stats.Consumed, DeepEquals, Throughput{})
+	c.Assert(stats.Groups, DeepEquals, []GroupThroughput{})
+}
+
+// Groups are ordered by their message rates.
+func (s *TopicStatsSuite) TestConsumed(c *C) {
+	for i := 0; i < 5; i++ {
+		s.t.Consumed("a", "foo", 10)
+	}
+	for i := 0; i < 10; i++ {
+		s.t.Consumed("b", "foo", 1)
+	}
+	s.t.Consumed("c", "bar", 1)
+
+	// When
+	s.now = s.now.Add(5 * time.Second)
+	stats := s.t.Stats("foo")
+
+	// Then
+	c.Assert(stats.Consumed.Messages, Equals, int64(15))
+	c.Assert(stats.Consumed.Bytes, Equals, int64(60))
+	c.Assert(stats.Consumed.MessageRates.M1, Equals, float64(3))
+	c.Assert(len(stats.Groups), Equals, 2)
+	c.Assert(stats.Groups[0].Group, Equals, "b")
+	c.Assert(stats.Groups[0].MessageRates.M1, Equals, float64(2))
+	c.Assert(stats.Groups[1].Group, Equals, "a")
+	c.Assert(stats.Groups[1].ByteRates.M1, Equals, float64(10))
+}
+
+// Rates decay when nothing happens, and start over after a long pause, while
+// totals are preserved.
+func (s *TopicStatsSuite) TestDecay(c *C) {
+	s.t.Produced("foo", 100)
+	s.now = s.now.Add(5 * time.Second)
+	rate := s.t.Stats("foo").Produced.MessageRates.M1
+
+	// When
+	s.now = s.now.Add(time.Minute)
+
+	// Then
+	stats := s.t.Stats("foo")
+	c.Assert(stats.Produced.MessageRates.M1 < rate, Equals, true)
+	c.Assert(stats.Produced.MessageRates.M15 > stats.Produced.MessageRates.M1, Equals, true)
+
+	// When
+	s.now = s.now.Add(2 * time.Hour)
+
+	// Then
+	stats = s.t.Stats("foo")
+	c.Assert(stats.Produced.MessageRates, DeepEquals, Rates{})
+	c.Assert(stats.Produced.Messages, Equals, int64(1))
+}
+
+func (s *TopicStatsSuite) TestUnknownTopic(c *C) {
+	c.Assert(s.t.Stats("foo"), DeepEquals, Stats{Groups: []GroupThroughput{}})
+}
+
+// A nil instance ignores everything.
+func (s *TopicStatsSuite) TestNil(c *C) {
+	var t *T
+	t.Produced("foo", 1)
+	t.Consumed("a", "foo", 1)
+	c.Assert(t.Stats("foo"), DeepEquals, Stats{Groups: []GroupThroughput{}})
+}